@@ -169,6 +169,15 @@ func ClientConfigsDomain(d dns.Domain) (ClientConfigs, error) {
 		if l.IMAP.Enabled {
 			c.Entries = append(c.Entries, ClientConfigsEntry{"IMAP", host, config.Port(l.IMAPS.Port, 143), name, note(l.TLS != nil, !l.IMAP.NoRequireSTARTTLS)})
 		}
+		if l.POP3S.Enabled {
+			c.Entries = append(c.Entries, ClientConfigsEntry{"POP3", host, config.Port(l.POP3S.Port, 995), name, "with TLS"})
+		}
+		if l.POP3.Enabled {
+			c.Entries = append(c.Entries, ClientConfigsEntry{"POP3", host, config.Port(l.POP3.Port, 110), name, note(l.TLS != nil, !l.POP3.NoRequireSTARTTLS)})
+		}
+		if l.ManageSieve.Enabled {
+			c.Entries = append(c.Entries, ClientConfigsEntry{"ManageSieve", host, config.Port(l.ManageSieve.Port, 4190), name, note(l.TLS != nil, !l.ManageSieve.NoRequireSTARTTLS)})
+		}
 	}
 
 	return c, nil
@@ -19,6 +19,7 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/greylist"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
@@ -383,6 +384,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 	backupDB(mtastsdb.DB, "mtasts.db")
 	backupDB(tlsrptdb.ReportDB, "tlsrpt.db")
 	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db")
+	backupDB(greylist.DB, "greylist.db")
 	backupFile("receivedid.key")
 
 	// Acme directory is optional.
@@ -518,6 +520,15 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 			xctl.log.Check(err, "closing junkfilter")
 		}
 
+		// Copy reservoir filter model, if present. The model file is replaced
+		// atomically when saved, so a plain copy is consistent.
+		modelpath := filepath.Join("accounts", acc.Name, "reservoirfilter.model")
+		if _, err := os.Stat(filepath.Join(srcDataDir, modelpath)); err == nil {
+			backupFile(modelpath)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			xerrx("checking for reservoir filter model (not backed up)", err)
+		}
+
 		dstdbpath := filepath.Join(dstDataDir, dbpath)
 		opts := bstore.Options{MustExist: true, RegisterLogger: xctl.log.Logger}
 		db, err := bstore.Open(ctx, dstdbpath, &opts, store.DBTypes...)
@@ -606,7 +617,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 				}
 			}
 			switch p {
-			case "index.db", "junkfilter.db", "junkfilter.bloom":
+			case "index.db", "junkfilter.db", "junkfilter.bloom", "reservoirfilter.model":
 				return nil
 			}
 			ap := filepath.Join("accounts", acc.Name, p)
@@ -670,7 +681,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		}
 
 		switch p {
-		case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "ctl":
+		case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "greylist.db", "receivedid.key", "ctl":
 			// Already handled.
 			return nil
 		case "lastknownversion": // Optional file, not yet handled.
@@ -15,6 +15,7 @@ import (
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/reservoir"
 	"github.com/mjl-/mox/smtp"
 )
 
@@ -74,6 +75,15 @@ type Static struct {
 	OutgoingTLSReportsForAllSuccess bool  `sconf:"optional" sconf-doc:"Also send TLS reports if there were no SMTP STARTTLS connection failures. By default, reports are only sent when at least one failure occurred. If a report is sent, it does always include the successful connection counts as well."`
 	QuotaMessageSize                int64 `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for each individual account, only applicable if greater than zero. Can be overridden per account. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage. The quota only applies to the email message files, not to any file system overhead and also not the message index database file (account for approximately 15% overhead)."`
 
+	TrustedARCSealers       []string     `sconf:"optional" sconf-doc:"Domains of intermediaries, like mailing lists and forwarding services, whose ARC seals are trusted. If a message fails DMARC, but has a valid ARC chain most recently sealed by one of these domains, and the ARC-Authentication-Results recorded by the sealer show a DMARC pass, or a DKIM or SPF pass aligned with the message From domain, the DMARC policy of the sender is not applied, as the intermediary vouches for the authentication results it recorded when it received the message. Only add domains you trust to not relay spam with forged authentication results."`
+	TrustedARCSealerDomains []dns.Domain `sconf:"-" json:"-"`
+
+	SRS *SRS `sconf:"optional" sconf-doc:"Sender Rewriting Scheme for messages forwarded to external addresses by Sieve redirect. Only Sieve redirect forwards messages: members of aliases are local accounts, and destinations cannot forward to external addresses. The SMTP MAIL FROM of a forwarded message is rewritten to an SRS address in the domain of the recipient that encodes the original sender, so SPF passes at the next hop. Bounces to SRS addresses are sent back to the original sender. Without SRS, the recipient address is used as MAIL FROM, and bounces go to the recipient."`
+
+	Greylist *Greylist `sconf:"optional" sconf-doc:"Greylisting for incoming SMTP deliveries. The first delivery attempt for a combination of remote IP (masked to /26 for IPv4, /48 for IPv6), SMTP MAIL FROM and RCPT TO is rejected at RCPT TO with a temporary error, and accepted when the sending mail server retries after a delay. Recipients are deferred individually, before the message is transferred. Remote networks from which the recipient account received non-junk messages, known senders, and AllowedNetworks, are not greylisted. A known sender has an SPF pass for the MAIL FROM domain, from which the recipient account received non-junk DMARC-aligned messages. Triplets are stored in greylist.db in the data directory."`
+
+	OIDC *OIDC `sconf:"optional" sconf-doc:"Authentication with tokens from an OpenID Connect identity provider, as alternative to passwords. IMAP and SMTP submission accept tokens with SASL mechanisms OAUTHBEARER and XOAUTH2, and the account and webmail web interfaces can log in through the identity provider. Tokens are verified with the signing keys published by the identity provider. The identity provider only authenticates, users must still have an account with the email address from the token."`
+
 	// All IPs that were explicitly listened on for external SMTP. Only set when there
 	// are no unspecified external SMTP listeners and there is at most one for IPv4 and
 	// at most one for IPv6. Used for setting the local address when making outgoing
@@ -88,6 +98,38 @@ type Static struct {
 	GID uint32 `sconf:"-" json:"-"`
 }
 
+// SRS configures the Sender Rewriting Scheme for forwarded messages.
+type SRS struct {
+	Secrets       []string      `sconf-doc:"Secrets for the HMAC that authenticates SRS addresses, each at least 16 characters. Only the first secret signs new SRS addresses, the other secrets are only used to verify SRS addresses of bounces. To rotate, add a new secret at the start of the list, and remove the old secret once MaxAge has passed."`
+	MaxAge        time.Duration `sconf:"optional" sconf-doc:"Period after forwarding during which bounces to an SRS address are accepted. SRS addresses have a resolution of a day. Default 504h (21 days)."`
+	SecretsParsed [][]byte      `sconf:"-" json:"-"`
+}
+
+// Greylist configures greylisting of incoming deliveries.
+type Greylist struct {
+	Delay           time.Duration `sconf:"optional" sconf-doc:"Minimum time between the first attempt and a retry that is accepted. Default 5m."`
+	RetryWindow     time.Duration `sconf:"optional" sconf-doc:"Period after the first attempt in which a retry is accepted. Later retries are deferred again as first attempt. Default 24h."`
+	Expiration      time.Duration `sconf:"optional" sconf-doc:"Period after its last delivery attempt that a triplet is kept. Passed triplets are not delayed again during this period. Default 840h (35 days)."`
+	AllowedNetworks []string      `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation that are never greylisted, e.g. of known mail servers of large providers that retry from other IPs."`
+
+	AllowedNets []*net.IPNet `sconf:"-" json:"-"`
+}
+
+// OIDC configures an OpenID Connect identity provider for authentication with
+// tokens.
+type OIDC struct {
+	Issuer           string   `sconf-doc:"Issuer identifier, a URL, e.g. https://login.example.com/realms/company. Tokens must have this value as \"iss\" claim."`
+	DiscoveryURL     string   `sconf:"optional" sconf-doc:"URL of the OpenID Connect discovery document, with the URL of the signing keys (JWKS), and the authorization and token endpoints used for web logins. Default: Issuer with /.well-known/openid-configuration appended."`
+	Audience         []string `sconf:"optional" sconf-doc:"Accepted values for the \"aud\" claim of tokens. Tokens used with IMAP and SMTP are typically access tokens requested by mail clients for a specific audience. Default: ClientID."`
+	ClientID         string   `sconf:"optional" sconf-doc:"Client ID registered at the identity provider, for web logins with the authorization code flow. Web logins are only enabled when set. ID tokens from web logins must have ClientID as audience. Register the paths of the account and webmail interfaces followed by oidc/callback as redirect URIs, e.g. https://mail.example.com/webmail/oidc/callback."`
+	ClientSecretFile string   `sconf:"optional" sconf-doc:"File containing the client secret for ClientID, for confidential clients. Relative to the directory of mox.conf. Web logins always use PKCE, a secret is not needed for public clients."`
+	Claim            string   `sconf:"optional" sconf-doc:"Claim in tokens with the email address used to find the account, like the email address used for password logins. If the value is not an email address and ClaimDomain is set, the value is used as localpart at ClaimDomain. For claim \"email\", a token must have \"email_verified\" true. Default: email."`
+	ClaimDomain      string   `sconf:"optional" sconf-doc:"Domain for claim values without @, e.g. when Claim is preferred_username."`
+	Scopes           []string `sconf:"optional" sconf-doc:"Scopes to request for web logins. Default: openid, email and profile."`
+
+	ClientSecret string `sconf:"-" json:"-"`
+}
+
 // InitialMailboxes are mailboxes created for a new account.
 type InitialMailboxes struct {
 	SpecialUse SpecialUseMailboxes `sconf:"optional" sconf-doc:"Special-use roles to mailbox to create."`
@@ -182,6 +224,30 @@ type Listener struct {
 		Port           int  `sconf:"optional" sconf-doc:"Default 993."`
 		EnabledOnHTTPS bool `sconf:"optional" sconf-doc:"Additionally enable IMAP on HTTPS port 443 via TLS ALPN. TLS Application Layer Protocol Negotiation allows clients to request a specific protocol from the server as part of the TLS connection setup. When this setting is enabled and a client requests the 'imap' protocol after TLS, it will be able to talk IMAP to Mox on port 443. This is meant to be useful as a censorship circumvention technique for Delta Chat."`
 	} `sconf:"optional" sconf-doc:"IMAP over TLS for reading email, by email applications. Requires a TLS config."`
+	ManageSieve struct {
+		Enabled           bool
+		Port              int  `sconf:"optional" sconf-doc:"Default 4190."`
+		NoRequireSTARTTLS bool `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+	} `sconf:"optional" sconf-doc:"ManageSieve for managing Sieve scripts that filter incoming email, by email applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS command."`
+	POP3 struct {
+		Enabled           bool
+		Port              int  `sconf:"optional" sconf-doc:"Default 110."`
+		NoRequireSTARTTLS bool `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+	} `sconf:"optional" sconf-doc:"POP3 for retrieving email from the Inbox, by email applications and devices that do not support IMAP. Starts out in plain text, can be upgraded to TLS with the STLS command. Prefer using POP3S instead which is always a TLS connection."`
+	POP3S struct {
+		Enabled bool
+		Port    int `sconf:"optional" sconf-doc:"Default 995."`
+	} `sconf:"optional" sconf-doc:"POP3 over TLS for retrieving email from the Inbox, by email applications and devices that do not support IMAP. Requires a TLS config."`
+	LMTP struct {
+		Enabled           bool
+		Port              int      `sconf:"optional" sconf-doc:"Default 24. Only used when AllowedIPs is set."`
+		UnixSocket        string   `sconf:"optional" sconf-doc:"Path of a unix domain socket to listen on, relative to the data directory if not absolute. The socket is accessible for the user and group mox runs as."`
+		AllowedIPs        []string `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation that are allowed to connect. If set, LMTP is served over TCP on the IPs of the listener, and connections from other IPs are refused."`
+		TrustedAuthServID string   `sconf:"optional" sconf-doc:"If set, the SPF and iprev results of the topmost Authentication-Results message header with this authserv-id (typically the hostname of the upstream MTA) are used for the delivered message, including the remote IP address from the iprev result. Without it, SPF and iprev are not evaluated: The connection is from the upstream, not the original sender. DKIM signatures are always verified."`
+
+		AllowedNets []*net.IPNet `sconf:"-" json:"-"`
+	} `sconf:"optional" sconf-doc:"LMTP for delivery of incoming messages to local accounts by a trusted MTA or content filter, with a response for each recipient. Messages are not evaluated for junk and reputation, and DMARC policies are not enforced, but rulesets and Sieve scripts are applied. At least one of UnixSocket and AllowedIPs must be set."`
+	Milters      []Milter   `sconf:"optional" sconf-doc:"Mail filters (milters) speaking the Sendmail milter protocol, such as rspamd, ClamAV or OpenDKIM, to pass messages through, in order. Used for incoming deliveries over SMTP and for submissions over Submission and Submissions on this listener, not for LMTP. Milters see each stage of the SMTP transaction, and can reject or temporarily fail the message or individual recipients. At the end of the message, milters can add and change header fields, add recipients (only local recipients for incoming deliveries) and quarantine the message. Quarantined incoming messages are delivered to the Junk mailbox with the $Junk flag. Quarantined submissions are put on hold in the queue."`
 	AccountHTTP  WebService `sconf:"optional" sconf-doc:"Account web interface, for email users wanting to change their accounts, e.g. set new password, set new delivery rulesets. Default path is /."`
 	AccountHTTPS WebService `sconf:"optional" sconf-doc:"Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS config."`
 	AdminHTTP    WebService `sconf:"optional" sconf-doc:"Admin web interface, for managing domains, accounts, etc. Default path is /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set up a tunnel (e.g. WireGuard) and add its IP to the mox 'internal' listener."`
@@ -190,6 +256,8 @@ type Listener struct {
 	WebmailHTTPS WebService `sconf:"optional" sconf-doc:"Webmail client, like WebmailHTTP, but for HTTPS. Requires a TLS config."`
 	WebAPIHTTP   WebService `sconf:"optional" sconf-doc:"Like WebAPIHTTPS, but with plain HTTP, without TLS."`
 	WebAPIHTTPS  WebService `sconf:"optional" sconf-doc:"WebAPI, a simple HTTP/JSON-based API for email, with HTTPS (requires a TLS config). Default path is /webapi/."`
+	JMAPHTTP     WebService `sconf:"optional" sconf-doc:"Like JMAPHTTPS, but with plain HTTP, without TLS."`
+	JMAPHTTPS    WebService `sconf:"optional" sconf-doc:"JMAP, the JSON Meta Application Protocol for email clients, with HTTPS (requires a TLS config). Default path is /jmap/. Clients find the session resource through /.well-known/jmap, which is served on the same port."`
 	MetricsHTTP  struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Default 8010."`
@@ -220,7 +288,17 @@ type Listener struct {
 	} `sconf:"optional" sconf-doc:"All configured WebHandlers will serve on an enabled listener. Either ACME must be configured, or for each WebHandler domain a TLS certificate must be configured."`
 }
 
-// WebService is an internal web interface: webmail, webaccount, webadmin, webapi.
+// Milter is an external content filter speaking the Sendmail milter protocol.
+type Milter struct {
+	Address        string        `sconf-doc:"Address of the milter, either \"unix:\" followed by the path of a unix domain socket, or \"inet:\" followed by host and port separated by a colon, e.g. \"inet:localhost:11332\" for rspamd."`
+	ConnectTimeout time.Duration `sconf:"optional" sconf-doc:"Timeout for connecting to the milter. Default 10s."`
+	CommandTimeout time.Duration `sconf:"optional" sconf-doc:"Timeout for each command passed to the milter, including its response. Default 30s."`
+	FailClosed     bool          `sconf:"optional" sconf-doc:"If set, messages are rejected with a temporary error when the milter cannot be reached or fails. By default, a failing milter is skipped for the remainder of the transaction, as if it accepted the message."`
+	NoSMTP         bool          `sconf:"optional" sconf-doc:"Do not use this milter for incoming deliveries over SMTP."`
+	NoSubmission   bool          `sconf:"optional" sconf-doc:"Do not use this milter for submissions over Submission and Submissions."`
+}
+
+// WebService is an internal web interface: webmail, webaccount, webadmin, webapi, jmap.
 type WebService struct {
 	Enabled   bool
 	Port      int    `sconf:"optional" sconf-doc:"Default 80 for HTTP and 443 for HTTPS. See Hostname at Listener for hostname matching behaviour."`
@@ -447,11 +525,13 @@ type Account struct {
 	KeepRejects                  bool                   `sconf:"optional" sconf-doc:"Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."`
 	AutomaticJunkFlags           AutomaticJunkFlags     `sconf:"optional" sconf-doc:"Automatically set $Junk and $NotJunk flags based on mailbox messages are delivered/moved/copied to. Email clients typically have too limited functionality to conveniently set these flags, especially $NonJunk, but they can all move messages to a different mailbox, so this helps them."`
 	JunkFilter                   *JunkFilter            `sconf:"optional" sconf-doc:"Content-based filtering, using the junk-status of individual messages to rank words in such messages as spam or ham. It is recommended you always set the applicable (non)-junk status on messages, and that you do not empty your Trash because those messages contain valuable ham/spam training information."` // todo: sane defaults for junkfilter
+	ReservoirFilter              *ReservoirFilter       `sconf:"optional" sconf-doc:"Experimental content-based filtering with an echo state network reservoir and affective analysis, combined with the probability from the JunkFilter, which must also be configured. The combined probability is compared against the JunkFilter threshold."`
 	MaxOutgoingMessagesPerDay    int                    `sconf:"optional" sconf-doc:"Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000."`
 	MaxFirstTimeRecipientsPerDay int                    `sconf:"optional" sconf-doc:"Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200."`
 	NoFirstTimeSenderDelay       bool                   `sconf:"optional" sconf-doc:"Do not apply a delay to SMTP connections before accepting an incoming message from a first-time sender. Can be useful for accounts that sends automated responses and want instant replies."`
 	NoCustomPassword             bool                   `sconf:"optional" sconf-doc:"If set, this account cannot set a password of their own choice, but can only set a new randomly generated password, preventing password reuse across services and use of weak passwords. Custom account passwords can be set by the admin."`
 	IMAPCapabilitiesDisabled     []string               `sconf:"optional" sconf-doc:"IMAP capabilities (upper-case) to disable on the connection after authentication. Useful if the account uses an email client with an incompatible implementation for a capability/extension."`
+	POP3RetrievedMailbox         string                 `sconf:"optional" sconf-doc:"If non-empty, messages from the Inbox that were retrieved but not deleted during a POP3 session are moved to this mailbox when the session ends, creating the mailbox if needed. Useful to keep messages retrieved by devices around, without them being retrieved again in the next session. By default, retrieved messages are left in the Inbox."`
 	// We will not work around client incompatibilities based on client software. ../rfc/2971:93
 
 	Routes []Route `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates these account routes, domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
@@ -475,6 +555,11 @@ type JunkFilter struct {
 	junk.Params
 }
 
+type ReservoirFilter struct {
+	Params reservoir.FilterConfig `sconf-doc:"Parameters for the reservoir filter. Unset numeric ESN parameters, ReservoirWeight and MembraneDepth get their default values."`
+	Shadow bool                   `sconf:"optional" sconf-doc:"Run the reservoir filter in shadow mode: incoming messages are classified and the outcome is stored with the message, but delivery decisions are made with only the Bayesian probability. The admin web interface has a report for the account comparing the decisions the reservoir filter would have made with those of the Bayesian filter, and with messages later marked as junk or nonjunk. Useful for evaluating the reservoir filter before it affects deliveries."`
+}
+
 type Destination struct {
 	Mailbox                      string    `sconf:"optional" sconf-doc:"Mailbox to deliver to if none of Rulesets match. Default: Inbox."`
 	Rulesets                     []Ruleset `sconf:"optional" sconf-doc:"Delivery rules based on message and SMTP transaction. You may want to match each mailing list by SMTP MailFrom address, VerifiedDomain and/or List-ID header (typically <listname.example.org> if the list address is listname@example.org), delivering them to their own mailbox."`
@@ -329,6 +329,107 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# technique for Delta Chat. (optional)
 				EnabledOnHTTPS: false
 
+			# ManageSieve for managing Sieve scripts that filter incoming email, by email
+			# applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS
+			# command. (optional)
+			ManageSieve:
+				Enabled: false
+
+				# Default 4190. (optional)
+				Port: 0
+
+				# Enable this only when the connection is otherwise encrypted (e.g. through a
+				# VPN). (optional)
+				NoRequireSTARTTLS: false
+
+			# POP3 for retrieving email from the Inbox, by email applications and devices that
+			# do not support IMAP. Starts out in plain text, can be upgraded to TLS with the
+			# STLS command. Prefer using POP3S instead which is always a TLS connection.
+			# (optional)
+			POP3:
+				Enabled: false
+
+				# Default 110. (optional)
+				Port: 0
+
+				# Enable this only when the connection is otherwise encrypted (e.g. through a
+				# VPN). (optional)
+				NoRequireSTARTTLS: false
+
+			# POP3 over TLS for retrieving email from the Inbox, by email applications and
+			# devices that do not support IMAP. Requires a TLS config. (optional)
+			POP3S:
+				Enabled: false
+
+				# Default 995. (optional)
+				Port: 0
+
+			# LMTP for delivery of incoming messages to local accounts by a trusted MTA or
+			# content filter, with a response for each recipient. Messages are not evaluated
+			# for junk and reputation, and DMARC policies are not enforced, but rulesets and
+			# Sieve scripts are applied. At least one of UnixSocket and AllowedIPs must be
+			# set. (optional)
+			LMTP:
+				Enabled: false
+
+				# Default 24. Only used when AllowedIPs is set. (optional)
+				Port: 0
+
+				# Path of a unix domain socket to listen on, relative to the data directory if not
+				# absolute. The socket is accessible for the user and group mox runs as.
+				# (optional)
+				UnixSocket:
+
+				# IP addresses or networks in CIDR notation that are allowed to connect. If set,
+				# LMTP is served over TCP on the IPs of the listener, and connections from other
+				# IPs are refused. (optional)
+				AllowedIPs:
+					-
+
+				# If set, the SPF and iprev results of the topmost Authentication-Results message
+				# header with this authserv-id (typically the hostname of the upstream MTA) are
+				# used for the delivered message, including the remote IP address from the iprev
+				# result. Without it, SPF and iprev are not evaluated: The connection is from the
+				# upstream, not the original sender. DKIM signatures are always verified.
+				# (optional)
+				TrustedAuthServID:
+
+			# Mail filters (milters) speaking the Sendmail milter protocol, such as rspamd,
+			# ClamAV or OpenDKIM, to pass messages through, in order. Used for incoming
+			# deliveries over SMTP and for submissions over Submission and Submissions on this
+			# listener, not for LMTP. Milters see each stage of the SMTP transaction, and can
+			# reject or temporarily fail the message or individual recipients. At the end of
+			# the message, milters can add and change header fields, add recipients (only
+			# local recipients for incoming deliveries) and quarantine the message.
+			# Quarantined incoming messages are delivered to the Junk mailbox with the $Junk
+			# flag. Quarantined submissions are put on hold in the queue. (optional)
+			Milters:
+				-
+
+					# Address of the milter, either "unix:" followed by the path of a unix domain
+					# socket, or "inet:" followed by host and port separated by a colon, e.g.
+					# "inet:localhost:11332" for rspamd.
+					Address:
+
+					# Timeout for connecting to the milter. Default 10s. (optional)
+					ConnectTimeout: 0s
+
+					# Timeout for each command passed to the milter, including its response. Default
+					# 30s. (optional)
+					CommandTimeout: 0s
+
+					# If set, messages are rejected with a temporary error when the milter cannot be
+					# reached or fails. By default, a failing milter is skipped for the remainder of
+					# the transaction, as if it accepted the message. (optional)
+					FailClosed: false
+
+					# Do not use this milter for incoming deliveries over SMTP. (optional)
+					NoSMTP: false
+
+					# Do not use this milter for submissions over Submission and Submissions.
+					# (optional)
+					NoSubmission: false
+
 			# Account web interface, for email users wanting to change their accounts, e.g.
 			# set new password, set new delivery rulesets. Default path is /. (optional)
 			AccountHTTP:
@@ -466,6 +567,40 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+			# Like JMAPHTTPS, but with plain HTTP, without TLS. (optional)
+			JMAPHTTP:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. See Hostname at Listener for hostname
+				# matching behaviour. (optional)
+				Port: 0
+
+				# Path to serve requests on. Should end with a slash, related to cookie paths.
+				# (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# JMAP, the JSON Meta Application Protocol for email clients, with HTTPS (requires
+			# a TLS config). Default path is /jmap/. Clients find the session resource through
+			# /.well-known/jmap, which is served on the same port. (optional)
+			JMAPHTTPS:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. See Hostname at Listener for hostname
+				# matching behaviour. (optional)
+				Port: 0
+
+				# Path to serve requests on. Should end with a slash, related to cookie paths.
+				# (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
 			# Serve prometheus metrics, for monitoring. You should not enable this on a public
 			# IP. (optional)
 			MetricsHTTP:
@@ -777,6 +912,116 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	# (optional)
 	QuotaMessageSize: 0
 
+	# Domains of intermediaries, like mailing lists and forwarding services, whose ARC
+	# seals are trusted. If a message fails DMARC, but has a valid ARC chain most
+	# recently sealed by one of these domains, and the ARC-Authentication-Results
+	# recorded by the sealer show a DMARC pass, or a DKIM or SPF pass aligned with the
+	# message From domain, the DMARC policy of the sender is not applied, as the
+	# intermediary vouches for the authentication results it recorded when it received
+	# the message. Only add domains you trust to not relay spam with forged
+	# authentication results. (optional)
+	TrustedARCSealers:
+		-
+
+	# Sender Rewriting Scheme for messages forwarded to external addresses by Sieve
+	# redirect. Only Sieve redirect forwards messages: members of aliases are local
+	# accounts, and destinations cannot forward to external addresses. The SMTP MAIL
+	# FROM of a forwarded message is rewritten to an SRS address in the domain of the
+	# recipient that encodes the original sender, so SPF passes at the next hop.
+	# Bounces to SRS addresses are sent back to the original sender. Without SRS, the
+	# recipient address is used as MAIL FROM, and bounces go to the recipient.
+	# (optional)
+	SRS:
+
+		# Secrets for the HMAC that authenticates SRS addresses, each at least 16
+		# characters. Only the first secret signs new SRS addresses, the other secrets are
+		# only used to verify SRS addresses of bounces. To rotate, add a new secret at the
+		# start of the list, and remove the old secret once MaxAge has passed.
+		Secrets:
+			-
+
+		# Period after forwarding during which bounces to an SRS address are accepted. SRS
+		# addresses have a resolution of a day. Default 504h (21 days). (optional)
+		MaxAge: 0s
+
+	# Greylisting for incoming SMTP deliveries. The first delivery attempt for a
+	# combination of remote IP (masked to /26 for IPv4, /48 for IPv6), SMTP MAIL FROM
+	# and RCPT TO is rejected at RCPT TO with a temporary error, and accepted when the
+	# sending mail server retries after a delay. Recipients are deferred individually,
+	# before the message is transferred. Remote networks from which the recipient
+	# account received non-junk messages, known senders, and AllowedNetworks, are not
+	# greylisted. A known sender has an SPF pass for the MAIL FROM domain, from which
+	# the recipient account received non-junk DMARC-aligned messages. Triplets are
+	# stored in greylist.db in the data directory. (optional)
+	Greylist:
+
+		# Minimum time between the first attempt and a retry that is accepted. Default 5m.
+		# (optional)
+		Delay: 0s
+
+		# Period after the first attempt in which a retry is accepted. Later retries are
+		# deferred again as first attempt. Default 24h. (optional)
+		RetryWindow: 0s
+
+		# Period after its last delivery attempt that a triplet is kept. Passed triplets
+		# are not delayed again during this period. Default 840h (35 days). (optional)
+		Expiration: 0s
+
+		# IP addresses or networks in CIDR notation that are never greylisted, e.g. of
+		# known mail servers of large providers that retry from other IPs. (optional)
+		AllowedNetworks:
+			-
+
+	# Authentication with tokens from an OpenID Connect identity provider, as
+	# alternative to passwords. IMAP and SMTP submission accept tokens with SASL
+	# mechanisms OAUTHBEARER and XOAUTH2, and the account and webmail web interfaces
+	# can log in through the identity provider. Tokens are verified with the signing
+	# keys published by the identity provider. The identity provider only
+	# authenticates, users must still have an account with the email address from the
+	# token. (optional)
+	OIDC:
+
+		# Issuer identifier, a URL, e.g. https://login.example.com/realms/company. Tokens
+		# must have this value as "iss" claim.
+		Issuer:
+
+		# URL of the OpenID Connect discovery document, with the URL of the signing keys
+		# (JWKS), and the authorization and token endpoints used for web logins. Default:
+		# Issuer with /.well-known/openid-configuration appended. (optional)
+		DiscoveryURL:
+
+		# Accepted values for the "aud" claim of tokens. Tokens used with IMAP and SMTP
+		# are typically access tokens requested by mail clients for a specific audience.
+		# Default: ClientID. (optional)
+		Audience:
+			-
+
+		# Client ID registered at the identity provider, for web logins with the
+		# authorization code flow. Web logins are only enabled when set. ID tokens from
+		# web logins must have ClientID as audience. Register the paths of the account and
+		# webmail interfaces followed by oidc/callback as redirect URIs, e.g.
+		# https://mail.example.com/webmail/oidc/callback. (optional)
+		ClientID:
+
+		# File containing the client secret for ClientID, for confidential clients.
+		# Relative to the directory of mox.conf. Web logins always use PKCE, a secret is
+		# not needed for public clients. (optional)
+		ClientSecretFile:
+
+		# Claim in tokens with the email address used to find the account, like the email
+		# address used for password logins. If the value is not an email address and
+		# ClaimDomain is set, the value is used as localpart at ClaimDomain. For claim
+		# "email", a token must have "email_verified" true. Default: email. (optional)
+		Claim:
+
+		# Domain for claim values without @, e.g. when Claim is preferred_username.
+		# (optional)
+		ClaimDomain:
+
+		# Scopes to request for web logins. Default: openid, email and profile. (optional)
+		Scopes:
+			-
+
 # domains.conf
 
 	# NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be
@@ -1293,6 +1538,131 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# in calculating probability reduced. E.g. 1 or 2. (optional)
 					RareWords: 0
 
+			# Experimental content-based filtering with an echo state network reservoir and
+			# affective analysis, combined with the probability from the JunkFilter, which
+			# must also be configured. The combined probability is compared against the
+			# JunkFilter threshold. (optional)
+			ReservoirFilter:
+
+				# Parameters for the reservoir filter. Unset numeric ESN parameters,
+				# ReservoirWeight and MembraneDepth get their default values.
+				Params:
+
+					# Echo State Network parameters for reservoir computing. (optional)
+					ESNParams:
+
+						# Number of neurons in the reservoir layer. Default: 100. (optional)
+						ReservoirSize: 0
+
+						# Spectral radius for reservoir stability. Should be < 1.0. Default: 0.95.
+						# (optional)
+						SpectralRadius: 0.000000
+
+						# Scaling factor for input signals. Default: 1.0. (optional)
+						InputScaling: 0.000000
+
+						# Leak rate for neuron activation (0-1). Default: 0.3. (optional)
+						LeakRate: 0.000000
+
+						# Sparsity of reservoir connections (0-1). Default: 0.1. (optional)
+						Sparsity: 0.000000
+
+						# Ridge regression parameter for regularization. Default: 1e-8. (optional)
+						RidgeParam: 0.000000
+
+						# Depth of the tree structure for hierarchical processing. Default: 3. (optional)
+						TreeDepth: 0
+
+						# Seed for generating the random reservoir and input weights. If zero, a random
+						# seed is generated when a new model is created. The seed is stored with the
+						# model. (optional)
+						Seed: 0
+
+					# Personality traits for affective computing. (optional)
+					Persona:
+
+						# Positive/negative emotional tone, modulates signal strength (-1 to 1).
+						# (optional)
+						Valence: 0.000000
+
+						# Activation/energy level, affects membrane permeability (0 to 1). (optional)
+						Arousal: 0.000000
+
+						# Control/power dimension (0 to 1). (optional)
+						Dominance: 0.000000
+
+						# Focus/concentration, modulates leak rate (0 to 1). (optional)
+						Attention: 0.000000
+
+						# Retention capacity, controls Ricci flow rate (0 to 1). (optional)
+						Memory: 0.000000
+
+						# Novelty generation (0 to 1). (optional)
+						Creativity: 0.000000
+
+					# Enable reservoir computing enhancement. Default: false. (optional)
+					EnableReservoir: false
+
+					# Enable affective computing. Default: false. (optional)
+					EnableAffective: false
+
+					# Weight of reservoir prediction (0-1). Default: 0.3. (optional)
+					ReservoirWeight: 0.000000
+
+					# Files with affective lexicons, with emotion keywords and common words for
+					# language detection for a language, relative to the config directory. A built-in
+					# English lexicon is always available, a lexicon for "en" replaces it. Affective
+					# analysis is skipped for messages in a language that does not match any lexicon.
+					# (optional)
+					Lexicons:
+						-
+
+					# Depth of P-system membrane hierarchy. Default: 3. (optional)
+					MembraneDepth: 0
+
+					# Feature extractor that turns a message into input for the reservoir. "standard"
+					# uses hashed words from the junk filter tokenizer, text statistics,
+					# authentication results, reputation, links and the HTML to text ratio. "basic"
+					# uses text statistics and a list of English spam keywords. Changing the extractor
+					# replaces the model. Default: standard. (optional)
+					FeatureExtractor:
+
+					# Number of hashed word features for the standard feature extractor. Default: 64.
+					# (optional)
+					FeatureWords: 0
+
+					# Maximum number of messages marked as junk or nonjunk to keep as training samples
+					# for the reservoir output weights. When exceeded, the oldest samples are removed.
+					# Default: 1000. (optional)
+					MaxSamples: 0
+
+					# Keep reservoir state per sender, so a sequence of messages from the same source
+					# drives the reservoir over time, instead of classifying each message from a reset
+					# reservoir. "orgdomain" keeps state per organizational domain of the message From
+					# address if it is validated by SPF or DKIM, and per remote IP /26 (IPv4) or /48
+					# (IPv6) otherwise. "ip" always keeps state per remote IP /26 or /48. "none"
+					# disables per-sender state. States are kept in memory only. Default: none.
+					# (optional)
+					SenderSequence:
+
+					# Maximum number of sender states kept for the account, the least recently used is
+					# removed first. Default: 1000. (optional)
+					SenderStates: 0
+
+					# Period after which a sender state that was not updated is discarded, and the
+					# next message from the sender starts from a reset reservoir. Default: 24h.
+					# (optional)
+					SenderStateExpiry: 0s
+
+				# Run the reservoir filter in shadow mode: incoming messages are classified and
+				# the outcome is stored with the message, but delivery decisions are made with
+				# only the Bayesian probability. The admin web interface has a report for the
+				# account comparing the decisions the reservoir filter would have made with those
+				# of the Bayesian filter, and with messages later marked as junk or nonjunk.
+				# Useful for evaluating the reservoir filter before it affects deliveries.
+				# (optional)
+				Shadow: false
+
 			# Maximum number of outgoing messages for this account in a 24 hour window. This
 			# limits the damage to recipients and the reputation of this mail server in case
 			# of account compromise. Default 1000. (optional)
@@ -1320,6 +1690,13 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			IMAPCapabilitiesDisabled:
 				-
 
+			# If non-empty, messages from the Inbox that were retrieved but not deleted during
+			# a POP3 session are moved to this mailbox when the session ends, creating the
+			# mailbox if needed. Useful to keep messages retrieved by devices around, without
+			# them being retrieved again in the next session. By default, retrieved messages
+			# are left in the Inbox. (optional)
+			POP3RetrievedMailbox:
+
 			# Routes for delivering outgoing messages through the queue. Each delivery attempt
 			# evaluates these account routes, domain routes and finally global routes. The
 			# transport of the first matching route is used in the delivery attempt. If no
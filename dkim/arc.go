@@ -0,0 +1,420 @@
+package dkim
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxio"
+)
+
+// ARC, Authenticated Received Chain, ../rfc/8617, lets intermediaries like
+// mailing lists and forwarders record the authentication results for a message
+// as they received it, in a chain of "ARC sets". Each set is signed by the
+// intermediary with DKIM keys. A receiver can use the results recorded by a
+// trusted intermediary when the DKIM and SPF checks for the original sender fail
+// after forwarding.
+//
+// An ARC set consists of three headers with the same instance (i=):
+// ARC-Authentication-Results with the authentication results of the
+// intermediary, ARC-Message-Signature that signs the message like a
+// DKIM-Signature, and ARC-Seal that signs the ARC headers of this and all earlier
+// sets.
+
+// ARCStatus is the result of validating the ARC chain of a message.
+type ARCStatus string
+
+const (
+	ARCStatusNone ARCStatus = "none" // Message has no ARC sets.
+	ARCStatusPass ARCStatus = "pass" // All ARC sets are present and the seals and most recent message signature are valid.
+	ARCStatusFail ARCStatus = "fail" // The chain is broken, a signature is invalid, or an intermediary already found the chain invalid.
+)
+
+// ARC errors.
+var (
+	ErrARCChain = errors.New("dkim: invalid arc chain")
+	ErrARCSeal  = errors.New("dkim: cannot add arc set")
+)
+
+// ARCSet is a set of ARC headers added by an intermediary.
+type ARCSet struct {
+	Instance    int
+	Sealer      dns.Domain // Domain (d=) of the ARC-Seal.
+	AuthResults string     // Value of ARC-Authentication-Results after the instance, unfolded.
+}
+
+// ARCResult is the result of validating the ARC chain of a message.
+type ARCResult struct {
+	Status ARCStatus
+	Sets   []ARCSet // Ordered by instance. Set when the ARC headers could be parsed.
+
+	// Lowest instance whose ARC-Message-Signature still validates, i.e. the message
+	// has not been modified since that intermediary. Only set for ARCStatusPass.
+	OldestPass int
+
+	Err error // Details for ARCStatusFail.
+}
+
+// Sealer returns the domain of the intermediary that added the most recent ARC
+// set, i.e. the last one that handled the message before it reached us. Only
+// meaningful for ARCStatusPass.
+func (r ARCResult) Sealer() dns.Domain {
+	if len(r.Sets) == 0 {
+		return dns.Domain{}
+	}
+	return r.Sets[len(r.Sets)-1].Sealer
+}
+
+// arcSet is a parsed ARC set with its raw headers, needed for calculating hashes.
+type arcSet struct {
+	aar, ams, as        header
+	amsSig, asSig       *Sig
+	amsVerify, asVerify []byte // Headers with empty b= and without crlf, for verification.
+	cv                  string
+	authResults         string
+}
+
+// parseARCSets parses the ARC headers into sets ordered by instance. All
+// instances from 1 up to the number of sets must be present exactly once.
+func parseARCSets(hdrs []header, smtputf8 bool) ([]arcSet, error) {
+	byInstance := map[int]*arcSet{}
+	set := func(i int) *arcSet {
+		s, ok := byInstance[i]
+		if !ok {
+			s = &arcSet{}
+			byInstance[i] = s
+		}
+		return s
+	}
+	for _, h := range hdrs {
+		switch h.lkey {
+		case "arc-authentication-results":
+			// ../rfc/8617
+			v := strings.TrimSpace(strings.ReplaceAll(string(h.value), "\r\n", ""))
+			t := strings.SplitN(v, ";", 2)
+			k, iv, _ := strings.Cut(t[0], "=")
+			instance, err := strconv.Atoi(strings.TrimSpace(iv))
+			if strings.TrimSpace(k) != "i" || err != nil || instance < 1 || instance > 50 {
+				return nil, fmt.Errorf("%w: invalid instance in ARC-Authentication-Results header", ErrARCChain)
+			}
+			s := set(instance)
+			if s.aar.raw != nil {
+				return nil, fmt.Errorf("%w: duplicate ARC-Authentication-Results header for instance %d", ErrARCChain, instance)
+			}
+			s.aar = h
+			if len(t) == 2 {
+				s.authResults = strings.TrimSpace(t[1])
+			}
+		case "arc-message-signature":
+			sig, arc, verifySig, err := parseSig(h.raw, smtputf8, kindARCMessageSignature)
+			if err != nil {
+				return nil, fmt.Errorf("%w: parsing ARC-Message-Signature: %v", ErrARCChain, err)
+			}
+			s := set(arc.instance)
+			if s.amsSig != nil {
+				return nil, fmt.Errorf("%w: duplicate ARC-Message-Signature header for instance %d", ErrARCChain, arc.instance)
+			}
+			s.ams, s.amsSig, s.amsVerify = h, sig, verifySig
+		case "arc-seal":
+			sig, arc, verifySig, err := parseSig(h.raw, smtputf8, kindARCSeal)
+			if err != nil {
+				return nil, fmt.Errorf("%w: parsing ARC-Seal: %v", ErrARCChain, err)
+			}
+			s := set(arc.instance)
+			if s.asSig != nil {
+				return nil, fmt.Errorf("%w: duplicate ARC-Seal header for instance %d", ErrARCChain, arc.instance)
+			}
+			s.as, s.asSig, s.asVerify, s.cv = h, sig, verifySig, arc.cv
+		}
+	}
+
+	sets := make([]arcSet, len(byInstance))
+	for i := range sets {
+		s, ok := byInstance[i+1]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing ARC set for instance %d", ErrARCChain, i+1)
+		}
+		if s.aar.raw == nil || s.amsSig == nil || s.asSig == nil {
+			return nil, fmt.Errorf("%w: incomplete ARC set for instance %d", ErrARCChain, i+1)
+		}
+		sets[i] = *s
+	}
+	return sets, nil
+}
+
+// arcSealHash calculates the hash for the ARC-Seal of the last of sets. The ARC
+// headers of all sets are hashed with relaxed canonicalization, the final
+// ARC-Seal from sealVerify, with empty b= and without trailing crlf.
+func arcSealHash(hash crypto.Hash, sets []arcSet, sealVerify []byte) ([]byte, error) {
+	// ../rfc/8617
+	h := hash.New()
+	for i, s := range sets {
+		l := []string{string(s.aar.raw), string(s.ams.raw)}
+		if i < len(sets)-1 {
+			l = append(l, string(s.as.raw))
+		}
+		for _, v := range l {
+			ch, err := relaxedCanonicalHeaderWithoutCRLF(v)
+			if err != nil {
+				return nil, fmt.Errorf("canonicalizing header: %w", err)
+			}
+			h.Write([]byte(ch + "\r\n"))
+		}
+	}
+	ch, err := relaxedCanonicalHeaderWithoutCRLF(string(sealVerify))
+	if err != nil {
+		return nil, fmt.Errorf("canonicalizing ARC-Seal header: %w", err)
+	}
+	h.Write([]byte(ch))
+	return h.Sum(nil), nil
+}
+
+// ARCVerify validates the ARC chain of a message.
+//
+// If the headers of the message cannot be parsed, an error is returned.
+// Otherwise the result of the validation is returned.
+//
+// NOTE: A passing chain only means the intermediaries that added the ARC sets
+// are authenticated. Whether the authentication results they recorded should be
+// used depends on whether the caller trusts the sealers.
+func ARCVerify(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, smtputf8 bool, r io.ReaderAt) (result ARCResult, rerr error) {
+	log := mlog.New("dkim", elog)
+	start := timeNow()
+	defer func() {
+		log.Debugx("arc verify result", rerr,
+			slog.Bool("smtputf8", smtputf8),
+			slog.Any("status", result.Status),
+			slog.Int("sets", len(result.Sets)),
+			slog.Any("err", result.Err),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	hdrs, bodyOffset, err := parseHeaders(bufio.NewReader(&moxio.AtReader{R: r}))
+	if err != nil {
+		return ARCResult{}, fmt.Errorf("%w: %s", ErrHeaderMalformed, err)
+	}
+
+	fail := func(err error) (ARCResult, error) {
+		result.Status = ARCStatusFail
+		result.OldestPass = 0
+		result.Err = err
+		return result, nil
+	}
+
+	sets, err := parseARCSets(hdrs, smtputf8)
+	if err != nil {
+		return fail(err)
+	}
+	if len(sets) == 0 {
+		return ARCResult{Status: ARCStatusNone}, nil
+	}
+	for i, s := range sets {
+		result.Sets = append(result.Sets, ARCSet{i + 1, s.asSig.Domain, s.authResults})
+	}
+
+	// An intermediary that found the chain invalid ends the chain. ../rfc/8617
+	n := len(sets)
+	if sets[n-1].cv == "fail" {
+		return fail(fmt.Errorf("%w: chain marked as failed at instance %d", ErrARCChain, n))
+	}
+	for i, s := range sets {
+		if i == 0 && s.cv != "none" || i > 0 && s.cv != "pass" {
+			return fail(fmt.Errorf("%w: unexpected chain validation status %q for instance %d", ErrARCChain, s.cv, i+1))
+		}
+	}
+
+	// verifyMessageSig verifies the ARC-Message-Signature of set i, like a DKIM-Signature.
+	verifyMessageSig := func(i int) error {
+		s := sets[i]
+		h, canonHeaderSimple, canonBodySimple, err := checkSignatureParams(ctx, log, s.amsSig)
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReader(&moxio.AtReader{R: r, Offset: int64(bodyOffset)})
+		status, _, _, err := verifySignature(ctx, log.Logger, resolver, s.amsSig, h, canonHeaderSimple, canonBodySimple, hdrs, s.amsVerify, br, true)
+		if err == nil && status != StatusPass {
+			err = fmt.Errorf("status %s", status)
+		}
+		return err
+	}
+
+	// The most recent message signature must be valid. ../rfc/8617
+	if err := verifyMessageSig(n - 1); err != nil {
+		return fail(fmt.Errorf("%w: ARC-Message-Signature instance %d: %w", ErrARCChain, n, err))
+	}
+
+	// All seals must be valid, starting with the most recent. ../rfc/8617
+	for i := n - 1; i >= 0; i-- {
+		sig := sets[i].asSig
+		hash, ok := algHash(sig.AlgorithmHash)
+		if !ok {
+			return fail(fmt.Errorf("%w: ARC-Seal instance %d: %w: %q", ErrARCChain, i+1, ErrHashAlgorithmUnknown, sig.AlgorithmHash))
+		}
+		_, record, _, _, err := Lookup(ctx, log.Logger, resolver, sig.Selector, sig.Domain)
+		if err != nil {
+			return fail(fmt.Errorf("%w: ARC-Seal instance %d: %w", ErrARCChain, i+1, err))
+		}
+		if _, err := checkRecord(record, sig); err != nil {
+			return fail(fmt.Errorf("%w: ARC-Seal instance %d: %w", ErrARCChain, i+1, err))
+		}
+		dh, err := arcSealHash(hash, sets[:i+1], sets[i].asVerify)
+		if err != nil {
+			return fail(fmt.Errorf("%w: ARC-Seal instance %d: %w", ErrARCChain, i+1, err))
+		}
+		if _, err := verifyDataHash(record, sig, hash, dh); err != nil {
+			return fail(fmt.Errorf("%w: ARC-Seal instance %d: %w", ErrARCChain, i+1, err))
+		}
+	}
+
+	// Find the oldest intermediary for which the message is unmodified. ../rfc/8617
+	result.OldestPass = n
+	for i := n - 2; i >= 0; i-- {
+		if verifyMessageSig(i) != nil {
+			break
+		}
+		result.OldestPass = i + 1
+	}
+
+	result.Status = ARCStatusPass
+	return result, nil
+}
+
+// ARCSeal returns the headers for a new ARC set for a message, to be prepended
+// to the message. The first selector with an RSA key is used for signing,
+// because ARC only allows rsa-sha256.
+//
+// authResults is the value of the Authentication-Results header for the message,
+// without header name, and is recorded in the ARC-Authentication-Results header.
+// chain is the result of validating the ARC chain when the message was received.
+// A failed chain is not sealed, and an error is returned.
+func ARCSeal(ctx context.Context, elog *slog.Logger, domain dns.Domain, selectors []Selector, smtputf8 bool, authResults string, chain ARCResult, msg io.ReaderAt) (headers string, rerr error) {
+	log := mlog.New("dkim", elog)
+	start := timeNow()
+	defer func() {
+		log.Debugx("arc seal result", rerr,
+			slog.Any("domain", domain),
+			slog.Bool("smtputf8", smtputf8),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	if chain.Status == ARCStatusFail {
+		return "", fmt.Errorf("%w: arc chain of message is invalid", ErrARCSeal)
+	}
+
+	var sel Selector
+	var key *rsa.PrivateKey
+	for _, s := range selectors {
+		if k, ok := s.PrivateKey.(*rsa.PrivateKey); ok {
+			sel, key = s, k
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("%w: no selector with rsa key", ErrARCSeal)
+	}
+
+	hdrs, bodyOffset, err := parseHeaders(bufio.NewReader(&moxio.AtReader{R: msg}))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrHeaderMalformed, err)
+	}
+	sets, err := parseARCSets(hdrs, smtputf8)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrARCSeal, err)
+	}
+	if len(sets) != len(chain.Sets) {
+		return "", fmt.Errorf("%w: message has %d arc sets, validated chain has %d", ErrARCSeal, len(sets), len(chain.Sets))
+	}
+	instance := len(sets) + 1
+	if instance > 50 {
+		// ../rfc/8617
+		return "", fmt.Errorf("%w: too many arc sets", ErrARCSeal)
+	}
+
+	MetricSign.IncLabels("rsa")
+	signTime := timeNow().Unix()
+
+	aar := fmt.Sprintf("ARC-Authentication-Results: i=%d; %s\r\n", instance, strings.TrimRight(strings.TrimLeft(authResults, " \t"), "\r\n"))
+	aarHdrs, _, err := parseHeaders(bufio.NewReader(strings.NewReader(aar + "\r\n")))
+	if err != nil {
+		return "", fmt.Errorf("%w: parsing arc authentication results: %v", ErrARCSeal, err)
+	}
+
+	// The message signature is like a DKIM-Signature. ../rfc/8617
+	ams := newSigWithDefaults()
+	ams.AlgorithmSign = "rsa"
+	ams.AlgorithmHash = "sha256"
+	ams.Domain = domain
+	ams.Selector = sel.Domain
+	ams.SignedHeaders = sel.Headers
+	ams.SignTime = signTime
+	ams.Canonicalization = "relaxed/relaxed"
+	br := bufio.NewReader(&moxio.AtReader{R: msg, Offset: int64(bodyOffset)})
+	ams.BodyHash, err = bodyHash(crypto.SHA256.New(), false, br)
+	if err != nil {
+		return "", err
+	}
+	amsTags := arcTags{instance: instance}
+	amsh, err := ams.header(kindARCMessageSignature, amsTags)
+	if err != nil {
+		return "", err
+	}
+	dh, err := dataHash(crypto.SHA256.New(), false, ams, hdrs, []byte(strings.TrimSuffix(amsh, "\r\n")))
+	if err != nil {
+		return "", err
+	}
+	ams.Signature, err = key.Sign(cryptorand.Reader, dh, crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("signing data: %v", err)
+	}
+	amsh, err = ams.header(kindARCMessageSignature, amsTags)
+	if err != nil {
+		return "", err
+	}
+	amsHdrs, _, err := parseHeaders(bufio.NewReader(strings.NewReader(amsh + "\r\n")))
+	if err != nil {
+		return "", fmt.Errorf("parsing arc message signature: %v", err)
+	}
+
+	// The seal signs the ARC headers of all sets, including the new one.
+	as := newSigWithDefaults()
+	as.Canonicalization = ""
+	as.AlgorithmSign = "rsa"
+	as.AlgorithmHash = "sha256"
+	as.Domain = domain
+	as.Selector = sel.Domain
+	as.SignTime = signTime
+	asTags := arcTags{instance: instance, cv: "none"}
+	if instance > 1 {
+		asTags.cv = "pass"
+	}
+	ash, err := as.header(kindARCSeal, asTags)
+	if err != nil {
+		return "", err
+	}
+	sets = append(sets, arcSet{aar: aarHdrs[0], ams: amsHdrs[0]})
+	dh, err = arcSealHash(crypto.SHA256, sets, []byte(strings.TrimSuffix(ash, "\r\n")))
+	if err != nil {
+		return "", err
+	}
+	as.Signature, err = key.Sign(cryptorand.Reader, dh, crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("signing data: %v", err)
+	}
+	ash, err = as.header(kindARCSeal, asTags)
+	if err != nil {
+		return "", err
+	}
+
+	return ash + amsh + aar, nil
+}
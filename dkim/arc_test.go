@@ -0,0 +1,110 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+)
+
+func TestARC(t *testing.T) {
+	message := strings.ReplaceAll(`Message-ID: <test@mox.example>
+Date: Fri, 10 Dec 2021 20:09:08 +0100
+To: list@list.example
+From: <mjl@mox.example>
+Subject: test
+
+test
+`, "\n", "\r\n")
+
+	ctx := context.Background()
+	rsaKey := getRSAKey(t)
+	sel := Selector{
+		Hash:       "sha256",
+		PrivateKey: rsaKey,
+		Headers:    strings.Split("From,To,Subject,Date,Message-ID", ","),
+		Domain:     dns.Domain{ASCII: "arc"},
+	}
+
+	record := &Record{Version: "DKIM1", Key: "rsa", PublicKey: rsaKey.Public()}
+	txt, err := record.Record()
+	tcheck(t, err, "making dns txt record")
+	resolver := dns.MockResolver{
+		TXT: map[string][]string{
+			"arc._domainkey.list.example.":    {txt},
+			"arc._domainkey.forward.example.": {txt},
+		},
+	}
+
+	verify := func(msg string, expStatus ARCStatus, expSets int) ARCResult {
+		t.Helper()
+		result, err := ARCVerify(ctx, pkglog.Logger, resolver, false, strings.NewReader(msg))
+		tcheck(t, err, "arc verify")
+		if result.Status != expStatus || len(result.Sets) != expSets {
+			t.Fatalf("got status %s with %d sets (err %v), expected %s with %d sets", result.Status, len(result.Sets), result.Err, expStatus, expSets)
+		}
+		return result
+	}
+
+	// No ARC headers.
+	chain := verify(message, ARCStatusNone, 0)
+
+	// Mailing list adds first set, and modifies the subject.
+	msg := strings.Replace(message, "Subject: test", "Subject: [list] test", 1)
+	headers, err := ARCSeal(ctx, pkglog.Logger, dns.Domain{ASCII: "list.example"}, []Selector{sel}, false, "list.example; dkim=pass header.d=mox.example", chain, strings.NewReader(msg))
+	tcheck(t, err, "arc seal")
+	msg = headers + msg
+	chain = verify(msg, ARCStatusPass, 1)
+	if chain.Sealer().ASCII != "list.example" || chain.OldestPass != 1 || chain.Sets[0].AuthResults != "list.example; dkim=pass header.d=mox.example" {
+		t.Fatalf("unexpected chain %#v", chain)
+	}
+
+	// Forwarder adds second set.
+	headers, err = ARCSeal(ctx, pkglog.Logger, dns.Domain{ASCII: "forward.example"}, []Selector{sel}, false, "forward.example; arc=pass", chain, strings.NewReader(msg))
+	tcheck(t, err, "arc seal")
+	if !strings.Contains(headers, "cv=pass;") {
+		t.Fatalf("missing cv=pass in second arc set:\n%s", headers)
+	}
+	msg2 := "Received: from forward.example\r\n" + headers + msg
+	chain = verify(msg2, ARCStatusPass, 2)
+	if chain.Sealer().ASCII != "forward.example" || chain.OldestPass != 1 {
+		t.Fatalf("unexpected chain %#v", chain)
+	}
+
+	// Modified body invalidates the most recent message signature.
+	verify(msg2+"more\r\n", ARCStatusFail, 2)
+
+	// Modified earlier ARC header invalidates the seals.
+	verify(strings.Replace(msg2, "dkim=pass header.d=mox.example", "dkim=pass header.d=other.example", 1), ARCStatusFail, 2)
+
+	// Missing set.
+	verify(headers+message, ARCStatusFail, 0)
+
+	// Signing key not in DNS.
+	delete(resolver.TXT, "arc._domainkey.list.example.")
+	verify(msg2, ARCStatusFail, 2)
+
+	// A failed chain isn't sealed.
+	_, err = ARCSeal(ctx, pkglog.Logger, dns.Domain{ASCII: "forward.example"}, []Selector{sel}, false, "forward.example; arc=fail", ARCResult{Status: ARCStatusFail}, strings.NewReader(msg2))
+	if !errors.Is(err, ErrARCSeal) {
+		t.Fatalf("got err %v, expected ErrARCSeal", err)
+	}
+
+	// ARC set added by Microsoft. Parsed, but we can't validate without the key.
+	buf, err := os.ReadFile("../testdata/dmarc-reports/outlook.eml")
+	tcheck(t, err, "read message")
+	chain = verify(string(buf), ARCStatusFail, 1)
+	if chain.Sealer().ASCII != "microsoft.com" || !errors.Is(chain.Err, ErrNoRecord) {
+		t.Fatalf("unexpected chain %#v", chain)
+	}
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
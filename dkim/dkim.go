@@ -526,6 +526,45 @@ func verifySignatureRecord(r *Record, sig *Sig, hash crypto.Hash, canonHeaderSim
 		}
 	}
 
+	if status, err := checkRecord(r, sig); err != nil {
+		return status, err
+	}
+
+	if sig.Length >= 0 {
+		// todo future: implement l= parameter in signatures. we don't currently allow this through policy check.
+		return StatusPermerror, fmt.Errorf("l= (length) parameter in signature not yet implemented")
+	}
+
+	// We first check the signature is with the claimed body hash is valid. Then we
+	// verify the body hash. In case of invalid signatures, we won't read the entire
+	// body.
+	// ../rfc/6376:1700
+	// ../rfc/6376:2656
+
+	dh, err := dataHash(hash.New(), canonHeaderSimple, sig, hdrs, verifySig)
+	if err != nil {
+		// Any error is likely an invalid header field in the message, hence permanent error.
+		return StatusPermerror, fmt.Errorf("calculating data hash: %w", err)
+	}
+
+	if status, err := verifyDataHash(r, sig, hash, dh); err != nil {
+		return status, err
+	}
+
+	bh, err := bodyHash(hash.New(), canonDataSimple, body)
+	if err != nil {
+		// Any error is likely some internal error, hence temporary error.
+		return StatusTemperror, fmt.Errorf("calculating body hash: %w", err)
+	}
+	if !bytes.Equal(sig.BodyHash, bh) {
+		return StatusFail, fmt.Errorf("%w: signature bodyhash %x != calculated bodyhash %x", ErrBodyhashMismatch, sig.BodyHash, bh)
+	}
+
+	return StatusPass, nil
+}
+
+// checkRecord checks if the DNS record allows the signature.
+func checkRecord(r *Record, sig *Sig) (Status, error) {
 	// ../rfc/6376:2639
 	if len(r.Hashes) > 0 {
 		ok := false
@@ -566,24 +605,12 @@ func verifySignatureRecord(r *Record, sig *Sig, hash crypto.Hash, canonHeaderSim
 			}
 		}
 	}
+	return "", nil
+}
 
-	if sig.Length >= 0 {
-		// todo future: implement l= parameter in signatures. we don't currently allow this through policy check.
-		return StatusPermerror, fmt.Errorf("l= (length) parameter in signature not yet implemented")
-	}
-
-	// We first check the signature is with the claimed body hash is valid. Then we
-	// verify the body hash. In case of invalid signatures, we won't read the entire
-	// body.
-	// ../rfc/6376:1700
-	// ../rfc/6376:2656
-
-	dh, err := dataHash(hash.New(), canonHeaderSimple, sig, hdrs, verifySig)
-	if err != nil {
-		// Any error is likely an invalid header field in the message, hence permanent error.
-		return StatusPermerror, fmt.Errorf("calculating data hash: %w", err)
-	}
-
+// verifyDataHash checks the signature in sig over data hash dh with the public key
+// from the DNS record.
+func verifyDataHash(r *Record, sig *Sig, hash crypto.Hash, dh []byte) (Status, error) {
 	switch k := r.PublicKey.(type) {
 	case *rsa.PublicKey:
 		if err := rsa.VerifyPKCS1v15(k, hash, dh, sig.Signature); err != nil {
@@ -596,16 +623,6 @@ func verifySignatureRecord(r *Record, sig *Sig, hash crypto.Hash, canonHeaderSim
 	default:
 		return StatusPermerror, fmt.Errorf("%w: unrecognized signature algorithm %q", ErrSigAlgorithmUnknown, r.Key)
 	}
-
-	bh, err := bodyHash(hash.New(), canonDataSimple, body)
-	if err != nil {
-		// Any error is likely some internal error, hence temporary error.
-		return StatusTemperror, fmt.Errorf("calculating body hash: %w", err)
-	}
-	if !bytes.Equal(sig.BodyHash, bh) {
-		return StatusFail, fmt.Errorf("%w: signature bodyhash %x != calculated bodyhash %x", ErrBodyhashMismatch, sig.BodyHash, bh)
-	}
-
 	return StatusPass, nil
 }
 
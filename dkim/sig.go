@@ -74,13 +74,48 @@ func (s Sig) Algorithm() string {
 	return s.AlgorithmSign + "-" + s.AlgorithmHash
 }
 
+// sigKind is the type of header with a DKIM-Signature-like syntax. ARC uses the
+// same syntax for its ARC-Message-Signature and ARC-Seal headers.
+type sigKind int
+
+const (
+	kindDKIM                sigKind = iota // DKIM-Signature.
+	kindARCMessageSignature                // ARC-Message-Signature, like DKIM-Signature, but with "i" holding the instance, and without "v".
+	kindARCSeal                            // ARC-Seal, with "i" and "cv", without "h" and "bh".
+)
+
+var sigKindHeaders = map[sigKind]string{
+	kindDKIM:                "DKIM-Signature",
+	kindARCMessageSignature: "ARC-Message-Signature",
+	kindARCSeal:             "ARC-Seal",
+}
+
+// arcTags holds the ARC-specific tags of ARC-Message-Signature and ARC-Seal.
+// ../rfc/8617
+type arcTags struct {
+	instance int    // Field "i", 1 to 50.
+	cv       string // Chain validation status, "none", "pass" or "fail", for ARC-Seal only. Field "cv".
+}
+
 // Header returns the DKIM-Signature header in string form, to be prepended to a
 // message, including DKIM-Signature field name and trailing \r\n.
 func (s *Sig) Header() (string, error) {
+	return s.header(kindDKIM, arcTags{})
+}
+
+func (s *Sig) header(kind sigKind, arc arcTags) (string, error) {
 	// ../rfc/6376:1021
 	// todo: make a higher-level writer that accepts pairs, and only folds to next line when needed.
 	w := &message.HeaderWriter{}
-	w.Addf("", "DKIM-Signature: v=%d;", s.Version)
+	switch kind {
+	case kindDKIM:
+		w.Addf("", "DKIM-Signature: v=%d;", s.Version)
+	case kindARCMessageSignature:
+		w.Addf("", "ARC-Message-Signature: i=%d;", arc.instance)
+	case kindARCSeal:
+		w.Addf("", "ARC-Seal: i=%d;", arc.instance)
+		w.Addf(" ", "cv=%s;", arc.cv)
+	}
 	// Domain names must always be in ASCII. ../rfc/6376:1115 ../rfc/6376:1187 ../rfc/6376:1303
 	w.Addf(" ", "d=%s;", s.Domain.ASCII)
 	w.Addf(" ", "s=%s;", s.Selector.ASCII)
@@ -143,7 +178,9 @@ func (s *Sig) Header() (string, error) {
 		}
 	}
 
-	w.Addf(" ", "bh=%s;", base64.StdEncoding.EncodeToString(s.BodyHash))
+	if kind != kindARCSeal {
+		w.Addf(" ", "bh=%s;", base64.StdEncoding.EncodeToString(s.BodyHash))
+	}
 
 	w.Addf(" ", "b=")
 	if len(s.Signature) > 0 {
@@ -173,6 +210,8 @@ func packQpHdrValue(s string) string {
 
 var (
 	errSigHeader         = errors.New("not DKIM-Signature header")
+	errSigARCInstance    = errors.New("invalid arc instance (i=)")
+	errSigARCSealHeaders = errors.New("arc seal must not have signed headers (h=)")
 	errSigDuplicateTag   = errors.New("duplicate tag")
 	errSigMissingCRLF    = errors.New("missing crlf at end")
 	errSigExpired        = errors.New("signature timestamp (t=) must be before signature expiration (x=)")
@@ -189,11 +228,20 @@ var (
 // The dkim signature with signature left empty ("b=") and without trailing
 // crlf is returned, for use in verification.
 func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err error) {
+	sig, _, verifySig, err = parseSig(buf, smtputf8, kindDKIM)
+	return
+}
+
+// parseSig parses a DKIM-Signature, or an ARC-Message-Signature or ARC-Seal, as
+// indicated by kind. For the ARC headers, the ARC-specific tags are returned in
+// arc.
+func parseSig(buf []byte, smtputf8 bool, kind sigKind) (sig *Sig, arc arcTags, verifySig []byte, err error) {
 	defer func() {
 		if x := recover(); x == nil {
 			return
 		} else if xerr, ok := x.(error); ok {
 			sig = nil
+			arc = arcTags{}
 			verifySig = nil
 			err = xerr
 		} else {
@@ -214,7 +262,7 @@ func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err
 	seen := map[string]struct{}{}
 	p := parser{s: string(buf), smtputf8: smtputf8}
 	name := p.xhdrName(false)
-	if !strings.EqualFold(name, "DKIM-Signature") {
+	if !strings.EqualFold(name, sigKindHeaders[kind]) {
 		xerrorf("%w", errSigHeader)
 	}
 	p.wsp()
@@ -284,6 +332,14 @@ func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err
 			// ../rfc/6376:1134
 			ds.SignedHeaders = p.xsignedHeaderFields()
 		case "i":
+			if kind != kindDKIM {
+				// For ARC, "i" is the instance of the ARC set. ../rfc/8617
+				arc.instance = int(p.xnumber(2))
+				if arc.instance < 1 || arc.instance > 50 {
+					xerrorf("%w: %d", errSigARCInstance, arc.instance)
+				}
+				break
+			}
 			// ../rfc/6376:1171
 			id := p.xauid()
 			ds.Identity = &id
@@ -305,6 +361,13 @@ func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err
 		case "z":
 			// ../rfc/6376:1361
 			ds.CopiedHeaders = p.xcopiedHeaderFields()
+		case "cv":
+			if kind == kindARCSeal {
+				// Chain validation status. ../rfc/8617
+				arc.cv = strings.ToLower(p.xtakefn1(false, func(c rune, i int) bool { return isalpha(c) }))
+				break
+			}
+			fallthrough
 		default:
 			// We must ignore unknown fields. ../rfc/6376:692 ../rfc/6376:1022
 			p.xchar() // ../rfc/6376-eid5070
@@ -325,15 +388,27 @@ func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err
 
 	// ../rfc/6376:2532
 	required := []string{"v", "a", "b", "bh", "d", "h", "s"}
+	switch kind {
+	case kindARCMessageSignature:
+		// ../rfc/8617
+		required = []string{"i", "a", "b", "bh", "d", "h", "s"}
+	case kindARCSeal:
+		// ../rfc/8617
+		required = []string{"i", "a", "b", "cv", "d", "s"}
+		if _, ok := seen["h"]; ok {
+			xerrorf("%w", errSigARCSealHeaders)
+		}
+	}
 	for _, req := range required {
 		if _, ok := seen[req]; !ok {
 			xerrorf("%w: %q", errSigMissingTag, req)
 		}
 	}
 
-	if strings.EqualFold(ds.AlgorithmHash, "sha1") && len(ds.BodyHash) != 20 {
+	// ARC-Seal has no body hash.
+	if kind != kindARCSeal && strings.EqualFold(ds.AlgorithmHash, "sha1") && len(ds.BodyHash) != 20 {
 		xerrorf("%w: got %d bytes, must be 20 for sha1", errSigBodyHash, len(ds.BodyHash))
-	} else if strings.EqualFold(ds.AlgorithmHash, "sha256") && len(ds.BodyHash) != 32 {
+	} else if kind != kindARCSeal && strings.EqualFold(ds.AlgorithmHash, "sha256") && len(ds.BodyHash) != 32 {
 		xerrorf("%w: got %d bytes, must be 32 for sha256", errSigBodyHash, len(ds.BodyHash))
 	}
 
@@ -349,5 +424,5 @@ func parseSignature(buf []byte, smtputf8 bool) (sig *Sig, verifySig []byte, err
 		xerrorf("%w: identity domain %q not under domain %q", errSigIdentityDomain, ds.Identity.Domain.ASCII, ds.Domain.ASCII)
 	}
 
-	return ds, []byte(p.tracked), nil
+	return ds, arc, []byte(p.tracked), nil
 }
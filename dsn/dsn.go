@@ -75,6 +75,12 @@ type Message struct {
 	// Original message or headers to include in DSN as third MIME part.
 	// Optional. Only used for generating DSNs, not set for parsed DNSs.
 	Original []byte
+
+	// If set and Original is a full message, the whole message is included instead of
+	// only its headers. For the RET=FULL parameter of the SMTP DSN extension,
+	// ../rfc/3461. Ignored when the original message requires smtputf8 but the DSN is
+	// composed without smtputf8, only headers are included then.
+	OriginalFull bool
 }
 
 // Action is a field in a DSN.
@@ -129,6 +135,22 @@ type Recipient struct {
 	Header textproto.MIMEHeader
 }
 
+// ParseORCPT returns the original recipient from an ORCPT parameter of the SMTP
+// DSN extension, the address type, a semicolon and the xtext-decoded address, for
+// use in Recipient.OriginalRecipient. A zero path is returned for address types
+// other than "rfc822" and "utf-8", and for invalid addresses.
+func ParseORCPT(orcpt string) smtp.Path {
+	t := strings.SplitN(orcpt, ";", 2)
+	if len(t) != 2 || !strings.EqualFold(t[0], "rfc822") && !strings.EqualFold(t[0], "utf-8") {
+		return smtp.Path{}
+	}
+	addr, err := smtp.ParseAddress(t[1])
+	if err != nil {
+		return smtp.Path{}
+	}
+	return addr.Path()
+}
+
 // Compose returns a DSN message.
 //
 // smtputf8 indicates whether the remote MTA that is receiving the DSN
@@ -144,7 +166,6 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 	// - 2. message/delivery-status;
 	// - 3. (optional) original message (either in full, or only headers).
 
-	// todo future: add option to send full message. but only do so if the message is <100kb.
 	// todo future: possibly write to a file directly, instead of building up message in memory.
 
 	// If message does not require smtputf8, we are never generating a utf-8 DSN.
@@ -222,7 +243,7 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 	}
 
 	// Per-message fields first. ../rfc/3464:575
-	// todo future: once we support the smtp dsn extension, the envid should be saved/set as OriginalEnvelopeID. ../rfc/3464:583 ../rfc/3461:1139
+	// OriginalEnvelopeID is from the ENVID parameter of the SMTP DSN extension. ../rfc/3464:583 ../rfc/3461:1139
 	if m.OriginalEnvelopeID != "" {
 		status("Original-Envelope-ID", m.OriginalEnvelopeID)
 	}
@@ -302,20 +323,34 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 		}
 	}
 
-	// We include only the header of the original message.
-	// todo: add the textual version of the original message, if it exists and isn't too large.
+	// We include the header of the original message, or the whole message if requested.
 	if m.Original != nil {
+		full := m.OriginalFull && (smtputf8 || !m.SMTPUTF8)
 		headers, err := message.ReadHeaders(bufio.NewReader(bytes.NewReader(m.Original)))
 		if err != nil && errors.Is(err, message.ErrHeaderSeparator) {
 			// Whole data is a header.
 			headers = m.Original
+			full = false
 		} else if err != nil {
 			return nil, err
 		}
-		// Else, this is a whole message. We still only include the headers. todo: include the whole body.
 
 		origHdr := textproto.MIMEHeader{}
-		if smtputf8 {
+		if full {
+			// ../rfc/3462:175 ../rfc/6533:431
+			if smtputf8 {
+				origHdr.Set("Content-Type", "message/global")
+			} else {
+				origHdr.Set("Content-Type", "message/rfc822")
+			}
+			// Only identity encodings are allowed for message/rfc822. ../rfc/2046
+			if bytes.IndexFunc(m.Original, func(r rune) bool { return r >= 0x80 }) >= 0 {
+				origHdr.Set("Content-Transfer-Encoding", "8BIT")
+			} else {
+				origHdr.Set("Content-Transfer-Encoding", "7BIT")
+			}
+			headers = m.Original
+		} else if smtputf8 {
 			// ../rfc/6533:431
 			// ../rfc/6533:605
 			origHdr.Set("Content-Type", "message/global-headers") // ../rfc/6533:625
@@ -336,7 +371,7 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 			return nil, err
 		}
 
-		if !smtputf8 && m.SMTPUTF8 {
+		if !full && !smtputf8 && m.SMTPUTF8 {
 			data := base64.StdEncoding.EncodeToString(headers)
 			for len(data) > 0 {
 				line := data
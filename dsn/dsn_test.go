@@ -129,6 +129,21 @@ func TestDSN(t *testing.T) {
 	tcompareReader(t, part.Parts[2].Reader(), m.Original)
 	tcompare(t, pmsg.Recipients[0].FinalRecipient, m.Recipients[0].FinalRecipient)
 
+	// With full original message, and fields for the smtp dsn extension.
+	m.OriginalFull = true
+	m.Original = []byte("Subject: test\r\n\r\nbody\r\n")
+	m.OriginalEnvelopeID = "envid"
+	m.Recipients[0].OriginalRecipient = smtp.Path{Localpart: "other", IPDomain: xparseIPDomain("remote.example")}
+	msgbuf, err = m.Compose(log, false)
+	if err != nil {
+		t.Fatalf("composing dsn with full message: %v", err)
+	}
+	pmsg, part = tparseMessage(t, msgbuf, 3)
+	tcheckType(t, &part.Parts[2], "message", "rfc822", "7bit")
+	tcompareReader(t, part.Parts[2].Reader(), m.Original)
+	tcompare(t, pmsg.OriginalEnvelopeID, "envid")
+	tcompare(t, pmsg.Recipients[0].OriginalRecipient, m.Recipients[0].OriginalRecipient)
+
 	// An utf-8 message.
 	m = Message{
 		SMTPUTF8: true,
@@ -0,0 +1,147 @@
+// Package greylist keeps track of delivery attempts for greylisting incoming
+// messages.
+//
+// With greylisting, the first delivery attempt for a combination of (masked)
+// remote IP, SMTP MAIL FROM and RCPT TO, the "triplet", is deferred with a
+// temporary error. Legitimate mail servers retry the delivery after a while, and
+// a retry after the configured delay passes. Much junk is sent by software that
+// doesn't retry. Triplets that passed are remembered, so later messages are not
+// delayed again. ../rfc/6647
+package greylist
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var (
+	metricCheck = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_greylist_check_total",
+			Help: "Number of greylist checks for incoming deliveries by result: pass, defer, error.",
+		},
+		[]string{"result"},
+	)
+)
+
+var timeNow = time.Now // Tests override this.
+
+// Triplet is a combination of masked remote IP, SMTP MAIL FROM and RCPT TO that
+// attempted a delivery.
+type Triplet struct {
+	ID       int64
+	IPMasked string `bstore:"nonzero,unique IPMasked+MailFrom+RcptTo"`
+	MailFrom string // Lower case, empty for the null sender.
+	RcptTo   string `bstore:"nonzero"` // Lower case.
+
+	First     time.Time // First attempt in the current retry window.
+	Last      time.Time `bstore:"index"` // Last attempt, for expiring the triplet.
+	Passed    time.Time // When the triplet passed, zero if it hasn't passed yet.
+	Deferred  int       // Number of attempts deferred.
+	Delivered int       // Number of attempts passed.
+}
+
+var DBTypes = []any{Triplet{}} // Types stored in DB.
+var DB *bstore.DB              // Exported for backups.
+
+// Init opens the database.
+func Init() error {
+	log := mlog.New("greylist", nil)
+
+	p := mox.DataDirPath("greylist.db")
+	os.MkdirAll(filepath.Dir(p), 0770)
+	opts := bstore.Options{Timeout: 5 * time.Second, Perm: 0660, RegisterLogger: moxvar.RegisterLogger(p, log.Logger)}
+	var err error
+	DB, err = bstore.Open(mox.Shutdown, p, &opts, DBTypes...)
+	return err
+}
+
+// Close closes the database.
+func Close() error {
+	if err := DB.Close(); err != nil {
+		return fmt.Errorf("close db: %w", err)
+	}
+	DB = nil
+	return nil
+}
+
+// Check records a delivery attempt for the triplet and returns whether it passes.
+//
+// A first attempt is deferred. A retry passes if it arrives at least delay after
+// the first attempt, but within window. Later retries start a new window. After
+// passing, attempts keep passing until the triplet hasn't been seen for expire.
+// Triplets not seen for expire are removed.
+func Check(ctx context.Context, log mlog.Log, ipMasked, mailFrom, rcptTo string, delay, window, expire time.Duration) (pass bool, rerr error) {
+	defer func() {
+		result := "defer"
+		if rerr != nil {
+			result = "error"
+		} else if pass {
+			result = "pass"
+		}
+		metricCheck.WithLabelValues(result).Inc()
+	}()
+
+	now := timeNow()
+	t := Triplet{IPMasked: ipMasked, MailFrom: strings.ToLower(mailFrom), RcptTo: strings.ToLower(rcptTo)}
+	err := DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Triplet](tx)
+		q.FilterLess("Last", now.Add(-expire))
+		if n, err := q.Delete(); err != nil {
+			return fmt.Errorf("removing expired triplets: %v", err)
+		} else if n > 0 {
+			log.Debug("expired greylist triplets removed", slog.Int("count", n))
+		}
+
+		q = bstore.QueryTx[Triplet](tx)
+		q.FilterEqual("IPMasked", t.IPMasked)
+		q.FilterEqual("MailFrom", t.MailFrom)
+		q.FilterEqual("RcptTo", t.RcptTo)
+		xt, err := q.Get()
+		if err == bstore.ErrAbsent {
+			t.First = now
+			t.Last = now
+			t.Deferred = 1
+			return tx.Insert(&t)
+		} else if err != nil {
+			return fmt.Errorf("looking up triplet: %v", err)
+		}
+		t = xt
+
+		t.Last = now
+		if t.Passed.IsZero() {
+			age := now.Sub(t.First)
+			if age > window {
+				// Retried too late, start over.
+				t.First = now
+			}
+			if age < delay || age > window {
+				t.Deferred++
+				return tx.Update(&t)
+			}
+			t.Passed = now
+		}
+		pass = true
+		t.Delivered++
+		return tx.Update(&t)
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Debug("greylist check", slog.Bool("pass", pass), slog.String("ipmasked", t.IPMasked), slog.String("mailfrom", t.MailFrom), slog.String("rcptto", t.RcptTo), slog.Int("deferred", t.Deferred))
+	return pass, nil
+}
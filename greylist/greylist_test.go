@@ -0,0 +1,73 @@
+package greylist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var ctxbg = context.Background()
+
+func TestGreylist(t *testing.T) {
+	mox.Shutdown = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/greylist/fake.conf")
+	mox.Conf.Static.DataDir = "."
+
+	dbpath := mox.DataDirPath("greylist.db")
+	os.MkdirAll(filepath.Dir(dbpath), 0770)
+	os.Remove(dbpath)
+	defer os.RemoveAll(filepath.Dir(dbpath))
+
+	log := mlog.New("greylist", nil)
+
+	if err := Init(); err != nil {
+		t.Fatalf("init database: %s", err)
+	}
+	defer Close()
+
+	now := time.Now().Round(0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	const delay, window, expire = 5 * time.Minute, 24 * time.Hour, 35 * 24 * time.Hour
+	check := func(ipMasked, mailFrom, rcptTo string, exp bool) {
+		t.Helper()
+		pass, err := Check(ctxbg, log, ipMasked, mailFrom, rcptTo, delay, window, expire)
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		if pass != exp {
+			t.Fatalf("got pass %v, expected %v", pass, exp)
+		}
+	}
+
+	// First attempt and early retry are deferred.
+	check("10.0.0.0", "remote@example.org", "mjl@mox.example", false)
+	now = now.Add(time.Minute)
+	check("10.0.0.0", "remote@example.org", "mjl@mox.example", false)
+
+	// Retry after delay passes, as do later attempts.
+	now = now.Add(5 * time.Minute)
+	check("10.0.0.0", "Remote@example.org", "mjl@mox.example", true)
+	check("10.0.0.0", "remote@example.org", "mjl@mox.example", true)
+
+	// Other triplets, including the null sender, are deferred.
+	check("10.0.0.0", "", "mjl@mox.example", false)
+	check("10.0.1.0", "remote@example.org", "mjl@mox.example", false)
+	check("10.0.0.0", "remote@example.org", "other@mox.example", false)
+
+	// Retry after the window starts over.
+	now = now.Add(25 * time.Hour)
+	check("10.0.0.0", "", "mjl@mox.example", false)
+	now = now.Add(10 * time.Minute)
+	check("10.0.0.0", "", "mjl@mox.example", true)
+
+	// Passed triplet expires when unused.
+	now = now.Add(36 * 24 * time.Hour)
+	check("10.0.0.0", "remote@example.org", "mjl@mox.example", false)
+}
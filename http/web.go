@@ -34,6 +34,7 @@ import (
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/imapserver"
+	"github.com/mjl-/mox/jmapserver"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/ratelimit"
@@ -561,6 +562,15 @@ func redirectToTrailingSlash(srv *serve, hostMatch func(dns.IPDomain) bool, name
 	}
 }
 
+// jmapWellKnown redirects requests for the JMAP session resource at its
+// well-known location to the configured path. ../rfc/8620:878
+func jmapWellKnown(srv *serve, hostMatch func(dns.IPDomain) bool, path string) {
+	handler := mox.SafeHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, path+"session", http.StatusTemporaryRedirect)
+	}))
+	srv.ServiceHandle("jmap", hostMatch, "/.well-known/jmap", handler)
+}
+
 // Listen binds to sockets for HTTP listeners, including those required for ACME to
 // generate TLS certificates. It stores the listeners so Serve can start serving them.
 func Listen() {
@@ -749,6 +759,32 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		redirectToTrailingSlash(srv, accountHostMatch, "webapi", path)
 	}
 
+	if l.JMAPHTTP.Enabled {
+		port := config.Port(l.JMAPHTTP.Port, 80)
+		path := "/jmap/"
+		if l.JMAPHTTP.Path != "" {
+			path = l.JMAPHTTP.Path
+		}
+		srv := ensureServe(false, l.JMAPHTTP.Forwarded, false, port, "jmap-http at "+path, true)
+		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), jmapserver.NewServer(maxMsgSize, path, l.JMAPHTTP.Forwarded)))
+		srv.ServiceHandle("jmap", accountHostMatch, path, handler)
+		redirectToTrailingSlash(srv, accountHostMatch, "jmap", path)
+		jmapWellKnown(srv, accountHostMatch, path)
+		ensureACMEHTTP01(srv)
+	}
+	if l.JMAPHTTPS.Enabled {
+		port := config.Port(l.JMAPHTTPS.Port, 443)
+		path := "/jmap/"
+		if l.JMAPHTTPS.Path != "" {
+			path = l.JMAPHTTPS.Path
+		}
+		srv := ensureServe(true, l.JMAPHTTPS.Forwarded, false, port, "jmap-https at "+path, true)
+		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), jmapserver.NewServer(maxMsgSize, path, l.JMAPHTTPS.Forwarded)))
+		srv.ServiceHandle("jmap", accountHostMatch, path, handler)
+		redirectToTrailingSlash(srv, accountHostMatch, "jmap", path)
+		jmapWellKnown(srv, accountHostMatch, path)
+	}
+
 	if l.WebmailHTTP.Enabled {
 		port := config.Port(l.WebmailHTTP.Port, 80)
 		path := "/webmail/"
@@ -315,6 +315,51 @@ func (c *Conn) Status(mailbox string, attrs ...StatusAttr) (resp Response, rerr
 	return c.transactf("status %s (%s)", astring(mailbox), strings.Join(l, " "))
 }
 
+// SetACL sets the rights of an identifier on a mailbox using the IMAP4 "SETACL"
+// command. Rights starting with "+" or "-" add or remove rights.
+//
+// Required capability: "ACL".
+func (c *Conn) SetACL(mailbox, identifier, rights string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("setacl %s %s %s", astring(mailbox), astring(identifier), astring(rights))
+}
+
+// DeleteACL removes the rights of an identifier on a mailbox using the IMAP4
+// "DELETEACL" command.
+//
+// Required capability: "ACL".
+func (c *Conn) DeleteACL(mailbox, identifier string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("deleteacl %s %s", astring(mailbox), astring(identifier))
+}
+
+// GetACL requests the rights of all identifiers on a mailbox using the IMAP4
+// "GETACL" command.
+//
+// Required capability: "ACL".
+func (c *Conn) GetACL(mailbox string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("getacl %s", astring(mailbox))
+}
+
+// ListRights requests the rights that can be granted to an identifier on a
+// mailbox using the IMAP4 "LISTRIGHTS" command.
+//
+// Required capability: "ACL".
+func (c *Conn) ListRights(mailbox, identifier string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("listrights %s %s", astring(mailbox), astring(identifier))
+}
+
+// MyRights requests the rights of the user on a mailbox using the IMAP4
+// "MYRIGHTS" command.
+//
+// Required capability: "ACL".
+func (c *Conn) MyRights(mailbox string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("myrights %s", astring(mailbox))
+}
+
 // Append represents a parameter to the IMAP4 "APPEND" or "REPLACE" commands, for
 // adding a message to mailbox, or replacing a message with a new version in a
 // mailbox.
@@ -532,6 +577,30 @@ func (c *Conn) UIDSearch(seqSet string, criteria string) (resp Response, rerr er
 	return c.transactf("seach %s %s", seqSet, criteria)
 }
 
+// UIDSort returns the UIDs of messages in the selected/active mailbox that match
+// the search criteria, ordered by the sort criteria, using the IMAP4 "UID SORT"
+// command.
+//
+// Sort criteria is a space-separated list of sort keys, each optionally prefixed
+// with "REVERSE", e.g. "REVERSE DATE SUBJECT".
+//
+// Required capability: "SORT".
+func (c *Conn) UIDSort(sortCriteria string, searchCriteria string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("uid sort (%s) utf-8 %s", sortCriteria, searchCriteria)
+}
+
+// UIDThread returns the UIDs of messages in the selected/active mailbox that match
+// the search criteria, arranged in threads using the IMAP4 "UID THREAD" command.
+//
+// Algorithm is "REFERENCES" or "ORDEREDSUBJECT".
+//
+// Required capability: "THREAD=REFERENCES" or "THREAD=ORDEREDSUBJECT".
+func (c *Conn) UIDThread(algorithm string, searchCriteria string) (resp Response, rerr error) {
+	defer c.recover(&rerr, &resp)
+	return c.transactf("uid thread %s utf-8 %s", algorithm, searchCriteria)
+}
+
 // MSNMove moves messages from the sequence set in the selected/active mailbox to
 // destMailbox using the IMAP4 "MOVE" command.
 //
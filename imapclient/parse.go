@@ -461,6 +461,43 @@ func (p *Proto) xuntagged() Untagged {
 		p.xcrlf()
 		return r
 
+	case "ACL":
+		// ../rfc/4314
+		p.xspace()
+		r := UntaggedACL{Mailbox: p.xastring()}
+		for p.space() {
+			id := p.xastring()
+			p.xspace()
+			r.Rights = append(r.Rights, IdentifierRights{id, p.xastring()})
+		}
+		p.xcrlf()
+		return r
+
+	case "LISTRIGHTS":
+		// ../rfc/4314
+		p.xspace()
+		var r UntaggedListrights
+		r.Mailbox = p.xastring()
+		p.xspace()
+		r.Identifier = p.xastring()
+		p.xspace()
+		r.Required = p.xastring()
+		for p.space() {
+			r.Optional = append(r.Optional, p.xastring())
+		}
+		p.xcrlf()
+		return r
+
+	case "MYRIGHTS":
+		// ../rfc/4314
+		p.xspace()
+		var r UntaggedMyrights
+		r.Mailbox = p.xastring()
+		p.xspace()
+		r.Rights = p.xastring()
+		p.xcrlf()
+		return r
+
 	case "STATUS":
 		// ../rfc/9051:6681
 		p.xspace()
@@ -592,6 +629,39 @@ func (p *Proto) xuntagged() Untagged {
 		p.xcrlf()
 		return r
 
+	case "SORT":
+		// ../rfc/5256
+		var nums []uint32
+		for p.space() {
+			// ../rfc/7162:2557
+			if p.take('(') {
+				p.xtake("MODSEQ")
+				p.xspace()
+				modseq := p.xint64()
+				p.xtake(")")
+				p.xcrlf()
+				return UntaggedSortModSeq{nums, modseq}
+			}
+			nums = append(nums, p.xnzuint32())
+		}
+		r := UntaggedSort(nums)
+		p.xcrlf()
+		return r
+
+	case "THREAD":
+		// ../rfc/5256
+		var r UntaggedThread
+		if p.space() {
+			for p.peek('(') {
+				r = append(r, p.xthreadList())
+			}
+			if len(r) == 0 {
+				p.xerrorf("expected thread list")
+			}
+		}
+		p.xcrlf()
+		return r
+
 	case "ESEARCH":
 		r := p.xesearchResponse()
 		p.xcrlf()
@@ -1480,6 +1550,48 @@ func (p *Proto) xnamespaceDescr() NamespaceDescr {
 	return NamespaceDescr{prefix, b, exts}
 }
 
+// xthreadList parses a parenthesized thread, returning its root. ../rfc/5256
+func (p *Proto) xthreadList() ThreadNode {
+	p.xtake("(")
+	var root ThreadNode
+	if p.peek('(') {
+		// Placeholder parent with two or more children.
+		root.Children = p.xthreadNested()
+		p.xtake(")")
+		return root
+	}
+
+	// Messages with a single child each, possibly ending with a message with multiple
+	// children.
+	root.Num = p.xnzuint32()
+	var nums []uint32
+	var children []ThreadNode
+	for p.space() {
+		if p.peek('(') {
+			children = p.xthreadNested()
+			break
+		}
+		nums = append(nums, p.xnzuint32())
+	}
+	p.xtake(")")
+	for i := len(nums) - 1; i >= 0; i-- {
+		children = []ThreadNode{{nums[i], children}}
+	}
+	root.Children = children
+	return root
+}
+
+func (p *Proto) xthreadNested() []ThreadNode {
+	l := []ThreadNode{p.xthreadList()}
+	for p.peek('(') {
+		l = append(l, p.xthreadList())
+	}
+	if len(l) < 2 {
+		p.xerrorf("nested thread list must have at least two threads")
+	}
+	return l
+}
+
 // ../rfc/9051:6546
 // Already consumed: "ESEARCH"
 func (p *Proto) xesearchResponse() (r UntaggedEsearch) {
@@ -35,6 +35,34 @@ func TestParse(t *testing.T) {
 	tcheckf(t, err, "parsing untagged")
 	tcompare(t, ut, UntaggedBye{Text: "done"})
 
+	ut, err = ParseUntagged("* THREAD (2)(3 6 (4 23)(44 7 96))((5)(8 9))\r\n")
+	tcheckf(t, err, "parsing untagged thread")
+	tcompare(t, ut, UntaggedThread{
+		{Num: 2},
+		{Num: 3, Children: []ThreadNode{
+			{Num: 6, Children: []ThreadNode{
+				{Num: 4, Children: []ThreadNode{{Num: 23}}},
+				{Num: 44, Children: []ThreadNode{{Num: 7, Children: []ThreadNode{{Num: 96}}}}},
+			}},
+		}},
+		{Children: []ThreadNode{
+			{Num: 5},
+			{Num: 8, Children: []ThreadNode{{Num: 9}}},
+		}},
+	})
+
+	ut, err = ParseUntagged("* SORT 5 3 4\r\n")
+	tcheckf(t, err, "parsing untagged sort")
+	tcompare(t, ut, UntaggedSort{5, 3, 4})
+
+	ut, err = ParseUntagged("* ACL INBOX mjl lrswipkxtea anyone lr\r\n")
+	tcheckf(t, err, "parsing untagged acl")
+	tcompare(t, ut, UntaggedACL{"INBOX", []IdentifierRights{{"mjl", "lrswipkxtea"}, {"anyone", "lr"}}})
+
+	ut, err = ParseUntagged("* LISTRIGHTS INBOX other \"\" l r s\r\n")
+	tcheckf(t, err, "parsing untagged listrights")
+	tcompare(t, ut, UntaggedListrights{"INBOX", "other", "", []string{"l", "r", "s"}})
+
 	tag, result, err := ParseResult("tag1 OK [ALERT] Hello\r\n")
 	tcheckf(t, err, "parsing result")
 	tcompare(t, tag, "tag1")
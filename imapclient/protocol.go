@@ -392,6 +392,60 @@ type UntaggedSearchModSeq struct {
 	Nums   []uint32
 	ModSeq int64
 }
+
+// UntaggedSort is the response to a SORT command, with message sequence numbers
+// or UIDs in the requested order. ../rfc/5256
+type UntaggedSort []uint32
+
+// UntaggedSortModSeq is like UntaggedSort, but with the highest modseq of the
+// returned messages, when the sort criteria included MODSEQ. ../rfc/7162:1101
+type UntaggedSortModSeq struct {
+	Nums   []uint32
+	ModSeq int64
+}
+
+// UntaggedThread is the response to a THREAD command, with a ThreadNode for the
+// root of each thread.
+type UntaggedThread []ThreadNode
+
+// ThreadNode is a message in a thread, with its replies.
+type ThreadNode struct {
+	// Message sequence number or UID. Zero for a placeholder for a missing parent
+	// of multiple messages, only used for the root of a thread.
+	Num      uint32
+	Children []ThreadNode
+}
+
+// UntaggedACL is the response to GETACL, with the rights of identifiers on a
+// mailbox. ../rfc/4314
+type UntaggedACL struct {
+	Mailbox string
+	Rights  []IdentifierRights
+}
+
+// IdentifierRights are the rights of an identifier, e.g. a user name or "anyone".
+type IdentifierRights struct {
+	Identifier string
+	Rights     string
+}
+
+// UntaggedListrights is the response to LISTRIGHTS, with the rights that are
+// always granted to an identifier, and the rights that can be granted, each as
+// group. ../rfc/4314
+type UntaggedListrights struct {
+	Mailbox    string
+	Identifier string
+	Required   string
+	Optional   []string
+}
+
+// UntaggedMyrights is the response to MYRIGHTS, with the rights of the user on a
+// mailbox. ../rfc/4314
+type UntaggedMyrights struct {
+	Mailbox string
+	Rights  string
+}
+
 type UntaggedStatus struct {
 	Mailbox string
 	Attrs   map[StatusAttr]int64 // Upper case status attributes.
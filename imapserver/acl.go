@@ -0,0 +1,737 @@
+package imapserver
+
+// Mailboxes can be shared with other accounts through the IMAP ACL extension,
+// ../rfc/4314. Grants are stored in the auth database, see store.MailboxACL.
+//
+// Shared mailboxes are visible to other accounts in the "Other Users" namespace,
+// as "Other Users/<account>/<mailbox>". When a shared mailbox is selected, the
+// account of the connection is switched to the account owning the mailbox. We
+// register a store.Comm with that account, so we get changes made by sessions of
+// the owner and of other accounts that have the mailbox selected, and our changes
+// are broadcast to them. Commands that don't operate on the selected mailbox,
+// like LIST, STATUS and APPEND, are executed with the account of the user.
+//
+// Commands operating on a mailbox by name, like STATUS and APPEND, temporarily
+// switch to the account owning a shared mailbox. Messages are copied/moved
+// between accounts by adding new messages.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/store"
+)
+
+// Namespace for mailboxes shared by other accounts, followed by "/<account>/".
+const sharedNamespace = "Other Users"
+
+// sharedName parses a mailbox name in the namespace for shared mailboxes.
+func sharedName(name string) (account, mbname string, ok bool) {
+	s, ok := strings.CutPrefix(name, sharedNamespace+"/")
+	if !ok {
+		return "", "", false
+	}
+	account, mbname, ok = strings.Cut(s, "/")
+	return account, mbname, ok && account != "" && mbname != ""
+}
+
+// xcheckNotShared returns an error if name is in the namespace for shared
+// mailboxes. Mailboxes cannot be created in that namespace.
+func xcheckNotShared(name string) {
+	if name == sharedNamespace || strings.HasPrefix(name, sharedNamespace+"/") {
+		xusercodeErrorf("CANNOT", "cannot create mailboxes in namespace %q for other users", sharedNamespace)
+	}
+}
+
+// home returns the account of the logged in user.
+func (c *conn) home() *store.Account {
+	if c.homeAccount != nil {
+		return c.homeAccount
+	}
+	return c.account
+}
+
+// inShared returns whether a shared mailbox is selected and we are executing a
+// command with the account of that mailbox.
+func (c *conn) inShared() bool {
+	return c.sharedComm != nil && c.comm == c.sharedComm
+}
+
+// homePending returns the channel for changes to the account of the user while
+// executing commands for a selected shared mailbox. Otherwise nil is returned, a
+// channel that never receives.
+func (c *conn) homePending() chan struct{} {
+	if c.homeComm == nil || c.comm == c.homeComm {
+		return nil
+	}
+	return c.homeComm.Pending
+}
+
+// xhome calls fn with the account of the user while a shared mailbox is selected.
+// The selected mailbox is hidden by clearing mailboxID, its ID can clash with
+// mailboxes of the user.
+func (c *conn) xhome(fn func()) {
+	comm, mailboxID := c.sharedComm, c.mailboxID
+	c.account, c.comm, c.mailboxID = c.homeAccount, c.homeComm, 0
+	defer func() {
+		// Restore, unless the shared mailbox was unselected, possibly by selecting
+		// another mailbox.
+		if c.sharedComm != nil && c.sharedComm == comm {
+			c.account, c.comm, c.mailboxID = c.sharedAccount, c.sharedComm, mailboxID
+		}
+	}()
+	fn()
+}
+
+// sharedRelease switches back to the account of the user after a shared mailbox
+// was selected. Called when unselecting.
+func (c *conn) sharedRelease() {
+	if c.sharedComm == nil {
+		return
+	}
+	acc, comm := c.sharedAccount, c.sharedComm
+	c.account, c.comm = c.homeAccount, c.homeComm
+	c.homeAccount, c.homeComm, c.sharedAccount, c.sharedComm = nil, nil, nil, nil
+	c.sharedRights, c.sharedPrefix = "", ""
+
+	comm.Unregister()
+	err := acc.Close()
+	c.xsanity(err, "closing account of shared mailbox")
+}
+
+// xsharedOpen opens the account owning a shared mailbox and checks the user has
+// the needed rights. The caller must close the account.
+func (c *conn) xsharedOpen(account, mbname, need string) (acc *store.Account, mb store.Mailbox, rights string) {
+	mbname, _, err := store.CheckMailboxName(mbname, true)
+	if err != nil {
+		xusercodeErrorf("CANNOT", "%s", err)
+	}
+
+	// Only open accounts that have shared a mailbox with us.
+	home := c.home()
+	grants, err := store.MailboxACLShared(context.TODO(), home.Name)
+	xcheckf(err, "listing shared mailboxes")
+	if !slices.ContainsFunc(grants, func(g store.MailboxACL) bool { return g.Account == account }) {
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+
+	acc, err = store.OpenAccount(c.log, account, false)
+	if err != nil {
+		c.log.Debugx("opening account of shared mailbox", err)
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+	var ok bool
+	defer func() {
+		if !ok {
+			err := acc.Close()
+			c.xsanity(err, "closing account of shared mailbox")
+		}
+	}()
+
+	acc.WithRLock(func() {
+		err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			xmb, err := acc.MailboxFind(tx, mbname)
+			if xmb != nil {
+				mb = *xmb
+			}
+			return err
+		})
+		xcheckf(err, "finding mailbox")
+	})
+
+	if mb.ID != 0 {
+		rights, err = store.MailboxACLRights(context.TODO(), account, mb.ID, home.Name)
+		xcheckf(err, "get rights for mailbox")
+	}
+	// Without rights, the mailbox does not exist for the user. ../rfc/4314
+	if !strings.ContainsAny(rights, "lrikxa") {
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+	for _, r := range need {
+		if !strings.ContainsRune(rights, r) {
+			xusercodeErrorf("NOPERM", "missing right %q for mailbox", r)
+		}
+	}
+
+	ok = true
+	return acc, mb, rights
+}
+
+// xselectShared switches the connection to the account of a shared mailbox that
+// is about to be selected. Its name in the account is returned.
+func (c *conn) xselectShared(account, mbname string) string {
+	acc, mb, rights := c.xsharedOpen(account, mbname, "r")
+
+	c.homeAccount, c.homeComm = c.account, c.comm
+	c.sharedAccount, c.sharedComm = acc, store.RegisterComm(acc)
+	c.account, c.comm = c.sharedAccount, c.sharedComm
+	c.sharedRights = rights
+	c.sharedPrefix = sharedNamespace + "/" + account + "/"
+	return mb.Name
+}
+
+// sharedSwitch switches the connection to the account owning name if it is a
+// shared mailbox, after checking rights. The mailbox name in the account is
+// returned, and a function that switches back to the original account. The error,
+// a userError, is returned instead of raised, for commands that must first read
+// the remainder of the command.
+func (c *conn) sharedSwitch(name, need string) (rname string, restore func(), rerr error) {
+	restore = func() {}
+	account, mbname, ok := sharedName(name)
+	if !ok {
+		return name, restore, nil
+	}
+
+	defer func() {
+		x := recover()
+		if err, ok := x.(userError); ok {
+			rname, rerr = name, err
+		} else if x != nil {
+			panic(x)
+		}
+	}()
+
+	acc, mb, _ := c.xsharedOpen(account, mbname, need)
+	origAccount, origComm, origMailboxID := c.account, c.comm, c.mailboxID
+	comm := store.RegisterComm(acc)
+	c.account, c.comm, c.mailboxID = acc, comm, 0
+	restore = func() {
+		c.account, c.comm, c.mailboxID = origAccount, origComm, origMailboxID
+		comm.Unregister()
+		err := acc.Close()
+		c.xsanity(err, "closing account of shared mailbox")
+	}
+	return mb.Name, restore, nil
+}
+
+// sharedRefresh looks up the current rights of the user on the selected shared
+// mailbox. The owner can change the rights while the mailbox is selected, so they
+// are looked up for each command, not only at SELECT.
+func (c *conn) sharedRefresh() {
+	if !c.inShared() {
+		return
+	}
+	rights, err := store.MailboxACLRights(context.TODO(), c.sharedAccount.Name, c.mailboxID, c.homeAccount.Name)
+	xcheckf(err, "get rights for shared mailbox")
+	c.sharedRights = rights
+}
+
+// sharedHasRight returns whether the user has right r on the selected mailbox.
+// Always true for mailboxes of the user.
+func (c *conn) sharedHasRight(r rune) bool {
+	return !c.inShared() || strings.ContainsRune(c.sharedRights, r)
+}
+
+// xsharedNeed raises an error if a shared mailbox is selected and the user does
+// not have all needed rights.
+func (c *conn) xsharedNeed(need string) {
+	for _, r := range need {
+		if !c.sharedHasRight(r) {
+			xusercodeErrorf("NOPERM", "missing right %q for shared mailbox", r)
+		}
+	}
+}
+
+// sharedChanges returns only the changes for the selected shared mailbox. Removals
+// for other mailboxes are marked as seen.
+func (c *conn) sharedChanges(changes []store.Change) []store.Change {
+	var l []store.Change
+	for _, change := range changes {
+		var mbID int64
+		switch ch := change.(type) {
+		case store.ChangeAddUID:
+			mbID = ch.MailboxID
+		case store.ChangeRemoveUIDs:
+			mbID = ch.MailboxID
+			if mbID != c.mailboxID {
+				c.comm.RemovalSeen(ch)
+			}
+		case store.ChangeFlags:
+			mbID = ch.MailboxID
+		}
+		if mbID == c.mailboxID {
+			l = append(l, change)
+		}
+	}
+	return l
+}
+
+// xdestination resolves the destination mailbox for a copy or move. If it is in
+// another account than that of the selected mailbox, that account is returned.
+// The name of the mailbox in the account is returned. The returned function must
+// be called when done.
+func (c *conn) xdestination(name string) (acc *store.Account, mbname string, done func()) {
+	done = func() {}
+	account, mbname, ok := sharedName(name)
+	if !ok {
+		if c.inShared() {
+			return c.homeAccount, name, done
+		}
+		return nil, name, done
+	}
+
+	acc, mb, _ := c.xsharedOpen(account, mbname, "i")
+	closeAccount := func() {
+		err := acc.Close()
+		c.xsanity(err, "closing account of shared mailbox")
+	}
+	if acc == c.account {
+		closeAccount()
+		return nil, mb.Name, done
+	}
+	return acc, mb.Name, closeAccount
+}
+
+// xcopyAccounts copies messages from the selected mailbox to a mailbox in another
+// account. The messages are added as new messages, like with APPEND.
+func (c *conn) xcopyAccounts(isUID bool, nums numSet, dstAcc *store.Account, name string) (uids []store.UID, mbDst store.Mailbox, newUIDs []store.UID) {
+	var msgs []store.Message
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			c.xmailboxID(tx, c.mailboxID) // Validate.
+
+			uids = c.gatherCopyMoveUIDs(tx, isUID, nums)
+			if len(uids) == 0 {
+				xuserErrorf("no matching messages to copy")
+			}
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+			q.FilterEqual("UID", slicesAny(uids)...)
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("UID")
+			var err error
+			msgs, err = q.List()
+			xcheckf(err, "fetching messages")
+			if len(msgs) != len(uids) {
+				xserverErrorf("uid and message mismatch")
+			}
+		})
+	})
+
+	// Message files are not removed while this session references them, so we can
+	// read them without holding the lock.
+	files := make([]*os.File, len(msgs))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				store.CloseRemoveTempFile(c.log, f, "copied message")
+			}
+		}
+	}()
+	var totalSize int64
+	for i, m := range msgs {
+		f, err := store.CreateMessageTemp(c.log, "imap-copy")
+		xcheckf(err, "creating temp file for message")
+		files[i] = f
+		mr := c.account.MessageReader(m)
+		n, err := io.Copy(f, mr)
+		mr.Close()
+		xcheckf(err, "copying message")
+		msgs[i].Size = n
+		totalSize += n
+	}
+
+	var newIDs []int64
+	var commit bool
+	defer func() {
+		if commit {
+			return
+		}
+		for _, id := range newIDs {
+			p := dstAcc.MessagePath(id)
+			err := os.Remove(p)
+			c.xsanity(err, "cleaning up copied message file after error")
+		}
+	}()
+
+	dstAcc.WithWLock(func() {
+		var changes []store.Change
+
+		err := dstAcc.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			xmb, err := dstAcc.MailboxFind(tx, name)
+			xcheckf(err, "finding mailbox")
+			if xmb == nil {
+				xusercodeErrorf("TRYCREATE", "%w", store.ErrUnknownMailbox)
+			}
+			mbDst = *xmb
+			nkeywords := len(mbDst.Keywords)
+
+			ok, maxSize, err := dstAcc.CanAddMessageSize(tx, totalSize)
+			xcheckf(err, "checking quota")
+			if !ok {
+				// ../rfc/9051:5155 ../rfc/9208:472
+				xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", maxSize)
+			}
+
+			modseq, err := dstAcc.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+			mbDst.ModSeq = modseq
+
+			msgDirs := map[string]struct{}{}
+			for i, om := range msgs {
+				m := store.Message{
+					MailboxID:     mbDst.ID,
+					MailboxOrigID: mbDst.ID,
+					Received:      om.Received,
+					Flags:         om.Flags,
+					Keywords:      om.Keywords,
+					Size:          om.Size,
+					ModSeq:        modseq,
+					CreateSeq:     modseq,
+				}
+				err := dstAcc.MessageAdd(c.log, tx, &mbDst, &m, files[i], store.AddOpts{SkipDirSync: true})
+				xcheckf(err, "adding message")
+				newIDs = append(newIDs, m.ID)
+				newUIDs = append(newUIDs, m.UID)
+				changes = append(changes, m.ChangeAddUID(mbDst))
+				msgDirs[filepath.Dir(dstAcc.MessagePath(m.ID))] = struct{}{}
+			}
+
+			changes = append(changes, mbDst.ChangeCounts())
+			if nkeywords != len(mbDst.Keywords) {
+				changes = append(changes, mbDst.ChangeKeywords())
+			}
+
+			err = tx.Update(&mbDst)
+			xcheckf(err, "updating mailbox counts")
+
+			for _, dir := range slices.Sorted(maps.Keys(msgDirs)) {
+				err := moxio.SyncDir(c.log, dir)
+				xcheckf(err, "sync dir")
+			}
+			return nil
+		})
+		xcheckf(err, "transaction")
+		commit = true
+
+		store.BroadcastChanges(dstAcc, changes)
+	})
+
+	return uids, mbDst, newUIDs
+}
+
+// xexpungeUIDs expunges messages from the selected mailbox, regardless of their
+// \Deleted flag, for moving messages to another account. The expunged UIDs are
+// returned.
+func (c *conn) xexpungeUIDs(uids []store.UID) (expunged []store.UID, modseq store.ModSeq) {
+	c.account.WithWLock(func() {
+		var changes []store.Change
+
+		c.xdbwrite(func(tx *bstore.Tx) {
+			mb := c.xmailboxID(tx, c.mailboxID)
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+			q.FilterEqual("UID", slicesAny(uids)...)
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("UID")
+			l, err := q.List()
+			xcheckf(err, "listing messages to expunge")
+
+			modseq, err = c.account.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+			mb.ModSeq = modseq
+
+			if len(l) > 0 {
+				chremuids, chmbcounts, err := c.account.MessageRemove(c.log, tx, modseq, &mb, store.RemoveOpts{}, l...)
+				xcheckf(err, "expunging messages")
+				changes = append(changes, chremuids, chmbcounts)
+			}
+
+			err = tx.Update(&mb)
+			xcheckf(err, "update mailbox")
+
+			for _, m := range l {
+				expunged = append(expunged, m.UID)
+			}
+		})
+
+		c.broadcast(changes)
+	})
+	return
+}
+
+// sharedReplaceDestination resolves the destination mailbox for REPLACE while a shared
+// mailbox is selected. The destination must be in the same account. The error is
+// returned instead of raised, because a literal may still need to be read.
+func (c *conn) sharedReplaceDestination(tx *bstore.Tx, name string) (string, error) {
+	account, mbname, ok := sharedName(name)
+	if !ok || account != c.account.Name {
+		return "", userError{"CANNOT", fmt.Errorf("cannot replace to mailbox in other account")}
+	}
+	for _, r := range "te" {
+		if !c.sharedHasRight(r) {
+			return "", userError{"NOPERM", fmt.Errorf("missing right %q for shared mailbox", r)}
+		}
+	}
+
+	mbname, _, err := store.CheckMailboxName(mbname, true)
+	if err != nil {
+		return "", userError{"CANNOT", err}
+	}
+	mb, err := c.account.MailboxFind(tx, mbname)
+	if err != nil {
+		return "", serverError{fmt.Errorf("finding mailbox: %v", err)}
+	} else if mb == nil {
+		return "", userError{"TRYCREATE", store.ErrUnknownMailbox}
+	}
+	rights, err := store.MailboxACLRights(context.TODO(), account, mb.ID, c.homeAccount.Name)
+	if err != nil {
+		return "", serverError{fmt.Errorf("get rights for mailbox: %v", err)}
+	} else if !strings.ContainsRune(rights, 'i') {
+		return "", userError{"NOPERM", fmt.Errorf("missing right %q for mailbox", 'i')}
+	}
+	return mb.Name, nil
+}
+
+// sharedMailbox is a mailbox of another account, with its name in the shared
+// namespace.
+type sharedMailbox struct {
+	mb     store.Mailbox
+	rights string
+}
+
+// xsharedMailboxes returns the mailboxes shared with the user that are visible in
+// listings, i.e. with the lookup right.
+func (c *conn) xsharedMailboxes() []sharedMailbox {
+	grants, err := store.MailboxACLShared(context.TODO(), c.home().Name)
+	xcheckf(err, "listing shared mailboxes")
+
+	// Merge rights for the user and "anyone".
+	accountRights := map[string]map[int64]string{}
+	for _, g := range grants {
+		if accountRights[g.Account] == nil {
+			accountRights[g.Account] = map[int64]string{}
+		}
+		accountRights[g.Account][g.MailboxID] = store.ACLRightsMerge(accountRights[g.Account][g.MailboxID], g.Rights)
+	}
+
+	var l []sharedMailbox
+	for _, account := range slices.Sorted(maps.Keys(accountRights)) {
+		acc, err := store.OpenAccount(c.log, account, false)
+		if err != nil {
+			c.log.Debugx("opening account for shared mailboxes", err)
+			continue
+		}
+		acc.WithRLock(func() {
+			err = acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+				for _, id := range slices.Sorted(maps.Keys(accountRights[account])) {
+					rights := accountRights[account][id]
+					if !strings.ContainsRune(rights, 'l') {
+						continue
+					}
+					mb, err := store.MailboxID(tx, id)
+					if err == bstore.ErrAbsent || err == store.ErrMailboxExpunged {
+						continue
+					} else if err != nil {
+						return err
+					}
+					mb.Name = sharedNamespace + "/" + account + "/" + mb.Name
+					l = append(l, sharedMailbox{mb, rights})
+				}
+				return nil
+			})
+		})
+		cerr := acc.Close()
+		c.xsanity(cerr, "closing account of shared mailbox")
+		xcheckf(err, "listing shared mailboxes")
+	}
+	return l
+}
+
+// xaclMailbox looks up a mailbox of the user or a shared mailbox, returning the
+// owner and the rights of the user.
+func (c *conn) xaclMailbox(name, need string) (owner string, mb store.Mailbox, rights string) {
+	if account, mbname, ok := sharedName(name); ok {
+		acc, mb, rights := c.xsharedOpen(account, mbname, need)
+		err := acc.Close()
+		c.xsanity(err, "closing account of shared mailbox")
+		return account, mb, rights
+	}
+
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			mb = c.xmailbox(tx, name, "")
+		})
+	})
+	return c.account.Name, mb, store.ACLRightsAll
+}
+
+// xaclGrantee resolves an identifier to an account name or "anyone".
+func xaclGrantee(identifier string) string {
+	grantee, err := store.ACLGrantee(identifier)
+	if err != nil {
+		xuserErrorf("%s", err)
+	}
+	return grantee
+}
+
+// Setacl changes the rights for an identifier (account, email address of an
+// account, or "anyone") on a mailbox.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdSetacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xspace()
+	modRights := p.xastring()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+	owner, mb, _ := c.xaclMailbox(name, "a")
+	grantee := xaclGrantee(identifier)
+	if grantee == owner {
+		xuserErrorf("cannot change rights of mailbox owner")
+	}
+
+	var rights string
+	if s, ok := strings.CutPrefix(modRights, "+"); ok {
+		rights = c.xaclRights(owner, mb.ID, grantee) + s
+	} else if s, ok := strings.CutPrefix(modRights, "-"); ok {
+		rights = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(s, r) {
+				return -1
+			}
+			return r
+		}, c.xaclRights(owner, mb.ID, grantee))
+	} else {
+		rights = modRights
+	}
+
+	err := store.MailboxACLSet(context.TODO(), owner, mb.ID, grantee, rights)
+	if err != nil {
+		xuserErrorf("%s", err)
+	}
+	c.ok(tag, cmd)
+}
+
+// xaclRights returns the rights granted to grantee, not including those for
+// "anyone".
+func (c *conn) xaclRights(owner string, mailboxID int64, grantee string) string {
+	l, err := store.MailboxACLList(context.TODO(), owner, mailboxID)
+	xcheckf(err, "listing rights")
+	for _, acl := range l {
+		if acl.Grantee == grantee {
+			return acl.Rights
+		}
+	}
+	return ""
+}
+
+// Deleteacl removes the rights for an identifier on a mailbox.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdDeleteacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+	owner, mb, _ := c.xaclMailbox(name, "a")
+	grantee := xaclGrantee(identifier)
+	if grantee == owner {
+		xuserErrorf("cannot remove rights of mailbox owner")
+	}
+
+	err := store.MailboxACLSet(context.TODO(), owner, mb.ID, grantee, "")
+	xcheckf(err, "removing rights")
+	c.ok(tag, cmd)
+}
+
+// Getacl returns the rights of all identifiers for a mailbox, including the
+// owner.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGetacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+	owner, mb, _ := c.xaclMailbox(name, "a")
+	l, err := store.MailboxACLList(context.TODO(), owner, mb.ID)
+	xcheckf(err, "listing rights")
+
+	// Response syntax: ../rfc/4314
+	resp := fmt.Sprintf("* ACL %s %s %s", mailboxt(name).pack(c), astring(owner).pack(c), astring(store.ACLRightsAll).pack(c))
+	for _, acl := range l {
+		resp += fmt.Sprintf(" %s %s", astring(acl.Grantee).pack(c), astring(acl.Rights).pack(c))
+	}
+	c.xbwritelinef("%s", resp)
+	c.ok(tag, cmd)
+}
+
+// Listrights returns the rights that can be granted to an identifier on a
+// mailbox. The owner always has all rights, others can be granted each right
+// independently.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdListrights(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+	owner, _, _ := c.xaclMailbox(name, "a")
+	grantee := xaclGrantee(identifier)
+
+	// Response syntax: ../rfc/4314
+	resp := fmt.Sprintf("* LISTRIGHTS %s %s", mailboxt(name).pack(c), astring(identifier).pack(c))
+	if grantee == owner {
+		resp += " " + astring(store.ACLRightsAll).pack(c)
+	} else {
+		resp += ` ""`
+		for _, r := range store.ACLRightsAll {
+			resp += " " + string(r)
+		}
+	}
+	c.xbwritelinef("%s", resp)
+	c.ok(tag, cmd)
+}
+
+// Myrights returns the rights of the user on a mailbox.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdMyrights(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+	_, _, rights := c.xaclMailbox(name, "")
+
+	// Response syntax: ../rfc/4314
+	c.xbwritelinef("* MYRIGHTS %s %s", mailboxt(name).pack(c), astring(rights).pack(c))
+	c.ok(tag, cmd)
+}
@@ -0,0 +1,143 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestACL(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+	tc.login("mjl@mox.example", password0)
+
+	tc2 := startArgs(t, false, false, false, true, true, "other")
+	defer tc2.closeNoWait()
+	tc2.login("other@mox.example", password0)
+
+	tc.client.Create("shared", nil)
+	tc.client.Append("shared", makeAppend(exampleMsg))
+
+	tc.transactf("ok", "myrights inbox")
+	tc.xuntagged(imapclient.UntaggedMyrights{Mailbox: "Inbox", Rights: "lrswipkxtea"})
+
+	tc.transactf("ok", "getacl shared")
+	tc.xuntagged(imapclient.UntaggedACL{Mailbox: "shared", Rights: []imapclient.IdentifierRights{{Identifier: "mjl", Rights: "lrswipkxtea"}}})
+
+	tc.transactf("bad", "setacl shared other")               // Missing rights.
+	tc.transactf("no", "setacl nonexistent other lr")        // Unknown mailbox.
+	tc.transactf("no", "setacl shared bogus lr")             // Unknown identifier.
+	tc.transactf("no", "setacl shared mjl lr")               // Owner.
+	tc.transactf("no", "setacl shared other lrz")            // Unknown right.
+	tc.transactf("ok", "setacl shared other@mox.example lr") // Email address of account.
+
+	tc.transactf("ok", "getacl shared")
+	tc.xuntagged(imapclient.UntaggedACL{Mailbox: "shared", Rights: []imapclient.IdentifierRights{{Identifier: "mjl", Rights: "lrswipkxtea"}, {Identifier: "other", Rights: "lr"}}})
+
+	tc.transactf("ok", "listrights shared other")
+	tc.xuntagged(imapclient.UntaggedListrights{Mailbox: "shared", Identifier: "other", Required: "", Optional: []string{"l", "r", "s", "w", "i", "p", "k", "x", "t", "e", "a"}})
+
+	tc2.transactf("ok", "namespace")
+	tc2.xuntagged(imapclient.UntaggedNamespace{
+		Personal: []imapclient.NamespaceDescr{{Prefix: "", Separator: '/'}},
+		Other:    []imapclient.NamespaceDescr{{Prefix: "Other Users/", Separator: '/'}},
+	})
+
+	tc2.transactf("ok", `list "" "Other Users*"`)
+	tc2.xuntagged(
+		imapclient.UntaggedList{Flags: []string{`\Noselect`}, Separator: '/', Mailbox: "Other Users"},
+		imapclient.UntaggedList{Flags: []string{`\Noselect`}, Separator: '/', Mailbox: "Other Users/mjl"},
+		imapclient.UntaggedList{Separator: '/', Mailbox: "Other Users/mjl/shared"},
+	)
+
+	tc2.transactf("ok", `myrights "Other Users/mjl/shared"`)
+	tc2.xuntagged(imapclient.UntaggedMyrights{Mailbox: "Other Users/mjl/shared", Rights: "lr"})
+	tc2.transactf("no", `getacl "Other Users/mjl/shared"`) // Needs admin right.
+	tc2.xcodeWord("NOPERM")
+	tc2.transactf("no", `myrights "Other Users/mjl/Inbox"`) // Not shared.
+	tc2.transactf("no", `myrights "Other Users/other/Inbox"`)
+
+	tc2.transactf("ok", `status "Other Users/mjl/shared" (messages)`)
+	tc2.xuntagged(imapclient.UntaggedStatus{Mailbox: "Other Users/mjl/shared", Attrs: map[imapclient.StatusAttr]int64{"MESSAGES": 1}})
+
+	tc2.transactf("no", `append "Other Users/mjl/shared" (\Seen) {%d+}`+"\r\n"+exampleMsg, len(exampleMsg)) // Needs insert right.
+	tc2.xcodeWord("NOPERM")
+
+	tc2.transactf("no", `create "Other Users/test"`)
+	tc2.xcodeWord("CANNOT")
+
+	// Without rights to change flags or expunge, the mailbox is read-only.
+	tc2.transactf("ok", `select "Other Users/mjl/shared"`)
+	tc2.xcodeWord("READ-ONLY")
+	tc2.transactf("ok", "fetch 1 body[]")
+	tc2.transactf("ok", "fetch 1 flags")
+	tc2.xuntagged(tc2.untaggedFetch(1, 1, imapclient.FetchFlags(nil)))
+	tc2.transactf("no", `store 1 +flags (\Seen)`)
+
+	// Commands not for the selected mailbox use the account of the user.
+	tc2.transactf("ok", "status inbox (messages)")
+	tc2.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{"MESSAGES": 0}})
+
+	tc.transactf("ok", "setacl shared other +swite")
+	tc2.transactf("ok", `myrights "Other Users/mjl/shared"`)
+	tc2.xuntagged(imapclient.UntaggedMyrights{Mailbox: "Other Users/mjl/shared", Rights: "lrswite"})
+
+	tc2.transactf("ok", `select "Other Users/mjl/shared"`)
+	tc2.xcodeWord("READ-WRITE")
+
+	// Rights changed by the owner apply to the selected mailbox for the next command.
+	tc.transactf("ok", "setacl shared other -w")
+	tc2.transactf("no", `store 1 +flags (\Flagged)`)
+	tc2.xcodeWord("NOPERM")
+	tc.transactf("ok", "setacl shared other +w")
+
+	// Changes by the owner and by the other account are visible to each other.
+	tc.client.Select("shared")
+	tc2.transactf("ok", `store 1 +flags (\Flagged)`)
+	tc.transactf("ok", "noop")
+	tc.xuntagged(tc.untaggedFetch(1, 1, imapclient.FetchFlags{`\Flagged`}))
+
+	tc.client.Append("shared", makeAppend(exampleMsg))
+	tc2.transactf("ok", "noop")
+	tc2.xuntagged(imapclient.UntaggedExists(2), tc2.untaggedFetch(2, 2, imapclient.FetchFlags(nil)))
+
+	// Copy and move to a mailbox of the user, in another account.
+	tc2.transactf("ok", "copy 1 inbox")
+	if code, ok := tc2.lastResponse.Code.(imapclient.CodeCopyUID); !ok || len(code.From) != 1 || code.From[0].First != 1 || len(code.To) != 1 || code.To[0].First != 1 {
+		t.Fatalf("got code %#v, expected copyuid for uid 1 to 1", tc2.lastResponse.Code)
+	}
+	tc2.transactf("ok", "move 2 inbox")
+	tc2.xuntaggedOpt(false, imapclient.UntaggedExpunge(2))
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedExpunge(2))
+
+	tc2.transactf("ok", "status inbox (messages)")
+	tc2.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{"MESSAGES": 2}})
+
+	tc2.transactf("no", `copy 1 "Other Users/mjl/shared"`) // Cannot copy to selected mailbox.
+	tc2.transactf("no", `copy 1 "Other Users/mjl/Inbox"`)  // Not shared.
+
+	tc2.transactf("ok", "unselect")
+	tc2.transactf("ok", "select inbox")
+	tc2.transactf("ok", "fetch 1:* flags")
+	tc2.xuntagged(
+		tc2.untaggedFetch(1, 1, imapclient.FetchFlags{`\Flagged`}),
+		tc2.untaggedFetch(2, 2, imapclient.FetchFlags(nil)),
+	)
+
+	// Copy from a mailbox of the user to the shared mailbox, needs insert right.
+	tc2.transactf("ok", `copy 1 "Other Users/mjl/shared"`)
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedExists(2), tc.untaggedFetch(2, 3, imapclient.FetchFlags{`\Flagged`}))
+
+	// Removing the grant makes the mailbox invisible.
+	tc.transactf("ok", "deleteacl shared other")
+	tc2.transactf("no", `myrights "Other Users/mjl/shared"`)
+	tc2.transactf("ok", `list "" "Other Users*"`)
+	tc2.xuntagged()
+
+	// Rights for anyone.
+	tc.transactf("ok", "setacl shared anyone lr")
+	tc2.transactf("ok", `myrights "Other Users/mjl/shared"`)
+	tc2.xuntagged(imapclient.UntaggedMyrights{Mailbox: "Other Users/mjl/shared", Rights: "lr"})
+}
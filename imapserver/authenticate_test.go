@@ -14,14 +14,18 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/text/secure/precis"
 
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/imapclient"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/store"
 )
@@ -380,3 +384,78 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 		t.Fatalf("got err %#v, expected tls 'bad certificate' alert", err)
 	}
 }
+
+func TestAuthenticateOAuth(t *testing.T) {
+	issuer := oidc.NewMockIssuer()
+	defer issuer.Close()
+
+	tc := start(t, false)
+
+	// Not announced or allowed without configuration.
+	tc.transactf("ok", "capability")
+	if slices.Contains(tc.lastResponse.Untagged[0].(imapclient.UntaggedCapability), "AUTH=OAUTHBEARER") {
+		t.Fatalf("oauthbearer announced without oidc config")
+	}
+	token := issuer.Token(map[string]any{"aud": "mail", "email": "mjl@mox.example", "email_verified": true})
+	tc.transactf("no", "authenticate oauthbearer %s", base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "n,,\x01auth=Bearer %s\x01\x01", token)))
+
+	// Starting a connection loads the config again.
+	oidcConf := &config.OIDC{
+		Issuer:       issuer.URL,
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		Audience:     []string{"mail"},
+		Claim:        "email",
+	}
+	mox.Conf.Static.OIDC = oidcConf
+	defer func() { mox.Conf.Static.OIDC = nil }()
+
+	tc.transactf("ok", "capability")
+	caps := tc.lastResponse.Untagged[0].(imapclient.UntaggedCapability)
+	if !slices.Contains(caps, "AUTH=OAUTHBEARER") || !slices.Contains(caps, "AUTH=XOAUTH2") {
+		t.Fatalf("oauthbearer and xoauth2 not announced, capabilities %v", caps)
+	}
+
+	// auth sends the initial response for the mechanism, and returns the server response.
+	auth := func(mech, status string, client sasl.Client) {
+		t.Helper()
+		buf, _, err := client.Next(nil)
+		tcheck(t, err, "sasl client first")
+		tc.cmdf("", "authenticate %s %s", mech, base64.StdEncoding.EncodeToString(buf))
+		if status == "ok" {
+			tc.readstatus("ok")
+			return
+		}
+		line, err := tc.client.Readline()
+		tcheck(t, err, "read continuation")
+		chal, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "+ "))
+		tcheck(t, err, "decode challenge")
+		if string(chal) != sasl.OAuthErrorChallenge {
+			t.Fatalf("got challenge %q, expected %q", chal, sasl.OAuthErrorChallenge)
+		}
+		buf, _, err = client.Next(chal)
+		if err == nil {
+			t.Fatalf("sasl client did not return error for error challenge")
+		}
+		tc.writelinef("%s", base64.StdEncoding.EncodeToString(buf))
+		tc.readstatus(status)
+		tc.xcodeWord("AUTHENTICATIONFAILED")
+	}
+
+	badToken := issuer.Token(map[string]any{"aud": "other", "email": "mjl@mox.example", "email_verified": true})
+	unknownToken := issuer.Token(map[string]any{"aud": "mail", "email": "unknown@mox.example", "email_verified": true})
+	auth("oauthbearer", "no", sasl.NewClientOAUTHBEARER("", badToken))
+	auth("oauthbearer", "no", sasl.NewClientOAUTHBEARER("", unknownToken))
+	auth("xoauth2", "no", sasl.NewClientXOAUTH2("mjl@mox.example", badToken))
+
+	tc.transactf("bad", "authenticate oauthbearer %s", base64.StdEncoding.EncodeToString([]byte("bogus")))
+	tc.transactf("no", "authenticate oauthbearer %s", base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "n,a=other@mox.example,\x01auth=Bearer %s\x01\x01", token)))
+	tc.xcodeWord("AUTHORIZATIONFAILED")
+
+	auth("oauthbearer", "ok", sasl.NewClientOAUTHBEARER("mjl@mox.example", token))
+	tc.close()
+
+	tc = start(t, false)
+	mox.Conf.Static.OIDC = oidcConf
+	defer tc.close()
+	auth("xoauth2", "ok", sasl.NewClientXOAUTH2("mjl@mox.example", token))
+}
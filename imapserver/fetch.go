@@ -706,7 +706,7 @@ func xenvelope(p *message.Part) token {
 }
 
 func (cmd *fetchCmd) peekOrSeen(peek bool) {
-	if cmd.conn.readonly || peek {
+	if cmd.conn.readonly || peek || !cmd.conn.sharedHasRight('s') {
 		return
 	}
 	m := cmd.xensureMessage()
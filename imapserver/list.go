@@ -134,11 +134,17 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 	var responseLines []string
 	var respMetadata []concatspace
 
+	// Gathered before locking our account, to not hold locks of multiple accounts.
+	shared := c.xsharedMailboxes()
+
 	c.account.WithRLock(func() {
 		c.xdbread(func(tx *bstore.Tx) {
 			type info struct {
 				mailbox    *store.Mailbox
 				subscribed bool
+				noselect   bool   // For parents of shared mailboxes.
+				shared     bool   // Mailbox of other account.
+				rights     string // For shared mailbox.
 			}
 			names := map[string]info{}
 			hasSubscribedChild := map[string]bool{}
@@ -157,6 +163,22 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 			})
 			xcheckf(err, "listing mailboxes")
 
+			// Mailboxes shared by other accounts, and their parents in the namespace.
+			for _, sm := range shared {
+				if _, ok := names[sm.mb.Name]; ok {
+					continue
+				}
+				names[sm.mb.Name] = info{mailbox: &sm.mb, shared: true, rights: sm.rights}
+				nameList = append(nameList, sm.mb.Name)
+				for p := mox.ParentMailboxName(sm.mb.Name); p != ""; p = mox.ParentMailboxName(p) {
+					hasChild[p] = true
+					if _, ok := names[p]; !ok {
+						names[p] = info{noselect: true}
+						nameList = append(nameList, p)
+					}
+				}
+			}
+
 			qs := bstore.QueryTx[store.Subscription](tx)
 			err = qs.ForEach(func(sub store.Subscription) error {
 				info, ok := names[sub.Name]
@@ -191,9 +213,12 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 						flags = append(flags, bare(`\NonExistent`))
 					}
 				}
-				if (info.mailbox == nil || listSubscribed) && flags == nil && extended == nil {
+				if (info.mailbox == nil && !info.noselect || listSubscribed) && flags == nil && extended == nil {
 					continue
 				}
+				if info.noselect {
+					flags = append(flags, bare(`\Noselect`))
+				}
 
 				if retChildren {
 					var f string
@@ -228,12 +253,13 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 				line := fmt.Sprintf(`* LIST %s "/" %s%s`, flags.pack(c), mailboxt(name).pack(c), extStr)
 				responseLines = append(responseLines, line)
 
-				if retStatusAttrs != nil && info.mailbox != nil {
+				// Status of shared mailboxes requires the read right. ../rfc/4314
+				if retStatusAttrs != nil && info.mailbox != nil && (!info.shared || strings.ContainsRune(info.rights, 'r')) {
 					responseLines = append(responseLines, c.xstatusLine(tx, *info.mailbox, retStatusAttrs))
 				}
 
 				// ../rfc/9590:101
-				if info.mailbox != nil && len(retMetadata) > 0 {
+				if info.mailbox != nil && !info.shared && len(retMetadata) > 0 {
 					var meta listspace
 					for _, k := range retMetadata {
 						q := bstore.QueryTx[store.Annotation](tx)
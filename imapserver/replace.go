@@ -67,11 +67,22 @@ func (c *conn) cmdxReplace(isUID bool, tag, cmd string, p *parser) {
 	// in quota. If a non-nil func is returned, an error was found. Calling the
 	// function aborts handling this command.
 	var uidOld store.UID
+	var sharedResolved bool
+	var sharedErr error
 	checkMessage := func(tx *bstore.Tx) func() {
 		if c.readonly {
 			return func() { xuserErrorf("mailbox open in read-only mode") }
 		}
 
+		// With a shared mailbox selected, the destination must be in the same account.
+		if c.inShared() && !sharedResolved {
+			sharedResolved = true
+			name, sharedErr = c.sharedReplaceDestination(tx, name)
+		}
+		if sharedErr != nil {
+			return func() { panic(sharedErr) }
+		}
+
 		mb, err := c.account.MailboxFind(tx, name)
 		if err != nil {
 			return func() { xserverErrorf("finding mailbox: %v", err) }
@@ -99,63 +99,13 @@ func (c *conn) cmdxSearch(isUID, isE bool, tag, cmd string, p *parser) {
 		}
 	}
 	p.xspace()
-	sk := &searchKey{
-		searchKeys: []searchKey{*p.xsearchKey()},
-	}
-	for !p.empty() {
-		p.xspace()
-		sk.searchKeys = append(sk.searchKeys, *p.xsearchKey())
-	}
-
-	// Sequence set search program must be rejected with UIDONLY enabled. ../rfc/9586:220
-	if c.uidonly && sk.hasSequenceNumbers() {
-		xsyntaxCodeErrorf("UIDREQUIRED", "cannot search message sequence numbers in search program with uidonly enabled")
-	}
+	sk, bodySearch, textSearch := c.xsearchProgram(p)
 
 	// Even in case of error, we ensure search result is changed.
 	if save {
 		c.searchResult = []store.UID{}
 	}
 
-	// We gather word and not-word searches from the top-level, turn them
-	// into a WordSearch for a more efficient search.
-	// todo optimize: also gather them out of AND searches.
-	var textWords, textNotWords, bodyWords, bodyNotWords []string
-	n := 0
-	for _, xsk := range sk.searchKeys {
-		switch xsk.op {
-		case "BODY":
-			bodyWords = append(bodyWords, xsk.astring)
-			continue
-		case "TEXT":
-			textWords = append(textWords, xsk.astring)
-			continue
-		case "NOT":
-			switch xsk.searchKey.op {
-			case "BODY":
-				bodyNotWords = append(bodyNotWords, xsk.searchKey.astring)
-				continue
-			case "TEXT":
-				textNotWords = append(textNotWords, xsk.searchKey.astring)
-				continue
-			}
-		}
-		sk.searchKeys[n] = xsk
-		n++
-	}
-	// We may be left with an empty but non-nil sk.searchKeys, which is important for
-	// matching.
-	sk.searchKeys = sk.searchKeys[:n]
-	var bodySearch, textSearch *store.WordSearch
-	if len(bodyWords) > 0 || len(bodyNotWords) > 0 {
-		ws := store.PrepareWordSearch(bodyWords, bodyNotWords)
-		bodySearch = &ws
-	}
-	if len(textWords) > 0 || len(textNotWords) > 0 {
-		ws := store.PrepareWordSearch(textWords, textNotWords)
-		textSearch = &ws
-	}
-
 	// Note: we only hold the account rlock for verifying the mailbox at the start.
 	c.account.RLock()
 	runlock := c.account.RUnlock
@@ -379,31 +329,7 @@ func (c *conn) cmdxSearch(isUID, isE bool, tag, cmd string, p *parser) {
 				msgCount = c.exists
 			}
 
-			// Used for interpreting UID sets with a star, like "1:*" and "10:*". Only called
-			// for UIDs that are higher than the number, since "10:*" evaluates to "10:5" if 5
-			// is the highest UID, and UID 5-10 would all match.
-			var cachedHighestUID store.UID
-			xhighestUID := func() store.UID {
-				if cachedHighestUID > 0 {
-					return cachedHighestUID
-				}
-
-				q := bstore.QueryTx[store.Message](tx)
-				q.FilterNonzero(store.Message{MailboxID: mb.ID})
-				q.FilterEqual("Expunged", false)
-				if mb.ID == c.mailboxID {
-					q.FilterLess("UID", c.uidnext)
-				}
-				q.SortDesc("UID")
-				q.Limit(1)
-				m, err := q.Get()
-				if err == bstore.ErrAbsent {
-					xuserErrorf("cannot use * on empty mailbox")
-				}
-				xcheckf(err, "get last uid")
-				cachedHighestUID = m.UID
-				return cachedHighestUID
-			}
+			xhighestUID := c.xhighestUIDFunc(tx, mb.ID)
 
 			progressOrig := progress
 
@@ -596,6 +522,92 @@ func (c *conn) cmdxSearch(isUID, isE bool, tag, cmd string, p *parser) {
 	c.ok(tag, cmd)
 }
 
+// xsearchProgram parses the search keys that make up the remainder of a SEARCH,
+// SORT or THREAD command. Top-level word searches are gathered into WordSearches
+// for more efficient matching.
+func (c *conn) xsearchProgram(p *parser) (sk *searchKey, bodySearch, textSearch *store.WordSearch) {
+	sk = &searchKey{
+		searchKeys: []searchKey{*p.xsearchKey()},
+	}
+	for !p.empty() {
+		p.xspace()
+		sk.searchKeys = append(sk.searchKeys, *p.xsearchKey())
+	}
+
+	// Sequence set search program must be rejected with UIDONLY enabled. ../rfc/9586:220
+	if c.uidonly && sk.hasSequenceNumbers() {
+		xsyntaxCodeErrorf("UIDREQUIRED", "cannot search message sequence numbers in search program with uidonly enabled")
+	}
+
+	// We gather word and not-word searches from the top-level, turn them
+	// into a WordSearch for a more efficient search.
+	// todo optimize: also gather them out of AND searches.
+	var textWords, textNotWords, bodyWords, bodyNotWords []string
+	n := 0
+	for _, xsk := range sk.searchKeys {
+		switch xsk.op {
+		case "BODY":
+			bodyWords = append(bodyWords, xsk.astring)
+			continue
+		case "TEXT":
+			textWords = append(textWords, xsk.astring)
+			continue
+		case "NOT":
+			switch xsk.searchKey.op {
+			case "BODY":
+				bodyNotWords = append(bodyNotWords, xsk.searchKey.astring)
+				continue
+			case "TEXT":
+				textNotWords = append(textNotWords, xsk.searchKey.astring)
+				continue
+			}
+		}
+		sk.searchKeys[n] = xsk
+		n++
+	}
+	// We may be left with an empty but non-nil sk.searchKeys, which is important for
+	// matching.
+	sk.searchKeys = sk.searchKeys[:n]
+	if len(bodyWords) > 0 || len(bodyNotWords) > 0 {
+		ws := store.PrepareWordSearch(bodyWords, bodyNotWords)
+		bodySearch = &ws
+	}
+	if len(textWords) > 0 || len(textNotWords) > 0 {
+		ws := store.PrepareWordSearch(textWords, textNotWords)
+		textSearch = &ws
+	}
+	return
+}
+
+// xhighestUIDFunc returns a function that returns the highest UID in a mailbox,
+// used for interpreting UID sets with a star, like "1:*" and "10:*". Only called
+// for UIDs that are higher than the number, since "10:*" evaluates to "10:5" if 5
+// is the highest UID, and UID 5-10 would all match.
+func (c *conn) xhighestUIDFunc(tx *bstore.Tx, mailboxID int64) func() store.UID {
+	var cachedHighestUID store.UID
+	return func() store.UID {
+		if cachedHighestUID > 0 {
+			return cachedHighestUID
+		}
+
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MailboxID: mailboxID})
+		q.FilterEqual("Expunged", false)
+		if mailboxID == c.mailboxID {
+			q.FilterLess("UID", c.uidnext)
+		}
+		q.SortDesc("UID")
+		q.Limit(1)
+		m, err := q.Get()
+		if err == bstore.ErrAbsent {
+			xuserErrorf("cannot use * on empty mailbox")
+		}
+		xcheckf(err, "get last uid")
+		cachedHighestUID = m.UID
+		return cachedHighestUID
+	}
+}
+
 type search struct {
 	c           *conn
 	tx          *bstore.Tx
@@ -20,7 +20,7 @@ non-ASCII UTF-8. Until that's enabled, we do use UTF-7 for mailbox names. See
 - Do not write output on a connection with an account lock held. Writing can block, a slow client could block account operations.
 - When handling commands that modify the selected mailbox, always check that the mailbox is not opened readonly. And always revalidate the selected mailbox, another session may have deleted the mailbox.
 - After making changes to an account/mailbox/message, you must broadcast changes. You must do this with the account lock held. Otherwise, other later changes (e.g. message deliveries) may be made and broadcast before changes that were made earlier. Make sure to commit changes in the database first, because the commit may fail.
-- Mailbox hierarchies are slash separated, no leading slash. We keep the case, except INBOX is renamed to Inbox, also for submailboxes in INBOX. We don't allow existence of a child where its parent does not exist. We have no \NoInferiors or \NoSelect. Newly created mailboxes are automatically subscribed.
+- Mailbox hierarchies are slash separated, no leading slash. We keep the case, except INBOX is renamed to Inbox, also for submailboxes in INBOX. We don't allow existence of a child where its parent does not exist. We have no \NoInferiors, and \NoSelect only for the levels above shared mailboxes of other accounts. Newly created mailboxes are automatically subscribed.
 - For CONDSTORE and QRESYNC support, we set "modseq" for each change/expunge. Once expunged, a modseq doesn't change anymore. We don't yet remove old expunged records. The records aren't too big. Next step may be to let an admin reclaim space manually.
 */
 
@@ -75,8 +75,11 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 )
 
@@ -186,6 +189,13 @@ var serverCapabilitiesList = []string{
 	"MULTISEARCH",                     // ../rfc/7377:187
 	"NOTIFY",                          // ../rfc/5465:195
 	"UIDONLY",                         // ../rfc/9586:127
+	"SORT",                            // ../rfc/5256
+	"SORT=DISPLAY",                    // ../rfc/5957
+	"ESORT",                           // ../rfc/5267
+	"THREAD=ORDEREDSUBJECT",           // ../rfc/5256
+	"THREAD=REFERENCES",               //
+	"ACL",                             // ../rfc/4314
+	"RIGHTS=texk",                     //
 	// "COMPRESS=DEFLATE", // ../rfc/4978, disabled for interoperability issues: The flate reader (inflate) still blocks on partial flushes, preventing progress.
 }
 var serverCapabilities = strings.Join(serverCapabilitiesList, " ")
@@ -252,6 +262,17 @@ type conn struct {
 	uidnext   store.UID   // We don't return search/fetch/etc results for uids >= uidnext, which is updated when applying changes.
 	exists    uint32      // Needed for uidonly, equal to len(uids) for non-uidonly sessions.
 	uids      []store.UID // UIDs known in this session, sorted. todo future: store more space-efficiently, as ranges.
+
+	// Set when a mailbox of another account is selected. While executing commands
+	// for the selected mailbox, account and comm are set to sharedAccount and
+	// sharedComm. For other commands, they are set to homeAccount and homeComm, the
+	// account of the user.
+	homeAccount   *store.Account
+	homeComm      *store.Comm
+	sharedAccount *store.Account
+	sharedComm    *store.Comm
+	sharedRights  string // Rights of the user on the selected shared mailbox.
+	sharedPrefix  string // "Other Users/<account>/", for mailbox names in responses.
 }
 
 // capability for use with ENABLED and CAPABILITY. We always keep this upper case,
@@ -292,13 +313,18 @@ func stateCommands(cmds ...string) map[string]struct{} {
 var (
 	commandsStateAny              = stateCommands("capability", "noop", "logout", "id")
 	commandsStateNotAuthenticated = stateCommands("starttls", "authenticate", "login")
-	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub", "getquotaroot", "getquota", "getmetadata", "setmetadata", "compress", "esearch", "notify")
-	commandsStateSelected         = stateCommands("close", "unselect", "expunge", "search", "fetch", "store", "copy", "move", "uid expunge", "uid search", "uid fetch", "uid store", "uid copy", "uid move", "replace", "uid replace", "esearch")
+	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub", "getquotaroot", "getquota", "getmetadata", "setmetadata", "compress", "esearch", "notify", "setacl", "deleteacl", "getacl", "listrights", "myrights")
+	commandsStateSelected         = stateCommands("close", "unselect", "expunge", "search", "fetch", "store", "copy", "move", "uid expunge", "uid search", "uid fetch", "uid store", "uid copy", "uid move", "replace", "uid replace", "esearch", "sort", "uid sort", "thread", "uid thread")
 )
 
 // Commands that use sequence numbers. Cannot be used when UIDONLY is enabled.
 // Commands like UID SEARCH have additional checks for some parameters.
-var commandsSequence = stateCommands("search", "fetch", "store", "copy", "move", "replace")
+var commandsSequence = stateCommands("search", "fetch", "store", "copy", "move", "replace", "sort", "thread")
+
+// Commands executed with the account of the selected mailbox when it is shared by
+// another account. Other commands are executed with the account of the user. See
+// acl.go.
+var commandsShared = stateCommands("check", "close", "unselect", "expunge", "uid expunge", "search", "uid search", "fetch", "uid fetch", "store", "uid store", "copy", "uid copy", "move", "uid move", "replace", "uid replace", "sort", "uid sort", "thread", "uid thread", "idle", "noop")
 
 var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	// Any state.
@@ -334,6 +360,11 @@ var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	"compress":     (*conn).cmdCompress,
 	"esearch":      (*conn).cmdEsearch,
 	"notify":       (*conn).cmdNotify, // Connection does not have to be in selected state. ../rfc/5465:792 ../rfc/5465:921
+	"setacl":       (*conn).cmdSetacl,
+	"deleteacl":    (*conn).cmdDeleteacl,
+	"getacl":       (*conn).cmdGetacl,
+	"listrights":   (*conn).cmdListrights,
+	"myrights":     (*conn).cmdMyrights,
 
 	// Selected.
 	"check":       (*conn).cmdCheck,
@@ -343,6 +374,10 @@ var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	"uid expunge": (*conn).cmdUIDExpunge,
 	"search":      (*conn).cmdSearch,
 	"uid search":  (*conn).cmdUIDSearch,
+	"sort":        (*conn).cmdSort,
+	"uid sort":    (*conn).cmdUIDSort,
+	"thread":      (*conn).cmdThread,
+	"uid thread":  (*conn).cmdUIDThread,
 	"fetch":       (*conn).cmdFetch,
 	"uid fetch":   (*conn).cmdUIDFetch,
 	"store":       (*conn).cmdStore,
@@ -512,6 +547,7 @@ func (c *conn) unselect() {
 	if c.state == stateSelected {
 		c.state = stateAuthenticated
 	}
+	c.sharedRelease()
 	c.mailboxID = 0
 	c.uidnext = 0
 	c.exists = 0
@@ -678,7 +714,7 @@ func (c *conn) xwriteresultf(format string, args ...any) {
 // write buffered tagged command response, but first write pending changes.
 func (c *conn) xbwriteresultf(format string, args ...any) {
 	switch c.cmd {
-	case "fetch", "store", "search":
+	case "fetch", "store", "search", "sort", "thread":
 		// ../rfc/9051:5862 ../rfc/7162:2033
 	case "select", "examine":
 		// We don't send changes before having confirmed opening the mailbox, to prevent
@@ -848,6 +884,7 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		// their message removals so the files can be erased.
 		c.flushNotifyDelayed()
 
+		c.sharedRelease()
 		if c.account != nil {
 			c.comm.Unregister()
 			err := c.account.Close()
@@ -1322,6 +1359,13 @@ func (c *conn) command() {
 				c.xapplyChanges(overflow, changes, false)
 				c.xflush()
 
+			case <-c.homePending():
+				c.xhome(func() {
+					overflow, changes := c.comm.Get()
+					c.xapplyChanges(overflow, changes, false)
+				})
+				c.xflush()
+
 			case <-mox.Shutdown.Done():
 				// ../rfc/9051:5375
 				c.xwritelinef("* BYE shutting down")
@@ -1374,6 +1418,11 @@ func (c *conn) command() {
 		xsyntaxCodeErrorf("UIDREQUIRED", "cannot use message sequence numbers with uidonly")
 	}
 
+	if _, ok := commandsShared[cmdlow]; !ok && c.homeAccount != nil {
+		c.xhome(func() { fn(c, tag, cmd, p) })
+		return
+	}
+	c.sharedRefresh()
 	fn(c, tag, cmd, p)
 }
 
@@ -1802,6 +1851,12 @@ func (c *conn) xapplyChanges(overflow bool, changes []store.Change, sendDelayed
 		changes = nil
 	}
 
+	// With a mailbox of another account selected, we only pass on changes for that
+	// mailbox. Mailbox IDs can clash with those of the account of the user.
+	if c.inShared() {
+		changes = c.sharedChanges(changes)
+	}
+
 	// applyChanges for IDLE and NOTIFY. When explicitly in IDLE while NOTIFY is
 	// enabled, we still respond with messages as for NOTIFY. ../rfc/5465:406
 	if c.notify != nil {
@@ -1853,7 +1908,9 @@ func (c *conn) xapplyChanges(overflow bool, changes []store.Change, sendDelayed
 		default:
 			panic(fmt.Errorf("missing case for %#v", change))
 		}
-		if c.state == stateSelected && mbID == c.mailboxID {
+		// mailboxID is zero while executing commands for the user with a shared mailbox
+		// selected, see xhome.
+		if c.state == stateSelected && c.mailboxID != 0 && mbID == c.mailboxID {
 			n = append(n, change)
 		}
 	}
@@ -2417,6 +2474,9 @@ func (c *conn) capabilities() string {
 	if c.tls && len(c.conn.(*tls.Conn).ConnectionState().PeerCertificates) > 0 && !c.viaHTTPS && !c.noTLSClientAuth {
 		caps += " AUTH=EXTERNAL"
 	}
+	if (c.tls || c.noRequireSTARTTLS) && oidc.Configured() != nil {
+		caps += " AUTH=OAUTHBEARER AUTH=XOAUTH2" // ../rfc/7628
+	}
 	return caps
 }
 
@@ -2884,6 +2944,69 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+	case "OAUTHBEARER", "XOAUTH2":
+		c.loginAttempt.AuthMech = strings.ToLower(authType)
+
+		provider := oidc.Configured()
+		if provider == nil {
+			xuserErrorf("method not supported")
+		}
+		if !c.noRequireSTARTTLS && !c.tls {
+			// ../rfc/9051:5194
+			xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
+		}
+
+		// Tokens are credentials, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		var authz, token string
+		var err error
+		if c.loginAttempt.AuthMech == "oauthbearer" {
+			authz, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			authz, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			c.loginAttempt.Result = store.AuthBadProtocol
+			xsyntaxErrorf("%s", err)
+		}
+
+		// On failure, the server sends a challenge with an error, and the client responds
+		// with a dummy message, before authentication fails. ../rfc/7628
+		xfailf := func(format string, args ...any) {
+			c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(sasl.OAuthErrorChallenge)))
+			xreadContinuation()
+			xusercodeErrorf("AUTHENTICATIONFAILED", format, args...)
+		}
+
+		username, err = provider.VerifyAccessToken(context.TODO(), c.log, token)
+		if errors.Is(err, oidc.ErrToken) {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Infox("failed authentication attempt with token", err, slog.Any("remote", c.remoteIP))
+			xfailf("bad token")
+		} else if err != nil {
+			c.log.Errorx("verifying token", err)
+			xusercodeErrorf("UNAVAILABLE", "temporary error verifying token")
+		}
+		c.loginAttempt.LoginAddress = username
+
+		if authz != "" {
+			if addr, err := smtp.ParseAddress(norm.NFC.String(authz)); err != nil || addr.String() != username {
+				xusercodeErrorf("AUTHORIZATIONFAILED", "cannot assume role")
+			}
+		}
+
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt with token for unknown address", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xfailf("bad token")
+			}
+			xserverErrorf("looking up address: %v", err)
+		}
+
 	case "EXTERNAL":
 		c.loginAttempt.AuthMech = "external"
 
@@ -3257,6 +3380,15 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 
 	name = xcheckmailboxname(name, true)
 
+	if account, mbname, ok := sharedName(name); ok {
+		name = c.xselectShared(account, mbname)
+		defer func() {
+			if c.state != stateSelected {
+				c.sharedRelease()
+			}
+		}()
+	}
+
 	var mb store.Mailbox
 	c.account.WithRLock(func() {
 		c.xdbread(func(tx *bstore.Tx) {
@@ -3302,7 +3434,7 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 			}
 			c.xbwritelinef(`* OK [UIDVALIDITY %d] x`, mb.UIDValidity)
 			c.xbwritelinef(`* OK [UIDNEXT %d] x`, mb.UIDNext)
-			c.xbwritelinef(`* LIST () "/" %s`, mailboxt(mb.Name).pack(c))
+			c.xbwritelinef(`* LIST () "/" %s`, mailboxt(c.sharedPrefix+mb.Name).pack(c))
 			if c.enabled[capCondstore] {
 				// ../rfc/7162:417
 				// ../rfc/7162-eid5055 ../rfc/7162:484 ../rfc/7162:1167
@@ -3481,7 +3613,8 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 		})
 	})
 
-	if isselect {
+	// With a shared mailbox, the user needs rights to make changes. ../rfc/4314
+	if isselect && (c.sharedComm == nil || strings.ContainsAny(c.sharedRights, "stwe")) {
 		c.xbwriteresultf("%s OK [READ-WRITE] x", tag)
 		c.readonly = false
 	} else {
@@ -3530,6 +3663,7 @@ func (c *conn) cmdCreate(tag, cmd string, p *parser) {
 	origName := name
 	name = strings.TrimRight(name, "/") // ../rfc/9051:1930
 	name = xcheckmailboxname(name, false)
+	xcheckNotShared(name)
 
 	var specialUse store.SpecialUse
 	specialUseBools := map[string]*bool{
@@ -3635,6 +3769,7 @@ func (c *conn) cmdRename(tag, cmd string, p *parser) {
 
 	src = xcheckmailboxname(src, true)
 	dst = xcheckmailboxname(dst, false)
+	xcheckNotShared(dst)
 
 	var cleanupIDs []int64
 	defer func() {
@@ -3878,7 +4013,8 @@ func (c *conn) cmdNamespace(tag, cmd string, p *parser) {
 	p.xempty()
 
 	// Response syntax: ../rfc/9051:6778 ../rfc/2342:415
-	c.xbwritelinef(`* NAMESPACE (("" "/")) NIL NIL`)
+	// Mailboxes shared by other accounts are in a separate namespace. ../rfc/4314
+	c.xbwritelinef(`* NAMESPACE (("" "/")) (("%s/" "/")) NIL`, sharedNamespace)
 	c.ok(tag, cmd)
 }
 
@@ -3904,6 +4040,14 @@ func (c *conn) cmdStatus(tag, cmd string, p *parser) {
 	p.xempty()
 
 	name = xcheckmailboxname(name, true)
+	respName := name
+
+	// Status on a shared mailbox requires the read right. ../rfc/4314
+	name, restore, err := c.sharedSwitch(name, "r")
+	if err != nil {
+		panic(err)
+	}
+	defer restore()
 
 	var mb store.Mailbox
 
@@ -3911,6 +4055,7 @@ func (c *conn) cmdStatus(tag, cmd string, p *parser) {
 	c.account.WithRLock(func() {
 		c.xdbread(func(tx *bstore.Tx) {
 			mb = c.xmailbox(tx, name, "")
+			mb.Name = respName
 			responseLine = c.xstatusLine(tx, mb, attrs)
 		})
 	})
@@ -4008,6 +4153,17 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 
 	var appends []*appendMsg
 	var commit bool
+
+	// Request syntax: ../rfc/9051:6325 ../rfc/6855:219 ../rfc/3501:4547 ../rfc/3502:218
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+
+	// Appending to a shared mailbox is done with the account of the mailbox, and
+	// requires the insert right. An error is returned after reading the messages.
+	name, restore, sharedErr := c.sharedSwitch(name, "i")
+	defer restore()
+
 	defer func() {
 		for _, a := range appends {
 			if !commit && a.m.ID != 0 {
@@ -4018,11 +4174,6 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		}
 	}()
 
-	// Request syntax: ../rfc/9051:6325 ../rfc/6855:219 ../rfc/3501:4547 ../rfc/3502:218
-	p.xspace()
-	name := p.xmailbox()
-	p.xspace()
-
 	// Check how much quota space is available. We'll keep track of remaining quota as
 	// we accept multiple messages.
 	quotaMsgMax := c.account.QuotaMessageSize()
@@ -4086,6 +4237,9 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		if synclit {
 			// Check for mailbox on first iteration.
 			if len(appends) <= 1 {
+				if sharedErr != nil {
+					panic(sharedErr)
+				}
 				name = xcheckmailboxname(name, true)
 				c.xdbread(func(tx *bstore.Tx) {
 					c.xmailbox(tx, name, "TRYCREATE")
@@ -4113,7 +4267,7 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		} else {
 			// We'll discard the message and return an error as soon as we can (possible
 			// synchronizing literal of next message, or after we've seen all messages).
-			if overQuota || cancel {
+			if overQuota || cancel || sharedErr != nil {
 				f = io.Discard
 			} else {
 				var err error
@@ -4150,6 +4304,9 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 	}
 	p.xempty()
 
+	if sharedErr != nil {
+		panic(sharedErr)
+	}
 	name = xcheckmailboxname(name, true)
 
 	if overQuota {
@@ -4433,7 +4590,7 @@ func (c *conn) cmdClose(tag, cmd string, p *parser) {
 	// Request syntax: ../rfc/9051:6476 ../rfc/3501:4679
 	p.xempty()
 
-	if !c.readonly {
+	if !c.readonly && c.sharedHasRight('e') {
 		c.xexpunge(nil, true)
 	}
 	c.unselect()
@@ -4534,6 +4691,7 @@ func (c *conn) cmdExpunge(tag, cmd string, p *parser) {
 	if c.readonly {
 		xuserErrorf("mailbox open in read-only mode")
 	}
+	c.xsharedNeed("e")
 
 	c.cmdxExpunge(tag, cmd, nil)
 }
@@ -4553,6 +4711,7 @@ func (c *conn) cmdUIDExpunge(tag, cmd string, p *parser) {
 	if c.readonly {
 		xuserErrorf("mailbox open in read-only mode")
 	}
+	c.xsharedNeed("e")
 
 	c.cmdxExpunge(tag, cmd, &uidSet)
 }
@@ -4683,6 +4842,16 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 
 	name = xcheckmailboxname(name, true)
 
+	// Copying to a mailbox in another account, e.g. from a shared mailbox to a mailbox
+	// of the user, is done by adding the messages as new messages.
+	dstAcc, name, done := c.xdestination(name)
+	defer done()
+	if dstAcc != nil {
+		uids, mbDst, newUIDs := c.xcopyAccounts(isUID, nums, dstAcc, name)
+		c.xwriteresultf("%s OK [COPYUID %d %s %s] copied", tag, mbDst.UIDValidity, compactUIDSet(uids).String(), compactUIDSet(newUIDs).String())
+		return
+	}
+
 	// Files that were created during the copy. Remove them if the operation fails.
 	var newIDs []int64
 	defer func() {
@@ -4897,6 +5066,19 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 	if c.readonly {
 		xuserErrorf("mailbox open in read-only mode")
 	}
+	// Moving from a shared mailbox requires rights to delete and expunge. ../rfc/4314
+	c.xsharedNeed("te")
+
+	// Moving to a mailbox in another account is done by copying and expunging.
+	dstAcc, name, done := c.xdestination(name)
+	defer done()
+	if dstAcc != nil {
+		uids, mbDst, newUIDs := c.xcopyAccounts(isUID, nums, dstAcc, name)
+		expunged, modseq := c.xexpungeUIDs(uids)
+		copyUID := fmt.Sprintf("%d %s %s", mbDst.UIDValidity, compactUIDSet(uids).String(), compactUIDSet(newUIDs).String())
+		c.xwriteMoveResult(tag, cmd, copyUID, expunged, modseq)
+		return
+	}
 
 	// UIDs to move.
 	var uids []store.UID
@@ -4954,10 +5136,17 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 		c.broadcast(changes)
 	})
 
+	newUIDs := numSet{ranges: []numRange{{setNumber{number: uint32(uidFirst)}, &setNumber{number: uint32(mbDst.UIDNext - 1)}}}}
+	copyUID := fmt.Sprintf("%d %s %s", mbDst.UIDValidity, compactUIDSet(uids).String(), newUIDs.String())
+	c.xwriteMoveResult(tag, cmd, copyUID, uids, modseq)
+}
+
+// xwriteMoveResult writes the COPYUID response code, expunges or vanished
+// responses for the moved messages, and the result.
+func (c *conn) xwriteMoveResult(tag, cmd string, copyUID string, uids []store.UID, modseq store.ModSeq) {
 	// ../rfc/9051:4708 ../rfc/6851:254
 	// ../rfc/9051:4713
-	newUIDs := numSet{ranges: []numRange{{setNumber{number: uint32(uidFirst)}, &setNumber{number: uint32(mbDst.UIDNext - 1)}}}}
-	c.xbwritelinef("* OK [COPYUID %d %s %s] moved", mbDst.UIDValidity, compactUIDSet(uids).String(), newUIDs.String())
+	c.xbwritelinef("* OK [COPYUID %s] moved", copyUID)
 	qresync := c.enabled[capQresync]
 	var vanishedUIDs numSet
 	for i := range uids {
@@ -5195,6 +5384,21 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 		mask = store.FlagsAll
 	}
 
+	// Changing \Seen and \Deleted need separate rights on shared mailboxes. ../rfc/4314
+	if c.inShared() {
+		if mask.Seen {
+			c.xsharedNeed("s")
+		}
+		if mask.Deleted {
+			c.xsharedNeed("t")
+		}
+		other := mask
+		other.Seen, other.Deleted = false, false
+		if other != (store.Flags{}) || !plus && !minus || len(keywords) > 0 {
+			c.xsharedNeed("w")
+		}
+	}
+
 	var mb, origmb store.Mailbox
 	var updated []store.Message
 	var changed []store.Message // ModSeq more recent than unchangedSince, will be in MODIFIED response code, and we will send untagged fetch responses so client is up to date.
@@ -0,0 +1,530 @@
+package imapserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/store"
+)
+
+// SORT and THREAD operate on the selected mailbox only. Both evaluate a search
+// program like SEARCH does, and order or arrange the matching messages. THREAD
+// does not thread messages itself, it uses the threading information (ThreadID,
+// ThreadParentIDs) that is stored with each message when it is added, which is
+// also used by the webmail client.
+//
+// ../rfc/5256 ../rfc/5267 ../rfc/5957
+
+// State: Selected
+func (c *conn) cmdSort(tag, cmd string, p *parser) {
+	c.cmdxSort(false, tag, cmd, p)
+}
+
+// State: Selected
+func (c *conn) cmdUIDSort(tag, cmd string, p *parser) {
+	c.cmdxSort(true, tag, cmd, p)
+}
+
+// State: Selected
+func (c *conn) cmdThread(tag, cmd string, p *parser) {
+	c.cmdxThread(false, tag, cmd, p)
+}
+
+// State: Selected
+func (c *conn) cmdUIDThread(tag, cmd string, p *parser) {
+	c.cmdxThread(true, tag, cmd, p)
+}
+
+// xsearchCharset parses the charset that is required for SORT and THREAD.
+func xsearchCharset(p *parser) {
+	// Like SEARCH, we allow US-ASCII and UTF-8 (which is required). ../rfc/5256
+	charset := strings.ToUpper(p.xastring())
+	if charset != "US-ASCII" && charset != "UTF-8" {
+		xusercodeErrorf("BADCHARSET", "only US-ASCII and UTF-8 supported")
+	}
+}
+
+// xsearchSelected evaluates the search program on the messages in the selected
+// mailbox the session knows about, calling fn for each match in UID order.
+func (c *conn) xsearchSelected(tag string, sk *searchKey, bodySearch, textSearch *store.WordSearch, fn func(m store.Message)) {
+	// Note: we only hold the account rlock for verifying the mailbox at the start.
+	c.account.RLock()
+	runlock := c.account.RUnlock
+	// Note: in a defer because we replace it below.
+	defer func() {
+		runlock()
+	}()
+
+	// Like SEARCH, we periodically let the client know we are still working.
+	inProgressLast := time.Now()
+	inProgressTag := "nil"
+	if !strings.Contains(tag, "]") {
+		inProgressTag = dquote(tag).pack(c)
+	}
+
+	c.xdbread(func(tx *bstore.Tx) {
+		mb := c.xmailboxID(tx, c.mailboxID) // Validate.
+
+		runlock()
+		runlock = func() {}
+
+		msgCount := uint32(mb.MailboxCounts.Total + mb.MailboxCounts.Deleted)
+		if !c.uidonly {
+			msgCount = c.exists
+		}
+		goal := "nil"
+		if msgCount > 0 {
+			goal = fmt.Sprintf("%d", msgCount)
+		}
+		xhighestUID := c.xhighestUIDFunc(tx, mb.ID)
+
+		var progress uint32
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MailboxID: mb.ID})
+		q.FilterEqual("Expunged", false)
+		q.FilterLess("UID", c.uidnext)
+		q.SortAsc("UID")
+		for m, err := range q.All() {
+			xcheckf(err, "list messages in mailbox")
+
+			if time.Since(inProgressLast) > inProgressPeriod {
+				c.xwritelinef("* OK [INPROGRESS (%s %d %s)] still searching", inProgressTag, progress, goal)
+				inProgressLast = time.Now()
+			}
+			progress++
+
+			// Sequence number is set by searchMatch for messages in the selected mailbox.
+			if c.searchMatch(tx, msgCount, 0, m, *sk, bodySearch, textSearch, xhighestUID) {
+				fn(m)
+			}
+		}
+	})
+}
+
+// sortMsg holds the values needed for sorting and threading a message, so we
+// don't have to keep the full messages in memory.
+type sortMsg struct {
+	ID              int64
+	UID             store.UID
+	ModSeq          store.ModSeq
+	Size            int64
+	Received        time.Time
+	Sent            time.Time // From Date header, or received time if absent. ../rfc/5256
+	SubjectBase     string
+	ThreadID        int64
+	ThreadParentIDs []int64
+
+	// Lower-cased, for case-insensitive comparison.
+	From, To, CC           string // Localpart of first address. ../rfc/5256
+	DisplayFrom, DisplayTo string // Display name of first address, or the address. ../rfc/5957
+}
+
+func (c *conn) makeSortMsg(m store.Message) sortMsg {
+	sm := sortMsg{
+		ID:              m.ID,
+		UID:             m.UID,
+		ModSeq:          m.ModSeq,
+		Size:            m.Size,
+		Received:        m.Received,
+		Sent:            m.Received,
+		SubjectBase:     m.SubjectBase,
+		ThreadID:        m.ThreadID,
+		ThreadParentIDs: m.ThreadParentIDs,
+	}
+
+	// We only need the envelope from the parsed form, not the message file.
+	var partialPart struct {
+		Envelope *message.Envelope
+	}
+	if m.ParsedBuf == nil {
+		c.log.Debug("missing parsed message for sort, using empty values", slog.Any("uid", m.UID), slog.Int64("msgid", m.ID))
+		return sm
+	} else if err := json.Unmarshal(m.ParsedBuf, &partialPart); err != nil {
+		c.log.Debugx("unmarshal parsed message for sort, using empty values", err, slog.Any("uid", m.UID), slog.Int64("msgid", m.ID))
+		return sm
+	}
+	env := partialPart.Envelope
+	if env == nil {
+		return sm
+	}
+	if !env.Date.IsZero() {
+		sm.Sent = env.Date
+	}
+	mailbox := func(l []message.Address) string {
+		if len(l) == 0 {
+			return ""
+		}
+		return strings.ToLower(l[0].User)
+	}
+	display := func(l []message.Address) string {
+		if len(l) == 0 {
+			return ""
+		}
+		if l[0].Name != "" {
+			return strings.ToLower(l[0].Name)
+		}
+		return strings.ToLower(l[0].User + "@" + l[0].Host)
+	}
+	sm.From = mailbox(env.From)
+	sm.To = mailbox(env.To)
+	sm.CC = mailbox(env.CC)
+	sm.DisplayFrom = display(env.From)
+	sm.DisplayTo = display(env.To)
+	return sm
+}
+
+type sortCriterion struct {
+	Reverse bool
+	Key     string // ARRIVAL, CC, DATE, FROM, SIZE, SUBJECT, TO, DISPLAYFROM, DISPLAYTO.
+}
+
+func (sc sortCriterion) compare(a, b sortMsg) int {
+	var r int
+	switch sc.Key {
+	case "ARRIVAL":
+		r = a.Received.Compare(b.Received)
+	case "CC":
+		r = cmp.Compare(a.CC, b.CC)
+	case "DATE":
+		r = a.Sent.Compare(b.Sent)
+	case "FROM":
+		r = cmp.Compare(a.From, b.From)
+	case "SIZE":
+		r = cmp.Compare(a.Size, b.Size)
+	case "SUBJECT":
+		r = cmp.Compare(a.SubjectBase, b.SubjectBase)
+	case "TO":
+		r = cmp.Compare(a.To, b.To)
+	case "DISPLAYFROM":
+		r = cmp.Compare(a.DisplayFrom, b.DisplayFrom)
+	case "DISPLAYTO":
+		r = cmp.Compare(a.DisplayTo, b.DisplayTo)
+	default:
+		panic("missing case")
+	}
+	if sc.Reverse {
+		return -r
+	}
+	return r
+}
+
+// Sort returns messages matching criteria specified in parameters, in the order
+// of the sort criteria.
+//
+// State: Selected
+func (c *conn) cmdxSort(isUID bool, tag, cmd string, p *parser) {
+	// Command and syntax: ../rfc/5256 ../rfc/5267 ../rfc/5957
+
+	// With ESORT, RETURN options result in an ESEARCH response. ../rfc/5267
+	var eargs map[string]bool // Options except SAVE. Nil means regular SORT response.
+	var save bool
+
+	p.xspace()
+	if p.take("RETURN (") {
+		eargs = map[string]bool{}
+
+		for !p.take(")") {
+			if len(eargs) > 0 || save {
+				p.xspace()
+			}
+			if w, ok := p.takelist("MIN", "MAX", "ALL", "COUNT", "SAVE"); ok {
+				if w == "SAVE" {
+					save = true
+				} else {
+					eargs[w] = true
+				}
+			} else {
+				xsyntaxErrorf("ESORT result option %q not supported", w)
+			}
+		}
+		if len(eargs) == 0 && !save {
+			eargs["ALL"] = true
+		}
+		p.xspace()
+	}
+
+	p.xtake("(")
+	var criteria []sortCriterion
+	for {
+		var sc sortCriterion
+		sc.Reverse = p.take("REVERSE ")
+		// DISPLAY* first, we match on prefix.
+		sc.Key = p.xtakelist("DISPLAYFROM", "DISPLAYTO", "ARRIVAL", "CC", "DATE", "FROM", "SIZE", "SUBJECT", "TO")
+		criteria = append(criteria, sc)
+		if !p.take(" ") {
+			break
+		}
+	}
+	p.xtake(")")
+	p.xspace()
+	xsearchCharset(p)
+	p.xspace()
+	sk, bodySearch, textSearch := c.xsearchProgram(p)
+
+	// Even in case of error, we ensure search result is changed.
+	if save {
+		c.searchResult = []store.UID{}
+	}
+
+	var msgs []sortMsg
+	var maxModSeq store.ModSeq
+	c.xsearchSelected(tag, sk, bodySearch, textSearch, func(m store.Message) {
+		msgs = append(msgs, c.makeSortMsg(m))
+		maxModSeq = max(maxModSeq, m.ModSeq)
+	})
+
+	// Messages are in UID order, which is also message sequence order, the final tie
+	// breaker. ../rfc/5256
+	slices.SortStableFunc(msgs, func(a, b sortMsg) int {
+		for _, sc := range criteria {
+			if r := sc.compare(a, b); r != 0 {
+				return r
+			}
+		}
+		return 0
+	})
+
+	// NOTE: we are potentially converting UIDs to msgseq, but keep the store.UID type
+	// for convenience.
+	nums := make([]store.UID, len(msgs))
+	for i, sm := range msgs {
+		if isUID {
+			nums[i] = sm.UID
+		} else {
+			nums[i] = store.UID(c.xsequence(sm.UID))
+		}
+	}
+
+	if eargs == nil {
+		// ../rfc/5256
+		var b strings.Builder
+		b.WriteString("* SORT")
+		for _, v := range nums {
+			fmt.Fprintf(&b, " %d", v)
+		}
+		// ../rfc/7162:1101
+		if sk.hasModseq() && len(nums) > 0 {
+			fmt.Fprintf(&b, " (MODSEQ %d)", maxModSeq.Client())
+		}
+		c.xbwritelinef("%s", b.String())
+	} else {
+		if save {
+			// Saved result is a set, it does not keep the sort order. ../rfc/5267
+			uids := make([]store.UID, len(msgs))
+			for i, sm := range msgs {
+				uids[i] = sm.UID
+			}
+			slices.Sort(uids)
+			c.searchResult = uids
+			c.checkUIDs(c.searchResult, false)
+		}
+
+		if len(eargs) > 0 {
+			fmt.Fprintf(c.xbw, `* ESEARCH (TAG "%s")`, tag)
+			if isUID {
+				fmt.Fprintf(c.xbw, " UID")
+			}
+			// MIN and MAX are the first and last message in sort order, ALL is in sort order.
+			// ../rfc/5267
+			if eargs["MIN"] && len(nums) > 0 {
+				fmt.Fprintf(c.xbw, " MIN %d", nums[0])
+			}
+			if eargs["MAX"] && len(nums) > 0 {
+				fmt.Fprintf(c.xbw, " MAX %d", nums[len(nums)-1])
+			}
+			if eargs["COUNT"] {
+				fmt.Fprintf(c.xbw, " COUNT %d", len(nums))
+			}
+			if eargs["ALL"] && len(nums) > 0 {
+				fmt.Fprintf(c.xbw, " ALL %s", compactUIDSet(nums).String())
+			}
+			if sk.hasModseq() && len(nums) > 0 {
+				fmt.Fprintf(c.xbw, " MODSEQ %d", maxModSeq.Client())
+			}
+			c.xbwritelinef("")
+		}
+	}
+
+	c.ok(tag, cmd)
+}
+
+// threadNode is a message in a thread. For REFERENCES, a thread root can be a
+// placeholder (with nil msg) for messages in the same thread whose common ancestor
+// did not match or is not in the mailbox.
+type threadNode struct {
+	msg      *sortMsg
+	children []*threadNode
+}
+
+// sent returns the date for sorting the node. For placeholders, it is the date of
+// the first child. Children must already be sorted.
+func (n *threadNode) sent() time.Time {
+	if n.msg == nil {
+		return n.children[0].sent()
+	}
+	return n.msg.Sent
+}
+
+// sortThreads sorts the nodes and their children by sent date. Nodes are in
+// message sequence order, the tie breaker. ../rfc/5256
+func sortThreads(l []*threadNode) {
+	for _, n := range l {
+		sortThreads(n.children)
+	}
+	slices.SortStableFunc(l, func(a, b *threadNode) int {
+		return a.sent().Compare(b.sent())
+	})
+}
+
+// threadReferences arranges messages in threads, using the thread ids and parent
+// ids stored with the messages. A message is a child of its closest ancestor that
+// is in msgs. ../rfc/5256
+func threadReferences(msgs []sortMsg) []*threadNode {
+	nodes := map[int64]*threadNode{}
+	for i := range msgs {
+		nodes[msgs[i].ID] = &threadNode{msg: &msgs[i]}
+	}
+
+	// Messages without ancestor in msgs, per thread.
+	var threadIDs []int64
+	tops := map[int64][]*threadNode{}
+	for _, sm := range msgs {
+		n := nodes[sm.ID]
+		var parent *threadNode
+		for _, pid := range sm.ThreadParentIDs {
+			if parent = nodes[pid]; parent != nil {
+				break
+			}
+		}
+		if parent != nil {
+			parent.children = append(parent.children, n)
+			continue
+		}
+		if _, ok := tops[sm.ThreadID]; !ok {
+			threadIDs = append(threadIDs, sm.ThreadID)
+		}
+		tops[sm.ThreadID] = append(tops[sm.ThreadID], n)
+	}
+
+	var roots []*threadNode
+	for _, tid := range threadIDs {
+		l := tops[tid]
+		if len(l) == 1 {
+			roots = append(roots, l[0])
+		} else {
+			// Siblings without parent are kept together under a placeholder. ../rfc/5256
+			roots = append(roots, &threadNode{children: l})
+		}
+	}
+	sortThreads(roots)
+	return roots
+}
+
+// threadOrderedSubject groups messages by base subject. The first message by
+// sent date is the parent of the other messages. ../rfc/5256
+func threadOrderedSubject(msgs []sortMsg) []*threadNode {
+	var roots []*threadNode
+	subjects := map[string]*threadNode{}
+	for i, sm := range msgs {
+		n := &threadNode{msg: &msgs[i]}
+		if r, ok := subjects[sm.SubjectBase]; ok {
+			r.children = append(r.children, n)
+		} else {
+			subjects[sm.SubjectBase] = &threadNode{children: []*threadNode{n}}
+			roots = append(roots, subjects[sm.SubjectBase])
+		}
+	}
+	for i, r := range roots {
+		slices.SortStableFunc(r.children, func(a, b *threadNode) int {
+			return a.msg.Sent.Compare(b.msg.Sent)
+		})
+		// First message becomes the parent of the others.
+		first := r.children[0]
+		first.children = r.children[1:]
+		roots[i] = first
+	}
+	slices.SortStableFunc(roots, func(a, b *threadNode) int {
+		return a.msg.Sent.Compare(b.msg.Sent)
+	})
+	return roots
+}
+
+// writeThread writes the members of a thread-list, without the outer parenthesis.
+// A message with a single child is followed by that child, with multiple children
+// each child is a parenthesized list. ../rfc/5256
+func writeThread(b *strings.Builder, n *threadNode, num func(sm *sortMsg) uint32) {
+	if n.msg != nil {
+		fmt.Fprintf(b, "%d", num(n.msg))
+		if len(n.children) == 0 {
+			return
+		}
+		b.WriteByte(' ')
+		if len(n.children) == 1 {
+			writeThread(b, n.children[0], num)
+			return
+		}
+	}
+	for _, ch := range n.children {
+		b.WriteByte('(')
+		writeThread(b, ch, num)
+		b.WriteByte(')')
+	}
+}
+
+// Thread returns messages matching criteria specified in parameters, arranged in
+// threads.
+//
+// State: Selected
+func (c *conn) cmdxThread(isUID bool, tag, cmd string, p *parser) {
+	// Command and syntax: ../rfc/5256
+
+	p.xspace()
+	algorithm := p.xtakelist("REFERENCES", "ORDEREDSUBJECT")
+	p.xspace()
+	xsearchCharset(p)
+	p.xspace()
+	sk, bodySearch, textSearch := c.xsearchProgram(p)
+
+	var msgs []sortMsg
+	c.xsearchSelected(tag, sk, bodySearch, textSearch, func(m store.Message) {
+		msgs = append(msgs, c.makeSortMsg(m))
+	})
+
+	var roots []*threadNode
+	switch algorithm {
+	case "REFERENCES":
+		roots = threadReferences(msgs)
+	case "ORDEREDSUBJECT":
+		roots = threadOrderedSubject(msgs)
+	default:
+		panic("missing case")
+	}
+
+	num := func(sm *sortMsg) uint32 {
+		if isUID {
+			return uint32(sm.UID)
+		}
+		return uint32(c.xsequence(sm.UID))
+	}
+	var b strings.Builder
+	b.WriteString("* THREAD")
+	if len(roots) > 0 {
+		b.WriteByte(' ')
+	}
+	for _, r := range roots {
+		b.WriteByte('(')
+		writeThread(&b, r, num)
+		b.WriteByte(')')
+	}
+	c.xbwritelinef("%s", b.String())
+
+	c.ok(tag, cmd)
+}
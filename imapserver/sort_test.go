@@ -0,0 +1,186 @@
+package imapserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+// sortTestMsg returns a message for sort and thread tests. Headers are only added
+// when non-empty.
+func sortTestMsg(date, from, to, cc, subject, msgID, inReplyTo, references string) string {
+	s := "Date: " + date + "\r\n"
+	for _, h := range [][2]string{{"From", from}, {"To", to}, {"Cc", cc}, {"Subject", subject}, {"Message-Id", msgID}, {"In-Reply-To", inReplyTo}, {"References", references}} {
+		if h[1] != "" {
+			s += fmt.Sprintf("%s: %s\r\n", h[0], h[1])
+		}
+	}
+	return s + "\r\nbody\r\n"
+}
+
+func addSortMsgs(tc *testconn) {
+	msgs := []string{
+		sortTestMsg("Mon, 3 Jan 2022 10:00:00 +0100", `"Zed" <a@mox.example>`, "<c@mox.example>", "", "hello", "<1@mox.example>", "", ""),
+		sortTestMsg("Sat, 1 Jan 2022 10:00:00 +0100", "<b@mox.example>", `"Alice" <z@mox.example>`, "<q@mox.example>", "Re: hello", "<2@mox.example>", "<1@mox.example>", "<1@mox.example>"),
+		sortTestMsg("Sun, 2 Jan 2022 10:00:00 +0100", `"Bob" <c@mox.example>`, "<a@mox.example>", "", "other", "<3@mox.example>", "", ""),
+		sortTestMsg("Tue, 4 Jan 2022 10:00:00 +0100", "<d@mox.example>", "", "", "Re: hello", "<4@mox.example>", "<2@mox.example>", "<1@mox.example> <2@mox.example>"),
+		sortTestMsg("Wed, 5 Jan 2022 10:00:00 +0100", "<e@mox.example>", "", "", "Re: hello", "<5@mox.example>", "<1@mox.example>", "<1@mox.example>"),
+	}
+	// Received times are in reverse order of UIDs.
+	received := time.Date(2022, time.February, 10, 0, 0, 0, 0, time.UTC)
+	for i, msg := range msgs {
+		tc.client.Append("inbox", makeAppendTime(msg, received.Add(-time.Duration(i)*time.Hour)))
+	}
+}
+
+func TestSort(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+	tc.login("mjl@mox.example", password0)
+	tc.client.Select("inbox")
+	addSortMsgs(tc)
+
+	// Dates: 2, 3, 1, 4, 5.
+	tc.transactf("ok", "sort (date) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 1, 4, 5})
+
+	tc.transactf("ok", "sort (reverse date) us-ascii all")
+	tc.xuntagged(imapclient.UntaggedSort{5, 4, 1, 3, 2})
+
+	tc.transactf("ok", "sort (arrival) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{5, 4, 3, 2, 1})
+
+	tc.transactf("ok", "sort (from) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{1, 2, 3, 4, 5})
+
+	// Display name, or address if absent: zed, b@, bob, d@, e@.
+	tc.transactf("ok", "sort (displayfrom) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 4, 5, 1})
+
+	// Missing addresses sort first, ties are in sequence order.
+	tc.transactf("ok", "sort (to) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{4, 5, 3, 1, 2})
+
+	tc.transactf("ok", "sort (displayto) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{4, 5, 3, 2, 1})
+
+	tc.transactf("ok", "sort (cc) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{1, 3, 4, 5, 2})
+
+	// Base subject ignores "Re:".
+	tc.transactf("ok", "sort (subject date) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 1, 4, 5, 3})
+
+	tc.transactf("ok", "uid sort (date) utf-8 subject hello")
+	tc.xuntagged(imapclient.UntaggedSort{2, 1, 4, 5})
+
+	tc.transactf("ok", "sort (date) utf-8 subject nomatch")
+	tc.xuntagged(imapclient.UntaggedSort(nil))
+
+	// ESORT, with MIN/MAX in sort order, and ALL in sort order.
+	tc.transactf("ok", "sort return (min max count) (date) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{Min: 2, Max: 5, Count: uint32ptr(5)})
+
+	tc.transactf("ok", "uid sort return (all) (reverse date) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{UID: true, All: esearchall0("5,4,1,3,2")})
+
+	tc.transactf("ok", "uid sort return () (date) utf-8 from a@mox.example")
+	tc.xesearch(imapclient.UntaggedEsearch{UID: true, All: esearchall0("1")})
+
+	// SAVE stores the result as set, for use with $.
+	tc.transactf("ok", "uid sort return (save) (reverse date) utf-8 subject hello")
+	tc.xnountagged()
+	tc.transactf("ok", "uid search uid $")
+	tc.xsearch(1, 2, 4, 5)
+
+	tc.transactf("no", "sort (date) iso-8859-1 all")
+	tc.xcode(imapclient.CodeBadCharset(nil))
+	tc.transactf("bad", "sort (bogus) utf-8 all")
+	tc.transactf("bad", "sort () utf-8 all")
+	tc.transactf("bad", "sort (date) utf-8")
+	tc.transactf("bad", "sort return (partial 1:10) (date) utf-8 all")
+
+	tc.client.Unselect()
+	tc.transactf("no", "sort (date) utf-8 all")
+}
+
+func TestThread(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+	tc.login("mjl@mox.example", password0)
+	tc.client.Select("inbox")
+
+	tc.transactf("ok", "thread references utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread(nil))
+
+	addSortMsgs(tc)
+
+	// Message 1 has replies 2 and 5, and 4 is a reply to 2. Message 3 is on its own,
+	// with an earlier date than 1.
+	tc.transactf("ok", "thread references utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{
+		{Num: 3},
+		{Num: 1, Children: []imapclient.ThreadNode{
+			{Num: 2, Children: []imapclient.ThreadNode{{Num: 4}}},
+			{Num: 5},
+		}},
+	})
+
+	// Without message 2, its reply becomes a child of 1.
+	tc.transactf("ok", "uid thread references utf-8 not from b@mox.example")
+	tc.xuntagged(imapclient.UntaggedThread{
+		{Num: 3},
+		{Num: 1, Children: []imapclient.ThreadNode{{Num: 4}, {Num: 5}}},
+	})
+
+	// Without message 1, its replies are kept together under a placeholder, which
+	// sorts by its earliest child.
+	tc.transactf("ok", "thread references utf-8 not from a@mox.example")
+	tc.xuntagged(imapclient.UntaggedThread{
+		{Children: []imapclient.ThreadNode{
+			{Num: 2, Children: []imapclient.ThreadNode{{Num: 4}}},
+			{Num: 5},
+		}},
+		{Num: 3},
+	})
+
+	// Grouped by base subject, the earliest message is the parent.
+	tc.transactf("ok", "thread orderedsubject utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{
+		{Num: 2, Children: []imapclient.ThreadNode{{Num: 1}, {Num: 4}, {Num: 5}}},
+		{Num: 3},
+	})
+
+	tc.transactf("ok", "uid thread orderedsubject utf-8 subject other")
+	tc.xuntagged(imapclient.UntaggedThread{{Num: 3}})
+
+	tc.transactf("bad", "thread bogus utf-8 all")
+	tc.transactf("no", "thread references iso-8859-1 all")
+}
+
+func TestSortThreadUIDOnly(t *testing.T) {
+	tc := start(t, true)
+	defer tc.close()
+	tc.login("mjl@mox.example", password0)
+	tc.client.Select("inbox")
+	addSortMsgs(tc)
+
+	tc.transactf("bad", "sort (date) utf-8 all")
+	tc.xcode(imapclient.CodeWord("UIDREQUIRED"))
+	tc.transactf("bad", "thread references utf-8 all")
+	tc.xcode(imapclient.CodeWord("UIDREQUIRED"))
+
+	tc.transactf("ok", "uid sort (date) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 1, 4, 5})
+
+	tc.transactf("ok", "uid thread references utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{
+		{Num: 3},
+		{Num: 1, Children: []imapclient.ThreadNode{
+			{Num: 2, Children: []imapclient.ThreadNode{{Num: 4}}},
+			{Num: 5},
+		}},
+	})
+}
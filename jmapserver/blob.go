@@ -0,0 +1,240 @@
+package jmapserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/store"
+)
+
+// Blobs are raw messages ("b" followed by message id), parts of messages ("b",
+// message id, "-" and the part id with dots replaced by underscores) or uploaded
+// files ("u" followed by a random id). Uploads are stored in the account
+// directory, and removed after a day. ../rfc/8620:2840
+
+// Uploads are kept for at least a day. ../rfc/8620:2885
+const uploadExpiration = 24 * time.Hour
+
+func uploadDir(acc *store.Account) string {
+	return filepath.Join(acc.Dir, "jmapupload")
+}
+
+// cleanupUploads removes expired uploads.
+func cleanupUploads(log mlog.Log, acc *store.Account) {
+	dir := uploadDir(acc)
+	l, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Errorx("listing jmap uploads for cleanup", err)
+		}
+		return
+	}
+	for _, e := range l {
+		fi, err := e.Info()
+		if err != nil || time.Since(fi.ModTime()) < uploadExpiration {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		err = os.Remove(p)
+		log.Check(err, "removing expired jmap upload", slog.String("path", p))
+	}
+}
+
+// uploadPath returns the path for an upload blob id, or false if the id is
+// invalid.
+func uploadPath(acc *store.Account, blobID string) (string, bool) {
+	if len(blobID) < 2 || blobID[0] != 'u' || strings.ContainsAny(blobID, "/\\.") {
+		return "", false
+	}
+	return filepath.Join(uploadDir(acc), blobID), true
+}
+
+// serveUpload stores the request body as a new blob. ../rfc/8620:2863
+func (s server) serveUpload(log mlog.Log, w http.ResponseWriter, r *http.Request, acc *store.Account) {
+	accountID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upload/"), "/")
+	if accountID != acc.Name {
+		writeProblem(w, http.StatusNotFound, "about:blank", "", "unknown account")
+		return
+	}
+
+	cleanupUploads(log, acc)
+
+	dir := uploadDir(acc)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		log.Errorx("creating jmap upload directory", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("read random: %v", err))
+	}
+	blobID := "u" + base64.RawURLEncoding.EncodeToString(buf)
+	p := filepath.Join(dir, blobID)
+	f, err := os.Create(p)
+	if err != nil {
+		log.Errorx("creating jmap upload file", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, http.MaxBytesReader(w, r.Body, s.maxMsgSize))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		xerr := os.Remove(p)
+		log.Check(xerr, "removing partial upload", slog.String("path", p))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, "urn:ietf:params:jmap:error:limit", "maxSizeUpload", "upload too large")
+			return
+		}
+		log.Debugx("storing upload", err)
+		http.Error(w, "400 - bad request - reading upload", http.StatusBadRequest)
+		return
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(log, w, map[string]any{
+		"accountId": acc.Name,
+		"blobId":    blobID,
+		"type":      ct,
+		"size":      n,
+	})
+}
+
+// findPart returns the leaf part with partID (IMAP section numbering).
+func findPart(p *message.Part, partID string) (*message.Part, bool) {
+	if p.MediaType != "MULTIPART" {
+		return p, partID == "1"
+	}
+	for _, s := range strings.Split(partID, ".") {
+		i, err := strconv.Atoi(s)
+		if err != nil || p.MediaType != "MULTIPART" || i < 1 || i > len(p.Parts) {
+			return nil, false
+		}
+		p = &p.Parts[i-1]
+	}
+	return p, p.MediaType != "MULTIPART"
+}
+
+// errBlobNotFound is returned for unknown blobs.
+var errBlobNotFound = errors.New("blob not found")
+
+// openBlob returns a reader for the blob. For parts of messages, the decoded
+// content is returned. Must be called with the account read lock held.
+func openBlob(tx *bstore.Tx, acc *store.Account, blobID string) (io.ReadCloser, error) {
+	if strings.HasPrefix(blobID, "u") {
+		p, ok := uploadPath(acc, blobID)
+		if !ok {
+			return nil, errBlobNotFound
+		}
+		f, err := os.Open(p)
+		if err != nil && errors.Is(err, os.ErrNotExist) {
+			return nil, errBlobNotFound
+		}
+		return f, err
+	}
+
+	if !strings.HasPrefix(blobID, "b") {
+		return nil, errBlobNotFound
+	}
+	idStr, partID, isPart := strings.Cut(blobID[1:], "-")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, errBlobNotFound
+	}
+	m := store.Message{ID: id}
+	err = tx.Get(&m)
+	if err == bstore.ErrAbsent || err == nil && m.Expunged {
+		return nil, errBlobNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("get message: %v", err)
+	}
+	msgr := acc.MessageReader(m)
+	if !isPart {
+		return msgr, nil
+	}
+	p, err := m.LoadPart(msgr)
+	if err != nil {
+		msgr.Close()
+		return nil, fmt.Errorf("load parsed message: %v", err)
+	}
+	pp, ok := findPart(&p, strings.ReplaceAll(partID, "_", "."))
+	if !ok {
+		msgr.Close()
+		return nil, errBlobNotFound
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{pp.Reader(), msgr}, nil
+}
+
+// serveDownload serves a blob. ../rfc/8620:2940
+func (s server) serveDownload(log mlog.Log, w http.ResponseWriter, r *http.Request, acc *store.Account) {
+	t := strings.Split(strings.TrimPrefix(r.URL.Path, "/download/"), "/")
+	if len(t) != 3 || t[0] != acc.Name {
+		http.NotFound(w, r)
+		return
+	}
+	blobID, name := t[1], t[2]
+
+	var rc io.ReadCloser
+	var err error
+	acc.WithRLock(func() {
+		err = acc.DB.Read(r.Context(), func(tx *bstore.Tx) error {
+			rc, err = openBlob(tx, acc, blobID)
+			return err
+		})
+	})
+	if err == errBlobNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Errorx("opening blob for download", err, slog.String("blobid", blobID))
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		err := rc.Close()
+		log.Check(err, "closing blob")
+	}()
+
+	ct := r.URL.Query().Get("accept")
+	if ct == "" || strings.ContainsAny(ct, "\r\n") {
+		ct = "application/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", ct)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	// Blobs are immutable. ../rfc/8620:2990
+	h.Set("Cache-Control", "private, immutable, max-age=31536000")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+	_, err = io.Copy(w, rc)
+	log.Check(err, "writing blob")
+}
@@ -0,0 +1,930 @@
+package jmapserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/store"
+)
+
+// Email properties, excluding header:* properties. ../rfc/8621:1000
+var emailProperties = []string{
+	"id",
+	"blobId",
+	"threadId",
+	"mailboxIds",
+	"keywords",
+	"size",
+	"receivedAt",
+	"messageId",
+	"inReplyTo",
+	"references",
+	"sender",
+	"from",
+	"to",
+	"cc",
+	"bcc",
+	"replyTo",
+	"subject",
+	"sentAt",
+	"hasAttachment",
+	"preview",
+	"bodyStructure",
+	"bodyValues",
+	"textBody",
+	"htmlBody",
+	"attachments",
+	"headers",
+}
+
+// Properties returned by Email/get if properties is null. ../rfc/8621:1913
+var emailDefaultProperties = []string{
+	"id",
+	"blobId",
+	"threadId",
+	"mailboxIds",
+	"keywords",
+	"size",
+	"receivedAt",
+	"messageId",
+	"inReplyTo",
+	"references",
+	"sender",
+	"from",
+	"to",
+	"cc",
+	"bcc",
+	"replyTo",
+	"subject",
+	"sentAt",
+	"hasAttachment",
+	"preview",
+	"bodyValues",
+	"textBody",
+	"htmlBody",
+	"attachments",
+}
+
+// EmailBodyPart properties, excluding header:* properties. ../rfc/8621:1460
+var bodyPartProperties = []string{
+	"partId",
+	"blobId",
+	"size",
+	"headers",
+	"name",
+	"type",
+	"charset",
+	"disposition",
+	"cid",
+	"language",
+	"location",
+	"subParts",
+}
+
+// Default bodyProperties for Email/get. ../rfc/8621:1940
+var bodyPartDefaultProperties = []string{
+	"partId",
+	"blobId",
+	"size",
+	"name",
+	"type",
+	"charset",
+	"disposition",
+	"cid",
+	"language",
+	"location",
+}
+
+// Keywords for the system flags and well-known flags. JMAP keywords are
+// case-insensitive, and returned in lower case. \Deleted is not exposed, messages
+// with that flag are not visible. ../rfc/8621:838
+func emailKeywords(m store.Message) map[string]bool {
+	r := map[string]bool{}
+	fields := []struct {
+		keyword string
+		have    bool
+	}{
+		{"$seen", m.Seen},
+		{"$answered", m.Answered},
+		{"$flagged", m.Flagged},
+		{"$draft", m.Draft},
+		{"$forwarded", m.Forwarded},
+		{"$junk", m.Junk},
+		{"$notjunk", m.Notjunk},
+		{"$phishing", m.Phishing},
+		{"$mdnsent", m.MDNSent},
+	}
+	for _, f := range fields {
+		if f.have {
+			r[f.keyword] = true
+		}
+	}
+	for _, kw := range m.Keywords {
+		r[kw] = true
+	}
+	return r
+}
+
+// xparseKeyword returns the lower case keyword, and a pointer to the flag in flags
+// if it is a system or well-known flag.
+func xparseKeyword(flags *store.Flags, kw string) (string, *bool) {
+	kw = strings.ToLower(kw)
+	switch kw {
+	case "$seen":
+		return kw, &flags.Seen
+	case "$answered":
+		return kw, &flags.Answered
+	case "$flagged":
+		return kw, &flags.Flagged
+	case "$draft":
+		return kw, &flags.Draft
+	case "$forwarded":
+		return kw, &flags.Forwarded
+	case "$junk":
+		return kw, &flags.Junk
+	case "$notjunk":
+		return kw, &flags.Notjunk
+	case "$phishing":
+		return kw, &flags.Phishing
+	case "$mdnsent":
+		return kw, &flags.MDNSent
+	}
+	if err := store.CheckKeyword(kw); err != nil || len(kw) > 255 {
+		xinvalidPropertiesf("keywords", "invalid keyword %q", kw)
+	}
+	return kw, nil
+}
+
+// xkeywordsFlags parses the JMAP keywords into flags and other keywords. The
+// \Deleted flag is never set.
+func xkeywordsFlags(keywords map[string]bool) (store.Flags, []string) {
+	var flags store.Flags
+	var l []string
+	for k, v := range keywords {
+		if !v {
+			xinvalidPropertiesf("keywords", "keyword values must be true")
+		}
+		kw, fp := xparseKeyword(&flags, k)
+		if fp != nil {
+			*fp = true
+		} else if !slices.Contains(l, kw) {
+			l = append(l, kw)
+		}
+	}
+	slices.Sort(l)
+	return flags, l
+}
+
+// rawHeader is a header field with raw value as it appears in the message,
+// including folding whitespace. ../rfc/8621:1117
+type rawHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// parseRawHeaders parses the header section of a message or part.
+func parseRawHeaders(buf []byte) []rawHeader {
+	var l []rawHeader
+	for len(buf) > 0 && !bytes.HasPrefix(buf, []byte("\r\n")) && !bytes.HasPrefix(buf, []byte("\n")) {
+		// A field ends at a newline not followed by whitespace.
+		n := 0
+		for {
+			i := bytes.IndexByte(buf[n:], '\n')
+			if i < 0 {
+				n = len(buf)
+				break
+			}
+			n += i + 1
+			if n >= len(buf) || buf[n] != ' ' && buf[n] != '\t' {
+				break
+			}
+		}
+		line := buf[:n]
+		buf = buf[n:]
+		k := bytes.IndexByte(line, ':')
+		if k < 0 {
+			continue
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(line[k+1:]), "\n"), "\r")
+		l = append(l, rawHeader{strings.TrimRight(string(line[:k]), " \t"), value})
+	}
+	return l
+}
+
+// Decoder for RFC 2047 encoded-words, with support for non-utf-8 charsets.
+var wordDecoder = mime.WordDecoder{
+	CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "", "us-ascii", "utf-8":
+			return r, nil
+		}
+		return message.DecodeReader(charset, r), nil
+	},
+}
+
+// unfold removes the CRLF of folded header values, and trims surrounding
+// whitespace.
+func unfold(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "")
+	s = strings.ReplaceAll(s, "\n", "")
+	return strings.TrimSpace(s)
+}
+
+// headerText returns a header value in the Text form, unfolded and with
+// encoded-words decoded. ../rfc/8621:1160
+func headerText(raw string) string {
+	s := unfold(raw)
+	if v, err := wordDecoder.DecodeHeader(s); err == nil {
+		s = v
+	}
+	return s
+}
+
+// emailAddress is an EmailAddress object. ../rfc/8621:1191
+type emailAddress struct {
+	Name  *string `json:"name"`
+	Email string  `json:"email"`
+}
+
+// emailAddressGroup is an EmailAddressGroup object. ../rfc/8621:1237
+type emailAddressGroup struct {
+	Name      *string        `json:"name"`
+	Addresses []emailAddress `json:"addresses"`
+}
+
+// headerAddresses parses a header value in the Addresses form. Invalid values
+// result in an empty list.
+func headerAddresses(raw string) []emailAddress {
+	r := []emailAddress{}
+	s := unfold(raw)
+	if s == "" {
+		return r
+	}
+	l, err := message.ParseAddressList(s)
+	if err != nil {
+		return r
+	}
+	for _, a := range l {
+		r = append(r, jmapAddress(a))
+	}
+	return r
+}
+
+func jmapAddress(a message.Address) emailAddress {
+	ea := emailAddress{Email: a.User + "@" + a.Host}
+	if a.Name != "" {
+		name := a.Name
+		ea.Name = &name
+	}
+	return ea
+}
+
+// headerMessageIDs parses a header value in the MessageIds form, returning nil if
+// the value cannot be parsed. ../rfc/8621:1270
+func headerMessageIDs(raw string) []string {
+	var l []string
+	s := unfold(raw)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+		if !strings.HasPrefix(s, "<") {
+			return nil
+		}
+		id, rest, ok := strings.Cut(s[1:], ">")
+		if !ok || id == "" {
+			return nil
+		}
+		l = append(l, strings.ReplaceAll(id, " ", ""))
+		s = rest
+	}
+	return l
+}
+
+// headerDate parses a header value in the Date form, returning nil for invalid
+// dates. ../rfc/8621:1290
+func headerDate(raw string) *string {
+	t, err := mail.ParseDate(unfold(raw))
+	if err != nil {
+		return nil
+	}
+	s := t.Format("2006-01-02T15:04:05Z07:00")
+	return &s
+}
+
+// headerURLs parses a header value in the URLs form, as used in List-* headers,
+// returning nil if invalid. ../rfc/8621:1300
+func headerURLs(raw string) []string {
+	var l []string
+	for _, e := range strings.Split(unfold(raw), ",") {
+		e = strings.TrimSpace(e)
+		if !strings.HasPrefix(e, "<") || !strings.Contains(e, ">") {
+			continue
+		}
+		l = append(l, e[1:strings.Index(e, ">")])
+	}
+	return l
+}
+
+// headerForm returns the value for a header in a form.
+func headerForm(form, raw string) any {
+	switch form {
+	case "raw":
+		return raw
+	case "text":
+		return headerText(raw)
+	case "addresses":
+		return headerAddresses(raw)
+	case "groupedAddresses":
+		// We don't track groups, all addresses are returned in a single unnamed group.
+		return []emailAddressGroup{{nil, headerAddresses(raw)}}
+	case "messageIds":
+		return headerMessageIDs(raw)
+	case "date":
+		return headerDate(raw)
+	case "urls":
+		return headerURLs(raw)
+	}
+	panic("unknown header form " + form)
+}
+
+// headerProperty is a parsed "header:" property. ../rfc/8621:1310
+type headerProperty struct {
+	name string // Lower case.
+	form string // raw, text, addresses, groupedAddresses, messageIds, date, urls.
+	all  bool
+}
+
+// xparseHeaderProperty parses a "header:" property.
+func xparseHeaderProperty(prop string) headerProperty {
+	t := strings.Split(strings.TrimPrefix(prop, "header:"), ":")
+	hp := headerProperty{name: strings.ToLower(t[0]), form: "raw"}
+	if hp.name == "" {
+		xinvalidArgumentsf("missing header name in property %q", prop)
+	}
+	t = t[1:]
+	if len(t) > 0 && t[len(t)-1] == "all" {
+		hp.all = true
+		t = t[:len(t)-1]
+	}
+	if len(t) == 1 && strings.HasPrefix(t[0], "as") {
+		form := strings.TrimPrefix(t[0], "as")
+		form = strings.ToLower(form[:1]) + form[1:]
+		switch form {
+		case "raw", "text", "addresses", "groupedAddresses", "messageIds", "date", "urls":
+			hp.form = form
+			t = t[1:]
+		}
+	}
+	if len(t) > 0 {
+		xinvalidArgumentsf("invalid header property %q", prop)
+	}
+	return hp
+}
+
+// value returns the property value for the header fields.
+func (hp headerProperty) value(l []rawHeader) any {
+	var vl []any
+	for _, h := range l {
+		if strings.EqualFold(h.Name, hp.name) {
+			vl = append(vl, headerForm(hp.form, h.Value))
+		}
+	}
+	if hp.all {
+		if vl == nil {
+			return []any{}
+		}
+		return vl
+	}
+	if len(vl) == 0 {
+		return nil
+	}
+	return vl[len(vl)-1]
+}
+
+// bodyPart is a leaf or multipart part of a message, with the information needed
+// for the EmailBodyPart object.
+type bodyPart struct {
+	part        *message.Part
+	partID      string // Empty for multiparts.
+	blobID      string // Empty for multiparts.
+	typ         string // Lower case media type, e.g. text/plain.
+	name        string // Filename, can be empty.
+	disposition string // Lower case, can be empty.
+	subParts    []*bodyPart
+}
+
+// partBlobID returns the blob id for a part of a message.
+func partBlobID(msgID int64, partID string) string {
+	return fmt.Sprintf("b%d-%s", msgID, strings.ReplaceAll(partID, ".", "_"))
+}
+
+// makeBodyPart returns the body structure for p. Part ids are IMAP section
+// numbers, with a message that is not a multipart having part "1".
+func makeBodyPart(msgID int64, p *message.Part, partID string) *bodyPart {
+	bp := &bodyPart{part: p}
+	if p.MediaType == "" {
+		bp.typ = "text/plain"
+	} else {
+		bp.typ = strings.ToLower(p.MediaType + "/" + p.MediaSubType)
+	}
+	disp, name, _ := p.DispositionFilename()
+	bp.disposition = strings.ToLower(disp)
+	bp.name = name
+	if p.MediaType == "MULTIPART" {
+		for i := range p.Parts {
+			sub := fmt.Sprintf("%d", i+1)
+			if partID != "" {
+				sub = partID + "." + sub
+			}
+			bp.subParts = append(bp.subParts, makeBodyPart(msgID, &p.Parts[i], sub))
+		}
+		return bp
+	}
+	if partID == "" {
+		partID = "1"
+	}
+	bp.partID = partID
+	bp.blobID = partBlobID(msgID, partID)
+	return bp
+}
+
+func isInlineMediaType(typ string) bool {
+	return strings.HasPrefix(typ, "image/") || strings.HasPrefix(typ, "audio/") || strings.HasPrefix(typ, "video/")
+}
+
+// parseStructure finds the text and html bodies and attachments, following the
+// algorithm from ../rfc/8621:1580. Nil textBody or htmlBody pointers indicate the
+// body is no longer collected.
+func parseStructure(parts []*bodyPart, multipartType string, inAlternative bool, htmlBody, textBody, attachments *[]*bodyPart) {
+	textLength := -1
+	if textBody != nil {
+		textLength = len(*textBody)
+	}
+	htmlLength := -1
+	if htmlBody != nil {
+		htmlLength = len(*htmlBody)
+	}
+
+	for i, part := range parts {
+		isMultipart := strings.HasPrefix(part.typ, "multipart/")
+		isInline := part.disposition != "attachment" &&
+			(part.typ == "text/plain" || part.typ == "text/html" || isInlineMediaType(part.typ)) &&
+			(i == 0 || multipartType != "related" && (isInlineMediaType(part.typ) || part.name == ""))
+
+		if isMultipart {
+			subMultiType := strings.TrimPrefix(part.typ, "multipart/")
+			parseStructure(part.subParts, subMultiType, inAlternative || subMultiType == "alternative", htmlBody, textBody, attachments)
+		} else if isInline {
+			if multipartType == "alternative" {
+				switch part.typ {
+				case "text/plain":
+					*textBody = append(*textBody, part)
+				case "text/html":
+					*htmlBody = append(*htmlBody, part)
+				default:
+					*attachments = append(*attachments, part)
+				}
+				continue
+			} else if inAlternative {
+				if part.typ == "text/plain" {
+					htmlBody = nil
+				}
+				if part.typ == "text/html" {
+					textBody = nil
+				}
+			}
+			if textBody != nil {
+				*textBody = append(*textBody, part)
+			}
+			if htmlBody != nil {
+				*htmlBody = append(*htmlBody, part)
+			}
+			if (textBody == nil || htmlBody == nil) && isInlineMediaType(part.typ) {
+				*attachments = append(*attachments, part)
+			}
+		} else {
+			*attachments = append(*attachments, part)
+		}
+	}
+
+	if multipartType == "alternative" && textBody != nil && htmlBody != nil {
+		// Found html part only.
+		if textLength == len(*textBody) && htmlLength != len(*htmlBody) {
+			*textBody = append(*textBody, (*htmlBody)[htmlLength:]...)
+		}
+		// Found plain text part only.
+		if htmlLength == len(*htmlBody) && textLength != len(*textBody) {
+			*htmlBody = append(*htmlBody, (*textBody)[textLength:]...)
+		}
+	}
+}
+
+// bodyPartObject returns the EmailBodyPart object with the requested properties.
+func bodyPartObject(bp *bodyPart, props []string) map[string]any {
+	r := map[string]any{}
+	var headers []rawHeader
+	xheaders := func() []rawHeader {
+		if headers == nil {
+			buf, err := io.ReadAll(bp.part.HeaderReader())
+			xcheckf(err, "reading part header")
+			headers = parseRawHeaders(buf)
+		}
+		return headers
+	}
+	nilString := func(s *string) *string {
+		if s == nil || *s == "" {
+			return nil
+		}
+		return s
+	}
+	for _, prop := range props {
+		var v any
+		switch prop {
+		case "partId":
+			v = nilString(&bp.partID)
+		case "blobId":
+			v = nilString(&bp.blobID)
+		case "size":
+			if bp.partID != "" {
+				v = bp.part.DecodedSize
+			} else {
+				v = 0
+			}
+		case "headers":
+			v = xheaders()
+		case "name":
+			v = nilString(&bp.name)
+		case "type":
+			v = bp.typ
+		case "charset":
+			if cs, ok := bp.part.ContentTypeParams["charset"]; ok {
+				v = cs
+			} else if strings.HasPrefix(bp.typ, "text/") {
+				v = "us-ascii"
+			}
+		case "disposition":
+			v = nilString(&bp.disposition)
+		case "cid":
+			if bp.part.ContentID != nil {
+				s := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(*bp.part.ContentID), "<"), ">")
+				v = nilString(&s)
+			}
+		case "language":
+			if bp.part.ContentLanguage != nil {
+				var l []string
+				for _, s := range strings.Split(*bp.part.ContentLanguage, ",") {
+					if s = strings.TrimSpace(s); s != "" {
+						l = append(l, s)
+					}
+				}
+				v = l
+			}
+		case "location":
+			v = nilString(bp.part.ContentLocation)
+		case "subParts":
+			if bp.subParts != nil {
+				l := []map[string]any{}
+				for _, sub := range bp.subParts {
+					l = append(l, bodyPartObject(sub, props))
+				}
+				v = l
+			}
+		default:
+			v = xparseHeaderProperty(prop).value(xheaders())
+		}
+		r[prop] = v
+	}
+	return r
+}
+
+// emailBodyValue is an EmailBodyValue object. ../rfc/8621:1540
+type emailBodyValue struct {
+	Value             string `json:"value"`
+	IsEncodingProblem bool   `json:"isEncodingProblem"`
+	IsTruncated       bool   `json:"isTruncated"`
+}
+
+// readBodyValue reads the decoded text of a part, truncated to maxBytes if > 0.
+func readBodyValue(p *message.Part, maxBytes int) emailBodyValue {
+	var bv emailBodyValue
+	r := p.ReaderUTF8OrBinary()
+	if maxBytes > 0 {
+		r = io.LimitReader(r, int64(maxBytes)+1)
+	}
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		bv.IsEncodingProblem = true
+	}
+	if maxBytes > 0 && len(buf) > maxBytes {
+		buf = buf[:maxBytes]
+		// Don't cut a utf-8 sequence in half.
+		for i := 0; i < utf8.UTFMax && len(buf) > 0 && !utf8.Valid(buf); i++ {
+			buf = buf[:len(buf)-1]
+		}
+		bv.IsTruncated = true
+	}
+	if !utf8.Valid(buf) {
+		bv.IsEncodingProblem = true
+		buf = []byte(strings.ToValidUTF8(string(buf), "�"))
+	}
+	// JMAP body values have line endings normalized to LF. ../rfc/8621:1555
+	bv.Value = strings.ReplaceAll(string(buf), "\r\n", "\n")
+	return bv
+}
+
+// Email/get arguments. ../rfc/8621:1905
+type emailGetArgs struct {
+	getArgs
+	BodyProperties      []string `json:"bodyProperties"`
+	FetchTextBodyValues bool     `json:"fetchTextBodyValues"`
+	FetchHTMLBodyValues bool     `json:"fetchHTMLBodyValues"`
+	FetchAllBodyValues  bool     `json:"fetchAllBodyValues"`
+	MaxBodyValueBytes   int      `json:"maxBodyValueBytes"`
+}
+
+// emailData is a message for which an Email object is assembled. The parsed
+// structure and headers are loaded on demand.
+type emailData struct {
+	c    *call
+	m    store.Message
+	msgr *store.MsgReader
+
+	part      *message.Part
+	headers   []rawHeader
+	structure *bodyPart
+
+	textBody, htmlBody, attachments []*bodyPart
+}
+
+func (ed *emailData) close() {
+	if ed.msgr != nil {
+		err := ed.msgr.Close()
+		ed.c.log.Check(err, "closing message reader")
+		ed.msgr = nil
+	}
+}
+
+func (ed *emailData) xpart() *message.Part {
+	if ed.part == nil {
+		ed.msgr = ed.c.acc.MessageReader(ed.m)
+		p, err := ed.m.LoadPart(ed.msgr)
+		xcheckf(err, "load parsed message")
+		ed.part = &p
+	}
+	return ed.part
+}
+
+func (ed *emailData) xheaders() []rawHeader {
+	if ed.headers == nil {
+		buf, err := io.ReadAll(ed.xpart().HeaderReader())
+		xcheckf(err, "reading message header")
+		ed.headers = parseRawHeaders(buf)
+	}
+	return ed.headers
+}
+
+func (ed *emailData) xstructure() *bodyPart {
+	if ed.structure == nil {
+		ed.structure = makeBodyPart(ed.m.ID, ed.xpart(), "")
+		ed.textBody = []*bodyPart{}
+		ed.htmlBody = []*bodyPart{}
+		ed.attachments = []*bodyPart{}
+		parseStructure([]*bodyPart{ed.structure}, "mixed", false, &ed.htmlBody, &ed.textBody, &ed.attachments)
+	}
+	return ed.structure
+}
+
+// xheader returns the last header value for name in a form.
+func (ed *emailData) xheader(name, form string) any {
+	return headerProperty{name, form, false}.value(ed.xheaders())
+}
+
+// xpreview returns the preview, generating it if the message doesn't have one
+// yet.
+func (ed *emailData) xpreview() string {
+	var s string
+	if ed.m.Preview != nil {
+		s = *ed.m.Preview
+	} else {
+		s, _ = ed.xpart().Preview(ed.c.log)
+	}
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) > 256 {
+		s = string([]rune(s)[:256])
+	}
+	return s
+}
+
+// emailObject returns the Email object with the requested properties.
+func (c *call) xemailObject(ed *emailData, props []string, args emailGetArgs) map[string]any {
+	m := ed.m
+	r := map[string]any{}
+	bodyProps := args.BodyProperties
+	if bodyProps == nil {
+		bodyProps = bodyPartDefaultProperties
+	}
+	bodyParts := func(l []*bodyPart) []map[string]any {
+		r := []map[string]any{}
+		for _, bp := range l {
+			r = append(r, bodyPartObject(bp, bodyProps))
+		}
+		return r
+	}
+	for _, prop := range props {
+		var v any
+		switch prop {
+		case "id":
+			v = emailID(m.ID)
+		case "blobId":
+			v = fmt.Sprintf("b%d", m.ID)
+		case "threadId":
+			v = threadID(threadIDOf(m))
+		case "mailboxIds":
+			v = map[string]bool{mailboxID(m.MailboxID): true}
+		case "keywords":
+			v = emailKeywords(m)
+		case "size":
+			v = m.Size
+		case "receivedAt":
+			v = utcDate(m.Received)
+		case "messageId":
+			v = ed.xheader("message-id", "messageIds")
+		case "inReplyTo":
+			v = ed.xheader("in-reply-to", "messageIds")
+		case "references":
+			v = ed.xheader("references", "messageIds")
+		case "sender", "from", "to", "cc", "bcc", "replyTo":
+			name := prop
+			if prop == "replyTo" {
+				name = "reply-to"
+			}
+			if l := ed.xheader(name, "addresses"); l != nil {
+				v = l
+			}
+		case "subject":
+			v = ed.xheader("subject", "text")
+		case "sentAt":
+			v = ed.xheader("date", "date")
+		case "hasAttachment":
+			ed.xstructure()
+			v = len(ed.attachments) > 0
+		case "preview":
+			v = ed.xpreview()
+		case "bodyStructure":
+			v = bodyPartObject(ed.xstructure(), slices.Concat(bodyProps, []string{"subParts"}))
+		case "bodyValues":
+			ed.xstructure()
+			values := map[string]emailBodyValue{}
+			add := func(l []*bodyPart) {
+				for _, bp := range l {
+					if _, ok := values[bp.partID]; !ok && strings.HasPrefix(bp.typ, "text/") {
+						values[bp.partID] = readBodyValue(bp.part, args.MaxBodyValueBytes)
+					}
+				}
+			}
+			if args.FetchTextBodyValues || args.FetchAllBodyValues {
+				add(ed.textBody)
+			}
+			if args.FetchHTMLBodyValues || args.FetchAllBodyValues {
+				add(ed.htmlBody)
+			}
+			v = values
+		case "textBody":
+			ed.xstructure()
+			v = bodyParts(ed.textBody)
+		case "htmlBody":
+			ed.xstructure()
+			v = bodyParts(ed.htmlBody)
+		case "attachments":
+			ed.xstructure()
+			v = bodyParts(ed.attachments)
+		case "headers":
+			v = ed.xheaders()
+		default:
+			v = xparseHeaderProperty(prop).value(ed.xheaders())
+		}
+		r[prop] = v
+	}
+	return r
+}
+
+// xvisibleMessage returns the message for an email id, or false if it does not
+// exist or is not visible through JMAP.
+func xvisibleMessage(tx *bstore.Tx, id string) (store.Message, bool) {
+	m := store.Message{ID: parseID('e', id)}
+	if m.ID == 0 {
+		return m, false
+	}
+	err := tx.Get(&m)
+	if err == bstore.ErrAbsent || err == nil && (m.Expunged || m.Deleted) {
+		return m, false
+	}
+	xcheckf(err, "get message")
+	return m, true
+}
+
+func (c *call) emailGet(args emailGetArgs) getResult {
+	c.xaccount(args.AccountID)
+	known := slices.Clone(emailProperties)
+	for _, p := range args.Properties {
+		if strings.HasPrefix(p, "header:") {
+			xparseHeaderProperty(p)
+			known = append(known, p)
+		}
+	}
+	c.xcheckGet(args.getArgs, known)
+	for _, p := range args.BodyProperties {
+		if strings.HasPrefix(p, "header:") {
+			xparseHeaderProperty(p)
+		} else if !slices.Contains(bodyPartProperties, p) {
+			xinvalidArgumentsf("unknown body property %q", p)
+		}
+	}
+	if args.MaxBodyValueBytes < 0 {
+		xinvalidArgumentsf("maxBodyValueBytes must not be negative")
+	}
+	if args.IDs == nil {
+		xmethodErrorf("requestTooLarge", "ids must be specified")
+	}
+
+	props := args.Properties
+	if props == nil {
+		props = emailDefaultProperties
+	}
+	if !slices.Contains(props, "id") {
+		props = append([]string{"id"}, props...)
+	}
+
+	r := getResult{
+		AccountID: args.AccountID,
+		List:      []map[string]any{},
+		NotFound:  []string{},
+	}
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			r.State = modseqState(xlastModSeq(tx))
+
+			for _, id := range args.IDs {
+				m, ok := xvisibleMessage(tx, id)
+				if !ok {
+					r.NotFound = append(r.NotFound, id)
+					continue
+				}
+				ed := &emailData{c: c, m: m}
+				func() {
+					defer ed.close()
+					r.List = append(r.List, c.xemailObject(ed, props, args))
+				}()
+			}
+		})
+	})
+	return r
+}
+
+// Messages are visible if they are not expunged and don't have the \Deleted flag.
+// A message with a CreateSeq after the state is new, otherwise changed. Moved
+// messages keep their id, and get a new CreateSeq.
+func (c *call) emailChanges(args changesArgs) changesResult {
+	c.xaccount(args.AccountID)
+
+	var r changesResult
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			last := xlastModSeq(tx)
+			since := c.xsinceModSeq(tx, args.SinceState, last)
+
+			var l []objectChange
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterGreater("ModSeq", since)
+			err := q.ForEach(func(m store.Message) error {
+				oc := objectChange{id: emailID(m.ID), modseq: m.ModSeq}
+				if m.Expunged || m.Deleted {
+					if m.CreateSeq > since {
+						return nil
+					}
+					oc.kind = changeDestroyed
+				} else if m.CreateSeq > since {
+					oc.kind = changeCreated
+				} else {
+					oc.kind = changeUpdated
+				}
+				l = append(l, oc)
+				return nil
+			})
+			xcheckf(err, "listing changed messages")
+			r = c.xchangesResult(args, last, l)
+		})
+	})
+	return r
+}
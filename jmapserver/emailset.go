@@ -0,0 +1,951 @@
+package jmapserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webops"
+)
+
+// Shared operations on messages, with errors as method errors that abort the
+// transaction.
+var xops = webops.XOps{
+	DBWrite: xdbwrite,
+	Checkf: func(ctx context.Context, err error, format string, args ...any) {
+		xcheckf(err, format, args...)
+	},
+	Checkuserf: func(ctx context.Context, err error, format string, args ...any) {
+		if err != nil {
+			xinvalidArgumentsf("%s: %s", fmt.Sprintf(format, args...), err)
+		}
+	},
+}
+
+// emailBodyPartCreate is an EmailBodyPart in an Email/set create. Leaf parts
+// reference a body value with partId, or an uploaded blob or message part with
+// blobId. ../rfc/8621:2745
+type emailBodyPartCreate struct {
+	PartID      *string               `json:"partId"`
+	BlobID      *string               `json:"blobId"`
+	Type        *string               `json:"type"`
+	Charset     *string               `json:"charset"`
+	Disposition *string               `json:"disposition"`
+	Cid         *string               `json:"cid"`
+	Name        *string               `json:"name"`
+	Language    []string              `json:"language"`
+	Location    *string               `json:"location"`
+	SubParts    []emailBodyPartCreate `json:"subParts"`
+
+	empty bool // Empty text/plain body, for messages without body parts.
+}
+
+// emailBodyValueCreate is an EmailBodyValue in an Email/set create.
+type emailBodyValueCreate struct {
+	Value             string `json:"value"`
+	IsEncodingProblem bool   `json:"isEncodingProblem"`
+	IsTruncated       bool   `json:"isTruncated"`
+}
+
+// emailCreate is an Email object in an Email/set create. Header fields can also be
+// specified with "header:" properties, in raw or text form. ../rfc/8621:2680
+type emailCreate struct {
+	MailboxIDs    map[string]bool                 `json:"mailboxIds"`
+	Keywords      map[string]bool                 `json:"keywords"`
+	ReceivedAt    *string                         `json:"receivedAt"`
+	MessageID     []string                        `json:"messageId"`
+	InReplyTo     []string                        `json:"inReplyTo"`
+	References    []string                        `json:"references"`
+	Sender        []emailAddress                  `json:"sender"`
+	From          []emailAddress                  `json:"from"`
+	To            []emailAddress                  `json:"to"`
+	Cc            []emailAddress                  `json:"cc"`
+	Bcc           []emailAddress                  `json:"bcc"`
+	ReplyTo       []emailAddress                  `json:"replyTo"`
+	Subject       *string                         `json:"subject"`
+	SentAt        *string                         `json:"sentAt"`
+	BodyStructure *emailBodyPartCreate            `json:"bodyStructure"`
+	BodyValues    map[string]emailBodyValueCreate `json:"bodyValues"`
+	TextBody      []emailBodyPartCreate           `json:"textBody"`
+	HTMLBody      []emailBodyPartCreate           `json:"htmlBody"`
+	Attachments   []emailBodyPartCreate           `json:"attachments"`
+
+	headers []createHeader // From "header:" properties.
+}
+
+// Header fields written for the convenience properties, that cannot be set with
+// "header:" properties as well.
+var emailCreateHeaders = map[string]string{
+	"sender":      "sender",
+	"from":        "from",
+	"to":          "to",
+	"cc":          "cc",
+	"bcc":         "bcc",
+	"reply-to":    "replyTo",
+	"subject":     "subject",
+	"date":        "sentAt",
+	"message-id":  "messageId",
+	"in-reply-to": "inReplyTo",
+	"references":  "references",
+}
+
+// createHeader is a header field from a "header:" property of a new email.
+type createHeader struct {
+	name  string // As specified.
+	value string
+	text  bool // Text form, to be encoded when written. Otherwise raw.
+}
+
+// validRawHeaderValue returns whether a raw header value only has line endings
+// for folding: CRLF followed by whitespace.
+func validRawHeaderValue(s string) bool {
+	for i, c := range s {
+		switch c {
+		case '\r':
+			if !strings.HasPrefix(s[i:], "\r\n ") && !strings.HasPrefix(s[i:], "\r\n\t") {
+				return false
+			}
+		case '\n':
+			if i == 0 || s[i-1] != '\r' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// xparseEmailCreate parses an Email create object.
+func xparseEmailCreate(raw json.RawMessage) emailCreate {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		xsetErrorf("invalidProperties", "parsing email: %v", err)
+	}
+	var headers []createHeader
+	for k, v := range m {
+		if !strings.HasPrefix(k, "header:") {
+			continue
+		}
+		delete(m, k)
+		hp := func() headerProperty {
+			defer func() {
+				if x := recover(); x != nil {
+					if _, ok := x.(methodError); !ok {
+						panic(x)
+					}
+					xinvalidPropertiesf(k, "invalid header property")
+				}
+			}()
+			return xparseHeaderProperty(k)
+		}()
+		if hp.all || hp.form != "raw" && hp.form != "text" {
+			xinvalidPropertiesf(k, "only header properties in raw or text form can be set")
+		}
+		if strings.HasPrefix(hp.name, "content-") || hp.name == "mime-version" {
+			xinvalidPropertiesf(k, "header %q cannot be set", hp.name)
+		}
+		if prop, ok := emailCreateHeaders[hp.name]; ok {
+			if _, ok := m[prop]; ok {
+				xinvalidPropertiesf(k, "header also set through property %q", prop)
+			}
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			xinvalidPropertiesf(k, "header value must be a string")
+		}
+		if hp.form == "raw" && !validRawHeaderValue(s) {
+			xinvalidPropertiesf(k, "raw header value has invalid line endings")
+		} else if hp.form == "text" && strings.ContainsAny(s, "\r\n") {
+			xinvalidPropertiesf(k, "header value cannot contain newlines")
+		}
+		// Keep the case of the name as specified.
+		name := strings.Split(strings.TrimPrefix(k, "header:"), ":")[0]
+		headers = append(headers, createHeader{name, s, hp.form == "text"})
+	}
+	slices.SortStableFunc(headers, func(a, b createHeader) int {
+		return strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	})
+
+	for k := range m {
+		if slices.Contains([]string{"id", "blobId", "threadId", "size", "hasAttachment", "preview", "headers"}, k) {
+			xinvalidPropertiesf(k, "property %q cannot be set", k)
+		}
+	}
+	buf, err := json.Marshal(m)
+	xcheckf(err, "marshal email")
+	var ec emailCreate
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ec); err != nil {
+		xsetErrorf("invalidProperties", "parsing email: %v", err)
+	}
+	ec.headers = headers
+	return ec
+}
+
+func isASCII(s string) bool {
+	for _, c := range s {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// xnameAddresses parses addresses of a property for composing.
+func xnameAddresses(property string, l []emailAddress) []message.NameAddress {
+	var r []message.NameAddress
+	for _, a := range l {
+		addr, err := smtp.ParseAddress(a.Email)
+		if err != nil {
+			xinvalidPropertiesf(property, "parsing address %q: %v", a.Email, err)
+		}
+		var name string
+		if a.Name != nil {
+			name = *a.Name
+			if strings.ContainsAny(name, "\r\n") {
+				xinvalidPropertiesf(property, "name cannot contain newlines")
+			}
+		}
+		r = append(r, message.NameAddress{DisplayName: name, Address: addr})
+	}
+	return r
+}
+
+// xmessageIDsHeader returns the header value for message ids.
+func xmessageIDsHeader(property string, l []string) string {
+	var t []string
+	for _, id := range l {
+		if id == "" || strings.ContainsAny(id, "<> \t\r\n") {
+			xinvalidPropertiesf(property, "invalid message-id %q", id)
+		}
+		t = append(t, "<"+id+">")
+	}
+	return strings.Join(t, "\r\n\t")
+}
+
+// xbodyStructure returns the MIME structure to write for a new email, from either
+// the bodyStructure or the textBody, htmlBody and attachments properties.
+// ../rfc/8621:2770
+func xbodyStructure(ec emailCreate) emailBodyPartCreate {
+	if ec.BodyStructure != nil {
+		if ec.TextBody != nil || ec.HTMLBody != nil || ec.Attachments != nil {
+			xinvalidPropertiesf("bodyStructure", "bodyStructure cannot be combined with textBody, htmlBody or attachments")
+		}
+		return *ec.BodyStructure
+	}
+
+	typ := func(p emailBodyPartCreate, def string) string {
+		if p.Type == nil {
+			return def
+		}
+		return strings.ToLower(*p.Type)
+	}
+	multipart := func(subtype string, parts ...emailBodyPartCreate) emailBodyPartCreate {
+		t := "multipart/" + subtype
+		return emailBodyPartCreate{Type: &t, SubParts: parts}
+	}
+
+	if len(ec.TextBody) > 1 || len(ec.TextBody) == 1 && typ(ec.TextBody[0], "text/plain") != "text/plain" {
+		xinvalidPropertiesf("textBody", "textBody must have at most one text/plain part")
+	}
+	if len(ec.HTMLBody) > 1 || len(ec.HTMLBody) == 1 && typ(ec.HTMLBody[0], "text/html") != "text/html" {
+		xinvalidPropertiesf("htmlBody", "htmlBody must have at most one text/html part")
+	}
+	var body *emailBodyPartCreate
+	if len(ec.TextBody) == 1 && len(ec.HTMLBody) == 1 {
+		text, html := ec.TextBody[0], ec.HTMLBody[0]
+		textType, htmlType := "text/plain", "text/html"
+		text.Type, html.Type = &textType, &htmlType
+		alt := multipart("alternative", text, html)
+		body = &alt
+	} else if len(ec.TextBody) == 1 {
+		p := ec.TextBody[0]
+		t := "text/plain"
+		p.Type = &t
+		body = &p
+	} else if len(ec.HTMLBody) == 1 {
+		p := ec.HTMLBody[0]
+		t := "text/html"
+		p.Type = &t
+		body = &p
+	}
+
+	// Inline attachments with a content-id go in a multipart/related with the body,
+	// others in a multipart/mixed.
+	var inline, attached []emailBodyPartCreate
+	for _, a := range ec.Attachments {
+		if a.Cid != nil && a.Disposition != nil && strings.EqualFold(*a.Disposition, "inline") && body != nil {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+	if len(inline) > 0 {
+		rel := multipart("related", append([]emailBodyPartCreate{*body}, inline...)...)
+		body = &rel
+	}
+	if body == nil {
+		body = &emailBodyPartCreate{empty: true}
+	}
+	if len(attached) > 0 {
+		mixed := multipart("mixed", append([]emailBodyPartCreate{*body}, attached...)...)
+		body = &mixed
+	}
+	return *body
+}
+
+// composer writes the body parts of a new email.
+type composer struct {
+	c      *call
+	tx     *bstore.Tx
+	xc     *message.Composer
+	values map[string]emailBodyValueCreate
+}
+
+// xcreatePart writes the header for a part, in the message header if parent is
+// nil, and returns a writer for the part body.
+func (cw composer) xcreatePart(parent *multipart.Writer, h textproto.MIMEHeader) io.Writer {
+	if parent == nil {
+		keys := make([]string, 0, len(h))
+		for k := range h {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			for _, v := range h[k] {
+				cw.xc.Header(k, v)
+			}
+		}
+		cw.xc.Line()
+		return cw.xc
+	}
+	w, err := parent.CreatePart(h)
+	xcheckf(err, "adding part")
+	return w
+}
+
+// xwritePart writes a part and its subparts.
+func (cw composer) xwritePart(parent *multipart.Writer, p emailBodyPartCreate, property string) {
+	h := textproto.MIMEHeader{}
+	check := func(s *string, name string) {
+		if s != nil && strings.ContainsAny(*s, "\r\n") {
+			xinvalidPropertiesf(property, "%s cannot contain newlines", name)
+		}
+	}
+	check(p.Type, "type")
+	check(p.Charset, "charset")
+	check(p.Disposition, "disposition")
+	check(p.Cid, "cid")
+	check(p.Name, "name")
+	check(p.Location, "location")
+	if p.Cid != nil {
+		h.Set("Content-Id", "<"+strings.TrimSuffix(strings.TrimPrefix(*p.Cid, "<"), ">")+">")
+	}
+	if len(p.Language) > 0 {
+		h.Set("Content-Language", strings.Join(p.Language, ", "))
+	}
+	if p.Location != nil {
+		h.Set("Content-Location", *p.Location)
+	}
+	if p.Disposition != nil || p.Name != nil {
+		disp := "attachment"
+		if p.Disposition != nil {
+			disp = strings.ToLower(*p.Disposition)
+		}
+		params := map[string]string{}
+		if p.Name != nil {
+			params["filename"] = *p.Name
+		}
+		h.Set("Content-Disposition", mime.FormatMediaType(disp, params))
+	}
+
+	if p.empty {
+		textBody, ct, cte := cw.xc.TextPart("plain", "")
+		h.Set("Content-Type", ct)
+		h.Set("Content-Transfer-Encoding", cte)
+		_, err := cw.xcreatePart(parent, h).Write(textBody)
+		xcheckf(err, "writing text")
+		return
+	}
+
+	if p.Type != nil && strings.HasPrefix(strings.ToLower(*p.Type), "multipart/") {
+		if p.PartID != nil || p.BlobID != nil || p.Charset != nil {
+			xinvalidPropertiesf(property, "multipart cannot have partId, blobId or charset")
+		}
+		if len(p.SubParts) == 0 {
+			xinvalidPropertiesf(property, "multipart must have subParts")
+		}
+		mp := multipart.NewWriter(cw.xc)
+		h.Set("Content-Type", mime.FormatMediaType(strings.ToLower(*p.Type), map[string]string{"boundary": mp.Boundary()}))
+		cw.xcreatePart(parent, h)
+		for _, sub := range p.SubParts {
+			cw.xwritePart(mp, sub, property)
+		}
+		err := mp.Close()
+		xcheckf(err, "closing multipart")
+		return
+	}
+	if p.SubParts != nil {
+		xinvalidPropertiesf(property, "only multiparts can have subParts")
+	}
+
+	if p.PartID != nil {
+		// Text from bodyValues. ../rfc/8621:2800
+		if p.BlobID != nil || p.Charset != nil {
+			xinvalidPropertiesf(property, "part with partId cannot have blobId or charset")
+		}
+		bv, ok := cw.values[*p.PartID]
+		if !ok {
+			xinvalidPropertiesf(property, "unknown partId %q", *p.PartID)
+		}
+		if bv.IsEncodingProblem || bv.IsTruncated {
+			xinvalidPropertiesf("bodyValues", "isEncodingProblem and isTruncated must be false")
+		}
+		typ := "text/plain"
+		if p.Type != nil {
+			typ = strings.ToLower(*p.Type)
+		}
+		subtype, ok := strings.CutPrefix(typ, "text/")
+		if !ok || subtype == "" {
+			xinvalidPropertiesf(property, "part with partId must have a text type")
+		}
+		textBody, ct, cte := cw.xc.TextPart(subtype, bv.Value)
+		h.Set("Content-Type", ct)
+		h.Set("Content-Transfer-Encoding", cte)
+		_, err := cw.xcreatePart(parent, h).Write(textBody)
+		xcheckf(err, "writing text")
+		return
+	}
+
+	if p.BlobID == nil {
+		xinvalidPropertiesf(property, "part must have partId or blobId")
+	}
+	rc, err := openBlob(cw.tx, cw.c.acc, cw.c.xresolveSetID(*p.BlobID))
+	if err == errBlobNotFound {
+		panic(setError{"blobNotFound", fmt.Sprintf("blob %q not found", *p.BlobID), nil})
+	}
+	xcheckf(err, "opening blob")
+	defer func() {
+		err := rc.Close()
+		cw.c.log.Check(err, "closing blob")
+	}()
+	typ := "application/octet-stream"
+	if p.Type != nil {
+		typ = strings.ToLower(*p.Type)
+	}
+	params := map[string]string{}
+	if p.Charset != nil {
+		params["charset"] = *p.Charset
+	}
+	if p.Name != nil {
+		params["name"] = *p.Name
+	}
+	ct := mime.FormatMediaType(typ, params)
+	if ct == "" {
+		xinvalidPropertiesf(property, "invalid type %q", typ)
+	}
+	h.Set("Content-Type", ct)
+	if typ == "message/rfc822" {
+		// Messages cannot be encoded. ../rfc/2046:1316
+		_, err = io.Copy(cw.xcreatePart(parent, h), rc)
+		xcheckf(err, "writing message part")
+		return
+	}
+	h.Set("Content-Transfer-Encoding", "base64")
+	bw := moxio.Base64Writer(cw.xcreatePart(parent, h))
+	_, err = io.Copy(bw, rc)
+	xcheckf(err, "writing blob")
+	err = bw.Close()
+	xcheckf(err, "flushing blob")
+}
+
+// xcomposeEmail writes a new message for the email to f.
+func (c *call) xcomposeEmail(tx *bstore.Tx, f *os.File, ec emailCreate, smtputf8 bool) (size int64) {
+	xc := message.NewComposer(f, c.s.maxMsgSize, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrMessageSize) {
+			xsetErrorf("tooLarge", "message too large")
+		} else if ok && errors.Is(err, message.ErrCompose) {
+			xcheckf(err, "composing message")
+		}
+		panic(x)
+	}()
+
+	xc.HeaderAddrs("From", xnameAddresses("from", ec.From))
+	xc.HeaderAddrs("Sender", xnameAddresses("sender", ec.Sender))
+	xc.HeaderAddrs("Reply-To", xnameAddresses("replyTo", ec.ReplyTo))
+	xc.HeaderAddrs("To", xnameAddresses("to", ec.To))
+	xc.HeaderAddrs("Cc", xnameAddresses("cc", ec.Cc))
+	// Drafts keep their Bcc header, it is removed when submitting.
+	xc.HeaderAddrs("Bcc", xnameAddresses("bcc", ec.Bcc))
+	if ec.Subject != nil && *ec.Subject != "" {
+		if strings.ContainsAny(*ec.Subject, "\r\n") {
+			xinvalidPropertiesf("subject", "subject cannot contain newlines")
+		}
+		xc.Subject(*ec.Subject)
+	}
+	hasHeader := func(name string) bool {
+		return slices.ContainsFunc(ec.headers, func(h createHeader) bool { return strings.EqualFold(h.name, name) })
+	}
+	if !hasHeader("Date") {
+		date := time.Now()
+		if ec.SentAt != nil {
+			t, err := time.Parse(time.RFC3339, *ec.SentAt)
+			if err != nil {
+				xinvalidPropertiesf("sentAt", "parsing date: %v", err)
+			}
+			date = t
+		}
+		xc.Header("Date", date.Format(message.RFC5322Z))
+	}
+	if len(ec.MessageID) > 1 {
+		xinvalidPropertiesf("messageId", "at most one message-id allowed")
+	} else if len(ec.MessageID) == 1 {
+		xc.Header("Message-Id", xmessageIDsHeader("messageId", ec.MessageID))
+	} else if !hasHeader("Message-Id") {
+		xc.Header("Message-Id", fmt.Sprintf("<%s>", mox.MessageIDGen(smtputf8)))
+	}
+	if len(ec.InReplyTo) > 0 {
+		xc.Header("In-Reply-To", xmessageIDsHeader("inReplyTo", ec.InReplyTo))
+	}
+	if len(ec.References) > 0 {
+		xc.Header("References", xmessageIDsHeader("references", ec.References))
+	}
+	for _, h := range ec.headers {
+		if !h.text {
+			fmt.Fprintf(xc, "%s:%s\r\n", h.name, h.value)
+		} else if !smtputf8 && !isASCII(h.value) {
+			xc.Header(h.name, mime.QEncoding.Encode("utf-8", h.value))
+		} else {
+			xc.Header(h.name, h.value)
+		}
+	}
+	xc.Header("MIME-Version", "1.0")
+
+	cw := composer{c, tx, xc, ec.BodyValues}
+	cw.xwritePart(nil, xbodyStructure(ec), "bodyStructure")
+	xc.Flush()
+	return xc.Size
+}
+
+// Result for a created email. ../rfc/8621:2860
+type emailCreated struct {
+	ID       string `json:"id"`
+	BlobID   string `json:"blobId"`
+	ThreadID string `json:"threadId"`
+	Size     int64  `json:"size"`
+}
+
+func makeEmailCreated(m store.Message) emailCreated {
+	return emailCreated{emailID(m.ID), fmt.Sprintf("b%d", m.ID), threadID(threadIDOf(m)), m.Size}
+}
+
+// xsingleMailbox returns the single mailbox from a mailboxIds property value.
+func (c *call) xsingleMailbox(tx *bstore.Tx, mailboxIDs map[string]bool) store.Mailbox {
+	var ids []string
+	for id, v := range mailboxIDs {
+		if !v {
+			xinvalidPropertiesf("mailboxIds", "mailboxIds values must be true")
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		xinvalidPropertiesf("mailboxIds", "email must be in a mailbox")
+	} else if len(ids) > 1 {
+		xsetErrorf("tooManyMailboxes", "email can be in only one mailbox")
+	}
+	return xmailboxProperty(tx, "mailboxIds", c.xresolveSetID(ids[0]))
+}
+
+// emailTx adds and changes messages within a transaction, keeping track of the
+// changes and the message files to remove if the transaction fails.
+type emailTx struct {
+	c       *call
+	tx      *bstore.Tx
+	modseq  store.ModSeq
+	changes []store.Change
+	files   []int64 // Message ids for files added, removed on failure.
+}
+
+func (ea *emailTx) xmodseq() store.ModSeq {
+	if ea.modseq == 0 {
+		var err error
+		ea.modseq, err = ea.c.acc.NextModSeq(ea.tx)
+		xcheckf(err, "next modseq")
+	}
+	return ea.modseq
+}
+
+// xadd adds the message in f to mailbox mb.
+func (ea *emailTx) xadd(mb store.Mailbox, f *os.File, size int64, flags store.Flags, keywords []string, received time.Time) store.Message {
+	origmb := mb
+	modseq := ea.xmodseq()
+	m := store.Message{
+		CreateSeq:     modseq,
+		ModSeq:        modseq,
+		MailboxID:     mb.ID,
+		MailboxOrigID: mb.ID,
+		Received:      received,
+		Flags:         flags,
+		Keywords:      keywords,
+		Size:          size,
+	}
+	err := ea.c.acc.MessageAdd(ea.c.log, ea.tx, &mb, &m, f, store.AddOpts{})
+	if err != nil && errors.Is(err, store.ErrOverQuota) {
+		xsetErrorf("overQuota", "%v", err)
+	}
+	xcheckf(err, "adding message")
+	ea.files = append(ea.files, m.ID)
+
+	err = ea.tx.Update(&mb)
+	xcheckf(err, "updating mailbox")
+	ea.changes = append(ea.changes, m.ChangeAddUID(mb), mb.ChangeCounts())
+	if mb.KeywordsChanged(origmb) {
+		ea.changes = append(ea.changes, mb.ChangeKeywords())
+	}
+	return m
+}
+
+// cleanup removes added message files if the transaction was not committed.
+func (ea *emailTx) cleanup() {
+	for _, id := range ea.files {
+		p := ea.c.acc.MessagePath(id)
+		err := os.Remove(p)
+		ea.c.log.Check(err, "removing message file after failure", slog.String("path", p))
+	}
+	ea.files = nil
+}
+
+// xreceivedAt parses an optional receivedAt property.
+func xreceivedAt(s *string) time.Time {
+	if s == nil {
+		return time.Now()
+	}
+	t, err := time.Parse(time.RFC3339, *s)
+	if err != nil {
+		xinvalidPropertiesf("receivedAt", "parsing date: %v", err)
+	}
+	return t
+}
+
+// xemailCreate composes and adds a new email.
+func (c *call) xemailCreate(ea *emailTx, raw json.RawMessage) store.Message {
+	ec := xparseEmailCreate(raw)
+
+	// If any address needs smtputf8, we write the whole message as utf-8.
+	var smtputf8 bool
+	for _, a := range slices.Concat(ec.Sender, ec.From, ec.To, ec.Cc, ec.Bcc, ec.ReplyTo) {
+		if addr, err := smtp.ParseAddress(a.Email); err == nil && addr.Localpart.IsInternational() {
+			smtputf8 = true
+		}
+	}
+	mb := c.xsingleMailbox(ea.tx, ec.MailboxIDs)
+	flags, keywords := xkeywordsFlags(ec.Keywords)
+	received := xreceivedAt(ec.ReceivedAt)
+
+	f, err := store.CreateMessageTemp(c.log, "jmap-email")
+	xcheckf(err, "creating temporary file for message")
+	defer store.CloseRemoveTempFile(c.log, f, "new email")
+
+	size := c.xcomposeEmail(ea.tx, f, ec, smtputf8)
+	return ea.xadd(mb, f, size, flags, keywords, received)
+}
+
+// xemailUpdate applies a patch of keywords and mailboxIds to an email.
+// ../rfc/8621:2880
+func (c *call) xemailUpdate(ea *emailTx, id string, patch map[string]json.RawMessage, retrain *[]store.Message) []store.Change {
+	tx := ea.tx
+	modseq := &ea.modseq
+	m, ok := xvisibleMessage(tx, c.xresolveSetID(id))
+	if !ok {
+		xsetErrorf("notFound", "email not found")
+	}
+
+	// Gather the new keywords and mailboxes.
+	keywords := emailKeywords(m)
+	mailboxIDs := map[string]bool{mailboxID(m.MailboxID): true}
+	for k, v := range patch {
+		path := patchPath(k)
+		switch {
+		case k == "keywords":
+			keywords = map[string]bool{}
+			if err := json.Unmarshal(v, &keywords); err != nil {
+				xinvalidPropertiesf(k, "parsing keywords: %v", err)
+			}
+		case len(path) == 2 && path[0] == "keywords":
+			kw, _ := xparseKeyword(&store.Flags{}, path[1])
+			if set, ok := jsonBool(v); !ok {
+				xinvalidPropertiesf(k, "value must be true or null")
+			} else if set {
+				keywords[kw] = true
+			} else {
+				delete(keywords, kw)
+			}
+		case k == "mailboxIds":
+			mailboxIDs = map[string]bool{}
+			if err := json.Unmarshal(v, &mailboxIDs); err != nil {
+				xinvalidPropertiesf(k, "parsing mailboxIds: %v", err)
+			}
+		case len(path) == 2 && path[0] == "mailboxIds":
+			if set, ok := jsonBool(v); !ok {
+				xinvalidPropertiesf(k, "value must be true or null")
+			} else if set {
+				mailboxIDs[c.xresolveSetID(path[1])] = true
+			} else {
+				delete(mailboxIDs, c.xresolveSetID(path[1]))
+			}
+		default:
+			if slices.Contains(emailProperties, path[0]) {
+				xinvalidPropertiesf(k, "property %q cannot be changed", path[0])
+			}
+			xinvalidPropertiesf(k, "unknown property %q", path[0])
+		}
+	}
+	// Lower-case the keys.
+	lkeywords := map[string]bool{}
+	for k, v := range keywords {
+		lkeywords[strings.ToLower(k)] = v
+	}
+	flags, kwl := xkeywordsFlags(lkeywords)
+	mbDst := c.xsingleMailbox(tx, mailboxIDs)
+
+	// All checks done, make changes.
+	var changes []store.Change
+	if *modseq == 0 {
+		var err error
+		*modseq, err = c.acc.NextModSeq(tx)
+		xcheckf(err, "next modseq")
+	}
+	flags.Deleted = m.Deleted
+	if flags != m.Flags || !slices.Equal(kwl, m.Keywords) {
+		mb := store.Mailbox{ID: m.MailboxID}
+		err := tx.Get(&mb)
+		xcheckf(err, "get mailbox")
+		origmb := mb
+
+		mb.Sub(m.MailboxCounts())
+		oflags := m.Flags
+		m.Flags = flags
+		m.Keywords = kwl
+		m.ModSeq = *modseq
+		mb.Add(m.MailboxCounts())
+		mb.Keywords, _ = store.MergeKeywords(mb.Keywords, kwl)
+		mb.ModSeq = *modseq
+
+		err = tx.Update(&m)
+		xcheckf(err, "updating message")
+		err = tx.Update(&mb)
+		xcheckf(err, "updating mailbox")
+		changes = append(changes, m.ChangeFlags(oflags, mb))
+		if mb.MailboxCounts != origmb.MailboxCounts {
+			changes = append(changes, mb.ChangeCounts())
+		}
+		if mb.KeywordsChanged(origmb) {
+			changes = append(changes, mb.ChangeKeywords())
+		}
+		*retrain = append(*retrain, m)
+	}
+	if mbDst.ID != m.MailboxID {
+		newIDs, nchanges := xops.MessageMoveTx(c.ctx, c.log, c.acc, tx, []int64{m.ID}, mbDst, modseq)
+		ea.files = append(ea.files, newIDs...)
+		changes = append(changes, nchanges...)
+	}
+	return changes
+}
+
+func (c *call) emailSet(args setArgs) setResult {
+	c.xaccount(args.AccountID)
+
+	var r setResult
+	r.AccountID = args.AccountID
+
+	c.acc.WithWLock(func() {
+		ea := &emailTx{c: c}
+		defer ea.cleanup()
+
+		xdbwrite(c.ctx, c.acc, func(tx *bstore.Tx) {
+			ea.tx = tx
+			oldState := modseqState(xlastModSeq(tx))
+			c.xcheckSet(args, oldState)
+			r.OldState = &oldState
+
+			creationIDs := make([]string, 0, len(args.Create))
+			for cid := range args.Create {
+				creationIDs = append(creationIDs, cid)
+			}
+			slices.Sort(creationIDs)
+			for _, cid := range creationIDs {
+				serr := xsetItem(func() {
+					m := c.xemailCreate(ea, args.Create[cid])
+					c.createdIDs[cid] = emailID(m.ID)
+					r.addCreated(cid, makeEmailCreated(m))
+				})
+				if serr != nil {
+					r.addNotCreated(cid, serr)
+				}
+			}
+
+			var retrain []store.Message
+			for id, patch := range args.Update {
+				serr := xsetItem(func() {
+					nchanges := c.xemailUpdate(ea, id, patch, &retrain)
+					ea.changes = append(ea.changes, nchanges...)
+					r.addUpdated(id, nil)
+				})
+				if serr != nil {
+					r.addNotUpdated(id, serr)
+				}
+			}
+			err := c.acc.RetrainMessages(c.ctx, c.log, tx, retrain)
+			xcheckf(err, "retraining messages")
+
+			for _, id := range args.Destroy {
+				serr := xsetItem(func() {
+					m, ok := xvisibleMessage(tx, c.xresolveSetID(id))
+					if !ok {
+						xsetErrorf("notFound", "email not found")
+					}
+					nchanges := xops.MessageDeleteTx(c.ctx, c.log, tx, c.acc, []int64{m.ID}, &ea.modseq)
+					ea.changes = append(ea.changes, nchanges...)
+					r.Destroyed = append(r.Destroyed, id)
+				})
+				if serr != nil {
+					r.addNotDestroyed(id, serr)
+				}
+			}
+
+			r.NewState = modseqState(xlastModSeq(tx))
+		})
+		ea.files = nil // Committed.
+
+		store.BroadcastChanges(c.acc, ea.changes)
+	})
+	return r
+}
+
+// Email/import arguments. ../rfc/8621:3000
+type emailImportArgs struct {
+	AccountID string                 `json:"accountId"`
+	IfInState *string                `json:"ifInState"`
+	Emails    map[string]emailImport `json:"emails"`
+}
+
+type emailImport struct {
+	BlobID     string          `json:"blobId"`
+	MailboxIDs map[string]bool `json:"mailboxIds"`
+	Keywords   map[string]bool `json:"keywords"`
+	ReceivedAt *string         `json:"receivedAt"`
+}
+
+type emailImportResult struct {
+	AccountID  string               `json:"accountId"`
+	OldState   *string              `json:"oldState"`
+	NewState   string               `json:"newState"`
+	Created    map[string]any       `json:"created"`
+	NotCreated map[string]*setError `json:"notCreated"`
+}
+
+// xemailImport adds a message from a blob.
+func (c *call) xemailImport(ea *emailTx, ei emailImport) store.Message {
+	mb := c.xsingleMailbox(ea.tx, ei.MailboxIDs)
+	flags, keywords := xkeywordsFlags(ei.Keywords)
+	received := xreceivedAt(ei.ReceivedAt)
+
+	rc, err := openBlob(ea.tx, c.acc, c.xresolveSetID(ei.BlobID))
+	if err == errBlobNotFound {
+		xsetErrorf("blobNotFound", "blob %q not found", ei.BlobID)
+	}
+	xcheckf(err, "opening blob")
+	defer func() {
+		err := rc.Close()
+		c.log.Check(err, "closing blob")
+	}()
+
+	f, err := store.CreateMessageTemp(c.log, "jmap-import")
+	xcheckf(err, "creating temporary file for message")
+	defer store.CloseRemoveTempFile(c.log, f, "imported email")
+
+	size, err := io.Copy(f, rc)
+	xcheckf(err, "copying blob")
+	if size > c.s.maxMsgSize {
+		xsetErrorf("tooLarge", "message too large")
+	}
+	if _, err := message.Parse(c.log.Logger, false, f); err != nil {
+		xsetErrorf("invalidEmail", "parsing message: %v", err)
+	}
+	return ea.xadd(mb, f, size, flags, keywords, received)
+}
+
+func (c *call) emailImport(args emailImportArgs) emailImportResult {
+	c.xaccount(args.AccountID)
+	if len(args.Emails) > maxObjectsInSet {
+		xmethodErrorf("requestTooLarge", "too many emails, max %d", maxObjectsInSet)
+	}
+
+	var r emailImportResult
+	r.AccountID = args.AccountID
+
+	c.acc.WithWLock(func() {
+		ea := &emailTx{c: c}
+		defer ea.cleanup()
+
+		xdbwrite(c.ctx, c.acc, func(tx *bstore.Tx) {
+			ea.tx = tx
+			oldState := modseqState(xlastModSeq(tx))
+			if args.IfInState != nil && *args.IfInState != oldState {
+				xmethodErrorf("stateMismatch", "state is %q", oldState)
+			}
+			r.OldState = &oldState
+
+			creationIDs := make([]string, 0, len(args.Emails))
+			for cid := range args.Emails {
+				creationIDs = append(creationIDs, cid)
+			}
+			slices.Sort(creationIDs)
+			for _, cid := range creationIDs {
+				serr := xsetItem(func() {
+					m := c.xemailImport(ea, args.Emails[cid])
+					c.createdIDs[cid] = emailID(m.ID)
+					if r.Created == nil {
+						r.Created = map[string]any{}
+					}
+					r.Created[cid] = makeEmailCreated(m)
+				})
+				if serr != nil {
+					if r.NotCreated == nil {
+						r.NotCreated = map[string]*setError{}
+					}
+					r.NotCreated[cid] = serr
+				}
+			}
+
+			r.NewState = modseqState(xlastModSeq(tx))
+		})
+		ea.files = nil // Committed.
+
+		store.BroadcastChanges(c.acc, ea.changes)
+	})
+	return r
+}
@@ -0,0 +1,187 @@
+package jmapserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// Types that push notifications can be requested for.
+var pushTypes = []string{"Mailbox", "Email", "Thread", "EmailDelivery"}
+
+// changedTypes returns the JMAP types affected by the changes, and calls
+// RemovalSeen for removals.
+func changedTypes(comm *store.Comm, changes []store.Change) map[string]bool {
+	types := map[string]bool{}
+	for _, ch := range changes {
+		switch c := ch.(type) {
+		case store.ChangeAddUID:
+			types["Email"] = true
+			types["Thread"] = true
+			types["Mailbox"] = true
+			types["EmailDelivery"] = true
+		case store.ChangeRemoveUIDs:
+			comm.RemovalSeen(c)
+			types["Email"] = true
+			types["Thread"] = true
+			types["Mailbox"] = true
+		case store.ChangeFlags:
+			types["Email"] = true
+			types["Mailbox"] = true
+		case store.ChangeAddMailbox, store.ChangeRemoveMailbox, store.ChangeRenameMailbox, store.ChangeMailboxCounts, store.ChangeMailboxSpecialUse, store.ChangeAddSubscription, store.ChangeRemoveSubscription:
+			types["Mailbox"] = true
+		}
+	}
+	return types
+}
+
+// stateChange is pushed to clients when the state of types changed.
+// ../rfc/8620:3283
+type stateChange struct {
+	Type    string                       `json:"@type"`
+	Changed map[string]map[string]string `json:"changed"`
+}
+
+// serveEventSource sends state changes as server-sent events until the client
+// disconnects. ../rfc/8620:3540
+func (s server) serveEventSource(log mlog.Log, w http.ResponseWriter, r *http.Request, acc *store.Account) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error("internal error: ResponseWriter not a http.Flusher")
+		http.Error(w, "500 - internal error - cannot access underlying connection", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	want := map[string]bool{}
+	for _, t := range strings.Split(q.Get("types"), ",") {
+		if t == "*" {
+			for _, pt := range pushTypes {
+				want[pt] = true
+			}
+		} else if slices.Contains(pushTypes, t) {
+			want[t] = true
+		}
+	}
+	closeAfterState := q.Get("closeafter") == "state"
+	var ping time.Duration
+	if v := q.Get("ping"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			http.Error(w, "400 - bad request - invalid ping", http.StatusBadRequest)
+			return
+		}
+		// We require a minimum interval. ../rfc/8620:3590
+		if n > 0 {
+			ping = time.Duration(max(n, 30)) * time.Second
+		}
+	}
+
+	comm := store.RegisterComm(acc)
+	defer comm.Unregister()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // For nginx reverse proxies, to not buffer.
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	write := func(event string, v any) bool {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			log.Errorx("marshal event", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf); err != nil {
+			log.Debugx("writing event", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	writeState := func(types map[string]bool) bool {
+		var modseq store.ModSeq
+		err := acc.DB.Read(r.Context(), func(tx *bstore.Tx) error {
+			modseq = xlastModSeq(tx)
+			return nil
+		})
+		if err != nil {
+			log.Errorx("get state for push", err)
+			return false
+		}
+		changed := map[string]string{}
+		for t := range types {
+			if want[t] {
+				changed[t] = modseqState(modseq)
+			}
+		}
+		if len(changed) == 0 {
+			return true
+		}
+		return write("state", stateChange{"StateChange", map[string]map[string]string{acc.Name: changed}})
+	}
+
+	if !closeAfterState {
+		if !writeState(want) {
+			return
+		}
+	}
+
+	var pingc <-chan time.Time
+	if ping > 0 {
+		ticker := time.NewTicker(ping)
+		defer ticker.Stop()
+		pingc = ticker.C
+	}
+	// Keepalive comments, for proxies, if no ping was requested.
+	keepalive := time.NewTicker(5 * time.Minute)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-mox.Shutdown.Done():
+			return
+
+		case <-r.Context().Done():
+			return
+
+		case <-pingc:
+			if !write("ping", map[string]int{"interval": int(ping / time.Second)}) {
+				return
+			}
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-comm.Pending:
+			overflow, changes := comm.Get()
+			types := changedTypes(comm, changes)
+			if overflow {
+				for _, t := range pushTypes {
+					types[t] = true
+				}
+			}
+			if !writeState(types) {
+				return
+			}
+			if closeAfterState && slices.ContainsFunc(pushTypes, func(t string) bool { return types[t] && want[t] }) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package jmapserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Identities are the addresses configured for the account, and aliases the
+// account is allowed to send as. They cannot be changed through JMAP. A
+// catchall destination results in an identity with a "*" localpart.
+// ../rfc/8621:3370
+
+var identityProperties = []string{
+	"id",
+	"name",
+	"email",
+	"replyTo",
+	"bcc",
+	"textSignature",
+	"htmlSignature",
+	"mayDelete",
+}
+
+// identity is an Identity object.
+type identity struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Email         string         `json:"email"`
+	ReplyTo       []emailAddress `json:"replyTo"`
+	Bcc           []emailAddress `json:"bcc"`
+	TextSignature string         `json:"textSignature"`
+	HTMLSignature string         `json:"htmlSignature"`
+	MayDelete     bool           `json:"mayDelete"`
+}
+
+func identityID(email string) string {
+	return "i" + base64.RawURLEncoding.EncodeToString([]byte(email))
+}
+
+// identities returns the identities for the account, sorted by email address,
+// and the state.
+func (c *call) identities() ([]identity, string) {
+	accConf, _ := c.acc.Conf()
+	var l []identity
+	seen := map[string]bool{}
+	add := func(name, email string) {
+		if seen[email] {
+			return
+		}
+		seen[email] = true
+		l = append(l, identity{ID: identityID(email), Name: name, Email: email})
+	}
+	for a, dest := range accConf.Destinations {
+		name := dest.FullName
+		if name == "" {
+			name = accConf.FullName
+		}
+		if strings.HasPrefix(a, "@") {
+			dom, err := dns.ParseDomain(a[1:])
+			if err != nil {
+				c.log.Debugx("parsing catchall destination domain, skipping", err)
+				continue
+			}
+			add(name, "*@"+dom.Name())
+			continue
+		}
+		addr, err := smtp.ParseAddress(a)
+		if err != nil {
+			c.log.Debugx("parsing destination address, skipping", err)
+			continue
+		}
+		add(name, addr.String())
+	}
+	for _, a := range accConf.Aliases {
+		if !a.Alias.AllowMsgFrom {
+			continue
+		}
+		lp, err := smtp.ParseLocalpart(a.Alias.LocalpartStr)
+		if err != nil {
+			c.log.Debugx("parsing alias localpart, skipping", err)
+			continue
+		}
+		add("", smtp.NewAddress(lp, a.Alias.Domain).String())
+	}
+	slices.SortFunc(l, func(a, b identity) int {
+		return strings.Compare(a.Email, b.Email)
+	})
+	buf, err := json.Marshal(l)
+	xcheckf(err, "marshal identities")
+	sum := sha256.Sum256(buf)
+	return l, base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (c *call) identityGet(args getArgs) getResult {
+	props := c.xcheckGet(args, identityProperties)
+
+	l, state := c.identities()
+	r := getResult{
+		AccountID: args.AccountID,
+		State:     state,
+		List:      []map[string]any{},
+		NotFound:  []string{},
+	}
+	add := func(ident identity) {
+		all := map[string]any{
+			"id":            ident.ID,
+			"name":          ident.Name,
+			"email":         ident.Email,
+			"replyTo":       ident.ReplyTo,
+			"bcc":           ident.Bcc,
+			"textSignature": ident.TextSignature,
+			"htmlSignature": ident.HTMLSignature,
+			"mayDelete":     ident.MayDelete,
+		}
+		if props == nil {
+			r.List = append(r.List, all)
+			return
+		}
+		o := map[string]any{}
+		for p := range props {
+			o[p] = all[p]
+		}
+		r.List = append(r.List, o)
+	}
+	if args.IDs == nil {
+		for _, ident := range l {
+			add(ident)
+		}
+		return r
+	}
+	for _, id := range args.IDs {
+		i := slices.IndexFunc(l, func(ident identity) bool { return ident.ID == id })
+		if i < 0 {
+			r.NotFound = append(r.NotFound, id)
+		} else {
+			add(l[i])
+		}
+	}
+	return r
+}
+
+// Identities are configured, we don't keep track of changes.
+func (c *call) identityChanges(args changesArgs) changesResult {
+	c.xaccount(args.AccountID)
+	_, state := c.identities()
+	if args.SinceState != state {
+		xmethodErrorf("cannotCalculateChanges", "identities changed")
+	}
+	return changesResult{
+		AccountID: args.AccountID,
+		OldState:  state,
+		NewState:  state,
+		Created:   []string{},
+		Updated:   []string{},
+		Destroyed: []string{},
+	}
+}
+
+func (c *call) identitySet(args setArgs) setResult {
+	_, state := c.identities()
+	c.xcheckSet(args, state)
+	r := setResult{AccountID: args.AccountID, OldState: &state, NewState: state}
+	serr := &setError{Type: "forbidden", Description: "identities are configured by the administrator"}
+	for cid := range args.Create {
+		r.addNotCreated(cid, serr)
+	}
+	for id := range args.Update {
+		r.addNotUpdated(id, serr)
+	}
+	for _, id := range args.Destroy {
+		r.addNotDestroyed(id, serr)
+	}
+	return r
+}
+
+// xidentity returns the identity for an id.
+func (c *call) xidentity(id string) (identity, bool) {
+	l, _ := c.identities()
+	i := slices.IndexFunc(l, func(ident identity) bool { return ident.ID == id })
+	if i < 0 {
+		return identity{}, false
+	}
+	return l[i], true
+}
@@ -0,0 +1,733 @@
+package jmapserver
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/store"
+)
+
+// Mailbox properties. ../rfc/8621:470
+var mailboxProperties = []string{
+	"id",
+	"name",
+	"parentId",
+	"role",
+	"sortOrder",
+	"totalEmails",
+	"unreadEmails",
+	"totalThreads",
+	"unreadThreads",
+	"myRights",
+	"isSubscribed",
+}
+
+// Mailbox rights. We have no shared mailboxes, all rights are always present,
+// except for Inbox that cannot be renamed or removed. ../rfc/8621:591
+type mailboxRights struct {
+	MayReadItems   bool `json:"mayReadItems"`
+	MayAddItems    bool `json:"mayAddItems"`
+	MayRemoveItems bool `json:"mayRemoveItems"`
+	MaySetSeen     bool `json:"maySetSeen"`
+	MaySetKeywords bool `json:"maySetKeywords"`
+	MayCreateChild bool `json:"mayCreateChild"`
+	MayRename      bool `json:"mayRename"`
+	MayDelete      bool `json:"mayDelete"`
+	MaySubmit      bool `json:"maySubmit"`
+}
+
+// mailboxRole returns the JMAP role for a mailbox, from the IANA IMAP mailbox name
+// attributes registry. ../rfc/8621:515
+func mailboxRole(mb store.Mailbox) *string {
+	var role string
+	switch {
+	case mb.Name == "Inbox":
+		role = "inbox"
+	case mb.Archive:
+		role = "archive"
+	case mb.Draft:
+		role = "drafts"
+	case mb.Junk:
+		role = "junk"
+	case mb.Sent:
+		role = "sent"
+	case mb.Trash:
+		role = "trash"
+	default:
+		return nil
+	}
+	return &role
+}
+
+// xspecialUseForRole returns the special-use flags for a role, or a setError.
+func xspecialUseForRole(role *string, isInbox bool) store.SpecialUse {
+	var su store.SpecialUse
+	if role == nil {
+		if isInbox {
+			xinvalidPropertiesf("role", "inbox must have role inbox")
+		}
+		return su
+	}
+	switch *role {
+	case "inbox":
+		if !isInbox {
+			xinvalidPropertiesf("role", "only Inbox can have role inbox")
+		}
+	case "archive":
+		su.Archive = true
+	case "drafts":
+		su.Draft = true
+	case "junk":
+		su.Junk = true
+	case "sent":
+		su.Sent = true
+	case "trash":
+		su.Trash = true
+	default:
+		xinvalidPropertiesf("role", "unsupported role %q", *role)
+	}
+	if isInbox && *role != "inbox" {
+		xinvalidPropertiesf("role", "inbox must have role inbox")
+	}
+	return su
+}
+
+// mailboxName returns the last element of the mailbox name, the name of a mailbox
+// in JMAP.
+func mailboxName(mb store.Mailbox) string {
+	t := strings.Split(mb.Name, "/")
+	return t[len(t)-1]
+}
+
+// threadCounts holds the number of threads in a mailbox.
+type threadCounts struct {
+	total  int
+	unread int
+}
+
+// xthreadCounts calculates the thread counts for all mailboxes. An unread thread
+// has a message in the mailbox, and a message without $seen that is not in a trash
+// mailbox. ../rfc/8621:566
+func xthreadCounts(tx *bstore.Tx) map[int64]threadCounts {
+	trash := map[int64]bool{}
+	err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Expunged", false).FilterEqual("Trash", true).ForEach(func(mb store.Mailbox) error {
+		trash[mb.ID] = true
+		return nil
+	})
+	xcheckf(err, "listing trash mailboxes")
+
+	type thread struct {
+		mailboxes map[int64]struct{}
+		unread    bool
+	}
+	threads := map[int64]*thread{}
+	q := bstore.QueryTx[store.Message](tx)
+	q.FilterEqual("Expunged", false)
+	q.FilterEqual("Deleted", false)
+	err = q.ForEach(func(m store.Message) error {
+		tid := threadIDOf(m)
+		t := threads[tid]
+		if t == nil {
+			t = &thread{mailboxes: map[int64]struct{}{}}
+			threads[tid] = t
+		}
+		t.mailboxes[m.MailboxID] = struct{}{}
+		if !m.Seen && !trash[m.MailboxID] {
+			t.unread = true
+		}
+		return nil
+	})
+	xcheckf(err, "listing messages for thread counts")
+
+	counts := map[int64]threadCounts{}
+	for _, t := range threads {
+		for mbID := range t.mailboxes {
+			tc := counts[mbID]
+			tc.total++
+			if t.unread {
+				tc.unread++
+			}
+			counts[mbID] = tc
+		}
+	}
+	return counts
+}
+
+// mailboxObject returns the JMAP object for a mailbox with the requested
+// properties, nil meaning all.
+func mailboxObject(mb store.Mailbox, subscribed bool, tc threadCounts, props map[string]bool) map[string]any {
+	var parentID *string
+	if mb.ParentID != 0 {
+		s := mailboxID(mb.ParentID)
+		parentID = &s
+	}
+	isInbox := mb.Name == "Inbox"
+	all := map[string]any{
+		"id":            mailboxID(mb.ID),
+		"name":          mailboxName(mb),
+		"parentId":      parentID,
+		"role":          mailboxRole(mb),
+		"sortOrder":     0,
+		"totalEmails":   mb.Total,
+		"unreadEmails":  mb.Unread,
+		"totalThreads":  tc.total,
+		"unreadThreads": tc.unread,
+		"myRights": mailboxRights{
+			MayReadItems:   true,
+			MayAddItems:    true,
+			MayRemoveItems: true,
+			MaySetSeen:     true,
+			MaySetKeywords: true,
+			MayCreateChild: true,
+			MayRename:      !isInbox,
+			MayDelete:      !isInbox,
+			MaySubmit:      true,
+		},
+		"isSubscribed": subscribed,
+	}
+	if props == nil {
+		return all
+	}
+	r := map[string]any{}
+	for p := range props {
+		r[p] = all[p]
+	}
+	return r
+}
+
+// xsubscribed returns whether the mailbox is subscribed.
+func xsubscribed(tx *bstore.Tx, name string) bool {
+	err := tx.Get(&store.Subscription{Name: name})
+	if err == bstore.ErrAbsent {
+		return false
+	}
+	xcheckf(err, "get subscription")
+	return true
+}
+
+// xmailbox returns the mailbox for a JMAP id, or a setError "notFound".
+func xmailbox(tx *bstore.Tx, id string) store.Mailbox {
+	mb := store.Mailbox{ID: parseID('m', id)}
+	if mb.ID == 0 {
+		xsetErrorf("notFound", "unknown mailbox")
+	}
+	err := tx.Get(&mb)
+	if err == bstore.ErrAbsent || err == nil && mb.Expunged {
+		xsetErrorf("notFound", "unknown mailbox")
+	}
+	xcheckf(err, "get mailbox")
+	return mb
+}
+
+// ../rfc/8621:627
+func (c *call) mailboxGet(args getArgs) getResult {
+	props := c.xcheckGet(args, mailboxProperties)
+
+	r := getResult{
+		AccountID: args.AccountID,
+		List:      []map[string]any{},
+		NotFound:  []string{},
+	}
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			r.State = modseqState(xlastModSeq(tx))
+
+			var tcounts map[int64]threadCounts
+			if props == nil || props["totalThreads"] || props["unreadThreads"] {
+				tcounts = xthreadCounts(tx)
+			}
+
+			add := func(mb store.Mailbox) {
+				r.List = append(r.List, mailboxObject(mb, xsubscribed(tx, mb.Name), tcounts[mb.ID], props))
+			}
+
+			if args.IDs == nil {
+				q := bstore.QueryTx[store.Mailbox](tx)
+				q.FilterEqual("Expunged", false)
+				q.SortAsc("Name")
+				err := q.ForEach(func(mb store.Mailbox) error {
+					add(mb)
+					return nil
+				})
+				xcheckf(err, "listing mailboxes")
+				return
+			}
+			for _, id := range args.IDs {
+				mb := store.Mailbox{ID: parseID('m', id)}
+				if mb.ID == 0 {
+					r.NotFound = append(r.NotFound, id)
+					continue
+				}
+				err := tx.Get(&mb)
+				if err == bstore.ErrAbsent || err == nil && mb.Expunged {
+					r.NotFound = append(r.NotFound, id)
+					continue
+				}
+				xcheckf(err, "get mailbox")
+				add(mb)
+			}
+		})
+	})
+	return r
+}
+
+// Mailbox/changes response, with updatedProperties. We don't track which
+// properties changed, so it is always null. ../rfc/8621:680
+type mailboxChangesResult struct {
+	changesResult
+	UpdatedProperties []string `json:"updatedProperties"`
+}
+
+// The modseq of a mailbox is updated when its counts change, e.g. for changes to
+// messages in the mailbox, so we find all changes through the modseq.
+func (c *call) mailboxChanges(args changesArgs) mailboxChangesResult {
+	c.xaccount(args.AccountID)
+
+	var r mailboxChangesResult
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			last := xlastModSeq(tx)
+			since := c.xsinceModSeq(tx, args.SinceState, last)
+
+			var l []objectChange
+			q := bstore.QueryTx[store.Mailbox](tx)
+			q.FilterGreater("ModSeq", since)
+			err := q.ForEach(func(mb store.Mailbox) error {
+				oc := objectChange{id: mailboxID(mb.ID), modseq: mb.ModSeq}
+				if mb.Expunged {
+					if mb.CreateSeq > since {
+						return nil
+					}
+					oc.kind = changeDestroyed
+				} else if mb.CreateSeq > since {
+					oc.kind = changeCreated
+				} else {
+					oc.kind = changeUpdated
+				}
+				l = append(l, oc)
+				return nil
+			})
+			xcheckf(err, "listing changed mailboxes")
+			r.changesResult = c.xchangesResult(args, last, l)
+		})
+	})
+	return r
+}
+
+// Mailbox/query filter condition. ../rfc/8621:726
+type mailboxFilter struct {
+	ParentID     optionalID `json:"parentId"`
+	Name         *string    `json:"name"`
+	Role         optionalID `json:"role"`
+	HasAnyRole   *bool      `json:"hasAnyRole"`
+	IsSubscribed *bool      `json:"isSubscribed"`
+}
+
+// mailboxQueryArgs has arguments for Mailbox/query. ../rfc/8621:720
+type mailboxQueryArgs struct {
+	queryArgs
+	SortAsTree   bool `json:"sortAsTree"`
+	FilterAsTree bool `json:"filterAsTree"`
+}
+
+func (c *call) mailboxQuery(args mailboxQueryArgs) queryResult {
+	c.xaccount(args.AccountID)
+	f := xparseFilter[mailboxFilter](args.Filter)
+	for _, cmp := range args.Sort {
+		if cmp.Property != "name" && cmp.Property != "sortOrder" {
+			xmethodErrorf("unsupportedSort", "unsupported sort property %q", cmp.Property)
+		}
+	}
+
+	var ids []string
+	var state string
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			state = modseqState(xlastModSeq(tx))
+
+			mbl, err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Expunged", false).List()
+			xcheckf(err, "listing mailboxes")
+			byID := map[int64]store.Mailbox{}
+			for _, mb := range mbl {
+				byID[mb.ID] = mb
+			}
+
+			matchCond := func(mb store.Mailbox, fc mailboxFilter) bool {
+				if fc.ParentID.Set {
+					var pid int64
+					if fc.ParentID.Value != nil {
+						pid = parseID('m', c.xresolveID(*fc.ParentID.Value))
+						if pid == 0 {
+							return false
+						}
+					}
+					if mb.ParentID != pid {
+						return false
+					}
+				}
+				if fc.Name != nil && !strings.Contains(strings.ToLower(mailboxName(mb)), strings.ToLower(*fc.Name)) {
+					return false
+				}
+				role := mailboxRole(mb)
+				if fc.Role.Set {
+					if (fc.Role.Value == nil) != (role == nil) || role != nil && *role != *fc.Role.Value {
+						return false
+					}
+				}
+				if fc.HasAnyRole != nil && *fc.HasAnyRole != (role != nil) {
+					return false
+				}
+				if fc.IsSubscribed != nil && *fc.IsSubscribed != xsubscribed(tx, mb.Name) {
+					return false
+				}
+				return true
+			}
+			var match func(mb store.Mailbox) bool
+			match = func(mb store.Mailbox) bool {
+				if !f.match(func(fc mailboxFilter) bool { return matchCond(mb, fc) }) {
+					return false
+				}
+				// With filterAsTree, a mailbox only matches if its parents match too.
+				if args.FilterAsTree && mb.ParentID != 0 {
+					if pmb, ok := byID[mb.ParentID]; ok {
+						return match(pmb)
+					}
+				}
+				return true
+			}
+			mbl = slices.DeleteFunc(mbl, func(mb store.Mailbox) bool { return !match(mb) })
+
+			compare := func(a, b store.Mailbox) int {
+				for _, cmp := range args.Sort {
+					var v int
+					if cmp.Property == "name" {
+						v = strings.Compare(strings.ToLower(mailboxName(a)), strings.ToLower(mailboxName(b)))
+					}
+					if !cmp.ascending() {
+						v = -v
+					}
+					if v != 0 {
+						return v
+					}
+				}
+				return int(a.ID - b.ID)
+			}
+			if args.SortAsTree {
+				// Full names sort parents before children. We sort on the path elements
+				// so siblings are ordered by the sort criteria.
+				path := func(mb store.Mailbox) []store.Mailbox {
+					var l []store.Mailbox
+					for {
+						l = append([]store.Mailbox{mb}, l...)
+						pmb, ok := byID[mb.ParentID]
+						if mb.ParentID == 0 || !ok {
+							return l
+						}
+						mb = pmb
+					}
+				}
+				slices.SortFunc(mbl, func(a, b store.Mailbox) int {
+					pa, pb := path(a), path(b)
+					for i := 0; i < len(pa) && i < len(pb); i++ {
+						if pa[i].ID != pb[i].ID {
+							return compare(pa[i], pb[i])
+						}
+					}
+					return len(pa) - len(pb)
+				})
+			} else {
+				slices.SortFunc(mbl, compare)
+			}
+			for _, mb := range mbl {
+				ids = append(ids, mailboxID(mb.ID))
+			}
+		})
+	})
+	return c.xqueryResult(args.queryArgs, state, ids)
+}
+
+// mailboxSetArgs are the arguments for Mailbox/set. ../rfc/8621:825
+type mailboxSetArgs struct {
+	setArgs
+	OnDestroyRemoveEmails bool `json:"onDestroyRemoveEmails"`
+}
+
+// Properties of a mailbox that can be set in create or update.
+type mailboxSettable struct {
+	Name         *string    `json:"name"`
+	ParentID     optionalID `json:"parentId"`
+	Role         optionalID `json:"role"`
+	SortOrder    *int       `json:"sortOrder"`
+	IsSubscribed *bool      `json:"isSubscribed"`
+}
+
+// xparseMailboxSettable parses the create object or update patch of a mailbox.
+func xparseMailboxSettable(raw json.RawMessage) mailboxSettable {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		xsetErrorf("invalidProperties", "parsing mailbox: %v", err)
+	}
+	for k := range m {
+		if !slices.Contains([]string{"name", "parentId", "role", "sortOrder", "isSubscribed"}, k) {
+			if slices.Contains(mailboxProperties, k) {
+				xinvalidPropertiesf(k, "property %q cannot be set", k)
+			}
+			xinvalidPropertiesf(k, "unknown property %q", k)
+		}
+	}
+	var ms mailboxSettable
+	if err := json.Unmarshal(raw, &ms); err != nil {
+		xsetErrorf("invalidProperties", "parsing mailbox: %v", err)
+	}
+	if ms.SortOrder != nil && *ms.SortOrder != 0 {
+		xinvalidPropertiesf("sortOrder", "only sortOrder 0 is supported")
+	}
+	return ms
+}
+
+// xmailboxFullName returns the full mailbox name for the name and parent.
+func (c *call) xmailboxFullName(tx *bstore.Tx, name string, parentID *string) string {
+	if name == "" || strings.Contains(name, "/") {
+		xinvalidPropertiesf("name", "name must be non-empty and cannot contain a slash")
+	}
+	if len(name) > maxSizeMailboxName {
+		xinvalidPropertiesf("name", "name too long")
+	}
+	full := name
+	if parentID != nil {
+		pmb := xmailboxProperty(tx, "parentId", c.xresolveSetID(*parentID))
+		full = pmb.Name + "/" + name
+	}
+	full, _, err := store.CheckMailboxName(full, true)
+	if err != nil {
+		xinvalidPropertiesf("name", "%v", err)
+	}
+	return full
+}
+
+// xresolveSetID resolves a creation id, returning a setError instead of a method
+// error for unknown references.
+func (c *call) xresolveSetID(id string) string {
+	if strings.HasPrefix(id, "#") {
+		v, ok := c.createdIDs[id[1:]]
+		if !ok {
+			xsetErrorf("invalidProperties", "unknown creation id %q", id)
+		}
+		return v
+	}
+	return id
+}
+
+// xmailboxProperty returns the mailbox for id, with an invalidProperties
+// setError for the property if it does not exist.
+func xmailboxProperty(tx *bstore.Tx, property, id string) store.Mailbox {
+	var mb store.Mailbox
+	if serr := xsetItem(func() { mb = xmailbox(tx, id) }); serr != nil {
+		xinvalidPropertiesf(property, "unknown mailbox %q", id)
+	}
+	return mb
+}
+
+// xsetSpecialUse sets the special-use flags on mailbox mb, clearing them from
+// other mailboxes.
+func (c *call) xsetSpecialUse(tx *bstore.Tx, mb *store.Mailbox, su store.SpecialUse, modseq store.ModSeq) []store.Change {
+	var changes []store.Change
+	clearPrevious := func(clear bool, specialUse string) {
+		if !clear {
+			return
+		}
+		var ombl []store.Mailbox
+		q := bstore.QueryTx[store.Mailbox](tx)
+		q.FilterNotEqual("ID", mb.ID)
+		q.FilterEqual(specialUse, true)
+		q.Gather(&ombl)
+		_, err := q.UpdateFields(map[string]any{specialUse: false, "ModSeq": modseq})
+		xcheckf(err, "updating previous special-use mailboxes")
+		for _, omb := range ombl {
+			omb.ModSeq = modseq
+			changes = append(changes, omb.ChangeSpecialUse())
+		}
+	}
+	clearPrevious(su.Archive, "Archive")
+	clearPrevious(su.Draft, "Draft")
+	clearPrevious(su.Junk, "Junk")
+	clearPrevious(su.Sent, "Sent")
+	clearPrevious(su.Trash, "Trash")
+
+	mb.SpecialUse = su
+	mb.ModSeq = modseq
+	err := tx.Update(mb)
+	xcheckf(err, "updating special-use flags for mailbox")
+	return append(changes, mb.ChangeSpecialUse())
+}
+
+func (c *call) mailboxSet(args mailboxSetArgs) setResult {
+	c.xaccount(args.AccountID)
+
+	var r setResult
+	r.AccountID = args.AccountID
+
+	c.acc.WithWLock(func() {
+		var changes []store.Change
+
+		xdbwrite(c.ctx, c.acc, func(tx *bstore.Tx) {
+			oldState := modseqState(xlastModSeq(tx))
+			c.xcheckSet(args.setArgs, oldState)
+			r.OldState = &oldState
+
+			// Create mailboxes, in order of creation ids so references to parents can be
+			// resolved, with parents typically created first.
+			creationIDs := make([]string, 0, len(args.Create))
+			for cid := range args.Create {
+				creationIDs = append(creationIDs, cid)
+			}
+			slices.Sort(creationIDs)
+			for _, cid := range creationIDs {
+				serr := xsetItem(func() {
+					ms := xparseMailboxSettable(args.Create[cid])
+					if ms.Name == nil {
+						xinvalidPropertiesf("name", "missing name")
+					}
+					name := c.xmailboxFullName(tx, *ms.Name, ms.ParentID.Value)
+					var su store.SpecialUse
+					if ms.Role.Set {
+						su = xspecialUseForRole(ms.Role.Value, name == "Inbox")
+					}
+					mb, nchanges, _, exists, err := c.acc.MailboxCreate(tx, name, su)
+					if exists {
+						xsetErrorf("invalidProperties", "mailbox with name already exists")
+					}
+					xcheckf(err, "creating mailbox")
+					changes = append(changes, nchanges...)
+
+					// MailboxCreate subscribes new mailboxes.
+					subscribed := true
+					if ms.IsSubscribed != nil && !*ms.IsSubscribed {
+						err := tx.Delete(&store.Subscription{Name: mb.Name})
+						xcheckf(err, "removing subscription")
+						changes = append(changes, store.ChangeRemoveSubscription{MailboxName: mb.Name})
+						subscribed = false
+					}
+
+					c.createdIDs[cid] = mailboxID(mb.ID)
+					r.addCreated(cid, mailboxObject(mb, subscribed, threadCounts{}, map[string]bool{"id": true, "sortOrder": true, "totalEmails": true, "unreadEmails": true, "totalThreads": true, "unreadThreads": true, "myRights": true, "isSubscribed": true}))
+				})
+				if serr != nil {
+					r.addNotCreated(cid, serr)
+				}
+			}
+
+			for id, raw := range args.Update {
+				var rawPatch json.RawMessage
+				rawPatch, err := json.Marshal(raw)
+				xcheckf(err, "marshal patch")
+				serr := xsetItem(func() {
+					mb := xmailbox(tx, c.xresolveSetID(id))
+					ms := xparseMailboxSettable(rawPatch)
+
+					// Validate all properties before making changes.
+					newName := mb.Name
+					if ms.Name != nil || ms.ParentID.Set {
+						name := mailboxName(mb)
+						if ms.Name != nil {
+							name = *ms.Name
+						}
+						parentID := ms.ParentID.Value
+						if !ms.ParentID.Set && mb.ParentID != 0 {
+							s := mailboxID(mb.ParentID)
+							parentID = &s
+						}
+						newName = c.xmailboxFullName(tx, name, parentID)
+						if newName != mb.Name {
+							if mb.Name == "Inbox" {
+								xsetErrorf("forbidden", "inbox cannot be renamed")
+							}
+							if strings.HasPrefix(newName, mb.Name+"/") {
+								xinvalidPropertiesf("parentId", "mailbox cannot be moved into itself")
+							}
+							if exists, err := c.acc.MailboxExists(tx, newName); err != nil {
+								xcheckf(err, "checking if mailbox exists")
+							} else if exists {
+								xinvalidPropertiesf("name", "mailbox with name already exists")
+							}
+						}
+					}
+					su := mb.SpecialUse
+					if ms.Role.Set {
+						su = xspecialUseForRole(ms.Role.Value, mb.Name == "Inbox")
+					}
+
+					var modseq store.ModSeq
+					if newName != mb.Name {
+						nchanges, _, _, err := c.acc.MailboxRename(tx, &mb, newName, &modseq)
+						xcheckf(err, "renaming mailbox")
+						changes = append(changes, nchanges...)
+					}
+					if su != mb.SpecialUse {
+						if modseq == 0 {
+							var err error
+							modseq, err = c.acc.NextModSeq(tx)
+							xcheckf(err, "next modseq")
+						}
+						changes = append(changes, c.xsetSpecialUse(tx, &mb, su, modseq)...)
+					}
+					if ms.IsSubscribed != nil && *ms.IsSubscribed != xsubscribed(tx, mb.Name) {
+						if *ms.IsSubscribed {
+							nchanges, err := c.acc.SubscriptionEnsure(tx, mb.Name)
+							xcheckf(err, "adding subscription")
+							changes = append(changes, nchanges...)
+						} else {
+							err := tx.Delete(&store.Subscription{Name: mb.Name})
+							xcheckf(err, "removing subscription")
+							changes = append(changes, store.ChangeRemoveSubscription{MailboxName: mb.Name})
+						}
+						// Subscriptions are not stored in the mailbox, but JMAP clients need to
+						// see the change.
+						if modseq == 0 {
+							var err error
+							modseq, err = c.acc.NextModSeq(tx)
+							xcheckf(err, "next modseq")
+						}
+						mb.ModSeq = modseq
+						err := tx.Update(&mb)
+						xcheckf(err, "updating mailbox modseq")
+					}
+					r.addUpdated(id, nil)
+				})
+				if serr != nil {
+					r.addNotUpdated(id, serr)
+				}
+			}
+
+			for _, id := range args.Destroy {
+				serr := xsetItem(func() {
+					mb := xmailbox(tx, c.xresolveSetID(id))
+					if mb.Name == "Inbox" {
+						xsetErrorf("forbidden", "inbox cannot be removed")
+					}
+					if !args.OnDestroyRemoveEmails && mb.Total+mb.Deleted > 0 {
+						xsetErrorf("mailboxHasEmail", "mailbox has messages")
+					}
+					nchanges, hasChildren, err := c.acc.MailboxDelete(c.ctx, c.log, tx, &mb)
+					if hasChildren {
+						xsetErrorf("mailboxHasChild", "mailbox has child mailboxes")
+					}
+					xcheckf(err, "removing mailbox")
+					changes = append(changes, nchanges...)
+					r.Destroyed = append(r.Destroyed, id)
+				})
+				if serr != nil {
+					r.addNotDestroyed(id, serr)
+				}
+			}
+
+			r.NewState = modseqState(xlastModSeq(tx))
+		})
+
+		store.BroadcastChanges(c.acc, changes)
+	})
+	return r
+}
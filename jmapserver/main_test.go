@@ -0,0 +1,17 @@
+package jmapserver
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mjl-/mox/metrics"
+)
+
+func TestMain(m *testing.M) {
+	m.Run()
+	if metrics.Panics.Load() > 0 {
+		fmt.Println("unhandled panics encountered")
+		os.Exit(2)
+	}
+}
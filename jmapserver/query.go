@@ -0,0 +1,365 @@
+package jmapserver
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/store"
+)
+
+// Sort properties supported for Email/query. ../rfc/8621:2290
+var emailSortProperties = []string{
+	"receivedAt",
+	"sentAt",
+	"size",
+	"from",
+	"to",
+	"subject",
+	"hasKeyword",
+	"allInThreadHaveKeyword",
+	"someInThreadHaveKeyword",
+}
+
+// Email/query filter condition. ../rfc/8621:2130
+type emailFilter struct {
+	InMailbox               *string  `json:"inMailbox"`
+	InMailboxOtherThan      []string `json:"inMailboxOtherThan"`
+	Before                  *string  `json:"before"`
+	After                   *string  `json:"after"`
+	MinSize                 *int64   `json:"minSize"`
+	MaxSize                 *int64   `json:"maxSize"`
+	AllInThreadHaveKeyword  *string  `json:"allInThreadHaveKeyword"`
+	SomeInThreadHaveKeyword *string  `json:"someInThreadHaveKeyword"`
+	NoneInThreadHaveKeyword *string  `json:"noneInThreadHaveKeyword"`
+	HasKeyword              *string  `json:"hasKeyword"`
+	NotKeyword              *string  `json:"notKeyword"`
+	HasAttachment           *bool    `json:"hasAttachment"`
+	Text                    *string  `json:"text"`
+	From                    *string  `json:"from"`
+	To                      *string  `json:"to"`
+	Cc                      *string  `json:"cc"`
+	Bcc                     *string  `json:"bcc"`
+	Subject                 *string  `json:"subject"`
+	Body                    *string  `json:"body"`
+	Header                  []string `json:"header"`
+}
+
+// Email/query arguments. ../rfc/8621:2110
+type emailQueryArgs struct {
+	queryArgs
+	CollapseThreads bool `json:"collapseThreads"`
+}
+
+// queryMessage is a message evaluated for a query, with lazily loaded data for
+// matching and sorting.
+type queryMessage struct {
+	ed       *emailData
+	envelope *message.Envelope
+	loaded   bool
+}
+
+// xenvelope returns the envelope from the parsed message, possibly nil.
+func (qm *queryMessage) xenvelope() *message.Envelope {
+	if !qm.loaded {
+		qm.loaded = true
+		if qm.ed.m.ParsedBuf != nil {
+			var p message.Part
+			err := json.Unmarshal(qm.ed.m.ParsedBuf, &p)
+			xcheckf(err, "parsing message structure")
+			qm.envelope = p.Envelope
+		}
+	}
+	return qm.envelope
+}
+
+// hasKeyword returns whether the message has the keyword.
+func hasKeyword(m store.Message, kw string) bool {
+	return emailKeywords(m)[strings.ToLower(kw)]
+}
+
+func containsFold(s, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+func addressesText(l []message.Address) string {
+	var t []string
+	for _, a := range l {
+		t = append(t, a.Name+" <"+a.User+"@"+a.Host+">")
+	}
+	return strings.Join(t, ", ")
+}
+
+// xbodyContains returns whether the text of the body parts contains s.
+func (qm *queryMessage) xbodyContains(s string) bool {
+	ed := qm.ed
+	ed.xstructure()
+	var parts []*bodyPart
+	parts = append(parts, ed.textBody...)
+	parts = append(parts, ed.htmlBody...)
+	seen := map[string]bool{}
+	for _, bp := range parts {
+		if seen[bp.partID] || !strings.HasPrefix(bp.typ, "text/") {
+			continue
+		}
+		seen[bp.partID] = true
+		buf, err := io.ReadAll(bp.part.ReaderUTF8OrBinary())
+		if err != nil {
+			ed.c.log.Debugx("reading part for search, ignoring", err)
+		}
+		if containsFold(string(buf), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// xparseUTCDate parses a date in a filter.
+func xparseUTCDate(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		xinvalidArgumentsf("parsing date %q: %v", s, err)
+	}
+	return t
+}
+
+func (c *call) emailQuery(args emailQueryArgs) queryResult {
+	c.xaccount(args.AccountID)
+	f := xparseFilter[emailFilter](args.Filter)
+	f.walk(func(fc emailFilter) {
+		if fc.Before != nil {
+			xparseUTCDate(*fc.Before)
+		}
+		if fc.After != nil {
+			xparseUTCDate(*fc.After)
+		}
+		if len(fc.Header) > 2 || fc.Header != nil && len(fc.Header) == 0 {
+			xmethodErrorf("unsupportedFilter", "header filter must have 1 or 2 elements")
+		}
+	})
+	for _, cmp := range args.Sort {
+		if !slices.Contains(emailSortProperties, cmp.Property) {
+			xmethodErrorf("unsupportedSort", "unsupported sort property %q", cmp.Property)
+		}
+		if strings.HasSuffix(cmp.Property, "eyword") && cmp.Keyword == "" {
+			xmethodErrorf("unsupportedSort", "missing keyword for sort property %q", cmp.Property)
+		}
+	}
+
+	var ids []string
+	var state string
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			state = modseqState(xlastModSeq(tx))
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterEqual("Deleted", false)
+			// Common case: only messages from a single mailbox.
+			if f != nil && f.operator == "" && f.cond.InMailbox != nil {
+				mbID := parseID('m', c.xresolveID(*f.cond.InMailbox))
+				q.FilterNonzero(store.Message{MailboxID: mbID})
+			}
+			msgs, err := q.List()
+			xcheckf(err, "listing messages")
+
+			// For thread keyword conditions and sorts.
+			threads := map[int64][]store.Message{}
+			for _, m := range msgs {
+				tid := threadIDOf(m)
+				threads[tid] = append(threads[tid], m)
+			}
+			threadKeyword := func(m store.Message, kw string, all bool) bool {
+				for _, tm := range threads[threadIDOf(m)] {
+					if hasKeyword(tm, kw) != all {
+						return !all
+					}
+				}
+				return all
+			}
+
+			qml := make([]*queryMessage, len(msgs))
+			for i, m := range msgs {
+				qml[i] = &queryMessage{ed: &emailData{c: c, m: m}}
+			}
+			defer func() {
+				for _, qm := range qml {
+					qm.ed.close()
+				}
+			}()
+
+			matchCond := func(qm *queryMessage, fc emailFilter) bool {
+				m := qm.ed.m
+				if fc.InMailbox != nil && mailboxID(m.MailboxID) != c.xresolveID(*fc.InMailbox) {
+					return false
+				}
+				for _, id := range fc.InMailboxOtherThan {
+					if mailboxID(m.MailboxID) == c.xresolveID(id) {
+						return false
+					}
+				}
+				if fc.Before != nil && !m.Received.Before(xparseUTCDate(*fc.Before)) {
+					return false
+				}
+				if fc.After != nil && m.Received.Before(xparseUTCDate(*fc.After)) {
+					return false
+				}
+				if fc.MinSize != nil && m.Size < *fc.MinSize {
+					return false
+				}
+				if fc.MaxSize != nil && m.Size >= *fc.MaxSize {
+					return false
+				}
+				if fc.AllInThreadHaveKeyword != nil && !threadKeyword(m, *fc.AllInThreadHaveKeyword, true) {
+					return false
+				}
+				if fc.SomeInThreadHaveKeyword != nil && !threadKeyword(m, *fc.SomeInThreadHaveKeyword, false) {
+					return false
+				}
+				if fc.NoneInThreadHaveKeyword != nil && threadKeyword(m, *fc.NoneInThreadHaveKeyword, false) {
+					return false
+				}
+				if fc.HasKeyword != nil && !hasKeyword(m, *fc.HasKeyword) {
+					return false
+				}
+				if fc.NotKeyword != nil && hasKeyword(m, *fc.NotKeyword) {
+					return false
+				}
+				if fc.HasAttachment != nil {
+					qm.ed.xstructure()
+					if *fc.HasAttachment != (len(qm.ed.attachments) > 0) {
+						return false
+					}
+				}
+				env := qm.xenvelope()
+				if env == nil {
+					env = &message.Envelope{}
+				}
+				if fc.From != nil && !containsFold(addressesText(env.From), *fc.From) {
+					return false
+				}
+				if fc.To != nil && !containsFold(addressesText(env.To), *fc.To) {
+					return false
+				}
+				if fc.Cc != nil && !containsFold(addressesText(env.CC), *fc.Cc) {
+					return false
+				}
+				if fc.Bcc != nil && !containsFold(addressesText(env.BCC), *fc.Bcc) {
+					return false
+				}
+				if fc.Subject != nil && !containsFold(env.Subject, *fc.Subject) {
+					return false
+				}
+				if fc.Text != nil {
+					s := *fc.Text
+					if !containsFold(addressesText(slices.Concat(env.From, env.To, env.CC, env.BCC)), s) && !containsFold(env.Subject, s) && !qm.xbodyContains(s) {
+						return false
+					}
+				}
+				if fc.Body != nil && !qm.xbodyContains(*fc.Body) {
+					return false
+				}
+				if fc.Header != nil {
+					var found bool
+					for _, h := range qm.ed.xheaders() {
+						if strings.EqualFold(h.Name, fc.Header[0]) && (len(fc.Header) == 1 || containsFold(headerText(h.Value), fc.Header[1])) {
+							found = true
+							break
+						}
+					}
+					if !found {
+						return false
+					}
+				}
+				return true
+			}
+			qml = slices.DeleteFunc(qml, func(qm *queryMessage) bool {
+				if !f.match(func(fc emailFilter) bool { return matchCond(qm, fc) }) {
+					qm.ed.close()
+					return true
+				}
+				return false
+			})
+
+			sorts := args.Sort
+			if len(sorts) == 0 {
+				asc := false
+				sorts = []comparator{{Property: "receivedAt", IsAscending: &asc}}
+			}
+			firstAddress := func(l []message.Address) string {
+				if len(l) == 0 {
+					return ""
+				}
+				if l[0].Name != "" {
+					return strings.ToLower(l[0].Name)
+				}
+				return strings.ToLower(l[0].User + "@" + l[0].Host)
+			}
+			envelope := func(qm *queryMessage) *message.Envelope {
+				if env := qm.xenvelope(); env != nil {
+					return env
+				}
+				return &message.Envelope{}
+			}
+			boolCompare := func(a, b bool) int {
+				if a == b {
+					return 0
+				} else if a {
+					return 1
+				}
+				return -1
+			}
+			slices.SortStableFunc(qml, func(a, b *queryMessage) int {
+				ma, mb := a.ed.m, b.ed.m
+				for _, cmp := range sorts {
+					var v int
+					switch cmp.Property {
+					case "receivedAt":
+						v = ma.Received.Compare(mb.Received)
+					case "sentAt":
+						v = envelope(a).Date.Compare(envelope(b).Date)
+					case "size":
+						v = int(ma.Size - mb.Size)
+					case "from":
+						v = strings.Compare(firstAddress(envelope(a).From), firstAddress(envelope(b).From))
+					case "to":
+						v = strings.Compare(firstAddress(envelope(a).To), firstAddress(envelope(b).To))
+					case "subject":
+						v = strings.Compare(strings.ToLower(ma.SubjectBase), strings.ToLower(mb.SubjectBase))
+					case "hasKeyword":
+						v = boolCompare(hasKeyword(ma, cmp.Keyword), hasKeyword(mb, cmp.Keyword))
+					case "allInThreadHaveKeyword":
+						v = boolCompare(threadKeyword(ma, cmp.Keyword, true), threadKeyword(mb, cmp.Keyword, true))
+					case "someInThreadHaveKeyword":
+						v = boolCompare(threadKeyword(ma, cmp.Keyword, false), threadKeyword(mb, cmp.Keyword, false))
+					}
+					if !cmp.ascending() {
+						v = -v
+					}
+					if v != 0 {
+						return v
+					}
+				}
+				return int(ma.ID - mb.ID)
+			})
+
+			seenThreads := map[int64]bool{}
+			for _, qm := range qml {
+				if args.CollapseThreads {
+					tid := threadIDOf(qm.ed.m)
+					if seenThreads[tid] {
+						continue
+					}
+					seenThreads[tid] = true
+				}
+				ids = append(ids, emailID(qm.ed.m.ID))
+			}
+		})
+	})
+	return c.xqueryResult(args.queryArgs, state, ids)
+}
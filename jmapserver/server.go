@@ -0,0 +1,1385 @@
+// Package jmapserver implements a JMAP server, for email clients, with the core
+// protocol of ../rfc/8620 and mail of ../rfc/8621.
+//
+// JMAP objects map onto the account database: Mailbox and Email are
+// store.Mailbox and store.Message, a Thread is the set of messages with the same
+// store.Message.ThreadID, EmailSubmission is store.EmailSubmission and
+// Identities are the addresses configured for the account. The state strings for
+// the objects stored in the database are the account-wide modseq, as also used by
+// IMAP CONDSTORE/QRESYNC, so changes can be calculated from the ModSeq and
+// CreateSeq fields. Push notifications are sent based on the changes broadcast
+// through store.Comm.
+//
+// A message is always in exactly one mailbox, so an Email has exactly one entry
+// in mailboxIds. Messages with the IMAP \Deleted flag are not visible through
+// JMAP.
+package jmapserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+var pkglog = mlog.New("jmap", nil)
+
+var (
+	// Similar between ../webmail/webmail.go:/metricSubmission and ../smtpserver/server.go:/metricSubmission and ../webapisrv/server.go:/metricSubmission
+	metricSubmission = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_jmap_submission_total",
+			Help: "JMAP message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, queueerror, domaindisabled.",
+		},
+		[]string{
+			"result",
+		},
+	)
+	metricServerErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_jmap_errors_total",
+			Help: "JMAP server errors, known values: dkimsign.",
+		},
+		[]string{
+			"error",
+		},
+	)
+	metricResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_jmap_method_results_total",
+			Help: "JMAP method call results by method and result.",
+		},
+		[]string{"method", "result"}, // result: "ok" or error type.
+	)
+	metricDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_jmap_request_duration_seconds",
+			Help:    "JMAP request duration by endpoint.",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{"endpoint"}, // session, api, upload, download.
+	)
+)
+
+// Capabilities we implement. ../rfc/8620:476 ../rfc/8621:210
+const (
+	capCore       = "urn:ietf:params:jmap:core"
+	capMail       = "urn:ietf:params:jmap:mail"
+	capSubmission = "urn:ietf:params:jmap:submission"
+)
+
+// Limits, announced in the session resource.
+const (
+	maxSizeRequest        = 10 * 1024 * 1024
+	maxConcurrentUpload   = 4
+	maxConcurrentRequests = 8
+	maxCallsInRequest     = 64
+	maxObjectsInGet       = 1000
+	maxObjectsInSet       = 1000
+	maxSizeMailboxName    = 255
+)
+
+// NewServer returns a new http.Handler for a JMAP server. The handler expects the
+// path prefix to be stripped from requests.
+func NewServer(maxMsgSize int64, path string, isForwarded bool) http.Handler {
+	return server{maxMsgSize, path, isForwarded}
+}
+
+// server implements the JMAP endpoints.
+type server struct {
+	maxMsgSize  int64  // Of outgoing messages and uploads.
+	path        string // Path JMAP is configured under, typically /jmap/.
+	isForwarded bool   // Whether incoming requests are reverse-proxied. Used for remote IPs and URLs in the session resource.
+}
+
+// Number of requests and uploads in progress, per account.
+var concurrency = struct {
+	sync.Mutex
+	requests map[string]int
+	uploads  map[string]int
+}{requests: map[string]int{}, uploads: map[string]int{}}
+
+// concurrencyAdd registers a request or upload in progress for the account,
+// returning false if the limit has been reached. The returned function must be
+// called when the request is done.
+func concurrencyAdd(m map[string]int, accName string, limit int) (done func(), ok bool) {
+	concurrency.Lock()
+	defer concurrency.Unlock()
+	if m[accName] >= limit {
+		return nil, false
+	}
+	m[accName]++
+	return func() {
+		concurrency.Lock()
+		defer concurrency.Unlock()
+		m[accName]--
+		if m[accName] == 0 {
+			delete(m, accName)
+		}
+	}, true
+}
+
+// ServeHTTP implements http.Handler.
+func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := pkglog.WithContext(r.Context()) // Take cid from webserver.
+
+	// Browser-based clients are typically served from another origin. Authentication
+	// is through the Authorization header, not cookies, so allowing any origin is
+	// safe. ../rfc/8620:3929
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "OPTIONS" {
+		h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept, Last-Event-ID")
+		h.Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var endpoint string
+	var method string
+	p := r.URL.Path
+	switch {
+	case p == "/session":
+		endpoint, method = "session", "GET"
+	case p == "/api":
+		endpoint, method = "api", "POST"
+	case strings.HasPrefix(p, "/download/"):
+		endpoint, method = "download", "GET"
+	case strings.HasPrefix(p, "/upload/"):
+		endpoint, method = "upload", "POST"
+	case p == "/eventsource":
+		endpoint, method = "eventsource", "GET"
+	case p == "/":
+		http.Redirect(w, r, s.path+"session", http.StatusSeeOther)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method && !(method == "GET" && r.Method == "HEAD") {
+		http.Error(w, "405 - method not allowed - use "+strings.ToLower(method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	t0 := time.Now()
+	defer func() {
+		metricDuration.WithLabelValues(endpoint).Observe(float64(time.Since(t0)) / float64(time.Second))
+	}()
+
+	acc, loginAddress, ok := s.authenticate(log, w, r)
+	if !ok {
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	log = log.With(slog.String("account", acc.Name))
+
+	switch endpoint {
+	case "session":
+		s.serveSession(log, w, r, acc, loginAddress)
+	case "api":
+		done, ok := concurrencyAdd(concurrency.requests, acc.Name, maxConcurrentRequests)
+		if !ok {
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:limit", "maxConcurrentRequests", "too many concurrent requests")
+			return
+		}
+		defer done()
+		s.serveAPI(log, w, r, acc, loginAddress)
+	case "download":
+		s.serveDownload(log, w, r, acc)
+	case "upload":
+		done, ok := concurrencyAdd(concurrency.uploads, acc.Name, maxConcurrentUpload)
+		if !ok {
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:limit", "maxConcurrentUpload", "too many concurrent uploads")
+			return
+		}
+		defer done()
+		s.serveUpload(log, w, r, acc)
+	case "eventsource":
+		s.serveEventSource(log, w, r, acc)
+	}
+}
+
+// authenticate verifies the HTTP basic authentication credentials of the request.
+// If authentication fails, a response is written and ok is false. Otherwise the
+// caller must close the account.
+func (s server) authenticate(log mlog.Log, w http.ResponseWriter, r *http.Request) (acc *store.Account, loginAddress string, ok bool) {
+	email, password, aok := r.BasicAuth()
+	if !aok {
+		log.Debug("missing http basic authentication credentials")
+		w.Header().Set("WWW-Authenticate", "Basic realm=jmap")
+		http.Error(w, "401 - unauthorized - use http basic auth with email address as username", http.StatusUnauthorized)
+		return nil, "", false
+	}
+
+	t0 := time.Now()
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	remoteIP := webauth.RemoteIP(log, s.isForwarded, r)
+	if remoteIP == nil {
+		log.Debug("cannot find remote ip for rate limiter")
+		http.Error(w, "500 - internal server error - cannot find remote ip", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if !mox.LimiterFailedAuth.CanAdd(remoteIP, t0, 1) {
+		metrics.AuthenticationRatelimitedInc("jmap")
+		log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", remoteIP))
+		http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
+		return nil, "", false
+	}
+
+	la := store.LoginAttempt{
+		RemoteIP:     remoteIP.String(),
+		TLS:          store.LoginAttemptTLS(r.TLS),
+		Protocol:     "jmap",
+		AuthMech:     "httpbasic",
+		UserAgent:    r.UserAgent(),
+		LoginAddress: email,
+		Result:       store.AuthError,
+	}
+	defer func() {
+		store.LoginAttemptAdd(context.Background(), log, la)
+	}()
+
+	var err error
+	acc, la.AccountName, err = store.OpenEmailAuth(log, email, password, true)
+	if err != nil {
+		mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+		if errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, store.ErrUnknownCredentials) || errors.Is(err, store.ErrLoginDisabled) {
+			log.Debug("bad http basic authentication credentials")
+			la.Result = store.AuthBadCredentials
+			msg := "use http basic auth with email address as username"
+			if errors.Is(err, store.ErrLoginDisabled) {
+				la.Result = store.AuthLoginDisabled
+				msg = "login is disabled for this account"
+			}
+			w.Header().Set("WWW-Authenticate", "Basic realm=jmap")
+			http.Error(w, "401 - unauthorized - "+msg, http.StatusUnauthorized)
+			return nil, "", false
+		}
+		log.Errorx("verifying credentials", err)
+		http.Error(w, "500 - internal server error - verifying credentials", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	la.AccountName = acc.Name
+	la.Result = store.AuthSuccess
+	mox.LimiterFailedAuth.Reset(remoteIP, t0)
+	return acc, email, true
+}
+
+// writeProblem writes a request-level error as problem details JSON.
+// ../rfc/8620:1090 ../rfc/7807
+func writeProblem(w http.ResponseWriter, status int, typ, limit, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	v := struct {
+		Type   string `json:"type"`
+		Status int    `json:"status"`
+		Limit  string `json:"limit,omitempty"`
+		Detail string `json:"detail"`
+	}{typ, status, limit, detail}
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSON writes v as JSON response.
+func writeJSON(log mlog.Log, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	log.Check(err, "writing json response")
+}
+
+// baseURL returns the absolute URL JMAP is served at, ending with a slash.
+func (s server) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if s.isForwarded {
+		if v := r.Header.Get("X-Forwarded-Proto"); v == "http" || v == "https" {
+			scheme = v
+		}
+		if v := r.Header.Get("X-Forwarded-Host"); v != "" {
+			host = v
+		}
+	}
+	return scheme + "://" + host + s.path
+}
+
+// Session resource, for discovery of capabilities, accounts and URLs.
+// ../rfc/8620:548
+type session struct {
+	Capabilities    map[string]any            `json:"capabilities"`
+	Accounts        map[string]sessionAccount `json:"accounts"`
+	PrimaryAccounts map[string]string         `json:"primaryAccounts"`
+	Username        string                    `json:"username"`
+	APIURL          string                    `json:"apiUrl"`
+	DownloadURL     string                    `json:"downloadUrl"`
+	UploadURL       string                    `json:"uploadUrl"`
+	EventSourceURL  string                    `json:"eventSourceUrl"`
+	State           string                    `json:"state"`
+}
+
+type sessionAccount struct {
+	Name                string         `json:"name"`
+	IsPersonal          bool           `json:"isPersonal"`
+	IsReadOnly          bool           `json:"isReadOnly"`
+	AccountCapabilities map[string]any `json:"accountCapabilities"`
+}
+
+type coreCapabilities struct {
+	MaxSizeUpload         int64    `json:"maxSizeUpload"`
+	MaxConcurrentUpload   int      `json:"maxConcurrentUpload"`
+	MaxSizeRequest        int64    `json:"maxSizeRequest"`
+	MaxConcurrentRequests int      `json:"maxConcurrentRequests"`
+	MaxCallsInRequest     int      `json:"maxCallsInRequest"`
+	MaxObjectsInGet       int      `json:"maxObjectsInGet"`
+	MaxObjectsInSet       int      `json:"maxObjectsInSet"`
+	CollationAlgorithms   []string `json:"collationAlgorithms"`
+}
+
+type mailCapabilities struct {
+	MaxMailboxesPerEmail       int      `json:"maxMailboxesPerEmail"`
+	MaxMailboxDepth            *int     `json:"maxMailboxDepth"`
+	MaxSizeMailboxName         int      `json:"maxSizeMailboxName"`
+	MaxSizeAttachmentsPerEmail int64    `json:"maxSizeAttachmentsPerEmail"`
+	EmailQuerySortOptions      []string `json:"emailQuerySortOptions"`
+	MayCreateTopLevelMailbox   bool     `json:"mayCreateTopLevelMailbox"`
+}
+
+type submissionCapabilities struct {
+	MaxDelayedSend       int                 `json:"maxDelayedSend"`
+	SubmissionExtensions map[string][]string `json:"submissionExtensions"`
+}
+
+func (s server) makeSession(r *http.Request, acc *store.Account, loginAddress string) session {
+	base := s.baseURL(r)
+	accountCaps := map[string]any{
+		capMail: mailCapabilities{
+			MaxMailboxesPerEmail:       1,
+			MaxSizeMailboxName:         maxSizeMailboxName,
+			MaxSizeAttachmentsPerEmail: s.maxMsgSize,
+			EmailQuerySortOptions:      emailSortProperties,
+			MayCreateTopLevelMailbox:   true,
+		},
+		capSubmission: submissionCapabilities{
+			MaxDelayedSend:       int(queue.FutureReleaseIntervalMax / time.Second),
+			SubmissionExtensions: map[string][]string{"FUTURERELEASE": {fmt.Sprintf("%d", int(queue.FutureReleaseIntervalMax/time.Second))}},
+		},
+	}
+	sess := session{
+		Capabilities: map[string]any{
+			capCore: coreCapabilities{
+				MaxSizeUpload:         s.maxMsgSize,
+				MaxConcurrentUpload:   maxConcurrentUpload,
+				MaxSizeRequest:        maxSizeRequest,
+				MaxConcurrentRequests: maxConcurrentRequests,
+				MaxCallsInRequest:     maxCallsInRequest,
+				MaxObjectsInGet:       maxObjectsInGet,
+				MaxObjectsInSet:       maxObjectsInSet,
+				CollationAlgorithms:   []string{"i;ascii-casemap", "i;unicode-casemap"},
+			},
+			capMail:       struct{}{},
+			capSubmission: struct{}{},
+		},
+		Accounts: map[string]sessionAccount{
+			acc.Name: {
+				Name:                loginAddress,
+				IsPersonal:          true,
+				AccountCapabilities: accountCaps,
+			},
+		},
+		PrimaryAccounts: map[string]string{
+			capMail:       acc.Name,
+			capSubmission: acc.Name,
+		},
+		Username:       loginAddress,
+		APIURL:         base + "api",
+		DownloadURL:    base + "download/{accountId}/{blobId}/{name}?accept={type}",
+		UploadURL:      base + "upload/{accountId}/",
+		EventSourceURL: base + "eventsource?types={types}&closeafter={closeafter}&ping={ping}",
+	}
+	// The state changes when any of the information in the session changes.
+	buf, err := json.Marshal(sess)
+	if err != nil {
+		panic(fmt.Sprintf("marshal session: %v", err))
+	}
+	sum := sha256.Sum256(buf)
+	sess.State = base64.RawURLEncoding.EncodeToString(sum[:12])
+	return sess
+}
+
+func (s server) serveSession(log mlog.Log, w http.ResponseWriter, r *http.Request, acc *store.Account, loginAddress string) {
+	writeJSON(log, w, s.makeSession(r, acc, loginAddress))
+}
+
+// Request object with method calls. ../rfc/8620:876
+type request struct {
+	Using       []string          `json:"using"`
+	MethodCalls []invocation      `json:"methodCalls"`
+	CreatedIDs  map[string]string `json:"createdIds,omitempty"`
+}
+
+// Response object with method responses. ../rfc/8620:958
+type response struct {
+	MethodResponses []invocation      `json:"methodResponses"`
+	CreatedIDs      map[string]string `json:"createdIds,omitempty"`
+	SessionState    string            `json:"sessionState"`
+}
+
+// invocation is a method call or response, as JSON array with a name, arguments
+// and call id.
+type invocation struct {
+	Name   string
+	Args   json.RawMessage
+	CallID string
+}
+
+func (inv invocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{inv.Name, inv.Args, inv.CallID})
+}
+
+func (inv *invocation) UnmarshalJSON(buf []byte) error {
+	var l []json.RawMessage
+	if err := json.Unmarshal(buf, &l); err != nil {
+		return err
+	}
+	if len(l) != 3 {
+		return fmt.Errorf("invocation must have 3 elements, got %d", len(l))
+	}
+	if err := json.Unmarshal(l[0], &inv.Name); err != nil {
+		return fmt.Errorf("method name: %v", err)
+	}
+	if len(l[1]) == 0 || l[1][0] != '{' {
+		return fmt.Errorf("arguments must be an object")
+	}
+	inv.Args = l[1]
+	if err := json.Unmarshal(l[2], &inv.CallID); err != nil {
+		return fmt.Errorf("method call id: %v", err)
+	}
+	return nil
+}
+
+// methodError is a method-level error, returned as "error" response.
+// ../rfc/8620:1170
+type methodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+func (e methodError) Error() string {
+	return e.Type + ": " + e.Description
+}
+
+func xmethodErrorf(typ, format string, args ...any) {
+	panic(methodError{typ, fmt.Sprintf(format, args...)})
+}
+
+func xcheckf(err error, format string, args ...any) {
+	if err != nil {
+		msg := fmt.Sprintf(format, args...)
+		panic(methodError{"serverFail", fmt.Sprintf("%s: %s", msg, err)})
+	}
+}
+
+func xinvalidArgumentsf(format string, args ...any) {
+	xmethodErrorf("invalidArguments", format, args...)
+}
+
+// setError is an error for an object in a /set call. ../rfc/8620:2088
+type setError struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Properties  []string `json:"properties,omitempty"`
+}
+
+func xsetErrorf(typ, format string, args ...any) {
+	panic(setError{Type: typ, Description: fmt.Sprintf(format, args...)})
+}
+
+func xinvalidPropertiesf(property, format string, args ...any) {
+	panic(setError{"invalidProperties", fmt.Sprintf(format, args...), []string{property}})
+}
+
+// xsetItem calls fn and returns a setError it panics with, passing on other
+// panics. Functions must only raise a setError before making changes.
+func xsetItem(fn func()) (serr *setError) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(setError); ok {
+			serr = &err
+			return
+		}
+		panic(x)
+	}()
+	fn()
+	return nil
+}
+
+// call is the context for a method call.
+type call struct {
+	s            server
+	ctx          context.Context
+	log          mlog.Log
+	acc          *store.Account
+	loginAddress string
+	r            *http.Request
+	using        map[string]bool
+	createdIDs   map[string]string // Creation ids from the request and its method calls, to ids.
+	responses    []invocation      // Earlier responses, for result references.
+	callID       string
+	extra        []invocation // Additional responses for the method, added after its own response.
+}
+
+// method handles a JMAP method call.
+type method struct {
+	capability string
+	fn         func(c *call, args json.RawMessage) any
+}
+
+// handle returns a method for fn, taking care of parsing arguments.
+func handle[A, R any](capability string, fn func(c *call, args A) R) method {
+	return method{capability, func(c *call, raw json.RawMessage) any {
+		var args A
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&args); err != nil {
+			xinvalidArgumentsf("parsing arguments: %v", err)
+		}
+		return fn(c, args)
+	}}
+}
+
+var methods = map[string]method{
+	"Core/echo": {capCore, func(c *call, args json.RawMessage) any { return args }},
+
+	"Mailbox/get":          handle(capMail, (*call).mailboxGet),
+	"Mailbox/changes":      handle(capMail, (*call).mailboxChanges),
+	"Mailbox/query":        handle(capMail, (*call).mailboxQuery),
+	"Mailbox/queryChanges": handle(capMail, (*call).queryChanges),
+	"Mailbox/set":          handle(capMail, (*call).mailboxSet),
+
+	"Thread/get":     handle(capMail, (*call).threadGet),
+	"Thread/changes": handle(capMail, (*call).threadChanges),
+
+	"Email/get":          handle(capMail, (*call).emailGet),
+	"Email/changes":      handle(capMail, (*call).emailChanges),
+	"Email/query":        handle(capMail, (*call).emailQuery),
+	"Email/queryChanges": handle(capMail, (*call).queryChanges),
+	"Email/set":          handle(capMail, (*call).emailSet),
+	"Email/import":       handle(capMail, (*call).emailImport),
+
+	"Identity/get":     handle(capSubmission, (*call).identityGet),
+	"Identity/changes": handle(capSubmission, (*call).identityChanges),
+	"Identity/set":     handle(capSubmission, (*call).identitySet),
+
+	"EmailSubmission/get":          handle(capSubmission, (*call).submissionGet),
+	"EmailSubmission/changes":      handle(capSubmission, (*call).submissionChanges),
+	"EmailSubmission/query":        handle(capSubmission, (*call).submissionQuery),
+	"EmailSubmission/queryChanges": handle(capSubmission, (*call).queryChanges),
+	"EmailSubmission/set":          handle(capSubmission, (*call).submissionSet),
+}
+
+func (s server) serveAPI(log mlog.Log, w http.ResponseWriter, r *http.Request, acc *store.Account, loginAddress string) {
+	// ../rfc/8620:1060
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(strings.ToLower(ct), "application/json") {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notJSON", "", "content-type must be application/json")
+		return
+	}
+	buf, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSizeRequest))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:limit", "maxSizeRequest", "request too large")
+		} else {
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notRequest", "", "reading request: "+err.Error())
+		}
+		return
+	}
+	if !json.Valid(buf) {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notJSON", "", "request is not valid json")
+		return
+	}
+	var req request
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil || req.Using == nil || req.MethodCalls == nil {
+		msg := "missing using or methodCalls"
+		if err != nil {
+			msg = err.Error()
+		}
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notRequest", "", "parsing request: "+msg)
+		return
+	}
+	using := map[string]bool{}
+	for _, u := range req.Using {
+		switch u {
+		case capCore, capMail, capSubmission:
+			using[u] = true
+		default:
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:unknownCapability", "", "unknown capability "+u)
+			return
+		}
+	}
+	if len(req.MethodCalls) > maxCallsInRequest {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:limit", "maxCallsInRequest", "too many method calls")
+		return
+	}
+
+	c := &call{
+		s:            s,
+		ctx:          r.Context(),
+		log:          log,
+		acc:          acc,
+		loginAddress: loginAddress,
+		r:            r,
+		using:        using,
+		createdIDs:   map[string]string{},
+	}
+	for k, v := range req.CreatedIDs {
+		c.createdIDs[k] = v
+	}
+
+	for _, mc := range req.MethodCalls {
+		c.callID = mc.CallID
+		c.extra = nil
+		name, result := c.invoke(mc.Name, mc.Args)
+		args, err := json.Marshal(result)
+		if err != nil {
+			log.Errorx("marshal method response", err, slog.String("method", mc.Name))
+			name = "error"
+			args, _ = json.Marshal(methodError{"serverFail", "marshal response"})
+		}
+		c.responses = append(c.responses, invocation{name, args, mc.CallID})
+		c.responses = append(c.responses, c.extra...)
+	}
+
+	resp := response{
+		MethodResponses: c.responses,
+		SessionState:    s.makeSession(r, acc, loginAddress).State,
+	}
+	if req.CreatedIDs != nil {
+		resp.CreatedIDs = c.createdIDs
+	}
+	writeJSON(log, w, resp)
+}
+
+// invoke calls a method, returning the name of the response (the method name, or
+// "error") and the response arguments.
+func (c *call) invoke(name string, args json.RawMessage) (rname string, result any) {
+	log := c.log.With(slog.String("method", name))
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		err, ok := x.(methodError)
+		if !ok {
+			log.Error("unhandled panic in jmap method", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.JMAP)
+			err = methodError{"serverFail", "unhandled error"}
+		} else if err.Type == "serverFail" {
+			log.Errorx("jmap method server error", err)
+		} else {
+			log.Debugx("jmap method error", err)
+		}
+		metricResults.WithLabelValues(metricMethod(name), err.Type).Inc()
+		c.extra = nil
+		rname, result = "error", err
+	}()
+
+	m, ok := methods[name]
+	if !ok {
+		xmethodErrorf("unknownMethod", "unknown method %q", name)
+	}
+	if !c.using[m.capability] {
+		xmethodErrorf("unknownMethod", "capability %s for method not in using", m.capability)
+	}
+
+	args = c.xresolveReferences(args)
+	result = m.fn(c, args)
+	metricResults.WithLabelValues(name, "ok").Inc()
+	log.Debug("jmap method call")
+	return name, result
+}
+
+// metricMethod returns the method name for use as metric label, replacing
+// unknown methods to prevent unbounded label values.
+func metricMethod(name string) string {
+	if _, ok := methods[name]; ok {
+		return name
+	}
+	return "(unknown)"
+}
+
+// Result reference to a previous method response. ../rfc/8620:1330
+type resultReference struct {
+	ResultOf string `json:"resultOf"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+}
+
+// xresolveReferences replaces arguments starting with "#" with the values from
+// the referenced previous method responses.
+func (c *call) xresolveReferences(args json.RawMessage) json.RawMessage {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(args, &m); err != nil {
+		xinvalidArgumentsf("parsing arguments: %v", err)
+	}
+	var changed bool
+	for k, v := range m {
+		if !strings.HasPrefix(k, "#") {
+			continue
+		}
+		if _, ok := m[k[1:]]; ok {
+			xinvalidArgumentsf("argument %q present both with and without result reference", k[1:])
+		}
+		var ref resultReference
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ref); err != nil {
+			xmethodErrorf("invalidResultReference", "parsing result reference: %v", err)
+		}
+		i := slices.IndexFunc(c.responses, func(inv invocation) bool { return inv.CallID == ref.ResultOf })
+		if i < 0 || c.responses[i].Name != ref.Name {
+			xmethodErrorf("invalidResultReference", "no response %q for call id %q", ref.Name, ref.ResultOf)
+		}
+		var result any
+		if err := json.Unmarshal(c.responses[i].Args, &result); err != nil {
+			xcheckf(err, "parsing earlier response")
+		}
+		value, err := evalPointer(result, ref.Path)
+		if err != nil {
+			xmethodErrorf("invalidResultReference", "evaluating path %q: %v", ref.Path, err)
+		}
+		buf, err := json.Marshal(value)
+		xcheckf(err, "marshal result reference value")
+		delete(m, k)
+		m[k[1:]] = buf
+		changed = true
+	}
+	if !changed {
+		return args
+	}
+	buf, err := json.Marshal(m)
+	xcheckf(err, "marshal arguments")
+	return buf
+}
+
+// evalPointer evaluates a JSON pointer on v, with the JMAP extension that "*"
+// maps the remainder of the path over all elements of an array, flattening
+// resulting arrays. ../rfc/6901 ../rfc/8620:1370
+func evalPointer(v any, path string) (any, error) {
+	if path == "" {
+		return v, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New("path must start with slash")
+	}
+	return evalTokens(v, strings.Split(path[1:], "/"))
+}
+
+func evalTokens(v any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return v, nil
+	}
+	tok := unescapePointer(tokens[0])
+	switch x := v.(type) {
+	case []any:
+		if tok == "*" {
+			r := []any{}
+			for _, e := range x {
+				ev, err := evalTokens(e, tokens[1:])
+				if err != nil {
+					return nil, err
+				}
+				if l, ok := ev.([]any); ok {
+					r = append(r, l...)
+				} else {
+					r = append(r, ev)
+				}
+			}
+			return r, nil
+		}
+		i, err := strconv.Atoi(tok)
+		if err != nil || i < 0 || i >= len(x) {
+			return nil, fmt.Errorf("invalid array index %q", tok)
+		}
+		return evalTokens(x[i], tokens[1:])
+	case map[string]any:
+		e, ok := x[tok]
+		if !ok {
+			return nil, fmt.Errorf("no property %q", tok)
+		}
+		return evalTokens(e, tokens[1:])
+	}
+	return nil, fmt.Errorf("cannot evaluate %q on non-object and non-array value", tok)
+}
+
+func unescapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
+
+// xaccount checks the account id is that of the authenticated account.
+func (c *call) xaccount(accountID string) {
+	if accountID != c.acc.Name {
+		xmethodErrorf("accountNotFound", "unknown account %q", accountID)
+	}
+}
+
+// xresolveID returns the id for a creation id reference "#creationid", or the id
+// itself.
+func (c *call) xresolveID(id string) string {
+	if !strings.HasPrefix(id, "#") {
+		return id
+	}
+	if v, ok := c.createdIDs[id[1:]]; ok {
+		return v
+	}
+	xinvalidArgumentsf("unknown creation id reference %q", id)
+	return ""
+}
+
+// Ids of objects have a one-letter type prefix, followed by the database id.
+// Ids should not consist of just digits. ../rfc/8620:616
+
+func mailboxID(id int64) string { return fmt.Sprintf("m%d", id) }
+func emailID(id int64) string   { return fmt.Sprintf("e%d", id) }
+func threadID(id int64) string  { return fmt.Sprintf("t%d", id) }
+
+func submissionID(id int64) string { return fmt.Sprintf("s%d", id) }
+
+// parseID parses an id with type prefix, returning 0 for invalid ids.
+func parseID(prefix byte, s string) int64 {
+	if len(s) < 2 || s[0] != prefix {
+		return 0
+	}
+	v, err := strconv.ParseInt(s[1:], 10, 64)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return v
+}
+
+// threadIDOf returns the thread id of a message. Messages not yet assigned to
+// a thread are their own thread.
+func threadIDOf(m store.Message) int64 {
+	if m.ThreadID == 0 {
+		return m.ID
+	}
+	return m.ThreadID
+}
+
+func xdbwrite(ctx context.Context, acc *store.Account, fn func(tx *bstore.Tx)) {
+	err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		fn(tx)
+		return nil
+	})
+	xcheckf(err, "transaction")
+}
+
+func xdbread(ctx context.Context, acc *store.Account, fn func(tx *bstore.Tx)) {
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		fn(tx)
+		return nil
+	})
+	xcheckf(err, "transaction")
+}
+
+// lastModSeq returns the most recently assigned modseq for the account.
+func xlastModSeq(tx *bstore.Tx) store.ModSeq {
+	v := store.SyncState{ID: 1}
+	err := tx.Get(&v)
+	if err == bstore.ErrAbsent {
+		return 0
+	}
+	xcheckf(err, "get sync state")
+	return v.LastModSeq
+}
+
+// State strings for the types stored in the database are the account modseq.
+func modseqState(modseq store.ModSeq) string {
+	return fmt.Sprintf("%d", modseq)
+}
+
+// xsinceModSeq parses the state of a /changes request, returning an error if we
+// cannot calculate the changes since that state, e.g. because the expunged records
+// have been removed.
+func (c *call) xsinceModSeq(tx *bstore.Tx, state string, last store.ModSeq) store.ModSeq {
+	v, err := strconv.ParseInt(state, 10, 64)
+	if err != nil || v < 0 || store.ModSeq(v) > last {
+		xmethodErrorf("cannotCalculateChanges", "unknown state %q", state)
+	}
+	highestDeleted, err := c.acc.HighestDeletedModSeq(tx)
+	xcheckf(err, "get highest deleted modseq")
+	if store.ModSeq(v) < highestDeleted {
+		xmethodErrorf("cannotCalculateChanges", "state %q too old", state)
+	}
+	return store.ModSeq(v)
+}
+
+// Arguments for /get methods. ../rfc/8620:1498
+type getArgs struct {
+	AccountID  string   `json:"accountId"`
+	IDs        []string `json:"ids"`        // Nil for all objects.
+	Properties []string `json:"properties"` // Nil for all or default properties.
+}
+
+type getResult struct {
+	AccountID string           `json:"accountId"`
+	State     string           `json:"state"`
+	List      []map[string]any `json:"list"`
+	NotFound  []string         `json:"notFound"`
+}
+
+// xcheckGet checks the ids and properties of a /get request. Properties that are
+// not in known are invalid. The "id" property is always returned.
+func (c *call) xcheckGet(args getArgs, known []string) (props map[string]bool) {
+	c.xaccount(args.AccountID)
+	if len(args.IDs) > maxObjectsInGet {
+		xmethodErrorf("requestTooLarge", "too many ids, max %d", maxObjectsInGet)
+	}
+	for i, id := range args.IDs {
+		args.IDs[i] = c.xresolveID(id)
+	}
+	if args.Properties == nil {
+		return nil
+	}
+	props = map[string]bool{"id": true}
+	for _, p := range args.Properties {
+		if !slices.Contains(known, p) {
+			xinvalidArgumentsf("unknown property %q", p)
+		}
+		props[p] = true
+	}
+	return props
+}
+
+// Arguments for /changes methods. ../rfc/8620:1629
+type changesArgs struct {
+	AccountID  string `json:"accountId"`
+	SinceState string `json:"sinceState"`
+	MaxChanges *int   `json:"maxChanges"`
+}
+
+type changesResult struct {
+	AccountID      string   `json:"accountId"`
+	OldState       string   `json:"oldState"`
+	NewState       string   `json:"newState"`
+	HasMoreChanges bool     `json:"hasMoreChanges"`
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	Destroyed      []string `json:"destroyed"`
+}
+
+type changeKind int
+
+const (
+	changeCreated changeKind = iota
+	changeUpdated
+	changeDestroyed
+)
+
+// objectChange is a change to an object, gathered for a /changes response.
+type objectChange struct {
+	id     string
+	modseq store.ModSeq
+	kind   changeKind
+}
+
+// xchangesResult makes a /changes response from the changes since a state. If
+// there are more changes than maxChanges, only the changes up to a modseq are
+// returned, with that modseq as new state.
+func (c *call) xchangesResult(args changesArgs, last store.ModSeq, l []objectChange) changesResult {
+	if args.MaxChanges != nil && *args.MaxChanges <= 0 {
+		xinvalidArgumentsf("maxChanges must be positive")
+	}
+	slices.SortStableFunc(l, func(a, b objectChange) int {
+		return int(a.modseq - b.modseq)
+	})
+
+	// Keep only the last change for an object.
+	seen := map[string]int{}
+	var nl []objectChange
+	for _, oc := range l {
+		if i, ok := seen[oc.id]; ok {
+			if nl[i].kind == changeCreated && oc.kind == changeUpdated {
+				continue
+			}
+			nl[i].kind = oc.kind
+			nl[i].modseq = oc.modseq
+			continue
+		}
+		seen[oc.id] = len(nl)
+		nl = append(nl, oc)
+	}
+	l = nl
+	slices.SortStableFunc(l, func(a, b objectChange) int {
+		return int(a.modseq - b.modseq)
+	})
+
+	r := changesResult{
+		AccountID: args.AccountID,
+		OldState:  args.SinceState,
+		NewState:  modseqState(last),
+		Created:   []string{},
+		Updated:   []string{},
+		Destroyed: []string{},
+	}
+	if args.MaxChanges != nil && len(l) > *args.MaxChanges {
+		// Cut at a modseq boundary so the intermediate state is consistent.
+		n := *args.MaxChanges
+		for n > 0 && l[n-1].modseq == l[n].modseq {
+			n--
+		}
+		if n == 0 {
+			xmethodErrorf("cannotCalculateChanges", "more changes in a single state than maxChanges")
+		}
+		l = l[:n]
+		r.NewState = modseqState(l[n-1].modseq)
+		r.HasMoreChanges = true
+	}
+	for _, oc := range l {
+		switch oc.kind {
+		case changeCreated:
+			r.Created = append(r.Created, oc.id)
+		case changeUpdated:
+			r.Updated = append(r.Updated, oc.id)
+		case changeDestroyed:
+			r.Destroyed = append(r.Destroyed, oc.id)
+		}
+	}
+	return r
+}
+
+// Arguments for /set methods. ../rfc/8620:1866
+type setArgs struct {
+	AccountID string                                `json:"accountId"`
+	IfInState *string                               `json:"ifInState"`
+	Create    map[string]json.RawMessage            `json:"create"`
+	Update    map[string]map[string]json.RawMessage `json:"update"`
+	Destroy   []string                              `json:"destroy"`
+}
+
+type setResult struct {
+	AccountID    string               `json:"accountId"`
+	OldState     *string              `json:"oldState"`
+	NewState     string               `json:"newState"`
+	Created      map[string]any       `json:"created"`
+	Updated      map[string]any       `json:"updated"`
+	Destroyed    []string             `json:"destroyed"`
+	NotCreated   map[string]*setError `json:"notCreated"`
+	NotUpdated   map[string]*setError `json:"notUpdated"`
+	NotDestroyed map[string]*setError `json:"notDestroyed"`
+}
+
+// xcheckSet checks the common /set arguments, including the ifInState against
+// the current state.
+func (c *call) xcheckSet(args setArgs, state string) {
+	c.xaccount(args.AccountID)
+	if len(args.Create)+len(args.Update)+len(args.Destroy) > maxObjectsInSet {
+		xmethodErrorf("requestTooLarge", "too many objects, max %d", maxObjectsInSet)
+	}
+	if args.IfInState != nil && *args.IfInState != state {
+		xmethodErrorf("stateMismatch", "state is %q", state)
+	}
+	for id := range args.Update {
+		if _, ok := args.Create[id]; ok {
+			xinvalidArgumentsf("id %q both in create and update", id)
+		}
+	}
+}
+
+func (r *setResult) addCreated(creationID string, v any) {
+	if r.Created == nil {
+		r.Created = map[string]any{}
+	}
+	r.Created[creationID] = v
+}
+
+func (r *setResult) addUpdated(id string, v any) {
+	if r.Updated == nil {
+		r.Updated = map[string]any{}
+	}
+	r.Updated[id] = v
+}
+
+func (r *setResult) addNotCreated(creationID string, err *setError) {
+	if r.NotCreated == nil {
+		r.NotCreated = map[string]*setError{}
+	}
+	r.NotCreated[creationID] = err
+}
+
+func (r *setResult) addNotUpdated(id string, err *setError) {
+	if r.NotUpdated == nil {
+		r.NotUpdated = map[string]*setError{}
+	}
+	r.NotUpdated[id] = err
+}
+
+func (r *setResult) addNotDestroyed(id string, err *setError) {
+	if r.NotDestroyed == nil {
+		r.NotDestroyed = map[string]*setError{}
+	}
+	r.NotDestroyed[id] = err
+}
+
+// Arguments for /query methods. ../rfc/8620:2213
+type queryArgs struct {
+	AccountID      string          `json:"accountId"`
+	Filter         json.RawMessage `json:"filter"`
+	Sort           []comparator    `json:"sort"`
+	Position       int             `json:"position"`
+	Anchor         *string         `json:"anchor"`
+	AnchorOffset   int             `json:"anchorOffset"`
+	Limit          *int            `json:"limit"`
+	CalculateTotal bool            `json:"calculateTotal"`
+}
+
+type comparator struct {
+	Property    string `json:"property"`
+	IsAscending *bool  `json:"isAscending"`
+	Collation   string `json:"collation"`
+	Keyword     string `json:"keyword"` // For Email hasKeyword sorts.
+}
+
+func (cmp comparator) ascending() bool {
+	return cmp.IsAscending == nil || *cmp.IsAscending
+}
+
+type queryResult struct {
+	AccountID           string   `json:"accountId"`
+	QueryState          string   `json:"queryState"`
+	CanCalculateChanges bool     `json:"canCalculateChanges"`
+	Position            int      `json:"position"`
+	IDs                 []string `json:"ids"`
+	Total               *int     `json:"total,omitempty"`
+	Limit               *int     `json:"limit,omitempty"`
+}
+
+// xqueryResult returns the window of ids requested.
+func (c *call) xqueryResult(args queryArgs, state string, ids []string) queryResult {
+	r := queryResult{
+		AccountID:  args.AccountID,
+		QueryState: state,
+		IDs:        []string{},
+	}
+	if args.CalculateTotal {
+		n := len(ids)
+		r.Total = &n
+	}
+
+	position := args.Position
+	if args.Anchor != nil {
+		i := slices.Index(ids, c.xresolveID(*args.Anchor))
+		if i < 0 {
+			xmethodErrorf("anchorNotFound", "anchor not in results")
+		}
+		position = max(0, i+args.AnchorOffset)
+	} else if position < 0 {
+		position = max(0, len(ids)+position)
+	}
+	position = min(position, len(ids))
+
+	limit := maxObjectsInGet
+	if args.Limit != nil {
+		if *args.Limit < 0 {
+			xinvalidArgumentsf("negative limit")
+		}
+		if *args.Limit > limit {
+			r.Limit = &limit
+		} else {
+			limit = *args.Limit
+		}
+	}
+	end := min(len(ids), position+limit)
+	r.Position = position
+	r.IDs = append(r.IDs, ids[position:end]...)
+	return r
+}
+
+// queryChanges is not implemented for any type. Clients will query again.
+// ../rfc/8620:2519
+func (c *call) queryChanges(args struct {
+	AccountID       string          `json:"accountId"`
+	Filter          json.RawMessage `json:"filter"`
+	Sort            []comparator    `json:"sort"`
+	SinceQueryState string          `json:"sinceQueryState"`
+	MaxChanges      *int            `json:"maxChanges"`
+	UpToID          *string         `json:"upToId"`
+	CalculateTotal  bool            `json:"calculateTotal"`
+	CollapseThreads bool            `json:"collapseThreads"`
+}) any {
+	c.xaccount(args.AccountID)
+	xmethodErrorf("cannotCalculateChanges", "query changes not implemented")
+	return nil
+}
+
+// patchPath parses the key of a patch object into its path elements.
+func patchPath(key string) []string {
+	t := strings.Split(key, "/")
+	for i, s := range t {
+		t[i] = unescapePointer(s)
+	}
+	return t
+}
+
+// optionalID is an id that can be absent, null or a string, for filters and
+// properties where null has a meaning.
+type optionalID struct {
+	Set   bool
+	Value *string
+}
+
+func (o *optionalID) UnmarshalJSON(buf []byte) error {
+	o.Set = true
+	return json.Unmarshal(buf, &o.Value)
+}
+
+// jsonBool returns whether raw is the JSON value true. Patch values are true or
+// null.
+func jsonBool(raw json.RawMessage) (value, ok bool) {
+	switch string(bytes.TrimSpace(raw)) {
+	case "true":
+		return true, true
+	case "null", "false":
+		return false, true
+	}
+	return false, false
+}
+
+// utcDate formats a time as UTCDate. ../rfc/8620:650
+func utcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// filter is a parsed FilterOperator or FilterCondition of a /query request.
+// ../rfc/8620:2236
+type filter[C any] struct {
+	operator   string // AND, OR or NOT. Empty for a condition.
+	conditions []filter[C]
+	cond       C
+}
+
+// xparseFilter parses a filter, with unknown conditions resulting in an
+// unsupportedFilter error. A null/absent filter matches everything.
+func xparseFilter[C any](raw json.RawMessage) *filter[C] {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		xmethodErrorf("unsupportedFilter", "parsing filter: %v", err)
+	}
+	if _, ok := m["operator"]; ok {
+		var op struct {
+			Operator   string            `json:"operator"`
+			Conditions []json.RawMessage `json:"conditions"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&op); err != nil {
+			xmethodErrorf("unsupportedFilter", "parsing filter operator: %v", err)
+		}
+		if op.Operator != "AND" && op.Operator != "OR" && op.Operator != "NOT" {
+			xmethodErrorf("unsupportedFilter", "unknown filter operator %q", op.Operator)
+		}
+		f := &filter[C]{operator: op.Operator}
+		for _, c := range op.Conditions {
+			sub := xparseFilter[C](c)
+			if sub == nil {
+				xmethodErrorf("unsupportedFilter", "null condition in filter operator")
+			}
+			f.conditions = append(f.conditions, *sub)
+		}
+		return f
+	}
+	f := &filter[C]{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f.cond); err != nil {
+		xmethodErrorf("unsupportedFilter", "parsing filter condition: %v", err)
+	}
+	return f
+}
+
+// match evaluates the filter, with fn matching a single condition. A nil filter
+// matches.
+func (f *filter[C]) match(fn func(cond C) bool) bool {
+	if f == nil {
+		return true
+	}
+	switch f.operator {
+	case "AND":
+		for _, sub := range f.conditions {
+			if !sub.match(fn) {
+				return false
+			}
+		}
+		return true
+	case "OR":
+		for _, sub := range f.conditions {
+			if sub.match(fn) {
+				return true
+			}
+		}
+		return false
+	case "NOT":
+		for _, sub := range f.conditions {
+			if sub.match(fn) {
+				return false
+			}
+		}
+		return true
+	}
+	return fn(f.cond)
+}
+
+// walk calls fn for each condition in the filter.
+func (f *filter[C]) walk(fn func(cond C)) {
+	if f == nil {
+		return
+	}
+	if f.operator == "" {
+		fn(f.cond)
+	}
+	for i := range f.conditions {
+		f.conditions[i].walk(fn)
+	}
+}
@@ -0,0 +1,489 @@
+package jmapserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheckf(t *testing.T, err error, format string, args ...any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func tcompare(t *testing.T, got, expect any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expect)
+	}
+}
+
+// testClient makes requests to the JMAP server.
+type testClient struct {
+	t       *testing.T
+	baseURL string
+}
+
+const testPassword = "test1234"
+
+func (tc testClient) do(method, path, ct string, body io.Reader) *http.Response {
+	tc.t.Helper()
+	req, err := http.NewRequest(method, tc.baseURL+path, body)
+	tcheckf(tc.t, err, "new request")
+	req.SetBasicAuth("mjl@mox.example", testPassword)
+	if ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	tcheckf(tc.t, err, "http request")
+	return resp
+}
+
+// call makes an API request with method calls, each an array with name,
+// arguments and call id, and returns the method responses.
+func (tc testClient) call(calls ...[]any) []invocation {
+	tc.t.Helper()
+	req := map[string]any{
+		"using":       []string{capCore, capMail, capSubmission},
+		"methodCalls": calls,
+	}
+	buf, err := json.Marshal(req)
+	tcheckf(tc.t, err, "marshal request")
+	resp := tc.do("POST", "/api", "application/json", strings.NewReader(string(buf)))
+	defer resp.Body.Close()
+	tcompare(tc.t, resp.StatusCode, http.StatusOK)
+	var r response
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	tcheckf(tc.t, err, "decode response")
+	return r.MethodResponses
+}
+
+// call1 makes a single method call and parses the response into result, which
+// is cleared first.
+func (tc testClient) call1(name string, args any, result any) {
+	tc.t.Helper()
+	l := tc.call([]any{name, args, "c0"})
+	if len(l) == 0 {
+		tc.t.Fatalf("no method responses")
+	}
+	if l[0].Name != name {
+		tc.t.Fatalf("got response %q (%s), expected %q", l[0].Name, l[0].Args, name)
+	}
+	reflect.ValueOf(result).Elem().SetZero()
+	err := json.Unmarshal(l[0].Args, result)
+	tcheckf(tc.t, err, "parse %s response", name)
+}
+
+// callError makes a single method call and checks the error type.
+func (tc testClient) callError(name string, args any, expType string) {
+	tc.t.Helper()
+	l := tc.call([]any{name, args, "c0"})
+	tcompare(tc.t, l[0].Name, "error")
+	var merr methodError
+	err := json.Unmarshal(l[0].Args, &merr)
+	tcheckf(tc.t, err, "parse error")
+	tcompare(tc.t, merr.Type, expType)
+}
+
+type testSetResult struct {
+	OldState     string                    `json:"oldState"`
+	NewState     string                    `json:"newState"`
+	Created      map[string]map[string]any `json:"created"`
+	Updated      map[string]any            `json:"updated"`
+	Destroyed    []string                  `json:"destroyed"`
+	NotCreated   map[string]setError       `json:"notCreated"`
+	NotUpdated   map[string]setError       `json:"notUpdated"`
+	NotDestroyed map[string]setError       `json:"notDestroyed"`
+}
+
+func TestServer(t *testing.T) {
+	mox.LimitersInit()
+	os.RemoveAll("../testdata/jmapserver/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/jmapserver/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := store.Init(ctxbg)
+	tcheckf(t, err, "store init")
+	defer func() {
+		err := store.Close()
+		tcheckf(t, err, "store close")
+	}()
+	defer store.Switchboard()()
+	err = queue.Init()
+	tcheckf(t, err, "queue init")
+	defer queue.Shutdown()
+
+	log := mlog.New("jmapserver", nil)
+	acc, err := store.OpenAccount(log, "mjl", false)
+	tcheckf(t, err, "open account")
+	err = acc.SetPassword(log, testPassword)
+	tcheckf(t, err, "set password")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	hs := httptest.NewServer(NewServer(100*1024, "/jmap/", false))
+	defer hs.Close()
+	tc := testClient{t, hs.URL}
+
+	// Authentication is required.
+	resp, err := http.Get(hs.URL + "/session")
+	tcheckf(t, err, "get session")
+	resp.Body.Close()
+	tcompare(t, resp.StatusCode, http.StatusUnauthorized)
+
+	// Session resource.
+	resp = tc.do("GET", "/session", "", nil)
+	var sess session
+	err = json.NewDecoder(resp.Body).Decode(&sess)
+	resp.Body.Close()
+	tcheckf(t, err, "parse session")
+	tcompare(t, sess.PrimaryAccounts[capMail], "mjl")
+	tcompare(t, sess.APIURL, hs.URL+"/jmap/api")
+	if sess.State == "" {
+		t.Fatalf("missing session state")
+	}
+
+	// Request-level errors.
+	resp = tc.do("POST", "/api", "application/json", strings.NewReader(`{"using": ["urn:bogus"], "methodCalls": []}`))
+	resp.Body.Close()
+	tcompare(t, resp.StatusCode, http.StatusBadRequest)
+	resp = tc.do("POST", "/api", "text/plain", strings.NewReader(`{}`))
+	resp.Body.Close()
+	tcompare(t, resp.StatusCode, http.StatusBadRequest)
+
+	// Method-level errors.
+	tc.callError("Bogus/get", map[string]any{}, "unknownMethod")
+	tc.callError("Mailbox/get", map[string]any{"accountId": "other"}, "accountNotFound")
+	tc.callError("Mailbox/get", map[string]any{"accountId": "mjl", "bogus": true}, "invalidArguments")
+
+	// Core/echo returns its arguments.
+	l := tc.call([]any{"Core/echo", map[string]any{"hello": true}, "e0"})
+	tcompare(t, string(l[0].Args), `{"hello":true}`)
+
+	// Mailboxes.
+	var mbget struct {
+		State string           `json:"state"`
+		List  []map[string]any `json:"list"`
+	}
+	tc.call1("Mailbox/get", map[string]any{"accountId": "mjl", "ids": nil}, &mbget)
+	mailboxes := map[string]string{} // Role to id.
+	for _, mb := range mbget.List {
+		if role, ok := mb["role"].(string); ok {
+			mailboxes[role] = mb["id"].(string)
+		}
+	}
+	for _, role := range []string{"inbox", "sent", "drafts", "trash", "junk", "archive"} {
+		if mailboxes[role] == "" {
+			t.Fatalf("missing mailbox with role %q", role)
+		}
+	}
+	mbState := mbget.State
+
+	var mbset testSetResult
+	tc.call1("Mailbox/set", map[string]any{
+		"accountId": "mjl",
+		"create": map[string]any{
+			"l": map[string]any{"name": "Lists"},
+			"x": map[string]any{"name": "Sub", "parentId": "#l"},
+		},
+	}, &mbset)
+	tcompare(t, len(mbset.Created), 2)
+	listsID := mbset.Created["l"]["id"].(string)
+
+	var mbchanges changesResult
+	tc.call1("Mailbox/changes", map[string]any{"accountId": "mjl", "sinceState": mbState}, &mbchanges)
+	tcompare(t, len(mbchanges.Created), 2)
+
+	// Rename, and removing a mailbox with children fails.
+	tc.call1("Mailbox/set", map[string]any{
+		"accountId": "mjl",
+		"update":    map[string]any{listsID: map[string]any{"name": "Lists2"}},
+		"destroy":   []string{listsID},
+	}, &mbset)
+	tcompare(t, len(mbset.Updated), 1)
+	tcompare(t, mbset.NotDestroyed[listsID].Type, "mailboxHasChild")
+
+	// Create a draft.
+	var emailset testSetResult
+	tc.call1("Email/set", map[string]any{
+		"accountId": "mjl",
+		"create": map[string]any{
+			"d": map[string]any{
+				"mailboxIds": map[string]bool{mailboxes["drafts"]: true},
+				"keywords":   map[string]bool{"$draft": true, "$seen": true},
+				"from":       []map[string]any{{"name": "mjl", "email": "mjl@mox.example"}},
+				"to":         []map[string]any{{"email": "remote@example.org"}},
+				"bcc":        []map[string]any{{"email": "hidden@example.org"}},
+				"subject":    "test message",
+				"bodyValues": map[string]any{"t": map[string]any{"value": "hello world\n"}},
+				"textBody":   []map[string]any{{"partId": "t", "type": "text/plain"}},
+			},
+			"bad": map[string]any{
+				"mailboxIds": map[string]bool{mailboxes["drafts"]: true, mailboxes["inbox"]: true},
+			},
+		},
+	}, &emailset)
+	draftID := emailset.Created["d"]["id"].(string)
+	tcompare(t, emailset.NotCreated["bad"].Type, "tooManyMailboxes")
+
+	var emailget struct {
+		List []struct {
+			ID         string                    `json:"id"`
+			Subject    string                    `json:"subject"`
+			Keywords   map[string]bool           `json:"keywords"`
+			MailboxIDs map[string]bool           `json:"mailboxIds"`
+			From       []emailAddress            `json:"from"`
+			TextBody   []map[string]any          `json:"textBody"`
+			BodyValues map[string]emailBodyValue `json:"bodyValues"`
+			Preview    string                    `json:"preview"`
+		} `json:"list"`
+		NotFound []string `json:"notFound"`
+	}
+	tc.call1("Email/get", map[string]any{"accountId": "mjl", "ids": []string{draftID, "e999"}, "fetchTextBodyValues": true}, &emailget)
+	tcompare(t, len(emailget.List), 1)
+	tcompare(t, emailget.NotFound, []string{"e999"})
+	e := emailget.List[0]
+	tcompare(t, e.Subject, "test message")
+	tcompare(t, e.Keywords, map[string]bool{"$draft": true, "$seen": true})
+	tcompare(t, e.From[0].Email, "mjl@mox.example")
+	tcompare(t, e.Preview, "hello world")
+	partID := e.TextBody[0]["partId"].(string)
+	tcompare(t, e.BodyValues[partID].Value, "hello world\n")
+
+	// Query with a result reference to get.
+	l = tc.call(
+		[]any{"Email/query", map[string]any{"accountId": "mjl", "filter": map[string]any{"inMailbox": mailboxes["drafts"]}}, "q"},
+		[]any{"Email/get", map[string]any{"accountId": "mjl", "#ids": map[string]any{"resultOf": "q", "name": "Email/query", "path": "/ids"}, "properties": []string{"subject"}}, "g"},
+	)
+	tcompare(t, l[1].Name, "Email/get")
+	err = json.Unmarshal(l[1].Args, &emailget)
+	tcheckf(t, err, "parse email get")
+	tcompare(t, len(emailget.List), 1)
+	tcompare(t, emailget.List[0].ID, draftID)
+
+	// Change keywords with patches.
+	var emailState struct {
+		State string `json:"state"`
+	}
+	tc.call1("Email/get", map[string]any{"accountId": "mjl", "ids": []string{}}, &emailState)
+	tc.call1("Email/set", map[string]any{
+		"accountId": "mjl",
+		"update":    map[string]any{draftID: map[string]any{"keywords/$seen": nil, "keywords/$flagged": true}},
+	}, &emailset)
+	tcompare(t, len(emailset.Updated), 1)
+	var emailchanges changesResult
+	tc.call1("Email/changes", map[string]any{"accountId": "mjl", "sinceState": emailState.State}, &emailchanges)
+	tcompare(t, emailchanges.Updated, []string{draftID})
+
+	// Upload a message, and import it.
+	const rawMsg = "From: <remote@example.org>\r\nTo: <mjl@mox.example>\r\nSubject: imported\r\nMessage-Id: <imported@example.org>\r\n\r\nimported body\r\n"
+	resp = tc.do("POST", "/upload/mjl/", "message/rfc822", strings.NewReader(rawMsg))
+	var upload struct {
+		BlobID string `json:"blobId"`
+		Size   int64  `json:"size"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	tcheckf(t, err, "parse upload response")
+	tcompare(t, resp.StatusCode, http.StatusCreated)
+	tcompare(t, upload.Size, int64(len(rawMsg)))
+
+	var imported struct {
+		Created    map[string]emailCreated `json:"created"`
+		NotCreated map[string]setError     `json:"notCreated"`
+	}
+	tc.call1("Email/import", map[string]any{
+		"accountId": "mjl",
+		"emails": map[string]any{
+			"i":   map[string]any{"blobId": upload.BlobID, "mailboxIds": map[string]bool{mailboxes["inbox"]: true}},
+			"bad": map[string]any{"blobId": "ubogus", "mailboxIds": map[string]bool{mailboxes["inbox"]: true}},
+		},
+	}, &imported)
+	tcompare(t, imported.NotCreated["bad"].Type, "blobNotFound")
+	importedEmail := imported.Created["i"]
+	tcompare(t, importedEmail.Size, int64(len(rawMsg)))
+
+	// Download the imported message.
+	resp = tc.do("GET", "/download/mjl/"+importedEmail.BlobID+"/msg.eml", "", nil)
+	buf, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	tcheckf(t, err, "read download")
+	tcompare(t, string(buf), rawMsg)
+
+	var threadget struct {
+		List []struct {
+			EmailIDs []string `json:"emailIds"`
+		} `json:"list"`
+	}
+	tc.call1("Thread/get", map[string]any{"accountId": "mjl", "ids": []string{importedEmail.ThreadID}}, &threadget)
+	tcompare(t, threadget.List[0].EmailIDs, []string{importedEmail.ID})
+
+	// Move the imported message to the archive.
+	tc.call1("Email/set", map[string]any{
+		"accountId": "mjl",
+		"update":    map[string]any{importedEmail.ID: map[string]any{"mailboxIds": map[string]bool{mailboxes["archive"]: true}}},
+	}, &emailset)
+	tcompare(t, len(emailset.Updated), 1)
+	tc.call1("Email/get", map[string]any{"accountId": "mjl", "ids": []string{importedEmail.ID}, "properties": []string{"mailboxIds"}}, &emailget)
+	tcompare(t, emailget.List[0].MailboxIDs, map[string]bool{mailboxes["archive"]: true})
+
+	// Identities.
+	var identget struct {
+		List []identity `json:"list"`
+	}
+	tc.call1("Identity/get", map[string]any{"accountId": "mjl"}, &identget)
+	var identID string
+	for _, ident := range identget.List {
+		if ident.Email == "mjl@mox.example" {
+			identID = ident.ID
+		}
+	}
+	if identID == "" {
+		t.Fatalf("missing identity for mjl@mox.example")
+	}
+
+	// Submit the draft for delivery in the future, moving it to the sent mailbox on
+	// success.
+	l = tc.call([]any{"EmailSubmission/set", map[string]any{
+		"accountId": "mjl",
+		"create": map[string]any{
+			"s": map[string]any{
+				"identityId": identID,
+				"emailId":    draftID,
+				"envelope": map[string]any{
+					"mailFrom": map[string]any{"email": "mjl@mox.example", "parameters": map[string]any{"HOLDFOR": "3600"}},
+					"rcptTo":   []map[string]any{{"email": "remote@example.org"}},
+				},
+			},
+			"badfrom": map[string]any{"identityId": identID, "emailId": importedEmail.ID},
+		},
+		"onSuccessUpdateEmail": map[string]any{
+			"#s": map[string]any{"mailboxIds": map[string]bool{mailboxes["sent"]: true}, "keywords/$draft": nil},
+		},
+	}, "s0"})
+	tcompare(t, len(l), 2)
+	tcompare(t, l[1].Name, "Email/set")
+	var subset testSetResult
+	err = json.Unmarshal(l[0].Args, &subset)
+	tcheckf(t, err, "parse submission set")
+	tcompare(t, subset.NotCreated["badfrom"].Type, "forbiddenFrom")
+	subID := subset.Created["s"]["id"].(string)
+
+	qmsgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheckf(t, err, "list queue")
+	tcompare(t, len(qmsgs), 1)
+	// Bcc header must have been removed.
+	qmr, err := queue.OpenMessage(ctxbg, qmsgs[0].ID)
+	tcheckf(t, err, "open queued message")
+	buf, err = io.ReadAll(qmr)
+	qmr.Close()
+	tcheckf(t, err, "read queued message")
+	if strings.Contains(strings.ToLower(string(buf)), "hidden@example.org") {
+		t.Fatalf("queued message contains bcc address")
+	}
+
+	tc.call1("Email/get", map[string]any{"accountId": "mjl", "ids": []string{draftID}, "properties": []string{"mailboxIds", "keywords"}}, &emailget)
+	tcompare(t, emailget.List[0].MailboxIDs, map[string]bool{mailboxes["sent"]: true})
+	tcompare(t, emailget.List[0].Keywords, map[string]bool{"$flagged": true})
+
+	var subget struct {
+		List []map[string]any `json:"list"`
+	}
+	tc.call1("EmailSubmission/get", map[string]any{"accountId": "mjl", "ids": []string{subID}}, &subget)
+	tcompare(t, subget.List[0]["undoStatus"], "pending")
+
+	// Cancel the delivery.
+	var subupd testSetResult
+	tc.call1("EmailSubmission/set", map[string]any{"accountId": "mjl", "update": map[string]any{subID: map[string]any{"undoStatus": "canceled"}}}, &subupd)
+	tcompare(t, len(subupd.Updated), 1)
+	qmsgs, err = queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheckf(t, err, "list queue")
+	tcompare(t, len(qmsgs), 0)
+
+	var subquery queryResult
+	tc.call1("EmailSubmission/query", map[string]any{"accountId": "mjl", "filter": map[string]any{"undoStatus": "canceled"}}, &subquery)
+	tcompare(t, subquery.IDs, []string{subID})
+
+	// Destroy the email.
+	tc.call1("Email/set", map[string]any{"accountId": "mjl", "destroy": []string{draftID}}, &emailset)
+	tcompare(t, emailset.Destroyed, []string{draftID})
+	tc.call1("Email/get", map[string]any{"accountId": "mjl", "ids": []string{draftID}}, &emailget)
+	tcompare(t, emailget.NotFound, []string{draftID})
+}
+
+func TestEventSource(t *testing.T) {
+	mox.LimitersInit()
+	os.RemoveAll("../testdata/jmapserver/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/jmapserver/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := store.Init(ctxbg)
+	tcheckf(t, err, "store init")
+	defer func() {
+		err := store.Close()
+		tcheckf(t, err, "store close")
+	}()
+	defer store.Switchboard()()
+
+	log := mlog.New("jmapserver", nil)
+	acc, err := store.OpenAccount(log, "mjl", false)
+	tcheckf(t, err, "open account")
+	err = acc.SetPassword(log, testPassword)
+	tcheckf(t, err, "set password")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	hs := httptest.NewServer(NewServer(100*1024, "/jmap/", false))
+	defer hs.Close()
+	tc := testClient{t, hs.URL}
+
+	ctx, cancel := context.WithTimeout(ctxbg, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", hs.URL+"/eventsource?types=Mailbox&closeafter=state&ping=0", nil)
+	tcheckf(t, err, "new request")
+	req.SetBasicAuth("mjl@mox.example", testPassword)
+	resp, err := http.DefaultClient.Do(req)
+	tcheckf(t, err, "eventsource request")
+	defer resp.Body.Close()
+	tcompare(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// Creating a mailbox results in a state change.
+	var mbset testSetResult
+	tc.call1("Mailbox/set", map[string]any{"accountId": "mjl", "create": map[string]any{"n": map[string]any{"name": "New"}}}, &mbset)
+
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	tcheckf(t, err, "read event")
+	tcompare(t, line, "event: state\n")
+	line, err = br.ReadString('\n')
+	tcheckf(t, err, "read event data")
+	var sc stateChange
+	err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &sc)
+	tcheckf(t, err, "parse state change")
+	tcompare(t, sc.Changed["mjl"]["Mailbox"], mbset.NewState)
+}
@@ -0,0 +1,807 @@
+package jmapserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// EmailSubmission objects are stored as store.EmailSubmission, referencing the
+// messages added to the queue. The undo and delivery status are determined from
+// the queue and the retired queue messages. ../rfc/8621:3709
+
+var submissionProperties = []string{
+	"id",
+	"identityId",
+	"emailId",
+	"threadId",
+	"envelope",
+	"sendAt",
+	"undoStatus",
+	"deliveryStatus",
+	"dsnBlobIds",
+	"mdnBlobIds",
+}
+
+// submissionAddress is an Address in an Envelope. ../rfc/8621:3780
+type submissionAddress struct {
+	Email      string             `json:"email"`
+	Parameters map[string]*string `json:"parameters"`
+}
+
+type submissionEnvelope struct {
+	MailFrom submissionAddress   `json:"mailFrom"`
+	RcptTo   []submissionAddress `json:"rcptTo"`
+}
+
+// deliveryStatus is the delivery status for a recipient. ../rfc/8621:3850
+type deliveryStatus struct {
+	SMTPReply string `json:"smtpReply"`
+	Delivered string `json:"delivered"` // queued, yes, no, unknown.
+	Displayed string `json:"displayed"` // Always unknown.
+}
+
+// queueStatus is the state of the queued messages of a submission.
+type queueStatus struct {
+	queued  map[int64]queue.Msg
+	retired map[int64]queue.MsgRetired
+}
+
+// xqueueStatus looks up the queued and retired messages for submissions.
+func (c *call) xqueueStatus(subs []store.EmailSubmission) queueStatus {
+	var ids []int64
+	for _, sub := range subs {
+		ids = append(ids, sub.QueueMsgIDs...)
+	}
+	qs := queueStatus{map[int64]queue.Msg{}, map[int64]queue.MsgRetired{}}
+	if len(ids) == 0 {
+		return qs
+	}
+	msgs, err := queue.List(c.ctx, queue.Filter{IDs: ids, Account: c.acc.Name}, queue.Sort{})
+	xcheckf(err, "listing queued messages")
+	for _, qm := range msgs {
+		qs.queued[qm.ID] = qm
+	}
+	retired, err := queue.RetiredList(c.ctx, queue.RetiredFilter{IDs: ids, Account: c.acc.Name}, queue.RetiredSort{})
+	xcheckf(err, "listing retired queue messages")
+	for _, mr := range retired {
+		qs.retired[mr.ID] = mr
+	}
+	return qs
+}
+
+// undoStatus returns pending if no delivery has been attempted for any
+// recipient, final otherwise, or canceled.
+func (qs queueStatus) undoStatus(sub store.EmailSubmission) string {
+	if sub.Canceled {
+		return "canceled"
+	}
+	for _, id := range sub.QueueMsgIDs {
+		qm, ok := qs.queued[id]
+		if !ok || qm.Attempts > 0 || qm.LastAttempt != nil {
+			return "final"
+		}
+	}
+	return "pending"
+}
+
+func smtpReply(l []queue.MsgResult) string {
+	if len(l) == 0 {
+		return "250 2.1.5 Queued"
+	}
+	r := l[len(l)-1]
+	code := r.Code
+	if code == 0 {
+		if r.Success {
+			code = 250
+		} else {
+			code = 451
+		}
+	}
+	s := strconv.Itoa(code)
+	if r.Secode != "" {
+		s += " " + fmt.Sprintf("%d.%s", code/100, r.Secode)
+	}
+	if r.Error != "" {
+		s += " " + r.Error
+	}
+	return s
+}
+
+func (qs queueStatus) deliveryStatus(sub store.EmailSubmission) map[string]deliveryStatus {
+	r := map[string]deliveryStatus{}
+	for i, rcpt := range sub.RcptTo {
+		ds := deliveryStatus{"250 2.1.5 Queued", "unknown", "unknown"}
+		if i < len(sub.QueueMsgIDs) {
+			id := sub.QueueMsgIDs[i]
+			if qm, ok := qs.queued[id]; ok {
+				ds.Delivered = "queued"
+				ds.SMTPReply = smtpReply(qm.Results)
+			} else if mr, ok := qs.retired[id]; ok {
+				ds.Delivered = "no"
+				if mr.Success {
+					ds.Delivered = "yes"
+				}
+				ds.SMTPReply = smtpReply(mr.Results)
+			}
+		}
+		r[rcpt] = ds
+	}
+	return r
+}
+
+func (c *call) submissionObject(sub store.EmailSubmission, qs queueStatus, props map[string]bool) map[string]any {
+	rcptTo := []submissionAddress{}
+	for _, rcpt := range sub.RcptTo {
+		rcptTo = append(rcptTo, submissionAddress{Email: rcpt})
+	}
+	all := map[string]any{
+		"id":             submissionID(sub.ID),
+		"identityId":     sub.IdentityID,
+		"emailId":        emailID(sub.MessageID),
+		"threadId":       threadID(sub.ThreadID),
+		"envelope":       submissionEnvelope{submissionAddress{Email: sub.MailFrom}, rcptTo},
+		"sendAt":         utcDate(sub.SendAt),
+		"undoStatus":     qs.undoStatus(sub),
+		"deliveryStatus": qs.deliveryStatus(sub),
+		"dsnBlobIds":     []string{},
+		"mdnBlobIds":     []string{},
+	}
+	if props == nil {
+		return all
+	}
+	r := map[string]any{}
+	for p := range props {
+		r[p] = all[p]
+	}
+	return r
+}
+
+// xsubmission returns the non-expunged submission for id.
+func xsubmission(tx *bstore.Tx, id string) store.EmailSubmission {
+	sub := store.EmailSubmission{ID: parseID('s', id)}
+	if sub.ID == 0 {
+		xsetErrorf("notFound", "submission not found")
+	}
+	err := tx.Get(&sub)
+	if err == bstore.ErrAbsent || err == nil && sub.Expunged {
+		xsetErrorf("notFound", "submission not found")
+	}
+	xcheckf(err, "get submission")
+	return sub
+}
+
+func (c *call) submissionGet(args getArgs) getResult {
+	props := c.xcheckGet(args, submissionProperties)
+
+	r := getResult{
+		AccountID: args.AccountID,
+		List:      []map[string]any{},
+		NotFound:  []string{},
+	}
+	var subs []store.EmailSubmission
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			r.State = modseqState(xlastModSeq(tx))
+
+			if args.IDs == nil {
+				var err error
+				subs, err = bstore.QueryTx[store.EmailSubmission](tx).FilterEqual("Expunged", false).SortAsc("ID").List()
+				xcheckf(err, "listing submissions")
+				return
+			}
+			for _, id := range args.IDs {
+				var sub store.EmailSubmission
+				if serr := xsetItem(func() { sub = xsubmission(tx, id) }); serr != nil {
+					r.NotFound = append(r.NotFound, id)
+				} else {
+					subs = append(subs, sub)
+				}
+			}
+		})
+	})
+	qs := c.xqueueStatus(subs)
+	for _, sub := range subs {
+		r.List = append(r.List, c.submissionObject(sub, qs, props))
+	}
+	return r
+}
+
+func (c *call) submissionChanges(args changesArgs) changesResult {
+	c.xaccount(args.AccountID)
+
+	var r changesResult
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			last := xlastModSeq(tx)
+			since := c.xsinceModSeq(tx, args.SinceState, last)
+
+			var l []objectChange
+			q := bstore.QueryTx[store.EmailSubmission](tx)
+			q.FilterGreater("ModSeq", since)
+			err := q.ForEach(func(sub store.EmailSubmission) error {
+				oc := objectChange{id: submissionID(sub.ID), modseq: sub.ModSeq}
+				if sub.Expunged {
+					if sub.CreateSeq > since {
+						return nil
+					}
+					oc.kind = changeDestroyed
+				} else if sub.CreateSeq > since {
+					oc.kind = changeCreated
+				} else {
+					oc.kind = changeUpdated
+				}
+				l = append(l, oc)
+				return nil
+			})
+			xcheckf(err, "listing changed submissions")
+			r = c.xchangesResult(args, last, l)
+		})
+	})
+	return r
+}
+
+// EmailSubmission/query filter condition. ../rfc/8621:3990
+type submissionFilter struct {
+	IdentityIDs []string `json:"identityIds"`
+	EmailIDs    []string `json:"emailIds"`
+	ThreadIDs   []string `json:"threadIds"`
+	UndoStatus  *string  `json:"undoStatus"`
+	Before      *string  `json:"before"`
+	After       *string  `json:"after"`
+}
+
+func (c *call) submissionQuery(args queryArgs) queryResult {
+	c.xaccount(args.AccountID)
+	f := xparseFilter[submissionFilter](args.Filter)
+	f.walk(func(fc submissionFilter) {
+		if fc.Before != nil {
+			xparseUTCDate(*fc.Before)
+		}
+		if fc.After != nil {
+			xparseUTCDate(*fc.After)
+		}
+	})
+	for _, cmp := range args.Sort {
+		if !slices.Contains([]string{"emailId", "threadId", "sentAt"}, cmp.Property) {
+			xmethodErrorf("unsupportedSort", "unsupported sort property %q", cmp.Property)
+		}
+	}
+
+	var subs []store.EmailSubmission
+	var state string
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			state = modseqState(xlastModSeq(tx))
+			var err error
+			subs, err = bstore.QueryTx[store.EmailSubmission](tx).FilterEqual("Expunged", false).List()
+			xcheckf(err, "listing submissions")
+		})
+	})
+	qs := c.xqueueStatus(subs)
+
+	subs = slices.DeleteFunc(subs, func(sub store.EmailSubmission) bool {
+		return !f.match(func(fc submissionFilter) bool {
+			if fc.IdentityIDs != nil && !slices.Contains(fc.IdentityIDs, sub.IdentityID) {
+				return false
+			}
+			if fc.EmailIDs != nil && !slices.ContainsFunc(fc.EmailIDs, func(id string) bool { return c.xresolveID(id) == emailID(sub.MessageID) }) {
+				return false
+			}
+			if fc.ThreadIDs != nil && !slices.Contains(fc.ThreadIDs, threadID(sub.ThreadID)) {
+				return false
+			}
+			if fc.UndoStatus != nil && qs.undoStatus(sub) != *fc.UndoStatus {
+				return false
+			}
+			if fc.Before != nil && !sub.SendAt.Before(xparseUTCDate(*fc.Before)) {
+				return false
+			}
+			if fc.After != nil && sub.SendAt.Before(xparseUTCDate(*fc.After)) {
+				return false
+			}
+			return true
+		})
+	})
+
+	slices.SortStableFunc(subs, func(a, b store.EmailSubmission) int {
+		for _, cmp := range args.Sort {
+			var v int
+			switch cmp.Property {
+			case "emailId":
+				v = int(a.MessageID - b.MessageID)
+			case "threadId":
+				v = int(a.ThreadID - b.ThreadID)
+			case "sentAt":
+				v = a.SendAt.Compare(b.SendAt)
+			}
+			if !cmp.ascending() {
+				v = -v
+			}
+			if v != 0 {
+				return v
+			}
+		}
+		return int(a.ID - b.ID)
+	})
+	ids := make([]string, len(subs))
+	for i, sub := range subs {
+		ids[i] = submissionID(sub.ID)
+	}
+	return c.xqueryResult(args, state, ids)
+}
+
+// EmailSubmission/set arguments. ../rfc/8621:4050
+type submissionSetArgs struct {
+	setArgs
+	OnSuccessUpdateEmail  map[string]map[string]json.RawMessage `json:"onSuccessUpdateEmail"`
+	OnSuccessDestroyEmail []string                              `json:"onSuccessDestroyEmail"`
+}
+
+// submissionCreate is an EmailSubmission object in a create.
+type submissionCreate struct {
+	IdentityID string              `json:"identityId"`
+	EmailID    string              `json:"emailId"`
+	Envelope   *submissionEnvelope `json:"envelope"`
+}
+
+// xholdUntil returns the time delivery is scheduled for based on the
+// FUTURERELEASE parameters of the MAIL FROM, or the zero time. ../rfc/4865:170
+func xholdUntil(params map[string]*string) (until time.Time, request string) {
+	for k, v := range params {
+		if v == nil {
+			xinvalidPropertiesf("envelope", "missing value for mail from parameter %q", k)
+		}
+		switch strings.ToUpper(k) {
+		case "HOLDFOR":
+			n, err := strconv.ParseInt(*v, 10, 64)
+			if err != nil || n < 0 || time.Duration(n)*time.Second > queue.FutureReleaseIntervalMax {
+				xinvalidPropertiesf("envelope", "invalid holdfor %q", *v)
+			}
+			until = time.Now().Add(time.Duration(n) * time.Second)
+			request = "for;" + *v
+		case "HOLDUNTIL":
+			t, err := time.Parse(time.RFC3339, *v)
+			if err != nil || time.Until(t) > queue.FutureReleaseIntervalMax {
+				xinvalidPropertiesf("envelope", "invalid holduntil %q", *v)
+			}
+			until = t
+			request = "until;" + t.UTC().Format(time.RFC3339)
+		default:
+			xinvalidPropertiesf("envelope", "unsupported mail from parameter %q", k)
+		}
+	}
+	return
+}
+
+// xcheckIdentityFrom checks the message From address matches the identity and
+// is allowed for the account.
+func (c *call) xcheckIdentityFrom(ident identity, from smtp.Address) {
+	lp, dom, _ := strings.Cut(ident.Email, "@")
+	if lp == "*" {
+		if !strings.EqualFold(dom, from.Domain.Name()) {
+			xsetErrorf("forbiddenFrom", "from address does not match identity domain")
+		}
+	} else if !strings.EqualFold(ident.Email, from.String()) {
+		xsetErrorf("forbiddenFrom", "from address does not match identity")
+	}
+	if ok, disabled := mox.AllowMsgFrom(c.acc.Name, from); disabled {
+		metricSubmission.WithLabelValues("domaindisabled").Inc()
+		xsetErrorf("forbiddenFrom", "domain of from address is temporarily disabled")
+	} else if !ok {
+		metricSubmission.WithLabelValues("badfrom").Inc()
+		xsetErrorf("forbiddenFrom", "from address not configured for account")
+	}
+}
+
+// submitMessage is a message prepared for submission.
+type submitMessage struct {
+	m          store.Message
+	data       []byte // Message without Bcc header.
+	from       smtp.Address
+	mailFrom   smtp.Path
+	rcpts      []smtp.Path
+	messageID  string
+	subject    string
+	holdUntil  time.Time
+	holdReq    string
+	smtputf8   bool
+	has8bit    bool
+	identityID string
+}
+
+// xprepareSubmission reads the message to submit and determines the envelope.
+func (c *call) xprepareSubmission(tx *bstore.Tx, sc submissionCreate) submitMessage {
+	ident, ok := c.xidentity(sc.IdentityID)
+	if !ok {
+		xinvalidPropertiesf("identityId", "unknown identity")
+	}
+	m, ok := xvisibleMessage(tx, c.xresolveSetID(sc.EmailID))
+	if !ok {
+		xinvalidPropertiesf("emailId", "unknown email")
+	}
+
+	msgr := c.acc.MessageReader(m)
+	defer func() {
+		err := msgr.Close()
+		c.log.Check(err, "closing message reader")
+	}()
+	p, err := m.LoadPart(msgr)
+	xcheckf(err, "load parsed message")
+	if m.Size > c.s.maxMsgSize {
+		xsetErrorf("tooLarge", "message too large")
+	}
+	buf, err := io.ReadAll(io.NewSectionReader(msgr, 0, m.Size))
+	xcheckf(err, "reading message")
+	if p.BodyOffset > int64(len(buf)) {
+		xsetErrorf("invalidEmail", "invalid message")
+	}
+
+	// Remove Bcc headers. ../rfc/8621:4168
+	var hdr bytes.Buffer
+	for _, h := range parseRawHeaders(buf[:p.BodyOffset]) {
+		if !strings.EqualFold(h.Name, "Bcc") {
+			fmt.Fprintf(&hdr, "%s:%s\r\n", h.Name, h.Value)
+		}
+	}
+	hdr.WriteString("\r\n")
+	data := append(hdr.Bytes(), buf[p.BodyOffset:]...)
+
+	env := p.Envelope
+	if env == nil || len(env.From) != 1 {
+		xsetErrorf("invalidEmail", "message must have a single from address")
+	}
+	xfromAddr := func(a message.Address, errType string) smtp.Address {
+		addr, err := smtp.ParseAddress(a.User + "@" + a.Host)
+		if err != nil {
+			xsetErrorf(errType, "invalid address %q: %v", a.User+"@"+a.Host, err)
+		}
+		return addr
+	}
+	sm := submitMessage{
+		m:          m,
+		data:       data,
+		from:       xfromAddr(env.From[0], "invalidEmail"),
+		messageID:  env.MessageID,
+		subject:    env.Subject,
+		identityID: ident.ID,
+	}
+	c.xcheckIdentityFrom(ident, sm.from)
+
+	sm.mailFrom = sm.from.Path()
+	if sc.Envelope != nil {
+		addr, err := smtp.ParseAddress(sc.Envelope.MailFrom.Email)
+		if err != nil {
+			xinvalidPropertiesf("envelope", "invalid mail from address: %v", err)
+		}
+		if ok, _ := mox.AllowMsgFrom(c.acc.Name, addr); !ok {
+			xsetErrorf("forbiddenMailFrom", "mail from address not configured for account")
+		}
+		sm.mailFrom = addr.Path()
+		sm.holdUntil, sm.holdReq = xholdUntil(sc.Envelope.MailFrom.Parameters)
+		for _, rcpt := range sc.Envelope.RcptTo {
+			if len(rcpt.Parameters) > 0 {
+				xinvalidPropertiesf("envelope", "rcpt to parameters not supported")
+			}
+			addr, err := smtp.ParseAddress(rcpt.Email)
+			if err != nil {
+				xsetErrorf("invalidRecipients", "invalid recipient address %q: %v", rcpt.Email, err)
+			}
+			sm.rcpts = append(sm.rcpts, addr.Path())
+		}
+	} else {
+		for _, a := range slices.Concat(env.To, env.CC, env.BCC) {
+			sm.rcpts = append(sm.rcpts, xfromAddr(a, "invalidRecipients").Path())
+		}
+	}
+	if len(sm.rcpts) == 0 {
+		xsetErrorf("noRecipients", "no recipients")
+	}
+
+	msglimit, rcptlimit, err := c.acc.SendLimitReached(tx, sm.rcpts)
+	if msglimit >= 0 {
+		metricSubmission.WithLabelValues("messagelimiterror").Inc()
+		xsetErrorf("forbiddenToSend", "outgoing message rate limit reached")
+	} else if rcptlimit >= 0 {
+		metricSubmission.WithLabelValues("recipientlimiterror").Inc()
+		xsetErrorf("forbiddenToSend", "outgoing new recipient rate limit reached")
+	}
+	xcheckf(err, "checking send limit")
+
+	sm.smtputf8 = sm.mailFrom.Localpart.IsInternational() || slices.ContainsFunc(sm.rcpts, func(p smtp.Path) bool { return p.Localpart.IsInternational() })
+	sm.has8bit = sm.smtputf8 || slices.ContainsFunc(data, func(b byte) bool { return b >= 0x80 })
+	return sm
+}
+
+// xsubmit adds the message to the queue for each recipient, returning the queue
+// message ids.
+func (c *call) xsubmit(sm submitMessage) []int64 {
+	// Similar between ../smtpserver/server.go:/submit\( and ../webmail/api.go:/MessageSubmit\( and ../webapisrv/server.go:/Send\(
+
+	dataFile, err := store.CreateMessageTemp(c.log, "jmap-submit")
+	xcheckf(err, "creating temporary file for message")
+	defer store.CloseRemoveTempFile(c.log, dataFile, "message to submit")
+	_, err = dataFile.Write(sm.data)
+	xcheckf(err, "writing message to submit")
+
+	// Add DKIM-Signature headers.
+	var msgPrefix string
+	fd := sm.from.Domain
+	confDom, _ := mox.Conf.Domain(fd)
+	selectors := mox.DKIMSelectors(confDom.DKIM)
+	if len(selectors) > 0 {
+		dkimHeaders, err := dkim.Sign(c.ctx, c.log.Logger, sm.from.Localpart, fd, selectors, sm.smtputf8, dataFile)
+		if err != nil {
+			metricServerErrors.WithLabelValues("dkimsign").Inc()
+		}
+		xcheckf(err, "sign dkim")
+		msgPrefix = dkimHeaders
+	}
+
+	// Each queued message gets a Received header. Like webmail and webapi, we don't
+	// add the IP address of the submitter.
+	recvFrom := message.HeaderCommentDomain(mox.Conf.Static.HostnameDomain, sm.smtputf8)
+	recvBy := mox.Conf.Static.HostnameDomain.XName(sm.smtputf8)
+	recvID := mox.ReceivedID(mox.CidFromCtx(c.ctx))
+	recvHdrFor := func(rcptTo string) string {
+		recvHdr := &message.HeaderWriter{}
+		recvHdr.Add(" ", "Received:", "from", recvFrom, "by", recvBy, "id", recvID) // ../rfc/5321:3158
+		if c.r.TLS != nil {
+			recvHdr.Add(" ", mox.TLSReceivedComment(c.log, *c.r.TLS)...)
+		}
+		recvHdr.Add(" ", "for", "<"+rcptTo+">;", time.Now().Format(message.RFC5322Z))
+		return recvHdr.String()
+	}
+
+	qml := make([]queue.Msg, len(sm.rcpts))
+	now := time.Now()
+	for i, rcpt := range sm.rcpts {
+		// Don't use per-recipient unique message prefix when multiple recipients are
+		// present, we want to keep the message identical.
+		var recvRcpt string
+		if len(sm.rcpts) == 1 {
+			recvRcpt = rcpt.XString(sm.smtputf8)
+		}
+		rcptMsgPrefix := recvHdrFor(recvRcpt) + msgPrefix
+		msgSize := int64(len(rcptMsgPrefix)) + int64(len(sm.data))
+		qm := queue.MakeMsg(sm.mailFrom, rcpt, sm.has8bit, sm.smtputf8, msgSize, sm.messageID, []byte(rcptMsgPrefix), nil, now, sm.subject)
+		if !sm.holdUntil.IsZero() {
+			qm.NextAttempt = sm.holdUntil
+			qm.FutureReleaseRequest = sm.holdReq
+		}
+		qml[i] = qm
+	}
+	err = queue.Add(c.ctx, c.log, c.acc.Name, dataFile, qml...)
+	if err != nil {
+		metricSubmission.WithLabelValues("queueerror").Inc()
+	}
+	xcheckf(err, "adding messages to the delivery queue")
+	metricSubmission.WithLabelValues("ok").Inc()
+
+	ids := make([]int64, len(qml))
+	for i, qm := range qml {
+		ids[i] = qm.ID
+	}
+	return ids
+}
+
+// xparseSubmissionCreate parses a create object.
+func xparseSubmissionCreate(raw json.RawMessage) submissionCreate {
+	var sc submissionCreate
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sc); err != nil {
+		xsetErrorf("invalidProperties", "parsing email submission: %v", err)
+	}
+	return sc
+}
+
+// xcreateSubmission prepares and queues a message, and stores the submission.
+func (c *call) xcreateSubmission(raw json.RawMessage) store.EmailSubmission {
+	sc := xparseSubmissionCreate(raw)
+
+	var sm submitMessage
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			sm = c.xprepareSubmission(tx, sc)
+		})
+	})
+
+	queueIDs := c.xsubmit(sm)
+
+	// Message has been added to the queue. Ensure we finish the work.
+	ctx := context.WithoutCancel(c.ctx)
+
+	sub := store.EmailSubmission{
+		IdentityID:  sm.identityID,
+		MessageID:   sm.m.ID,
+		ThreadID:    threadIDOf(sm.m),
+		MailFrom:    sm.mailFrom.XString(true),
+		QueueMsgIDs: queueIDs,
+		SendAt:      time.Now(),
+	}
+	if !sm.holdUntil.IsZero() {
+		sub.SendAt = sm.holdUntil
+	}
+	for _, rcpt := range sm.rcpts {
+		sub.RcptTo = append(sub.RcptTo, rcpt.XString(true))
+	}
+	c.acc.WithWLock(func() {
+		xdbwrite(ctx, c.acc, func(tx *bstore.Tx) {
+			modseq, err := c.acc.NextModSeq(tx)
+			xcheckf(err, "next modseq")
+			sub.CreateSeq = modseq
+			sub.ModSeq = modseq
+			err = tx.Insert(&sub)
+			xcheckf(err, "inserting submission")
+		})
+	})
+	return sub
+}
+
+// xcancelSubmission removes the queued messages of a pending submission from
+// the queue.
+func (c *call) xcancelSubmission(tx *bstore.Tx, sub store.EmailSubmission, modseq store.ModSeq) {
+	if sub.Canceled {
+		return
+	}
+	qs := c.xqueueStatus([]store.EmailSubmission{sub})
+	if qs.undoStatus(sub) != "pending" {
+		xsetErrorf("cannotUnsend", "delivery has already been attempted")
+	}
+	if len(sub.QueueMsgIDs) > 0 {
+		_, err := queue.Drop(c.ctx, c.log, queue.Filter{IDs: sub.QueueMsgIDs, Account: c.acc.Name})
+		xcheckf(err, "removing messages from queue")
+	}
+	sub.Canceled = true
+	sub.ModSeq = modseq
+	err := tx.Update(&sub)
+	xcheckf(err, "updating submission")
+}
+
+func (c *call) submissionSet(args submissionSetArgs) setResult {
+	c.xaccount(args.AccountID)
+
+	var r setResult
+	r.AccountID = args.AccountID
+
+	var oldState string
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			oldState = modseqState(xlastModSeq(tx))
+		})
+	})
+	c.xcheckSet(args.setArgs, oldState)
+	r.OldState = &oldState
+	for ref := range args.OnSuccessUpdateEmail {
+		if !strings.HasPrefix(ref, "#") {
+			xinvalidArgumentsf("onSuccessUpdateEmail keys must reference a created submission")
+		}
+	}
+	for _, ref := range args.OnSuccessDestroyEmail {
+		if !strings.HasPrefix(ref, "#") {
+			xinvalidArgumentsf("onSuccessDestroyEmail values must reference a created submission")
+		}
+	}
+
+	// Email ids of submissions created in this call, for onSuccess references.
+	createdEmails := map[string]string{}
+
+	creationIDs := make([]string, 0, len(args.Create))
+	for cid := range args.Create {
+		creationIDs = append(creationIDs, cid)
+	}
+	slices.Sort(creationIDs)
+	for _, cid := range creationIDs {
+		serr := xsetItem(func() {
+			sub := c.xcreateSubmission(args.Create[cid])
+			c.createdIDs[cid] = submissionID(sub.ID)
+			createdEmails[submissionID(sub.ID)] = emailID(sub.MessageID)
+			r.addCreated(cid, map[string]any{"id": submissionID(sub.ID), "threadId": threadID(sub.ThreadID), "sendAt": utcDate(sub.SendAt), "undoStatus": "pending"})
+		})
+		if serr != nil {
+			r.addNotCreated(cid, serr)
+		}
+	}
+
+	c.acc.WithWLock(func() {
+		xdbwrite(c.ctx, c.acc, func(tx *bstore.Tx) {
+			var modseq store.ModSeq
+			xmodseq := func() store.ModSeq {
+				if modseq == 0 {
+					var err error
+					modseq, err = c.acc.NextModSeq(tx)
+					xcheckf(err, "next modseq")
+				}
+				return modseq
+			}
+
+			// Only canceling is supported. ../rfc/8621:4214
+			for id, patch := range args.Update {
+				serr := xsetItem(func() {
+					sub := xsubmission(tx, c.xresolveSetID(id))
+					for k, v := range patch {
+						if k != "undoStatus" {
+							if slices.Contains(submissionProperties, k) {
+								xinvalidPropertiesf(k, "property %q cannot be changed", k)
+							}
+							xinvalidPropertiesf(k, "unknown property %q", k)
+						}
+						var s string
+						if err := json.Unmarshal(v, &s); err != nil || s != "canceled" {
+							xinvalidPropertiesf(k, "undoStatus can only be changed to canceled")
+						}
+						c.xcancelSubmission(tx, sub, xmodseq())
+					}
+					r.addUpdated(id, nil)
+				})
+				if serr != nil {
+					r.addNotUpdated(id, serr)
+				}
+			}
+
+			// Destroying a submission does not affect delivery. ../rfc/8621:4240
+			for _, id := range args.Destroy {
+				serr := xsetItem(func() {
+					sub := xsubmission(tx, c.xresolveSetID(id))
+					sub.Expunged = true
+					sub.ModSeq = xmodseq()
+					err := tx.Update(&sub)
+					xcheckf(err, "updating submission")
+					r.Destroyed = append(r.Destroyed, id)
+				})
+				if serr != nil {
+					r.addNotDestroyed(id, serr)
+				}
+			}
+
+			r.NewState = modseqState(xlastModSeq(tx))
+		})
+	})
+
+	// Implicit Email/set for successfully created submissions. ../rfc/8621:4080
+	emailFor := func(ref string) (string, bool) {
+		subID, ok := c.createdIDs[ref[1:]]
+		if !ok {
+			return "", false
+		}
+		emailID, ok := createdEmails[subID]
+		return emailID, ok
+	}
+	emailArgs := setArgs{AccountID: args.AccountID}
+	for ref, patch := range args.OnSuccessUpdateEmail {
+		if id, ok := emailFor(ref); ok {
+			if emailArgs.Update == nil {
+				emailArgs.Update = map[string]map[string]json.RawMessage{}
+			}
+			emailArgs.Update[id] = patch
+		}
+	}
+	for _, ref := range args.OnSuccessDestroyEmail {
+		if id, ok := emailFor(ref); ok {
+			emailArgs.Destroy = append(emailArgs.Destroy, id)
+		}
+	}
+	if emailArgs.Update != nil || emailArgs.Destroy != nil {
+		er := c.emailSet(emailArgs)
+		buf, err := json.Marshal(er)
+		xcheckf(err, "marshal email set response")
+		c.extra = append(c.extra, invocation{"Email/set", buf, c.callID})
+	}
+	return r
+}
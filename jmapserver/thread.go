@@ -0,0 +1,112 @@
+package jmapserver
+
+import (
+	"slices"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/store"
+)
+
+// xthreadMessages returns the visible messages in a thread, oldest first.
+func xthreadMessages(tx *bstore.Tx, tid int64) []store.Message {
+	q := bstore.QueryTx[store.Message](tx)
+	q.FilterNonzero(store.Message{ThreadID: tid})
+	q.FilterEqual("Expunged", false)
+	q.FilterEqual("Deleted", false)
+	l, err := q.List()
+	xcheckf(err, "listing messages in thread")
+	if len(l) == 0 {
+		// Messages without thread assignment are their own thread.
+		m := store.Message{ID: tid}
+		if err := tx.Get(&m); err == nil && m.ThreadID == 0 && !m.Expunged && !m.Deleted {
+			l = []store.Message{m}
+		}
+	}
+	slices.SortFunc(l, func(a, b store.Message) int {
+		if v := a.Received.Compare(b.Received); v != 0 {
+			return v
+		}
+		return int(a.ID - b.ID)
+	})
+	return l
+}
+
+// ../rfc/8621:305
+func (c *call) threadGet(args getArgs) getResult {
+	c.xcheckGet(args, []string{"id", "emailIds"})
+	if args.IDs == nil {
+		xmethodErrorf("requestTooLarge", "ids must be specified")
+	}
+
+	r := getResult{
+		AccountID: args.AccountID,
+		List:      []map[string]any{},
+		NotFound:  []string{},
+	}
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			r.State = modseqState(xlastModSeq(tx))
+
+			for _, id := range args.IDs {
+				tid := parseID('t', id)
+				var l []store.Message
+				if tid != 0 {
+					l = xthreadMessages(tx, tid)
+				}
+				if len(l) == 0 {
+					r.NotFound = append(r.NotFound, id)
+					continue
+				}
+				emailIDs := make([]string, len(l))
+				for i, m := range l {
+					emailIDs[i] = emailID(m.ID)
+				}
+				r.List = append(r.List, map[string]any{"id": id, "emailIds": emailIDs})
+			}
+		})
+	})
+	return r
+}
+
+// Threads change when messages in them change. A thread is created when its
+// oldest visible message is new, and destroyed when it has no visible messages
+// left.
+func (c *call) threadChanges(args changesArgs) changesResult {
+	c.xaccount(args.AccountID)
+
+	var r changesResult
+	c.acc.WithRLock(func() {
+		xdbread(c.ctx, c.acc, func(tx *bstore.Tx) {
+			last := xlastModSeq(tx)
+			since := c.xsinceModSeq(tx, args.SinceState, last)
+
+			// Highest modseq of changed messages for each thread.
+			threads := map[int64]store.ModSeq{}
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterGreater("ModSeq", since)
+			err := q.ForEach(func(m store.Message) error {
+				tid := threadIDOf(m)
+				threads[tid] = max(threads[tid], m.ModSeq)
+				return nil
+			})
+			xcheckf(err, "listing changed messages")
+
+			var l []objectChange
+			for tid, modseq := range threads {
+				oc := objectChange{id: threadID(tid), modseq: modseq}
+				ml := xthreadMessages(tx, tid)
+				if len(ml) == 0 {
+					oc.kind = changeDestroyed
+				} else if slices.IndexFunc(ml, func(m store.Message) bool { return m.CreateSeq <= since }) < 0 {
+					oc.kind = changeCreated
+				} else {
+					oc.kind = changeUpdated
+				}
+				l = append(l, oc)
+			}
+			r = c.xchangesResult(args, last, l)
+		})
+	})
+	return r
+}
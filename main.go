@@ -230,6 +230,8 @@ var commands = []struct {
 	{"junk play", cmdJunkPlay},
 	{"junk test", cmdJunkTest},
 	{"junk train", cmdJunkTrain},
+	{"reservoir test", cmdReservoirTest},
+	{"reservoir train", cmdReservoirTrain},
 	{"dmarcdb addreport", cmdDMARCDBAddReport},
 	{"tlsrptdb addreport", cmdTLSRPTDBAddReport},
 	{"updates addsigned", cmdUpdatesAddSigned},
@@ -1861,7 +1863,12 @@ func ctlcmdSetaccountpassword(ctl *ctl, account, password string) {
 func cmdDeliver(c *cmd) {
 	c.unlisted = true
 	c.params = "address < message"
-	c.help = "Deliver message to address."
+	c.help = `Deliver message to address.
+
+Rulesets and the Sieve script of the account are applied. Sieve reject, redirect
+and vacation actions are only executed for messages delivered over SMTP, and are
+ignored by this command.
+`
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
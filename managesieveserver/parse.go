@@ -0,0 +1,171 @@
+package managesieveserver
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum size of a literal string sent by a client. Scripts can be at most
+// store.SieveScriptMaxSize, but we read larger literals so we can respond with
+// a quota error.
+const maxLiteral = 1024 * 1024
+
+// Quoted strings are limited in length. ../rfc/5804
+const maxQuoted = 1024
+
+// Literal at the end of a line, with size and optional "+" for non-synchronizing
+// literals. ../rfc/5804
+var literalRegexp = regexp.MustCompile(`\{([0-9]+)\+?\}$`)
+
+// parser parses the arguments of a command. Commands are typically on a single
+// line, but literal strings span lines: the literal data follows the line
+// containing the literal size, and the command continues on the line after the
+// literal data.
+type parser struct {
+	c    *conn
+	line string // Remainder of the current line, without crlf.
+}
+
+func newParser(c *conn, line string) *parser {
+	return &parser{c: c, line: line}
+}
+
+func (p *parser) empty() bool {
+	return p.line == ""
+}
+
+func (p *parser) xempty() {
+	if !p.empty() {
+		xsyntaxErrorf("leftover data %q", p.line)
+	}
+}
+
+func (p *parser) take(s string) bool {
+	if strings.HasPrefix(p.line, s) {
+		p.line = p.line[len(s):]
+		return true
+	}
+	return false
+}
+
+func (p *parser) xspace() {
+	if !p.take(" ") {
+		xsyntaxErrorf("expected space, got %q", p.line)
+	}
+}
+
+// xatom parses a command name or other token without quotes.
+func (p *parser) xatom() string {
+	n := 0
+	for n < len(p.line) {
+		ch := p.line[n]
+		if ch <= ' ' || ch >= 0x7f || strings.IndexByte(`"{}()%*\`, ch) >= 0 {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		xsyntaxErrorf("expected atom, got %q", p.line)
+	}
+	s := p.line[:n]
+	p.line = p.line[n:]
+	return s
+}
+
+// xnumber parses a number. ../rfc/5804
+func (p *parser) xnumber() int64 {
+	n := 0
+	for n < len(p.line) && p.line[n] >= '0' && p.line[n] <= '9' {
+		n++
+	}
+	if n == 0 {
+		xsyntaxErrorf("expected number, got %q", p.line)
+	}
+	v, err := strconv.ParseInt(p.line[:n], 10, 63)
+	if err != nil {
+		xsyntaxErrorf("parsing number: %v", err)
+	}
+	p.line = p.line[n:]
+	return v
+}
+
+// xstring parses a quoted string or a literal. ../rfc/5804
+func (p *parser) xstring() string {
+	if p.take(`"`) {
+		var b strings.Builder
+		for {
+			if p.line == "" {
+				xsyntaxErrorf("unterminated quoted string")
+			}
+			ch := p.line[0]
+			p.line = p.line[1:]
+			if ch == '"' {
+				break
+			} else if ch == '\\' {
+				if p.line == "" || p.line[0] != '"' && p.line[0] != '\\' {
+					xsyntaxErrorf("invalid escape in quoted string")
+				}
+				ch = p.line[0]
+				p.line = p.line[1:]
+			}
+			b.WriteByte(ch)
+			if b.Len() > maxQuoted {
+				xsyntaxErrorf("quoted string too long")
+			}
+		}
+		s := b.String()
+		if !utf8.ValidString(s) {
+			xsyntaxErrorf("invalid utf-8 in quoted string")
+		}
+		return s
+	}
+
+	m := literalRegexp.FindStringSubmatchIndex(p.line)
+	if m == nil || m[0] != 0 {
+		xsyntaxErrorf("expected quoted string or literal, got %q", p.line)
+	}
+	size, err := strconv.ParseInt(p.line[m[2]:m[3]], 10, 63)
+	if err != nil || size > maxLiteral {
+		// We cannot continue reading commands, the literal data would be interpreted as
+		// commands.
+		p.c.xbrokenf("literal size %q invalid or too large (%w)", p.line[m[2]:m[3]], errProtocol)
+	}
+	p.line = ""
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(p.c.br, buf); err != nil {
+		p.c.xbrokenf("reading literal: %s (%w)", err, errIO)
+	}
+	if !utf8.Valid(buf) {
+		// Read the remainder of the command so we can continue with the next command.
+		p.line = p.c.xreadline()
+		xsyntaxErrorf("invalid utf-8 in literal")
+	}
+	p.line = p.c.xreadline()
+	return string(buf)
+}
+
+// pendingLiteral returns whether the unparsed remainder of the line ends with a
+// literal, which means literal data follows that must be read before the next
+// command.
+func (p *parser) pendingLiteral() bool {
+	return literalRegexp.MatchString(p.line)
+}
+
+// quoted returns s as a string for a response: quoted if possible, and as
+// literal otherwise. ../rfc/5804
+func quoted(s string) string {
+	if len(s) > maxQuoted || strings.ContainsAny(s, "\r\n\x00") {
+		return literal(s)
+	}
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// literal returns s as literal string for a response. ../rfc/5804
+func literal(s string) string {
+	return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+}
@@ -0,0 +1,1214 @@
+// Package managesieveserver implements a ManageSieve server (RFC 5804), for
+// managing the Sieve scripts of an account from email applications.
+package managesieveserver
+
+/*
+Implementation notes
+
+- ManageSieve is a line-based protocol with commands, without tags, similar to IMAP. Strings are quoted or literals. Clients must use non-synchronizing literals, but we also accept synchronizing literals without sending a continuation, as other servers do. ../rfc/5804
+- Scripts are stored as store.SieveScript in the account database, and are also managed through the account web interface. Scripts are checked with the sieve package when stored, so only scripts that can be evaluated during delivery are accepted.
+- Authentication uses the same SASL mechanisms as IMAP and submission, and login attempts are recorded in the same way.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricConnection = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_managesieve_connection_total",
+			Help: "Incoming ManageSieve connections.",
+		},
+	)
+	metricCommands = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_managesieve_command_duration_seconds",
+			Help:    "ManageSieve command duration and result codes in seconds.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"cmd",
+			"result", // ok, panic, ioerror, badsyntax, servererror, usererror, error
+		},
+	)
+)
+
+var unhandledPanics atomic.Int64 // For tests.
+
+var limiterConnectionrate, limiterConnections *ratelimit.Limiter
+
+func init() {
+	// Also called by tests, so they don't trigger the rate limiter.
+	limitersInit()
+}
+
+func limitersInit() {
+	mox.LimitersInit()
+	limiterConnectionrate = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Minute,
+				Limits: [...]int64{60, 180, 540},
+			},
+		},
+	}
+	limiterConnections = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Duration(math.MaxInt64), // All of time.
+				Limits: [...]int64{10, 30, 90},
+			},
+		},
+	}
+}
+
+// Delay after bad/suspicious behaviour. Tests set these to zero.
+var badClientDelay = time.Second // Before reads and after 1-byte writes for probably spammers.
+var authFailDelay = time.Second  // After authentication failure.
+
+type conn struct {
+	cid               int64
+	conn              net.Conn
+	connBroken        bool // Once broken, we won't flush any more data.
+	tls               bool // Whether TLS has been initialized.
+	br                *bufio.Reader
+	tr                *moxio.TraceReader // Kept to change trace level when reading authentication data.
+	xbw               *bufio.Writer      // To remote, with TLS added in case of TLS. Writes go through xtw to conn.Write, which panics on errors, hence the "x".
+	xtw               *moxio.TraceWriter
+	slow              bool      // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	lastlog           time.Time // For printing time since previous log line.
+	baseTLSConfig     *tls.Config
+	remoteIP          net.IP
+	noRequireSTARTTLS bool
+	cmd               string // Currently executing, for logging and metrics.
+	cmdStart          time.Time
+	ncmds             int     // Number of commands processed. Used to abort connection when first incoming command is unknown/invalid.
+	p                 *parser // Parser for current command, for detecting unread literal data after an error.
+	log               mlog.Log
+
+	loginAttempt *store.LoginAttempt
+
+	// Only set when connection has been authenticated.
+	authFailed int    // Number of failed auth attempts. For slowing down remote with many failures.
+	username   string // Full username as used during login.
+	account    *store.Account
+}
+
+var commandsStateAny = map[string]bool{"capability": true, "logout": true, "noop": true}
+var commandsStateNotAuthenticated = map[string]bool{"starttls": true, "authenticate": true}
+
+var commands = map[string]func(c *conn, p *parser){
+	// Any state.
+	"capability": (*conn).cmdCapability,
+	"logout":     (*conn).cmdLogout,
+	"noop":       (*conn).cmdNoop,
+
+	// Not authenticated.
+	"starttls":     (*conn).cmdStarttls,
+	"authenticate": (*conn).cmdAuthenticate,
+
+	// Authenticated.
+	"havespace":    (*conn).cmdHavespace,
+	"putscript":    (*conn).cmdPutscript,
+	"listscripts":  (*conn).cmdListscripts,
+	"setactive":    (*conn).cmdSetactive,
+	"getscript":    (*conn).cmdGetscript,
+	"deletescript": (*conn).cmdDeletescript,
+	"renamescript": (*conn).cmdRenamescript,
+	"checkscript":  (*conn).cmdCheckscript,
+}
+
+var errIO = errors.New("io error")             // For read/write errors and errors that should close the connection.
+var errProtocol = errors.New("protocol error") // For protocol errors for which a stack trace should be printed.
+
+var sanityChecks bool
+
+// check err for sanity.
+// if not nil and checkSanity true (set during tests), then panic. if not nil during normal operation, just log.
+func (c *conn) xsanity(err error, format string, args ...any) {
+	if err == nil {
+		return
+	}
+	if sanityChecks {
+		panic(fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), err))
+	}
+	c.log.Errorx(fmt.Sprintf(format, args...), err)
+}
+
+func (c *conn) xbrokenf(format string, args ...any) {
+	c.connBroken = true
+	panic(fmt.Errorf(format, args...))
+}
+
+// Listen initializes all managesieve listeners for the configuration, and stores
+// them for Serve to start them.
+func Listen() {
+	names := slices.Sorted(maps.Keys(mox.Conf.Static.Listeners))
+	for _, name := range names {
+		listener := mox.Conf.Static.Listeners[name]
+
+		var tlsConfig *tls.Config
+		if listener.TLS != nil {
+			tlsConfig = listener.TLS.Config
+		}
+
+		if listener.ManageSieve.Enabled {
+			port := config.Port(listener.ManageSieve.Port, 4190)
+			for _, ip := range listener.IPs {
+				listen1(name, ip, port, tlsConfig, listener.ManageSieve.NoRequireSTARTTLS)
+			}
+		}
+	}
+}
+
+var servers []func()
+
+func listen1(listenerName, ip string, port int, tlsConfig *tls.Config, noRequireSTARTTLS bool) {
+	log := mlog.New("managesieveserver", nil)
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+	if os.Getuid() == 0 {
+		log.Print("listening for managesieve",
+			slog.String("listener", listenerName),
+			slog.String("addr", addr))
+	}
+	network := mox.Network(ip)
+	ln, err := mox.Listen(network, addr)
+	if err != nil {
+		log.Fatalx("managesieve: listen for managesieve", err, slog.String("listener", listenerName))
+	}
+
+	// Each listener gets its own copy of the config, so session keys between different
+	// ports on same listener aren't shared. See imapserver.
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig)
+	}
+
+	serve := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox("managesieve: accept", err, slog.String("listener", listenerName))
+				continue
+			}
+
+			metricConnection.Inc()
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, noRequireSTARTTLS)
+		}
+	}
+
+	servers = append(servers, serve)
+}
+
+// Serve starts serving on all listeners, launching a goroutine per listener.
+func Serve() {
+	for _, serve := range servers {
+		go serve()
+	}
+	servers = nil
+}
+
+// Logbg returns a logger for logging in the background (in a goroutine), eg for
+// logging LoginAttempts.
+func (c *conn) logbg() mlog.Log {
+	log := mlog.New("managesieveserver", nil).WithCid(c.cid)
+	if c.username != "" {
+		log = log.With(slog.String("username", c.username))
+	}
+	return log
+}
+
+func (c *conn) setSlow(on bool) {
+	if on && !c.slow {
+		c.log.Debug("connection changed to slow")
+	} else if !on && c.slow {
+		c.log.Debug("connection restored to regular pace")
+	}
+	c.slow = on
+}
+
+// Write makes a connection an io.Writer. It panics for i/o errors. These errors
+// are handled in the connection command loop.
+func (c *conn) Write(buf []byte) (int, error) {
+	chunk := len(buf)
+	if c.slow {
+		chunk = 1
+	}
+
+	var n int
+	for len(buf) > 0 {
+		err := c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		c.log.Check(err, "setting write deadline")
+
+		nn, err := c.conn.Write(buf[:chunk])
+		if err != nil {
+			c.xbrokenf("write: %s (%w)", err, errIO)
+		}
+		n += nn
+		buf = buf[chunk:]
+		if len(buf) > 0 && badClientDelay > 0 {
+			mox.Sleep(mox.Context, badClientDelay)
+		}
+	}
+	return n, nil
+}
+
+func (c *conn) xtraceread(level slog.Level) func() {
+	c.tr.SetTrace(level)
+	return func() {
+		c.tr.SetTrace(mlog.LevelTrace)
+	}
+}
+
+// Cache of line buffers for reading commands.
+var bufpool = moxio.NewBufpool(8, 16*1024)
+
+// xreadline reads a line, without crlf.
+func (c *conn) xreadline() string {
+	if c.slow && badClientDelay > 0 {
+		mox.Sleep(mox.Context, badClientDelay)
+	}
+
+	d := 5 * time.Minute
+	if c.account == nil {
+		d = 30 * time.Second
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(d))
+	c.log.Check(err, "setting read deadline")
+
+	line, err := bufpool.Readline(c.log, c.br)
+	if err != nil && errors.Is(err, moxio.ErrLineTooLong) {
+		c.xbrokenf("%s (%w)", err, errProtocol)
+	} else if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err := c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+			c.log.Check(err, "setting deadline")
+			c.xwriteresult("BYE", "", "inactive")
+		}
+		c.xbrokenf("%s (%w)", err, errIO)
+	}
+	return line
+}
+
+// Buffer line for write.
+func (c *conn) xbwritelinef(format string, args ...any) {
+	format += "\r\n"
+	fmt.Fprintf(c.xbw, format, args...)
+}
+
+func (c *conn) xwritelinef(format string, args ...any) {
+	c.xbwritelinef(format, args...)
+	c.xflush()
+}
+
+func (c *conn) xflush() {
+	// If the connection is already broken, we're not going to write more.
+	if c.connBroken {
+		return
+	}
+
+	err := c.xbw.Flush()
+	xcheckf(err, "flush") // Should never happen, the Write caused by the Flush should panic on i/o error.
+}
+
+// xwriteresult writes a response, "OK", "NO" or "BYE", with optional response
+// code (without parentheses) and human-readable text. ../rfc/5804
+func (c *conn) xwriteresult(result, code, text string) {
+	s := result
+	if code != "" {
+		s += " (" + code + ")"
+	}
+	if text != "" {
+		s += " " + quoted(text)
+	}
+	c.xwritelinef("%s", s)
+}
+
+func (c *conn) ok(text string) {
+	c.xwriteresult("OK", "", text)
+}
+
+var cleanClose struct{} // Sentinel value for panic/recover indicating clean close of connection.
+
+// serve handles a single ManageSieve connection on nc.
+//
+// If tlsConfig is set, STARTTLS may enable TLS. If noRequireSTARTTLS is set, TLS
+// is not required for authentication with plain text passwords.
+//
+// The connection is closed before returning.
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, noRequireSTARTTLS bool) {
+	var remoteIP net.IP
+	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = a.IP
+	} else {
+		// For tests.
+		remoteIP = net.ParseIP("127.0.0.10")
+	}
+
+	c := &conn{
+		cid:               cid,
+		conn:              nc,
+		lastlog:           time.Now(),
+		baseTLSConfig:     tlsConfig,
+		remoteIP:          remoteIP,
+		noRequireSTARTTLS: noRequireSTARTTLS,
+		cmd:               "(greeting)",
+		cmdStart:          time.Now(),
+	}
+	var logmutex sync.Mutex
+	// Also see (and possibly update) c.logbg, for logging in a goroutine.
+	c.log = mlog.New("managesieveserver", nil).WithFunc(func() []slog.Attr {
+		logmutex.Lock()
+		defer logmutex.Unlock()
+		now := time.Now()
+		l := []slog.Attr{
+			slog.Int64("cid", c.cid),
+			slog.Duration("delta", now.Sub(c.lastlog)),
+		}
+		c.lastlog = now
+		if c.username != "" {
+			l = append(l, slog.String("username", c.username))
+		}
+		return l
+	})
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+	c.xtw = moxio.NewTraceWriter(c.log, "S: ", c)
+	c.xbw = bufio.NewWriter(c.xtw)
+
+	c.log.Info("new connection",
+		slog.Any("remote", c.conn.RemoteAddr()),
+		slog.Any("local", c.conn.LocalAddr()),
+		slog.String("listener", listenerName))
+
+	defer func() {
+		err := c.conn.Close()
+		if err != nil {
+			c.log.Debugx("closing connection", err)
+		}
+
+		if c.account != nil {
+			err := c.account.Close()
+			c.xsanity(err, "close account")
+			c.account = nil
+		}
+
+		x := recover()
+		if x == nil || x == cleanClose {
+			c.log.Info("connection closed")
+		} else if err, ok := x.(error); ok && isClosed(err) {
+			c.log.Infox("connection closed", err)
+		} else {
+			c.log.Error("unhandled panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Managesieve)
+			unhandledPanics.Add(1) // For tests.
+		}
+	}()
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwriteresult("BYE", "", "mox shutting down")
+		return
+	default:
+	}
+
+	if !limiterConnectionrate.Add(c.remoteIP, time.Now(), 1) {
+		c.xwriteresult("BYE", "TRYLATER", "connection rate from your ip or network too high, slow down please")
+		return
+	}
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+		metrics.AuthenticationRatelimitedInc("managesieve")
+		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
+		c.xwriteresult("BYE", "TRYLATER", "too many auth failures")
+		return
+	}
+
+	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+		c.xwriteresult("BYE", "TRYLATER", "too many open connections from your ip or network")
+		return
+	}
+	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
+
+	// We register and unregister the original connection, in case it c.conn is
+	// replaced with a TLS connection later on.
+	mox.Connections.Register(nc, "managesieve", listenerName)
+	defer mox.Connections.Unregister(nc)
+
+	c.xbwriteCapabilities()
+	c.ok("mox managesieve ready")
+
+	for {
+		c.command()
+		c.xflush() // For flushing errors, or commands that did not flush explicitly.
+	}
+}
+
+// isClosed returns whether i/o failed, typically because the connection is closed.
+// For connection errors, we often want to generate fewer logs.
+func isClosed(err error) bool {
+	return errors.Is(err, errIO) || errors.Is(err, errProtocol) || mlog.IsClosed(err)
+}
+
+// newLoginAttempt initializes a c.loginAttempt, for adding to the store after
+// filling in the results and other details.
+func (c *conn) newLoginAttempt(authMech string) {
+	var state *tls.ConnectionState
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		v := tc.ConnectionState()
+		state = &v
+	}
+
+	localAddr := c.conn.LocalAddr().String()
+	localIP, _, _ := net.SplitHostPort(localAddr)
+	if localIP == "" {
+		localIP = localAddr
+	}
+
+	c.loginAttempt = &store.LoginAttempt{
+		RemoteIP: c.remoteIP.String(),
+		LocalIP:  localIP,
+		TLS:      store.LoginAttemptTLS(state),
+		Protocol: "managesieve",
+		AuthMech: authMech,
+		Result:   store.AuthError, // Replaced by caller.
+	}
+}
+
+// xtlsHandshake performs the TLS handshake on the connection.
+func (c *conn) xtlsHandshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, c.baseTLSConfig.Clone())
+	c.conn = tlsConn
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	c.log.Debug("starting tls server handshake")
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		c.xbrokenf("tls handshake: %s (%w)", err, errIO)
+	}
+	cancel()
+
+	cs := tlsConn.ConnectionState()
+	version, ciphersuite := moxio.TLSInfo(cs)
+	c.log.Debug("tls handshake completed",
+		slog.String("version", version),
+		slog.String("ciphersuite", ciphersuite),
+		slog.String("sni", cs.ServerName),
+		slog.Bool("resumed", cs.DidResume))
+}
+
+func (c *conn) command() {
+	var cmd string
+	c.p = nil
+
+	defer func() {
+		var result string
+		defer func() {
+			metricCommands.WithLabelValues(cmd, result).Observe(float64(time.Since(c.cmdStart)) / float64(time.Second))
+		}()
+
+		logFields := []slog.Attr{
+			slog.String("cmd", c.cmd),
+			slog.Duration("duration", time.Since(c.cmdStart)),
+		}
+		c.cmd = ""
+
+		x := recover()
+		if x == nil || x == cleanClose {
+			c.log.Debug("managesieve command done", logFields...)
+			result = "ok"
+			if x == cleanClose {
+				panic(x)
+			}
+			return
+		}
+		err, ok := x.(error)
+		if !ok {
+			c.log.Error("managesieve command panic", append([]slog.Attr{slog.Any("panic", x)}, logFields...)...)
+			result = "panic"
+			panic(x)
+		}
+
+		if isClosed(err) {
+			c.log.Infox("managesieve command ioerror", err, logFields...)
+			result = "ioerror"
+			if errors.Is(err, errProtocol) {
+				debug.PrintStack()
+			}
+			panic(err)
+		}
+
+		// If the command was aborted before reading a literal, the literal data would be
+		// read as commands. We have to abort the connection.
+		fatal := c.p != nil && c.p.pendingLiteral()
+
+		var sxerr syntaxError
+		var uerr userError
+		var serr serverError
+		if errors.As(err, &sxerr) {
+			result = "badsyntax"
+			if c.ncmds == 0 {
+				// Other side is likely speaking something else than ManageSieve, send error
+				// message and stop processing.
+				c.xwriteresult("BYE", "", "please try again speaking managesieve")
+				c.xbrokenf("not speaking managesieve (%w)", errIO)
+			}
+			c.log.Debugx("managesieve command syntax error", sxerr.err, logFields...)
+			c.xwriteresult("NO", "", "bad syntax: "+sxerr.errmsg)
+		} else if errors.As(err, &serr) {
+			result = "servererror"
+			c.log.Errorx("managesieve command server error", err, logFields...)
+			debug.PrintStack()
+			c.xwriteresult("NO", "", err.Error())
+		} else if errors.As(err, &uerr) {
+			result = "usererror"
+			c.log.Debugx("managesieve command user error", err, logFields...)
+			c.xwriteresult("NO", uerr.code, err.Error())
+		} else {
+			// Other type of panic, we pass it on, aborting the connection.
+			result = "panic"
+			c.log.Errorx("managesieve command panic", err, logFields...)
+			panic(err)
+		}
+		if fatal {
+			c.xwriteresult("BYE", "", "aborting connection after error for command with literal")
+			c.xbrokenf("aborting connection after error for command with literal (%w)", errProtocol)
+		}
+	}()
+
+	cmd = "(unrecognized)"
+	line := c.xreadline()
+	c.p = newParser(c, line)
+	c.cmdStart = time.Now()
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwriteresult("BYE", "", "shutting down")
+		c.xbrokenf("shutting down (%w)", errIO)
+	default:
+	}
+
+	cmdlow := strings.ToLower(c.p.xatom())
+	fn := commands[cmdlow]
+	if fn == nil {
+		xsyntaxErrorf("unknown command %q", cmdlow)
+	}
+	cmd = cmdlow
+	c.cmd = cmdlow
+	c.ncmds++
+
+	// Check if command is allowed in this state.
+	if commandsStateAny[cmdlow] {
+	} else if commandsStateNotAuthenticated[cmdlow] {
+		if c.account != nil {
+			xuserErrorf("already authenticated")
+		}
+	} else if c.account == nil {
+		xuserErrorf("authentication required")
+	}
+
+	fn(c, c.p)
+}
+
+// saslMechanisms returns the SASL mechanisms available on the connection.
+//
+// Like IMAP, we always announce support for SCRAM PLUS-variants, also on
+// connections without TLS. Clients should not select PLUS variants without TLS,
+// but do the bare SCRAM variant without indicating the server claims to support
+// the PLUS variant.
+func (c *conn) saslMechanisms() []string {
+	var l []string
+	if c.tls || c.noRequireSTARTTLS {
+		l = append(l, "PLAIN")
+	}
+	return append(l, "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5")
+}
+
+// xbwriteCapabilities writes the capabilities, without the final OK response.
+// ../rfc/5804
+func (c *conn) xbwriteCapabilities() {
+	capability := func(name, value string) {
+		c.xbwritelinef("%s %s", quoted(name), quoted(value))
+	}
+	capability("IMPLEMENTATION", "mox "+moxvar.Version)
+	capability("SASL", strings.Join(c.saslMechanisms(), " "))
+	capability("SIEVE", strings.Join(sieve.Extensions, " "))
+	capability("MAXREDIRECTS", fmt.Sprintf("%d", sieve.MaxRedirects))
+	if !c.tls && c.baseTLSConfig != nil {
+		c.xbwritelinef(`"STARTTLS"`)
+	}
+	if c.account != nil {
+		capability("OWNER", c.username)
+	}
+	capability("VERSION", "1.0")
+}
+
+// Capability returns the capabilities of the server, for the current state of
+// the connection.
+//
+// State: any
+func (c *conn) cmdCapability(p *parser) {
+	p.xempty()
+	c.xbwriteCapabilities()
+	c.ok("")
+}
+
+// Logout ends the connection.
+//
+// State: any
+func (c *conn) cmdLogout(p *parser) {
+	p.xempty()
+	c.ok("logout")
+	panic(cleanClose)
+}
+
+// Noop does nothing, except returning the optional tag, for synchronizing with
+// the client.
+//
+// State: any
+func (c *conn) cmdNoop(p *parser) {
+	if p.empty() {
+		c.ok("done")
+		return
+	}
+	p.xspace()
+	tag := p.xstring()
+	p.xempty()
+	c.xwriteresult("OK", "TAG "+quoted(tag), "done")
+}
+
+// Starttls enables TLS on the connection. Afterwards, the capabilities are sent
+// again.
+//
+// State: not authenticated
+func (c *conn) cmdStarttls(p *parser) {
+	p.xempty()
+
+	if c.tls {
+		xuserErrorf("tls already active")
+	}
+	if c.baseTLSConfig == nil {
+		xuserErrorf("starttls not announced")
+	}
+
+	conn := xprefixConn(c.conn, c.br)
+	// We add the cid to facilitate debugging in case of TLS connection failure.
+	c.ok("begin tls negotiation (" + mox.ReceivedID(c.cid) + ")")
+
+	c.xtlsHandshake(conn)
+	c.tls = true
+
+	// Capabilities must be sent again after TLS is active. ../rfc/5804
+	c.xbwriteCapabilities()
+	c.ok("tls active")
+}
+
+// Authenticate using SASL. Supports multiple back and forths between client and
+// server to finish authentication.
+//
+// State: not authenticated
+func (c *conn) cmdAuthenticate(p *parser) {
+	// For many failed auth attempts, slow down verification attempts.
+	if c.authFailed > 3 && authFailDelay > 0 {
+		mox.Sleep(mox.Context, time.Duration(c.authFailed-3)*authFailDelay)
+	}
+
+	// If authentication fails due to missing derived secrets, we don't hold it against
+	// the connection. There is no way to indicate server support for an authentication
+	// mechanism, but that a mechanism won't work for an account.
+	var missingDerivedSecrets bool
+
+	c.authFailed++ // Compensated on success.
+	defer func() {
+		if missingDerivedSecrets {
+			c.authFailed--
+		}
+		// On the 3rd failed authentication, start responding slowly. Successful auth will
+		// cause fast responses again.
+		if c.authFailed >= 3 {
+			c.setSlow(true)
+		}
+	}()
+
+	c.newLoginAttempt("")
+	defer func() {
+		store.LoginAttemptAdd(context.Background(), c.logbg(), *c.loginAttempt)
+		if c.loginAttempt.Result == store.AuthSuccess {
+			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+		} else if !missingDerivedSecrets {
+			mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+		}
+		c.loginAttempt = nil
+	}()
+
+	p.xspace()
+	authType := p.xstring()
+
+	xdecode := func(s string) []byte {
+		if s == "*" {
+			c.loginAttempt.Result = store.AuthAborted
+			xuserErrorf("authenticate aborted by client")
+		}
+		buf, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			xsyntaxErrorf("parsing base64: %v", err)
+		}
+		return buf
+	}
+
+	// xreadContinuation reads a client response, a string on its own line.
+	xreadContinuation := func() []byte {
+		rp := newParser(c, c.xreadline())
+		c.p = rp
+		s := rp.xstring()
+		rp.xempty()
+		return xdecode(s)
+	}
+
+	xreadInitial := func() []byte {
+		if p.empty() {
+			// Empty server challenge, client responds with the initial response.
+			c.xwritelinef(`""`)
+			return xreadContinuation()
+		}
+		p.xspace()
+		s := p.xstring()
+		p.xempty()
+		return xdecode(s)
+	}
+
+	var account *store.Account
+	var username string
+	defer func() {
+		if account != nil {
+			err := account.Close()
+			c.xsanity(err, "close account")
+		}
+	}()
+
+	// Final server data to include in the OK response, for SCRAM.
+	var serverFinal string
+
+	switch strings.ToUpper(authType) {
+	case "PLAIN":
+		c.loginAttempt.AuthMech = "plain"
+
+		if !c.noRequireSTARTTLS && !c.tls {
+			xusercodeErrorf("ENCRYPT-NEEDED", "tls required for login")
+		}
+
+		// Plain text passwords, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		plain := bytes.Split(buf, []byte{0})
+		if len(plain) != 3 {
+			xsyntaxErrorf("bad plain auth data, expected 3 nul-separated tokens, got %d tokens", len(plain))
+		}
+		authz := norm.NFC.String(string(plain[0]))
+		username = norm.NFC.String(string(plain[1]))
+		password := string(plain[2])
+		c.loginAttempt.LoginAddress = username
+
+		if authz != "" && authz != username {
+			xuserErrorf("cannot assume role")
+		}
+
+		var err error
+		account, c.loginAttempt.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("authentication failed", slog.String("username", username))
+				xuserErrorf("bad credentials")
+			}
+			xuserErrorf("error")
+		}
+
+	case "CRAM-MD5":
+		c.loginAttempt.AuthMech = "cram-md5"
+
+		p.xempty()
+
+		// ../rfc/2195:82
+		chal := fmt.Sprintf("<%d.%d@%s>", uint64(mox.CryptoRandInt()), time.Now().UnixNano(), mox.Conf.Static.HostnameDomain.ASCII)
+		c.xwritelinef("%s", quoted(base64.StdEncoding.EncodeToString([]byte(chal))))
+
+		resp := xreadContinuation()
+		t := strings.Split(string(resp), " ")
+		if len(t) != 2 || len(t[1]) != 2*md5.Size {
+			xsyntaxErrorf("malformed cram-md5 response")
+		}
+		username = norm.NFC.String(t[0])
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("cram-md5 auth", slog.String("address", username))
+		var err error
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad credentials")
+			}
+			xserverErrorf("looking up address: %v", err)
+		}
+		var ipadhash, opadhash hash.Hash
+		account.WithRLock(func() {
+			err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+				password, err := bstore.QueryTx[store.Password](tx).Get()
+				if err == bstore.ErrAbsent {
+					c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+					xuserErrorf("bad credentials")
+				}
+				if err != nil {
+					return err
+				}
+
+				ipadhash = password.CRAMMD5.Ipad
+				opadhash = password.CRAMMD5.Opad
+				return nil
+			})
+			xcheckf(err, "tx read")
+		})
+		if ipadhash == nil || opadhash == nil {
+			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			missingDerivedSecrets = true
+			xuserErrorf("bad credentials")
+		}
+
+		// ../rfc/2195:138 ../rfc/2104:142
+		ipadhash.Write([]byte(chal))
+		opadhash.Write(ipadhash.Sum(nil))
+		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
+		if digest != t[1] {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xuserErrorf("bad credentials")
+		}
+
+	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+		// No plaintext credentials, we can log these normally.
+
+		c.loginAttempt.AuthMech = strings.ToLower(authType)
+		var h func() hash.Hash
+		switch c.loginAttempt.AuthMech {
+		case "scram-sha-1", "scram-sha-1-plus":
+			h = sha1.New
+		case "scram-sha-256", "scram-sha-256-plus":
+			h = sha256.New
+		default:
+			xserverErrorf("missing case for scram variant")
+		}
+
+		var cs *tls.ConnectionState
+		requireChannelBinding := strings.HasSuffix(c.loginAttempt.AuthMech, "-plus")
+		if requireChannelBinding && !c.tls {
+			xuserErrorf("cannot use plus variant with tls channel binding without tls")
+		}
+		if c.tls {
+			xcs := c.conn.(*tls.Conn).ConnectionState()
+			cs = &xcs
+		}
+		c0 := xreadInitial()
+		ss, err := scram.NewServer(h, c0, cs, requireChannelBinding)
+		if err != nil {
+			c.log.Infox("scram protocol error", err, slog.Any("remote", c.remoteIP))
+			xuserErrorf("scram protocol error: %s", err)
+		}
+		username = ss.Authentication
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("scram auth", slog.String("authentication", username))
+		// We check for login being disabled when finishing.
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			xuserErrorf("scram not possible")
+		}
+		if ss.Authorization != "" && ss.Authorization != username {
+			xuserErrorf("authentication with authorization for different user not supported")
+		}
+		var xscram store.SCRAM
+		account.WithRLock(func() {
+			err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+				password, err := bstore.QueryTx[store.Password](tx).Get()
+				if err == bstore.ErrAbsent {
+					c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+					xuserErrorf("bad credentials")
+				}
+				xcheckf(err, "fetching credentials")
+				switch c.loginAttempt.AuthMech {
+				case "scram-sha-1", "scram-sha-1-plus":
+					xscram = password.SCRAMSHA1
+				case "scram-sha-256", "scram-sha-256-plus":
+					xscram = password.SCRAMSHA256
+				default:
+					xserverErrorf("missing case for scram credentials")
+				}
+				if len(xscram.Salt) == 0 || xscram.Iterations == 0 || len(xscram.SaltedPassword) == 0 {
+					missingDerivedSecrets = true
+					c.log.Info("scram auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+					xuserErrorf("scram not possible")
+				}
+				return nil
+			})
+			xcheckf(err, "read tx")
+		})
+		s1, err := ss.ServerFirst(xscram.Iterations, xscram.Salt)
+		xcheckf(err, "scram first server step")
+		c.xwritelinef("%s", quoted(base64.StdEncoding.EncodeToString([]byte(s1))))
+		c2 := xreadContinuation()
+		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		if err != nil {
+			if errors.Is(err, scram.ErrInvalidProof) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad credentials")
+			} else if errors.Is(err, scram.ErrChannelBindingsDontMatch) {
+				c.loginAttempt.Result = store.AuthBadChannelBinding
+				c.log.Warn("bad channel binding during authentication, potential mitm", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("channel bindings do not match, potential mitm")
+			} else if errors.Is(err, scram.ErrInvalidEncoding) {
+				c.loginAttempt.Result = store.AuthBadProtocol
+				c.log.Infox("bad scram protocol message", err, slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad scram protocol message: %s", err)
+			}
+			xuserErrorf("server final: %w", err)
+		}
+		// The final server message is sent in the SASL response code of the OK response,
+		// so the client does not have to send an empty response. ../rfc/5804
+		serverFinal = base64.StdEncoding.EncodeToString([]byte(s3))
+
+	default:
+		c.loginAttempt.AuthMech = "(unrecognized)"
+		xuserErrorf("method not supported")
+	}
+
+	if accConf, ok := account.Conf(); !ok {
+		xserverErrorf("cannot get account config")
+	} else if accConf.LoginDisabled != "" {
+		c.loginAttempt.Result = store.AuthLoginDisabled
+		c.log.Info("account login disabled", slog.String("username", username))
+		xuserErrorf("%w: %s", store.ErrLoginDisabled, accConf.LoginDisabled)
+	}
+
+	c.account = account
+	account = nil // Prevent cleanup.
+	c.username = username
+
+	c.setSlow(false)
+	c.loginAttempt.AccountName = c.account.Name
+	c.loginAttempt.LoginAddress = c.username
+	c.loginAttempt.Result = store.AuthSuccess
+	c.authFailed = 0
+	var code string
+	if serverFinal != "" {
+		code = "SASL " + quoted(serverFinal)
+	}
+	c.xwriteresult("OK", code, "authenticated")
+}
+
+// xchecksieve turns errors from operations on Sieve scripts into responses with
+// the response codes clients expect.
+func xchecksieve(err error, format string, args ...any) {
+	if err == nil {
+		return
+	} else if err == bstore.ErrAbsent {
+		xusercodeErrorf("NONEXISTENT", "script does not exist")
+	} else if errors.Is(err, store.ErrSieveActive) {
+		xusercodeErrorf("ACTIVE", "%s", err)
+	} else if errors.Is(err, store.ErrSieveExists) {
+		xusercodeErrorf("ALREADYEXISTS", "%s", err)
+	} else if errors.Is(err, store.ErrSieveQuota) {
+		// Script sizes are checked before, so this is about the number of scripts.
+		xusercodeErrorf("QUOTA/MAXSCRIPTS", "%s", err)
+	} else if errors.Is(err, store.ErrSieveInvalid) {
+		xuserErrorf("%s", err)
+	}
+	xcheckf(err, format, args...)
+}
+
+func xchecksize(size int64) {
+	if size > store.SieveScriptMaxSize {
+		xusercodeErrorf("QUOTA/MAXSIZE", "script larger than maximum size %d", store.SieveScriptMaxSize)
+	}
+}
+
+// Havespace checks whether a script with the name and size can be stored.
+//
+// State: authenticated
+func (c *conn) cmdHavespace(p *parser) {
+	p.xspace()
+	name := p.xstring()
+	p.xspace()
+	size := p.xnumber()
+	p.xempty()
+
+	if err := sieve.CheckScriptName(name); err != nil {
+		xuserErrorf("%s", err)
+	}
+	xchecksize(size)
+
+	_, err := c.account.SieveScriptGet(context.TODO(), name)
+	if err == bstore.ErrAbsent {
+		l, err := c.account.SieveScripts(context.TODO())
+		xcheckf(err, "listing scripts")
+		if len(l) >= store.SieveScriptsMax {
+			xusercodeErrorf("QUOTA/MAXSCRIPTS", "maximum number of scripts %d reached", store.SieveScriptsMax)
+		}
+	} else {
+		xcheckf(err, "looking up script")
+	}
+	c.ok("")
+}
+
+// Putscript stores a script, replacing an existing script with the same name.
+// The script is checked before storing.
+//
+// State: authenticated
+func (c *conn) cmdPutscript(p *parser) {
+	p.xspace()
+	name := p.xstring()
+	p.xspace()
+	content := p.xstring()
+	p.xempty()
+
+	xchecksize(int64(len(content)))
+	_, err := c.account.SieveScriptSave(context.TODO(), name, content)
+	xchecksieve(err, "saving script")
+	c.ok("")
+}
+
+// Listscripts lists the names of all scripts, marking the active script.
+//
+// State: authenticated
+func (c *conn) cmdListscripts(p *parser) {
+	p.xempty()
+
+	l, err := c.account.SieveScripts(context.TODO())
+	xcheckf(err, "listing scripts")
+	for _, ss := range l {
+		if ss.Active {
+			c.xbwritelinef("%s ACTIVE", quoted(ss.Name))
+		} else {
+			c.xbwritelinef("%s", quoted(ss.Name))
+		}
+	}
+	c.ok("")
+}
+
+// Setactive makes a script the active script, used during delivery. An empty name
+// deactivates the active script.
+//
+// State: authenticated
+func (c *conn) cmdSetactive(p *parser) {
+	p.xspace()
+	name := p.xstring()
+	p.xempty()
+
+	err := c.account.SieveScriptActivate(context.TODO(), name)
+	xchecksieve(err, "activating script")
+	c.ok("")
+}
+
+// Getscript returns the content of a script.
+//
+// State: authenticated
+func (c *conn) cmdGetscript(p *parser) {
+	p.xspace()
+	name := p.xstring()
+	p.xempty()
+
+	ss, err := c.account.SieveScriptGet(context.TODO(), name)
+	xchecksieve(err, "getting script")
+	c.xbwritelinef("%s", literal(ss.Content))
+	c.ok("")
+}
+
+// Deletescript removes a script. The active script cannot be removed.
+//
+// State: authenticated
+func (c *conn) cmdDeletescript(p *parser) {
+	p.xspace()
+	name := p.xstring()
+	p.xempty()
+
+	err := c.account.SieveScriptDelete(context.TODO(), name)
+	xchecksieve(err, "removing script")
+	c.ok("")
+}
+
+// Renamescript changes the name of a script.
+//
+// State: authenticated
+func (c *conn) cmdRenamescript(p *parser) {
+	p.xspace()
+	oldName := p.xstring()
+	p.xspace()
+	newName := p.xstring()
+	p.xempty()
+
+	err := c.account.SieveScriptRename(context.TODO(), oldName, newName)
+	xchecksieve(err, "renaming script")
+	c.ok("")
+}
+
+// Checkscript checks a script for errors, without storing it.
+//
+// State: authenticated
+func (c *conn) cmdCheckscript(p *parser) {
+	p.xspace()
+	content := p.xstring()
+	p.xempty()
+
+	xchecksize(int64(len(content)))
+	if _, err := sieve.Parse(content); err != nil {
+		xuserErrorf("%s", err)
+	}
+	c.ok("")
+}
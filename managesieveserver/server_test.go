@@ -0,0 +1,327 @@
+package managesieveserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sasl"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("managesieveserver", nil)
+
+const password = "testtest"
+
+func init() {
+	sanityChecks = true
+
+	// Don't slow down tests.
+	badClientDelay = 0
+	authFailDelay = 0
+
+	mox.Context = ctxbg
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+type testconn struct {
+	t          *testing.T
+	conn       net.Conn
+	br         *bufio.Reader
+	serverConn net.Conn
+	done       chan struct{}
+}
+
+func (tc *testconn) close() {
+	tc.conn.Close()
+	tc.serverConn.Close()
+	<-tc.done
+	if n := unhandledPanics.Swap(0); n > 0 {
+		tc.t.Fatalf("handled %d panics", n)
+	}
+}
+
+// readline reads a line, including the data of a literal at the end of the line
+// and the remainder of the line after the literal.
+func (tc *testconn) readline() string {
+	tc.t.Helper()
+	line, err := tc.br.ReadString('\n')
+	tcheck(tc.t, err, "read line")
+	line = strings.TrimSuffix(line, "\r\n")
+	if m := literalRegexp.FindStringSubmatch(line); m != nil {
+		size, err := strconv.ParseInt(m[1], 10, 64)
+		tcheck(tc.t, err, "parse literal size")
+		buf := make([]byte, size)
+		_, err = io.ReadFull(tc.br, buf)
+		tcheck(tc.t, err, "read literal")
+		line = line[:len(line)-len(m[0])] + string(buf) + tc.readline()
+	}
+	return line
+}
+
+func (tc *testconn) writelinef(format string, args ...any) {
+	tc.t.Helper()
+	_, err := fmt.Fprintf(tc.conn, format+"\r\n", args...)
+	tcheck(tc.t, err, "write")
+}
+
+// response reads lines until a result line, and checks the result starts with
+// expResult. The lines before the result line are returned.
+func (tc *testconn) response(expResult string) []string {
+	tc.t.Helper()
+	var lines []string
+	for {
+		line := tc.readline()
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "NO") || strings.HasPrefix(line, "BYE") {
+			if !strings.HasPrefix(line, expResult) {
+				tc.t.Fatalf("got result %q, expected %q", line, expResult)
+			}
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (tc *testconn) transactf(expResult, format string, args ...any) []string {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	return tc.response(expResult)
+}
+
+func (tc *testconn) tcompare(got, exp []string) {
+	tc.t.Helper()
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		tc.t.Fatalf("got %q, expected %q", got, exp)
+	}
+}
+
+// authenticate runs a SASL exchange, with an initial response if the mechanism
+// has one.
+func (tc *testconn) authenticate(client sasl.Client, expResult string) {
+	tc.t.Helper()
+	name, _ := client.Info()
+	toServer, _, err := client.Next(nil)
+	tcheck(tc.t, err, "sasl initial response")
+	if toServer != nil {
+		tc.writelinef(`AUTHENTICATE "%s" "%s"`, name, base64.StdEncoding.EncodeToString(toServer))
+	} else {
+		tc.writelinef(`AUTHENTICATE "%s"`, name)
+	}
+	for {
+		line := tc.readline()
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "NO") {
+			if !strings.HasPrefix(line, expResult) {
+				tc.t.Fatalf("got result %q, expected %q", line, expResult)
+			}
+			if rest, ok := strings.CutPrefix(line, `OK (SASL "`); ok {
+				buf, err := base64.StdEncoding.DecodeString(rest[:strings.IndexByte(rest, '"')])
+				tcheck(tc.t, err, "decode sasl server final")
+				_, _, err = client.Next(buf)
+				tcheck(tc.t, err, "sasl server final")
+			}
+			return
+		}
+		fromServer, err := base64.StdEncoding.DecodeString(strings.Trim(line, `"`))
+		tcheck(tc.t, err, "decode sasl challenge")
+		toServer, _, err := client.Next(fromServer)
+		if err != nil {
+			tc.writelinef(`"*"`)
+			continue
+		}
+		tc.writelinef(`"%s"`, base64.StdEncoding.EncodeToString(toServer))
+	}
+}
+
+func (tc *testconn) login() {
+	tc.t.Helper()
+	tc.authenticate(sasl.NewClientPlain("mjl@mox.example", password), "OK")
+}
+
+var connCounter int64
+
+func start(t *testing.T, noRequireSTARTTLS bool) *testconn {
+	limitersInit() // Reset rate limiters.
+
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/managesieve/mox.conf")
+	mox.MustLoadConfig(true, false)
+	store.Close() // May not be open, we ignore error.
+	os.RemoveAll("../testdata/managesieve/data")
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	switchStop := store.Switchboard()
+	t.Cleanup(func() {
+		switchStop()
+		err := store.Close()
+		tcheck(t, err, "store close")
+	})
+
+	acc, err := store.OpenAccount(pkglog, "mjl", false)
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(pkglog, password)
+	tcheck(t, err, "set password")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.WaitClosed()
+
+	serverConn, clientConn := net.Pipe()
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{fakeCert(t)},
+	}
+
+	done := make(chan struct{})
+	connCounter++
+	go func() {
+		serve("test", connCounter, tlsConfig, serverConn, noRequireSTARTTLS)
+		close(done)
+	}()
+	tc := &testconn{t: t, conn: clientConn, br: bufio.NewReader(clientConn), serverConn: serverConn, done: done}
+	tc.response("OK")
+	return tc
+}
+
+func fakeCert(t *testing.T) tls.Certificate {
+	seed := make([]byte, ed25519.SeedSize)
+	privKey := ed25519.NewKeyFromSeed(seed) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	localCertBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "making certificate")
+	cert, err := x509.ParseCertificate(localCertBuf)
+	tcheck(t, err, "parsing generated certificate")
+	return tls.Certificate{
+		Certificate: [][]byte{localCertBuf},
+		PrivateKey:  privKey,
+		Leaf:        cert,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	caps := tc.transactf("OK", "CAPABILITY")
+	tc.tcompare(caps[1:3], []string{`"SASL" "SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5"`, `"SIEVE" "body comparator-i;ascii-casemap comparator-i;octet copy envelope ereject fileinto imap4flags include reject vacation variables"`})
+
+	// Commands that require authentication.
+	tc.transactf("NO", "LISTSCRIPTS")
+	tc.transactf("NO", `GETSCRIPT "x"`)
+
+	// Plain text passwords require TLS.
+	tc.authenticate(sasl.NewClientPlain("mjl@mox.example", password), "NO (ENCRYPT-NEEDED)")
+	tc.authenticate(sasl.NewClientSCRAMSHA256("mjl@mox.example", "badpass", false), "NO")
+	tc.authenticate(sasl.NewClientSCRAMSHA1("mjl@mox.example", password, false), "OK (SASL ")
+
+	// Not allowed after authentication.
+	tc.transactf("NO", `AUTHENTICATE "PLAIN" ""`)
+	caps = tc.transactf("OK", "CAPABILITY")
+	tc.tcompare(caps[len(caps)-2:], []string{`"OWNER" "mjl@mox.example"`, `"VERSION" "1.0"`})
+
+	tc.transactf("OK", "LOGOUT")
+}
+
+func TestStarttls(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	tc.transactf("OK", "STARTTLS")
+	tlsConn := tls.Client(tc.conn, &tls.Config{InsecureSkipVerify: true})
+	tc.conn = tlsConn
+	tc.br = bufio.NewReader(tlsConn)
+	caps := tc.response("OK")
+	tc.tcompare(caps[1:2], []string{`"SASL" "PLAIN SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5"`})
+	tc.transactf("NO", "STARTTLS")
+
+	tc.authenticate(sasl.NewClientPlain("mjl@mox.example", "badpass"), "NO")
+	tc.authenticate(sasl.NewClientPlain("disabled@mox.example", password), "NO")
+	tc.authenticate(sasl.NewClientSCRAMSHA256PLUS("mjl@mox.example", password, tlsConn.ConnectionState()), "OK (SASL ")
+}
+
+func TestCRAMMD5(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	tc.authenticate(sasl.NewClientCRAMMD5("mjl@mox.example", "badpass"), "NO")
+	tc.authenticate(sasl.NewClientCRAMMD5("mjl@mox.example", password), "OK")
+}
+
+func TestScripts(t *testing.T) {
+	tc := start(t, true)
+	defer tc.close()
+
+	// Without initial response, the server sends an empty challenge.
+	tc.writelinef(`AUTHENTICATE "PLAIN"`)
+	if line := tc.readline(); line != `""` {
+		t.Fatalf("got %q, expected empty challenge", line)
+	}
+	tc.writelinef(`"*"`)
+	tc.response("NO")
+	tc.login()
+
+	tc.tcompare(tc.transactf("OK", "LISTSCRIPTS"), nil)
+
+	script := "require \"fileinto\";\r\nif header :contains \"subject\" \"list\" {\r\n\tfileinto \"Lists\";\r\n}\r\n"
+	tc.transactf("OK", "CHECKSCRIPT {%d+}\r\n%s", len(script), script)
+	tc.transactf("NO", `CHECKSCRIPT "fileinto \"Lists\";"`) // Missing require.
+	tc.transactf("OK", `HAVESPACE "main" 1000`)
+	tc.transactf("NO (QUOTA/MAXSIZE)", `HAVESPACE "main" 1000000`)
+	tc.transactf("NO", `HAVESPACE "a/b" 10`)
+
+	tc.transactf("OK", "PUTSCRIPT \"main\" {%d+}\r\n%s", len(script), script)
+	tc.transactf("OK", `PUTSCRIPT "other" "keep;"`)
+	tc.transactf("NO", `PUTSCRIPT "bad" "bogus;"`)
+	big := strings.Repeat("#", store.SieveScriptMaxSize+1)
+	tc.transactf("NO (QUOTA/MAXSIZE)", "PUTSCRIPT \"big\" {%d}\r\n%s", len(big), big) // Synchronizing literal also accepted.
+
+	tc.tcompare(tc.transactf("OK", "GETSCRIPT \"main\""), []string{script})
+	tc.transactf("NO (NONEXISTENT)", `GETSCRIPT "absent"`)
+
+	tc.transactf("OK", `SETACTIVE "main"`)
+	tc.transactf("NO (NONEXISTENT)", `SETACTIVE "absent"`)
+	tc.tcompare(tc.transactf("OK", "LISTSCRIPTS"), []string{`"main" ACTIVE`, `"other"`})
+
+	tc.transactf("NO (ACTIVE)", `DELETESCRIPT "main"`)
+	tc.transactf("NO (NONEXISTENT)", `DELETESCRIPT "absent"`)
+	tc.transactf("NO (ALREADYEXISTS)", `RENAMESCRIPT "other" "main"`)
+	tc.transactf("NO (NONEXISTENT)", `RENAMESCRIPT "absent" "x"`)
+	tc.transactf("OK", `RENAMESCRIPT "main" "renamed"`)
+	tc.tcompare(tc.transactf("OK", "LISTSCRIPTS"), []string{`"other"`, `"renamed" ACTIVE`})
+
+	tc.transactf("OK", `SETACTIVE ""`)
+	tc.transactf("OK", `DELETESCRIPT "renamed"`)
+	tc.tcompare(tc.transactf("OK", "LISTSCRIPTS"), []string{`"other"`})
+
+	tc.transactf(`OK (TAG "sync")`, `NOOP "sync"`)
+	tc.transactf("NO", "BOGUS")
+	tc.transactf("NO", `GETSCRIPT "unterminated`)
+
+	// Error before literal data was read aborts the connection.
+	tc.writelinef("BOGUS {4+}\r\nkeep")
+	tc.response("NO")
+	tc.response("BYE")
+}
@@ -24,6 +24,9 @@ const (
 	Import           Panic = "import"
 	Serve            Panic = "serve"
 	Imapserver       Panic = "imapserver"
+	JMAP             Panic = "jmapserver"
+	Managesieve      Panic = "managesieveserver"
+	POP3             Panic = "pop3server"
 	Dmarcdb          Panic = "dmarcdb"
 	Mtastsdb         Panic = "mtastsdb"
 	Queue            Panic = "queue"
@@ -53,6 +56,9 @@ func init() {
 		Import,
 		Serve,
 		Imapserver,
+		JMAP,
+		Managesieve,
+		POP3,
 		Mtastsdb,
 		Queue,
 		Smtpclient,
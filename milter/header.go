@@ -0,0 +1,110 @@
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a header field of a message.
+type Field struct {
+	Name  string
+	Value string // Without leading whitespace and trailing CRLF, lines separated by "\n".
+	Raw   []byte // Field as it appears in the message, including trailing CRLF.
+}
+
+// ReadHeader reads the header fields of a message from br, and the empty line
+// separating header and body. Afterwards, br is positioned at the start of the
+// body.
+func ReadHeader(br *bufio.Reader) ([]Field, error) {
+	var fields []Field
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			// Message without body.
+			return fields, nil
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if bytes.Equal(line, []byte("\r\n")) || bytes.Equal(line, []byte("\n")) {
+			return fields, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				return nil, errors.New("continuation line without header field")
+			}
+			f := &fields[len(fields)-1]
+			f.Raw = append(f.Raw, line...)
+			f.Value += "\n" + strings.TrimRight(string(line), "\r\n")
+			continue
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !validHeaderName(string(bytes.TrimRight(name, " \t"))) {
+			return nil, fmt.Errorf("malformed header field %q", line)
+		}
+		fields = append(fields, Field{
+			Name:  string(bytes.TrimRight(name, " \t")),
+			Value: strings.TrimRight(strings.TrimLeft(string(value), " \t"), "\r\n"),
+			Raw:   line,
+		})
+	}
+}
+
+// ApplyHeader returns the header section with the header modifications of mods
+// applied to fields, including the empty line separating header and body.
+// Modifications that are not about header fields are ignored.
+func ApplyHeader(fields []Field, mods []Modification) []byte {
+	fields = append([]Field{}, fields...)
+	for _, m := range mods {
+		switch m.Kind {
+		case ModAddHeader:
+			fields = append(fields, makeField(m.Name, m.Value))
+		case ModInsertHeader:
+			i := min(max(m.Index, 0), len(fields))
+			fields = append(fields[:i], append([]Field{makeField(m.Name, m.Value)}, fields[i:]...)...)
+		case ModChangeHeader:
+			var n int
+			i := len(fields)
+			for j, f := range fields {
+				if strings.EqualFold(f.Name, m.Name) {
+					n++
+					if n == m.Index {
+						i = j
+						break
+					}
+				}
+			}
+			if i == len(fields) {
+				if m.Value != "" {
+					fields = append(fields, makeField(m.Name, m.Value))
+				}
+			} else if m.Value == "" {
+				fields = append(fields[:i], fields[i+1:]...)
+			} else {
+				fields[i] = makeField(m.Name, m.Value)
+			}
+		}
+	}
+
+	var buf []byte
+	for _, f := range fields {
+		buf = append(buf, f.Raw...)
+	}
+	return append(buf, "\r\n"...)
+}
+
+// makeField returns a field with lines separated by CRLF. Continuation lines
+// without leading whitespace get a tab, so they cannot start a new header field.
+func makeField(name, value string) Field {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(value, "\r\n", "\n"), "\n"), "\n")
+	for i := 1; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], " ") && !strings.HasPrefix(lines[i], "\t") {
+			lines[i] = "\t" + lines[i]
+		}
+	}
+	raw := name + ": " + strings.Join(lines, "\r\n") + "\r\n"
+	return Field{name, strings.Join(lines, "\n"), []byte(raw)}
+}
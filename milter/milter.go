@@ -0,0 +1,642 @@
+// Package milter is a client for the Sendmail mail filter protocol (milter),
+// version 6, for passing SMTP transactions to external content filters such as
+// rspamd, ClamAV or OpenDKIM.
+//
+// A client connects to a milter for a single message transaction. The SMTP server
+// passes each stage of the transaction to the milter: connection details, hello,
+// MAIL FROM, each RCPT TO, and finally the message header and body. At each
+// stage, the milter can accept, reject or temporarily fail the message. At the
+// end of the message, the milter can also request modifications, such as adding
+// and changing header fields, adding recipients, or quarantining the message.
+//
+// The protocol is not formally specified. This implementation follows the
+// libmilter implementation and the behaviour of Sendmail and Postfix.
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Commands sent by the MTA.
+const (
+	cmdBody    = 'B' // Chunk of the message body.
+	cmdConnect = 'C' // Connection details.
+	cmdMacro   = 'D' // Macros for the next command.
+	cmdEOB     = 'E' // End of message.
+	cmdHelo    = 'H' // HELO/EHLO name.
+	cmdHeader  = 'L' // Single header field.
+	cmdMail    = 'M' // MAIL FROM.
+	cmdEOH     = 'N' // End of headers.
+	cmdOptneg  = 'O' // Option negotiation.
+	cmdQuit    = 'Q' // Close the connection.
+	cmdRcpt    = 'R' // RCPT TO.
+	cmdData    = 'T' // DATA.
+)
+
+// Responses sent by the milter.
+const (
+	respAddRcpt    = '+' // Add recipient.
+	respAddRcptPar = '2' // Add recipient with ESMTP parameters.
+	respAccept     = 'a' // Accept the message, no further filtering.
+	respContinue   = 'c' // Continue with the next stage.
+	respDiscard    = 'd' // Accept the message, but silently discard it.
+	respAddHeader  = 'h' // Add header field at the end of the header.
+	respInsHeader  = 'i' // Insert header field at an index.
+	respChgHeader  = 'm' // Change or delete header field.
+	respProgress   = 'p' // Still busy, keep waiting.
+	respQuarantine = 'q' // Quarantine the message.
+	respReject     = 'r' // Reject with a permanent error.
+	respSkip       = 's' // Skip further body chunks.
+	respTempfail   = 't' // Reject with a temporary error.
+	respReplyCode  = 'y' // Reject with the SMTP reply code and text.
+)
+
+// Actions a milter can request during option negotiation, that modify the message.
+const (
+	actAddHeaders    = 0x01
+	actChangeBody    = 0x02
+	actAddRcpt       = 0x04
+	actDelRcpt       = 0x08
+	actChangeHeaders = 0x10
+	actQuarantine    = 0x20
+	actChangeFrom    = 0x40
+	actAddRcptPar    = 0x80
+)
+
+// Protocol flags, negotiated for leaving out stages of the transaction (no*), and
+// for not waiting for a reply (nr*).
+const (
+	protoNoConnect = 0x01
+	protoNoHelo    = 0x02
+	protoNoMail    = 0x04
+	protoNoRcpt    = 0x08
+	protoNoBody    = 0x10
+	protoNoHeaders = 0x20
+	protoNoEOH     = 0x40
+	protoNRHeader  = 0x80
+	protoNoUnknown = 0x100
+	protoNoData    = 0x200
+	protoSkip      = 0x400
+	protoNRConnect = 0x1000
+	protoNRHelo    = 0x2000
+	protoNRMail    = 0x4000
+	protoNRRcpt    = 0x8000
+	protoNRData    = 0x10000
+	protoNRUnknown = 0x20000
+	protoNREOH     = 0x40000
+	protoNRBody    = 0x80000
+)
+
+const version = 6
+
+// We don't offer changing the body or envelope sender, or removing recipients.
+const ourActions = actAddHeaders | actChangeHeaders | actAddRcpt | actAddRcptPar | actQuarantine
+
+const ourProtocol = protoNoConnect | protoNoHelo | protoNoMail | protoNoRcpt | protoNoBody | protoNoHeaders | protoNoEOH | protoNRHeader | protoNoUnknown | protoNoData | protoSkip | protoNRConnect | protoNRHelo | protoNRMail | protoNRRcpt | protoNRData | protoNRUnknown | protoNREOH | protoNRBody
+
+// Maximum size of a packet from a milter. We don't allow body replacement, so
+// packets are small.
+const maxPacketSize = 1024 * 1024
+
+// Body chunks sent to the milter are at most this size.
+const bodyChunkSize = 65535
+
+var (
+	ErrProtocol = errors.New("milter protocol error")        // After a malformed or unexpected packet from the milter.
+	ErrBotched  = errors.New("milter connection is botched") // Set on a client, and returned for new operations, after an i/o or protocol error.
+)
+
+// Action is the decision of a milter about a message transaction.
+type Action byte
+
+const (
+	Continue Action = iota // Continue with the next stage of the transaction.
+	Accept                 // Accept the message, the milter doesn't need to see the remainder of the transaction.
+	Reject                 // Reject with a permanent error. For RCPT, only for that recipient.
+	Tempfail               // Reject with a temporary error. For RCPT, only for that recipient.
+	Discard                // Accept the message, but silently discard it.
+)
+
+func (a Action) String() string {
+	switch a {
+	case Continue:
+		return "continue"
+	case Accept:
+		return "accept"
+	case Reject:
+		return "reject"
+	case Tempfail:
+		return "tempfail"
+	case Discard:
+		return "discard"
+	}
+	return fmt.Sprintf("(unknown action %d)", a)
+}
+
+// Response is the response of a milter for a stage of the transaction.
+type Response struct {
+	Action Action
+
+	// For Reject and Tempfail, the SMTP response to use. Always set, with defaults if
+	// the milter didn't specify a reply code.
+	Code   int
+	Secode string // Enhanced status code without class, e.g. "7.1".
+	Text   string
+}
+
+// ModKind is the type of a modification requested by a milter at the end of a
+// message.
+type ModKind byte
+
+const (
+	ModAddHeader    ModKind = iota // Add header field at the end of the header section.
+	ModInsertHeader                // Insert header field at index, with 0 being the top.
+	ModChangeHeader                // Change occurrence Index (starting at 1) of header field Name. An empty value removes the header field.
+	ModAddRcpt                     // Add recipient Rcpt, with optional ESMTP parameters Args.
+	ModQuarantine                  // Quarantine message with Reason.
+)
+
+// Modification is a change to the message, requested by a milter at the end of a
+// message.
+type Modification struct {
+	Kind   ModKind
+	Index  int    // For ModInsertHeader and ModChangeHeader.
+	Name   string // Header field name.
+	Value  string // Header field value, lines separated by "\n".
+	Rcpt   string // For ModAddRcpt, without angle brackets.
+	Args   string // For ModAddRcpt, optional ESMTP parameters.
+	Reason string // For ModQuarantine.
+}
+
+// Client is a connection to a milter, for a single message transaction.
+type Client struct {
+	log            mlog.Log
+	conn           net.Conn
+	br             *bufio.Reader
+	commandTimeout time.Duration
+	botched        bool
+
+	actions  uint32 // Negotiated actions the milter may request.
+	protocol uint32 // Negotiated protocol flags.
+}
+
+// Dial connects to the milter at address and negotiates options. Address is
+// either "unix:" followed by a path to a unix domain socket, or "inet:" followed
+// by host and port separated by a colon.
+//
+// Each command, including its response, must complete within commandTimeout.
+func Dial(ctx context.Context, elog *slog.Logger, address string, connectTimeout, commandTimeout time.Duration) (*Client, error) {
+	var network, addr string
+	if s, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, addr = "unix", s
+	} else if s, ok := strings.CutPrefix(address, "inet:"); ok {
+		network, addr = "tcp", s
+	} else if s, ok := strings.CutPrefix(address, "inet6:"); ok {
+		network, addr = "tcp6", s
+	} else {
+		return nil, fmt.Errorf("unrecognized milter address %q, must start with unix:, inet: or inet6:", address)
+	}
+
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial milter: %w", err)
+	}
+	c, err := New(elog, conn, commandTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// New negotiates options with a milter on conn and returns a client. On error,
+// the caller must close conn.
+func New(elog *slog.Logger, conn net.Conn, commandTimeout time.Duration) (*Client, error) {
+	c := &Client{
+		log:            mlog.New("milter", elog),
+		conn:           conn,
+		br:             bufio.NewReader(conn),
+		commandTimeout: commandTimeout,
+	}
+
+	buf := binary.BigEndian.AppendUint32(nil, version)
+	buf = binary.BigEndian.AppendUint32(buf, ourActions)
+	buf = binary.BigEndian.AppendUint32(buf, ourProtocol)
+	if err := c.write(cmdOptneg, buf); err != nil {
+		return nil, err
+	}
+	cmd, data, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if cmd != cmdOptneg || len(data) < 12 {
+		return nil, fmt.Errorf("%w: unexpected response %q with %d bytes to option negotiation", ErrProtocol, cmd, len(data))
+	}
+	v := binary.BigEndian.Uint32(data[0:4])
+	actions := binary.BigEndian.Uint32(data[4:8])
+	protocol := binary.BigEndian.Uint32(data[8:12])
+	if v < 2 {
+		return nil, fmt.Errorf("%w: unsupported milter protocol version %d", ErrProtocol, v)
+	}
+	if protocol&^ourProtocol != 0 {
+		return nil, fmt.Errorf("%w: milter requested unsupported protocol flags 0x%x", ErrProtocol, protocol&^ourProtocol)
+	}
+	if actions&^ourActions != 0 {
+		c.log.Info("milter requested unsupported actions, ignoring", slog.String("actions", fmt.Sprintf("0x%x", actions&^ourActions)))
+	}
+	c.actions = actions & ourActions
+	c.protocol = protocol
+	c.log.Debug("milter options negotiated", slog.Any("version", v), slog.String("actions", fmt.Sprintf("0x%x", c.actions)), slog.String("protocol", fmt.Sprintf("0x%x", c.protocol)))
+	return c, nil
+}
+
+// Close sends the quit command and closes the connection.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	if !c.botched {
+		err := c.write(cmdQuit, nil)
+		c.log.Check(err, "writing milter quit command")
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) write(cmd byte, data []byte) error {
+	if c.botched {
+		return ErrBotched
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.commandTimeout)); err != nil {
+		c.botched = true
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	buf := make([]byte, 0, 5+len(data))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(1+len(data)))
+	buf = append(buf, cmd)
+	buf = append(buf, data...)
+	if _, err := c.conn.Write(buf); err != nil {
+		c.botched = true
+		return fmt.Errorf("write milter command %q: %w", cmd, err)
+	}
+	return nil
+}
+
+// read returns the next packet. Progress packets are skipped, extending the deadline.
+func (c *Client) read() (byte, []byte, error) {
+	if c.botched {
+		return 0, nil, ErrBotched
+	}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			c.botched = true
+			return 0, nil, fmt.Errorf("read milter response: %w", err)
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n == 0 || n > maxPacketSize {
+			c.botched = true
+			return 0, nil, fmt.Errorf("%w: bad packet size %d", ErrProtocol, n)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(c.br, buf); err != nil {
+			c.botched = true
+			return 0, nil, fmt.Errorf("read milter response: %w", err)
+		}
+		if buf[0] == respProgress {
+			if err := c.conn.SetDeadline(time.Now().Add(c.commandTimeout)); err != nil {
+				c.botched = true
+				return 0, nil, fmt.Errorf("set deadline: %w", err)
+			}
+			continue
+		}
+		return buf[0], buf[1:], nil
+	}
+}
+
+// readResponse reads the response for a command. Modifications are only allowed
+// at the end of the message, and are passed to mod.
+func (c *Client) readResponse(mod func(cmd byte, data []byte) error) (Response, bool, error) {
+	for {
+		cmd, data, err := c.read()
+		if err != nil {
+			return Response{}, false, err
+		}
+		switch cmd {
+		case respContinue:
+			return Response{Action: Continue}, false, nil
+		case respSkip:
+			return Response{Action: Continue}, true, nil
+		case respAccept:
+			return Response{Action: Accept}, false, nil
+		case respDiscard:
+			return Response{Action: Discard}, false, nil
+		case respReject:
+			return Response{Reject, smtp.C550MailboxUnavail, smtp.SePol7Other0, "rejected by content filter"}, false, nil
+		case respTempfail:
+			return Response{Tempfail, smtp.C451LocalErr, smtp.SePol7Other0, "temporarily rejected by content filter"}, false, nil
+		case respReplyCode:
+			r, err := parseReplyCode(string(bytes.TrimRight(data, "\x00")))
+			if err != nil {
+				c.botched = true
+			}
+			return r, false, err
+		case respAddHeader, respInsHeader, respChgHeader, respAddRcpt, respAddRcptPar, respQuarantine:
+			if mod == nil {
+				c.botched = true
+				return Response{}, false, fmt.Errorf("%w: modification %q before end of message", ErrProtocol, cmd)
+			}
+			if err := mod(cmd, data); err != nil {
+				c.botched = true
+				return Response{}, false, err
+			}
+		default:
+			c.botched = true
+			return Response{}, false, fmt.Errorf("%w: unexpected response %q", ErrProtocol, cmd)
+		}
+	}
+}
+
+// parseReplyCode parses an SMTP response set by a milter, e.g. "550 5.7.1 no
+// thanks". Multiline responses have lines separated by CRLF.
+func parseReplyCode(s string) (Response, error) {
+	var r Response
+	var texts []string
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if len(line) < 3 {
+			return r, fmt.Errorf("%w: reply code too short: %q", ErrProtocol, line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil || code/100 != 4 && code/100 != 5 {
+			return r, fmt.Errorf("%w: bad reply code in %q", ErrProtocol, line)
+		}
+		r.Code = code
+		line = strings.TrimLeft(line[3:], " -")
+		t := strings.SplitN(line, " ", 2)
+		if ecode := strings.SplitN(t[0], ".", 2); len(ecode) == 2 && len(ecode[0]) == 1 && (ecode[0][0] == '4' || ecode[0][0] == '5') {
+			r.Secode = ecode[1]
+			line = ""
+			if len(t) == 2 {
+				line = t[1]
+			}
+		}
+		if line != "" {
+			texts = append(texts, line)
+		}
+	}
+	if r.Code/100 == 4 {
+		r.Action = Tempfail
+	} else {
+		r.Action = Reject
+	}
+	if r.Secode == "" {
+		r.Secode = smtp.SePol7Other0
+	}
+	r.Text = strings.Join(texts, "; ")
+	if r.Text == "" {
+		r.Text = "rejected by content filter"
+	}
+	return r, nil
+}
+
+// command sends macros (if any) and a command, and reads the response unless the
+// milter negotiated to not send one.
+func (c *Client) command(cmd byte, data []byte, macros map[string]string, noReply uint32) (Response, error) {
+	if len(macros) > 0 {
+		buf := []byte{cmd}
+		for _, k := range slices.Sorted(maps.Keys(macros)) {
+			buf = append(buf, k...)
+			buf = append(buf, 0)
+			buf = append(buf, macros[k]...)
+			buf = append(buf, 0)
+		}
+		if err := c.write(cmdMacro, buf); err != nil {
+			return Response{}, err
+		}
+	}
+	if err := c.write(cmd, data); err != nil {
+		return Response{}, err
+	}
+	if c.protocol&noReply != 0 {
+		return Response{Action: Continue}, nil
+	}
+	r, _, err := c.readResponse(nil)
+	return r, err
+}
+
+func cstrings(l ...string) []byte {
+	var buf []byte
+	for _, s := range l {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Connect passes the connection details of the SMTP client. Hostname is the
+// reverse name, or the IP address in brackets.
+func (c *Client) Connect(hostname string, ip net.IP, port int, macros map[string]string) (Response, error) {
+	if c.protocol&protoNoConnect != 0 {
+		return Response{Action: Continue}, nil
+	}
+	buf := cstrings(hostname)
+	if ip == nil {
+		buf = append(buf, 'U')
+	} else {
+		family := byte('4')
+		if ip.To4() == nil {
+			family = '6'
+		}
+		buf = append(buf, family)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(port))
+		buf = append(buf, cstrings(ip.String())...)
+	}
+	return c.command(cmdConnect, buf, macros, protoNRConnect)
+}
+
+// Helo passes the name from the HELO or EHLO command.
+func (c *Client) Helo(name string, macros map[string]string) (Response, error) {
+	if c.protocol&protoNoHelo != 0 {
+		return Response{Action: Continue}, nil
+	}
+	return c.command(cmdHelo, cstrings(name), macros, protoNRHelo)
+}
+
+// Mail passes the MAIL FROM address (without angle brackets, empty for the null
+// reverse path) and ESMTP parameters.
+func (c *Client) Mail(from string, args []string, macros map[string]string) (Response, error) {
+	if c.protocol&protoNoMail != 0 {
+		return Response{Action: Continue}, nil
+	}
+	return c.command(cmdMail, cstrings(append([]string{"<" + from + ">"}, args...)...), macros, protoNRMail)
+}
+
+// Rcpt passes a RCPT TO address (without angle brackets) and ESMTP parameters. A
+// Reject or Tempfail response only applies to this recipient.
+func (c *Client) Rcpt(to string, args []string, macros map[string]string) (Response, error) {
+	if c.protocol&protoNoRcpt != 0 {
+		return Response{Action: Continue}, nil
+	}
+	return c.command(cmdRcpt, cstrings(append([]string{"<" + to + ">"}, args...)...), macros, protoNRRcpt)
+}
+
+// Message passes the DATA command, the header fields and body of msg, and the end
+// of the message. The response is the final decision of the milter. For Continue
+// and Accept, the modifications requested by the milter are returned, in order.
+func (c *Client) Message(msg io.Reader, macros map[string]string) (Response, []Modification, error) {
+	if c.protocol&protoNoData == 0 {
+		r, err := c.command(cmdData, nil, macros, protoNRData)
+		if err != nil || r.Action != Continue {
+			return r, nil, err
+		}
+		macros = nil
+	}
+
+	br := bufio.NewReader(msg)
+	fields, err := ReadHeader(br)
+	if err != nil {
+		return Response{}, nil, fmt.Errorf("reading message header: %v", err)
+	}
+	if c.protocol&protoNoHeaders == 0 {
+		for _, f := range fields {
+			r, err := c.command(cmdHeader, cstrings(f.Name, f.Value), macros, protoNRHeader)
+			if err != nil || r.Action != Continue {
+				return r, nil, err
+			}
+			macros = nil
+		}
+	}
+	if c.protocol&protoNoEOH == 0 {
+		r, err := c.command(cmdEOH, nil, macros, protoNREOH)
+		if err != nil || r.Action != Continue {
+			return r, nil, err
+		}
+		macros = nil
+	}
+
+	if c.protocol&protoNoBody == 0 {
+		buf := make([]byte, bodyChunkSize)
+		for {
+			n, err := io.ReadFull(br, buf)
+			if n > 0 {
+				if err := c.write(cmdBody, buf[:n]); err != nil {
+					return Response{}, nil, err
+				}
+				if c.protocol&protoNRBody == 0 {
+					r, skip, err := c.readResponse(nil)
+					if err != nil || r.Action != Continue {
+						return r, nil, err
+					} else if skip {
+						break
+					}
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return Response{}, nil, fmt.Errorf("reading message body: %v", err)
+			}
+		}
+	}
+
+	if err := c.write(cmdEOB, nil); err != nil {
+		return Response{}, nil, err
+	}
+	var mods []Modification
+	r, _, err := c.readResponse(func(cmd byte, data []byte) error {
+		m, err := c.parseModification(cmd, data)
+		if err != nil {
+			return err
+		}
+		mods = append(mods, m)
+		return nil
+	})
+	if err != nil {
+		return Response{}, nil, err
+	}
+	return r, mods, nil
+}
+
+func (c *Client) parseModification(cmd byte, data []byte) (Modification, error) {
+	var need uint32
+	var kind ModKind
+	var index bool
+	var nstr int
+	switch cmd {
+	case respAddHeader:
+		need, kind, nstr = actAddHeaders, ModAddHeader, 2
+	case respInsHeader:
+		need, kind, index, nstr = actAddHeaders, ModInsertHeader, true, 2
+	case respChgHeader:
+		need, kind, index, nstr = actChangeHeaders, ModChangeHeader, true, 2
+	case respAddRcpt:
+		need, kind, nstr = actAddRcpt, ModAddRcpt, 1
+	case respAddRcptPar:
+		need, kind, nstr = actAddRcptPar, ModAddRcpt, 2
+	case respQuarantine:
+		need, kind, nstr = actQuarantine, ModQuarantine, 1
+	}
+	if c.actions&need == 0 {
+		return Modification{}, fmt.Errorf("%w: modification %q not negotiated", ErrProtocol, cmd)
+	}
+	m := Modification{Kind: kind}
+	if index {
+		if len(data) < 4 {
+			return Modification{}, fmt.Errorf("%w: missing index for modification %q", ErrProtocol, cmd)
+		}
+		m.Index = int(binary.BigEndian.Uint32(data[:4]))
+		data = data[4:]
+	}
+	l := strings.Split(string(data), "\x00")
+	if len(l) < nstr || len(l) > nstr+1 || len(l) == nstr+1 && l[nstr] != "" {
+		return Modification{}, fmt.Errorf("%w: malformed modification %q", ErrProtocol, cmd)
+	}
+	switch kind {
+	case ModAddHeader, ModInsertHeader, ModChangeHeader:
+		m.Name, m.Value = l[0], l[1]
+		if !validHeaderName(m.Name) {
+			return Modification{}, fmt.Errorf("%w: invalid header field name %q", ErrProtocol, m.Name)
+		}
+		if m.Kind == ModChangeHeader && m.Index < 1 {
+			m.Index = 1
+		}
+	case ModAddRcpt:
+		m.Rcpt = strings.TrimSuffix(strings.TrimPrefix(l[0], "<"), ">")
+		if nstr == 2 {
+			m.Args = l[1]
+		}
+	case ModQuarantine:
+		m.Reason = l[0]
+	}
+	return m, nil
+}
+
+func validHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || c == ':' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package milter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+}
+
+// fakeMilter serves the milter protocol on conn. Recipients containing "reject"
+// are rejected. At the end of the message, modifications are sent, and the
+// message is accepted. Commands received are sent on cmds.
+func fakeMilter(conn net.Conn, protocol uint32, cmds chan<- byte) {
+	defer conn.Close()
+	defer close(cmds)
+
+	br := bufio.NewReader(conn)
+	read := func() (byte, []byte) {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			panic(err)
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			panic(err)
+		}
+		return buf[0], buf[1:]
+	}
+	write := func(cmd byte, data ...string) {
+		s := string(cmd) + strings.Join(data, "")
+		buf := append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+		if _, err := conn.Write(buf); err != nil {
+			panic(err)
+		}
+	}
+	u32 := func(v uint32) string {
+		return string(binary.BigEndian.AppendUint32(nil, v))
+	}
+
+	cmd, _ := read()
+	if cmd != cmdOptneg {
+		panic("expected optneg")
+	}
+	write(cmdOptneg, u32(6), u32(ourActions), u32(protocol))
+
+	for {
+		cmd, data := read()
+		cmds <- cmd
+		switch cmd {
+		case cmdQuit:
+			return
+		case cmdMacro:
+			continue
+		case cmdRcpt:
+			if strings.Contains(string(data), "reject") {
+				write(respReplyCode, "550 5.1.1 no such user\x00")
+				continue
+			}
+		case cmdHeader:
+			if protocol&protoNRHeader != 0 {
+				continue
+			}
+		case cmdBody:
+			write(respProgress)
+		case cmdEOB:
+			write(respAddHeader, "X-Spam\x00yes\x00")
+			write(respInsHeader, u32(0), "X-Top\x00line1\nline2\x00")
+			write(respChgHeader, u32(1), "Subject\x00\x00")
+			write(respAddRcpt, "<other@example.org>\x00")
+			write(respQuarantine, "suspicious\x00")
+			write(respAccept)
+			continue
+		}
+		write(respContinue)
+	}
+}
+
+func TestClient(t *testing.T) {
+	log := mlog.New("milter", nil)
+
+	test := func(protocol uint32, expCmds string) {
+		t.Helper()
+
+		cconn, sconn := net.Pipe()
+		cmds := make(chan byte, 100)
+		go fakeMilter(sconn, protocol, cmds)
+
+		c, err := New(log.Logger, cconn, time.Minute)
+		tcheck(t, err, "new client")
+
+		r, err := c.Connect("[10.0.0.1]", net.ParseIP("10.0.0.1"), 1234, map[string]string{"j": "mox.example"})
+		tcheck(t, err, "connect")
+		tcompare(t, r, Response{Action: Continue})
+		r, err = c.Helo("remote.example", nil)
+		tcheck(t, err, "helo")
+		tcompare(t, r, Response{Action: Continue})
+		r, err = c.Mail("remote@remote.example", []string{"BODY=8BITMIME"}, nil)
+		tcheck(t, err, "mail")
+		tcompare(t, r, Response{Action: Continue})
+		r, err = c.Rcpt("mjl@mox.example", nil, nil)
+		tcheck(t, err, "rcpt")
+		tcompare(t, r, Response{Action: Continue})
+		r, err = c.Rcpt("reject@mox.example", nil, nil)
+		tcheck(t, err, "rcpt")
+		tcompare(t, r, Response{Reject, smtp.C550MailboxUnavail, "1.1", "no such user"})
+
+		msg := "Subject: test\r\nFrom: <remote@remote.example>\r\n\r\nbody\r\n"
+		r, mods, err := c.Message(strings.NewReader(msg), nil)
+		tcheck(t, err, "message")
+		tcompare(t, r, Response{Action: Accept})
+		tcompare(t, mods, []Modification{
+			{Kind: ModAddHeader, Name: "X-Spam", Value: "yes"},
+			{Kind: ModInsertHeader, Name: "X-Top", Value: "line1\nline2"},
+			{Kind: ModChangeHeader, Index: 1, Name: "Subject"},
+			{Kind: ModAddRcpt, Rcpt: "other@example.org"},
+			{Kind: ModQuarantine, Reason: "suspicious"},
+		})
+
+		err = c.Close()
+		tcheck(t, err, "close")
+
+		var l []byte
+		for cmd := range cmds {
+			l = append(l, cmd)
+		}
+		tcompare(t, string(l), expCmds)
+	}
+
+	test(0, "DCHMRRTLLNBEQ")
+	test(protoNoHelo|protoNRHeader|protoNoData, "DCMRRLLNBEQ")
+}
+
+func TestParseReplyCode(t *testing.T) {
+	test := func(s string, exp Response, expErr error) {
+		t.Helper()
+		r, err := parseReplyCode(s)
+		if !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if err == nil {
+			tcompare(t, r, exp)
+		}
+	}
+
+	test("550 5.7.1 spam", Response{Reject, 550, "7.1", "spam"}, nil)
+	test("451 try later", Response{Tempfail, 451, smtp.SePol7Other0, "try later"}, nil)
+	test("554", Response{Reject, 554, smtp.SePol7Other0, "rejected by content filter"}, nil)
+	test("550-5.7.1 line1\r\n550 5.7.1 line2", Response{Reject, 550, "7.1", "line1; line2"}, nil)
+	test("250 ok", Response{}, ErrProtocol)
+	test("xx", Response{}, ErrProtocol)
+}
+
+func TestApplyHeader(t *testing.T) {
+	msg := "Subject: one\r\nReceived: a\r\n b\r\nreceived: c\r\n\r\nbody\r\n"
+	br := bufio.NewReader(strings.NewReader(msg))
+	fields, err := ReadHeader(br)
+	tcheck(t, err, "read header")
+	tcompare(t, fields, []Field{
+		{"Subject", "one", []byte("Subject: one\r\n")},
+		{"Received", "a\n b", []byte("Received: a\r\n b\r\n")},
+		{"received", "c", []byte("received: c\r\n")},
+	})
+	body, err := io.ReadAll(br)
+	tcheck(t, err, "read body")
+	tcompare(t, string(body), "body\r\n")
+
+	header := ApplyHeader(fields, []Modification{
+		{Kind: ModChangeHeader, Index: 2, Name: "Received", Value: "d"},
+		{Kind: ModChangeHeader, Index: 1, Name: "Subject"},
+		{Kind: ModChangeHeader, Index: 1, Name: "X-New", Value: "new"},
+		{Kind: ModInsertHeader, Index: 100, Name: "X-Last", Value: "last\nInjected: no"},
+		{Kind: ModInsertHeader, Index: 0, Name: "X-First", Value: "first"},
+		{Kind: ModQuarantine, Reason: "ignored"},
+	})
+	tcompare(t, string(header), "X-First: first\r\nReceived: a\r\n b\r\nReceived: d\r\nX-New: new\r\nX-Last: last\r\n\tInjected: no\r\n\r\n")
+
+	// Message without body.
+	fields, err = ReadHeader(bufio.NewReader(strings.NewReader("Subject: test\r\n")))
+	tcheck(t, err, "read header")
+	tcompare(t, len(fields), 1)
+
+	_, err = ReadHeader(bufio.NewReader(strings.NewReader(" continuation\r\n\r\n")))
+	if err == nil {
+		t.Fatalf("expected error for leading continuation line")
+	}
+}
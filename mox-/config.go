@@ -854,6 +854,9 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			needtls("SMTP", l.SMTP.Enabled && !l.SMTP.NoSTARTTLS)
 			needtls("Submissions", l.Submissions.Enabled)
 			needtls("Submission", l.Submission.Enabled && !l.Submission.NoRequireSTARTTLS)
+			needtls("ManageSieve", l.ManageSieve.Enabled && !l.ManageSieve.NoRequireSTARTTLS)
+			needtls("POP3S", l.POP3S.Enabled)
+			needtls("POP3", l.POP3.Enabled && !l.POP3.NoRequireSTARTTLS)
 			needtls("AccountHTTPS", l.AccountHTTPS.Enabled)
 			needtls("AdminHTTPS", l.AdminHTTPS.Enabled)
 			needtls("AutoconfigHTTPS", l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS)
@@ -897,6 +900,44 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 			l.SMTP.DNSBLZones = append(l.SMTP.DNSBLZones, d)
 		}
+		for i := range l.Milters {
+			m := &l.Milters[i]
+			if !strings.HasPrefix(m.Address, "unix:") && !strings.HasPrefix(m.Address, "inet:") && !strings.HasPrefix(m.Address, "inet6:") {
+				addListenerErrorf("milter address %q must start with unix:, inet: or inet6:", m.Address)
+			}
+			if m.ConnectTimeout == 0 {
+				m.ConnectTimeout = 10 * time.Second
+			}
+			if m.CommandTimeout == 0 {
+				m.CommandTimeout = 30 * time.Second
+			}
+			if m.ConnectTimeout < 0 || m.CommandTimeout < 0 {
+				addListenerErrorf("milter %q timeouts cannot be negative", m.Address)
+			}
+		}
+		if l.LMTP.Enabled && l.LMTP.UnixSocket == "" && len(l.LMTP.AllowedIPs) == 0 {
+			addListenerErrorf("lmtp enabled without unix socket or allowed ips")
+		}
+		for _, s := range l.LMTP.AllowedIPs {
+			if !strings.Contains(s, "/") {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					addListenerErrorf("invalid lmtp allowed ip %q", s)
+					continue
+				}
+				if ip.To4() != nil {
+					s += "/32"
+				} else {
+					s += "/128"
+				}
+			}
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				addListenerErrorf("parsing lmtp allowed ip network %q: %s", s, err)
+				continue
+			}
+			l.LMTP.AllowedNets = append(l.LMTP.AllowedNets, ipnet)
+		}
 		if l.IPsNATed && len(l.NATIPs) > 0 {
 			addListenerErrorf("both IPsNATed and NATIPs configued (remove deprecated IPsNATed)")
 		}
@@ -928,6 +969,8 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		l.WebmailHTTPS.Path = cleanPath("WebmailHTTPS", l.WebmailHTTPS.Enabled, l.WebmailHTTPS.Path)
 		l.WebAPIHTTP.Path = cleanPath("WebAPIHTTP", l.WebAPIHTTP.Enabled, l.WebAPIHTTP.Path)
 		l.WebAPIHTTPS.Path = cleanPath("WebAPIHTTPS", l.WebAPIHTTPS.Enabled, l.WebAPIHTTPS.Path)
+		l.JMAPHTTP.Path = cleanPath("JMAPHTTP", l.JMAPHTTP.Enabled, l.JMAPHTTP.Path)
+		l.JMAPHTTPS.Path = cleanPath("JMAPHTTPS", l.JMAPHTTPS.Enabled, l.JMAPHTTPS.Path)
 		c.Listeners[name] = l
 	}
 	if haveUnspecifiedSMTPListener {
@@ -1120,6 +1163,112 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		}
 	}
 
+	c.TrustedARCSealerDomains = nil
+	for _, s := range c.TrustedARCSealers {
+		d, err := dns.ParseDomain(s)
+		if err != nil {
+			addErrorf("parsing trusted arc sealer %q: %v", s, err)
+			continue
+		}
+		c.TrustedARCSealerDomains = append(c.TrustedARCSealerDomains, d)
+	}
+
+	if c.SRS != nil {
+		c.SRS.SecretsParsed = nil
+		if len(c.SRS.Secrets) == 0 {
+			addErrorf("srs: at least one secret required")
+		}
+		for _, s := range c.SRS.Secrets {
+			if len(s) < 16 {
+				addErrorf("srs: secrets must be at least 16 characters")
+			}
+			c.SRS.SecretsParsed = append(c.SRS.SecretsParsed, []byte(s))
+		}
+		if c.SRS.MaxAge == 0 {
+			c.SRS.MaxAge = 21 * 24 * time.Hour
+		} else if c.SRS.MaxAge < 0 || c.SRS.MaxAge >= 1024*24*time.Hour {
+			addErrorf("srs: max age must be positive and less than 1024 days")
+		}
+	}
+
+	if c.Greylist != nil {
+		g := c.Greylist
+		if g.Delay == 0 {
+			g.Delay = 5 * time.Minute
+		}
+		if g.RetryWindow == 0 {
+			g.RetryWindow = 24 * time.Hour
+		}
+		if g.Expiration == 0 {
+			g.Expiration = 35 * 24 * time.Hour
+		}
+		if g.Delay < 0 || g.RetryWindow <= g.Delay {
+			addErrorf("greylist: delay must be positive and less than retry window")
+		}
+		if g.Expiration < g.RetryWindow {
+			addErrorf("greylist: expiration must be at least the retry window")
+		}
+		g.AllowedNets = nil
+		for _, s := range g.AllowedNetworks {
+			if !strings.Contains(s, "/") {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					addErrorf("greylist: invalid allowed ip %q", s)
+					continue
+				}
+				if ip.To4() != nil {
+					s += "/32"
+				} else {
+					s += "/128"
+				}
+			}
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				addErrorf("greylist: parsing allowed network %q: %s", s, err)
+				continue
+			}
+			g.AllowedNets = append(g.AllowedNets, ipnet)
+		}
+	}
+
+	if c.OIDC != nil {
+		o := c.OIDC
+		if o.Issuer == "" {
+			addErrorf("oidc: issuer required")
+		} else if u, err := url.Parse(o.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			addErrorf("oidc: issuer must be http or https url")
+		}
+		if o.DiscoveryURL == "" {
+			o.DiscoveryURL = strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+		}
+		if len(o.Audience) == 0 {
+			if o.ClientID == "" {
+				addErrorf("oidc: audience or client id required")
+			}
+			o.Audience = []string{o.ClientID}
+		}
+		if o.ClientSecretFile != "" {
+			buf, err := os.ReadFile(configDirPath(configFile, o.ClientSecretFile))
+			if err != nil {
+				addErrorf("oidc: reading client secret: %v", err)
+			}
+			o.ClientSecret = strings.TrimSpace(string(buf))
+		}
+		if o.Claim == "" {
+			o.Claim = "email"
+		}
+		if o.ClaimDomain != "" {
+			if _, err := dns.ParseDomain(o.ClaimDomain); err != nil {
+				addErrorf("oidc: parsing claim domain: %v", err)
+			}
+		}
+		if len(o.Scopes) == 0 {
+			o.Scopes = []string{"openid", "email", "profile"}
+		} else if !slices.Contains(o.Scopes, "openid") {
+			addErrorf("oidc: scopes must include openid")
+		}
+	}
+
 	// Load CA certificate pool.
 	if c.TLS.CA != nil {
 		if c.TLS.CA.AdditionalToSystem {
@@ -1433,6 +1582,11 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 		checkMailboxNormf(acc.RejectsMailbox, "rejects mailbox", addErrorf)
 
+		if strings.EqualFold(acc.POP3RetrievedMailbox, "Inbox") {
+			addAccountErrorf("cannot set POP3RetrievedMailbox to inbox, retrieved messages are already in the inbox")
+		}
+		checkMailboxNormf(acc.POP3RetrievedMailbox, "pop3 retrieved mailbox", addErrorf)
+
 		if len(acc.LoginDisabled) > 256 {
 			addAccountErrorf("message for disabled login must be <256 characters")
 		}
@@ -1481,6 +1635,21 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			}
 		}
 
+		if acc.ReservoirFilter != nil {
+			if acc.JunkFilter == nil {
+				addAccountErrorf("reservoir filter requires a junk filter")
+			}
+			if err := acc.ReservoirFilter.Params.WithDefaults().Validate(); err != nil {
+				addAccountErrorf("reservoir filter: %v", err)
+			}
+			err := acc.ReservoirFilter.Params.LoadLexicons(func(f string) string {
+				return configDirPath(dynamicPath, f)
+			})
+			if err != nil {
+				addAccountErrorf("reservoir filter: %v", err)
+			}
+		}
+
 		acc.ParsedFromIDLoginAddresses = make([]smtp.Address, len(acc.FromIDLoginAddresses))
 		for i, s := range acc.FromIDLoginAddresses {
 			a, err := smtp.ParseAddress(s)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -67,3 +68,25 @@ func DKIMSign(ctx context.Context, log mlog.Log, from smtp.Path, smtputf8 bool,
 	}
 	return "", nil
 }
+
+// ARCSeal uses the DKIM configuration of domain to generate the headers for a new
+// ARC set, for a message forwarded on behalf of the domain. The headers are
+// returned. If the domain is not found or has no DKIM selectors for signing, an
+// empty string and nil error is returned.
+func ARCSeal(ctx context.Context, log mlog.Log, domain dns.Domain, smtputf8 bool, authResults string, chain dkim.ARCResult, msg io.ReaderAt) (string, error) {
+	confDom, ok := Conf.Domain(domain)
+	if !ok {
+		return "", nil
+	} else if confDom.Disabled {
+		return "", ErrDomainDisabled
+	}
+	selectors := DKIMSelectors(confDom.DKIM)
+	if len(selectors) == 0 {
+		return "", nil
+	}
+	arcHeaders, err := dkim.ARCSeal(ctx, log.Logger, domain, selectors, smtputf8, authResults, chain, msg)
+	if err != nil {
+		return "", fmt.Errorf("arc seal for domain %s: %w", domain, err)
+	}
+	return arcHeaders, nil
+}
@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// key is a public signing key from the JWKS of the identity provider.
+type key struct {
+	ID        string
+	Algorithm string // Optional, if set, tokens must use this algorithm.
+	Key       crypto.PublicKey
+}
+
+// jwks is a JSON Web Key Set, ../rfc/7517.
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+
+	// RSA.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC and OKP.
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// parse returns the usable signing keys. Keys for encryption or of unknown types
+// are skipped.
+func (ks jwks) parse(log mlog.Log) ([]key, error) {
+	var keys []key
+	for _, k := range ks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			log.Debugx("skipping oidc signing key", err, slog.String("kid", k.KeyID), slog.String("kty", k.KeyType))
+			continue
+		}
+		keys = append(keys, key{k.KeyID, k.Algorithm, pk})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable keys")
+	}
+	return keys, nil
+}
+
+func b64bigint(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := b64bigint(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("parsing modulus: %v", err)
+		}
+		e, err := b64bigint(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("parsing exponent: %v", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa key too small, %d bits", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q", k.Curve)
+		}
+		x, err := b64bigint(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("parsing x: %v", err)
+		}
+		y, err := b64bigint(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("parsing y: %v", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unknown curve %q", k.Curve)
+		}
+		buf, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("parsing x: %v", err)
+		}
+		if len(buf) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 public key size %d", len(buf))
+		}
+		return ed25519.PublicKey(buf), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+// jwt is a parsed but not yet verified JSON Web Token, ../rfc/7519.
+type jwt struct {
+	header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	signed    string // Header and payload as encoded in the token, covered by the signature.
+	payload   []byte
+	signature []byte
+}
+
+func parseJWT(token string) (*jwt, error) {
+	t := strings.Split(token, ".")
+	if len(t) != 3 {
+		return nil, fmt.Errorf("%w: not a jwt with signature", ErrToken)
+	}
+	var jt jwt
+	header, err := base64.RawURLEncoding.DecodeString(t[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding header: %v", ErrToken, err)
+	}
+	if err := json.Unmarshal(header, &jt.header); err != nil {
+		return nil, fmt.Errorf("%w: parsing header: %v", ErrToken, err)
+	}
+	jt.payload, err = base64.RawURLEncoding.DecodeString(t[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding payload: %v", ErrToken, err)
+	}
+	jt.signature, err = base64.RawURLEncoding.DecodeString(t[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding signature: %v", ErrToken, err)
+	}
+	jt.signed = t[0] + "." + t[1]
+	return &jt, nil
+}
+
+// hashAlgorithms for the signature algorithms, ../rfc/7518.
+var hashAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// verifySignature checks if one of keys has made the signature. The algorithm
+// from the header must match the type of key. Unsigned tokens ("none") and HMAC
+// algorithms are never accepted.
+func (jt *jwt) verifySignature(keys []key) error {
+	alg := jt.header.Algorithm
+	hash, ok := hashAlgorithms[alg]
+	if !ok && alg != "EdDSA" {
+		return fmt.Errorf("%w: unsupported signature algorithm %q", ErrToken, alg)
+	}
+	var digest []byte
+	if ok {
+		h := hash.New()
+		h.Write([]byte(jt.signed))
+		digest = h.Sum(nil)
+	}
+
+	for _, k := range keys {
+		if k.Algorithm != "" && k.Algorithm != alg {
+			continue
+		}
+		var valid bool
+		switch pk := k.Key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") {
+				valid = rsa.VerifyPKCS1v15(pk, hash, digest, jt.signature) == nil
+			} else if strings.HasPrefix(alg, "PS") {
+				valid = rsa.VerifyPSS(pk, hash, digest, jt.signature, nil) == nil
+			}
+		case *ecdsa.PublicKey:
+			// Signature is the concatenation of r and s, each of the size of the curve.
+			size := (pk.Curve.Params().BitSize + 7) / 8
+			// The curve is implied by the algorithm.
+			curveAlg := map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}[pk.Curve.Params().BitSize]
+			if alg == curveAlg && len(jt.signature) == 2*size {
+				r := new(big.Int).SetBytes(jt.signature[:size])
+				s := new(big.Int).SetBytes(jt.signature[size:])
+				valid = ecdsa.Verify(pk, digest, r, s)
+			}
+		case ed25519.PublicKey:
+			if alg == "EdDSA" {
+				valid = ed25519.Verify(pk, []byte(jt.signed), jt.signature)
+			}
+		}
+		if valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no valid signature by known key", ErrToken)
+}
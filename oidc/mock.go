@@ -0,0 +1,173 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MockIssuer is a minimal OpenID Connect identity provider, used for testing. It
+// serves a discovery document, signing keys, and authorization and token
+// endpoints, and issues tokens signed with an ECDSA P-256 key.
+type MockIssuer struct {
+	Server *httptest.Server
+	URL    string // Issuer, the URL of the server.
+
+	// Claims for ID tokens issued through the authorization endpoint, in addition
+	// to iss, aud, iat, exp and nonce.
+	LoginClaims map[string]any
+
+	key *ecdsa.PrivateKey
+
+	sync.Mutex
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	clientID, redirectURI, nonce, codeChallenge string
+}
+
+// NewMockIssuer starts a mock issuer. Call Close when done.
+func NewMockIssuer() *MockIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	m := &MockIssuer{key: key, codes: map[string]mockCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.serveDiscovery)
+	mux.HandleFunc("/jwks", m.serveJWKS)
+	mux.HandleFunc("/authorize", m.serveAuthorize)
+	mux.HandleFunc("/token", m.serveToken)
+	m.Server = httptest.NewServer(mux)
+	m.URL = m.Server.URL
+	return m
+}
+
+// Close stops the server.
+func (m *MockIssuer) Close() {
+	m.Server.Close()
+}
+
+// Token returns a signed token with claims. The issuer is set, and exp is set one
+// hour ahead, unless present in claims.
+func (m *MockIssuer) Token(claims map[string]any) string {
+	c := map[string]any{
+		"iss": m.URL,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": "mock"})
+	if err != nil {
+		panic(err)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := hashAlgorithms["ES256"].New()
+	digest.Write([]byte(signed))
+	r, s, err := ecdsa.Sign(cryptorand.Reader, m.key, digest.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *MockIssuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	d := Discovery{m.URL, m.URL + "/authorize", m.URL + "/token", m.URL + "/jwks"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+func (m *MockIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	b64 := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32)))
+	}
+	ks := jwks{Keys: []jwk{{KeyType: "EC", Use: "sig", KeyID: "mock", Algorithm: "ES256", Curve: "P-256", X: b64(m.key.X), Y: b64(m.key.Y)}}}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ks)
+}
+
+// serveAuthorize immediately redirects back with a code, as if the user logged in.
+func (m *MockIssuer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "400 - bad request - unsupported response type or code challenge method", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "400 - bad request - bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 16)
+	cryptorand.Read(buf)
+	code := base64.RawURLEncoding.EncodeToString(buf)
+	m.Lock()
+	m.codes[code] = mockCode{q.Get("client_id"), q.Get("redirect_uri"), q.Get("nonce"), q.Get("code_challenge")}
+	m.Unlock()
+
+	rq := redirectURI.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirectURI.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (m *MockIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code, descr string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": descr})
+	}
+
+	code := r.PostFormValue("code")
+	m.Lock()
+	c, ok := m.codes[code]
+	delete(m.codes, code)
+	m.Unlock()
+	if r.PostFormValue("grant_type") != "authorization_code" || !ok {
+		tokenError("invalid_grant", "unknown code")
+		return
+	}
+	if r.PostFormValue("client_id") != c.clientID || r.PostFormValue("redirect_uri") != c.redirectURI {
+		tokenError("invalid_grant", "client_id or redirect_uri mismatch")
+		return
+	}
+	if CodeChallenge(r.PostFormValue("code_verifier")) != c.codeChallenge {
+		tokenError("invalid_grant", "code verifier mismatch")
+		return
+	}
+
+	claims := map[string]any{"aud": c.clientID, "nonce": c.nonce}
+	for k, v := range m.LoginClaims {
+		claims[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"access_token": m.Token(map[string]any{"aud": c.clientID, "sub": fmt.Sprint(m.LoginClaims["sub"])}),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.Token(claims),
+	})
+	if err != nil {
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+	}
+}
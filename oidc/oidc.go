@@ -0,0 +1,421 @@
+// Package oidc verifies tokens from an OpenID Connect identity provider, for
+// authentication with SASL OAUTHBEARER and XOAUTH2 in IMAP and SMTP, and for web
+// logins with the authorization code flow.
+//
+// Only tokens in JWT format signed with asymmetric keys are accepted: RSA
+// (RS256/384/512, PS256/384/512), ECDSA (ES256/384/512) and Ed25519 (EdDSA).
+// Opaque access tokens are not supported, they would require token introspection
+// at the identity provider for each authentication.
+//
+// The signing keys (JWKS) are found through the discovery document, and are
+// fetched again when a token references an unknown key, e.g. after key rotation.
+//
+// A token identifies a user by an email address in a configurable claim. The
+// account is looked up by that address, like with password logins.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+var pkglog = mlog.New("oidc", nil)
+
+// ErrToken is returned for tokens that are not valid, e.g. malformed, expired, for
+// another audience, with an invalid signature, or without a usable address claim.
+// Authentication must fail. Other errors are temporary, e.g. failure to fetch
+// signing keys.
+var ErrToken = errors.New("invalid token")
+
+// Allowed difference in clocks between identity provider and us.
+const clockSkew = time.Minute
+
+// How long to use the discovery document and signing keys before fetching them
+// again.
+const cacheDuration = time.Hour
+
+// Minimum time between fetching signing keys for tokens with unknown key IDs.
+const refetchInterval = time.Minute
+
+// Discovery is the subset of the OpenID Connect discovery document used by mox.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect identity provider. The discovery document and
+// signing keys are fetched when needed.
+type Provider struct {
+	Config config.OIDC
+
+	sync.Mutex
+	discovery        *Discovery
+	discoveryFetched time.Time
+	keys             []key
+	keysFetched      time.Time
+}
+
+// NewProvider returns a provider for the configuration, which must have been
+// prepared as part of the static config, with defaults filled in.
+func NewProvider(conf config.OIDC) *Provider {
+	return &Provider{Config: conf}
+}
+
+var configured struct {
+	sync.Mutex
+	conf     *config.OIDC
+	provider *Provider
+}
+
+// Configured returns the provider from the static configuration, or nil if OIDC
+// isn't configured.
+func Configured() *Provider {
+	conf := mox.Conf.Static.OIDC
+	if conf == nil {
+		return nil
+	}
+	configured.Lock()
+	defer configured.Unlock()
+	if configured.conf != conf {
+		configured.conf = conf
+		configured.provider = NewProvider(*conf)
+	}
+	return configured.provider
+}
+
+// WebLogin returns whether logins through the identity provider are enabled
+// for the web interfaces.
+func (p *Provider) WebLogin() bool {
+	return p.Config.ClientID != ""
+}
+
+// fetchJSON fetches a JSON document with a GET request.
+func fetchJSON(ctx context.Context, u string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http get: status %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(v); err != nil {
+		return fmt.Errorf("parsing json response: %v", err)
+	}
+	return nil
+}
+
+// Discovery returns the discovery document, fetching it if needed. The lock is
+// not held while fetching, so a slow identity provider doesn't block other
+// authentications.
+func (p *Provider) Discovery(ctx context.Context, log mlog.Log) (*Discovery, error) {
+	p.Lock()
+	cur, fetched := p.discovery, p.discoveryFetched
+	p.Unlock()
+	if cur != nil && time.Since(fetched) < cacheDuration {
+		return cur, nil
+	}
+
+	var d Discovery
+	if err := fetchJSON(ctx, p.Config.DiscoveryURL, &d); err != nil {
+		if cur != nil {
+			// Keep using the document we have.
+			log.Errorx("fetching oidc discovery document, keeping previous", err)
+			return cur, nil
+		}
+		return nil, fmt.Errorf("fetching oidc discovery document: %v", err)
+	}
+	if d.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("issuer %q in discovery document does not match configured issuer %q", d.Issuer, p.Config.Issuer)
+	}
+	if d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document has no jwks_uri")
+	}
+	log.Debug("fetched oidc discovery document", slog.String("url", p.Config.DiscoveryURL), slog.String("jwksuri", d.JWKSURI))
+
+	p.Lock()
+	defer p.Unlock()
+	p.discovery = &d
+	p.discoveryFetched = time.Now()
+	return &d, nil
+}
+
+// signingKeys returns the keys that can have signed a token with key ID kid,
+// fetching keys if we don't have them yet, if they are old, or if we don't have
+// a key with the ID. Like for the discovery document, the lock is only held to
+// read and replace the cached keys, not while fetching.
+func (p *Provider) signingKeys(ctx context.Context, log mlog.Log, kid string) ([]key, error) {
+	match := func(keys []key) []key {
+		var l []key
+		for _, k := range keys {
+			if kid == "" || k.ID == kid {
+				l = append(l, k)
+			}
+		}
+		return l
+	}
+
+	p.Lock()
+	cur, fetched := p.keys, p.keysFetched
+	p.Unlock()
+
+	l := match(cur)
+	stale := time.Since(fetched) >= cacheDuration
+	unknown := len(l) == 0 && time.Since(fetched) >= refetchInterval
+	if !stale && !unknown {
+		return l, nil
+	}
+
+	d, err := p.Discovery(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	var ks jwks
+	if err := fetchJSON(ctx, d.JWKSURI, &ks); err != nil {
+		if len(cur) > 0 {
+			log.Errorx("fetching oidc signing keys, keeping previous", err)
+			return l, nil
+		}
+		return nil, fmt.Errorf("fetching oidc signing keys: %v", err)
+	}
+	keys, err := ks.parse(log)
+	if err != nil {
+		return nil, fmt.Errorf("parsing oidc signing keys: %v", err)
+	}
+	log.Debug("fetched oidc signing keys", slog.Int("nkeys", len(keys)))
+
+	p.Lock()
+	defer p.Unlock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	return match(keys), nil
+}
+
+// Claims are the claims from the payload of a verified token.
+type Claims map[string]any
+
+// verify verifies the token, including the signature, issuer, audience, validity
+// period, and nonce if non-empty.
+func (p *Provider) verify(ctx context.Context, log mlog.Log, token string, audiences []string, nonce string) (Claims, error) {
+	jt, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.signingKeys(ctx, log, jt.header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := jt.verifySignature(keys); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	dec := json.NewDecoder(strings.NewReader(string(jt.payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: parsing payload: %v", ErrToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q, expected %q", ErrToken, iss, p.Config.Issuer)
+	}
+	var auds []string
+	switch v := claims["aud"].(type) {
+	case string:
+		auds = []string{v}
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+	if !slices.ContainsFunc(auds, func(s string) bool { return slices.Contains(audiences, s) }) {
+		return nil, fmt.Errorf("%w: audience %v not accepted", ErrToken, auds)
+	}
+
+	now := time.Now()
+	exp, ok := claims.time("exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing expiration time", ErrToken)
+	} else if now.After(exp.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrToken, exp.Format(time.RFC3339))
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(clockSkew).Before(nbf) {
+		return nil, fmt.Errorf("%w: not valid before %s", ErrToken, nbf.Format(time.RFC3339))
+	}
+	if nonce != "" {
+		if v, _ := claims["nonce"].(string); v != nonce {
+			return nil, fmt.Errorf("%w: nonce mismatch", ErrToken)
+		}
+	}
+	return claims, nil
+}
+
+// time returns a NumericDate claim, in seconds since the epoch.
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	v, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// address returns the email address from the configured claim.
+func (p *Provider) address(claims Claims) (string, error) {
+	v, _ := claims[p.Config.Claim].(string)
+	if v == "" {
+		return "", fmt.Errorf("%w: missing claim %q", ErrToken, p.Config.Claim)
+	}
+	if p.Config.Claim == "email" {
+		// The email claim can be set by users at some identity providers, it must be
+		// verified before we use it to find an account.
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", fmt.Errorf("%w: email address not verified", ErrToken)
+		}
+	}
+	if !strings.Contains(v, "@") && p.Config.ClaimDomain != "" {
+		v += "@" + p.Config.ClaimDomain
+	}
+	addr, err := smtp.ParseAddress(v)
+	if err != nil {
+		return "", fmt.Errorf("%w: parsing address from claim %q: %v", ErrToken, p.Config.Claim, err)
+	}
+	return addr.String(), nil
+}
+
+// VerifyAccessToken verifies a bearer token, as used with SASL OAUTHBEARER and
+// XOAUTH2, and returns the email address from the configured claim. The token
+// must have one of the configured audiences.
+func (p *Provider) VerifyAccessToken(ctx context.Context, log mlog.Log, token string) (address string, rerr error) {
+	claims, err := p.verify(ctx, log, token, p.Config.Audience, "")
+	if err != nil {
+		return "", err
+	}
+	return p.address(claims)
+}
+
+// CodeChallenge returns the PKCE code challenge for a code verifier, with method
+// S256.
+func CodeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// AuthorizationURL returns the URL at the identity provider to redirect a browser
+// to for a web login. After login, the identity provider redirects to redirectURI
+// with the state and an authorization code, for use with Login.
+func (p *Provider) AuthorizationURL(ctx context.Context, log mlog.Log, redirectURI, state, nonce, codeVerifier string) (string, error) {
+	d, err := p.Discovery(ctx, log)
+	if err != nil {
+		return "", err
+	}
+	if d.AuthorizationEndpoint == "" {
+		return "", fmt.Errorf("discovery document has no authorization_endpoint")
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parsing authorization endpoint: %v", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.Config.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Login exchanges an authorization code from a web login for tokens at the
+// identity provider, verifies the ID token, and returns the email address from
+// the configured claim.
+func (p *Provider) Login(ctx context.Context, log mlog.Log, code, redirectURI, nonce, codeVerifier string) (address string, rerr error) {
+	d, err := p.Discovery(ctx, log)
+	if err != nil {
+		return "", err
+	}
+	if d.TokenEndpoint == "" {
+		return "", fmt.Errorf("discovery document has no token_endpoint")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("new token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&tr); err != nil {
+		return "", fmt.Errorf("parsing token response (status %s): %v", resp.Status, err)
+	}
+	if tr.Error != "" {
+		// E.g. an expired or already used code.
+		return "", fmt.Errorf("%w: token request: %s: %s", ErrToken, tr.Error, tr.ErrorDescription)
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: status %s", resp.Status)
+	} else if tr.IDToken == "" {
+		return "", fmt.Errorf("token response without id_token")
+	}
+
+	claims, err := p.verify(ctx, log, tr.IDToken, []string{p.Config.ClientID}, nonce)
+	if err != nil {
+		return "", err
+	}
+	return p.address(claims)
+}
@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	issuer := NewMockIssuer()
+	defer issuer.Close()
+
+	p := NewProvider(config.OIDC{
+		Issuer:       issuer.URL,
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		Audience:     []string{"mail"},
+		Claim:        "email",
+	})
+
+	test := func(claims map[string]any, expAddress string, expErr error) {
+		t.Helper()
+		token := issuer.Token(claims)
+		address, err := p.VerifyAccessToken(ctxbg, pkglog, token)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if address != expAddress {
+			t.Fatalf("got address %q, expected %q", address, expAddress)
+		}
+	}
+
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example", "email_verified": true}, "mjl@mox.example", nil)
+	test(map[string]any{"aud": []string{"other", "mail"}, "email": "mjl@mox.example", "email_verified": true}, "mjl@mox.example", nil)
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example"}, "", ErrToken)                           // Not verified.
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example", "email_verified": "true"}, "", ErrToken) // Not a boolean.
+	test(map[string]any{"aud": "other", "email": "mjl@mox.example"}, "", ErrToken)
+	test(map[string]any{"aud": "mail", "iss": "https://other.example", "email": "mjl@mox.example"}, "", ErrToken)
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example", "exp": time.Now().Add(-time.Hour).Unix()}, "", ErrToken)
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example", "nbf": time.Now().Add(time.Hour).Unix()}, "", ErrToken)
+	test(map[string]any{"aud": "mail", "email": "mjl@mox.example", "email_verified": false}, "", ErrToken)
+	test(map[string]any{"aud": "mail"}, "", ErrToken)
+	test(map[string]any{"aud": "mail", "email": "mjl"}, "", ErrToken)
+
+	// Claim without domain, with configured domain.
+	p.Config.Claim = "preferred_username"
+	p.Config.ClaimDomain = "mox.example"
+	test(map[string]any{"aud": "mail", "preferred_username": "mjl"}, "mjl@mox.example", nil)
+	test(map[string]any{"aud": "mail", "preferred_username": "mjl@other.example"}, "mjl@other.example", nil)
+
+	token := issuer.Token(map[string]any{"aud": "mail", "preferred_username": "mjl"})
+	t0 := strings.Split(token, ".")
+
+	// Modified payload.
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"` + issuer.URL + `","aud":"mail","preferred_username":"other","exp":9999999999}`))
+	_, err := p.VerifyAccessToken(ctxbg, pkglog, t0[0]+"."+payload+"."+t0[2])
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for modified payload", err)
+	}
+
+	// Unsigned token.
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	_, err = p.VerifyAccessToken(ctxbg, pkglog, header+"."+t0[1]+".")
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for unsigned token", err)
+	}
+
+	_, err = p.VerifyAccessToken(ctxbg, pkglog, "bogus")
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for malformed token", err)
+	}
+
+	// Token signed by key from another issuer, with same key id.
+	issuer2 := NewMockIssuer()
+	defer issuer2.Close()
+	issuer2.URL = issuer.URL
+	_, err = p.VerifyAccessToken(ctxbg, pkglog, issuer2.Token(map[string]any{"aud": "mail", "preferred_username": "mjl"}))
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for token signed by unknown key", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	issuer := NewMockIssuer()
+	defer issuer.Close()
+	issuer.LoginClaims = map[string]any{"sub": "1", "email": "mjl@mox.example", "email_verified": true}
+
+	p := NewProvider(config.OIDC{
+		Issuer:       issuer.URL,
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		Audience:     []string{"webmail"},
+		ClientID:     "webmail",
+		Claim:        "email",
+		Scopes:       []string{"openid", "email"},
+	})
+	if !p.WebLogin() {
+		t.Fatalf("web login not enabled")
+	}
+
+	const redirectURI = "https://mail.mox.example/webmail/oidc/callback"
+	const verifier = "verifier0123456789012345678901234567890123"
+
+	// authorize returns the code from the redirect by the issuer.
+	authorize := func(nonce string) string {
+		t.Helper()
+		authURL, err := p.AuthorizationURL(ctxbg, pkglog, redirectURI, "state0", nonce, verifier)
+		tcheck(t, err, "authorization url")
+		if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") || !strings.Contains(authURL, "scope=openid+email") {
+			t.Fatalf("unexpected authorization url %q", authURL)
+		}
+		client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(authURL)
+		tcheck(t, err, "authorize")
+		resp.Body.Close()
+		u, err := url.Parse(resp.Header.Get("Location"))
+		tcheck(t, err, "parse redirect")
+		if !strings.HasPrefix(u.String(), redirectURI+"?") || u.Query().Get("state") != "state0" {
+			t.Fatalf("unexpected redirect %q", u)
+		}
+		return u.Query().Get("code")
+	}
+
+	code := authorize("nonce0")
+	address, err := p.Login(ctxbg, pkglog, code, redirectURI, "nonce0", verifier)
+	tcheck(t, err, "login")
+	if address != "mjl@mox.example" {
+		t.Fatalf("got address %q, expected mjl@mox.example", address)
+	}
+
+	// Code can only be used once.
+	_, err = p.Login(ctxbg, pkglog, code, redirectURI, "nonce0", verifier)
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for reused code", err)
+	}
+
+	// Nonce in ID token must match.
+	code = authorize("nonce1")
+	_, err = p.Login(ctxbg, pkglog, code, redirectURI, "nonce0", verifier)
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for nonce mismatch", err)
+	}
+
+	// Code verifier must match the challenge.
+	code = authorize("nonce0")
+	_, err = p.Login(ctxbg, pkglog, code, redirectURI, "nonce0", verifier+"x")
+	if !errors.Is(err, ErrToken) {
+		t.Fatalf("got err %v, expected ErrToken for code verifier mismatch", err)
+	}
+}
@@ -0,0 +1,1589 @@
+// Package pop3server implements a POP3 server (RFC 1939), for retrieving
+// messages from the Inbox of an account by email applications and devices that
+// do not support IMAP.
+package pop3server
+
+/*
+Implementation notes
+
+- The maildrop is the Inbox of the account. The list of messages is read when authenticating, message numbers are stable during the session. ../rfc/1939
+- Only a single POP3 session can have the maildrop of an account open at a time. Other protocols (IMAP, webmail) can access the Inbox at the same time. Messages removed or moved out of the Inbox by other sessions are treated as deleted, and are not listed anymore.
+- Messages marked deleted are removed, and messages retrieved but not deleted are moved to the account's POP3RetrievedMailbox if configured, when the client ends the session with QUIT. If the connection is closed without QUIT, nothing changes. ../rfc/1939
+- The unique-id of a message for UIDL is formed from the UIDVALIDITY of the Inbox and the IMAP UID of the message, so it remains the same between sessions. ../rfc/1939
+- Message sizes are those of the stored messages, which we store with CRLF line endings. Messages are written with bare newlines changed into CRLF.
+- Authentication uses the same SASL mechanisms as IMAP and submission, and login attempts are recorded in the same way. ../rfc/5034
+- APOP is not implemented, it requires plain text passwords to be stored.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webops"
+)
+
+var (
+	metricConnection = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_pop3_connection_total",
+			Help: "Incoming POP3 connections.",
+		},
+		[]string{
+			"service", // pop3, pop3s
+		},
+	)
+	metricCommands = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_pop3_command_duration_seconds",
+			Help:    "POP3 command duration and result codes in seconds.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"cmd",
+			"result", // ok, panic, ioerror, badsyntax, servererror, usererror, error
+		},
+	)
+)
+
+var unhandledPanics atomic.Int64 // For tests.
+
+var limiterConnectionrate, limiterConnections *ratelimit.Limiter
+
+func init() {
+	// Also called by tests, so they don't trigger the rate limiter.
+	limitersInit()
+}
+
+func limitersInit() {
+	mox.LimitersInit()
+	limiterConnectionrate = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Minute,
+				Limits: [...]int64{300, 900, 2700},
+			},
+		},
+	}
+	limiterConnections = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Duration(math.MaxInt64), // All of time.
+				Limits: [...]int64{30, 90, 270},
+			},
+		},
+	}
+}
+
+// Delay after bad/suspicious behaviour. Tests set these to zero.
+var badClientDelay = time.Second // Before reads and after 1-byte writes for probably spammers.
+var authFailDelay = time.Second  // After authentication failure.
+
+// Accounts with their maildrop opened by a POP3 session. Only a single session
+// can have the maildrop open. ../rfc/1939
+var maildrops = struct {
+	sync.Mutex
+	accounts map[string]struct{}
+}{accounts: map[string]struct{}{}}
+
+// message is a message in the maildrop of a session.
+type message struct {
+	ID        int64
+	UID       store.UID
+	Size      int64
+	deleted   bool // Marked for deletion with DELE, can be reset with RSET.
+	removed   bool // Removed or moved out of the Inbox by another session.
+	retrieved bool // Retrieved with RETR, for moving to the POP3RetrievedMailbox.
+}
+
+type conn struct {
+	cid               int64
+	conn              net.Conn
+	connBroken        bool // Once broken, we won't flush any more data.
+	tls               bool // Whether TLS has been initialized.
+	br                *bufio.Reader
+	tr                *moxio.TraceReader // Kept to change trace level when reading authentication data.
+	xbw               *bufio.Writer      // To remote, with TLS added in case of TLS. Writes go through xtw to conn.Write, which panics on errors, hence the "x".
+	xtw               *moxio.TraceWriter
+	slow              bool      // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	lastlog           time.Time // For printing time since previous log line.
+	baseTLSConfig     *tls.Config
+	remoteIP          net.IP
+	noRequireSTARTTLS bool
+	cmd               string // Currently executing, for logging and metrics.
+	cmdStart          time.Time
+	ncmds             int // Number of commands processed. Used to abort connection when first incoming command is unknown/invalid.
+	log               mlog.Log
+
+	loginAttempt *store.LoginAttempt
+	authFailed   int    // Number of failed auth attempts. For slowing down remote with many failures.
+	user         string // From USER command, for PASS command.
+
+	// Only set when connection has been authenticated. The maildrop is open while
+	// account is set.
+	username    string // Full username as used during login.
+	account     *store.Account
+	comm        *store.Comm // For receiving removals of messages by other sessions.
+	mailboxID   int64       // Of the Inbox.
+	uidValidity uint32      // Of the Inbox, for unique-id's.
+	msgs        []message   // Ordered by UID. Message number is index+1.
+}
+
+var commandsStateAny = map[string]bool{"capa": true, "quit": true}
+var commandsStateAuthorization = map[string]bool{"user": true, "pass": true, "auth": true, "stls": true}
+
+var commands = map[string]func(c *conn, args string){
+	// Any state.
+	"capa": (*conn).cmdCapa,
+	"quit": (*conn).cmdQuit,
+
+	// Authorization.
+	"user": (*conn).cmdUser,
+	"pass": (*conn).cmdPass,
+	"auth": (*conn).cmdAuth,
+	"stls": (*conn).cmdStls,
+
+	// Transaction.
+	"stat": (*conn).cmdStat,
+	"list": (*conn).cmdList,
+	"uidl": (*conn).cmdUidl,
+	"retr": (*conn).cmdRetr,
+	"top":  (*conn).cmdTop,
+	"dele": (*conn).cmdDele,
+	"rset": (*conn).cmdRset,
+	"noop": (*conn).cmdNoop,
+}
+
+var errIO = errors.New("io error")             // For read/write errors and errors that should close the connection.
+var errProtocol = errors.New("protocol error") // For protocol errors for which a stack trace should be printed.
+
+var sanityChecks bool
+
+// check err for sanity.
+// if not nil and checkSanity true (set during tests), then panic. if not nil during normal operation, just log.
+func (c *conn) xsanity(err error, format string, args ...any) {
+	if err == nil {
+		return
+	}
+	if sanityChecks {
+		panic(fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), err))
+	}
+	c.log.Errorx(fmt.Sprintf(format, args...), err)
+}
+
+func (c *conn) xbrokenf(format string, args ...any) {
+	c.connBroken = true
+	panic(fmt.Errorf(format, args...))
+}
+
+// Listen initializes all pop3 listeners for the configuration, and stores them
+// for Serve to start them.
+func Listen() {
+	names := slices.Sorted(maps.Keys(mox.Conf.Static.Listeners))
+	for _, name := range names {
+		listener := mox.Conf.Static.Listeners[name]
+
+		var tlsConfig *tls.Config
+		if listener.TLS != nil {
+			tlsConfig = listener.TLS.Config
+		}
+
+		if listener.POP3.Enabled {
+			port := config.Port(listener.POP3.Port, 110)
+			for _, ip := range listener.IPs {
+				listen1("pop3", name, ip, port, tlsConfig, false, listener.POP3.NoRequireSTARTTLS)
+			}
+		}
+
+		if listener.POP3S.Enabled {
+			port := config.Port(listener.POP3S.Port, 995)
+			for _, ip := range listener.IPs {
+				listen1("pop3s", name, ip, port, tlsConfig, true, false)
+			}
+		}
+	}
+}
+
+var servers []func()
+
+func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config, xtls, noRequireSTARTTLS bool) {
+	log := mlog.New("pop3server", nil)
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+	if os.Getuid() == 0 {
+		log.Print("listening for "+protocol,
+			slog.String("listener", listenerName),
+			slog.String("addr", addr))
+	}
+	network := mox.Network(ip)
+	ln, err := mox.Listen(network, addr)
+	if err != nil {
+		log.Fatalx(protocol+": listen for "+protocol, err, slog.String("listener", listenerName))
+	}
+
+	// Each listener gets its own copy of the config, so session keys between different
+	// ports on same listener aren't shared. See imapserver.
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig)
+	}
+
+	serve := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox(protocol+": accept", err, slog.String("protocol", protocol), slog.String("listener", listenerName))
+				continue
+			}
+
+			metricConnection.WithLabelValues(protocol).Inc()
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noRequireSTARTTLS)
+		}
+	}
+
+	servers = append(servers, serve)
+}
+
+// Serve starts serving on all listeners, launching a goroutine per listener.
+func Serve() {
+	for _, serve := range servers {
+		go serve()
+	}
+	servers = nil
+}
+
+// Logbg returns a logger for logging in the background (in a goroutine), eg for
+// logging LoginAttempts.
+func (c *conn) logbg() mlog.Log {
+	log := mlog.New("pop3server", nil).WithCid(c.cid)
+	if c.username != "" {
+		log = log.With(slog.String("username", c.username))
+	}
+	return log
+}
+
+func (c *conn) setSlow(on bool) {
+	if on && !c.slow {
+		c.log.Debug("connection changed to slow")
+	} else if !on && c.slow {
+		c.log.Debug("connection restored to regular pace")
+	}
+	c.slow = on
+}
+
+// Write makes a connection an io.Writer. It panics for i/o errors. These errors
+// are handled in the connection command loop.
+func (c *conn) Write(buf []byte) (int, error) {
+	chunk := len(buf)
+	if c.slow {
+		chunk = 1
+	}
+
+	var n int
+	for len(buf) > 0 {
+		err := c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		c.log.Check(err, "setting write deadline")
+
+		nn, err := c.conn.Write(buf[:chunk])
+		if err != nil {
+			c.xbrokenf("write: %s (%w)", err, errIO)
+		}
+		n += nn
+		buf = buf[chunk:]
+		if len(buf) > 0 && badClientDelay > 0 {
+			mox.Sleep(mox.Context, badClientDelay)
+		}
+	}
+	return n, nil
+}
+
+func (c *conn) xtraceread(level slog.Level) func() {
+	c.tr.SetTrace(level)
+	return func() {
+		c.tr.SetTrace(mlog.LevelTrace)
+	}
+}
+
+func (c *conn) xtracewrite(level slog.Level) func() {
+	c.xflush()
+	c.xtw.SetTrace(level)
+	return func() {
+		c.xflush()
+		c.xtw.SetTrace(mlog.LevelTrace)
+	}
+}
+
+// Cache of line buffers for reading commands. Commands are at most 255 bytes,
+// but SASL responses can be longer. ../rfc/2449 ../rfc/5034
+var bufpool = moxio.NewBufpool(8, 16*1024)
+
+// xreadline reads a line, without crlf.
+func (c *conn) xreadline() string {
+	if c.slow && badClientDelay > 0 {
+		mox.Sleep(mox.Context, badClientDelay)
+	}
+
+	// Inactivity timeout must be at least 10 minutes after authentication. ../rfc/1939
+	d := 10 * time.Minute
+	if c.account == nil {
+		d = 30 * time.Second
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(d))
+	c.log.Check(err, "setting read deadline")
+
+	line, err := bufpool.Readline(c.log, c.br)
+	if err != nil && errors.Is(err, moxio.ErrLineTooLong) {
+		c.xbrokenf("%s (%w)", err, errProtocol)
+	} else if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// We do not enter the update state. ../rfc/1939
+			err := c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+			c.log.Check(err, "setting deadline")
+			c.xwritelinef("-ERR inactive, closing connection")
+		}
+		c.xbrokenf("%s (%w)", err, errIO)
+	}
+	return line
+}
+
+// Buffer line for write.
+func (c *conn) xbwritelinef(format string, args ...any) {
+	format += "\r\n"
+	fmt.Fprintf(c.xbw, format, args...)
+}
+
+func (c *conn) xwritelinef(format string, args ...any) {
+	c.xbwritelinef(format, args...)
+	c.xflush()
+}
+
+func (c *conn) xflush() {
+	// If the connection is already broken, we're not going to write more.
+	if c.connBroken {
+		return
+	}
+
+	err := c.xbw.Flush()
+	xcheckf(err, "flush") // Should never happen, the Write caused by the Flush should panic on i/o error.
+}
+
+func (c *conn) ok(format string, args ...any) {
+	c.xwritelinef("+OK %s", fmt.Sprintf(format, args...))
+}
+
+// xwriteerr writes an error response, with optional extended response code.
+// ../rfc/2449
+func (c *conn) xwriteerr(code, text string) {
+	if code != "" {
+		c.xwritelinef("-ERR [%s] %s", code, text)
+	} else {
+		c.xwritelinef("-ERR %s", text)
+	}
+}
+
+var cleanClose struct{} // Sentinel value for panic/recover indicating clean close of connection.
+
+// serve handles a single POP3 connection on nc.
+//
+// If xtls is set, immediate TLS should be enabled on the connection. If tlsConfig
+// is set and xtls is not set, STLS may enable TLS. If noRequireSTARTTLS is set, TLS
+// is not required for authentication with plain text passwords.
+//
+// The connection is closed before returning.
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noRequireSTARTTLS bool) {
+	var remoteIP net.IP
+	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = a.IP
+	} else {
+		// For tests.
+		remoteIP = net.ParseIP("127.0.0.10")
+	}
+
+	c := &conn{
+		cid:               cid,
+		conn:              nc,
+		tls:               xtls,
+		lastlog:           time.Now(),
+		baseTLSConfig:     tlsConfig,
+		remoteIP:          remoteIP,
+		noRequireSTARTTLS: noRequireSTARTTLS,
+		cmd:               "(greeting)",
+		cmdStart:          time.Now(),
+	}
+	var logmutex sync.Mutex
+	// Also see (and possibly update) c.logbg, for logging in a goroutine.
+	c.log = mlog.New("pop3server", nil).WithFunc(func() []slog.Attr {
+		logmutex.Lock()
+		defer logmutex.Unlock()
+		now := time.Now()
+		l := []slog.Attr{
+			slog.Int64("cid", c.cid),
+			slog.Duration("delta", now.Sub(c.lastlog)),
+		}
+		c.lastlog = now
+		if c.username != "" {
+			l = append(l, slog.String("username", c.username))
+		}
+		return l
+	})
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+	c.xtw = moxio.NewTraceWriter(c.log, "S: ", c)
+	c.xbw = bufio.NewWriter(c.xtw)
+
+	c.log.Info("new connection",
+		slog.Any("remote", c.conn.RemoteAddr()),
+		slog.Any("local", c.conn.LocalAddr()),
+		slog.Bool("tls", xtls),
+		slog.String("listener", listenerName))
+
+	defer func() {
+		err := c.conn.Close()
+		if err != nil {
+			c.log.Debugx("closing connection", err)
+		}
+
+		if c.account != nil {
+			c.closeMaildrop()
+		}
+
+		x := recover()
+		if x == nil || x == cleanClose {
+			c.log.Info("connection closed")
+		} else if err, ok := x.(error); ok && isClosed(err) {
+			c.log.Infox("connection closed", err)
+		} else {
+			c.log.Error("unhandled panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.POP3)
+			unhandledPanics.Add(1) // For tests.
+		}
+	}()
+
+	if xtls {
+		// Start TLS on connection. We perform the handshake explicitly, so we can set a
+		// timeout and log TLS details afterwards.
+		c.xtlsHandshake(c.conn)
+	}
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwriteerr("SYS/TEMP", "mox shutting down")
+		return
+	default:
+	}
+
+	if !limiterConnectionrate.Add(c.remoteIP, time.Now(), 1) {
+		c.xwriteerr("SYS/TEMP", "connection rate from your ip or network too high, slow down please")
+		return
+	}
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+		metrics.AuthenticationRatelimitedInc("pop3")
+		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
+		c.xwriteerr("SYS/TEMP", "too many auth failures")
+		return
+	}
+
+	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+		c.xwriteerr("SYS/TEMP", "too many open connections from your ip or network")
+		return
+	}
+	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
+
+	// We register and unregister the original connection, in case it c.conn is
+	// replaced with a TLS connection later on.
+	mox.Connections.Register(nc, "pop3", listenerName)
+	defer mox.Connections.Unregister(nc)
+
+	c.ok("mox pop3 ready")
+
+	for {
+		c.command()
+		c.xflush() // For flushing errors, or commands that did not flush explicitly.
+	}
+}
+
+// isClosed returns whether i/o failed, typically because the connection is closed.
+// For connection errors, we often want to generate fewer logs.
+func isClosed(err error) bool {
+	return errors.Is(err, errIO) || errors.Is(err, errProtocol) || mlog.IsClosed(err)
+}
+
+// newLoginAttempt initializes a c.loginAttempt, for adding to the store after
+// filling in the results and other details.
+func (c *conn) newLoginAttempt(authMech string) {
+	var state *tls.ConnectionState
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		v := tc.ConnectionState()
+		state = &v
+	}
+
+	localAddr := c.conn.LocalAddr().String()
+	localIP, _, _ := net.SplitHostPort(localAddr)
+	if localIP == "" {
+		localIP = localAddr
+	}
+
+	c.loginAttempt = &store.LoginAttempt{
+		RemoteIP: c.remoteIP.String(),
+		LocalIP:  localIP,
+		TLS:      store.LoginAttemptTLS(state),
+		Protocol: "pop3",
+		AuthMech: authMech,
+		Result:   store.AuthError, // Replaced by caller.
+	}
+}
+
+// xtlsHandshake performs the TLS handshake on the connection.
+func (c *conn) xtlsHandshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, c.baseTLSConfig.Clone())
+	c.conn = tlsConn
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	c.log.Debug("starting tls server handshake")
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		c.xbrokenf("tls handshake: %s (%w)", err, errIO)
+	}
+	cancel()
+
+	cs := tlsConn.ConnectionState()
+	version, ciphersuite := moxio.TLSInfo(cs)
+	c.log.Debug("tls handshake completed",
+		slog.String("version", version),
+		slog.String("ciphersuite", ciphersuite),
+		slog.String("sni", cs.ServerName),
+		slog.Bool("resumed", cs.DidResume))
+}
+
+func (c *conn) command() {
+	var cmd string
+
+	defer func() {
+		var result string
+		defer func() {
+			metricCommands.WithLabelValues(cmd, result).Observe(float64(time.Since(c.cmdStart)) / float64(time.Second))
+		}()
+
+		logFields := []slog.Attr{
+			slog.String("cmd", c.cmd),
+			slog.Duration("duration", time.Since(c.cmdStart)),
+		}
+		c.cmd = ""
+
+		x := recover()
+		if x == nil || x == cleanClose {
+			c.log.Debug("pop3 command done", logFields...)
+			result = "ok"
+			if x == cleanClose {
+				panic(x)
+			}
+			return
+		}
+		err, ok := x.(error)
+		if !ok {
+			c.log.Error("pop3 command panic", append([]slog.Attr{slog.Any("panic", x)}, logFields...)...)
+			result = "panic"
+			panic(x)
+		}
+
+		if isClosed(err) {
+			c.log.Infox("pop3 command ioerror", err, logFields...)
+			result = "ioerror"
+			if errors.Is(err, errProtocol) {
+				debug.PrintStack()
+			}
+			panic(err)
+		}
+
+		var sxerr syntaxError
+		var uerr userError
+		var serr serverError
+		if errors.As(err, &sxerr) {
+			result = "badsyntax"
+			if c.ncmds == 0 {
+				// Other side is likely speaking something else than POP3, send error message and
+				// stop processing.
+				c.xwriteerr("", "please try again speaking pop3")
+				c.xbrokenf("not speaking pop3 (%w)", errIO)
+			}
+			c.log.Debugx("pop3 command syntax error", sxerr.err, logFields...)
+			c.xwriteerr("", "bad syntax: "+sxerr.errmsg)
+		} else if errors.As(err, &serr) {
+			result = "servererror"
+			c.log.Errorx("pop3 command server error", err, logFields...)
+			debug.PrintStack()
+			c.xwriteerr("SYS/TEMP", err.Error())
+		} else if errors.As(err, &uerr) {
+			result = "usererror"
+			c.log.Debugx("pop3 command user error", err, logFields...)
+			c.xwriteerr(uerr.code, err.Error())
+		} else {
+			// Other type of panic, we pass it on, aborting the connection.
+			result = "panic"
+			c.log.Errorx("pop3 command panic", err, logFields...)
+			panic(err)
+		}
+	}()
+
+	cmd = "(unrecognized)"
+	line := c.xreadline()
+	// USER changes the trace level for reading the PASS line that typically follows.
+	// Pipelined lines may have been read already.
+	c.tr.SetTrace(mlog.LevelTrace)
+	c.cmdStart = time.Now()
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwriteerr("SYS/TEMP", "shutting down")
+		c.xbrokenf("shutting down (%w)", errIO)
+	default:
+	}
+
+	// Commands are a keyword, optionally followed by arguments separated by a single
+	// space. ../rfc/1939
+	name, args, _ := strings.Cut(line, " ")
+	cmdlow := strings.ToLower(name)
+	fn := commands[cmdlow]
+	if fn == nil {
+		xsyntaxErrorf("unknown command %q", cmdlow)
+	}
+	cmd = cmdlow
+	c.cmd = cmdlow
+	c.ncmds++
+
+	// Check if command is allowed in this state.
+	if commandsStateAny[cmdlow] {
+	} else if commandsStateAuthorization[cmdlow] {
+		if c.account != nil {
+			xuserErrorf("not allowed after authentication")
+		}
+	} else if c.account == nil {
+		xuserErrorf("authentication required")
+	} else {
+		c.applyChanges()
+	}
+
+	fn(c, args)
+}
+
+// xargs parses space-separated arguments, and checks the number of arguments is
+// between min and max.
+func xargs(args string, min, max int) []string {
+	var l []string
+	if args != "" {
+		l = strings.Split(args, " ")
+	}
+	if len(l) < min || len(l) > max {
+		xsyntaxErrorf("expected between %d and %d arguments, got %d", min, max, len(l))
+	}
+	return l
+}
+
+// xparseNumber parses a number, as used for message numbers and line counts.
+func xparseNumber(s string) int64 {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		xsyntaxErrorf("invalid number %q", s)
+	}
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		xsyntaxErrorf("parsing number %q: %v", s, err)
+	}
+	return v
+}
+
+// saslMechanisms returns the SASL mechanisms available on the connection.
+//
+// Like IMAP, we always announce support for SCRAM PLUS-variants, also on
+// connections without TLS. Clients should not select PLUS variants without TLS,
+// but do the bare SCRAM variant without indicating the server claims to support
+// the PLUS variant.
+func (c *conn) saslMechanisms() []string {
+	var l []string
+	if c.tls || c.noRequireSTARTTLS {
+		l = append(l, "PLAIN")
+	}
+	return append(l, "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5")
+}
+
+// Capa returns the capabilities of the server, for the current state of the
+// connection. ../rfc/2449
+//
+// State: any
+func (c *conn) cmdCapa(args string) {
+	xargs(args, 0, 0)
+
+	c.xbwritelinef("+OK capability list follows")
+	c.xbwritelinef("TOP")
+	c.xbwritelinef("UIDL")
+	c.xbwritelinef("RESP-CODES")
+	c.xbwritelinef("AUTH-RESP-CODE") // ../rfc/3206
+	c.xbwritelinef("PIPELINING")
+	if c.account == nil {
+		if c.tls || c.noRequireSTARTTLS {
+			c.xbwritelinef("USER")
+		}
+		c.xbwritelinef("SASL %s", strings.Join(c.saslMechanisms(), " "))
+		if !c.tls && c.baseTLSConfig != nil {
+			c.xbwritelinef("STLS") // ../rfc/2595
+		}
+	}
+	c.xbwritelinef("IMPLEMENTATION mox %s", moxvar.Version)
+	c.xwritelinef(".")
+}
+
+// Quit ends the session. If authenticated, messages marked for deletion are
+// removed, and retrieved messages are moved if configured for the account.
+//
+// State: any
+func (c *conn) cmdQuit(args string) {
+	xargs(args, 0, 0)
+
+	if c.account == nil {
+		c.ok("bye")
+		panic(cleanClose)
+	}
+
+	// Update state. ../rfc/1939
+	c.applyChanges()
+	ndeleted, nmoved := c.xupdate()
+	// Release the maildrop before responding, a client may start a new session
+	// immediately.
+	c.closeMaildrop()
+	c.ok("bye, %d messages deleted, %d messages moved", ndeleted, nmoved)
+	panic(cleanClose)
+}
+
+// Stls enables TLS on the connection. ../rfc/2595
+//
+// State: authorization
+func (c *conn) cmdStls(args string) {
+	xargs(args, 0, 0)
+
+	if c.tls {
+		xuserErrorf("tls already active")
+	}
+	if c.baseTLSConfig == nil {
+		xuserErrorf("stls not announced")
+	}
+
+	conn := xprefixConn(c.conn, c.br)
+	// We add the cid to facilitate debugging in case of TLS connection failure.
+	c.ok("begin tls negotiation (%s)", mox.ReceivedID(c.cid))
+
+	c.xtlsHandshake(conn)
+	c.tls = true
+	c.user = ""
+}
+
+// User sets the username for the PASS command that follows. ../rfc/1939
+//
+// State: authorization
+func (c *conn) cmdUser(args string) {
+	l := xargs(args, 1, 1)
+
+	if !c.noRequireSTARTTLS && !c.tls {
+		xusercodeErrorf("AUTH", "tls required for login")
+	}
+	c.user = norm.NFC.String(l[0])
+	c.ok("send password")
+
+	// The password is sent in plain text in the next line. Don't log it at trace level.
+	c.tr.SetTrace(mlog.LevelTraceauth)
+}
+
+// Pass authenticates with the username from USER and a password. The password
+// is the remainder of the line, it can contain spaces. ../rfc/1939
+//
+// State: authorization
+func (c *conn) cmdPass(args string) {
+	if c.user == "" {
+		xuserErrorf("missing USER command")
+	}
+	if !c.noRequireSTARTTLS && !c.tls {
+		xusercodeErrorf("AUTH", "tls required for login")
+	}
+	username := c.user
+	c.user = ""
+
+	var missingDerivedSecrets bool
+	defer c.authBegin("user", &missingDerivedSecrets)()
+
+	c.loginAttempt.LoginAddress = username
+	account, accName, err := store.OpenEmailAuth(c.log, username, args, false)
+	c.loginAttempt.AccountName = accName
+	if err != nil {
+		if errors.Is(err, store.ErrUnknownCredentials) {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("authentication failed", slog.String("username", username))
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+		xusercodeErrorf("AUTH", "error")
+	}
+	c.xauthenticated(account, username)
+	c.ok("authenticated, %d messages", len(c.msgs))
+}
+
+// authBegin starts an authentication attempt, slowing down after many failures.
+// The returned function must be deferred, it records the login attempt and updates
+// the failed authentication counters.
+func (c *conn) authBegin(authMech string, missingDerivedSecrets *bool) func() {
+	// For many failed auth attempts, slow down verification attempts.
+	if c.authFailed > 3 && authFailDelay > 0 {
+		mox.Sleep(mox.Context, time.Duration(c.authFailed-3)*authFailDelay)
+	}
+	c.authFailed++ // Compensated on success.
+	c.newLoginAttempt(authMech)
+
+	return func() {
+		// If authentication fails due to missing derived secrets, we don't hold it against
+		// the connection. There is no way to indicate server support for an authentication
+		// mechanism, but that a mechanism won't work for an account.
+		if *missingDerivedSecrets {
+			c.authFailed--
+		}
+		// On the 3rd failed authentication, start responding slowly. Successful auth will
+		// cause fast responses again.
+		if c.authFailed >= 3 {
+			c.setSlow(true)
+		}
+
+		store.LoginAttemptAdd(context.Background(), c.logbg(), *c.loginAttempt)
+		if c.loginAttempt.Result == store.AuthSuccess {
+			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+		} else if !*missingDerivedSecrets {
+			mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+		}
+		c.loginAttempt = nil
+	}
+}
+
+// xauthenticated finishes a successful authentication by opening the maildrop.
+// The account is closed if the maildrop cannot be opened.
+func (c *conn) xauthenticated(account *store.Account, username string) {
+	defer func() {
+		if account != nil {
+			err := account.Close()
+			c.xsanity(err, "close account")
+		}
+	}()
+
+	if accConf, ok := account.Conf(); !ok {
+		xserverErrorf("cannot get account config")
+	} else if accConf.LoginDisabled != "" {
+		c.loginAttempt.Result = store.AuthLoginDisabled
+		c.log.Info("account login disabled", slog.String("username", username))
+		xusercodeErrorf("AUTH", "%s: %s", store.ErrLoginDisabled, accConf.LoginDisabled)
+	}
+
+	// Credentials are valid, a maildrop in use is not an authentication failure.
+	c.setSlow(false)
+	c.loginAttempt.AccountName = account.Name
+	c.loginAttempt.LoginAddress = username
+	c.loginAttempt.Result = store.AuthSuccess
+	c.authFailed = 0
+
+	c.xopenMaildrop(account)
+	c.account = account
+	account = nil // Prevent cleanup.
+	c.username = username
+}
+
+// xopenMaildrop locks the maildrop of the account, and reads the messages in the
+// Inbox.
+func (c *conn) xopenMaildrop(acc *store.Account) {
+	maildrops.Lock()
+	_, inUse := maildrops.accounts[acc.Name]
+	if !inUse {
+		maildrops.accounts[acc.Name] = struct{}{}
+	}
+	maildrops.Unlock()
+	if inUse {
+		xusercodeErrorf("IN-USE", "maildrop in use by another pop3 session") // ../rfc/2449
+	}
+
+	var opened bool
+	defer func() {
+		if opened {
+			return
+		}
+		if c.comm != nil {
+			c.comm.Unregister()
+			c.comm = nil
+		}
+		maildrops.Lock()
+		delete(maildrops.accounts, acc.Name)
+		maildrops.Unlock()
+	}()
+
+	// Register before reading the messages, so we don't miss removals.
+	c.comm = store.RegisterComm(acc)
+
+	c.msgs = nil
+	acc.WithRLock(func() {
+		err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, "Inbox")
+			if err != nil {
+				return err
+			} else if mb == nil {
+				return fmt.Errorf("inbox not found")
+			}
+			c.mailboxID = mb.ID
+			c.uidValidity = mb.UIDValidity
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID})
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("UID")
+			return q.ForEach(func(m store.Message) error {
+				c.msgs = append(c.msgs, message{ID: m.ID, UID: m.UID, Size: m.Size})
+				return nil
+			})
+		})
+		xcheckf(err, "reading messages in inbox")
+	})
+	opened = true
+}
+
+// closeMaildrop closes the account and releases the maildrop.
+func (c *conn) closeMaildrop() {
+	c.comm.Unregister()
+	c.comm = nil
+
+	maildrops.Lock()
+	delete(maildrops.accounts, c.account.Name)
+	maildrops.Unlock()
+
+	err := c.account.Close()
+	c.xsanity(err, "close account")
+	c.account = nil
+}
+
+// applyChanges processes changes from other sessions, marking messages removed
+// from the Inbox as removed in the maildrop.
+func (c *conn) applyChanges() {
+	overflow, changes := c.comm.Get()
+	if overflow {
+		// We check if messages are still present before reading and updating them.
+		c.log.Info("too many pending changes from other sessions, ignored")
+	}
+	for _, ch := range changes {
+		rem, ok := ch.(store.ChangeRemoveUIDs)
+		if !ok {
+			continue
+		}
+		if rem.MailboxID == c.mailboxID {
+			for _, uid := range rem.UIDs {
+				i, ok := slices.BinarySearchFunc(c.msgs, uid, func(m message, uid store.UID) int { return int(m.UID) - int(uid) })
+				if ok {
+					c.msgs[i].removed = true
+				}
+			}
+		}
+		c.comm.RemovalSeen(rem)
+	}
+}
+
+// xmsg returns the message for a message number, which must not be deleted.
+func (c *conn) xmsg(s string) *message {
+	num := xparseNumber(s)
+	if num <= 0 || num > int64(len(c.msgs)) {
+		xuserErrorf("no such message")
+	}
+	m := &c.msgs[num-1]
+	if m.deleted {
+		xuserErrorf("message deleted")
+	} else if m.removed {
+		xuserErrorf("message removed by another session")
+	}
+	return m
+}
+
+// xuniqueID returns the unique-id for a message, for UIDL.
+func (c *conn) xuniqueID(m message) string {
+	return fmt.Sprintf("%d.%d", c.uidValidity, m.UID)
+}
+
+// Stat returns the number and total size of messages.
+//
+// State: transaction
+func (c *conn) cmdStat(args string) {
+	xargs(args, 0, 0)
+
+	var n int
+	var size int64
+	for _, m := range c.msgs {
+		if !m.deleted && !m.removed {
+			n++
+			size += m.Size
+		}
+	}
+	c.ok("%d %d", n, size)
+}
+
+// List returns the size of a message, or of all messages.
+//
+// State: transaction
+func (c *conn) cmdList(args string) {
+	l := xargs(args, 0, 1)
+
+	if len(l) == 1 {
+		m := c.xmsg(l[0])
+		c.ok("%s %d", l[0], m.Size)
+		return
+	}
+
+	c.xbwritelinef("+OK scan listing follows")
+	for i, m := range c.msgs {
+		if !m.deleted && !m.removed {
+			c.xbwritelinef("%d %d", i+1, m.Size)
+		}
+	}
+	c.xwritelinef(".")
+}
+
+// Uidl returns the unique-id of a message, or of all messages.
+//
+// State: transaction
+func (c *conn) cmdUidl(args string) {
+	l := xargs(args, 0, 1)
+
+	if len(l) == 1 {
+		m := c.xmsg(l[0])
+		c.ok("%s %s", l[0], c.xuniqueID(*m))
+		return
+	}
+
+	c.xbwritelinef("+OK unique-id listing follows")
+	for i, m := range c.msgs {
+		if !m.deleted && !m.removed {
+			c.xbwritelinef("%d %s", i+1, c.xuniqueID(m))
+		}
+	}
+	c.xwritelinef(".")
+}
+
+// Retr returns a message.
+//
+// State: transaction
+func (c *conn) cmdRetr(args string) {
+	l := xargs(args, 1, 1)
+
+	m := c.xmsg(l[0])
+	mr := c.xopenMessage(m)
+	defer func() {
+		err := mr.Close()
+		c.xsanity(err, "closing message reader")
+	}()
+
+	c.xbwritelinef("+OK %d octets", m.Size)
+	c.xwriteMessage(mr, -1)
+	m.retrieved = true
+}
+
+// Top returns the header of a message and the first lines of its body.
+//
+// State: transaction
+func (c *conn) cmdTop(args string) {
+	l := xargs(args, 2, 2)
+
+	m := c.xmsg(l[0])
+	lines := xparseNumber(l[1])
+	mr := c.xopenMessage(m)
+	defer func() {
+		err := mr.Close()
+		c.xsanity(err, "closing message reader")
+	}()
+
+	c.xbwritelinef("+OK top of message follows")
+	c.xwriteMessage(mr, lines)
+}
+
+// xopenMessage opens the message file, checking the message is still in the
+// Inbox.
+func (c *conn) xopenMessage(m *message) *store.MsgReader {
+	var mr *store.MsgReader
+	c.account.WithRLock(func() {
+		err := c.account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			xm := store.Message{ID: m.ID}
+			if err := tx.Get(&xm); err != nil {
+				return fmt.Errorf("get message: %w", err)
+			}
+			if xm.Expunged || xm.MailboxID != c.mailboxID || xm.UID != m.UID {
+				m.removed = true
+				xuserErrorf("message removed by another session")
+			}
+			// We open the file while holding the lock, the file may be erased when the
+			// message is removed by another session.
+			f, err := os.Open(c.account.MessagePath(xm.ID))
+			if err != nil {
+				return fmt.Errorf("open message file: %w", err)
+			}
+			mr = store.FileMsgReader(xm.MsgPrefix, f)
+			return nil
+		})
+		xcheckf(err, "reading message")
+	})
+	return mr
+}
+
+// xwriteMessage writes a message as multi-line response, with dot-stuffing and
+// the terminating line. If bodyLines is not negative, only the header and the
+// first bodyLines lines of the body are written. ../rfc/1939
+func (c *conn) xwriteMessage(r io.Reader, bodyLines int64) {
+	defer c.xtracewrite(mlog.LevelTracedata)()
+
+	br := bufio.NewReader(r)
+	header := true
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			s := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if header {
+				header = s != ""
+			} else if bodyLines >= 0 {
+				if bodyLines == 0 {
+					break
+				}
+				bodyLines--
+			}
+			if strings.HasPrefix(s, ".") {
+				s = "." + s
+			}
+			c.xbwritelinef("%s", s)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// We already started writing the response, we cannot send an error response.
+			c.xbrokenf("reading message: %v (%w)", err, errIO)
+		}
+	}
+	c.xbwritelinef(".")
+}
+
+// Dele marks a message for deletion. Messages are removed when the session
+// ends with QUIT.
+//
+// State: transaction
+func (c *conn) cmdDele(args string) {
+	l := xargs(args, 1, 1)
+
+	m := c.xmsg(l[0])
+	m.deleted = true
+	c.ok("message %s deleted", l[0])
+}
+
+// Rset unmarks all messages marked for deletion.
+//
+// State: transaction
+func (c *conn) cmdRset(args string) {
+	xargs(args, 0, 0)
+
+	var n int
+	for i := range c.msgs {
+		c.msgs[i].deleted = false
+		if !c.msgs[i].removed {
+			n++
+		}
+	}
+	c.ok("%d messages", n)
+}
+
+// Noop does nothing.
+//
+// State: transaction
+func (c *conn) cmdNoop(args string) {
+	xargs(args, 0, 0)
+	c.ok("")
+}
+
+var xops = webops.XOps{
+	DBWrite: func(ctx context.Context, acc *store.Account, fn func(tx *bstore.Tx)) {
+		err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+			fn(tx)
+			return nil
+		})
+		xcheckf(err, "transaction")
+	},
+	Checkf: func(ctx context.Context, err error, format string, args ...any) {
+		xcheckf(err, format, args...)
+	},
+	Checkuserf: func(ctx context.Context, err error, format string, args ...any) {
+		if err != nil {
+			xuserErrorf("%s: %s", fmt.Sprintf(format, args...), err)
+		}
+	},
+}
+
+// xupdate removes messages marked for deletion, and moves retrieved messages to
+// the POP3RetrievedMailbox of the account if configured. Messages that were
+// removed or moved by other sessions are skipped.
+func (c *conn) xupdate() (ndeleted, nmoved int) {
+	accConf, _ := c.account.Conf()
+
+	var deleteIDs, moveIDs []int64
+	for _, m := range c.msgs {
+		if m.removed {
+			continue
+		} else if m.deleted {
+			deleteIDs = append(deleteIDs, m.ID)
+		} else if m.retrieved && accConf.POP3RetrievedMailbox != "" {
+			moveIDs = append(moveIDs, m.ID)
+		}
+	}
+	if len(deleteIDs) == 0 && len(moveIDs) == 0 {
+		return 0, 0
+	}
+
+	ctx := context.TODO()
+	c.account.WithWLock(func() {
+		var changes []store.Change
+
+		var newIDs []int64
+		defer func() {
+			for _, id := range newIDs {
+				p := c.account.MessagePath(id)
+				err := os.Remove(p)
+				c.log.Check(err, "removing message file after failure", slog.String("path", p))
+			}
+		}()
+
+		xops.DBWrite(ctx, c.account, func(tx *bstore.Tx) {
+			// Skip messages that were removed or moved since we processed changes.
+			xpresent := func(ids []int64) []int64 {
+				var l []int64
+				for _, id := range ids {
+					m := store.Message{ID: id}
+					err := tx.Get(&m)
+					xcheckf(err, "get message")
+					if !m.Expunged && m.MailboxID == c.mailboxID {
+						l = append(l, id)
+					}
+				}
+				return l
+			}
+			deleteIDs = xpresent(deleteIDs)
+			moveIDs = xpresent(moveIDs)
+
+			var modseq store.ModSeq
+			if len(deleteIDs) > 0 {
+				changes = append(changes, xops.MessageDeleteTx(ctx, c.log, tx, c.account, deleteIDs, &modseq)...)
+			}
+			if len(moveIDs) > 0 {
+				mb, chl, err := c.account.MailboxEnsure(tx, accConf.POP3RetrievedMailbox, true, store.SpecialUse{}, &modseq)
+				xcheckf(err, "ensuring mailbox for retrieved messages")
+				changes = append(changes, chl...)
+
+				var l []store.Change
+				newIDs, l = xops.MessageMoveTx(ctx, c.log, c.account, tx, moveIDs, mb, &modseq)
+				changes = append(changes, l...)
+			}
+		})
+		newIDs = nil
+
+		c.comm.Broadcast(changes)
+	})
+	return len(deleteIDs), len(moveIDs)
+}
+
+// Auth authenticates using SASL. Supports multiple back and forths between
+// client and server to finish authentication. Without mechanism, the supported
+// mechanisms are listed, as many servers do. ../rfc/5034
+//
+// State: authorization
+func (c *conn) cmdAuth(args string) {
+	l := xargs(args, 0, 2)
+	if len(l) == 0 {
+		c.xbwritelinef("+OK")
+		for _, mech := range c.saslMechanisms() {
+			c.xbwritelinef("%s", mech)
+		}
+		c.xwritelinef(".")
+		return
+	}
+	authType := strings.ToUpper(l[0])
+
+	var missingDerivedSecrets bool
+	defer c.authBegin("", &missingDerivedSecrets)()
+
+	xdecode := func(s string) []byte {
+		if s == "*" {
+			c.loginAttempt.Result = store.AuthAborted
+			xuserErrorf("authentication aborted by client")
+		}
+		buf, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			xsyntaxErrorf("parsing base64: %v", err)
+		}
+		return buf
+	}
+
+	// xchallenge writes a server challenge and reads the client response.
+	xchallenge := func(buf []byte) []byte {
+		c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString(buf))
+		return xdecode(c.xreadline())
+	}
+
+	xreadInitial := func() []byte {
+		if len(l) == 1 {
+			// Empty server challenge, client responds with the initial response.
+			return xchallenge(nil)
+		} else if l[1] == "=" {
+			// Empty initial response. ../rfc/5034
+			return nil
+		}
+		return xdecode(l[1])
+	}
+
+	var account *store.Account
+	var username string
+	defer func() {
+		if account != nil {
+			err := account.Close()
+			c.xsanity(err, "close account")
+		}
+	}()
+
+	switch authType {
+	case "PLAIN":
+		c.loginAttempt.AuthMech = "plain"
+
+		if !c.noRequireSTARTTLS && !c.tls {
+			xusercodeErrorf("AUTH", "tls required for login")
+		}
+
+		// Plain text passwords, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		plain := bytes.Split(buf, []byte{0})
+		if len(plain) != 3 {
+			xsyntaxErrorf("bad plain auth data, expected 3 nul-separated tokens, got %d tokens", len(plain))
+		}
+		authz := norm.NFC.String(string(plain[0]))
+		username = norm.NFC.String(string(plain[1]))
+		password := string(plain[2])
+		c.loginAttempt.LoginAddress = username
+
+		if authz != "" && authz != username {
+			xusercodeErrorf("AUTH", "cannot assume role")
+		}
+
+		var err error
+		account, c.loginAttempt.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("authentication failed", slog.String("username", username))
+				xusercodeErrorf("AUTH", "bad credentials")
+			}
+			xusercodeErrorf("AUTH", "error")
+		}
+
+	case "CRAM-MD5":
+		c.loginAttempt.AuthMech = "cram-md5"
+
+		if len(l) != 1 {
+			xsyntaxErrorf("unexpected initial response for cram-md5")
+		}
+
+		// ../rfc/2195:82
+		chal := fmt.Sprintf("<%d.%d@%s>", uint64(mox.CryptoRandInt()), time.Now().UnixNano(), mox.Conf.Static.HostnameDomain.ASCII)
+		resp := xchallenge([]byte(chal))
+		t := strings.Split(string(resp), " ")
+		if len(t) != 2 || len(t[1]) != 2*md5.Size {
+			xsyntaxErrorf("malformed cram-md5 response")
+		}
+		username = norm.NFC.String(t[0])
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("cram-md5 auth", slog.String("address", username))
+		var err error
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "bad credentials")
+			}
+			xserverErrorf("looking up address: %v", err)
+		}
+		var ipadhash, opadhash hash.Hash
+		account.WithRLock(func() {
+			err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+				password, err := bstore.QueryTx[store.Password](tx).Get()
+				if err == bstore.ErrAbsent {
+					c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+					xusercodeErrorf("AUTH", "bad credentials")
+				}
+				if err != nil {
+					return err
+				}
+
+				ipadhash = password.CRAMMD5.Ipad
+				opadhash = password.CRAMMD5.Opad
+				return nil
+			})
+			xcheckf(err, "tx read")
+		})
+		if ipadhash == nil || opadhash == nil {
+			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			missingDerivedSecrets = true
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+
+		// ../rfc/2195:138 ../rfc/2104:142
+		ipadhash.Write([]byte(chal))
+		opadhash.Write(ipadhash.Sum(nil))
+		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
+		if digest != t[1] {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+
+	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+		// No plaintext credentials, we can log these normally.
+
+		c.loginAttempt.AuthMech = strings.ToLower(authType)
+		var h func() hash.Hash
+		switch c.loginAttempt.AuthMech {
+		case "scram-sha-1", "scram-sha-1-plus":
+			h = sha1.New
+		case "scram-sha-256", "scram-sha-256-plus":
+			h = sha256.New
+		default:
+			xserverErrorf("missing case for scram variant")
+		}
+
+		var cs *tls.ConnectionState
+		requireChannelBinding := strings.HasSuffix(c.loginAttempt.AuthMech, "-plus")
+		if requireChannelBinding && !c.tls {
+			xuserErrorf("cannot use plus variant with tls channel binding without tls")
+		}
+		if c.tls {
+			xcs := c.conn.(*tls.Conn).ConnectionState()
+			cs = &xcs
+		}
+		c0 := xreadInitial()
+		ss, err := scram.NewServer(h, c0, cs, requireChannelBinding)
+		if err != nil {
+			c.log.Infox("scram protocol error", err, slog.Any("remote", c.remoteIP))
+			xuserErrorf("scram protocol error: %s", err)
+		}
+		username = ss.Authentication
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("scram auth", slog.String("authentication", username))
+		// We check for login being disabled when finishing.
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			xusercodeErrorf("AUTH", "scram not possible")
+		}
+		if ss.Authorization != "" && ss.Authorization != username {
+			xusercodeErrorf("AUTH", "authentication with authorization for different user not supported")
+		}
+		var xscram store.SCRAM
+		account.WithRLock(func() {
+			err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+				password, err := bstore.QueryTx[store.Password](tx).Get()
+				if err == bstore.ErrAbsent {
+					c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+					xusercodeErrorf("AUTH", "bad credentials")
+				}
+				xcheckf(err, "fetching credentials")
+				switch c.loginAttempt.AuthMech {
+				case "scram-sha-1", "scram-sha-1-plus":
+					xscram = password.SCRAMSHA1
+				case "scram-sha-256", "scram-sha-256-plus":
+					xscram = password.SCRAMSHA256
+				default:
+					xserverErrorf("missing case for scram credentials")
+				}
+				if len(xscram.Salt) == 0 || xscram.Iterations == 0 || len(xscram.SaltedPassword) == 0 {
+					missingDerivedSecrets = true
+					c.log.Info("scram auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+					xusercodeErrorf("AUTH", "scram not possible")
+				}
+				return nil
+			})
+			xcheckf(err, "read tx")
+		})
+		s1, err := ss.ServerFirst(xscram.Iterations, xscram.Salt)
+		xcheckf(err, "scram first server step")
+		c2 := xchallenge([]byte(s1))
+		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		if err != nil {
+			if errors.Is(err, scram.ErrInvalidProof) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "bad credentials")
+			} else if errors.Is(err, scram.ErrChannelBindingsDontMatch) {
+				c.loginAttempt.Result = store.AuthBadChannelBinding
+				c.log.Warn("bad channel binding during authentication, potential mitm", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "channel bindings do not match, potential mitm")
+			} else if errors.Is(err, scram.ErrInvalidEncoding) {
+				c.loginAttempt.Result = store.AuthBadProtocol
+				c.log.Infox("bad scram protocol message", err, slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad scram protocol message: %s", err)
+			}
+			xuserErrorf("server final: %w", err)
+		}
+		// The final server message is sent as challenge, the client responds with an empty
+		// line. ../rfc/5034
+		if resp := xchallenge([]byte(s3)); len(resp) != 0 {
+			xsyntaxErrorf("unexpected response to scram server final")
+		}
+
+	default:
+		c.loginAttempt.AuthMech = "(unrecognized)"
+		xuserErrorf("mechanism not supported")
+	}
+
+	acc := account
+	account = nil // Closed by xauthenticated on failure.
+	c.xauthenticated(acc, username)
+	c.ok("authenticated, %d messages", len(c.msgs))
+}
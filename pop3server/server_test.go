@@ -0,0 +1,457 @@
+package pop3server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sasl"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("pop3server", nil)
+
+const password = "testtest"
+
+func init() {
+	sanityChecks = true
+
+	// Don't slow down tests.
+	badClientDelay = 0
+	authFailDelay = 0
+
+	mox.Context = ctxbg
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+type testconn struct {
+	t          *testing.T
+	conn       net.Conn
+	br         *bufio.Reader
+	serverConn net.Conn
+	done       chan struct{}
+}
+
+func (tc *testconn) close() {
+	tc.conn.Close()
+	tc.serverConn.Close()
+	<-tc.done
+	if n := unhandledPanics.Swap(0); n > 0 {
+		tc.t.Fatalf("handled %d panics", n)
+	}
+}
+
+func (tc *testconn) readline() string {
+	tc.t.Helper()
+	line, err := tc.br.ReadString('\n')
+	tcheck(tc.t, err, "read line")
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func (tc *testconn) writelinef(format string, args ...any) {
+	tc.t.Helper()
+	_, err := fmt.Fprintf(tc.conn, format+"\r\n", args...)
+	tcheck(tc.t, err, "write")
+}
+
+// transactf writes a command and checks the response line starts with expResp.
+func (tc *testconn) transactf(expResp, format string, args ...any) string {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	line := tc.readline()
+	if !strings.HasPrefix(line, expResp) {
+		tc.t.Fatalf("got response %q, expected %q", line, expResp)
+	}
+	return line
+}
+
+// transactMultif writes a command and reads the lines of a multi-line response,
+// without dot-unstuffing.
+func (tc *testconn) transactMultif(format string, args ...any) []string {
+	tc.t.Helper()
+	tc.transactf("+OK", format, args...)
+	var l []string
+	for {
+		line := tc.readline()
+		if line == "." {
+			return l
+		}
+		l = append(l, line)
+	}
+}
+
+func (tc *testconn) tcompare(got, exp []string) {
+	tc.t.Helper()
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		tc.t.Fatalf("got %q, expected %q", got, exp)
+	}
+}
+
+// authenticate runs a SASL exchange, with an initial response if the mechanism
+// has one.
+func (tc *testconn) authenticate(client sasl.Client, expResp string) {
+	tc.t.Helper()
+	name, _ := client.Info()
+	toServer, _, err := client.Next(nil)
+	tcheck(tc.t, err, "sasl initial response")
+	if toServer == nil {
+		tc.writelinef("AUTH %s", name)
+	} else if len(toServer) == 0 {
+		tc.writelinef("AUTH %s =", name)
+	} else {
+		tc.writelinef("AUTH %s %s", name, base64.StdEncoding.EncodeToString(toServer))
+	}
+	for {
+		line := tc.readline()
+		if strings.HasPrefix(line, "+OK") || strings.HasPrefix(line, "-ERR") {
+			if !strings.HasPrefix(line, expResp) {
+				tc.t.Fatalf("got response %q, expected %q", line, expResp)
+			}
+			return
+		}
+		fromServer, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "+ "))
+		tcheck(tc.t, err, "decode sasl challenge")
+		toServer, _, err := client.Next(fromServer)
+		if err != nil {
+			tc.writelinef("*")
+			continue
+		}
+		tc.writelinef("%s", base64.StdEncoding.EncodeToString(toServer))
+	}
+}
+
+func (tc *testconn) login(username string) {
+	tc.t.Helper()
+	tc.transactf("+OK", "USER %s", username)
+	tc.transactf("+OK", "PASS %s", password)
+}
+
+var connCounter int64
+
+func tsetup(t *testing.T) {
+	limitersInit() // Reset rate limiters.
+
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/pop3/mox.conf")
+	mox.MustLoadConfig(true, false)
+	store.Close() // May not be open, we ignore error.
+	os.RemoveAll("../testdata/pop3/data")
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	switchStop := store.Switchboard()
+	t.Cleanup(func() {
+		switchStop()
+		err := store.Close()
+		tcheck(t, err, "store close")
+	})
+
+	for _, name := range []string{"mjl", "other"} {
+		acc, err := store.OpenAccount(pkglog, name, false)
+		tcheck(t, err, "open account")
+		err = acc.SetPassword(pkglog, password)
+		tcheck(t, err, "set password")
+		err = acc.Close()
+		tcheck(t, err, "close account")
+		acc.WaitClosed()
+	}
+}
+
+func start(t *testing.T, noRequireSTARTTLS bool) *testconn {
+	serverConn, clientConn := net.Pipe()
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{fakeCert(t)},
+	}
+
+	done := make(chan struct{})
+	connCounter++
+	go func() {
+		serve("test", connCounter, tlsConfig, serverConn, false, noRequireSTARTTLS)
+		close(done)
+	}()
+	tc := &testconn{t: t, conn: clientConn, br: bufio.NewReader(clientConn), serverConn: serverConn, done: done}
+	line := tc.readline()
+	if !strings.HasPrefix(line, "+OK") {
+		t.Fatalf("got greeting %q, expected +OK", line)
+	}
+	return tc
+}
+
+func fakeCert(t *testing.T) tls.Certificate {
+	seed := make([]byte, ed25519.SeedSize)
+	privKey := ed25519.NewKeyFromSeed(seed) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	localCertBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "making certificate")
+	cert, err := x509.ParseCertificate(localCertBuf)
+	tcheck(t, err, "parsing generated certificate")
+	return tls.Certificate{
+		Certificate: [][]byte{localCertBuf},
+		PrivateKey:  privKey,
+		Leaf:        cert,
+	}
+}
+
+// tdeliver adds messages to the Inbox of the account.
+func tdeliver(t *testing.T, accName string, msgs ...string) {
+	t.Helper()
+	acc, err := store.OpenAccount(pkglog, accName, false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+	}()
+
+	for _, msg := range msgs {
+		mf, err := store.CreateMessageTemp(pkglog, "pop3test")
+		tcheck(t, err, "temp message")
+		_, err = mf.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		acc.WithWLock(func() {
+			m := store.Message{Received: time.Now(), Size: int64(len(msg))}
+			err = acc.DeliverMailbox(pkglog, "Inbox", &m, mf)
+			tcheck(t, err, "deliver message")
+		})
+		os.Remove(mf.Name())
+		mf.Close()
+	}
+}
+
+// tmailboxCount returns the number of messages in a mailbox of the account.
+func tmailboxCount(t *testing.T, accName, mailbox string) int {
+	t.Helper()
+	acc, err := store.OpenAccount(pkglog, accName, false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+	}()
+
+	var n int
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		mb, err := acc.MailboxFind(tx, mailbox)
+		if err != nil || mb == nil {
+			return err
+		}
+		n = int(mb.Total + mb.Deleted)
+		return nil
+	})
+	tcheck(t, err, "read mailbox")
+	return n
+}
+
+func TestAuthenticate(t *testing.T) {
+	tsetup(t)
+	tc := start(t, false)
+	defer tc.close()
+
+	capa := tc.transactMultif("CAPA")
+	tc.tcompare(capa[5:7], []string{"SASL SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5", "STLS"})
+
+	// Commands that require authentication.
+	tc.transactf("-ERR", "STAT")
+	tc.transactf("-ERR", "LIST")
+
+	// Plain text passwords require TLS.
+	tc.transactf("-ERR [AUTH]", "USER mjl@mox.example")
+	tc.transactf("-ERR", "PASS %s", password)
+	tc.authenticate(sasl.NewClientPlain("mjl@mox.example", password), "-ERR [AUTH]")
+
+	tc.authenticate(sasl.NewClientSCRAMSHA256("mjl@mox.example", "badpass", false), "-ERR [AUTH]")
+	tc.authenticate(sasl.NewClientCRAMMD5("mjl@mox.example", "badpass"), "-ERR [AUTH]")
+	tc.authenticate(sasl.NewClientSCRAMSHA1("mjl@mox.example", password, false), "+OK")
+
+	// Not allowed after authentication.
+	tc.transactf("-ERR", "USER mjl@mox.example")
+	capa = tc.transactMultif("CAPA")
+	tc.tcompare(capa[:5], []string{"TOP", "UIDL", "RESP-CODES", "AUTH-RESP-CODE", "PIPELINING"})
+	if len(capa) != 6 {
+		t.Fatalf("got capabilities %q, expected no authentication capabilities after authentication", capa)
+	}
+
+	tc.transactf("+OK", "QUIT")
+
+	tc = start(t, false)
+	defer tc.close()
+	tc.authenticate(sasl.NewClientCRAMMD5("mjl@mox.example", password), "+OK")
+	tc.transactf("+OK", "QUIT")
+
+	tc = start(t, true)
+	defer tc.close()
+	tc.tcompare(tc.transactMultif("AUTH"), []string{"PLAIN", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5"})
+	tc.authenticate(sasl.NewClientPlain("disabled@mox.example", password), "-ERR [AUTH]")
+	tc.authenticate(sasl.NewClientPlain("mjl@mox.example", password), "+OK")
+	tc.transactf("+OK", "QUIT")
+}
+
+func TestStls(t *testing.T) {
+	tsetup(t)
+	tc := start(t, false)
+	defer tc.close()
+
+	tc.transactf("+OK", "STLS")
+	tlsConn := tls.Client(tc.conn, &tls.Config{InsecureSkipVerify: true})
+	tc.conn = tlsConn
+	tc.br = bufio.NewReader(tlsConn)
+
+	capa := tc.transactMultif("CAPA")
+	tc.tcompare(capa[5:7], []string{"USER", "SASL PLAIN SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5"})
+	tc.transactf("-ERR", "STLS")
+	tc.authenticate(sasl.NewClientSCRAMSHA256PLUS("mjl@mox.example", password, tlsConn.ConnectionState()), "+OK")
+	tc.transactf("+OK", "QUIT")
+}
+
+func TestTransaction(t *testing.T) {
+	tsetup(t)
+
+	const msg0 = "Subject: first\r\n\r\nline 1\r\nline 2\r\n"
+	const msg1 = "Subject: second\r\nX-Test: x\r\n\r\n.dot\r\nline 2\r\nline 3\r\n"
+	const msg2 = "Subject: third\n\nbare newlines\n"
+	tdeliver(t, "mjl", msg0, msg1, msg2)
+
+	tc := start(t, true)
+	defer tc.close()
+	tc.login("mjl@mox.example")
+
+	// Only a single session can have the maildrop open.
+	tc2 := start(t, true)
+	defer tc2.close()
+	tc2.transactf("+OK", "USER mjl@mox.example")
+	tc2.transactf("-ERR [IN-USE]", "PASS %s", password)
+
+	tc.transactf(fmt.Sprintf("+OK 3 %d", len(msg0)+len(msg1)+len(msg2)), "STAT")
+	tc.tcompare(tc.transactMultif("LIST"), []string{
+		fmt.Sprintf("1 %d", len(msg0)),
+		fmt.Sprintf("2 %d", len(msg1)),
+		fmt.Sprintf("3 %d", len(msg2)),
+	})
+	tc.transactf(fmt.Sprintf("+OK 2 %d", len(msg1)), "LIST 2")
+	tc.transactf("-ERR", "LIST 4")
+	tc.transactf("-ERR", "LIST 0")
+	tc.transactf("-ERR", "LIST x")
+
+	uidl := tc.transactMultif("UIDL")
+	if len(uidl) != 3 || !strings.HasPrefix(uidl[0], "1 ") {
+		t.Fatalf("got uidl %q", uidl)
+	}
+	tc.transactf("+OK "+uidl[1], "UIDL 2")
+
+	tc.tcompare(tc.transactMultif("RETR 2"), []string{"Subject: second", "X-Test: x", "", "..dot", "line 2", "line 3"})
+	tc.tcompare(tc.transactMultif("TOP 2 1"), []string{"Subject: second", "X-Test: x", "", "..dot"})
+	tc.tcompare(tc.transactMultif("TOP 2 0"), []string{"Subject: second", "X-Test: x", ""})
+	tc.tcompare(tc.transactMultif("RETR 3"), []string{"Subject: third", "", "bare newlines"})
+
+	tc.transactf("+OK", "DELE 1")
+	tc.transactf("-ERR", "DELE 1")
+	tc.transactf("-ERR", "RETR 1")
+	tc.transactf("+OK 2 ", "STAT")
+	tc.transactf("+OK 3 messages", "RSET")
+	tc.transactf("+OK 3 ", "STAT")
+	tc.transactf("+OK", "DELE 1")
+	tc.transactf("+OK", "DELE 3")
+	tc.transactf("+OK", "NOOP")
+	tc.transactf("+OK bye, 2 messages deleted, 0 messages moved", "QUIT")
+
+	// Messages are deleted, unique-id of remaining message stays the same.
+	tc = start(t, true)
+	defer tc.close()
+	tc.login("mjl@mox.example")
+	tc.transactf("+OK 1 ", "STAT")
+	tc.transactf("+OK 1 "+strings.SplitN(uidl[1], " ", 2)[1], "UIDL 1")
+	tc.transactf("+OK", "DELE 1")
+
+	// Without QUIT, nothing is deleted.
+	tc.close()
+	tc = start(t, true)
+	defer tc.close()
+	tc.login("mjl@mox.example")
+	tc.transactf("+OK 1 ", "STAT")
+	tc.transactf("+OK", "QUIT")
+}
+
+func TestRetrievedMailbox(t *testing.T) {
+	tsetup(t)
+
+	const msg = "Subject: test\r\n\r\ntest\r\n"
+	tdeliver(t, "other", msg, msg, msg)
+
+	tc := start(t, true)
+	defer tc.close()
+	tc.login("other@mox.example")
+	tc.transactMultif("RETR 1")
+	tc.transactMultif("RETR 2")
+	tc.transactMultif("TOP 3 0")
+	tc.transactf("+OK", "DELE 2")
+	tc.transactf("+OK bye, 1 messages deleted, 1 messages moved", "QUIT")
+
+	if n := tmailboxCount(t, "other", "Inbox"); n != 1 {
+		t.Fatalf("got %d messages in inbox, expected 1", n)
+	}
+	if n := tmailboxCount(t, "other", "Retrieved"); n != 1 {
+		t.Fatalf("got %d messages in retrieved mailbox, expected 1", n)
+	}
+}
+
+func TestRemovedBySession(t *testing.T) {
+	tsetup(t)
+
+	const msg = "Subject: test\r\n\r\ntest\r\n"
+	tdeliver(t, "mjl", msg, msg)
+
+	tc := start(t, true)
+	defer tc.close()
+	tc.login("mjl@mox.example")
+
+	// Remove first message in another session.
+	acc, err := store.OpenAccount(pkglog, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+	}()
+	var id int64
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		m, err := bstore.QueryTx[store.Message](tx).FilterEqual("Expunged", false).SortAsc("UID").Limit(1).Get()
+		id = m.ID
+		return err
+	})
+	tcheck(t, err, "get message")
+	xops.MessageDelete(ctxbg, pkglog, acc, []int64{id})
+
+	tc.transactf("-ERR", "RETR 1")
+	tc.tcompare(tc.transactMultif("LIST"), []string{fmt.Sprintf("2 %d", len(msg))})
+	tc.transactf("+OK 1 ", "STAT")
+	tc.transactMultif("RETR 2")
+	tc.transactf("+OK", "DELE 2")
+	tc.transactf("+OK bye, 1 messages deleted", "QUIT")
+
+	if n := tmailboxCount(t, "mjl", "Inbox"); n != 0 {
+		t.Fatalf("got %d messages in inbox, expected 0", n)
+	}
+}
@@ -306,6 +306,9 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			mqlog.Info("delivered from queue")
 			mr.msg.markResult(mr.resp.Code, mr.resp.Secode, "", true)
 			delMsgs[i] = *mr.msg
+			if !result.remoteDSN {
+				deliverDSNSuccess(mqlog, *mr.msg, remoteMTA)
+			}
 		}
 		if len(delMsgs) > 0 {
 			err := DB.Write(context.Background(), func(tx *bstore.Tx) error {
@@ -376,6 +379,10 @@ type deliverResult struct {
 	delivered []*msgResp
 	failed    []*msgResp
 	err       error
+
+	// Whether the remote server supports the DSN extension, and is responsible for
+	// sending requested DSNs for successful deliveries.
+	remoteDSN bool
 }
 
 // deliverHost attempts to deliver msgs to host. All msgs must have the same
@@ -726,8 +733,10 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		}
 
 		rcpts := make([]string, n)
+		msgs := make([]*Msg, n)
 		for i, mr := range todo[:n] {
 			rcpts[i] = mr.msg.Recipient().XString(m0.SMTPUTF8)
+			msgs[i] = mr.msg
 		}
 
 		// Only require that remote announces 8bitmime extension when in pedantic mode. All
@@ -736,7 +745,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		// 7-bit-only, but the trouble likely isn't worth it.
 		req8bit := has8bit && mox.Pedantic
 
-		resps, err := sc.DeliverMultiple(ctx, mailFrom, rcpts, size, msg, req8bit, smtputf8, m0.RequireTLS != nil && *m0.RequireTLS)
+		resps, err := sc.DeliverMultipleDSN(ctx, mailFrom, rcpts, size, msg, req8bit, smtputf8, m0.RequireTLS != nil && *m0.RequireTLS, dsnOpts(msgs))
 		if err != nil && (len(resps) == 0 && n == len(msgResps) || len(resps) == len(msgResps)) {
 			// If error and it applies to all recipients, return a single error.
 			return deliverResult{err: inspectError(err)}
@@ -768,7 +777,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		// implement such a limit when we see it in practice.
 	}
 
-	return deliverResult{delivered: delivered, failed: failed}
+	return deliverResult{delivered: delivered, failed: failed, remoteDSN: sc.SupportsDSN()}
 }
 
 // Update (overwite) last known starttls/requiretls support for recipient domain.
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
@@ -65,7 +66,6 @@ func failMsgsDB(qlog mlog.Log, msgs []*Msg, dialedIPs map[string][]net.IP, backo
 func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string][]net.IP, backoff time.Duration, remoteMTA dsn.NameIP, err error) {
 	// todo future: when we implement relaying, we should be able to send DSNs to non-local users. and possibly specify a null mailfrom. ../rfc/5321:1503
 	// todo future: when we implement relaying, and a dsn cannot be delivered, and requiretls was active, we cannot drop the message. instead deliver to local postmaster? though ../rfc/8689:383 may intend to say the dsn should be delivered without requiretls?
-	// todo future: when we implement smtp dsn extension, parameter RET=FULL must be disregarded for messages with REQUIRETLS. ../rfc/8689:379
 
 	m0 := msgs[0]
 
@@ -213,7 +213,37 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 	}
 }
 
+// dsnRequested returns whether a DSN must be sent for notify, one of "SUCCESS",
+// "FAILURE" or "DELAY", as requested with the NOTIFY parameter of the SMTP DSN
+// extension. Without NOTIFY parameter, DSNs are sent for failures and delays.
+// ../rfc/3461
+func (m Msg) dsnRequested(notify string) bool {
+	if len(m.DSNNotify) == 0 {
+		return notify != "SUCCESS"
+	}
+	return slices.Contains(m.DSNNotify, notify)
+}
+
+// dsnOpts returns the parameters for the SMTP DSN extension for delivering msgs,
+// all for the same message, in a single transaction.
+func dsnOpts(msgs []*Msg) *smtpclient.DSNOpts {
+	opts := &smtpclient.DSNOpts{
+		Ret:        msgs[0].DSNRet,
+		EnvID:      msgs[0].DSNEnvelopeID,
+		Recipients: make([]smtpclient.DSNRecipient, len(msgs)),
+	}
+	for i, m := range msgs {
+		opts.Recipients[i] = smtpclient.DSNRecipient{Notify: m.DSNNotify, ORcpt: m.DSNOriginalRecipient}
+	}
+	return opts
+}
+
 func deliverDSNFailure(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string) {
+	if !m.dsnRequested("FAILURE") {
+		log.Debug("not sending dsn for delivery failure, not requested by sender")
+		return
+	}
+
 	const subject = "mail delivery failed"
 	message := fmt.Sprintf(`
 Delivery has failed permanently for your email to:
@@ -230,7 +260,7 @@ Error during the last delivery attempt:
 		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
-	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, true, nil, subject, message)
+	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, dsn.Failed, nil, subject, message)
 }
 
 func deliverDSNDelay(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, retryUntil time.Time) {
@@ -239,6 +269,10 @@ func deliverDSNDelay(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errms
 	if m.IsDMARCReport {
 		return
 	}
+	if !m.dsnRequested("DELAY") {
+		log.Debug("not sending dsn for delayed delivery, not requested by sender")
+		return
+	}
 
 	const subject = "mail delivery delayed"
 	message := fmt.Sprintf(`
@@ -257,17 +291,42 @@ Error during the last delivery attempt:
 		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
-	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, false, &retryUntil, subject, message)
+	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, dsn.Delayed, &retryUntil, subject, message)
+}
+
+// deliverDSNSuccess sends a DSN for a successful delivery, if requested by the
+// sender. Only for deliveries to a next hop that does not support the SMTP DSN
+// extension, otherwise the next hop is responsible for sending the DSN. Since we
+// are not the final destination, the action is "relayed". ../rfc/3461
+func deliverDSNSuccess(log mlog.Log, m Msg, remoteMTA dsn.NameIP) {
+	if !m.dsnRequested("SUCCESS") || m.Sender().IsZero() {
+		return
+	}
+
+	const subject = "mail delivered"
+	message := fmt.Sprintf(`
+Your email has been delivered to the mail server for:
+
+	%s
+
+The receiving mail server does not send delivery notifications, so it is
+unknown whether the message has reached the mailbox of the recipient.
+`, m.Recipient().XString(m.SMTPUTF8))
+
+	deliverDSN(log, m, remoteMTA, "", "", nil, dsn.Relayed, nil, subject, message)
 }
 
 // We only queue DSNs for delivery failures for emails submitted by authenticated
 // users. So we are delivering to local users. ../rfc/5321:1466
 // ../rfc/5321:1494
 // ../rfc/7208:490
-func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, permanent bool, retryUntil *time.Time, subject, textBody string) {
+func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, action dsn.Action, retryUntil *time.Time, subject, textBody string) {
 	kind := "delayed delivery"
-	if permanent {
+	switch action {
+	case dsn.Failed:
 		kind = "failure"
+	case dsn.Relayed:
+		kind = "relayed"
 	}
 
 	qlog := func(text string, err error) {
@@ -284,20 +343,33 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		err := msgr.Close()
 		log.Check(err, "closing message reader after queuing dsn")
 	}()
-	headers, err := message.ReadHeaders(bufio.NewReader(msgr))
-	if err != nil {
-		qlog("reading headers of queued message", err)
-		return
+
+	// With RET=FULL, the whole message is included in failure DSNs. But not for
+	// messages with REQUIRETLS, the DSN may be delivered without TLS. ../rfc/8689:379
+	full := action == dsn.Failed && m.DSNRet == "FULL" && (m.RequireTLS == nil || !*m.RequireTLS)
+	var original []byte
+	if full {
+		original, err = io.ReadAll(msgr)
+		if err != nil {
+			qlog("reading queued message", err)
+			return
+		}
+	} else {
+		original, err = message.ReadHeaders(bufio.NewReader(msgr))
+		if err != nil {
+			qlog("reading headers of queued message", err)
+			return
+		}
 	}
 
-	var action dsn.Action
 	var status string
-	if permanent {
+	switch action {
+	case dsn.Failed:
 		status = "5."
-		action = dsn.Failed
-	} else {
-		action = dsn.Delayed
+	case dsn.Delayed:
 		status = "4."
+	default:
+		status = "2."
 	}
 	if secodeOpt != "" {
 		status += secodeOpt
@@ -320,6 +392,7 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		References: m.MessageID,
 		TextBody:   textBody,
 
+		OriginalEnvelopeID:   m.DSNEnvelopeID,
 		ReportingMTA:         mox.Conf.Static.HostnameDomain.ASCII,
 		ArrivalDate:          m.Queued,
 		FutureReleaseRequest: m.FutureReleaseRequest,
@@ -327,6 +400,7 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		Recipients: []dsn.Recipient{
 			{
 				FinalRecipient:     m.Recipient(),
+				OriginalRecipient:  dsn.ParseORCPT(m.DSNOriginalRecipient),
 				Action:             action,
 				Status:             status,
 				StatusComment:      errmsg,
@@ -337,7 +411,8 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 			},
 		},
 
-		Original: headers,
+		Original:     original,
+		OriginalFull: full,
 	}
 	msgData, err := dsnMsg.Compose(log, m.SMTPUTF8)
 	if err != nil {
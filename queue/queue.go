@@ -180,6 +180,22 @@ type Msg struct {
 	FutureReleaseRequest string
 	// ../rfc/4865:305
 
+	// Parameters of the SMTP DSN extension, ../rfc/3461. Passed on to the next hop if
+	// it supports the DSN extension, otherwise used for the DSNs we send.
+
+	// From the NOTIFY parameter of RCPT TO. Either "NEVER", or one or more of
+	// "SUCCESS", "FAILURE" and "DELAY". If empty, DSNs are sent for failures and
+	// delays.
+	DSNNotify []string
+	// From the ORCPT parameter of RCPT TO, the address type, a semicolon and the
+	// original recipient address, xtext-decoded. E.g. "rfc822;mjl@mox.example".
+	DSNOriginalRecipient string
+	// From the RET parameter of MAIL FROM, "FULL" or "HDRS". With FULL, the whole
+	// message is included in a failure DSN instead of only its headers.
+	DSNRet string
+	// From the ENVID parameter of MAIL FROM, xtext-decoded. Included in DSNs.
+	DSNEnvelopeID string
+
 	Extra map[string]string // Extra information, for transactional email.
 }
 
@@ -565,6 +565,21 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("expected net.Dialer as dialer")
 	}
 
+	// Delivery with a DSN requested for success, to a server without DSN extension.
+	// We send a DSN with action "relayed".
+	qm = MakeMsg(path, path, false, false, int64(len(testmsg)), "<dsnsuccess@localhost>", nil, nil, time.Now(), "test")
+	qm.DSNNotify = []string{"SUCCESS"}
+	err = Add(ctxbg, pkglog, "mjl", mf, qm)
+	tcheck(t, err, "add message to queue for delivery")
+	testQueue(true, fakeSMTPServer, 1)
+
+	// Same, but the server supports the DSN extension and is responsible for the DSN.
+	err = Add(ctxbg, pkglog, "mjl", mf, qm)
+	tcheck(t, err, "add message to queue for delivery")
+	testDeliver(func(server net.Conn) {
+		nfakeSMTPServer(server, 1, 1, false, []string{"DSN"})
+	})
+
 	// Single delivery to two recipients at same domain, expecting single connection
 	// and single transaction.
 	qm0 := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
@@ -798,6 +813,14 @@ func TestQueue(t *testing.T) {
 	kick(1, qml[0].ID)
 	testDSN(makeBadFakeSMTPSTARTTLSServer(false))
 
+	// Same, but without DSN for failures as requested by sender.
+	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<tlsrequiredunsupportednodsn@localhost>", nil, &yes, time.Now(), "test")}
+	qml[0].DSNNotify = []string{"NEVER"}
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	kick(1, qml[0].ID)
+	testDeliver(makeBadFakeSMTPSTARTTLSServer(false))
+
 	// Restore pre-DANE behaviour.
 	resolver.AllAuthentic = false
 	resolver.TLSA = nil
@@ -228,7 +228,7 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	for i, m := range msgs {
 		rcpts[i] = m.Recipient().String()
 	}
-	rcptErrs, submiterr := client.DeliverMultiple(deliverctx, m0.Sender().String(), rcpts, size, msgr, req8bit, reqsmtputf8, requireTLS)
+	rcptErrs, submiterr := client.DeliverMultipleDSN(deliverctx, m0.Sender().String(), rcpts, size, msgr, req8bit, reqsmtputf8, requireTLS, dsnOpts(msgs))
 	if submiterr != nil {
 		qlog.Infox("smtp transaction for delivery failed", submiterr)
 	}
@@ -239,14 +239,15 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	qlog.Check(cerr, "closing message after delivery attempt")
 	msgr = nil
 
-	failed, delivered = processDeliveries(qlog, m0, msgs, addr, transport.Host, backoff, rcptErrs, submiterr)
+	failed, delivered = processDeliveries(qlog, m0, msgs, addr, transport.Host, backoff, rcptErrs, submiterr, client.SupportsDSN())
 }
 
 // Process failures and successful deliveries, retiring/removing messages from
-// queue, queueing webhooks.
+// queue, queueing webhooks. If remoteDSN is false, DSNs for successful deliveries
+// are sent if requested.
 //
 // Also used by deliverLocalserve.
-func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, remoteHost string, backoff time.Duration, rcptErrs []smtpclient.Response, submiterr error) (failed, delivered int) {
+func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, remoteHost string, backoff time.Duration, rcptErrs []smtpclient.Response, submiterr error, remoteDSN bool) (failed, delivered int) {
 	var delMsgs []Msg
 	for i, m := range msgs {
 		qmlog := qlog.With(
@@ -277,6 +278,9 @@ func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, r
 			delMsgs = append(delMsgs, *m)
 			qmlog.Info("delivered from queue with transport")
 			delivered++
+			if !remoteDSN {
+				deliverDSNSuccess(qmlog, *m, dsn.NameIP{Name: remoteHost})
+			}
 		}
 	}
 	if len(delMsgs) > 0 {
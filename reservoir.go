@@ -0,0 +1,265 @@
+package main
+
+/*
+note: as with the junk commands, these testdata paths are not in the repo, you
+should gather some of your own ham/spam emails.
+
+./mox reservoir train testdata/train/ham testdata/train/spam
+./mox reservoir train -train-ratio 0.8 -reservoir-weight 0.2 testdata/train/ham testdata/train/spam
+./mox reservoir test testdata/check/ham testdata/check/spam
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/reservoir"
+)
+
+type reservoirArgs struct {
+	junkArgs
+	config    reservoir.FilterConfig
+	modelPath string
+}
+
+func reservoirFlags(fs *flag.FlagSet) (a reservoirArgs) {
+	a.junkArgs = junkFlags(fs)
+	a.config = reservoir.DefaultFilterConfig()
+	a.config.EnableReservoir = true
+	fs.IntVar(&a.config.ESNParams.ReservoirSize, "reservoir-size", a.config.ESNParams.ReservoirSize, "number of neurons in the reservoir")
+	fs.Int64Var(&a.config.ESNParams.Seed, "reservoir-seed", 0, "seed for generating the reservoir, 0 for random")
+	fs.Float64Var(&a.config.ReservoirWeight, "reservoir-weight", a.config.ReservoirWeight, "weight of reservoir probability in combined probability")
+	fs.BoolVar(&a.config.EnableAffective, "affective", false, "enable affective computing in combined probability")
+	fs.Func("lexicon", "file with affective lexicon for a language, can be repeated", func(v string) error {
+		a.config.Lexicons = append(a.config.Lexicons, v)
+		return nil
+	})
+	fs.StringVar(&a.modelPath, "modelpath", "reservoir.model", "file with reservoir model")
+	return
+}
+
+func cmdReservoirTrain(c *cmd) {
+	c.unlisted = true
+	c.params = "hamdir spamdir"
+	c.help = `Train a junk filter and reservoir filter with messages from hamdir and spamdir.
+
+The messages are shuffled, with optional random seed. A part of the messages,
+see -train-ratio, is used for training, and the remaining messages are used for
+evaluating the filters, like "mox reservoir test".`
+	a := reservoirFlags(c.flag)
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	a.SetLogLevel()
+	if err := a.config.Validate(); err != nil {
+		log.Fatalf("invalid reservoir parameters: %v", err)
+	}
+	err := a.config.LoadLexicons(func(p string) string { return p })
+	xcheckf(err, "loading lexicons")
+
+	ctx := context.Background()
+	jf := must(junk.NewFilter(ctx, c.log, a.params, a.databasePath, a.bloomfilterPath))
+	defer func() {
+		if err := jf.Close(); err != nil {
+			log.Printf("closing junk filter: %v", err)
+		}
+	}()
+	rf, err := reservoir.NewFilter(ctx, c.log, a.config, a.modelPath)
+	xcheckf(err, "new reservoir filter")
+
+	hamDir := args[0]
+	spamDir := args[1]
+	hamFiles := listDir(hamDir)
+	spamFiles := listDir(spamDir)
+
+	var seed int64
+	if a.seed {
+		seed = time.Now().UnixMilli()
+	}
+	rand := mathrand.New(mathrand.NewSource(seed))
+	rand.Shuffle(len(hamFiles), func(i, j int) { hamFiles[i], hamFiles[j] = hamFiles[j], hamFiles[i] })
+	rand.Shuffle(len(spamFiles), func(i, j int) { spamFiles[i], spamFiles[j] = spamFiles[j], spamFiles[i] })
+
+	ntrainham := int(a.trainRatio * float64(len(hamFiles)))
+	ntrainspam := int(a.trainRatio * float64(len(spamFiles)))
+	trainHam := hamFiles[:ntrainham]
+	trainSpam := spamFiles[:ntrainspam]
+	testHam := hamFiles[ntrainham:]
+	testSpam := spamFiles[ntrainspam:]
+
+	var trainSent []string
+	if a.sentDir != "" {
+		trainSent = listDir(a.sentDir)
+	}
+
+	err = jf.TrainDirs(hamDir, a.sentDir, spamDir, trainHam, trainSent, trainSpam)
+	xcheckf(err, "train junk filter")
+
+	var samples []reservoir.Sample
+	var nmalformed int
+	addSamples := func(dir string, files []string, junk bool) {
+		for _, name := range files {
+			path := filepath.Join(dir, name)
+			err := reservoirMessage(c, path, func(p *message.Part) error {
+				words, err := jf.ParseMessage(*p)
+				if err != nil {
+					return err
+				}
+				features := rf.Features(reservoir.Message{Part: p, Words: words})
+				samples = append(samples, reservoir.Sample{ID: int64(len(samples) + 1), Junk: junk, Features: features})
+				return nil
+			})
+			if err != nil {
+				nmalformed++
+			}
+		}
+	}
+	addSamples(hamDir, trainHam, false)
+	addSamples(a.sentDir, trainSent, false)
+	addSamples(spamDir, trainSpam, true)
+
+	err = rf.Fit(ctx, samples)
+	xcheckf(err, "train reservoir filter")
+	err = rf.Save()
+	xcheckf(err, "save reservoir filter")
+
+	fmt.Printf("training done, nham %d, nsent %d, nspam %d, nmalformed %d\n", ntrainham, len(trainSent), ntrainspam, nmalformed)
+	if len(testHam) == 0 && len(testSpam) == 0 {
+		return
+	}
+	fmt.Println()
+	reservoirEvaluate(c, a, jf, rf, hamDir, testHam, spamDir, testSpam)
+}
+
+func cmdReservoirTest(c *cmd) {
+	c.unlisted = true
+	c.params = "hamdir spamdir"
+	c.help = `Evaluate a junk filter and reservoir filter on messages from hamdir and spamdir.
+
+For the Bayesian junk filter, the reservoir filter and their combined
+probability, the confusion matrix, precision, recall, F1, accuracy and area under
+the ROC curve are printed. The combined probability is also evaluated for other
+values of -reservoir-weight, to help with choosing a weight.`
+	a := reservoirFlags(c.flag)
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	a.SetLogLevel()
+	err := a.config.LoadLexicons(func(p string) string { return p })
+	xcheckf(err, "loading lexicons")
+
+	ctx := context.Background()
+	jf := must(junk.OpenFilter(ctx, c.log, a.params, a.databasePath, a.bloomfilterPath, false))
+	defer func() {
+		if err := jf.Close(); err != nil {
+			log.Printf("closing junk filter: %v", err)
+		}
+	}()
+	rf, err := reservoir.OpenFilter(ctx, c.log, a.config, a.modelPath)
+	xcheckf(err, "open reservoir filter")
+
+	reservoirEvaluate(c, a, jf, rf, args[0], listDir(args[0]), args[1], listDir(args[1]))
+}
+
+// reservoirMessage parses the message at path and calls fn with the part while
+// the file is still open. Authentication results are not available for messages
+// from files.
+func reservoirMessage(c *cmd, path string, fn func(p *message.Part) error) error {
+	mf, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := mf.Close(); err != nil {
+			log.Printf("closing message file: %v", err)
+		}
+	}()
+	fi, err := mf.Stat()
+	if err != nil {
+		return err
+	}
+	p, err := message.EnsurePart(c.log.Logger, false, mf, fi.Size())
+	if err != nil {
+		return err
+	}
+	return fn(&p)
+}
+
+func reservoirEvaluate(c *cmd, a reservoirArgs, jf *junk.Filter, rf *reservoir.ReservoirFilter, hamDir string, hamFiles []string, spamDir string, spamFiles []string) {
+	ctx := context.Background()
+
+	classify := func(dir string, files []string) (results []reservoir.ClassifyResult, malformed int) {
+		for _, name := range files {
+			path := filepath.Join(dir, name)
+			err := reservoirMessage(c, path, func(p *message.Part) error {
+				words, err := jf.ParseMessage(*p)
+				if err != nil {
+					return err
+				}
+				jr, err := jf.ClassifyWords(ctx, words)
+				if err != nil {
+					return err
+				}
+				r, err := rf.Classify(ctx, reservoir.Message{Part: p, Words: words}, jr.Probability)
+				if err != nil {
+					return err
+				}
+				results = append(results, *r)
+				return nil
+			})
+			if err != nil {
+				malformed++
+			}
+		}
+		return
+	}
+
+	hamResults, nmalformedham := classify(hamDir, hamFiles)
+	spamResults, nmalformedspam := classify(spamDir, spamFiles)
+	if esn := rf.ESN(); esn != nil && !esn.Trained() {
+		fmt.Printf("warning: reservoir is not trained\n")
+	}
+	fmt.Printf("total ham %d, malformed %d\n", len(hamResults), nmalformedham)
+	fmt.Printf("total spam %d, malformed %d\n", len(spamResults), nmalformedspam)
+
+	probs := func(l []reservoir.ClassifyResult, fn func(r *reservoir.ClassifyResult) float64) []float64 {
+		var r []float64
+		for i := range l {
+			r = append(r, fn(&l[i]))
+		}
+		return r
+	}
+	evaluate := func(fn func(r *reservoir.ClassifyResult) float64) reservoir.Evaluation {
+		return reservoir.Evaluate(probs(spamResults, fn), probs(hamResults, fn), a.spamThreshold)
+	}
+
+	printEval := func(name string, e reservoir.Evaluation) {
+		fmt.Printf("\n%s, threshold %.4f\n", name, e.Threshold)
+		fmt.Printf("            predicted spam  predicted ham\n")
+		fmt.Printf("actual spam %14d %14d\n", e.TruePositives, e.FalseNegatives)
+		fmt.Printf("actual ham  %14d %14d\n", e.FalsePositives, e.TrueNegatives)
+		fmt.Printf("precision %.6f, recall %.6f, f1 %.6f, accuracy %.6f, roc auc %.6f\n", e.Precision, e.Recall, e.F1, e.Accuracy, e.AUC)
+	}
+	printEval("bayesian", evaluate(func(r *reservoir.ClassifyResult) float64 { return r.BayesianProb }))
+	printEval("reservoir", evaluate(func(r *reservoir.ClassifyResult) float64 { return r.ReservoirProb }))
+	printEval(fmt.Sprintf("combined, reservoir weight %.2f", a.config.ReservoirWeight), evaluate(func(r *reservoir.ClassifyResult) float64 { return r.CombinedProb }))
+
+	fmt.Printf("\ncombined by reservoir weight\n")
+	fmt.Printf("weight  precision     recall         f1    roc auc\n")
+	for i := 0; i <= 10; i++ {
+		config := a.config
+		config.ReservoirWeight = float64(i) / 10
+		e := evaluate(config.Combine)
+		fmt.Printf("%6.2f %10.6f %10.6f %10.6f %10.6f\n", config.ReservoirWeight, e.Precision, e.Recall, e.F1, e.AUC)
+	}
+}
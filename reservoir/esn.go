@@ -8,12 +8,13 @@ package reservoir
 
 import (
 	"context"
+	cryptorand "crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"log/slog"
 	"math"
 	"math/rand"
 	"sync"
-	"time"
 
 	"github.com/mjl-/mox/mlog"
 )
@@ -22,24 +23,30 @@ import (
 type ESNParams struct {
 	// Reservoir size - number of neurons in the hidden layer
 	ReservoirSize int `sconf:"optional" sconf-doc:"Number of neurons in the reservoir layer. Default: 100."`
-	
+
 	// Spectral radius - controls memory capacity
 	SpectralRadius float64 `sconf:"optional" sconf-doc:"Spectral radius for reservoir stability. Should be < 1.0. Default: 0.95."`
-	
+
 	// Input scaling - scales input signals
 	InputScaling float64 `sconf:"optional" sconf-doc:"Scaling factor for input signals. Default: 1.0."`
-	
+
 	// Leak rate - controls neuron activation decay
 	LeakRate float64 `sconf:"optional" sconf-doc:"Leak rate for neuron activation (0-1). Default: 0.3."`
-	
+
 	// Sparsity - connection density in reservoir
 	Sparsity float64 `sconf:"optional" sconf-doc:"Sparsity of reservoir connections (0-1). Default: 0.1."`
-	
+
 	// Ridge regression parameter for output training
 	RidgeParam float64 `sconf:"optional" sconf-doc:"Ridge regression parameter for regularization. Default: 1e-8."`
-	
+
 	// Tree depth for hierarchical processing
 	TreeDepth int `sconf:"optional" sconf-doc:"Depth of the tree structure for hierarchical processing. Default: 3."`
+
+	// Input dimension, set from the feature extractor.
+	InputDim int `sconf:"-" json:"-"`
+
+	// Seed for the random number generator that initializes the reservoir.
+	Seed int64 `sconf:"optional" sconf-doc:"Seed for generating the random reservoir and input weights. If zero, a random seed is generated when a new model is created. The seed is stored with the model."`
 }
 
 // DefaultESNParams returns default parameters for the ESN.
@@ -58,37 +65,42 @@ func DefaultESNParams() ESNParams {
 // PersonaTrait represents LLM personality traits mapped to reservoir parameters.
 type PersonaTrait struct {
 	// Affective dimensions
-	Valence    float64 // Positive/negative emotional tone (-1 to 1)
-	Arousal    float64 // Activation/energy level (0 to 1)
-	Dominance  float64 // Control/power dimension (0 to 1)
-	
+	Valence   float64 `sconf:"optional" sconf-doc:"Positive/negative emotional tone, modulates signal strength (-1 to 1)."`
+	Arousal   float64 `sconf:"optional" sconf-doc:"Activation/energy level, affects membrane permeability (0 to 1)."`
+	Dominance float64 `sconf:"optional" sconf-doc:"Control/power dimension (0 to 1)."`
+
 	// Cognitive dimensions
-	Attention  float64 // Focus/concentration (0 to 1)
-	Memory     float64 // Retention capacity (0 to 1)
-	Creativity float64 // Novelty generation (0 to 1)
+	Attention  float64 `sconf:"optional" sconf-doc:"Focus/concentration, modulates leak rate (0 to 1)."`
+	Memory     float64 `sconf:"optional" sconf-doc:"Retention capacity, controls Ricci flow rate (0 to 1)."`
+	Creativity float64 `sconf:"optional" sconf-doc:"Novelty generation (0 to 1)."`
 }
 
 // ESN represents a Deep Tree Echo State Network.
 type ESN struct {
 	params  ESNParams
 	persona PersonaTrait
-	
+
 	// Network weights
 	inputWeights     [][]float64 // Input to reservoir weights
 	reservoirWeights [][]float64 // Recurrent reservoir weights
 	outputWeights    [][]float64 // Reservoir to output weights
-	
+
 	// State
 	state []float64 // Current reservoir state
-	
+
 	// Membrane computing components
 	membranes []*Membrane
-	
+
 	// Synchronization
-	mu     sync.RWMutex
-	rng    *rand.Rand
-	log    mlog.Log
-	trained bool
+	mu   sync.RWMutex
+	seed int64 // Seed for rng, stored with the model.
+
+	// Identifies the samples the output weights were trained on, see Fit.
+	trainedSamples      int
+	trainedLastSampleID int64
+	rng                 *rand.Rand
+	log                 mlog.Log
+	trained             bool
 }
 
 // NewESN creates a new Echo State Network with the given parameters.
@@ -102,20 +114,35 @@ func NewESN(log mlog.Log, params ESNParams, persona PersonaTrait) (*ESN, error)
 	if params.LeakRate <= 0 || params.LeakRate > 1.0 {
 		return nil, fmt.Errorf("leak rate must be in (0, 1]")
 	}
-	
+
+	seed := params.Seed
+	if seed == 0 {
+		var buf [8]byte
+		if _, err := cryptorand.Read(buf[:]); err != nil {
+			return nil, fmt.Errorf("generating seed: %w", err)
+		}
+		seed = int64(binary.LittleEndian.Uint64(buf[:]) &^ (1 << 63))
+	}
+
 	esn := &ESN{
 		params:  params,
 		persona: persona,
 		state:   make([]float64, params.ReservoirSize),
-		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		seed:    seed,
+		rng:     rand.New(rand.NewSource(seed)),
 		log:     log,
 	}
-	
+
 	// Initialize reservoir weights with Paun P-system membrane structure
 	if err := esn.initializeReservoir(); err != nil {
 		return nil, fmt.Errorf("initializing reservoir: %w", err)
 	}
-	
+
+	// Without known input dimension, input weights are initialized on first update.
+	if params.InputDim > 0 {
+		esn.SetInputWeights(params.InputDim)
+	}
+
 	return esn, nil
 }
 
@@ -123,9 +150,9 @@ func NewESN(log mlog.Log, params ESNParams, persona PersonaTrait) (*ESN, error)
 func (esn *ESN) initializeReservoir() error {
 	esn.mu.Lock()
 	defer esn.mu.Unlock()
-	
+
 	n := esn.params.ReservoirSize
-	
+
 	// Initialize reservoir weights with sparse random connections
 	esn.reservoirWeights = make([][]float64, n)
 	for i := range esn.reservoirWeights {
@@ -136,15 +163,15 @@ func (esn *ESN) initializeReservoir() error {
 			}
 		}
 	}
-	
+
 	// Scale weights to achieve desired spectral radius
 	if err := esn.scaleSpectralRadius(); err != nil {
 		return fmt.Errorf("scaling spectral radius: %w", err)
 	}
-	
+
 	// Initialize membrane structure for hierarchical processing
 	esn.initializeMembranes()
-	
+
 	return nil
 }
 
@@ -156,7 +183,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 	for i := range v {
 		v[i] = esn.rng.NormFloat64()
 	}
-	
+
 	// Normalize
 	norm := 0.0
 	for _, val := range v {
@@ -166,7 +193,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 	for i := range v {
 		v[i] /= norm
 	}
-	
+
 	// Power iteration
 	for iter := 0; iter < 50; iter++ {
 		// Multiply v by matrix
@@ -176,7 +203,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 				newV[i] += esn.reservoirWeights[i][j] * v[j]
 			}
 		}
-		
+
 		// Normalize
 		norm = 0.0
 		for _, val := range newV {
@@ -188,7 +215,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 		}
 		v = newV
 	}
-	
+
 	// Estimate largest eigenvalue (spectral radius)
 	eigenvalue := 0.0
 	for i := range v {
@@ -199,7 +226,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 		eigenvalue += product * v[i]
 	}
 	eigenvalue = math.Abs(eigenvalue)
-	
+
 	// Scale weights
 	if eigenvalue > 0 {
 		scale := esn.params.SpectralRadius / eigenvalue
@@ -209,7 +236,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 			}
 		}
 	}
-	
+
 	return nil
 }
 
@@ -217,7 +244,7 @@ func (esn *ESN) scaleSpectralRadius() error {
 func (esn *ESN) initializeMembranes() {
 	depth := esn.params.TreeDepth
 	esn.membranes = make([]*Membrane, 0)
-	
+
 	// Create hierarchical membrane structure
 	for level := 0; level < depth; level++ {
 		numMembranes := 1 << level // 2^level membranes at each level
@@ -256,51 +283,51 @@ func (esn *ESN) SetInputWeights(inputDim int) {
 func (esn *ESN) Update(ctx context.Context, input []float64) error {
 	esn.mu.Lock()
 	defer esn.mu.Unlock()
-	
+
 	if len(esn.inputWeights) == 0 {
 		esn.setInputWeights(len(input))
 	}
-	
+
 	if len(input) != len(esn.inputWeights[0]) {
 		return fmt.Errorf("input dimension mismatch: expected %d, got %d", len(esn.inputWeights[0]), len(input))
 	}
-	
+
 	// Simplified update with leak rate dynamics
 	newState := make([]float64, len(esn.state))
-	
+
 	for i := 0; i < len(esn.state); i++ {
 		// Input contribution
 		inputSum := 0.0
 		for j := range input {
 			inputSum += esn.inputWeights[i][j] * input[j]
 		}
-		
+
 		// Recurrent contribution
 		recurrentSum := 0.0
 		for j := 0; j < len(esn.state); j++ {
 			recurrentSum += esn.reservoirWeights[i][j] * esn.state[j]
 		}
-		
+
 		// Activation with affective modulation
 		activation := math.Tanh(inputSum + recurrentSum)
-		
+
 		// Apply persona-based emotional modulation
 		activation *= (1.0 + 0.1*esn.persona.Valence) // Valence affects signal strength
-		
+
 		// Leak dynamics influenced by attention
 		leakRate := esn.params.LeakRate * (1.0 + 0.2*esn.persona.Attention)
 		newState[i] = (1-leakRate)*esn.state[i] + leakRate*activation
 	}
-	
+
 	// Update state
 	copy(esn.state, newState)
-	
+
 	// Apply membrane computing transformations
 	esn.applyMembraneEvolution()
-	
+
 	// Apply Ricci flow curvature correction for geometric regularization
 	esn.applyRicciFlow()
-	
+
 	return nil
 }
 
@@ -308,31 +335,31 @@ func (esn *ESN) Update(ctx context.Context, input []float64) error {
 func (esn *ESN) computeDerivative(input, state []float64) []float64 {
 	n := len(state)
 	derivative := make([]float64, n)
-	
+
 	for i := 0; i < n; i++ {
 		// Input contribution
 		inputSum := 0.0
 		for j := range input {
 			inputSum += esn.inputWeights[i][j] * input[j]
 		}
-		
+
 		// Recurrent contribution
 		recurrentSum := 0.0
 		for j := 0; j < n; j++ {
 			recurrentSum += esn.reservoirWeights[i][j] * state[j]
 		}
-		
+
 		// Activation with affective modulation
 		activation := math.Tanh(inputSum + recurrentSum)
-		
+
 		// Apply persona-based emotional modulation
 		activation *= (1.0 + 0.1*esn.persona.Valence) // Valence affects signal strength
-		
+
 		// Leak dynamics influenced by attention
 		leakRate := esn.params.LeakRate * (1.0 + 0.2*esn.persona.Attention)
 		derivative[i] = -leakRate*state[i] + (1-leakRate)*activation
 	}
-	
+
 	return derivative
 }
 
@@ -357,7 +384,7 @@ func (esn *ESN) applyRicciFlow() {
 	// Simplified Ricci flow: adjust curvature of state space
 	// R_ij represents the Ricci curvature tensor
 	// ∂g_ij/∂t = -2R_ij (Ricci flow equation)
-	
+
 	// Compute local curvature estimate
 	n := len(esn.state)
 	for i := 0; i < n; i++ {
@@ -373,7 +400,7 @@ func (esn *ESN) applyRicciFlow() {
 		if count > 0 {
 			avgNeighbor := neighbors / count
 			curvature := esn.state[i] - avgNeighbor
-			
+
 			// Apply Ricci flow correction (small time step)
 			flowCoeff := 0.01 * esn.persona.Memory // Memory affects flow rate
 			esn.state[i] -= flowCoeff * curvature
@@ -381,11 +408,23 @@ func (esn *ESN) applyRicciFlow() {
 	}
 }
 
+// Seed returns the seed the reservoir and input weights were generated with.
+func (esn *ESN) Seed() int64 {
+	return esn.seed
+}
+
+// Trained returns whether output weights have been trained.
+func (esn *ESN) Trained() bool {
+	esn.mu.RLock()
+	defer esn.mu.RUnlock()
+	return esn.trained
+}
+
 // GetState returns the current reservoir state.
 func (esn *ESN) GetState() []float64 {
 	esn.mu.RLock()
 	defer esn.mu.RUnlock()
-	
+
 	state := make([]float64, len(esn.state))
 	copy(state, esn.state)
 	return state
@@ -395,65 +434,165 @@ func (esn *ESN) GetState() []float64 {
 func (esn *ESN) Reset() {
 	esn.mu.Lock()
 	defer esn.mu.Unlock()
-	
+
 	for i := range esn.state {
 		esn.state[i] = 0
 	}
 }
 
-// TrainOutput trains the output layer using ridge regression.
+// SetState replaces the reservoir state, e.g. with a state from GetState kept
+// for a sequence of messages from a sender.
+func (esn *ESN) SetState(state []float64) error {
+	esn.mu.Lock()
+	defer esn.mu.Unlock()
+
+	if len(state) != len(esn.state) {
+		return fmt.Errorf("state dimension mismatch: expected %d, got %d", len(esn.state), len(state))
+	}
+	copy(esn.state, state)
+	return nil
+}
+
+// TrainOutput trains the output layer using ridge regression, solving
+// W = T^T S (S^T S + λI)^-1 directly. With fewer states than state dimensions,
+// the equivalent and smaller dual form W = T^T (S S^T + λI)^-1 S is solved.
 func (esn *ESN) TrainOutput(ctx context.Context, states [][]float64, targets [][]float64) error {
 	esn.mu.Lock()
 	defer esn.mu.Unlock()
-	
+
 	if len(states) != len(targets) {
 		return fmt.Errorf("number of states (%d) must match number of targets (%d)", len(states), len(targets))
 	}
-	
+
 	if len(states) == 0 {
 		return fmt.Errorf("no training data provided")
 	}
-	
+
+	m := len(states)
 	inputDim := len(states[0])
 	outputDim := len(targets[0])
-	
-	// Initialize output weights
-	esn.outputWeights = make([][]float64, outputDim)
-	for i := range esn.outputWeights {
-		esn.outputWeights[i] = make([]float64, inputDim)
-	}
-	
-	// Ridge regression: W = (S^T S + λI)^-1 S^T T
-	// Simplified version: use gradient descent
-	learningRate := 0.01
-	epochs := 100
-	
-	for epoch := 0; epoch < epochs; epoch++ {
-		for s := range states {
-			// Forward pass
-			predictions := make([]float64, outputDim)
-			for i := 0; i < outputDim; i++ {
-				sum := 0.0
-				for j := 0; j < inputDim; j++ {
-					sum += esn.outputWeights[i][j] * states[s][j]
+	for s := range states {
+		if len(states[s]) != inputDim || len(targets[s]) != outputDim {
+			return fmt.Errorf("inconsistent dimensions for training sample %d", s)
+		}
+	}
+
+	var weights [][]float64
+	if m < inputDim {
+		// Dual form, solve (S S^T + λI) A = T, then W = A^T S.
+		a := make([][]float64, m)
+		for i := range a {
+			a[i] = make([]float64, m)
+			for j := range a[i] {
+				a[i][j] = dot(states[i], states[j])
+			}
+			a[i][i] += esn.params.RidgeParam
+		}
+		b := make([][]float64, m)
+		for i := range b {
+			b[i] = append([]float64{}, targets[i]...)
+		}
+		if err := solve(a, b); err != nil {
+			return fmt.Errorf("solving ridge regression: %w", err)
+		}
+		weights = make([][]float64, outputDim)
+		for k := range weights {
+			weights[k] = make([]float64, inputDim)
+			for s := range states {
+				for j := range inputDim {
+					weights[k][j] += b[s][k] * states[s][j]
 				}
-				predictions[i] = sum
 			}
-			
-			// Backward pass
-			for i := 0; i < outputDim; i++ {
-				error := predictions[i] - targets[s][i]
-				for j := 0; j < inputDim; j++ {
-					gradient := error*states[s][j] + esn.params.RidgeParam*esn.outputWeights[i][j]
-					esn.outputWeights[i][j] -= learningRate * gradient
+		}
+	} else {
+		// Primal form, solve (S^T S + λI) X = S^T T, then W = X^T.
+		a := make([][]float64, inputDim)
+		for i := range a {
+			a[i] = make([]float64, inputDim)
+		}
+		b := make([][]float64, inputDim)
+		for i := range b {
+			b[i] = make([]float64, outputDim)
+		}
+		for s := range states {
+			for i := range inputDim {
+				for j := range inputDim {
+					a[i][j] += states[s][i] * states[s][j]
+				}
+				for k := range outputDim {
+					b[i][k] += states[s][i] * targets[s][k]
 				}
 			}
 		}
+		for i := range inputDim {
+			a[i][i] += esn.params.RidgeParam
+		}
+		if err := solve(a, b); err != nil {
+			return fmt.Errorf("solving ridge regression: %w", err)
+		}
+		weights = make([][]float64, outputDim)
+		for k := range weights {
+			weights[k] = make([]float64, inputDim)
+			for j := range inputDim {
+				weights[k][j] = b[j][k]
+			}
+		}
 	}
-	
+
+	esn.outputWeights = weights
 	esn.trained = true
-	esn.log.Debug("esn trained", slog.Int("states", len(states)), slog.Int("epochs", epochs))
-	
+	esn.log.Debug("esn trained", slog.Int("states", len(states)))
+
+	return nil
+}
+
+func dot(a, b []float64) float64 {
+	var r float64
+	for i := range a {
+		r += a[i] * b[i]
+	}
+	return r
+}
+
+// solve solves a X = b for X using Gaussian elimination with partial pivoting.
+// Both a and b are modified, b holds the solution on return.
+func solve(a, b [][]float64) error {
+	n := len(a)
+	for col := range n {
+		pivot := col
+		for i := col + 1; i < n; i++ {
+			if math.Abs(a[i][col]) > math.Abs(a[pivot][col]) {
+				pivot = i
+			}
+		}
+		if a[pivot][col] == 0 || math.IsNaN(a[pivot][col]) {
+			return fmt.Errorf("singular matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for i := col + 1; i < n; i++ {
+			f := a[i][col] / a[col][col]
+			if f == 0 {
+				continue
+			}
+			for j := col; j < n; j++ {
+				a[i][j] -= f * a[col][j]
+			}
+			for k := range b[i] {
+				b[i][k] -= f * b[col][k]
+			}
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		for k := range b[i] {
+			v := b[i][k]
+			for j := i + 1; j < n; j++ {
+				v -= a[i][j] * b[j][k]
+			}
+			b[i][k] = v / a[i][i]
+		}
+	}
 	return nil
 }
 
@@ -461,18 +600,18 @@ func (esn *ESN) TrainOutput(ctx context.Context, states [][]float64, targets [][
 func (esn *ESN) Predict(ctx context.Context) ([]float64, error) {
 	esn.mu.RLock()
 	defer esn.mu.RUnlock()
-	
+
 	if !esn.trained {
 		return nil, fmt.Errorf("network not trained")
 	}
-	
+
 	if esn.outputWeights == nil {
 		return nil, fmt.Errorf("output weights not initialized")
 	}
-	
+
 	outputDim := len(esn.outputWeights)
 	output := make([]float64, outputDim)
-	
+
 	for i := 0; i < outputDim; i++ {
 		sum := 0.0
 		for j := range esn.state {
@@ -480,6 +619,6 @@ func (esn *ESN) Predict(ctx context.Context) ([]float64, error) {
 		}
 		output[i] = sum
 	}
-	
+
 	return output, nil
 }
@@ -0,0 +1,98 @@
+package reservoir
+
+import (
+	"sort"
+)
+
+// Evaluation holds quality metrics for spam probabilities of messages with known
+// classification.
+type Evaluation struct {
+	Threshold float64 // Probabilities above threshold are classified as spam.
+
+	// Confusion matrix, with spam as the positive class.
+	TruePositives  int
+	FalsePositives int
+	TrueNegatives  int
+	FalseNegatives int
+
+	Precision float64 // Of messages classified as spam, the fraction that is spam.
+	Recall    float64 // Of spam messages, the fraction classified as spam.
+	F1        float64 // Harmonic mean of precision and recall.
+	Accuracy  float64
+	AUC       float64 // Area under the ROC curve, independent of threshold.
+}
+
+// Evaluate calculates metrics for the probabilities of spam and ham messages.
+func Evaluate(spam, ham []float64, threshold float64) Evaluation {
+	e := Evaluation{Threshold: threshold}
+	for _, p := range spam {
+		if p > threshold {
+			e.TruePositives++
+		} else {
+			e.FalseNegatives++
+		}
+	}
+	for _, p := range ham {
+		if p > threshold {
+			e.FalsePositives++
+		} else {
+			e.TrueNegatives++
+		}
+	}
+	if n := e.TruePositives + e.FalsePositives; n > 0 {
+		e.Precision = float64(e.TruePositives) / float64(n)
+	}
+	if n := e.TruePositives + e.FalseNegatives; n > 0 {
+		e.Recall = float64(e.TruePositives) / float64(n)
+	}
+	if e.Precision+e.Recall > 0 {
+		e.F1 = 2 * e.Precision * e.Recall / (e.Precision + e.Recall)
+	}
+	if n := len(spam) + len(ham); n > 0 {
+		e.Accuracy = float64(e.TruePositives+e.TrueNegatives) / float64(n)
+	}
+	e.AUC = auc(spam, ham)
+	return e
+}
+
+// auc calculates the area under the ROC curve with the Mann-Whitney U
+// statistic: the probability that a random spam message gets a higher
+// probability than a random ham message, with ties counting half.
+func auc(spam, ham []float64) float64 {
+	if len(spam) == 0 || len(ham) == 0 {
+		return 0
+	}
+
+	type score struct {
+		p    float64
+		spam bool
+	}
+	l := make([]score, 0, len(spam)+len(ham))
+	for _, p := range spam {
+		l = append(l, score{p, true})
+	}
+	for _, p := range ham {
+		l = append(l, score{p, false})
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].p < l[j].p
+	})
+
+	// Sum of ranks of spam messages, with tied scores getting their average rank.
+	var rankSum float64
+	for i := 0; i < len(l); {
+		j := i
+		for j < len(l) && l[j].p == l[i].p {
+			j++
+		}
+		rank := float64(i+1+j) / 2
+		for k := i; k < j; k++ {
+			if l[k].spam {
+				rankSum += rank
+			}
+		}
+		i = j
+	}
+	ns, nh := float64(len(spam)), float64(len(ham))
+	return (rankSum - ns*(ns+1)/2) / (ns * nh)
+}
@@ -0,0 +1,266 @@
+package reservoir
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mjl-/mox/message"
+)
+
+// Message is the input for feature extraction. Only Part is required, extractors
+// use the other fields when present.
+type Message struct {
+	Part *message.Part
+
+	// Words from the junk filter tokenizer, see junk.Filter.ParseMessage.
+	Words map[string]struct{}
+
+	Auth Auth
+
+	// Key for the sender of the message, e.g. its organizational domain or remote IP
+	// network. If set, and the filter has a state cache, consecutive messages from
+	// the sender form a sequence for the reservoir. See ReservoirFilter.Classify.
+	Sender string
+}
+
+// Auth holds the authentication results and reputation for a message.
+type Auth struct {
+	DKIMDomains       []string // Domains with a valid DKIM signature.
+	SPF               string   // SPF result for MAIL FROM, e.g. "pass", "fail", "softfail", "none". Empty if unknown.
+	EHLOValidated     bool
+	MailFromValidated bool
+	MsgFromValidated  bool // Message From domain is aligned with a valid SPF or DKIM domain.
+
+	// Whether an inconclusive reputation analysis leans towards junk or nonjunk. Nil
+	// if unknown. For messages trained after delivery, this should be the reputation
+	// from the time of delivery, as used for classification.
+	Reputation *bool
+}
+
+// FeatureExtractor turns a message into a fixed-size feature vector, the input
+// for the reservoir. The input dimension of the reservoir is set to Dim.
+type FeatureExtractor interface {
+	Dim() int
+	Features(m Message) []float64
+}
+
+var extractors = struct {
+	sync.Mutex
+	m map[string]func(c FilterConfig) FeatureExtractor
+}{
+	m: map[string]func(c FilterConfig) FeatureExtractor{
+		"basic":    func(c FilterConfig) FeatureExtractor { return basicExtractor{} },
+		"standard": func(c FilterConfig) FeatureExtractor { return standardExtractor{c.FeatureWords} },
+	},
+}
+
+// RegisterExtractor makes a feature extractor available under name, for use in
+// FilterConfig.FeatureExtractor. Registering a name twice panics.
+func RegisterExtractor(name string, fn func(c FilterConfig) FeatureExtractor) {
+	extractors.Lock()
+	defer extractors.Unlock()
+	if _, ok := extractors.m[name]; ok {
+		panic(fmt.Sprintf("reservoir: extractor %q already registered", name))
+	}
+	extractors.m[name] = fn
+}
+
+// NewExtractor returns the feature extractor for the configuration. An empty
+// name selects the "standard" extractor.
+func NewExtractor(c FilterConfig) (FeatureExtractor, error) {
+	name := c.FeatureExtractor
+	if name == "" {
+		name = "standard"
+	}
+	extractors.Lock()
+	fn, ok := extractors.m[name]
+	extractors.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown feature extractor %q, known extractors: %s", name, strings.Join(extractorNames(), ", "))
+	}
+	return fn(c), nil
+}
+
+// basicExtractor extracts text statistics and English spam keywords from the
+// subject and the start of the body.
+type basicExtractor struct{}
+
+func (basicExtractor) Dim() int {
+	return 10
+}
+
+func (basicExtractor) Features(m Message) []float64 {
+	var rf ReservoirFilter
+	return rf.extractFeatures(rf.extractTextContent(m.Part))
+}
+
+// standardExtractor extracts hashed words, text statistics, authentication
+// results, reputation, links and the HTML to text ratio.
+type standardExtractor struct {
+	wordBuckets int
+}
+
+// Number of features after the hashed words.
+const standardFixedFeatures = 16
+
+func (x standardExtractor) Dim() int {
+	return x.wordBuckets + standardFixedFeatures
+}
+
+// linkRegexp matches URLs in text and HTML.
+var linkRegexp = regexp.MustCompile(`(?i)https?://[^\s"'<>()]+`)
+
+func (x standardExtractor) Features(m Message) []float64 {
+	features := make([]float64, x.Dim())
+
+	// Hashed words, with a hash bit determining the sign so collisions tend to
+	// cancel out. Scaled so messages with many words have a similar magnitude.
+	if x.wordBuckets > 0 && len(m.Words) > 0 {
+		for w := range m.Words {
+			h := fnv.New32a()
+			h.Write([]byte(w))
+			v := h.Sum32()
+			sign := 1.0
+			if v&(1<<31) != 0 {
+				sign = -1
+			}
+			features[int(v&^(1<<31))%x.wordBuckets] += sign
+		}
+		scale := 1 / math.Sqrt(float64(len(m.Words)))
+		for i := range x.wordBuckets {
+			features[i] *= scale
+		}
+	}
+	f := features[x.wordBuckets:]
+
+	var subject string
+	var text, html strings.Builder
+	if m.Part != nil {
+		if m.Part.Envelope != nil {
+			subject = m.Part.Envelope.Subject
+		}
+		gatherText(m.Part, &text, &html, 0)
+	}
+	s := subject + "\n" + text.String() + html.String()
+
+	// Text statistics.
+	f[0] = math.Min(math.Log1p(float64(len(s)))/math.Log(1024*1024), 1)
+	var upper, digit, special, letters int
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper++
+			letters++
+		case c >= 'a' && c <= 'z':
+			letters++
+		case c >= '0' && c <= '9':
+			digit++
+		case c == '!' || c == '$' || c == '%':
+			special++
+		}
+	}
+	if letters > 0 {
+		f[1] = float64(upper) / float64(letters)
+	}
+	if len(s) > 0 {
+		f[2] = float64(digit) / float64(len(s))
+		f[3] = math.Min(float64(special)/float64(len(s))*10, 1)
+	}
+
+	// Authentication results.
+	a := m.Auth
+	if len(a.DKIMDomains) > 0 {
+		f[4] = 1
+	}
+	f[5] = math.Min(float64(len(a.DKIMDomains)), 3) / 3
+	switch a.SPF {
+	case "pass":
+		f[6] = 1
+	case "fail", "softfail":
+		f[7] = 1
+	}
+	if a.EHLOValidated {
+		f[8] = 1
+	}
+	if a.MailFromValidated {
+		f[9] = 1
+	}
+	if a.MsgFromValidated {
+		f[10] = 1
+	}
+	if a.Reputation != nil {
+		if *a.Reputation {
+			f[11] = 1
+		} else {
+			f[11] = -1
+		}
+	}
+
+	// Links, and number of distinct hosts they point to.
+	links := linkRegexp.FindAllString(s, -1)
+	hosts := map[string]struct{}{}
+	for _, l := range links {
+		if u, err := url.Parse(l); err == nil && u.Host != "" {
+			hosts[strings.ToLower(u.Hostname())] = struct{}{}
+		}
+	}
+	f[12] = math.Min(math.Log1p(float64(len(links)))/math.Log(101), 1)
+	f[13] = math.Min(math.Log1p(float64(len(hosts)))/math.Log(101), 1)
+
+	// HTML to text ratio, and whether there is only HTML.
+	if n := text.Len() + html.Len(); n > 0 {
+		f[14] = float64(html.Len()) / float64(n)
+	}
+	if html.Len() > 0 && len(strings.TrimSpace(text.String())) == 0 {
+		f[15] = 1
+	}
+
+	return features
+}
+
+// Maximum number of bytes read from each text part.
+const maxTextPartSize = 1024 * 1024
+
+// gatherText writes the contents of the text and html parts to text and html.
+func gatherText(p *message.Part, text, html *strings.Builder, depth int) {
+	if depth > 10 {
+		return
+	}
+	ct := p.MediaType + "/" + p.MediaSubType
+	if ct == "TEXT/HTML" {
+		io.Copy(html, io.LimitReader(p.ReaderUTF8OrBinary(), maxTextPartSize))
+		return
+	}
+	if ct == "/" || strings.HasPrefix(ct, "TEXT/") {
+		io.Copy(text, io.LimitReader(p.ReaderUTF8OrBinary(), maxTextPartSize))
+		return
+	}
+	if p.Message != nil {
+		if err := p.SetMessageReaderAt(); err == nil {
+			gatherText(p.Message, text, html, depth+1)
+		}
+		return
+	}
+	for i := range p.Parts {
+		gatherText(&p.Parts[i], text, html, depth+1)
+	}
+}
+
+// extractorNames returns the names of the registered extractors, for error messages.
+func extractorNames() []string {
+	extractors.Lock()
+	defer extractors.Unlock()
+	var l []string
+	for name := range extractors.m {
+		l = append(l, name)
+	}
+	sort.Strings(l)
+	return l
+}
@@ -2,11 +2,18 @@
 package reservoir
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"math"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
@@ -16,37 +23,161 @@ import (
 type FilterConfig struct {
 	ESNParams ESNParams    `sconf:"optional" sconf-doc:"Echo State Network parameters for reservoir computing."`
 	Persona   PersonaTrait `sconf:"optional" sconf-doc:"Personality traits for affective computing."`
-	
+
 	// Integration parameters
 	EnableReservoir bool    `sconf:"optional" sconf-doc:"Enable reservoir computing enhancement. Default: false."`
 	EnableAffective bool    `sconf:"optional" sconf-doc:"Enable affective computing. Default: false."`
 	ReservoirWeight float64 `sconf:"optional" sconf-doc:"Weight of reservoir prediction (0-1). Default: 0.3."`
-	
+
+	// Affective lexicons
+	Lexicons       []string   `sconf:"optional" sconf-doc:"Files with affective lexicons, with emotion keywords and common words for language detection for a language, relative to the config directory. A built-in English lexicon is always available, a lexicon for \"en\" replaces it. Affective analysis is skipped for messages in a language that does not match any lexicon."`
+	ParsedLexicons []*Lexicon `sconf:"-" json:"-"`
+
 	// Membrane computing
 	MembraneDepth int `sconf:"optional" sconf-doc:"Depth of P-system membrane hierarchy. Default: 3."`
+
+	// Feature extraction
+	FeatureExtractor string `sconf:"optional" sconf-doc:"Feature extractor that turns a message into input for the reservoir. \"standard\" uses hashed words from the junk filter tokenizer, text statistics, authentication results, reputation, links and the HTML to text ratio. \"basic\" uses text statistics and a list of English spam keywords. Changing the extractor replaces the model. Default: standard."`
+	FeatureWords     int    `sconf:"optional" sconf-doc:"Number of hashed word features for the standard feature extractor. Default: 64."`
+
+	// Online training
+	MaxSamples int `sconf:"optional" sconf-doc:"Maximum number of messages marked as junk or nonjunk to keep as training samples for the reservoir output weights. When exceeded, the oldest samples are removed. Default: 1000."`
+
+	// Per-sender sequences
+	SenderSequence    string        `sconf:"optional" sconf-doc:"Keep reservoir state per sender, so a sequence of messages from the same source drives the reservoir over time, instead of classifying each message from a reset reservoir. \"orgdomain\" keeps state per organizational domain of the message From address if it is validated by SPF or DKIM, and per remote IP /26 (IPv4) or /48 (IPv6) otherwise. \"ip\" always keeps state per remote IP /26 or /48. \"none\" disables per-sender state. States are kept in memory only. Default: none."`
+	SenderStates      int           `sconf:"optional" sconf-doc:"Maximum number of sender states kept for the account, the least recently used is removed first. Default: 1000."`
+	SenderStateExpiry time.Duration `sconf:"optional" sconf-doc:"Period after which a sender state that was not updated is discarded, and the next message from the sender starts from a reset reservoir. Default: 24h."`
 }
 
 // DefaultFilterConfig returns default configuration.
 func DefaultFilterConfig() FilterConfig {
 	return FilterConfig{
-		ESNParams:       DefaultESNParams(),
-		Persona:         DefaultPersonaTrait(),
-		EnableReservoir: false,
-		EnableAffective: false,
-		ReservoirWeight: 0.3,
-		MembraneDepth:   3,
+		ESNParams:        DefaultESNParams(),
+		Persona:          DefaultPersonaTrait(),
+		EnableReservoir:  false,
+		EnableAffective:  false,
+		ReservoirWeight:  0.3,
+		MembraneDepth:    3,
+		FeatureExtractor: "standard",
+		FeatureWords:     64,
+		MaxSamples:       1000,
+
+		SenderSequence:    "none",
+		SenderStates:      1000,
+		SenderStateExpiry: 24 * time.Hour,
+	}
+}
+
+// WithDefaults returns a copy of the configuration with unset (zero) numeric
+// parameters replaced by their defaults. Configurations read from a config file
+// typically only specify a few fields. Persona traits are kept as is, zero is a
+// valid value for each trait.
+func (c FilterConfig) WithDefaults() FilterConfig {
+	d := DefaultESNParams()
+	p := &c.ESNParams
+	if p.ReservoirSize == 0 {
+		p.ReservoirSize = d.ReservoirSize
+	}
+	if p.SpectralRadius == 0 {
+		p.SpectralRadius = d.SpectralRadius
+	}
+	if p.InputScaling == 0 {
+		p.InputScaling = d.InputScaling
+	}
+	if p.LeakRate == 0 {
+		p.LeakRate = d.LeakRate
+	}
+	if p.Sparsity == 0 {
+		p.Sparsity = d.Sparsity
+	}
+	if p.RidgeParam == 0 {
+		p.RidgeParam = d.RidgeParam
+	}
+	if p.TreeDepth == 0 {
+		p.TreeDepth = d.TreeDepth
+	}
+	if c.ReservoirWeight == 0 {
+		c.ReservoirWeight = 0.3
+	}
+	if c.MembraneDepth == 0 {
+		c.MembraneDepth = 3
+	}
+	if c.FeatureExtractor == "" {
+		c.FeatureExtractor = "standard"
+	}
+	if c.FeatureWords == 0 {
+		c.FeatureWords = 64
+	}
+	if c.MaxSamples == 0 {
+		c.MaxSamples = 1000
+	}
+	if c.SenderSequence == "" {
+		c.SenderSequence = "none"
 	}
+	if c.SenderStates == 0 {
+		c.SenderStates = 1000
+	}
+	if c.SenderStateExpiry == 0 {
+		c.SenderStateExpiry = 24 * time.Hour
+	}
+	return c
+}
+
+// Validate checks the configuration for parameters that NewReservoirFilter would
+// reject, or that cannot result in meaningful probabilities. Call on a
+// configuration with defaults applied.
+func (c FilterConfig) Validate() error {
+	p := c.ESNParams
+	if p.ReservoirSize <= 0 {
+		return fmt.Errorf("reservoir size must be positive")
+	}
+	if p.SpectralRadius <= 0 || p.SpectralRadius >= 1.0 {
+		return fmt.Errorf("spectral radius must be in (0, 1)")
+	}
+	if p.LeakRate <= 0 || p.LeakRate > 1.0 {
+		return fmt.Errorf("leak rate must be in (0, 1]")
+	}
+	if p.Sparsity < 0 || p.Sparsity > 1 {
+		return fmt.Errorf("sparsity must be in [0, 1]")
+	}
+	if c.ReservoirWeight < 0 || c.ReservoirWeight > 1 {
+		return fmt.Errorf("reservoir weight must be in [0, 1]")
+	}
+	if c.MembraneDepth < 0 {
+		return fmt.Errorf("membrane depth must be >= 0")
+	}
+	if c.MaxSamples < 0 {
+		return fmt.Errorf("max samples must be >= 0")
+	}
+	if c.FeatureWords < 0 {
+		return fmt.Errorf("feature words must be >= 0")
+	}
+	if _, err := NewExtractor(c); err != nil {
+		return err
+	}
+	switch c.SenderSequence {
+	case "none", "orgdomain", "ip":
+	default:
+		return fmt.Errorf("sender sequence must be none, orgdomain or ip")
+	}
+	if c.SenderStates < 0 {
+		return fmt.Errorf("sender states must be >= 0")
+	}
+	if c.SenderStateExpiry < 0 {
+		return fmt.Errorf("sender state expiry must be >= 0")
+	}
+	return nil
 }
 
 // DefaultPersonaTrait returns a balanced default persona.
 func DefaultPersonaTrait() PersonaTrait {
 	return PersonaTrait{
-		Valence:    0.2,  // Slightly positive
-		Arousal:    0.6,  // Moderately alert
-		Dominance:  0.5,  // Neutral control
-		Attention:  0.8,  // High attention
-		Memory:     0.7,  // Good memory
-		Creativity: 0.5,  // Moderate creativity
+		Valence:    0.2, // Slightly positive
+		Arousal:    0.6, // Moderately alert
+		Dominance:  0.5, // Neutral control
+		Attention:  0.8, // High attention
+		Memory:     0.7, // Good memory
+		Creativity: 0.5, // Moderate creativity
 	}
 }
 
@@ -54,26 +185,38 @@ func DefaultPersonaTrait() PersonaTrait {
 type ReservoirFilter struct {
 	config FilterConfig
 	log    mlog.Log
-	
+
+	extractor FeatureExtractor
+	states    *StateCache // Per-sender reservoir states, nil if not kept.
+
 	// Reservoir computing components
-	esn             *ESN
-	affectiveAgent  *AffectiveAgent
-	membraneSystem  *MembraneSystem
-	
+	esn            *ESN
+	affectiveAgent *AffectiveAgent
+	membraneSystem *MembraneSystem
+
 	// Statistics
 	messagesProcessed int
 	reservoirEnabled  bool
+
+	modelPath string // Empty if the model is not persisted.
 }
 
 // NewReservoirFilter creates a new reservoir-enhanced filter.
 func NewReservoirFilter(log mlog.Log, config FilterConfig) (*ReservoirFilter, error) {
+	extractor, err := NewExtractor(config)
+	if err != nil {
+		return nil, err
+	}
+	config.ESNParams.InputDim = extractor.Dim()
+
 	rf := &ReservoirFilter{
 		config:            config,
 		log:               log,
+		extractor:         extractor,
 		messagesProcessed: 0,
 		reservoirEnabled:  config.EnableReservoir,
 	}
-	
+
 	if config.EnableReservoir {
 		// Initialize ESN
 		esn, err := NewESN(log, config.ESNParams, config.Persona)
@@ -81,66 +224,301 @@ func NewReservoirFilter(log mlog.Log, config FilterConfig) (*ReservoirFilter, er
 			return nil, fmt.Errorf("creating ESN: %w", err)
 		}
 		rf.esn = esn
-		
+
 		// Initialize membrane system
 		rf.membraneSystem = NewMembraneSystem(config.MembraneDepth)
-		
-		log.Debug("reservoir computing initialized", 
+
+		log.Debug("reservoir computing initialized",
 			slog.Int("reservoir_size", config.ESNParams.ReservoirSize),
 			slog.Int("membrane_depth", config.MembraneDepth))
 	}
-	
+
 	if config.EnableAffective {
 		// Initialize affective agent
 		rf.affectiveAgent = NewAffectiveAgent(config.Persona)
+		rf.affectiveAgent.Lexicons = config.lexicons()
 		log.Debug("affective computing initialized")
 	}
-	
+
+	return rf, nil
+}
+
+// NewFilter creates a new reservoir-enhanced filter with a freshly generated
+// model, and writes the model to modelPath. The model file must not yet exist.
+// If the reservoir is not enabled, no model file is written.
+func NewFilter(ctx context.Context, log mlog.Log, config FilterConfig, modelPath string) (*ReservoirFilter, error) {
+	if _, err := os.Stat(modelPath); err == nil {
+		return nil, fmt.Errorf("model already exists on disk: %s", modelPath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("checking for existing model: %w", err)
+	}
+
+	rf, err := NewReservoirFilter(log, config)
+	if err != nil {
+		return nil, err
+	}
+	rf.modelPath = modelPath
+	if rf.esn == nil {
+		return rf, nil
+	}
+	if err := rf.Save(); err != nil {
+		return nil, fmt.Errorf("saving new model: %w", err)
+	}
 	return rf, nil
 }
 
+// OpenFilter opens an existing reservoir-enhanced filter, reading its model from
+// modelPath. If the reservoir is not enabled, the model file is not read.
+func OpenFilter(ctx context.Context, log mlog.Log, config FilterConfig, modelPath string) (*ReservoirFilter, error) {
+	extractor, err := NewExtractor(config)
+	if err != nil {
+		return nil, err
+	}
+	config.ESNParams.InputDim = extractor.Dim()
+
+	rf := &ReservoirFilter{
+		config:           config,
+		log:              log,
+		extractor:        extractor,
+		reservoirEnabled: config.EnableReservoir,
+		modelPath:        modelPath,
+	}
+
+	if config.EnableReservoir {
+		f, err := os.Open(modelPath)
+		if err != nil {
+			return nil, fmt.Errorf("open model: %w", err)
+		}
+		defer func() {
+			err := f.Close()
+			log.Check(err, "closing model file")
+		}()
+		esn, err := ReadESN(log, bufio.NewReader(f), config.ESNParams, config.Persona)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", modelPath, err)
+		}
+		rf.esn = esn
+		rf.membraneSystem = NewMembraneSystem(config.MembraneDepth)
+	}
+
+	if config.EnableAffective {
+		rf.affectiveAgent = NewAffectiveAgent(config.Persona)
+		rf.affectiveAgent.Lexicons = config.lexicons()
+	}
+
+	return rf, nil
+}
+
+// Save writes the model to disk, if the filter was opened with a model path and
+// the reservoir is enabled. The file is replaced atomically.
+func (rf *ReservoirFilter) Save() error {
+	if rf.modelPath == "" || rf.esn == nil {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(rf.modelPath), filepath.Base(rf.modelPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary model file: %w", err)
+	}
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			rf.log.Check(err, "closing temporary model file")
+			err = os.Remove(f.Name())
+			rf.log.Check(err, "removing temporary model file")
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if err := rf.esn.WriteModel(bw); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing model: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync model file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing model file: %w", err)
+	}
+	if err := os.Rename(f.Name(), rf.modelPath); err != nil {
+		xerr := os.Remove(f.Name())
+		rf.log.Check(xerr, "removing temporary model file")
+		f = nil
+		return fmt.Errorf("replacing model file: %w", err)
+	}
+	f = nil
+	return nil
+}
+
+// Reset resets the reservoir, membrane and affective state, so the next message
+// is classified like by a newly opened filter. Trained output weights are kept.
+func (rf *ReservoirFilter) Reset() {
+	if rf.esn != nil {
+		rf.esn.Reset()
+		rf.membraneSystem = NewMembraneSystem(rf.config.MembraneDepth)
+	}
+	if rf.affectiveAgent != nil {
+		lexicons := rf.affectiveAgent.Lexicons
+		rf.affectiveAgent = NewAffectiveAgent(rf.config.Persona)
+		rf.affectiveAgent.Lexicons = lexicons
+	}
+}
+
+// ESN returns the echo state network of the filter, nil if the reservoir is not
+// enabled.
+func (rf *ReservoirFilter) ESN() *ESN {
+	return rf.esn
+}
+
+// Config returns the configuration of the filter.
+func (rf *ReservoirFilter) Config() FilterConfig {
+	return rf.config
+}
+
+// SetStateCache sets the cache with per-sender reservoir states, used by Classify
+// for messages with a Sender. The cache is typically shared by filters for the
+// same account, so it must outlive a single filter.
+func (rf *ReservoirFilter) SetStateCache(states *StateCache) {
+	rf.states = states
+}
+
 // ClassifyResult contains classification results from the reservoir filter.
 type ClassifyResult struct {
-	BayesianProb    float64 // Probability from Bayesian filter
-	ReservoirProb   float64 // Probability from reservoir computing
-	AffectiveProb   float64 // Probability from affective analysis
-	CombinedProb    float64 // Combined probability
-	AffectiveState  *AffectiveState // Emotional state (if affective enabled)
-	MembraneObjects []Object // Objects from membrane processing
+	BayesianProb      float64         // Probability from Bayesian filter
+	ReservoirProb     float64         // Probability from reservoir computing
+	ReservoirTrained  bool            // Whether ReservoirProb is from trained output weights, instead of a heuristic.
+	AffectiveProb     float64         // Probability from affective analysis
+	AffectiveLanguage string          // Detected language for affective analysis, LanguageUnknown if no lexicon matched. Empty if affective is not enabled.
+	CombinedProb      float64         // Combined probability
+	AffectiveState    *AffectiveState // Emotional state (if affective enabled)
+	MembraneObjects   []Object        // Objects from membrane processing
 }
 
 // ClassifyMessage classifies a message using reservoir computing enhancement.
+// Only features from the message part are used, see Classify for passing
+// tokenized words and authentication results.
 func (rf *ReservoirFilter) ClassifyMessage(ctx context.Context, part *message.Part, bayesianProb float64) (*ClassifyResult, error) {
+	return rf.Classify(ctx, Message{Part: part}, bayesianProb)
+}
+
+// Features returns the feature vector for m from the feature extractor of the
+// filter, as used for classification. Store it in a Sample for training.
+func (rf *ReservoirFilter) Features(m Message) []float64 {
+	return rf.extractor.Features(m)
+}
+
+// Prepared is a message prepared for classification, with its features and text
+// extracted. Preparation does not use the state of a filter, so a filter that is
+// shared only has to be locked for ClassifyPrepared.
+type Prepared struct {
+	Message Message
+
+	reservoir bool      // Whether features were extracted.
+	affective bool      // Whether affectiveText was extracted.
+	features  []float64 // For the reservoir.
+	content   string    // For the membrane system.
+	affText   string    // For the affective agent.
+}
+
+// PrepareMessage extracts the features and text of m for classification by a
+// filter with configuration config.
+func PrepareMessage(config FilterConfig, m Message) (*Prepared, error) {
+	extractor, err := NewExtractor(config)
+	if err != nil {
+		return nil, err
+	}
+	return prepare(config, extractor, m), nil
+}
+
+func prepare(config FilterConfig, extractor FeatureExtractor, m Message) *Prepared {
+	var rf ReservoirFilter
+	p := &Prepared{Message: m, content: rf.extractTextContent(m.Part)}
+	if config.EnableReservoir {
+		p.reservoir = true
+		p.features = extractor.Features(m)
+	}
+	if config.EnableAffective {
+		p.affective = true
+		p.affText = affectiveText(m.Part)
+	}
+	return p
+}
+
+// Classify classifies a message using reservoir computing enhancement.
+//
+// If m has a Sender and a state cache is set, the reservoir continues from the
+// state left by the previous message from the sender, and the new state is
+// stored for the next message. Otherwise the reservoir is reset first, so each
+// message is classified in isolation.
+func (rf *ReservoirFilter) Classify(ctx context.Context, m Message, bayesianProb float64) (*ClassifyResult, error) {
+	return rf.ClassifyPrepared(ctx, prepare(rf.config, rf.extractor, m), bayesianProb)
+}
+
+// ClassifyPrepared is like Classify, for a message prepared with PrepareMessage.
+// If the message was prepared for another configuration, e.g. after the
+// configuration changed, it is prepared again.
+func (rf *ReservoirFilter) ClassifyPrepared(ctx context.Context, p *Prepared, bayesianProb float64) (*ClassifyResult, error) {
+	t0 := time.Now()
+
+	if rf.config.EnableReservoir && (!p.reservoir || len(p.features) != rf.extractor.Dim()) || rf.config.EnableAffective && !p.affective {
+		p = prepare(rf.config, rf.extractor, p.Message)
+	}
+	m := p.Message
+
 	result := &ClassifyResult{
 		BayesianProb: bayesianProb,
 		CombinedProb: bayesianProb, // Default to Bayesian if reservoir disabled
 	}
-	
-	// Extract text content from message
-	content := rf.extractTextContent(part)
-	
-	// Affective analysis
+
+	// Text content of the message, for the membrane system.
+	content := p.content
+
+	// Affective analysis. Messages in an unknown language are left out, their
+	// neutral state would only dilute the combined probability.
 	if rf.config.EnableAffective && rf.affectiveAgent != nil {
-		state := rf.affectiveAgent.ProcessMessage(ctx, content)
-		result.AffectiveState = &state
-		result.AffectiveProb = rf.affectiveAgent.GetSpamProbability()
-		
-		rf.log.Debug("affective analysis", 
-			slog.Float64("spam_prob", result.AffectiveProb),
-			slog.Float64("valence", state.Valence),
-			slog.Float64("arousal", state.Arousal))
-	}
-	
+		state := rf.affectiveAgent.ProcessMessage(ctx, p.affText)
+		result.AffectiveLanguage = state.Language
+		if state.Language == LanguageUnknown {
+			rf.log.Debug("affective analysis skipped for unknown language")
+		} else {
+			result.AffectiveState = &state
+			result.AffectiveProb = rf.affectiveAgent.GetSpamProbability()
+
+			rf.log.Debug("affective analysis",
+				slog.String("language", state.Language),
+				slog.Float64("spam_prob", result.AffectiveProb),
+				slog.Float64("valence", state.Valence),
+				slog.Float64("arousal", state.Arousal))
+		}
+	}
+
 	// Reservoir computing analysis
 	if rf.config.EnableReservoir && rf.esn != nil {
-		// Convert content to feature vector
-		features := rf.extractFeatures(content)
-		
+		features := p.features
+
+		// Start from the state of the sender, or a reset reservoir.
+		sequence := m.Sender != "" && rf.states != nil
+		var state []float64
+		if sequence {
+			state = rf.states.Get(m.Sender)
+		}
+		if state == nil {
+			rf.esn.Reset()
+		} else if err := rf.esn.SetState(state); err != nil {
+			// E.g. after the reservoir size changed.
+			rf.log.Debugx("restoring sender state, resetting reservoir", err)
+			rf.esn.Reset()
+		}
+
 		// Update ESN with features
 		if err := rf.esn.Update(ctx, features); err != nil {
 			return nil, fmt.Errorf("updating ESN: %w", err)
 		}
-		
+		if sequence {
+			rf.states.Put(m.Sender, rf.esn.GetState())
+		}
+
 		// Process through membrane system
 		if rf.membraneSystem != nil {
 			if err := rf.processMembraneSystem(content); err != nil {
@@ -148,15 +526,16 @@ func (rf *ReservoirFilter) ClassifyMessage(ctx context.Context, part *message.Pa
 			}
 			result.MembraneObjects = rf.membraneSystem.CollectResults()
 		}
-		
+
 		// Get prediction (if trained)
-		if rf.esn.trained {
+		if rf.esn.Trained() {
+			result.ReservoirTrained = true
 			prediction, err := rf.esn.Predict(ctx)
 			if err == nil && len(prediction) > 0 {
 				// First output is spam probability
 				result.ReservoirProb = sigmoid(prediction[0])
-				
-				rf.log.Debug("reservoir prediction", 
+
+				rf.log.Debug("reservoir prediction",
 					slog.Float64("spam_prob", result.ReservoirProb))
 			}
 		} else {
@@ -170,25 +549,35 @@ func (rf *ReservoirFilter) ClassifyMessage(ctx context.Context, part *message.Pa
 			result.ReservoirProb = sigmoid(activation)
 		}
 	}
-	
+
 	// Combine predictions
 	result.CombinedProb = rf.combinePredictions(result)
-	
+
 	rf.messagesProcessed++
-	
+
+	metricClassify.Observe(float64(time.Since(t0)) / float64(time.Second))
+	metricProbability.WithLabelValues("bayesian").Observe(result.BayesianProb)
+	if rf.config.EnableReservoir && rf.esn != nil {
+		metricProbability.WithLabelValues("reservoir").Observe(result.ReservoirProb)
+	}
+	if result.AffectiveState != nil {
+		metricProbability.WithLabelValues("affective").Observe(result.AffectiveProb)
+	}
+	metricProbability.WithLabelValues("combined").Observe(result.CombinedProb)
+
 	return result, nil
 }
 
 // extractTextContent extracts text content from a message part.
 func (rf *ReservoirFilter) extractTextContent(part *message.Part) string {
 	var content strings.Builder
-	
+
 	// Extract subject
 	if part.Envelope != nil && part.Envelope.Subject != "" {
 		content.WriteString(part.Envelope.Subject)
 		content.WriteString(" ")
 	}
-	
+
 	// Extract body (simplified - reads up to 1MB)
 	// For production, would need full MIME handling and charset decoding
 	reader := part.Reader()
@@ -199,19 +588,38 @@ func (rf *ReservoirFilter) extractTextContent(part *message.Part) string {
 			content.Write(buf[:n])
 		}
 	}
-	
+
 	return content.String()
 }
 
+// htmlTagRegexp matches HTML tags, removed for affective analysis.
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// affectiveText returns the subject and the decoded text of a message for
+// affective analysis. HTML is only used if there is no plain text, with tags
+// removed.
+func affectiveText(part *message.Part) string {
+	var subject string
+	if part.Envelope != nil {
+		subject = part.Envelope.Subject
+	}
+	var text, html strings.Builder
+	gatherText(part, &text, &html, 0)
+	if strings.TrimSpace(text.String()) == "" {
+		return subject + "\n" + htmlTagRegexp.ReplaceAllString(html.String(), " ")
+	}
+	return subject + "\n" + text.String()
+}
+
 // extractFeatures extracts feature vector from text content.
 func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 	features := make([]float64, 10) // Fixed-size feature vector
-	
+
 	lower := strings.ToLower(content)
-	
+
 	// Feature 0: Length (normalized)
 	features[0] = math.Min(float64(len(content))/1000.0, 1.0)
-	
+
 	// Feature 1: Uppercase ratio
 	upperCount := 0
 	for _, r := range content {
@@ -222,7 +630,7 @@ func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 	if len(content) > 0 {
 		features[1] = float64(upperCount) / float64(len(content))
 	}
-	
+
 	// Feature 2: Digit ratio
 	digitCount := 0
 	for _, r := range content {
@@ -233,13 +641,13 @@ func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 	if len(content) > 0 {
 		features[2] = float64(digitCount) / float64(len(content))
 	}
-	
+
 	// Feature 3: Special character ratio
 	specialCount := strings.Count(content, "!") + strings.Count(content, "$") + strings.Count(content, "%")
 	if len(content) > 0 {
 		features[3] = float64(specialCount) / float64(len(content)) * 10.0
 	}
-	
+
 	// Feature 4-9: Spam keyword indicators
 	spamKeywords := [][]string{
 		{"free", "buy", "click"},
@@ -249,7 +657,7 @@ func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 		{"loan", "credit", "debt"},
 		{"make money", "work from home", "earn"},
 	}
-	
+
 	for i, keywords := range spamKeywords {
 		count := 0.0
 		for _, kw := range keywords {
@@ -259,7 +667,7 @@ func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 		}
 		features[4+i] = count
 	}
-	
+
 	return features
 }
 
@@ -267,7 +675,7 @@ func (rf *ReservoirFilter) extractFeatures(content string) []float64 {
 func (rf *ReservoirFilter) processMembraneSystem(content string) error {
 	// Inject objects into root membrane based on content analysis
 	lower := strings.ToLower(content)
-	
+
 	// Positive signals
 	positiveKeywords := []string{"thank", "please", "regards", "sincerely"}
 	for _, kw := range positiveKeywords {
@@ -281,7 +689,7 @@ func (rf *ReservoirFilter) processMembraneSystem(content string) error {
 			rf.membraneSystem.InjectObject("root", obj)
 		}
 	}
-	
+
 	// Negative signals (spam indicators)
 	negativeKeywords := []string{"click here", "buy now", "free money", "act now"}
 	for _, kw := range negativeKeywords {
@@ -295,35 +703,43 @@ func (rf *ReservoirFilter) processMembraneSystem(content string) error {
 			rf.membraneSystem.InjectObject("root", obj)
 		}
 	}
-	
+
 	// Perform evolution steps
 	for i := 0; i < 3; i++ {
 		if err := rf.membraneSystem.Step(); err != nil {
 			return err
 		}
 	}
-	
+
 	return nil
 }
 
 // combinePredictions combines predictions from different sources.
 func (rf *ReservoirFilter) combinePredictions(result *ClassifyResult) float64 {
+	return rf.config.Combine(result)
+}
+
+// Combine returns the combined probability of the individual probabilities in
+// result, as used by ClassifyMessage. Useful for evaluating the effect of other
+// weights without classifying messages again.
+func (c FilterConfig) Combine(result *ClassifyResult) float64 {
 	// Start with Bayesian
 	combined := result.BayesianProb
-	
-	// Add reservoir if enabled
-	if rf.config.EnableReservoir && result.ReservoirProb > 0 {
+
+	// Add reservoir if enabled. The heuristic probability of an untrained reservoir
+	// is only informational, it must not change the verdict.
+	if c.EnableReservoir && result.ReservoirTrained && result.ReservoirProb > 0 {
 		// Weighted combination
-		w := rf.config.ReservoirWeight
+		w := c.ReservoirWeight
 		combined = (1-w)*result.BayesianProb + w*result.ReservoirProb
 	}
-	
+
 	// Add affective if enabled
-	if rf.config.EnableAffective && result.AffectiveProb > 0 {
+	if c.EnableAffective && result.AffectiveProb > 0 {
 		// Affective gets small weight
 		combined = 0.8*combined + 0.2*result.AffectiveProb
 	}
-	
+
 	return combined
 }
 
@@ -338,16 +754,16 @@ func (rf *ReservoirFilter) GetStats() map[string]interface{} {
 		"messages_processed": rf.messagesProcessed,
 		"reservoir_enabled":  rf.reservoirEnabled,
 	}
-	
+
 	if rf.esn != nil {
 		stats["esn_trained"] = rf.esn.trained
 		stats["reservoir_size"] = rf.config.ESNParams.ReservoirSize
 	}
-	
+
 	if rf.membraneSystem != nil {
 		stats["membrane_depth"] = rf.config.MembraneDepth
 		stats["membrane_steps"] = rf.membraneSystem.StepCount
 	}
-	
+
 	return stats
 }
@@ -0,0 +1,204 @@
+package reservoir
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mjl-/sconf"
+)
+
+// LanguageUnknown is the language reported for content that does not match any
+// of the lexicons.
+const LanguageUnknown = "unknown"
+
+// Lexicon has keywords indicating emotions for a language, used for affective
+// analysis, and common words used to detect the language of a message.
+//
+// Keywords are matched against whole words of the lowercased text, and can consist
+// of multiple words, e.g. "click here". A keyword ending with "*" matches words
+// starting with the keyword, e.g. "enttäusch*" for "enttäuscht" and
+// "enttäuschend". Lexicons are read from files in sconf format, see ReadLexicon.
+type Lexicon struct {
+	Language string   `sconf-doc:"Language of the lexicon, e.g. \"nl\" for Dutch. A lexicon for \"en\" replaces the built-in English lexicon."`
+	Words    []string `sconf-doc:"Common words of the language, e.g. articles, pronouns and prepositions. Used for detecting the language of a message. Should not include words that are also common in other languages."`
+	Joy      []string `sconf:"optional" sconf-doc:"Keywords indicating joy."`
+	Sadness  []string `sconf:"optional" sconf-doc:"Keywords indicating sadness."`
+	Anger    []string `sconf:"optional" sconf-doc:"Keywords indicating anger."`
+	Fear     []string `sconf:"optional" sconf-doc:"Keywords indicating fear."`
+	Disgust  []string `sconf:"optional" sconf-doc:"Keywords indicating disgust."`
+	Interest []string `sconf:"optional" sconf-doc:"Keywords indicating interest."`
+	Surprise []string `sconf:"optional" sconf-doc:"Keywords indicating surprise."`
+	Spam     []string `sconf:"optional" sconf-doc:"Keywords typical for spam, counted as disgust and anger."`
+
+	words map[string]struct{} // From Words, for language detection.
+}
+
+// EnglishLexicon is the built-in lexicon, used when no lexicon for "en" is
+// configured.
+var EnglishLexicon = &Lexicon{
+	Language: "en",
+	Words:    []string{"the", "and", "of", "to", "is", "are", "was", "you", "your", "we", "our", "it", "this", "that", "for", "with", "have", "has", "be", "will", "not", "on", "at", "from", "by", "my", "me", "i", "a", "an", "or", "can", "here", "there", "what", "if", "all", "now", "very", "thank", "please"},
+	Joy:      []string{"happy", "joy", "great", "excellent", "wonderful", "love", "pleased", "delighted"},
+	Sadness:  []string{"sad", "unhappy", "disappointed", "unfortunate", "regret", "sorry"},
+	Anger:    []string{"angry", "furious", "outraged", "mad", "annoyed", "frustrated", "hate"},
+	Fear:     []string{"afraid", "scared", "worried", "anxious", "nervous", "concerned", "fear"},
+	Disgust:  []string{"disgusting", "revolting", "nasty", "awful", "terrible", "horrible"},
+	Interest: []string{"interesting", "curious", "wonder", "question", "inquiry", "explore"},
+	Surprise: []string{"surprise", "unexpected", "amazing", "astonishing", "shocking", "wow"},
+	Spam:     []string{"click here", "buy now", "free", "urgent", "limited time", "act now", "winner"},
+}
+
+func init() {
+	if err := EnglishLexicon.prepare(); err != nil {
+		panic(fmt.Sprintf("built-in lexicon: %v", err))
+	}
+}
+
+// ReadLexicon reads a lexicon from a file in sconf format, e.g.:
+//
+//	Language: nl
+//	Words:
+//		- het
+//		- een
+//	Joy:
+//		- blij
+//	Spam:
+//		- klik hier
+func ReadLexicon(path string) (*Lexicon, error) {
+	var l Lexicon
+	if err := sconf.ParseFile(path, &l); err != nil {
+		return nil, fmt.Errorf("parsing lexicon %s: %w", path, err)
+	}
+	if err := l.prepare(); err != nil {
+		return nil, fmt.Errorf("lexicon %s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// prepare validates the lexicon and normalizes its keywords.
+func (l *Lexicon) prepare() error {
+	if l.Language == "" || l.Language == LanguageUnknown {
+		return fmt.Errorf("invalid language %q", l.Language)
+	}
+	if len(l.Words) == 0 {
+		return fmt.Errorf("missing words for language detection")
+	}
+	l.words = map[string]struct{}{}
+	for _, w := range l.Words {
+		t := tokenize(w)
+		if len(t) != 1 {
+			return fmt.Errorf("word %q for language detection must be a single word", w)
+		}
+		l.words[t[0]] = struct{}{}
+	}
+	for _, kws := range []*[]string{&l.Joy, &l.Sadness, &l.Anger, &l.Fear, &l.Disgust, &l.Interest, &l.Surprise, &l.Spam} {
+		for i, kw := range *kws {
+			prefix := strings.HasSuffix(kw, "*")
+			t := tokenize(strings.TrimSuffix(kw, "*"))
+			if len(t) == 0 {
+				return fmt.Errorf("empty keyword %q", kw)
+			}
+			s := strings.Join(t, " ")
+			if prefix {
+				s += "*"
+			}
+			(*kws)[i] = s
+		}
+	}
+	return nil
+}
+
+// count returns the number of keywords that occur in text, as returned by
+// wordText.
+func (l *Lexicon) count(text string, keywords []string) float64 {
+	var n float64
+	for _, kw := range keywords {
+		var s string
+		if strings.HasSuffix(kw, "*") {
+			s = " " + strings.TrimSuffix(kw, "*")
+		} else {
+			s = " " + kw + " "
+		}
+		if strings.Contains(text, s) {
+			n++
+		}
+	}
+	return n
+}
+
+// Maximum number of words used for language detection and keyword matching.
+const maxLexiconWords = 2000
+
+// tokenize returns the lowercased words in s, split on non-letters.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c)
+	})
+}
+
+// wordText returns words as a single string with a space before and after each
+// word, for matching keywords on word boundaries.
+func wordText(words []string) string {
+	return " " + strings.Join(words, " ") + " "
+}
+
+// DetectLanguage returns the lexicon whose common words make up the largest part
+// of words, or nil if the language is unknown, i.e. fewer than 2 or less than 10%
+// of the words match any lexicon. On a tie, the first lexicon wins.
+func DetectLanguage(lexicons []*Lexicon, words []string) *Lexicon {
+	if len(words) > maxLexiconWords {
+		words = words[:maxLexiconWords]
+	}
+	var best *Lexicon
+	var bestn int
+	for _, l := range lexicons {
+		var n int
+		for _, w := range words {
+			if _, ok := l.words[w]; ok {
+				n++
+			}
+		}
+		if n > bestn {
+			best = l
+			bestn = n
+		}
+	}
+	if bestn < 2 || bestn*10 < len(words) {
+		return nil
+	}
+	return best
+}
+
+// lexicons returns the built-in English lexicon followed by the configured
+// lexicons, with a configured lexicon for "en" replacing the built-in lexicon.
+func (c FilterConfig) lexicons() []*Lexicon {
+	l := []*Lexicon{EnglishLexicon}
+	for _, x := range c.ParsedLexicons {
+		if x.Language == "en" {
+			l[0] = x
+		} else {
+			l = append(l, x)
+		}
+	}
+	return l
+}
+
+// LoadLexicons reads the lexicon files in Lexicons, with relative paths resolved
+// by fn, e.g. relative to the config directory, and sets ParsedLexicons.
+func (c *FilterConfig) LoadLexicons(fn func(path string) string) error {
+	c.ParsedLexicons = nil
+	seen := map[string]bool{}
+	for _, p := range c.Lexicons {
+		l, err := ReadLexicon(fn(p))
+		if err != nil {
+			return err
+		}
+		if seen[l.Language] {
+			return fmt.Errorf("duplicate lexicon for language %q", l.Language)
+		}
+		seen[l.Language] = true
+		c.ParsedLexicons = append(c.ParsedLexicons, l)
+	}
+	return nil
+}
@@ -9,13 +9,13 @@ import (
 // Membrane represents a P-system membrane in the hierarchical computing structure.
 // Membranes contain objects and evolution rules, and interact through permeability.
 type Membrane struct {
-	ID           string         // Unique identifier
-	Level        int            // Depth level in hierarchy (0 = outermost)
-	Permeability float64        // How easily objects pass through (0-1)
-	Objects      []Object       // Objects contained in this membrane
+	ID           string          // Unique identifier
+	Level        int             // Depth level in hierarchy (0 = outermost)
+	Permeability float64         // How easily objects pass through (0-1)
+	Objects      []Object        // Objects contained in this membrane
 	Rules        []EvolutionRule // Evolution rules for this membrane
-	Parent       *Membrane      // Parent membrane (nil for root)
-	Children     []*Membrane    // Child membranes
+	Parent       *Membrane       // Parent membrane (nil for root)
+	Children     []*Membrane     // Child membranes
 }
 
 // Object represents a computational object in a membrane.
@@ -29,11 +29,11 @@ type Object struct {
 // EvolutionRule represents a P-system evolution rule.
 // Rules transform objects within or across membranes.
 type EvolutionRule struct {
-	Name        string   // Rule identifier
-	Priority    int      // Execution priority (higher = first)
-	InputTypes  []string // Required input object types
-	OutputTypes []string // Produced output object types
-	Conditions  []string // Conditions for rule activation
+	Name        string                  // Rule identifier
+	Priority    int                     // Execution priority (higher = first)
+	InputTypes  []string                // Required input object types
+	OutputTypes []string                // Produced output object types
+	Conditions  []string                // Conditions for rule activation
 	Transform   func([]Object) []Object // Transformation function
 }
 
@@ -71,7 +71,7 @@ func (m *Membrane) Evolve() error {
 	// Sort rules by priority
 	sortedRules := make([]EvolutionRule, len(m.Rules))
 	copy(sortedRules, m.Rules)
-	
+
 	// Simple bubble sort by priority
 	for i := 0; i < len(sortedRules); i++ {
 		for j := i + 1; j < len(sortedRules); j++ {
@@ -80,11 +80,11 @@ func (m *Membrane) Evolve() error {
 			}
 		}
 	}
-	
+
 	// Apply rules
 	newObjects := make([]Object, 0)
 	usedObjects := make(map[int]bool)
-	
+
 	for _, rule := range sortedRules {
 		// Find matching objects for this rule
 		matches := m.findMatches(rule, usedObjects)
@@ -93,13 +93,13 @@ func (m *Membrane) Evolve() error {
 			for _, idx := range match {
 				usedObjects[idx] = true
 			}
-			
+
 			// Get input objects
 			inputObjs := make([]Object, len(match))
 			for i, idx := range match {
 				inputObjs[i] = m.Objects[idx]
 			}
-			
+
 			// Apply transformation
 			if rule.Transform != nil {
 				outputObjs := rule.Transform(inputObjs)
@@ -107,7 +107,7 @@ func (m *Membrane) Evolve() error {
 			}
 		}
 	}
-	
+
 	// Keep unused objects and add new ones
 	remainingObjects := make([]Object, 0)
 	for i, obj := range m.Objects {
@@ -116,25 +116,25 @@ func (m *Membrane) Evolve() error {
 		}
 	}
 	m.Objects = append(remainingObjects, newObjects...)
-	
+
 	return nil
 }
 
 // findMatches finds sets of objects that match the rule's input requirements.
 func (m *Membrane) findMatches(rule EvolutionRule, usedObjects map[int]bool) [][]int {
 	matches := make([][]int, 0)
-	
+
 	if len(rule.InputTypes) == 0 {
 		return matches
 	}
-	
+
 	// Simple implementation: find first complete match
 	match := make([]int, 0)
 	typeNeeded := make(map[string]bool)
 	for _, t := range rule.InputTypes {
 		typeNeeded[t] = true
 	}
-	
+
 	for i, obj := range m.Objects {
 		if usedObjects[i] {
 			continue
@@ -148,7 +148,7 @@ func (m *Membrane) findMatches(rule EvolutionRule, usedObjects map[int]bool) [][
 			}
 		}
 	}
-	
+
 	return matches
 }
 
@@ -157,7 +157,7 @@ func (m *Membrane) PassObjects(target *Membrane) error {
 	if target == nil {
 		return fmt.Errorf("target membrane is nil")
 	}
-	
+
 	remaining := make([]Object, 0)
 	for _, obj := range m.Objects {
 		// Probability of passing through membrane
@@ -166,7 +166,7 @@ func (m *Membrane) PassObjects(target *Membrane) error {
 			// Charged objects are more likely to move
 			passProb *= 1.2
 		}
-		
+
 		if passProb > 0.5 { // Simplified threshold
 			target.AddObject(obj)
 		} else {
@@ -174,7 +174,7 @@ func (m *Membrane) PassObjects(target *Membrane) error {
 		}
 	}
 	m.Objects = remaining
-	
+
 	return nil
 }
 
@@ -188,7 +188,7 @@ func (m *Membrane) ComputeDissolution() bool {
 			chargedCount++
 		}
 	}
-	
+
 	threshold := 10 // Arbitrary threshold
 	return chargedCount > threshold
 }
@@ -196,7 +196,7 @@ func (m *Membrane) ComputeDissolution() bool {
 // CreateDefaultRules creates default evolution rules for email processing.
 func CreateDefaultRules() []EvolutionRule {
 	rules := make([]EvolutionRule, 0)
-	
+
 	// Rule 1: Spam detection - transform high-value negative objects
 	spamRule := EvolutionRule{
 		Name:        "spam_detection",
@@ -220,7 +220,7 @@ func CreateDefaultRules() []EvolutionRule {
 		},
 	}
 	rules = append(rules, spamRule)
-	
+
 	// Rule 2: Ham detection - transform positive signals
 	hamRule := EvolutionRule{
 		Name:        "ham_detection",
@@ -243,7 +243,7 @@ func CreateDefaultRules() []EvolutionRule {
 		},
 	}
 	rules = append(rules, hamRule)
-	
+
 	// Rule 3: Affective modulation - adjust scores based on emotional context
 	affectiveRule := EvolutionRule{
 		Name:        "affective_modulation",
@@ -263,15 +263,15 @@ func CreateDefaultRules() []EvolutionRule {
 		},
 	}
 	rules = append(rules, affectiveRule)
-	
+
 	return rules
 }
 
 // MembraneSystem represents a complete P-system with hierarchical membranes.
 type MembraneSystem struct {
-	Root     *Membrane   // Root membrane
-	All      []*Membrane // All membranes in system
-	StepCount int        // Number of evolution steps performed
+	Root      *Membrane   // Root membrane
+	All       []*Membrane // All membranes in system
+	StepCount int         // Number of evolution steps performed
 }
 
 // NewMembraneSystem creates a new membrane system with hierarchical structure.
@@ -282,10 +282,10 @@ func NewMembraneSystem(depth int) *MembraneSystem {
 		All:       []*Membrane{root},
 		StepCount: 0,
 	}
-	
+
 	// Build hierarchical structure
 	system.buildHierarchy(root, depth, 1)
-	
+
 	return system
 }
 
@@ -294,21 +294,21 @@ func (ms *MembraneSystem) buildHierarchy(parent *Membrane, maxDepth, currentDept
 	if currentDepth >= maxDepth {
 		return
 	}
-	
+
 	// Create 2 children at each level (binary tree)
 	for i := 0; i < 2; i++ {
 		id := fmt.Sprintf("%s_%d", parent.ID, i)
 		permeability := 0.5 + 0.1*float64(currentDepth) // Deeper = more permeable
 		child := NewMembrane(id, currentDepth, permeability)
-		
+
 		parent.AddChild(child)
 		ms.All = append(ms.All, child)
-		
+
 		// Add default rules
 		for _, rule := range CreateDefaultRules() {
 			child.AddRule(rule)
 		}
-		
+
 		// Recurse
 		ms.buildHierarchy(child, maxDepth, currentDepth+1)
 	}
@@ -322,7 +322,7 @@ func (ms *MembraneSystem) Step() error {
 			return fmt.Errorf("evolving membrane %s: %w", membrane.ID, err)
 		}
 	}
-	
+
 	// Pass objects between membranes
 	for _, membrane := range ms.All {
 		if membrane.Parent != nil {
@@ -331,7 +331,7 @@ func (ms *MembraneSystem) Step() error {
 				return fmt.Errorf("passing objects from %s to parent: %w", membrane.ID, err)
 			}
 		}
-		
+
 		// Objects can pass to children
 		for _, child := range membrane.Children {
 			if err := membrane.PassObjects(child); err != nil {
@@ -339,7 +339,7 @@ func (ms *MembraneSystem) Step() error {
 			}
 		}
 	}
-	
+
 	ms.StepCount++
 	return nil
 }
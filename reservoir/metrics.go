@@ -0,0 +1,36 @@
+package reservoir
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricProbability = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_reservoir_probability",
+			Help:    "Junk probabilities of classified messages, by source: bayesian, reservoir, affective, combined.",
+			Buckets: []float64{0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5, 0.55, 0.6, 0.65, 0.7, 0.75, 0.8, 0.85, 0.9, 0.95, 1},
+		},
+		[]string{
+			"source",
+		},
+	)
+	metricClassify = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mox_reservoir_classify_duration_seconds",
+			Help:    "Duration of classifying a message with the reservoir filter, excluding parsing the message.",
+			Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+		},
+	)
+	metricTraining = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_reservoir_training_duration_seconds",
+			Help:    "Retraining of reservoir output weights, with result: trained, untrained (not enough samples of both junk and nonjunk), error.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30},
+		},
+		[]string{
+			"result",
+		},
+	)
+)
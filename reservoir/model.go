@@ -0,0 +1,150 @@
+package reservoir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// modelVersion is the version of the on-disk model format, incremented for
+// incompatible changes.
+const modelVersion = 1
+
+// ErrModelMismatch is returned by ReadESN when a stored model does not match the
+// configured parameters, e.g. after changing the reservoir size or seed.
+var ErrModelMismatch = errors.New("model does not match configuration")
+
+// model is the on-disk (JSON) representation of an ESN. The reservoir and input
+// weights are stored in full, even though they can be regenerated from the seed,
+// so changes to the initialization code don't invalidate trained output weights.
+type model struct {
+	Version          int
+	Seed             int64
+	ReservoirSize    int
+	Trained          bool
+	InputWeights     [][]float64
+	ReservoirWeights [][]float64
+	OutputWeights    [][]float64 `json:",omitempty"`
+
+	// Number of samples and highest sample ID the output weights were trained on.
+	TrainedSamples      int   `json:",omitempty"`
+	TrainedLastSampleID int64 `json:",omitempty"`
+}
+
+// WriteModel writes the seed and weights of the network to w. The current
+// reservoir state is not stored.
+func (esn *ESN) WriteModel(w io.Writer) error {
+	esn.mu.RLock()
+	defer esn.mu.RUnlock()
+
+	m := model{
+		Version:          modelVersion,
+		Seed:             esn.seed,
+		ReservoirSize:    esn.params.ReservoirSize,
+		Trained:          esn.trained,
+		InputWeights:     esn.inputWeights,
+		ReservoirWeights: esn.reservoirWeights,
+		OutputWeights:    esn.outputWeights,
+
+		TrainedSamples:      esn.trainedSamples,
+		TrainedLastSampleID: esn.trainedLastSampleID,
+	}
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		return fmt.Errorf("writing model: %w", err)
+	}
+	return nil
+}
+
+// ReadESN reads a network previously written with WriteModel. The reservoir size
+// in params, and the seed and input dimension if nonzero, must match the stored
+// model, otherwise an error wrapping ErrModelMismatch is returned.
+func ReadESN(log mlog.Log, r io.Reader, params ESNParams, persona PersonaTrait) (*ESN, error) {
+	m, err := readModel(r)
+	if err != nil {
+		return nil, err
+	}
+	if m.ReservoirSize != params.ReservoirSize {
+		return nil, fmt.Errorf("%w: model has reservoir size %d, configuration has %d", ErrModelMismatch, m.ReservoirSize, params.ReservoirSize)
+	}
+	if params.Seed != 0 && m.Seed != params.Seed {
+		return nil, fmt.Errorf("%w: model has seed %d, configuration has %d", ErrModelMismatch, m.Seed, params.Seed)
+	}
+	if params.InputDim > 0 && (len(m.InputWeights) == 0 || len(m.InputWeights[0]) != params.InputDim) {
+		var dim int
+		if len(m.InputWeights) > 0 {
+			dim = len(m.InputWeights[0])
+		}
+		return nil, fmt.Errorf("%w: model has input dimension %d, feature extractor has %d", ErrModelMismatch, dim, params.InputDim)
+	}
+
+	params.Seed = m.Seed
+	esn := &ESN{
+		params:           params,
+		persona:          persona,
+		inputWeights:     m.InputWeights,
+		reservoirWeights: m.ReservoirWeights,
+		outputWeights:    m.OutputWeights,
+		state:            make([]float64, params.ReservoirSize),
+		seed:             m.Seed,
+		rng:              rand.New(rand.NewSource(m.Seed)),
+		log:              log,
+		trained:          m.Trained,
+
+		trainedSamples:      m.TrainedSamples,
+		trainedLastSampleID: m.TrainedLastSampleID,
+	}
+	esn.initializeMembranes()
+	return esn, nil
+}
+
+// CheckModel reads a model and checks it for consistency.
+func CheckModel(r io.Reader) error {
+	_, err := readModel(r)
+	return err
+}
+
+func readModel(r io.Reader) (model, error) {
+	var m model
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, fmt.Errorf("reading model: %w", err)
+	}
+	if m.Version != modelVersion {
+		return m, fmt.Errorf("unsupported model version %d, expected %d", m.Version, modelVersion)
+	}
+	n := m.ReservoirSize
+	if n <= 0 {
+		return m, fmt.Errorf("model has invalid reservoir size %d", n)
+	}
+	if len(m.ReservoirWeights) != n {
+		return m, fmt.Errorf("model has %d reservoir weight rows, expected %d", len(m.ReservoirWeights), n)
+	}
+	for _, row := range m.ReservoirWeights {
+		if len(row) != n {
+			return m, fmt.Errorf("model has reservoir weight row of length %d, expected %d", len(row), n)
+		}
+	}
+	if len(m.InputWeights) > 0 {
+		if len(m.InputWeights) != n {
+			return m, fmt.Errorf("model has %d input weight rows, expected %d", len(m.InputWeights), n)
+		}
+		dim := len(m.InputWeights[0])
+		for _, row := range m.InputWeights {
+			if dim == 0 || len(row) != dim {
+				return m, fmt.Errorf("model has inconsistent input weight dimensions")
+			}
+		}
+	}
+	if m.Trained && len(m.OutputWeights) == 0 {
+		return m, fmt.Errorf("model is marked trained but has no output weights")
+	}
+	for _, row := range m.OutputWeights {
+		if len(row) != n {
+			return m, fmt.Errorf("model has output weight row of length %d, expected %d", len(row), n)
+		}
+	}
+	return m, nil
+}
@@ -2,8 +2,18 @@ package reservoir
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 )
 
@@ -11,20 +21,20 @@ func TestNewESN(t *testing.T) {
 	log := mlog.New("test", nil)
 	params := DefaultESNParams()
 	persona := DefaultPersonaTrait()
-	
+
 	esn, err := NewESN(log, params, persona)
 	if err != nil {
 		t.Fatalf("failed to create ESN: %v", err)
 	}
-	
+
 	if esn == nil {
 		t.Fatal("ESN is nil")
 	}
-	
+
 	if len(esn.state) != params.ReservoirSize {
 		t.Errorf("expected state size %d, got %d", params.ReservoirSize, len(esn.state))
 	}
-	
+
 	if len(esn.membranes) == 0 {
 		t.Error("expected membranes to be initialized")
 	}
@@ -35,21 +45,21 @@ func TestESNUpdate(t *testing.T) {
 	params := DefaultESNParams()
 	params.ReservoirSize = 50 // Smaller for testing
 	persona := DefaultPersonaTrait()
-	
+
 	esn, err := NewESN(log, params, persona)
 	if err != nil {
 		t.Fatalf("failed to create ESN: %v", err)
 	}
-	
+
 	// Create input
 	input := []float64{0.5, 0.3, 0.8, 0.1, 0.9}
-	
+
 	// Update state
 	err = esn.Update(context.Background(), input)
 	if err != nil {
 		t.Fatalf("failed to update ESN: %v", err)
 	}
-	
+
 	// Check state changed
 	state := esn.GetState()
 	hasNonZero := false
@@ -59,7 +69,7 @@ func TestESNUpdate(t *testing.T) {
 			break
 		}
 	}
-	
+
 	if !hasNonZero {
 		t.Error("expected some non-zero state values after update")
 	}
@@ -70,22 +80,22 @@ func TestESNReset(t *testing.T) {
 	params := DefaultESNParams()
 	params.ReservoirSize = 30
 	persona := DefaultPersonaTrait()
-	
+
 	esn, err := NewESN(log, params, persona)
 	if err != nil {
 		t.Fatalf("failed to create ESN: %v", err)
 	}
-	
+
 	// Update with some input
 	input := []float64{0.5, 0.3, 0.8}
 	err = esn.Update(context.Background(), input)
 	if err != nil {
 		t.Fatalf("failed to update ESN: %v", err)
 	}
-	
+
 	// Reset
 	esn.Reset()
-	
+
 	// Check all state is zero
 	state := esn.GetState()
 	for i, s := range state {
@@ -100,31 +110,31 @@ func TestESNTrainOutput(t *testing.T) {
 	params := DefaultESNParams()
 	params.ReservoirSize = 20
 	persona := DefaultPersonaTrait()
-	
+
 	esn, err := NewESN(log, params, persona)
 	if err != nil {
 		t.Fatalf("failed to create ESN: %v", err)
 	}
-	
+
 	// Create training data
 	states := [][]float64{
 		{0.1, 0.2, 0.3, 0.4, 0.5},
 		{0.5, 0.4, 0.3, 0.2, 0.1},
 		{0.3, 0.3, 0.3, 0.3, 0.3},
 	}
-	
+
 	targets := [][]float64{
 		{0.0},
 		{1.0},
 		{0.5},
 	}
-	
+
 	// Train
 	err = esn.TrainOutput(context.Background(), states, targets)
 	if err != nil {
 		t.Fatalf("failed to train output: %v", err)
 	}
-	
+
 	if !esn.trained {
 		t.Error("expected ESN to be marked as trained")
 	}
@@ -132,15 +142,15 @@ func TestESNTrainOutput(t *testing.T) {
 
 func TestMembraneSystem(t *testing.T) {
 	ms := NewMembraneSystem(3)
-	
+
 	if ms.Root == nil {
 		t.Fatal("root membrane is nil")
 	}
-	
+
 	if len(ms.All) == 0 {
 		t.Fatal("expected some membranes in system")
 	}
-	
+
 	// Test object injection
 	obj := Object{
 		Type:     "test",
@@ -148,12 +158,12 @@ func TestMembraneSystem(t *testing.T) {
 		Charge:   0,
 		Mobility: 0.5,
 	}
-	
+
 	err := ms.InjectObject("root", obj)
 	if err != nil {
 		t.Fatalf("failed to inject object: %v", err)
 	}
-	
+
 	if len(ms.Root.Objects) != 1 {
 		t.Errorf("expected 1 object in root, got %d", len(ms.Root.Objects))
 	}
@@ -161,11 +171,11 @@ func TestMembraneSystem(t *testing.T) {
 
 func TestMembraneEvolution(t *testing.T) {
 	membrane := NewMembrane("test", 0, 0.5)
-	
+
 	// Add objects
 	membrane.AddObject(Object{Type: "token", Value: 1.0, Charge: 0, Mobility: 0.5})
 	membrane.AddObject(Object{Type: "positive_signal", Value: 0.8, Charge: 1, Mobility: 0.7})
-	
+
 	// Add a simple rule
 	rule := EvolutionRule{
 		Name:        "test_rule",
@@ -182,13 +192,13 @@ func TestMembraneEvolution(t *testing.T) {
 		},
 	}
 	membrane.AddRule(rule)
-	
+
 	// Evolve
 	err := membrane.Evolve()
 	if err != nil {
 		t.Fatalf("failed to evolve: %v", err)
 	}
-	
+
 	// Check objects changed
 	hasProcessed := false
 	for _, obj := range membrane.Objects {
@@ -197,7 +207,7 @@ func TestMembraneEvolution(t *testing.T) {
 			break
 		}
 	}
-	
+
 	if !hasProcessed {
 		t.Error("expected processed object after evolution")
 	}
@@ -206,19 +216,19 @@ func TestMembraneEvolution(t *testing.T) {
 func TestAffectiveAgent(t *testing.T) {
 	persona := DefaultPersonaTrait()
 	agent := NewAffectiveAgent(persona)
-	
+
 	if agent == nil {
 		t.Fatal("agent is nil")
 	}
-	
+
 	// Test with positive message
 	positiveMsg := "Thank you for your wonderful help! I'm very happy with the results."
 	state := agent.ProcessMessage(context.Background(), positiveMsg)
-	
+
 	if state.Joy <= 0 {
 		t.Error("expected some joy in positive message")
 	}
-	
+
 	if state.Valence <= 0 {
 		t.Error("expected positive valence for positive message")
 	}
@@ -227,13 +237,13 @@ func TestAffectiveAgent(t *testing.T) {
 func TestAffectiveAgentSpamDetection(t *testing.T) {
 	persona := DefaultPersonaTrait()
 	agent := NewAffectiveAgent(persona)
-	
+
 	// Test with spam message
 	spamMsg := "Click here to buy now! Free money! Limited time offer! Act now!"
 	agent.ProcessMessage(context.Background(), spamMsg)
-	
+
 	spamProb := agent.GetSpamProbability()
-	
+
 	if spamProb < 0.2 {
 		t.Errorf("expected high spam probability for spam message, got %f", spamProb)
 	}
@@ -245,32 +255,539 @@ func TestReservoirFilter(t *testing.T) {
 	config.EnableReservoir = true
 	config.EnableAffective = true
 	config.ESNParams.ReservoirSize = 30 // Smaller for testing
-	
+
 	filter, err := NewReservoirFilter(log, config)
 	if err != nil {
 		t.Fatalf("failed to create reservoir filter: %v", err)
 	}
-	
+
 	if filter.esn == nil {
 		t.Error("expected ESN to be initialized")
 	}
-	
+
 	if filter.affectiveAgent == nil {
 		t.Error("expected affective agent to be initialized")
 	}
 }
 
+func TestFilterModel(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+	config := DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.ESNParams.ReservoirSize = 20
+	modelPath := filepath.Join(t.TempDir(), "reservoirfilter.model")
+
+	filter, err := NewFilter(ctx, log, config, modelPath)
+	if err != nil {
+		t.Fatalf("creating filter: %v", err)
+	}
+	if filter.esn.Seed() == 0 {
+		t.Fatalf("expected random seed")
+	}
+	if _, err := NewFilter(ctx, log, config, modelPath); err == nil {
+		t.Fatalf("expected error creating filter over existing model")
+	}
+
+	// Train output weights and save.
+	states := [][]float64{make([]float64, 20), make([]float64, 20)}
+	states[0][0] = 1
+	states[1][1] = 1
+	targets := [][]float64{{0}, {1}}
+	if err := filter.esn.TrainOutput(ctx, states, targets); err != nil {
+		t.Fatalf("train: %v", err)
+	}
+	if err := filter.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Reopen, weights and seed must be the same.
+	nfilter, err := OpenFilter(ctx, log, config, modelPath)
+	if err != nil {
+		t.Fatalf("open filter: %v", err)
+	}
+	if nfilter.esn.Seed() != filter.esn.Seed() {
+		t.Fatalf("seed mismatch, got %d, expected %d", nfilter.esn.Seed(), filter.esn.Seed())
+	}
+	if !nfilter.esn.Trained() {
+		t.Fatalf("expected trained model after reopen")
+	}
+	if !reflect.DeepEqual(nfilter.esn.inputWeights, filter.esn.inputWeights) || !reflect.DeepEqual(nfilter.esn.reservoirWeights, filter.esn.reservoirWeights) || !reflect.DeepEqual(nfilter.esn.outputWeights, filter.esn.outputWeights) {
+		t.Fatalf("weights differ after reopen")
+	}
+
+	f, err := os.Open(modelPath)
+	if err != nil {
+		t.Fatalf("open model: %v", err)
+	}
+	defer f.Close()
+	if err := CheckModel(f); err != nil {
+		t.Fatalf("check model: %v", err)
+	}
+
+	// Same seed results in same reservoir.
+	params := config.ESNParams
+	params.Seed = filter.esn.Seed()
+	esn, err := NewESN(log, params, config.Persona)
+	if err != nil {
+		t.Fatalf("new esn with seed: %v", err)
+	}
+	if !reflect.DeepEqual(esn.reservoirWeights, filter.esn.reservoirWeights) {
+		t.Fatalf("reservoir weights differ for same seed")
+	}
+
+	// Changed configuration is detected.
+	config.ESNParams.ReservoirSize = 30
+	if _, err := OpenFilter(ctx, log, config, modelPath); !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("got err %v, expected ErrModelMismatch", err)
+	}
+	config.ESNParams.ReservoirSize = 20
+	config.ESNParams.Seed = filter.esn.Seed() + 1
+	if _, err := OpenFilter(ctx, log, config, modelPath); !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("got err %v, expected ErrModelMismatch", err)
+	}
+}
+
+func TestFit(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+	config := DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.ESNParams.ReservoirSize = 20
+	config.ESNParams.Seed = 1
+	filter, err := NewReservoirFilter(log, config)
+	if err != nil {
+		t.Fatalf("creating filter: %v", err)
+	}
+
+	junk := filter.Features(Message{Part: parseTestMessage(t, "Subject: FREE!!!\r\nContent-Type: text/html\r\n\r\n<a href=\"https://spam.example/\">Click to buy now, urgent, limited offer, you are a WINNER</a>\r\n")})
+	ham := filter.Features(Message{Part: parseTestMessage(t, "Subject: notes\r\n\r\nHi, attached are the notes of the meeting. Regards\r\n")})
+
+	// Only one class, not trained.
+	if err := filter.Fit(ctx, []Sample{{ID: 1, Junk: true, Features: junk}}); err != nil {
+		t.Fatalf("fit: %v", err)
+	}
+	if filter.esn.Trained() {
+		t.Fatalf("trained with only junk samples")
+	}
+
+	samples := []Sample{
+		{ID: 1, Junk: true, Features: junk},
+		{ID: 2, Junk: false, Features: ham},
+		{ID: 3, Junk: false, Features: []float64{1}}, // Wrong dimension, skipped.
+	}
+	if err := filter.Fit(ctx, samples); err != nil {
+		t.Fatalf("fit: %v", err)
+	}
+	if !filter.esn.Trained() {
+		t.Fatalf("not trained")
+	}
+	if n, lastID := filter.TrainedSamples(); n != 3 || lastID != 3 {
+		t.Fatalf("got trained samples %d, last id %d, expected 3, 3", n, lastID)
+	}
+
+	predict := func(features []float64) float64 {
+		t.Helper()
+		filter.esn.Reset()
+		if err := filter.esn.Update(ctx, features); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		out, err := filter.esn.Predict(ctx)
+		if err != nil {
+			t.Fatalf("predict: %v", err)
+		}
+		return sigmoid(out[0])
+	}
+	if p := predict(junk); p < 0.5 {
+		t.Fatalf("junk sample predicted as ham, %v", p)
+	}
+	if p := predict(ham); p > 0.5 {
+		t.Fatalf("ham sample predicted as junk, %v", p)
+	}
+}
+
+func TestUntrained(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+	config := DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.ESNParams.ReservoirSize = 20
+	config.ESNParams.Seed = 1
+	filter, err := NewReservoirFilter(log, config)
+	if err != nil {
+		t.Fatalf("creating filter: %v", err)
+	}
+
+	// Without fitted output weights, the reservoir must not affect the bayesian verdict.
+	m := Message{Part: parseTestMessage(t, "Subject: FREE!!!\r\n\r\nClick to buy now, urgent, limited offer\r\n")}
+	for _, prob := range []float64{0.01, 0.5, 0.99} {
+		result, err := filter.Classify(ctx, m, prob)
+		if err != nil {
+			t.Fatalf("classify: %v", err)
+		}
+		if result.ReservoirTrained {
+			t.Fatalf("untrained filter gave trained reservoir probability")
+		}
+		if result.CombinedProb != prob {
+			t.Fatalf("got combined probability %v, expected bayesian probability %v", result.CombinedProb, prob)
+		}
+	}
+}
+
+func TestPrepared(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+	config := DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.EnableAffective = true
+	config.ESNParams.ReservoirSize = 20
+	config.ESNParams.Seed = 1
+	filter, err := NewReservoirFilter(log, config)
+	if err != nil {
+		t.Fatalf("creating filter: %v", err)
+	}
+
+	m := Message{Part: parseTestMessage(t, "Subject: FREE!!!\r\n\r\nClick to buy now, urgent, limited offer\r\n")}
+	exp, err := filter.Classify(ctx, m, 0.5)
+	if err != nil {
+		t.Fatalf("classify: %v", err)
+	}
+
+	// Prepared message has the features the filter would extract.
+	p, err := PrepareMessage(filter.Config(), m)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	tcompare := func(got, exp any) {
+		t.Helper()
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("got %#v, expected %#v", got, exp)
+		}
+	}
+	tcompare(p.features, filter.Features(m))
+	filter.Reset()
+	result, err := filter.ClassifyPrepared(ctx, p, 0.5)
+	if err != nil {
+		t.Fatalf("classify prepared: %v", err)
+	}
+	tcompare(result.AffectiveProb, exp.AffectiveProb)
+
+	// Message prepared for another configuration is prepared again.
+	oconfig := filter.Config()
+	oconfig.EnableAffective = false
+	oconfig.FeatureWords = 8
+	p, err = PrepareMessage(oconfig, m)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	filter.Reset()
+	result, err = filter.ClassifyPrepared(ctx, p, 0.5)
+	if err != nil {
+		t.Fatalf("classify prepared for other configuration: %v", err)
+	}
+	tcompare(result.AffectiveLanguage, exp.AffectiveLanguage)
+	tcompare(result.AffectiveProb, exp.AffectiveProb)
+}
+
+func parseTestMessage(t *testing.T, msg string) *message.Part {
+	t.Helper()
+	p, err := message.Parse(mlog.New("test", nil).Logger, false, strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	if err := p.Walk(mlog.New("test", nil).Logger, nil); err != nil {
+		t.Fatalf("walking message: %v", err)
+	}
+	return &p
+}
+
+func TestFeatureExtractors(t *testing.T) {
+	config := DefaultFilterConfig()
+	config.FeatureWords = 8
+
+	x, err := NewExtractor(config)
+	if err != nil {
+		t.Fatalf("new extractor: %v", err)
+	}
+	if x.Dim() != 8+standardFixedFeatures {
+		t.Fatalf("got dim %d, expected %d", x.Dim(), 8+standardFixedFeatures)
+	}
+
+	msg := "Subject: test\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=x\r\n\r\n--x\r\nContent-Type: text/html\r\n\r\n<a href=\"https://a.example/1\">one</a> <a href=\"https://a.example/2\">two</a> <a href=\"http://b.example\">three</a>\r\n--x--\r\n"
+	junk := true
+	m := Message{
+		Part:  parseTestMessage(t, msg),
+		Words: map[string]struct{}{"one": {}, "two": {}, "three": {}},
+		Auth:  Auth{DKIMDomains: []string{"a.example"}, SPF: "softfail", Reputation: &junk},
+	}
+	features := x.Features(m)
+	if len(features) != x.Dim() {
+		t.Fatalf("got %d features, expected %d", len(features), x.Dim())
+	}
+	var words float64
+	for _, v := range features[:8] {
+		words += math.Abs(v)
+	}
+	if words == 0 {
+		t.Fatalf("expected word features")
+	}
+	f := features[8:]
+	check := func(i int, expect float64) {
+		t.Helper()
+		if math.Abs(f[i]-expect) > 1e-9 {
+			t.Fatalf("feature %d: got %v, expected %v", i, f[i], expect)
+		}
+	}
+	check(4, 1)                            // DKIM.
+	check(6, 0)                            // SPF pass.
+	check(7, 1)                            // SPF fail or softfail.
+	check(11, 1)                           // Reputation junk.
+	check(12, math.Log1p(3)/math.Log(101)) // Links.
+	check(13, math.Log1p(2)/math.Log(101)) // Hosts.
+	check(14, 1)                           // HTML ratio.
+	check(15, 1)                           // Only HTML.
+
+	config.FeatureExtractor = "basic"
+	x, err = NewExtractor(config)
+	if err != nil || x.Dim() != 10 || len(x.Features(m)) != 10 {
+		t.Fatalf("basic extractor: err %v", err)
+	}
+
+	config.FeatureExtractor = "bogus"
+	if err := config.WithDefaults().Validate(); err == nil {
+		t.Fatalf("expected error for unknown extractor")
+	}
+
+	// Changing the extractor changes the input dimension, the model no longer matches.
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+	config = DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.ESNParams.ReservoirSize = 20
+	modelPath := filepath.Join(t.TempDir(), "reservoirfilter.model")
+	if _, err := NewFilter(ctx, log, config, modelPath); err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	config.FeatureExtractor = "basic"
+	if _, err := OpenFilter(ctx, log, config, modelPath); !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("got err %v, expected ErrModelMismatch", err)
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	c := NewStateCache(2, time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	if s := c.Get("a"); s != nil {
+		t.Fatalf("got state %v for unknown key", s)
+	}
+	c.Put("a", []float64{1})
+	c.Put("b", []float64{2})
+	c.Get("a") // Now most recently used.
+	c.Put("c", []float64{3})
+	if s := c.Get("b"); s != nil {
+		t.Fatalf("least recently used state not evicted")
+	}
+	if s := c.Get("a"); !reflect.DeepEqual(s, []float64{1}) {
+		t.Fatalf("got %v, expected [1]", s)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("got %d states, expected 2", c.Len())
+	}
+
+	// Returned states are copies.
+	c.Get("a")[0] = 10
+	if s := c.Get("a"); s[0] != 1 {
+		t.Fatalf("state modified through returned copy")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if s := c.Get("a"); s != nil {
+		t.Fatalf("expired state returned")
+	}
+
+	// Concurrent use, for the race detector.
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", i%3)
+			for range 100 {
+				c.Put(key, []float64{float64(i)})
+				c.Get(key)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Len() != 2 {
+		t.Fatalf("got %d states, expected 2", c.Len())
+	}
+}
+
+func TestSenderSequence(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("test", nil)
+
+	config := DefaultFilterConfig()
+	config.EnableReservoir = true
+	config.FeatureWords = 8
+	config.ESNParams.ReservoirSize = 20
+	config.ESNParams.Seed = 1
+	filter, err := NewReservoirFilter(log, config)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	filter.SetStateCache(NewStateCache(10, time.Hour))
+	// Membrane evolution is random, disable it to compare states.
+	filter.esn.membranes = nil
+
+	part := parseTestMessage(t, "Subject: hi\r\n\r\nhello\r\n")
+	classify := func(sender string) []float64 {
+		t.Helper()
+		if _, err := filter.Classify(ctx, Message{Part: part, Sender: sender}, 0.5); err != nil {
+			t.Fatalf("classify: %v", err)
+		}
+		return filter.esn.GetState()
+	}
+
+	// Without sender, each message starts from a reset reservoir.
+	s0 := classify("")
+	if s := classify(""); !reflect.DeepEqual(s, s0) {
+		t.Fatalf("state without sender depends on earlier message")
+	}
+
+	// First message of a sender starts from a reset reservoir, the next continues.
+	if s := classify("domain:example.org"); !reflect.DeepEqual(s, s0) {
+		t.Fatalf("first message of sender not from reset reservoir")
+	}
+	s1 := classify("domain:example.org")
+	if reflect.DeepEqual(s1, s0) {
+		t.Fatalf("second message of sender did not continue from sender state")
+	}
+
+	// Another sender is not affected.
+	if s := classify("ip:192.0.2.0"); !reflect.DeepEqual(s, s0) {
+		t.Fatalf("state of other sender used")
+	}
+	if s := filter.states.Get("domain:example.org"); !reflect.DeepEqual(s, s1) {
+		t.Fatalf("sender state not stored")
+	}
+
+	// Training uses the same states as classification: per sender in order of
+	// receipt, until the sender state expires.
+	features := filter.Features(Message{Part: part})
+	now := time.Now()
+	samples := []Sample{
+		{ID: 1, Features: features, Sender: "domain:example.org", Received: now.Add(time.Minute)},
+		{ID: 2, Features: features, Sender: "domain:example.org", Received: now},
+		{ID: 3, Features: features, Sender: "ip:192.0.2.0", Received: now.Add(time.Minute)},
+		{ID: 4, Features: features, Sender: "domain:example.org", Received: now.Add(3 * time.Hour)},
+		{ID: 5, Features: features, Received: now.Add(time.Minute)},
+	}
+	states, err := filter.sampleStates(ctx, samples, 0)
+	if err != nil {
+		t.Fatalf("sample states: %v", err)
+	}
+	if !reflect.DeepEqual(states, [][]float64{s1, s0, s0, s0, s0}) {
+		t.Fatalf("sample states do not match classification states")
+	}
+}
+
+func TestLexicons(t *testing.T) {
+	var c FilterConfig
+	c.Lexicons = []string{"nl.conf", "de.conf", "fr.conf"}
+	err := c.LoadLexicons(func(p string) string { return filepath.Join("lexicons", p) })
+	if err != nil {
+		t.Fatalf("loading lexicons: %v", err)
+	}
+	lexicons := c.lexicons()
+
+	detect := func(text, expLang string) {
+		t.Helper()
+		l := DetectLanguage(lexicons, tokenize(text))
+		lang := LanguageUnknown
+		if l != nil {
+			lang = l.Language
+		}
+		if lang != expLang {
+			t.Fatalf("detected language %q for %q, expected %q", lang, text, expLang)
+		}
+	}
+	detect("Thank you for your message, we will get back to you soon.", "en")
+	detect("Bedankt voor uw bericht, wij nemen zo snel mogelijk contact met u op.", "nl")
+	detect("Vielen Dank für Ihre Nachricht, wir werden uns so schnell wie möglich bei Ihnen melden.", "de")
+	detect("Merci pour votre message, nous vous répondrons dans les plus brefs délais.", "fr")
+	detect("Gracias por su mensaje, le responderemos lo antes posible.", LanguageUnknown)
+	detect("", LanguageUnknown)
+
+	agent := NewAffectiveAgent(DefaultPersonaTrait())
+	agent.Lexicons = lexicons
+
+	// Keywords, including prefix keywords, of the detected language are used.
+	state := agent.ProcessMessage(context.Background(), "Ich bin sehr enttäuscht und traurig, leider hat es nicht geklappt.")
+	if state.Language != "de" || state.Sadness <= 0 {
+		t.Fatalf("got language %q, sadness %f, expected de and sadness", state.Language, state.Sadness)
+	}
+
+	// Unknown language does not update the state, and gives no spam signal.
+	prev := agent.CurrentState
+	state = agent.ProcessMessage(context.Background(), "Haga clic aquí, oferta gratuita por tiempo limitado.")
+	if state != (AffectiveState{Language: LanguageUnknown}) {
+		t.Fatalf("got state %#v for unknown language", state)
+	}
+	prev.Language = LanguageUnknown
+	if agent.CurrentState != prev {
+		t.Fatalf("state changed for unknown language")
+	}
+	if p := agent.GetSpamProbability(); p != 0 {
+		t.Fatalf("got spam probability %f for unknown language, expected 0", p)
+	}
+
+	// Unknown language is reported by the filter, without affective probability.
+	config := DefaultFilterConfig()
+	config.EnableAffective = true
+	config.ParsedLexicons = c.ParsedLexicons
+	filter, err := NewReservoirFilter(mlog.New("test", nil), config)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	part := parseTestMessage(t, "Subject: Oferta\r\n\r\nHaga clic aquí, oferta gratuita por tiempo limitado.\r\n")
+	r, err := filter.ClassifyMessage(context.Background(), part, 0.4)
+	if err != nil {
+		t.Fatalf("classify: %v", err)
+	}
+	if r.AffectiveLanguage != LanguageUnknown || r.AffectiveState != nil || r.CombinedProb != 0.4 {
+		t.Fatalf("got language %q, state %v, combined %f, expected unknown, nil and bayesian probability", r.AffectiveLanguage, r.AffectiveState, r.CombinedProb)
+	}
+	part = parseTestMessage(t, "Subject: Aanbieding\r\n\r\nKlik hier voor een gratis aanbieding, alleen voor een beperkte tijd!\r\n")
+	r, err = filter.ClassifyMessage(context.Background(), part, 0.4)
+	if err != nil {
+		t.Fatalf("classify: %v", err)
+	}
+	if r.AffectiveLanguage != "nl" || r.AffectiveState == nil || r.AffectiveState.Disgust <= 0 {
+		t.Fatalf("got language %q, state %v, expected nl and disgust", r.AffectiveLanguage, r.AffectiveState)
+	}
+
+	// Lexicons are validated.
+	c.Lexicons = []string{"nl.conf", "nl.conf"}
+	if err := c.LoadLexicons(func(p string) string { return filepath.Join("lexicons", p) }); err == nil {
+		t.Fatalf("duplicate lexicon not rejected")
+	}
+}
+
 func TestFilterConfig(t *testing.T) {
 	config := DefaultFilterConfig()
-	
+
 	if config.ESNParams.ReservoirSize <= 0 {
 		t.Error("invalid reservoir size")
 	}
-	
+
 	if config.ESNParams.SpectralRadius <= 0 || config.ESNParams.SpectralRadius >= 1 {
 		t.Error("invalid spectral radius")
 	}
-	
+
 	if config.ReservoirWeight < 0 || config.ReservoirWeight > 1 {
 		t.Error("invalid reservoir weight")
 	}
@@ -278,28 +795,28 @@ func TestFilterConfig(t *testing.T) {
 
 func TestPersonaTrait(t *testing.T) {
 	persona := DefaultPersonaTrait()
-	
+
 	// Check all values are in valid ranges
 	if persona.Valence < -1 || persona.Valence > 1 {
 		t.Errorf("valence out of range: %f", persona.Valence)
 	}
-	
+
 	if persona.Arousal < 0 || persona.Arousal > 1 {
 		t.Errorf("arousal out of range: %f", persona.Arousal)
 	}
-	
+
 	if persona.Dominance < 0 || persona.Dominance > 1 {
 		t.Errorf("dominance out of range: %f", persona.Dominance)
 	}
-	
+
 	if persona.Attention < 0 || persona.Attention > 1 {
 		t.Errorf("attention out of range: %f", persona.Attention)
 	}
-	
+
 	if persona.Memory < 0 || persona.Memory > 1 {
 		t.Errorf("memory out of range: %f", persona.Memory)
 	}
-	
+
 	if persona.Creativity < 0 || persona.Creativity > 1 {
 		t.Errorf("creativity out of range: %f", persona.Creativity)
 	}
@@ -308,17 +825,17 @@ func TestPersonaTrait(t *testing.T) {
 func TestAffectiveStateReport(t *testing.T) {
 	persona := DefaultPersonaTrait()
 	agent := NewAffectiveAgent(persona)
-	
+
 	// Process a message
 	agent.ProcessMessage(context.Background(), "This is a test message.")
-	
+
 	// Generate report
 	report := agent.GenerateReport()
-	
+
 	if len(report) == 0 {
 		t.Error("expected non-empty report")
 	}
-	
+
 	// Check report contains key sections
 	expectedSections := []string{
 		"Affective State Report",
@@ -327,7 +844,7 @@ func TestAffectiveStateReport(t *testing.T) {
 		"Cognitive",
 		"Spam Probability",
 	}
-	
+
 	for _, section := range expectedSections {
 		if !contains(report, section) {
 			t.Errorf("report missing section: %s", section)
@@ -347,3 +864,33 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestEvaluate(t *testing.T) {
+	spam := []float64{0.9, 0.8, 0.4}
+	ham := []float64{0.1, 0.4, 0.6}
+	e := Evaluate(spam, ham, 0.5)
+	expect := Evaluation{
+		Threshold:      0.5,
+		TruePositives:  2,
+		FalsePositives: 1,
+		TrueNegatives:  2,
+		FalseNegatives: 1,
+		Precision:      2.0 / 3,
+		Recall:         2.0 / 3,
+		F1:             2.0 / 3,
+		Accuracy:       4.0 / 6,
+		AUC:            7.5 / 9, // 7 pairs ordered correctly, 1 tie.
+	}
+	if math.Abs(e.F1-expect.F1) > 1e-9 || math.Abs(e.AUC-expect.AUC) > 1e-9 {
+		t.Fatalf("got %#v, expected %#v", e, expect)
+	}
+	e.F1 = expect.F1
+	e.AUC = expect.AUC
+	if e != expect {
+		t.Fatalf("got %#v, expected %#v", e, expect)
+	}
+
+	if e := Evaluate(nil, ham, 0.5); e.AUC != 0 || e.Recall != 0 {
+		t.Fatalf("unexpected evaluation without spam: %#v", e)
+	}
+}
@@ -0,0 +1,92 @@
+package reservoir
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// StateCache keeps reservoir states per key, e.g. per sender, so a sequence of
+// messages from the same source drives the reservoir over time. The number of
+// states is bounded, the least recently used state is evicted first. States not
+// updated within the expiry period are discarded. Safe for concurrent use. When
+// messages for the same key are classified concurrently, the last stored state
+// wins.
+type StateCache struct {
+	mu      sync.Mutex
+	max     int
+	expiry  time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // Of *stateEntry, most recently used at front.
+
+	now func() time.Time // For tests.
+}
+
+type stateEntry struct {
+	key     string
+	state   []float64
+	updated time.Time
+}
+
+// NewStateCache returns a cache holding at most max states, each expiring after
+// expiry.
+func NewStateCache(max int, expiry time.Duration) *StateCache {
+	return &StateCache{
+		max:     max,
+		expiry:  expiry,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns a copy of the state for key, or nil if there is no state or it
+// has expired.
+func (c *StateCache) Get(key string) []float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	se := e.Value.(*stateEntry)
+	if c.now().Sub(se.updated) > c.expiry {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return append([]float64(nil), se.state...)
+}
+
+// Put stores a copy of state for key, evicting the least recently used state if
+// the cache is full.
+func (c *StateCache) Put(key string, state []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state = append([]float64(nil), state...)
+	now := c.now()
+	if e, ok := c.entries[key]; ok {
+		se := e.Value.(*stateEntry)
+		se.state = state
+		se.updated = now
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&stateEntry{key, state, now})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*stateEntry).key)
+	}
+}
+
+// Len returns the number of states in the cache, including expired states that
+// have not been discarded yet.
+func (c *StateCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,168 @@
+package reservoir
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Sample is a message classified by a user, for training the output weights.
+type Sample struct {
+	ID       int64 // Assigned by caller, higher for newer samples.
+	Junk     bool
+	Features []float64 // From the feature extractor.
+
+	// Sender and receive time of the message, as for Message.Sender. Used to train on
+	// the same per-sender state sequences as used by Classify.
+	Sender   string
+	Received time.Time
+}
+
+// Targets for the output layer when training. Predictions are passed through a
+// sigmoid, so targets are in logit space: sigmoid(±3) is ~0.05 and ~0.95.
+const (
+	targetJunk = 3.0
+	targetHam  = -3.0
+)
+
+// TrainedSamples returns the number of samples and highest sample ID that the
+// output weights were last trained on with Fit. Callers can compare against
+// their samples to determine if retraining is needed.
+func (rf *ReservoirFilter) TrainedSamples() (n int, lastID int64) {
+	if rf.esn == nil {
+		return 0, 0
+	}
+	rf.esn.mu.RLock()
+	defer rf.esn.mu.RUnlock()
+	return rf.esn.trainedSamples, rf.esn.trainedLastSampleID
+}
+
+// Fit retrains the output weights on samples, replacing earlier training. The
+// reservoir state for each sample is computed like Classify does, see
+// sampleStates, so the output weights are trained on the kind of states they are
+// applied to. Samples with a feature dimension different from the input weights
+// are skipped. If no samples of either class remain, the output weights are
+// cleared and the network is marked untrained. Save the filter afterwards to
+// persist the new weights.
+func (rf *ReservoirFilter) Fit(ctx context.Context, samples []Sample) error {
+	esn := rf.esn
+	if esn == nil {
+		return nil
+	}
+
+	t0 := time.Now()
+	result := "error"
+	defer func() {
+		metricTraining.WithLabelValues(result).Observe(float64(time.Since(t0)) / float64(time.Second))
+	}()
+
+	esn.mu.RLock()
+	inputDim := 0
+	if len(esn.inputWeights) > 0 {
+		inputDim = len(esn.inputWeights[0])
+	}
+	esn.mu.RUnlock()
+
+	sampleStates, err := rf.sampleStates(ctx, samples, inputDim)
+	if err != nil {
+		return err
+	}
+
+	var states, targets [][]float64
+	var njunk, nham int
+	var lastID int64
+	for i, s := range samples {
+		lastID = max(lastID, s.ID)
+		if sampleStates[i] == nil {
+			continue
+		}
+		states = append(states, sampleStates[i])
+		if s.Junk {
+			targets = append(targets, []float64{targetJunk})
+			njunk++
+		} else {
+			targets = append(targets, []float64{targetHam})
+			nham++
+		}
+	}
+
+	if njunk == 0 || nham == 0 {
+		esn.mu.Lock()
+		esn.outputWeights = nil
+		esn.trained = false
+		esn.trainedSamples = len(samples)
+		esn.trainedLastSampleID = lastID
+		esn.mu.Unlock()
+		rf.log.Debug("not enough samples of both classes for training reservoir output", slog.Int("junk", njunk), slog.Int("ham", nham))
+		result = "untrained"
+		return nil
+	}
+
+	if err := esn.TrainOutput(ctx, states, targets); err != nil {
+		return err
+	}
+	esn.mu.Lock()
+	esn.trainedSamples = len(samples)
+	esn.trainedLastSampleID = lastID
+	esn.mu.Unlock()
+	rf.log.Debug("trained reservoir output", slog.Int("junk", njunk), slog.Int("ham", nham))
+	result = "trained"
+	return nil
+}
+
+// sampleStates returns the reservoir state for each sample, nil for samples with
+// a feature dimension other than inputDim, or other than the first sample if
+// inputDim is 0. If the filter has a state cache, samples with a Sender continue
+// from the state of the previous sample of the sender in order of receipt, unless
+// it expired, like Classify continues from the state of the previous message from
+// the sender. Other samples start from a reset reservoir. The reservoir is reset
+// afterwards.
+func (rf *ReservoirFilter) sampleStates(ctx context.Context, samples []Sample, inputDim int) ([][]float64, error) {
+	esn := rf.esn
+	defer esn.Reset()
+
+	if inputDim == 0 && len(samples) > 0 {
+		inputDim = len(samples[0].Features)
+	}
+
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return samples[order[i]].Received.Before(samples[order[j]].Received)
+	})
+
+	type senderState struct {
+		state    []float64
+		received time.Time
+	}
+	senders := map[string]senderState{}
+	sequence := rf.states != nil
+
+	states := make([][]float64, len(samples))
+	for _, i := range order {
+		s := samples[i]
+		if len(s.Features) != inputDim {
+			continue
+		}
+		prev, ok := senders[s.Sender]
+		if sequence && s.Sender != "" && ok && s.Received.Sub(prev.received) <= rf.states.expiry {
+			if err := esn.SetState(prev.state); err != nil {
+				return nil, fmt.Errorf("restoring sender state for sample %d: %w", s.ID, err)
+			}
+		} else {
+			esn.Reset()
+		}
+		if err := esn.Update(ctx, s.Features); err != nil {
+			return nil, fmt.Errorf("updating reservoir for sample %d: %w", s.ID, err)
+		}
+		states[i] = esn.GetState()
+		if sequence && s.Sender != "" {
+			senders[s.Sender] = senderState{states[i], s.Received}
+		}
+	}
+	return states, nil
+}
@@ -0,0 +1,164 @@
+package sasl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OAUTHBEARER (RFC 7628) and XOAUTH2 (by Google, not standardized) send an OAuth
+// 2.0 bearer token, typically an access token from an OpenID Connect identity
+// provider. On failure, the server sends a challenge with a JSON error, and the
+// client sends a dummy response, after which the server fails authentication.
+
+// ErrOAuthSyntax is returned when parsing a malformed OAUTHBEARER or XOAUTH2
+// client response.
+var ErrOAuthSyntax = errors.New("malformed oauth client response")
+
+// OAuthErrorChallenge is the challenge a server sends when an OAUTHBEARER or
+// XOAUTH2 token is not accepted. ../rfc/7628
+const OAuthErrorChallenge = `{"status":"invalid_token","schemes":"bearer"}`
+
+type clientOAuthBearer struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientOAuthBearer)(nil)
+
+// NewClientOAUTHBEARER returns a client for SASL OAUTHBEARER authentication with a
+// bearer token, typically an access token from an OpenID Connect identity provider.
+// Username is optional, it is sent as authorization identity.
+//
+// OAUTHBEARER is specified in RFC 7628, A Set of Simple Authentication and Security
+// Layer (SASL) Mechanisms for OAuth.
+func NewClientOAUTHBEARER(username, token string) Client {
+	return &clientOAuthBearer{username, token, 0}
+}
+
+func (a *clientOAuthBearer) Info() (name string, hasCleartextCredentials bool) {
+	return "OAUTHBEARER", true
+}
+
+func (a *clientOAuthBearer) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		// ../rfc/7628 ../rfc/5801
+		var authz string
+		if a.Username != "" {
+			authz = "a=" + strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.Username)
+		}
+		return fmt.Appendf(nil, "n,%s,\x01auth=Bearer %s\x01\x01", authz, a.Token), true, nil
+	case 1:
+		// Server sent an error, we must respond with a dummy message. ../rfc/7628
+		return []byte{1}, true, fmt.Errorf("token not accepted: %s", fromServer)
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+type clientXOAuth2 struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientXOAuth2)(nil)
+
+// NewClientXOAUTH2 returns a client for SASL XOAUTH2 authentication with a bearer
+// token. XOAUTH2 is the non-standard predecessor of OAUTHBEARER, still commonly
+// used by email clients.
+func NewClientXOAUTH2(username, token string) Client {
+	return &clientXOAuth2{username, token, 0}
+}
+
+func (a *clientXOAuth2) Info() (name string, hasCleartextCredentials bool) {
+	return "XOAUTH2", true
+}
+
+func (a *clientXOAuth2) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		return fmt.Appendf(nil, "user=%s\x01auth=Bearer %s\x01\x01", a.Username, a.Token), true, nil
+	case 1:
+		// Server sent an error, we must respond with an empty message.
+		return []byte{}, true, fmt.Errorf("token not accepted: %s", fromServer)
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+// ParseOAuthBearer parses the initial client response for SASL OAUTHBEARER, as
+// used by servers. The authorization identity is optional.
+func ParseOAuthBearer(buf []byte) (authz, token string, rerr error) {
+	// ../rfc/7628
+	gs2, kvs, ok := bytes.Cut(buf, []byte{1})
+	if !ok {
+		return "", "", fmt.Errorf("%w: missing key/value pairs", ErrOAuthSyntax)
+	}
+	// ../rfc/5801
+	t := strings.Split(string(gs2), ",")
+	if len(t) != 3 || t[2] != "" {
+		return "", "", fmt.Errorf("%w: malformed gs2 header", ErrOAuthSyntax)
+	}
+	// Channel binding is not supported for OAUTHBEARER. ../rfc/7628
+	if t[0] != "n" && t[0] != "y" {
+		return "", "", fmt.Errorf("%w: channel binding not supported", ErrOAuthSyntax)
+	}
+	if t[1] != "" {
+		s, ok := strings.CutPrefix(t[1], "a=")
+		if !ok {
+			return "", "", fmt.Errorf("%w: malformed authorization identity", ErrOAuthSyntax)
+		}
+		authz = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(s)
+	}
+	token, err := parseAuthKVs(kvs)
+	return authz, token, err
+}
+
+// ParseXOAuth2 parses the initial client response for SASL XOAUTH2, as used by
+// servers.
+func ParseXOAuth2(buf []byte) (username, token string, rerr error) {
+	s, ok := bytes.CutPrefix(buf, []byte("user="))
+	if !ok {
+		return "", "", fmt.Errorf("%w: missing user", ErrOAuthSyntax)
+	}
+	user, kvs, ok := bytes.Cut(s, []byte{1})
+	if !ok {
+		return "", "", fmt.Errorf("%w: missing auth", ErrOAuthSyntax)
+	}
+	token, err := parseAuthKVs(kvs)
+	return string(user), token, err
+}
+
+// parseAuthKVs parses key/value pairs, separated and ended by 0x01, returning the
+// bearer token from the "auth" key.
+func parseAuthKVs(kvs []byte) (string, error) {
+	l, ok := bytes.CutSuffix(kvs, []byte{1, 1})
+	if !ok {
+		return "", fmt.Errorf("%w: missing final separators", ErrOAuthSyntax)
+	}
+	var token string
+	for _, kv := range bytes.Split(l, []byte{1}) {
+		k, v, ok := bytes.Cut(kv, []byte("="))
+		if !ok {
+			return "", fmt.Errorf("%w: malformed key/value pair", ErrOAuthSyntax)
+		}
+		if string(k) != "auth" {
+			// E.g. "host" and "port", ignored.
+			continue
+		}
+		// ../rfc/6750
+		scheme, t, ok := strings.Cut(string(v), " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") || t == "" {
+			return "", fmt.Errorf("%w: auth value must be bearer token", ErrOAuthSyntax)
+		}
+		token = t
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: missing auth", ErrOAuthSyntax)
+	}
+	return token, nil
+}
@@ -2,6 +2,8 @@
 //
 // Supported authentication mechanisms:
 //
+//   - OAUTHBEARER (with parsing for servers)
+//   - XOAUTH2 (with parsing for servers)
 //   - EXTERNAL
 //   - SCRAM-SHA-256-PLUS
 //   - SCRAM-SHA-1-PLUS
@@ -7,11 +7,14 @@ import (
 
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/greylist"
 	"github.com/mjl-/mox/http"
 	"github.com/mjl-/mox/imapserver"
+	"github.com/mjl-/mox/managesieveserver"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/pop3server"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/smtpserver"
 	"github.com/mjl-/mox/store"
@@ -56,6 +59,8 @@ func shutdown(log mlog.Log) {
 func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec bool) error {
 	smtpserver.Listen()
 	imapserver.Listen()
+	managesieveserver.Listen()
+	pop3server.Listen()
 	http.Listen()
 
 	if !skipForkExec {
@@ -82,6 +87,10 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 		return fmt.Errorf("dmarcdb init: %s", err)
 	}
 
+	if err := greylist.Init(); err != nil {
+		return fmt.Errorf("greylist init: %s", err)
+	}
+
 	if err := store.Init(mox.Context); err != nil {
 		return fmt.Errorf("store init: %s", err)
 	}
@@ -102,6 +111,8 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 	store.StartAuthCache()
 	smtpserver.Serve()
 	imapserver.Serve()
+	managesieveserver.Serve()
+	pop3server.Serve()
 	http.Serve()
 
 	go func() {
@@ -0,0 +1,445 @@
+package sieve
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Extensions lists the supported extensions, as used in "require", and as
+// announced by ManageSieve.
+var Extensions = []string{
+	"body",
+	"comparator-i;ascii-casemap",
+	"comparator-i;octet",
+	"copy",
+	"envelope",
+	"ereject",
+	"fileinto",
+	"imap4flags",
+	"include",
+	"reject",
+	"vacation",
+	"variables",
+}
+
+type argType int
+
+const (
+	typeNone argType = iota
+	typeString
+	typeStringList // Also accepts a single string.
+	typeNumber
+)
+
+// tagSpec describes a tagged argument.
+type tagSpec struct {
+	arg   argType // Type of the argument following the tag, typeNone if the tag has no argument.
+	ext   string  // Required extension, if any.
+	group string  // Tags in the same group are mutually exclusive, e.g. match types.
+}
+
+// spec describes a command or test.
+type spec struct {
+	ext  string // Required extension, if any.
+	tags map[string]tagSpec
+	pos  []argType // Positional arguments.
+	opt  int       // Number of leading positional arguments that are optional.
+
+	tests int  // For commands: 0 for no test, 1 for a single test. For tests: -1 for a test list, 1 for a single test.
+	block bool // For commands.
+}
+
+var (
+	comparatorTags = map[string]tagSpec{
+		"comparator": {typeString, "", "comparator"},
+		"is":         {typeNone, "", "match"},
+		"contains":   {typeNone, "", "match"},
+		"matches":    {typeNone, "", "match"},
+	}
+	addressTags = map[string]tagSpec{
+		"all":       {typeNone, "", "addresspart"},
+		"localpart": {typeNone, "", "addresspart"},
+		"domain":    {typeNone, "", "addresspart"},
+	}
+)
+
+func tags(l ...map[string]tagSpec) map[string]tagSpec {
+	r := map[string]tagSpec{}
+	for _, m := range l {
+		for k, v := range m {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+var commands = map[string]spec{
+	// ../rfc/5228
+	"require":  {pos: []argType{typeStringList}},
+	"if":       {tests: 1, block: true},
+	"elsif":    {tests: 1, block: true},
+	"else":     {block: true},
+	"stop":     {},
+	"keep":     {tags: map[string]tagSpec{"flags": {typeStringList, "imap4flags", ""}}},
+	"discard":  {},
+	"redirect": {tags: map[string]tagSpec{"copy": {typeNone, "copy", ""}}, pos: []argType{typeString}},
+
+	"fileinto": {ext: "fileinto", tags: map[string]tagSpec{"copy": {typeNone, "copy", ""}, "flags": {typeStringList, "imap4flags", ""}}, pos: []argType{typeString}},
+
+	// ../rfc/5429
+	"reject":  {ext: "reject", pos: []argType{typeString}},
+	"ereject": {ext: "ereject", pos: []argType{typeString}},
+
+	// ../rfc/5230
+	"vacation": {ext: "vacation", tags: map[string]tagSpec{
+		"days":      {typeNumber, "", ""},
+		"subject":   {typeString, "", ""},
+		"from":      {typeString, "", ""},
+		"addresses": {typeStringList, "", ""},
+		"mime":      {typeNone, "", ""},
+		"handle":    {typeString, "", ""},
+	}, pos: []argType{typeString}},
+
+	// ../rfc/5232
+	"setflag":    {ext: "imap4flags", pos: []argType{typeString, typeStringList}, opt: 1},
+	"addflag":    {ext: "imap4flags", pos: []argType{typeString, typeStringList}, opt: 1},
+	"removeflag": {ext: "imap4flags", pos: []argType{typeString, typeStringList}, opt: 1},
+
+	// ../rfc/5229
+	"set": {ext: "variables", tags: map[string]tagSpec{
+		"lower":         {typeNone, "", "case"},
+		"upper":         {typeNone, "", "case"},
+		"lowerfirst":    {typeNone, "", "casefirst"},
+		"upperfirst":    {typeNone, "", "casefirst"},
+		"quotewildcard": {typeNone, "", "quotewildcard"},
+		"length":        {typeNone, "", "length"},
+	}, pos: []argType{typeString, typeString}},
+
+	// ../rfc/6609
+	"include": {ext: "include", tags: map[string]tagSpec{
+		"personal": {typeNone, "", "location"},
+		"global":   {typeNone, "", "location"},
+		"once":     {typeNone, "", ""},
+		"optional": {typeNone, "", ""},
+	}, pos: []argType{typeString}},
+	"return": {ext: "include"},
+	"global": {ext: "include", pos: []argType{typeStringList}},
+}
+
+var tests = map[string]spec{
+	// ../rfc/5228
+	"address": {tags: tags(comparatorTags, addressTags), pos: []argType{typeStringList, typeStringList}},
+	"header":  {tags: comparatorTags, pos: []argType{typeStringList, typeStringList}},
+	"exists":  {pos: []argType{typeStringList}},
+	"size": {tags: map[string]tagSpec{
+		"over":  {typeNone, "", "size"},
+		"under": {typeNone, "", "size"},
+	}, pos: []argType{typeNumber}},
+	"allof": {tests: -1},
+	"anyof": {tests: -1},
+	"not":   {tests: 1},
+	"true":  {},
+	"false": {},
+
+	"envelope": {ext: "envelope", tags: tags(comparatorTags, addressTags), pos: []argType{typeStringList, typeStringList}},
+
+	// ../rfc/5173
+	"body": {ext: "body", tags: tags(comparatorTags, map[string]tagSpec{
+		"raw":     {typeNone, "", "transform"},
+		"content": {typeStringList, "", "transform"},
+		"text":    {typeNone, "", "transform"},
+	}), pos: []argType{typeStringList}},
+
+	// ../rfc/5229
+	"string": {ext: "variables", tags: comparatorTags, pos: []argType{typeStringList, typeStringList}},
+
+	// ../rfc/5232
+	"hasflag": {ext: "imap4flags", tags: comparatorTags, pos: []argType{typeStringList, typeStringList}, opt: 1},
+}
+
+// checked holds the arguments of a command or test after checking, by tag and
+// position.
+type checked struct {
+	tags map[string]Arg // Arg of the tag, Kind ArgTag for tags without argument.
+	pos  []Arg
+}
+
+// Script is a parsed and checked script, ready for evaluation.
+type Script struct {
+	Commands []*Command
+	Require  []string // Extensions required by the script.
+}
+
+// Parse parses a script and checks its commands, tests and arguments, including
+// whether the extensions they need are required. Errors are of type ParseError.
+func Parse(script string) (rs *Script, rerr error) {
+	cmds, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(ParseError); ok {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	c := &checker{require: map[string]bool{}}
+	c.checkCommands(cmds, true)
+	s := &Script{Commands: cmds}
+	for ext := range c.require {
+		s.Require = append(s.Require, ext)
+	}
+	slices.Sort(s.Require)
+	return s, nil
+}
+
+func (s *Script) requires(ext string) bool {
+	return slices.Contains(s.Require, ext)
+}
+
+type checker struct {
+	require map[string]bool
+}
+
+func (c *checker) xerrorf(line int, format string, args ...any) {
+	panic(ParseError{line, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) checkExt(line int, ext, what string) {
+	if ext != "" && !c.require[ext] {
+		c.xerrorf(line, "%s requires extension %q", what, ext)
+	}
+}
+
+// checkArgs checks the arguments against the spec, and returns them by tag and
+// position.
+func (c *checker) checkArgs(line int, what string, sp spec, args []Arg) checked {
+	r := checked{tags: map[string]Arg{}}
+	groups := map[string]string{}
+	i := 0
+	for ; i < len(args) && args[i].Kind == ArgTag; i++ {
+		a := args[i]
+		ts, ok := sp.tags[a.Tag]
+		if !ok {
+			c.xerrorf(a.Line, "unknown tag :%s for %s", a.Tag, what)
+		}
+		c.checkExt(a.Line, ts.ext, fmt.Sprintf("tag :%s", a.Tag))
+		if _, ok := r.tags[a.Tag]; ok {
+			c.xerrorf(a.Line, "duplicate tag :%s for %s", a.Tag, what)
+		}
+		if ts.group != "" {
+			if other, ok := groups[ts.group]; ok {
+				c.xerrorf(a.Line, "tags :%s and :%s cannot be combined for %s", other, a.Tag, what)
+			}
+			groups[ts.group] = a.Tag
+		}
+		if ts.arg == typeNone {
+			r.tags[a.Tag] = a
+			continue
+		}
+		i++
+		if i >= len(args) {
+			c.xerrorf(a.Line, "missing argument for tag :%s", a.Tag)
+		}
+		c.checkType(args[i], ts.arg, fmt.Sprintf("tag :%s", a.Tag))
+		r.tags[a.Tag] = args[i]
+	}
+	pos := args[i:]
+	for _, a := range pos {
+		if a.Kind == ArgTag {
+			c.xerrorf(a.Line, "tag :%s must come before other arguments for %s", a.Tag, what)
+		}
+	}
+	types := sp.pos
+	if len(pos) < len(types) && len(types)-len(pos) <= sp.opt {
+		types = types[len(types)-len(pos):]
+	}
+	if len(pos) != len(types) {
+		c.xerrorf(line, "%s needs %d arguments, got %d", what, len(sp.pos), len(pos))
+	}
+	for j, a := range pos {
+		c.checkType(a, types[j], what)
+	}
+	if len(pos) < len(sp.pos) {
+		// Leading optional arguments are absent, we prepend empty args so positions stay the same.
+		pos = append(make([]Arg, len(sp.pos)-len(pos)), pos...)
+	}
+	r.pos = pos
+
+	if cmp, ok := r.tags["comparator"]; ok {
+		name := strings.ToLower(cmp.Strings[0])
+		// Both comparators are always available, they do not have to be required.
+		// ../rfc/5228
+		if name != "i;octet" && name != "i;ascii-casemap" {
+			c.xerrorf(cmp.Line, "unknown comparator %q", cmp.Strings[0])
+		}
+	}
+	return r
+}
+
+func (c *checker) checkType(a Arg, t argType, what string) {
+	switch t {
+	case typeString:
+		if a.Kind != ArgString {
+			c.xerrorf(a.Line, "expected string for %s, got %s", what, a)
+		}
+	case typeStringList:
+		if a.Kind != ArgString && a.Kind != ArgStringList {
+			c.xerrorf(a.Line, "expected string list for %s, got %s", what, a)
+		}
+	case typeNumber:
+		if a.Kind != ArgNumber {
+			c.xerrorf(a.Line, "expected number for %s, got %s", what, a)
+		}
+	}
+}
+
+// checkCommands checks commands in a block, or at top level.
+func (c *checker) checkCommands(cmds []*Command, top bool) {
+	prev := ""
+	for _, cmd := range cmds {
+		sp, ok := commands[cmd.Name]
+		if !ok {
+			c.xerrorf(cmd.Line, "unknown command %q", cmd.Name)
+		}
+		what := fmt.Sprintf("command %q", cmd.Name)
+		c.checkExt(cmd.Line, sp.ext, what)
+
+		switch cmd.Name {
+		case "require":
+			// ../rfc/5228
+			if !top || prev != "" && prev != "require" {
+				c.xerrorf(cmd.Line, "require must come before other commands")
+			}
+		case "elsif", "else":
+			if prev != "if" && prev != "elsif" {
+				c.xerrorf(cmd.Line, "%s must follow if or elsif", cmd.Name)
+			}
+		}
+
+		ca := c.checkArgs(cmd.Line, what, sp, cmd.Args)
+		cmd.args = ca
+		if cmd.Name == "require" {
+			for _, ext := range ca.pos[0].Strings {
+				if !slices.Contains(Extensions, ext) {
+					c.xerrorf(cmd.Line, "unsupported extension %q", ext)
+				}
+				c.require[ext] = true
+			}
+		}
+		if cmd.Name == "set" {
+			c.checkVariableName(cmd.Line, ca.pos[0].Strings[0])
+		}
+		if cmd.Name == "global" {
+			c.checkExt(cmd.Line, "variables", what)
+			for _, s := range ca.pos[0].Strings {
+				c.checkVariableName(cmd.Line, s)
+			}
+		}
+		if cmd.Name == "include" {
+			c.checkScriptName(cmd.Line, ca.pos[0].Strings[0])
+		}
+		if sp.tests == 0 && len(cmd.Tests) > 0 {
+			c.xerrorf(cmd.Line, "%s does not take a test", what)
+		} else if sp.tests == 1 && len(cmd.Tests) != 1 {
+			c.xerrorf(cmd.Line, "%s needs a single test", what)
+		}
+		for _, t := range cmd.Tests {
+			c.checkTest(t)
+		}
+		if sp.block != cmd.hasBlock {
+			if sp.block {
+				c.xerrorf(cmd.Line, "%s needs a block", what)
+			}
+			c.xerrorf(cmd.Line, "%s does not take a block", what)
+		}
+		c.checkCommands(cmd.Block, false)
+		prev = cmd.Name
+	}
+}
+
+func (c *checker) checkTest(t *Test) {
+	sp, ok := tests[t.Name]
+	if !ok {
+		c.xerrorf(t.Line, "unknown test %q", t.Name)
+	}
+	what := fmt.Sprintf("test %q", t.Name)
+	c.checkExt(t.Line, sp.ext, what)
+	ca := c.checkArgs(t.Line, what, sp, t.Args)
+	t.args = ca
+	switch sp.tests {
+	case 0:
+		if t.Tests != nil {
+			c.xerrorf(t.Line, "%s does not take tests", what)
+		}
+	case 1:
+		if len(t.Tests) != 1 {
+			c.xerrorf(t.Line, "%s needs a single test", what)
+		}
+	case -1:
+		if len(t.Tests) == 0 {
+			c.xerrorf(t.Line, "%s needs a test list", what)
+		}
+	}
+	if t.Name == "size" && len(ca.tags) == 0 {
+		c.xerrorf(t.Line, "test \"size\" needs :over or :under")
+	}
+	if t.Name == "envelope" {
+		for _, s := range ca.pos[0].Strings {
+			switch strings.ToLower(s) {
+			case "from", "to":
+			default:
+				c.xerrorf(t.Line, "unknown envelope part %q", s)
+			}
+		}
+	}
+	for _, tt := range t.Tests {
+		c.checkTest(tt)
+	}
+}
+
+// checkVariableName checks a variable name for set and global, letters, digits
+// and underscores, not starting with a digit. ../rfc/5229
+func (c *checker) checkVariableName(line int, s string) {
+	if s == "" {
+		c.xerrorf(line, "empty variable name")
+	}
+	for i := range len(s) {
+		if !isIdentChar(s[i], i == 0) {
+			c.xerrorf(line, "invalid variable name %q", s)
+		}
+	}
+}
+
+// checkScriptName checks the name of an included script. ../rfc/6609
+func (c *checker) checkScriptName(line int, s string) {
+	if err := CheckScriptName(s); err != nil {
+		c.xerrorf(line, "include: %v", err)
+	}
+}
+
+// CheckScriptName checks whether s is a valid name for a script, as stored and as
+// used in "include": non-empty, at most 128 characters, no control characters or
+// slashes.
+func CheckScriptName(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty script name")
+	}
+	if len([]rune(s)) > 128 {
+		return fmt.Errorf("script name too long")
+	}
+	for _, c := range s {
+		if c < ' ' || c == 0x7f || c == '/' {
+			return fmt.Errorf("invalid character in script name %q", s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,872 @@
+package sieve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/ianaindex"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrScriptNotFound is returned by a Loader for a script that does not exist.
+var ErrScriptNotFound = errors.New("script not found")
+
+// Loader returns a parsed script for "include", from the personal scripts of the
+// account, or the global scripts if global is set. If the script does not exist,
+// ErrScriptNotFound must be returned.
+type Loader func(name string, global bool) (*Script, error)
+
+// Input is the message a script is evaluated against.
+type Input struct {
+	MailFrom string // Envelope sender, empty for the null sender.
+	RcptTo   string // Envelope recipient.
+	Header   textproto.MIMEHeader
+
+	// Message with subparts parsed and a reader set, for the "body" test. If nil, body
+	// tests don't match.
+	Part *message.Part
+	Size int64
+}
+
+// Result holds the actions resulting from evaluating a script. With no actions, a
+// message is delivered to the default mailbox through the implicit keep.
+type Result struct {
+	Keep      bool     // Deliver to the default mailbox, through "keep" or the implicit keep.
+	KeepFlags []string // Flags for the keep, from imap4flags. E.g. `\Seen` or "$label".
+	FileInto  []FileInto
+	Redirects []string // Addresses to forward to.
+
+	Rejected     bool   // Message must be rejected, with RejectReason.
+	RejectReason string // Possibly multi-line.
+
+	Vacation *Vacation
+}
+
+// FileInto is a "fileinto" action.
+type FileInto struct {
+	Mailbox string
+	Flags   []string
+}
+
+// Vacation is a "vacation" action. The caller is responsible for not sending
+// responses to automated messages and mailing lists, and for keeping track of
+// responses sent per sender and handle. ../rfc/5230
+type Vacation struct {
+	Reason    string   // Message text, or a MIME part if MIME is set.
+	Days      int      // Minimum number of days between responses to the same sender.
+	Subject   string   // If empty, the subject of the incoming message is used with an "Auto: " prefix.
+	From      string   // If empty, the recipient address is used.
+	Addresses []string // Additional addresses of the recipient.
+	MIME      bool     // Whether Reason is a MIME part, with headers.
+	Handle    string   // Identifies this response for tracking responses sent, derived from the other fields if not explicitly set.
+}
+
+// MaxRedirects is the maximum number of redirect actions during an evaluation.
+const MaxRedirects = 5
+
+// Limits on evaluation, to prevent resource exhaustion.
+const (
+	maxIncludeDepth = 10
+	maxIncludes     = 64
+	maxVariableSize = 64 * 1024
+	maxBodySize     = 1024 * 1024
+	maxMatchSteps   = 1000 * 1000
+)
+
+type runtimeError struct {
+	err error
+}
+
+type flow int
+
+const (
+	flowNext   flow = iota
+	flowReturn      // From included script.
+	flowStop
+)
+
+type evaluator struct {
+	input  Input
+	loader Loader
+
+	result       Result
+	implicitKeep bool
+	explicitKeep bool
+	flags        []string // Internal flags variable. ../rfc/5232
+	globals      map[string]string
+	included     map[string]bool
+	nincludes    int
+	bodies       map[string][]string // Cache of body texts.
+}
+
+// frame is the state of a (possibly included) script.
+type frame struct {
+	script  *Script
+	depth   int
+	vars    map[string]string
+	global  map[string]bool // Variables declared global.
+	matches []string        // Match variables from the last successful :matches.
+}
+
+func (e *evaluator) xerrorf(format string, args ...any) {
+	panic(runtimeError{fmt.Errorf(format, args...)})
+}
+
+// Evaluate executes a script for a message, returning the resulting actions.
+//
+// If an error occurs during execution, the error is returned along with a result
+// with only the implicit keep, as required for Sieve.
+func Evaluate(s *Script, input Input, loader Loader) (r Result, rerr error) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(runtimeError); ok {
+			r = Result{Keep: true}
+			rerr = err.err
+			return
+		}
+		panic(x)
+	}()
+
+	e := &evaluator{
+		input:        input,
+		loader:       loader,
+		implicitKeep: true,
+		globals:      map[string]string{},
+		included:     map[string]bool{},
+		bodies:       map[string][]string{},
+	}
+	e.run(s, 0)
+
+	r = e.result
+	if r.Rejected && (e.explicitKeep || len(r.FileInto) > 0 || len(r.Redirects) > 0 || r.Vacation != nil) {
+		// ../rfc/5429
+		e.xerrorf("reject cannot be combined with keep, fileinto, redirect or vacation")
+	}
+	if e.explicitKeep || e.implicitKeep && !r.Rejected {
+		r.Keep = true
+		if !e.explicitKeep {
+			r.KeepFlags = e.flags
+		}
+	}
+	return r, nil
+}
+
+func (e *evaluator) run(s *Script, depth int) flow {
+	f := &frame{script: s, depth: depth, vars: map[string]string{}, global: map[string]bool{}}
+	return e.execCommands(f, s.Commands)
+}
+
+func (e *evaluator) execCommands(f *frame, cmds []*Command) flow {
+	var taken bool // Whether a branch of the current if/elsif/else chain was taken.
+	for _, cmd := range cmds {
+		switch cmd.Name {
+		case "if", "elsif", "else":
+			if cmd.Name == "if" {
+				taken = false
+			}
+			if taken || cmd.Name != "else" && !e.test(f, cmd.Tests[0]) {
+				continue
+			}
+			taken = true
+			if fl := e.execCommands(f, cmd.Block); fl != flowNext {
+				return fl
+			}
+			continue
+		}
+		if fl := e.exec(f, cmd); fl != flowNext {
+			return fl
+		}
+	}
+	return flowNext
+}
+
+func (e *evaluator) exec(f *frame, cmd *Command) flow {
+	a := cmd.args
+	switch cmd.Name {
+	case "require":
+	case "stop":
+		return flowStop
+	case "return":
+		return flowReturn
+
+	case "keep":
+		e.explicitKeep = true
+		if fa, ok := a.tags["flags"]; ok {
+			e.result.KeepFlags = addFlags(e.result.KeepFlags, e.strs(f, fa.Strings))
+		} else {
+			e.result.KeepFlags = addFlags(e.result.KeepFlags, e.flags)
+		}
+
+	case "discard":
+		e.implicitKeep = false
+
+	case "fileinto":
+		if _, ok := a.tags["copy"]; !ok {
+			e.implicitKeep = false
+		}
+		fi := FileInto{Mailbox: e.str(f, a.pos[0].Strings[0]), Flags: e.flags}
+		if fa, ok := a.tags["flags"]; ok {
+			fi.Flags = addFlags(nil, e.strs(f, fa.Strings))
+		}
+		if fi.Mailbox == "" {
+			e.xerrorf("line %d: fileinto with empty mailbox name", cmd.Line)
+		}
+		for _, x := range e.result.FileInto {
+			if x.Mailbox == fi.Mailbox {
+				return flowNext
+			}
+		}
+		e.result.FileInto = append(e.result.FileInto, fi)
+
+	case "redirect":
+		if _, ok := a.tags["copy"]; !ok {
+			e.implicitKeep = false
+		}
+		s := e.str(f, a.pos[0].Strings[0])
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			e.xerrorf("line %d: redirect to invalid address %q: %v", cmd.Line, s, err)
+		}
+		s = addr.String()
+		for _, x := range e.result.Redirects {
+			if x == s {
+				return flowNext
+			}
+		}
+		if len(e.result.Redirects) >= MaxRedirects {
+			e.xerrorf("line %d: too many redirects, max %d", cmd.Line, MaxRedirects)
+		}
+		e.result.Redirects = append(e.result.Redirects, s)
+
+	case "reject", "ereject":
+		e.implicitKeep = false
+		e.result.Rejected = true
+		e.result.RejectReason = e.str(f, a.pos[0].Strings[0])
+
+	case "vacation":
+		if e.result.Vacation != nil {
+			e.xerrorf("line %d: multiple vacation actions", cmd.Line)
+		}
+		v := &Vacation{Reason: e.str(f, a.pos[0].Strings[0]), Days: 7}
+		if x, ok := a.tags["days"]; ok {
+			v.Days = int(min(max(x.Number, 1), 365))
+		}
+		if x, ok := a.tags["subject"]; ok {
+			v.Subject = e.str(f, x.Strings[0])
+		}
+		if x, ok := a.tags["from"]; ok {
+			v.From = e.str(f, x.Strings[0])
+		}
+		if x, ok := a.tags["addresses"]; ok {
+			v.Addresses = e.strs(f, x.Strings)
+		}
+		_, v.MIME = a.tags["mime"]
+		if x, ok := a.tags["handle"]; ok {
+			v.Handle = e.str(f, x.Strings[0])
+		} else {
+			// ../rfc/5230
+			h := sha256.Sum256([]byte(fmt.Sprintf("%q %q %q %v", v.Reason, v.Subject, v.From, v.MIME)))
+			v.Handle = hex.EncodeToString(h[:16])
+		}
+		e.result.Vacation = v
+
+	case "setflag", "addflag", "removeflag":
+		var name string
+		if len(a.pos[0].Strings) > 0 {
+			name = a.pos[0].Strings[0]
+		}
+		var cur []string
+		if cmd.Name != "setflag" {
+			cur = e.getFlags(f, name)
+		}
+		l := e.strs(f, a.pos[1].Strings)
+		if cmd.Name == "removeflag" {
+			cur = removeFlags(cur, l)
+		} else {
+			cur = addFlags(cur, l)
+		}
+		e.setFlags(f, name, cur)
+
+	case "set":
+		v := e.str(f, a.pos[1].Strings[0])
+		if _, ok := a.tags["lower"]; ok {
+			v = strings.ToLower(v)
+		} else if _, ok := a.tags["upper"]; ok {
+			v = strings.ToUpper(v)
+		}
+		if c, n := utf8.DecodeRuneInString(v); n > 0 {
+			if _, ok := a.tags["lowerfirst"]; ok {
+				v = string(unicode.ToLower(c)) + v[n:]
+			} else if _, ok := a.tags["upperfirst"]; ok {
+				v = string(unicode.ToUpper(c)) + v[n:]
+			}
+		}
+		if _, ok := a.tags["quotewildcard"]; ok {
+			v = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(v)
+		}
+		if _, ok := a.tags["length"]; ok {
+			v = strconv.Itoa(utf8.RuneCountInString(v))
+		}
+		e.setVar(f, a.pos[0].Strings[0], v)
+
+	case "global":
+		for _, s := range a.pos[0].Strings {
+			f.global[strings.ToLower(s)] = true
+		}
+
+	case "include":
+		name := a.pos[0].Strings[0]
+		_, global := a.tags["global"]
+		key := "personal:" + name
+		if global {
+			key = "global:" + name
+		}
+		if _, ok := a.tags["once"]; ok && e.included[key] {
+			return flowNext
+		}
+		if f.depth+1 > maxIncludeDepth {
+			e.xerrorf("line %d: include: includes nested too deep, max %d", cmd.Line, maxIncludeDepth)
+		}
+		e.nincludes++
+		if e.nincludes > maxIncludes {
+			e.xerrorf("line %d: include: too many includes, max %d", cmd.Line, maxIncludes)
+		}
+		if e.loader == nil {
+			e.xerrorf("line %d: include: no scripts available", cmd.Line)
+		}
+		s, err := e.loader(name, global)
+		if errors.Is(err, ErrScriptNotFound) {
+			if _, ok := a.tags["optional"]; ok {
+				return flowNext
+			}
+			e.xerrorf("line %d: include: script %q not found", cmd.Line, name)
+		} else if err != nil {
+			e.xerrorf("line %d: include: loading script %q: %v", cmd.Line, name, err)
+		}
+		e.included[key] = true
+		if e.run(s, f.depth+1) == flowStop {
+			return flowStop
+		}
+
+	default:
+		// Parse has checked all commands.
+		panic(fmt.Sprintf("unknown command %q", cmd.Name))
+	}
+	return flowNext
+}
+
+func (e *evaluator) test(f *frame, t *Test) bool {
+	a := t.args
+	switch t.Name {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "not":
+		return !e.test(f, t.Tests[0])
+	case "allof":
+		for _, tt := range t.Tests {
+			if !e.test(f, tt) {
+				return false
+			}
+		}
+		return true
+	case "anyof":
+		for _, tt := range t.Tests {
+			if e.test(f, tt) {
+				return true
+			}
+		}
+		return false
+
+	case "exists":
+		for _, h := range e.strs(f, a.pos[0].Strings) {
+			if len(e.input.Header.Values(h)) == 0 {
+				return false
+			}
+		}
+		return true
+
+	case "size":
+		if _, ok := a.tags["over"]; ok {
+			return e.input.Size > a.pos[0].Number
+		}
+		return e.input.Size < a.pos[0].Number
+
+	case "header":
+		var values []string
+		for _, h := range e.strs(f, a.pos[0].Strings) {
+			for _, v := range e.input.Header.Values(h) {
+				values = append(values, decodeHeader(v))
+			}
+		}
+		return e.match(f, a, values, e.strs(f, a.pos[1].Strings))
+
+	case "address":
+		var values []string
+		for _, h := range e.strs(f, a.pos[0].Strings) {
+			for _, v := range e.input.Header.Values(h) {
+				addrs, err := (&mail.AddressParser{WordDecoder: &wordDecoder}).ParseList(v)
+				if err != nil {
+					// Fall back to the whole value, it may still match.
+					values = append(values, addressPart(a, strings.TrimSpace(v)))
+					continue
+				}
+				for _, addr := range addrs {
+					values = append(values, addressPart(a, addr.Address))
+				}
+			}
+		}
+		return e.match(f, a, values, e.strs(f, a.pos[1].Strings))
+
+	case "envelope":
+		var values []string
+		for _, p := range a.pos[0].Strings {
+			switch strings.ToLower(p) {
+			case "from":
+				values = append(values, addressPart(a, e.input.MailFrom))
+			case "to":
+				values = append(values, addressPart(a, e.input.RcptTo))
+			}
+		}
+		return e.match(f, a, values, e.strs(f, a.pos[1].Strings))
+
+	case "body":
+		return e.match(f, a, e.body(f, a), e.strs(f, a.pos[0].Strings))
+
+	case "string":
+		return e.match(f, a, e.strs(f, a.pos[0].Strings), e.strs(f, a.pos[1].Strings))
+
+	case "hasflag":
+		var flags []string
+		if len(a.pos[0].Strings) == 0 {
+			flags = e.getFlags(f, "")
+		}
+		for _, name := range a.pos[0].Strings {
+			flags = append(flags, e.getFlags(f, name)...)
+		}
+		return e.match(f, a, flags, e.strs(f, a.pos[1].Strings))
+	}
+	// Parse has checked all tests.
+	panic(fmt.Sprintf("unknown test %q", t.Name))
+}
+
+// addressPart returns the part of address s for the :all, :localpart or :domain
+// tag. ../rfc/5228
+func addressPart(a checked, s string) string {
+	i := strings.LastIndexByte(s, '@')
+	if _, ok := a.tags["localpart"]; ok {
+		if i < 0 {
+			return s
+		}
+		return s[:i]
+	}
+	if _, ok := a.tags["domain"]; ok {
+		if i < 0 {
+			return ""
+		}
+		return s[i+1:]
+	}
+	return s
+}
+
+// match compares values against keys with the comparator and match type from the
+// arguments, setting match variables for a successful :matches.
+func (e *evaluator) match(f *frame, a checked, values, keys []string) bool {
+	fold := true
+	if c, ok := a.tags["comparator"]; ok && strings.EqualFold(c.Strings[0], "i;octet") {
+		fold = false
+	}
+	_, contains := a.tags["contains"]
+	_, matches := a.tags["matches"]
+	for _, v := range values {
+		for _, k := range keys {
+			switch {
+			case matches:
+				if caps, ok := globMatch(k, v, fold); ok {
+					f.matches = caps
+					return true
+				}
+			case contains:
+				if fold {
+					if strings.Contains(asciiLower(v), asciiLower(k)) {
+						return true
+					}
+				} else if strings.Contains(v, k) {
+					return true
+				}
+			default:
+				if fold && asciiLower(v) == asciiLower(k) || !fold && v == k {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// asciiLower lower cases only ASCII letters, as in the i;ascii-casemap
+// comparator.
+func asciiLower(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'A' && c <= 'Z' {
+			return c + ('a' - 'A')
+		}
+		return c
+	}, s)
+}
+
+// globMatch matches s against pattern with "*" for zero or more characters, "?"
+// for a single character and backslash as escape. On a match, the returned
+// captures have s as first element, followed by the text matched by each
+// wildcard, with "*" matching as few characters as possible. ../rfc/5229
+func globMatch(pattern, s string, fold bool) ([]string, bool) {
+	type tok struct {
+		wild rune // '*' or '?', or 0 for literal.
+		c    rune
+	}
+	var pat []tok
+	var esc bool
+	for _, c := range pattern {
+		switch {
+		case esc:
+			pat = append(pat, tok{0, c})
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '*' || c == '?':
+			pat = append(pat, tok{c, 0})
+		default:
+			pat = append(pat, tok{0, c})
+		}
+	}
+	str := []rune(s)
+	lower := func(c rune) rune {
+		if fold && c >= 'A' && c <= 'Z' {
+			return c + ('a' - 'A')
+		}
+		return c
+	}
+
+	caps := []string{s}
+	steps := 0
+	var rec func(pi, si int) bool
+	rec = func(pi, si int) bool {
+		steps++
+		if steps > maxMatchSteps {
+			return false
+		}
+		if pi == len(pat) {
+			return si == len(str)
+		}
+		t := pat[pi]
+		switch t.wild {
+		case '?':
+			if si >= len(str) {
+				return false
+			}
+			caps = append(caps, string(str[si]))
+			if rec(pi+1, si+1) {
+				return true
+			}
+			caps = caps[:len(caps)-1]
+			return false
+		case '*':
+			for n := 0; si+n <= len(str); n++ {
+				caps = append(caps, string(str[si:si+n]))
+				if rec(pi+1, si+n) {
+					return true
+				}
+				caps = caps[:len(caps)-1]
+			}
+			return false
+		}
+		if si >= len(str) || lower(str[si]) != lower(t.c) {
+			return false
+		}
+		return rec(pi+1, si+1)
+	}
+	if !rec(0, 0) {
+		return nil, false
+	}
+	return caps, true
+}
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// body returns the texts to match for a body test, with the transform from the
+// :raw, :content or :text tag. ../rfc/5173
+func (e *evaluator) body(f *frame, a checked) []string {
+	p := e.input.Part
+	if p == nil {
+		return nil
+	}
+
+	if _, ok := a.tags["raw"]; ok {
+		if l, ok := e.bodies["raw"]; ok {
+			return l
+		}
+		buf, err := io.ReadAll(io.LimitReader(p.RawReader(), maxBodySize))
+		if err != nil {
+			e.xerrorf("reading message body: %v", err)
+		}
+		l := []string{string(buf)}
+		e.bodies["raw"] = l
+		return l
+	}
+
+	// Without :content, the default is :text, with text parts only and HTML tags
+	// removed.
+	types := []string{"text"}
+	text := true
+	key := "text"
+	if x, ok := a.tags["content"]; ok {
+		types = e.strs(f, x.Strings)
+		text = false
+		key = "content:" + strings.Join(types, "\n")
+	}
+	if l, ok := e.bodies[key]; ok {
+		return l
+	}
+
+	var l []string
+	var walk func(p *message.Part)
+	walk = func(p *message.Part) {
+		if len(p.Parts) > 0 {
+			for i := range p.Parts {
+				walk(&p.Parts[i])
+			}
+			return
+		}
+		if p.Message != nil {
+			walk(p.Message)
+			return
+		}
+		mt := strings.ToLower(p.MediaType)
+		st := strings.ToLower(p.MediaSubType)
+		if mt == "" {
+			mt, st = "text", "plain"
+		}
+		var ok bool
+		for _, t := range types {
+			tt, ts, _ := strings.Cut(strings.ToLower(t), "/")
+			if tt == "" || tt == mt && (ts == "" || ts == st) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return
+		}
+		var r io.Reader
+		if mt == "text" {
+			r = p.ReaderUTF8OrBinary()
+		} else {
+			r = p.Reader()
+		}
+		buf, err := io.ReadAll(io.LimitReader(r, maxBodySize))
+		if err != nil {
+			e.xerrorf("reading message part: %v", err)
+		}
+		s := string(buf)
+		if text && st == "html" {
+			s = htmlTagRegexp.ReplaceAllString(s, " ")
+		}
+		l = append(l, s)
+	}
+	walk(p)
+	e.bodies[key] = l
+	return l
+}
+
+// str returns s with variables expanded, if the script requires "variables".
+func (e *evaluator) str(f *frame, s string) string {
+	if !f.script.requires("variables") || !strings.Contains(s, "${") {
+		return s
+	}
+
+	// ../rfc/5229
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			b.WriteString(s)
+			break
+		}
+		name := s[2:end]
+		if v, ok := e.lookup(f, name); ok {
+			b.WriteString(v)
+			s = s[end+1:]
+		} else {
+			// Not a valid variable reference, the "${" is taken literally.
+			b.WriteString("${")
+			s = s[2:]
+		}
+		if b.Len() > maxVariableSize {
+			e.xerrorf("string with variables too long")
+		}
+	}
+	return b.String()
+}
+
+func (e *evaluator) strs(f *frame, l []string) []string {
+	r := make([]string, len(l))
+	for i, s := range l {
+		r[i] = e.str(f, s)
+	}
+	return r
+}
+
+// lookup returns the value of a variable reference. Undefined variables have an
+// empty value. If name is not a valid reference, e.g. a namespace other than
+// "global", false is returned.
+func (e *evaluator) lookup(f *frame, name string) (string, bool) {
+	if name != "" && strings.Trim(name, "0123456789") == "" {
+		n, err := strconv.Atoi(name)
+		if err != nil || n >= len(f.matches) {
+			return "", true
+		}
+		return f.matches[n], true
+	}
+	name = strings.ToLower(name)
+	if rest, ok := strings.CutPrefix(name, "global."); ok {
+		if !validVariableName(rest) {
+			return "", false
+		}
+		return e.globals[rest], true
+	}
+	if !validVariableName(name) {
+		return "", false
+	}
+	if f.global[name] {
+		return e.globals[name], true
+	}
+	return f.vars[name], true
+}
+
+func validVariableName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if !isIdentChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *evaluator) setVar(f *frame, name, v string) {
+	if len(v) > maxVariableSize {
+		e.xerrorf("value for variable %q too long", name)
+	}
+	name = strings.ToLower(name)
+	if f.global[name] {
+		e.globals[name] = v
+	} else {
+		f.vars[name] = v
+	}
+}
+
+// getFlags returns the flags in a variable, or the internal flags variable if
+// name is empty.
+func (e *evaluator) getFlags(f *frame, name string) []string {
+	if name == "" {
+		return e.flags
+	}
+	v, _ := e.lookup(f, name)
+	return addFlags(nil, []string{v})
+}
+
+func (e *evaluator) setFlags(f *frame, name string, flags []string) {
+	if name == "" {
+		e.flags = flags
+		return
+	}
+	e.setVar(f, name, strings.Join(flags, " "))
+}
+
+// addFlags adds the space-separated flags in l to flags, skipping duplicates,
+// which are compared case-insensitively. ../rfc/5232
+func addFlags(flags []string, l []string) []string {
+	r := slices.Clone(flags)
+	for _, s := range l {
+	next:
+		for _, fl := range strings.Fields(s) {
+			for _, x := range r {
+				if strings.EqualFold(x, fl) {
+					continue next
+				}
+			}
+			r = append(r, fl)
+		}
+	}
+	return r
+}
+
+// removeFlags removes the space-separated flags in l from flags.
+func removeFlags(flags []string, l []string) []string {
+	rm := addFlags(nil, l)
+	var r []string
+next:
+	for _, fl := range flags {
+		for _, x := range rm {
+			if strings.EqualFold(x, fl) {
+				continue next
+			}
+		}
+		r = append(r, fl)
+	}
+	return r
+}
+
+var wordDecoder = mime.WordDecoder{
+	CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "", "us-ascii", "utf-8":
+			return r, nil
+		}
+		enc, _ := ianaindex.MIME.Encoding(charset)
+		if enc == nil {
+			enc, _ = ianaindex.IANA.Encoding(charset)
+		}
+		if enc == nil {
+			return r, fmt.Errorf("unknown charset %q", charset)
+		}
+		return enc.NewDecoder().Reader(r), nil
+	},
+}
+
+// decodeHeader returns the header value with RFC 2047 encoded-words decoded, or
+// the raw value if decoding fails.
+func decodeHeader(v string) string {
+	s, err := wordDecoder.DecodeHeader(v)
+	if err != nil {
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(s)
+}
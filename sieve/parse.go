@@ -0,0 +1,430 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command is a command in a script, e.g. "if", "fileinto" or "require". Only
+// control commands have a block.
+type Command struct {
+	Name  string // Lower case.
+	Args  []Arg
+	Tests []*Test // Test or test list.
+	Block []*Command
+	Line  int
+
+	hasBlock bool
+	args     checked // Set by Parse.
+}
+
+// Test is a test in a script, e.g. "header", "address" or "anyof".
+type Test struct {
+	Name  string // Lower case.
+	Args  []Arg
+	Tests []*Test // For allof, anyof and not.
+	Line  int
+
+	args checked // Set by Parse.
+}
+
+// ArgKind is the kind of an argument.
+type ArgKind int
+
+const (
+	ArgTag        ArgKind = iota // E.g. ":is".
+	ArgNumber                    // E.g. 100K.
+	ArgString                    // Single string, quoted or multi-line.
+	ArgStringList                // List of strings, e.g. ["a", "b"].
+)
+
+// Arg is an argument for a command or test.
+type Arg struct {
+	Kind    ArgKind
+	Tag     string   // For ArgTag, lower case, without colon.
+	Number  int64    // For ArgNumber, with quantifier applied.
+	Strings []string // For ArgString (single element) and ArgStringList.
+	Line    int
+}
+
+func (a Arg) String() string {
+	switch a.Kind {
+	case ArgTag:
+		return ":" + a.Tag
+	case ArgNumber:
+		return strconv.FormatInt(a.Number, 10)
+	case ArgString:
+		return strconv.Quote(a.Strings[0])
+	}
+	l := make([]string, len(a.Strings))
+	for i, s := range a.Strings {
+		l[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(l, ", ") + "]"
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdentifier
+	tokTag
+	tokNumber
+	tokString
+	tokSpecial // One of []{}(),;
+)
+
+type token struct {
+	kind tokenKind
+	s    string // Identifier/tag lower case, string value, or special character.
+	num  int64
+	line int
+}
+
+// ParseError is returned for syntax and semantic errors in a script.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type parser struct {
+	s    string
+	o    int
+	line int
+	peek *token
+}
+
+func (p *parser) xerrorf(line int, format string, args ...any) {
+	panic(ParseError{line, fmt.Sprintf(format, args...)})
+}
+
+// skip skips whitespace and comments. ../rfc/5228
+func (p *parser) skip() {
+	for p.o < len(p.s) {
+		switch c := p.s[p.o]; {
+		case c == '\n':
+			p.line++
+			p.o++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.o++
+		case c == '#':
+			for p.o < len(p.s) && p.s[p.o] != '\n' {
+				p.o++
+			}
+		case strings.HasPrefix(p.s[p.o:], "/*"):
+			line := p.line
+			end := strings.Index(p.s[p.o+2:], "*/")
+			if end < 0 {
+				p.xerrorf(line, "unterminated comment")
+			}
+			p.line += strings.Count(p.s[p.o:p.o+2+end], "\n")
+			p.o += 2 + end + 2
+		default:
+			return
+		}
+	}
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || !first && c >= '0' && c <= '9'
+}
+
+func (p *parser) next() token {
+	if p.peek != nil {
+		t := *p.peek
+		p.peek = nil
+		return t
+	}
+
+	p.skip()
+	line := p.line
+	if p.o >= len(p.s) {
+		return token{kind: tokEOF, line: line}
+	}
+	c := p.s[p.o]
+	switch {
+	case strings.IndexByte("[]{}(),;", c) >= 0:
+		p.o++
+		return token{kind: tokSpecial, s: string(c), line: line}
+
+	case c == ':':
+		p.o++
+		if p.o >= len(p.s) || !isIdentChar(p.s[p.o], true) {
+			p.xerrorf(line, "expected identifier after colon")
+		}
+		return token{kind: tokTag, s: p.ident(), line: line}
+
+	case c >= '0' && c <= '9':
+		start := p.o
+		for p.o < len(p.s) && p.s[p.o] >= '0' && p.s[p.o] <= '9' {
+			p.o++
+		}
+		v, err := strconv.ParseInt(p.s[start:p.o], 10, 64)
+		if err != nil {
+			p.xerrorf(line, "parsing number: %v", err)
+		}
+		// Quantifiers. ../rfc/5228
+		if p.o < len(p.s) {
+			var shift uint
+			switch p.s[p.o] {
+			case 'k', 'K':
+				shift = 10
+			case 'm', 'M':
+				shift = 20
+			case 'g', 'G':
+				shift = 30
+			}
+			if shift > 0 {
+				p.o++
+				if v > (1<<62)>>shift {
+					p.xerrorf(line, "number too large")
+				}
+				v <<= shift
+			}
+		}
+		return token{kind: tokNumber, num: v, line: line}
+
+	case c == '"':
+		return token{kind: tokString, s: p.quoted(), line: line}
+
+	case isIdentChar(c, true):
+		id := p.ident()
+		if id == "text" && p.o < len(p.s) && p.s[p.o] == ':' {
+			p.o++
+			return token{kind: tokString, s: p.multiline(), line: line}
+		}
+		return token{kind: tokIdentifier, s: id, line: line}
+	}
+	p.xerrorf(line, "unexpected character %q", c)
+	panic("not reached")
+}
+
+func (p *parser) ident() string {
+	start := p.o
+	for p.o < len(p.s) && isIdentChar(p.s[p.o], p.o == start) {
+		p.o++
+	}
+	return strings.ToLower(p.s[start:p.o])
+}
+
+// quoted parses a quoted string. Only \" and \\ are meaningful escapes, a
+// backslash before other characters is removed. ../rfc/5228
+func (p *parser) quoted() string {
+	line := p.line
+	p.o++ // Opening quote.
+	var b strings.Builder
+	for {
+		if p.o >= len(p.s) {
+			p.xerrorf(line, "unterminated string")
+		}
+		c := p.s[p.o]
+		p.o++
+		switch c {
+		case '"':
+			return b.String()
+		case '\\':
+			if p.o >= len(p.s) {
+				p.xerrorf(line, "unterminated string")
+			}
+			c = p.s[p.o]
+			p.o++
+		case '\n':
+			p.line++
+		}
+		b.WriteByte(c)
+	}
+}
+
+// multiline parses a multi-line string after "text:", ending with a line with
+// only a dot. Leading double dots are unstuffed. ../rfc/5228
+func (p *parser) multiline() string {
+	line := p.line
+	// Rest of the "text:" line can only have whitespace and a comment.
+	for p.o < len(p.s) && (p.s[p.o] == ' ' || p.s[p.o] == '\t') {
+		p.o++
+	}
+	if p.o < len(p.s) && p.s[p.o] == '#' {
+		for p.o < len(p.s) && p.s[p.o] != '\n' {
+			p.o++
+		}
+	}
+	if p.o < len(p.s) && p.s[p.o] == '\r' {
+		p.o++
+	}
+	if p.o >= len(p.s) || p.s[p.o] != '\n' {
+		p.xerrorf(line, "expected end of line after text:")
+	}
+	p.o++
+	p.line++
+
+	var b strings.Builder
+	for {
+		if p.o >= len(p.s) {
+			p.xerrorf(line, "unterminated multi-line string")
+		}
+		end := strings.IndexByte(p.s[p.o:], '\n')
+		var l string
+		if end < 0 {
+			l = p.s[p.o:]
+			p.o = len(p.s)
+		} else {
+			l = p.s[p.o : p.o+end]
+			p.o += end + 1
+		}
+		p.line++
+		l = strings.TrimSuffix(l, "\r")
+		if l == "." {
+			return b.String()
+		}
+		// Lines starting with a dot are dot-stuffed.
+		l = strings.TrimPrefix(l, ".")
+		b.WriteString(l)
+		b.WriteString("\r\n")
+	}
+}
+
+func (p *parser) unread(t token) {
+	p.peek = &t
+}
+
+func (p *parser) isSpecial(t token, s string) bool {
+	return t.kind == tokSpecial && t.s == s
+}
+
+// parseCommands parses commands until EOF or a closing brace.
+func (p *parser) parseCommands(inBlock bool) []*Command {
+	var l []*Command
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			if inBlock {
+				p.xerrorf(t.line, "missing closing brace")
+			}
+			return l
+		case p.isSpecial(t, "}"):
+			if !inBlock {
+				p.xerrorf(t.line, "unexpected closing brace")
+			}
+			return l
+		case t.kind != tokIdentifier:
+			p.xerrorf(t.line, "expected command, got %s", p.describe(t))
+		}
+
+		cmd := &Command{Name: t.s, Line: t.line}
+		cmd.Args, cmd.Tests = p.parseArguments()
+		t = p.next()
+		switch {
+		case p.isSpecial(t, ";"):
+		case p.isSpecial(t, "{"):
+			cmd.hasBlock = true
+			cmd.Block = p.parseCommands(true)
+		default:
+			p.xerrorf(t.line, "expected semicolon or block after command %q, got %s", cmd.Name, p.describe(t))
+		}
+		l = append(l, cmd)
+	}
+}
+
+// parseArguments parses arguments, followed by an optional test or test list.
+func (p *parser) parseArguments() ([]Arg, []*Test) {
+	var args []Arg
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokTag:
+			args = append(args, Arg{Kind: ArgTag, Tag: t.s, Line: t.line})
+		case t.kind == tokNumber:
+			args = append(args, Arg{Kind: ArgNumber, Number: t.num, Line: t.line})
+		case t.kind == tokString:
+			args = append(args, Arg{Kind: ArgString, Strings: []string{t.s}, Line: t.line})
+		case p.isSpecial(t, "["):
+			var l []string
+			for {
+				t := p.next()
+				if t.kind != tokString {
+					p.xerrorf(t.line, "expected string in string list, got %s", p.describe(t))
+				}
+				l = append(l, t.s)
+				t = p.next()
+				if p.isSpecial(t, "]") {
+					break
+				} else if !p.isSpecial(t, ",") {
+					p.xerrorf(t.line, "expected comma or closing bracket in string list, got %s", p.describe(t))
+				}
+			}
+			args = append(args, Arg{Kind: ArgStringList, Strings: l, Line: t.line})
+		case t.kind == tokIdentifier:
+			p.unread(t)
+			return args, []*Test{p.parseTest()}
+		case p.isSpecial(t, "("):
+			var tests []*Test
+			for {
+				tests = append(tests, p.parseTest())
+				t := p.next()
+				if p.isSpecial(t, ")") {
+					break
+				} else if !p.isSpecial(t, ",") {
+					p.xerrorf(t.line, "expected comma or closing parenthesis in test list, got %s", p.describe(t))
+				}
+			}
+			return args, tests
+		default:
+			p.unread(t)
+			return args, nil
+		}
+	}
+}
+
+func (p *parser) parseTest() *Test {
+	t := p.next()
+	if t.kind != tokIdentifier {
+		p.xerrorf(t.line, "expected test, got %s", p.describe(t))
+	}
+	test := &Test{Name: t.s, Line: t.line}
+	var tests []*Test
+	test.Args, tests = p.parseArguments()
+	if tests != nil {
+		test.Tests = tests
+	}
+	return test
+}
+
+func (p *parser) describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokIdentifier:
+		return fmt.Sprintf("identifier %q", t.s)
+	case tokTag:
+		return fmt.Sprintf("tag :%s", t.s)
+	case tokNumber:
+		return fmt.Sprintf("number %d", t.num)
+	case tokString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", t.s)
+}
+
+// parse parses the syntax of a script, without checking commands and arguments.
+func parse(script string) (cmds []*Command, rerr error) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(ParseError); ok {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	p := &parser{s: script, line: 1}
+	return p.parseCommands(false), nil
+}
@@ -0,0 +1,220 @@
+package sieve
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/message"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+}
+
+func TestParse(t *testing.T) {
+	bad := func(script string) {
+		t.Helper()
+		_, err := Parse(script)
+		var perr ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("parse %q: got err %v, expected parse error", script, err)
+		}
+	}
+	good := func(script string) {
+		t.Helper()
+		_, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+	}
+
+	good("")
+	good("# comment\nkeep;")
+	good("/* multi\nline */ keep;")
+	good(`require ["fileinto", "imap4flags"]; if header :contains "subject" "x" { fileinto :flags "\\Seen" "Spam"; } elsif true { discard; } else { keep; }`)
+	good(`if size :over 100K { discard; }`)
+	good(`require "vacation"; vacation :days 3 :subject "away" :addresses ["a@example.com"] text:
+I'm away.
+..
+.
+;`)
+	good(`require "variables"; set :lower :upperfirst "name" "${1}"; if string :is "${name}" "" { stop; }`)
+	good(`require "include"; include :personal :once "other"; return;`)
+	good(`require ["include", "variables"]; global "x";`)
+	good(`require "imap4flags"; setflag "\\Flagged"; addflag "var" "$label";`)
+	good(`require "body"; if body :content "text" :contains "x" { discard; }`)
+	good(`if address :domain :comparator "i;octet" "from" "example.com" { discard; }`)
+
+	bad("keep")                                             // Missing semicolon.
+	bad("keep; }")                                          // Unexpected brace.
+	bad("if true { keep;")                                  // Missing brace.
+	bad(`"keep";`)                                          // Not a command.
+	bad("/* unterminated")                                  // Comment.
+	bad(`fileinto "x";`)                                    // Missing require.
+	bad(`require "bogus";`)                                 // Unknown extension.
+	bad(`keep; require "fileinto";`)                        // Require after command.
+	bad(`if true { require "fileinto"; }`)                  // Require in block.
+	bad(`else { keep; }`)                                   // Else without if.
+	bad(`keep; elsif true { keep; }`)                       // Elsif without if.
+	bad(`if { keep; }`)                                     // Missing test.
+	bad(`if true keep;`)                                    // Missing block.
+	bad(`keep { }`)                                         // Unexpected block.
+	bad(`bogus;`)                                           // Unknown command.
+	bad(`if bogus { }`)                                     // Unknown test.
+	bad(`if header :is :contains "a" "b" { }`)              // Conflicting match types.
+	bad(`if header :is :is "a" "b" { }`)                    // Duplicate tag.
+	bad(`if header "a" :is "b" { }`)                        // Tag after positional argument.
+	bad(`if header :comparator "x" "a" "b" { }`)            // Unknown comparator.
+	bad(`if header "a" { }`)                                // Missing argument.
+	bad(`if size 100 { }`)                                  // Missing :over/:under.
+	bad(`if envelope "from" "x" { }`)                       // Missing require.
+	bad(`require "envelope"; if envelope "cc" "x" { }`)     // Unknown envelope part.
+	bad(`require "variables"; set "1a" "x";`)               // Bad variable name.
+	bad(`require "include"; include "a/b";`)                // Bad script name.
+	bad(`redirect 1;`)                                      // Wrong type.
+	bad(`if allof { }`)                                     // Missing test list.
+	bad(`require "vacation"; vacation :days "x" "reason";`) // Wrong tag argument type.
+	bad(`require "vacation"; vacation text:
+no end`)
+}
+
+func TestEvaluate(t *testing.T) {
+	const msg = "From: Mjl <mjl@Example.org>\r\nTo: you@example.com, \"Other\" <other@example.net>\r\nSubject: =?utf-8?q?hello_w=C3=B6rld?=\r\nList-Id: <list.example.org>\r\nContent-Type: multipart/alternative; boundary=x\r\n\r\n--x\r\nContent-Type: text/plain\r\n\r\nplain text body\r\n--x\r\nContent-Type: text/html\r\n\r\n<b>html</b> body\r\n--x--\r\n"
+	part, err := message.Parse(slog.Default(), false, strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if err := part.Walk(slog.Default(), nil); err != nil {
+		t.Fatalf("walk message: %v", err)
+	}
+	header, err := part.Header()
+	if err != nil {
+		t.Fatalf("parse header: %v", err)
+	}
+	input := Input{
+		MailFrom: "bounce@example.org",
+		RcptTo:   "you@example.com",
+		Header:   header,
+		Part:     &part,
+		Size:     int64(len(msg)),
+	}
+
+	scripts := map[string]string{
+		"lib":   `require ["fileinto", "include", "variables"]; global "folder"; fileinto "${folder}"; return; discard;`,
+		"stops": `stop;`,
+	}
+	loader := func(name string, global bool) (*Script, error) {
+		s, ok := scripts[name]
+		if !ok || global {
+			return nil, ErrScriptNotFound
+		}
+		return Parse(s)
+	}
+
+	eval := func(script string, exp Result) {
+		t.Helper()
+		s, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+		r, err := Evaluate(s, input, loader)
+		if err != nil {
+			t.Fatalf("evaluate %q: %v", script, err)
+		}
+		tcompare(t, r, exp)
+	}
+	evalErr := func(script string) {
+		t.Helper()
+		s, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+		r, err := Evaluate(s, input, loader)
+		if err == nil {
+			t.Fatalf("evaluate %q: expected error", script)
+		}
+		tcompare(t, r, Result{Keep: true})
+	}
+
+	keep := Result{Keep: true}
+	eval(``, keep)
+	eval(`discard;`, Result{})
+	eval(`discard; keep;`, keep)
+	eval(`if header :is "subject" "Hello Wörld" { discard; }`, Result{})
+	eval(`if header :comparator "i;octet" :is "subject" "Hello Wörld" { discard; }`, keep)
+	eval(`if header :contains ["list-id", "x-other"] "list.example" { discard; }`, Result{})
+	eval(`if header :matches "subject" "h?llo *" { discard; }`, Result{})
+	eval(`if header :matches "subject" "h\\?llo *" { discard; }`, keep)
+	eval(`if exists ["from", "to"] { discard; }`, Result{})
+	eval(`if exists ["from", "cc"] { discard; }`, keep)
+	eval(`if address :domain "from" "example.org" { discard; }`, Result{})
+	eval(`if address :localpart "to" "other" { discard; }`, Result{})
+	eval(`if address :all "to" "x@example.net" { discard; }`, keep)
+	eval(`require "envelope"; if envelope :localpart "from" "bounce" { discard; }`, Result{})
+	eval(`require "envelope"; if envelope :domain "to" "example.org" { discard; }`, keep)
+	eval(`if size :over 10 { discard; }`, Result{})
+	eval(`if size :under 10 { discard; }`, keep)
+	eval(`if anyof (false, not true) { discard; }`, keep)
+	eval(`if allof (true, not false) { discard; }`, Result{})
+	eval(`if false { discard; } elsif false { discard; } else { stop; } discard;`, keep)
+	eval(`if true { } elsif true { discard; }`, keep)
+
+	eval(`require "body"; if body :contains "plain text" { discard; }`, Result{})
+	eval(`require "body"; if body :contains "<b>" { discard; }`, keep)
+	eval(`require "body"; if body :content "text/html" :contains "<b>" { discard; }`, Result{})
+	eval(`require "body"; if body :raw :contains "Content-Type: text/html" { discard; }`, Result{})
+
+	eval(`require "fileinto"; fileinto "Lists"; fileinto "Lists";`, Result{FileInto: []FileInto{{Mailbox: "Lists"}}})
+	eval(`require ["fileinto", "copy"]; fileinto :copy "Lists";`, Result{Keep: true, FileInto: []FileInto{{Mailbox: "Lists"}}})
+	eval(`redirect "Other@Example.com";`, Result{Redirects: []string{"Other@example.com"}})
+	eval(`require "copy"; redirect :copy "other@example.com";`, Result{Keep: true, Redirects: []string{"other@example.com"}})
+	evalErr(`redirect "not an address";`)
+	eval(`require "reject"; reject "no thanks";`, Result{Rejected: true, RejectReason: "no thanks"})
+	eval(`require "ereject"; ereject "no";`, Result{Rejected: true, RejectReason: "no"})
+	evalErr(`require ["reject", "fileinto"]; fileinto "x"; reject "no";`)
+
+	eval(`require "vacation"; vacation :days 0 :handle "h" "away";`, Result{Keep: true, Vacation: &Vacation{Reason: "away", Days: 1, Handle: "h"}})
+	evalErr(`require "vacation"; vacation "a"; vacation "b";`)
+
+	// Flags.
+	eval(`require "imap4flags"; setflag "\\Seen $a"; addflag ["$b", "$A"]; removeflag "$b";`, Result{Keep: true, KeepFlags: []string{`\Seen`, "$a"}})
+	eval(`require ["imap4flags", "fileinto"]; addflag "$x"; fileinto "a"; fileinto :flags "$y" "b";`, Result{FileInto: []FileInto{{"a", []string{"$x"}}, {"b", []string{"$y"}}}})
+	eval(`require "imap4flags"; addflag "$x"; if hasflag "$X" { keep :flags "$z"; discard; }`, Result{Keep: true, KeepFlags: []string{"$z"}})
+	eval(`require ["imap4flags", "variables"]; addflag "v" "$x"; if hasflag :is "v" "$x" { discard; }`, Result{})
+
+	// Variables.
+	eval(`require ["variables", "fileinto"]; if header :matches "subject" "* w*" { set :upperfirst "f" "${1}-${2}"; fileinto "${f}"; }`, Result{FileInto: []FileInto{{Mailbox: "Hello-örld"}}})
+	eval(`require ["variables", "fileinto"]; set :length "n" "wörld"; set :quotewildcard "q" "a*b"; fileinto "${n} ${q} ${undefined}${bad.ns}";`, Result{FileInto: []FileInto{{Mailbox: `5 a\*b ${bad.ns}`}}})
+	eval(`require "variables"; set "x" "abc"; if string :is "${X}" "abc" { discard; }`, Result{})
+
+	// Include.
+	eval(`require ["include", "variables"]; global "folder"; set "folder" "Lib"; include "lib";`, Result{FileInto: []FileInto{{Mailbox: "Lib"}}})
+	eval(`require "include"; include "stops"; discard;`, keep)
+	eval(`require "include"; include :optional "missing";`, keep)
+	evalErr(`require "include"; include "missing";`)
+	evalErr(`require "include"; include :global "lib";`)
+	scripts["self"] = `require "include"; include "self";`
+	evalErr(`require "include"; include "self";`)
+}
+
+func TestGlobMatch(t *testing.T) {
+	test := func(pattern, s string, fold bool, exp []string) {
+		t.Helper()
+		caps, ok := globMatch(pattern, s, fold)
+		tcompare(t, ok, exp != nil)
+		tcompare(t, caps, exp)
+	}
+	test("*", "", false, []string{"", ""})
+	test("a*c", "abbc", false, []string{"abbc", "bb"})
+	test("*@*", "a@b@c", false, []string{"a@b@c", "a", "b@c"})
+	test("?b*", "Abc", true, []string{"Abc", "A", "c"})
+	test("A*", "abc", false, nil)
+	test(`a\*`, "a*", false, []string{"a*"})
+	test(`a\*`, "ab", false, nil)
+}
@@ -145,6 +145,8 @@ type Client struct {
 	extSMTPUTF8           bool              // Remote server supports SMTPUTF8 extension.
 	extAuthMechanisms     []string          // Supported authentication mechanisms.
 	extRequireTLS         bool              // Remote supports REQUIRETLS extension.
+	extDSN                bool              // Remote supports DSN extension.
+	extChunking           bool              // Remote supports CHUNKING extension, with BDAT command.
 	ExtLimits             map[string]string // For LIMITS extension, only if present and valid, with uppercase keys.
 	ExtLimitMailMax       int               // Max "MAIL" commands in a connection, if > 0.
 	ExtLimitRcptMax       int               // Max "RCPT" commands in a transaction, if > 0.
@@ -762,6 +764,10 @@ func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Do
 				c.extPipelining = true
 			case "REQUIRETLS":
 				c.extRequireTLS = true
+			case "DSN":
+				c.extDSN = true
+			case "CHUNKING":
+				c.extChunking = true
 			default:
 				// For SMTPUTF8 we must ignore any parameter. ../rfc/6531:207
 				if s == "SMTPUTF8" || strings.HasPrefix(s, "SMTPUTF8 ") {
@@ -1041,6 +1047,16 @@ func (c *Client) auth(auth func(mechanisms []string, cs *tls.ConnectionState) (s
 			return nil
 		} else if code == smtp.C334ContinueAuth {
 			if last {
+				// With OAUTHBEARER and XOAUTH2, a server sends an error as challenge after the
+				// final client message. The client must send a dummy response, after which the
+				// server fails authentication. ../rfc/7628
+				if fromserver, err := base64.StdEncoding.DecodeString(lastText); err == nil && len(moreLines) == 0 {
+					if toserver, _, err := a.Next(fromserver); err != nil && toserver != nil {
+						c.xwriteline(base64.StdEncoding.EncodeToString(toserver))
+						xcode, xsecode, xfirstLine, xmoreLines := c.xread()
+						c.xerrorf(xcode/100 == 5, xcode, xsecode, xfirstLine, xmoreLines, "authentication failed: %w", err)
+					}
+				}
 				c.xerrorf(false, code, secode, firstLine, moreLines, "server requested unexpected continuation of authentication")
 			}
 			if len(moreLines) > 0 {
@@ -1093,6 +1109,19 @@ func (c *Client) SupportsRequireTLS() bool {
 	return c.extRequireTLS
 }
 
+// SupportsDSN returns whether the SMTP server supports the DSN extension. If so,
+// the server takes over the responsibility of sending DSNs as requested with the
+// DSN parameters for a delivery.
+func (c *Client) SupportsDSN() bool {
+	return c.extDSN
+}
+
+// SupportsChunking returns whether the SMTP server supports the CHUNKING
+// extension. If so, messages are sent with BDAT instead of DATA.
+func (c *Client) SupportsChunking() bool {
+	return c.extChunking
+}
+
 // TLSConnectionState returns TLS details if TLS is enabled, and nil otherwise.
 func (c *Client) TLSConnectionState() *tls.ConnectionState {
 	if tlsConn, ok := c.conn.(*tls.Conn); ok {
@@ -1119,7 +1148,8 @@ func (c *Client) TLSConnectionState() *tls.ConnectionState {
 // extension, or delivery will fail.
 //
 // Deliver uses the following SMTP extensions if the remote server supports them:
-// 8BITMIME, SMTPUTF8, SIZE, PIPELINING, ENHANCEDSTATUSCODES, STARTTLS.
+// 8BITMIME, SMTPUTF8, SIZE, PIPELINING, ENHANCEDSTATUSCODES, STARTTLS. The DSN
+// extension is only used through DeliverMultipleDSN.
 //
 // Returned errors can be of type Error, one of the Err-variables in this package
 // or other underlying errors, e.g. for i/o. Use errors.Is to check.
@@ -1144,11 +1174,44 @@ var errNoRecipients = errors.New("no recipients accepted in transaction")
 // delivery attempt as failed. Also code "552" must be treated like temporary error
 // code "452" for historic reasons.
 func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []string, msgSize int64, msg io.Reader, req8bitmime, reqSMTPUTF8, requireTLS bool) (rcptResps []Response, rerr error) {
+	return c.DeliverMultipleDSN(ctx, mailFrom, rcptTo, msgSize, msg, req8bitmime, reqSMTPUTF8, requireTLS, nil)
+}
+
+// DSNOpts are parameters for the DSN extension, ../rfc/3461, for a delivery with
+// DeliverMultipleDSN.
+type DSNOpts struct {
+	Ret   string // "FULL" or "HDRS", or empty to leave it to the remote server.
+	EnvID string // Envelope identifier, not xtext-encoded. Optional.
+
+	// Per recipient, in the same order as rcptTo. Optional.
+	Recipients []DSNRecipient
+}
+
+// DSNRecipient holds the DSN parameters for a single recipient.
+type DSNRecipient struct {
+	// Either "NEVER", or one or more of "SUCCESS", "FAILURE" and "DELAY". If empty,
+	// the default of the remote server applies.
+	Notify []string
+
+	// Original recipient, the address type, a semicolon, and the address, not
+	// xtext-encoded. E.g. "rfc822;mjl@mox.example". Optional.
+	ORcpt string
+}
+
+// DeliverMultipleDSN is like DeliverMultiple, but also sends the parameters for
+// the DSN extension if dsnOpts is not nil and the remote server supports the DSN
+// extension. If the remote server does not support DSN, the parameters are not
+// sent and the caller is responsible for sending any requested DSNs, see
+// SupportsDSN.
+func (c *Client) DeliverMultipleDSN(ctx context.Context, mailFrom string, rcptTo []string, msgSize int64, msg io.Reader, req8bitmime, reqSMTPUTF8, requireTLS bool, dsnOpts *DSNOpts) (rcptResps []Response, rerr error) {
 	defer c.recover(&rerr)
 
 	if len(rcptTo) == 0 {
 		return nil, fmt.Errorf("need at least one recipient")
 	}
+	if dsnOpts != nil && len(dsnOpts.Recipients) != 0 && len(dsnOpts.Recipients) != len(rcptTo) {
+		return nil, fmt.Errorf("dsn parameters for %d recipients, need %d", len(dsnOpts.Recipients), len(rcptTo))
+	}
 
 	if c.origConn == nil {
 		return nil, ErrClosed
@@ -1197,23 +1260,54 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 		// ../rfc/8689:155
 		requiretlsArg = " REQUIRETLS"
 	}
+	// Parameters for DSN extension, only if the remote server supports it. ../rfc/3461
+	var dsnArgs string
+	rcptArgs := make([]string, len(rcptTo))
+	if c.extDSN && dsnOpts != nil {
+		if dsnOpts.Ret != "" {
+			dsnArgs += " RET=" + dsnOpts.Ret
+		}
+		if dsnOpts.EnvID != "" {
+			dsnArgs += " ENVID=" + xtext(dsnOpts.EnvID)
+		}
+		for i, r := range dsnOpts.Recipients {
+			if len(r.Notify) > 0 {
+				rcptArgs[i] += " NOTIFY=" + strings.Join(r.Notify, ",")
+			}
+			if r.ORcpt != "" {
+				if t := strings.SplitN(r.ORcpt, ";", 2); len(t) == 2 {
+					rcptArgs[i] += " ORCPT=" + t[0] + ";" + xtext(t[1])
+				}
+			}
+		}
+	}
+
+	// With CHUNKING, the message is sent with BDAT commands after the recipients have
+	// been accepted, instead of with DATA. ../rfc/3030
+	chunking := c.extChunking
 
 	// Transaction overview: ../rfc/5321:1015
 	// MAIL FROM: ../rfc/5321:1879
 	// RCPT TO: ../rfc/5321:1916
 	// DATA: ../rfc/5321:1992
-	lineMailFrom := fmt.Sprintf("MAIL FROM:<%s>%s%s%s%s", mailFrom, mailSize, bodyType, smtputf8Arg, requiretlsArg)
+	lineMailFrom := fmt.Sprintf("MAIL FROM:<%s>%s%s%s%s%s", mailFrom, mailSize, bodyType, smtputf8Arg, requiretlsArg, dsnArgs)
 
 	// We are going into a transaction. We'll clear this when done.
 	c.needRset = true
 
 	if c.extPipelining {
-		c.cmds = make([]string, 1+len(rcptTo)+1)
+		ndata := 1
+		if chunking {
+			ndata = 0
+		}
+		c.cmds = make([]string, 1+len(rcptTo)+ndata)
 		c.cmds[0] = "mailfrom"
 		for i := range rcptTo {
 			c.cmds[1+i] = "rcptto"
 		}
-		c.cmds[len(c.cmds)-1] = "data"
+		if !chunking {
+			c.cmds[len(c.cmds)-1] = "data"
+		}
 		c.cmdStart = time.Now()
 
 		// Write and read in separte goroutines. Otherwise, writing a large recipient list
@@ -1230,12 +1324,16 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 			var b bytes.Buffer
 			b.WriteString(lineMailFrom)
 			b.WriteString("\r\n")
-			for _, rcpt := range rcptTo {
+			for i, rcpt := range rcptTo {
 				b.WriteString("RCPT TO:<")
 				b.WriteString(rcpt)
-				b.WriteString(">\r\n")
+				b.WriteString(">")
+				b.WriteString(rcptArgs[i])
+				b.WriteString("\r\n")
+			}
+			if !chunking {
+				b.WriteString("DATA\r\n")
 			}
-			b.WriteString("DATA\r\n")
 			_, err := c.w.Write(b.Bytes())
 			if err == nil {
 				err = c.w.Flush()
@@ -1266,7 +1364,13 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 		}
 
 		// Read response to DATA.
-		datacode, datasecode, datafirstLine, datamoreLines, dataerr := c.read()
+		var datacode int
+		var datasecode, datafirstLine string
+		var datamoreLines []string
+		var dataerr error
+		if !chunking {
+			datacode, datasecode, datafirstLine, datamoreLines, dataerr = c.read()
+		}
 
 		writeerr := <-errc
 		errc = nil
@@ -1324,7 +1428,7 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 			c.xerrorf(false, 0, "", "", nil, "%w", errNoRecipientsPipelined)
 		}
 
-		if datacode != smtp.C354Continue {
+		if !chunking && datacode != smtp.C354Continue {
 			c.xerrorf(datacode/100 == 5, datacode, datasecode, datafirstLine, datamoreLines, "%w: got %d, expected 354", ErrStatus, datacode)
 		}
 
@@ -1342,7 +1446,7 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 		for i, rcpt := range rcptTo {
 			c.cmds[0] = "rcptto"
 			c.cmdStart = time.Now()
-			c.xwriteline(fmt.Sprintf("RCPT TO:<%s>", rcpt))
+			c.xwriteline(fmt.Sprintf("RCPT TO:<%s>%s", rcpt, rcptArgs[i]))
 			code, secode, firstLine, moreLines = c.xread()
 			if i > 0 && (code == smtp.C452StorageFull || code == smtp.C552MailboxFull) {
 				// Remote doesn't accept more recipients for this transaction. Don't send more, give
@@ -1368,15 +1472,23 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 			c.xerrorf(false, 0, "", "", nil, "%w", errNoRecipients)
 		}
 
-		c.cmds[0] = "data"
-		c.cmdStart = time.Now()
-		c.xwriteline("DATA")
-		code, secode, firstLine, moreLines = c.xread()
-		if code != smtp.C354Continue {
-			c.xerrorf(code/100 == 5, code, secode, firstLine, moreLines, "%w: got %d, expected 354", ErrStatus, code)
+		if !chunking {
+			c.cmds[0] = "data"
+			c.cmdStart = time.Now()
+			c.xwriteline("DATA")
+			code, secode, firstLine, moreLines = c.xread()
+			if code != smtp.C354Continue {
+				c.xerrorf(code/100 == 5, code, secode, firstLine, moreLines, "%w: got %d, expected 354", ErrStatus, code)
+			}
 		}
 	}
 
+	if chunking {
+		c.xwriteChunks(msg)
+		c.needRset = false
+		return
+	}
+
 	// For a DATA write, the suggested timeout is 3 minutes, we use 30 seconds for all
 	// writes through timeoutWriter. ../rfc/5321:3651
 	defer c.xtrace(mlog.LevelTracedata)()
@@ -1395,6 +1507,60 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 	return
 }
 
+// Size of BDAT chunks. The last chunk is usually smaller.
+var bdatChunkSize = 1024 * 1024
+
+// xwriteChunks writes msg with BDAT commands, without dot-stuffing, and reads the
+// response for each chunk. We don't rely on the message size given by the caller:
+// the last chunk is marked as such when we reach the end of msg, which can be a
+// chunk of zero bytes. ../rfc/3030
+func (c *Client) xwriteChunks(msg io.Reader) {
+	buf := make([]byte, bdatChunkSize)
+	for {
+		n, err := io.ReadFull(msg, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			// Nothing written for this chunk yet, so the connection is still usable.
+			c.xerrorf(false, 0, "", "", nil, "reading message: %w", err)
+		}
+
+		c.cmds[0] = "bdat"
+		c.cmdStart = time.Now()
+		if last {
+			c.xbwritelinef("BDAT %d LAST", n)
+		} else {
+			c.xbwritelinef("BDAT %d", n)
+		}
+		func() {
+			defer c.xtrace(mlog.LevelTracedata)()
+			if _, err := c.w.Write(buf[:n]); err != nil {
+				c.xbotchf(0, "", "", nil, "writing message chunk: %w", err)
+			}
+		}()
+		code, secode, firstLine, moreLines := c.xread()
+		if code != smtp.C250Completed {
+			c.xerrorf(code/100 == 5, code, secode, firstLine, moreLines, "%w: got %d, expected 2xx", ErrStatus, code)
+		}
+		if last {
+			return
+		}
+	}
+}
+
+// xtext encodes s for use in a DSN parameter, with "+" and a hexadecimal
+// representation for characters that cannot be used as is.
+func xtext(s string) string {
+	var r string
+	for _, b := range []byte(s) {
+		if b >= 0x21 && b < 0x7f && b != '+' && b != '=' {
+			r += string(rune(b))
+		} else {
+			r += fmt.Sprintf("+%02X", b)
+		}
+	}
+	return r
+}
+
 // Reset sends an SMTP RSET command to reset the message transaction state. Deliver
 // automatically sends it if needed.
 func (c *Client) Reset() (rerr error) {
@@ -918,6 +918,186 @@ func run(t *testing.T, server func(s xserver), client func(conn net.Conn)) {
 	}
 }
 
+func TestDSN(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("smtpclient", nil)
+
+	dsnOpts := &DSNOpts{
+		Ret:   "HDRS",
+		EnvID: "id+1=2",
+		Recipients: []DSNRecipient{
+			{Notify: []string{"SUCCESS", "FAILURE"}, ORcpt: "rfc822;Mjl+x@mox.example"},
+			{Notify: []string{"NEVER"}},
+		},
+	}
+	rcptTo := []string{"mjl@mox.example", "other@mox.example"}
+
+	// Parameters are sent if server supports DSN.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 DSN")
+		s.readline("MAIL FROM:<postmaster@other.example> RET=HDRS ENVID=id+2B1+3D2\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<mjl@mox.example> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;Mjl+2Bx@mox.example\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<other@mox.example> NOTIFY=NEVER\r\n")
+		s.writeline("250 ok")
+		s.readline("DATA")
+		s.writeline("354 continue")
+		s.readline(".")
+		s.writeline("250 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+		if err != nil {
+			panic(err)
+		}
+		if !c.SupportsDSN() {
+			panic("dsn not supported")
+		}
+		msg := ""
+		_, err = c.DeliverMultipleDSN(ctx, "postmaster@other.example", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false, dsnOpts)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	// Parameters are not sent if server does not support DSN.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250 mox.example")
+		s.readline("MAIL FROM:<postmaster@other.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<mjl@mox.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<other@mox.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("DATA")
+		s.writeline("354 continue")
+		s.readline(".")
+		s.writeline("250 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+		if err != nil {
+			panic(err)
+		}
+		if c.SupportsDSN() {
+			panic("dsn supported")
+		}
+		msg := ""
+		_, err = c.DeliverMultipleDSN(ctx, "postmaster@other.example", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false, dsnOpts)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+func TestChunking(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("smtpclient", nil)
+
+	defer func(size int) {
+		bdatChunkSize = size
+	}(bdatChunkSize)
+	bdatChunkSize = 8
+
+	readdata := func(s xserver, exp string) {
+		buf := make([]byte, len(exp))
+		_, err := io.ReadFull(s.br, buf)
+		s.check(err, "reading chunk")
+		if string(buf) != exp {
+			s.errorf("got chunk %q, expected %q", buf, exp)
+		}
+	}
+
+	deliver := func(msg string) func(conn net.Conn) {
+		return func(conn net.Conn) {
+			c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+			if err != nil {
+				panic(err)
+			}
+			if !c.SupportsChunking() {
+				panic("chunking not supported")
+			}
+			err = c.Deliver(ctx, "postmaster@other.example", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Message is sent in chunks without dot-stuffing, the last chunk is marked.
+	msg := "Subject: test\r\n\r\n.\r\n"
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 CHUNKING")
+		s.readline("MAIL FROM:")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:")
+		s.writeline("250 ok")
+		s.readline("BDAT 8\r\n")
+		readdata(s, msg[:8])
+		s.writeline("250 ok")
+		s.readline("BDAT 8\r\n")
+		readdata(s, msg[8:16])
+		s.writeline("250 ok")
+		s.readline("BDAT 4 LAST\r\n")
+		readdata(s, msg[16:])
+		s.writeline("250 ok")
+	}, deliver(msg))
+
+	// Message of exactly the chunk size, with pipelining, ends with an empty last chunk.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250-PIPELINING")
+		s.writeline("250 CHUNKING")
+		s.readline("MAIL FROM:")
+		s.readline("RCPT TO:")
+		s.writeline("250 ok")
+		s.writeline("250 ok")
+		s.readline("BDAT 8\r\n")
+		readdata(s, msg[:8])
+		s.writeline("250 ok")
+		s.readline("BDAT 0 LAST\r\n")
+		s.writeline("250 ok")
+	}, deliver(msg[:8]))
+
+	// No data is sent without accepted recipients.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250-PIPELINING")
+		s.writeline("250 CHUNKING")
+		s.readline("MAIL FROM:")
+		s.readline("RCPT TO:")
+		s.writeline("250 ok")
+		s.writeline("550 no")
+		s.readline("QUIT")
+		s.writeline("221 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+		if err != nil {
+			panic(err)
+		}
+		err = c.Deliver(ctx, "postmaster@other.example", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		var cerr Error
+		if err == nil || !errors.As(err, &cerr) || cerr.Code != 550 {
+			panic(fmt.Errorf("got err %v, expected 550 error", err))
+		}
+		err = c.Close()
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
 func TestLimits(t *testing.T) {
 	check := func(s string, expLimits map[string]string, expMailMax, expRcptMax, expRcptDomainMax int) {
 		t.Helper()
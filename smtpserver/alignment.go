@@ -2,9 +2,12 @@ package smtpserver
 
 import (
 	"context"
+	"strings"
 
 	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/spf"
@@ -41,3 +44,44 @@ func alignment(ctx context.Context, log mlog.Log, msgFromDomain dns.Domain, dkim
 	}
 	return store.ValidationNone
 }
+
+// arcAuthAligned returns whether the ARC-Authentication-Results of an intermediary
+// (without instance) record a DMARC pass for msgFromDomain, or a DKIM or SPF pass
+// aligned with msgFromDomain. Alignment is relaxed unless the DMARC record of
+// msgFromDomain requires strict alignment.
+func arcAuthAligned(ctx context.Context, log mlog.Log, authResults string, msgFromDomain dns.Domain, record *dmarc.Record) bool {
+	ar, err := message.ParseAuthResults(authResults + "\n")
+	if err != nil {
+		log.Debugx("parsing arc-authentication-results", err)
+		return false
+	}
+
+	// Value can be a domain or an address.
+	aligned := func(s string, strict bool) bool {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			s = s[i+1:]
+		}
+		d, err := dns.ParseDomain(s)
+		if err != nil {
+			return false
+		}
+		return d == msgFromDomain || !strict && publicsuffix.Lookup(ctx, log.Logger, d) == publicsuffix.Lookup(ctx, log.Logger, msgFromDomain)
+	}
+	strictDKIM := record != nil && record.ADKIM == dmarc.AlignStrict
+	strictSPF := record != nil && record.ASPF == dmarc.AlignStrict
+
+	for _, m := range ar.Methods {
+		if m.Result != "pass" {
+			continue
+		}
+		for _, p := range m.Props {
+			switch {
+			case m.Method == "dmarc" && p.Type == "header" && p.Property == "from" && aligned(p.Value, true),
+				m.Method == "dkim" && p.Type == "header" && p.Property == "d" && aligned(p.Value, strictDKIM),
+				m.Method == "spf" && p.Type == "smtp" && p.Property == "mailfrom" && aligned(p.Value, strictSPF):
+				return true
+			}
+		}
+	}
+	return false
+}
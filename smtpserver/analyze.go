@@ -18,10 +18,12 @@ import (
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dnsbl"
 	"github.com/mjl-/mox/iprev"
+	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/reservoir"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/subjectpass"
@@ -44,8 +46,10 @@ type delivery struct {
 	dmarcUse         bool
 	dmarcResult      dmarc.Result
 	dkimResults      []dkim.Result
+	arcResult        dkim.ARCResult
 	iprevStatus      iprev.Status
 	smtputf8         bool
+	lmtp             bool // Delivered over LMTP by a trusted upstream.
 }
 
 type analysis struct {
@@ -65,6 +69,23 @@ type analysis struct {
 	// Additional headers to add during delivery. Used for reasons a message to a
 	// dmarc/tls reporting address isn't processed.
 	headers string
+	// If the content was classified with a reservoir filter, its result, with the
+	// combined probability used for the decision.
+	reservoir *reservoir.ClassifyResult
+}
+
+// logAttrs returns attributes for logging the outcome of a delivery attempt.
+func (a analysis) logAttrs(msgFrom smtp.Address) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("reason", a.reason),
+		slog.Any("msgfrom", msgFrom),
+	}
+	if a.reservoir != nil {
+		attrs = append(attrs,
+			slog.Float64("bayesianprob", a.reservoir.BayesianProb),
+			slog.Float64("combinedprob", a.reservoir.CombinedProb))
+	}
+	return attrs
 }
 
 const (
@@ -85,6 +106,7 @@ const (
 	reasonIPrev             = "iprev"     // No or mild junk reputation signals, and bad iprev.
 	reasonHighRate          = "high-rate" // Too many messages, not added to rejects.
 	reasonMsgAuthRequired   = "msg-auth-required"
+	reasonLMTP              = "lmtp" // Delivered over LMTP, upstream is responsible for junk filtering.
 )
 
 func isListDomain(d delivery, ld dns.Domain) bool {
@@ -160,6 +182,12 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			}
 		}
 
+		// Messages delivered over LMTP without the IP of the original sender have no
+		// remote IP to limit on.
+		if d.lmtp && d.m.RemoteIPMasked1 == "" {
+			return nil
+		}
+
 		// todo future: make these configurable
 		// todo: should we have a limit for forwarded messages? they are stored with empty RemoteIPMasked*
 
@@ -185,12 +213,12 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		log.Errorx("checking delivery rates", err)
 		metricDelivery.WithLabelValues("checkrates", "").Inc()
 		addReasonText("checking delivery rates: %v", err)
-		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, "", headers}
+		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, "", headers, nil}
 	} else if err != nil {
 		log.Debugx("refusing due to high delivery rate", err)
 		metricDelivery.WithLabelValues("highrate", "").Inc()
 		addReasonText("high delivery rate")
-		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, reasonHighRate, reasonText, "", headers}
+		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, reasonHighRate, reasonText, "", headers, nil}
 	}
 
 	mailbox := d.destination.Mailbox
@@ -222,6 +250,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	}
 
 	var dmarcOverrideReason string
+	var reservoirResult *reservoir.ClassifyResult // Set when the reservoir filter was used for content analysis.
 
 	// For forwarded messages, we have different junk analysis. We don't reject for
 	// failing DMARC, and we clear fields that could implicate the forwarding mail
@@ -249,6 +278,30 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		addReasonText("ruleset indicates forwarded message")
 	}
 
+	// A trusted intermediary that sealed a valid ARC chain vouches for the
+	// authentication results before it forwarded the message. If it recorded a DMARC
+	// pass, or an aligned DKIM or SPF pass, for the message From domain, we don't
+	// apply the DMARC policy of the sender. ../rfc/8617
+	if d.dmarcUse && d.dmarcResult.Status != dmarc.StatusPass && d.arcResult.Status == dkim.ARCStatusPass {
+		sealer := d.arcResult.Sealer()
+		for _, dom := range mox.Conf.Static.TrustedARCSealerDomains {
+			if dom.ASCII != sealer.ASCII {
+				continue
+			}
+			authResults := d.arcResult.Sets[len(d.arcResult.Sets)-1].AuthResults
+			if !arcAuthAligned(ctx, log, authResults, d.msgFrom.Domain, d.dmarcResult.Record) {
+				log.Info("message failing dmarc has valid arc chain sealed by trusted sealer, but sealer did not record aligned pass", slog.Any("sealer", sealer), slog.String("authresults", authResults))
+				addReasonText("valid arc chain sealed by trusted sealer %s without aligned pass", sealer)
+			} else {
+				d.dmarcUse = false
+				dmarcOverrideReason = string(dmarcrpt.PolicyOverrideTrustedForwarder)
+				log.Info("message failing dmarc has valid arc chain sealed by trusted sealer, not using dmarc result", slog.Any("sealer", sealer))
+				addReasonText("valid arc chain sealed by trusted sealer %s with aligned pass", sealer)
+			}
+			break
+		}
+	}
+
 	assignMailbox := func(tx *bstore.Tx) error {
 		// Set message MailboxID to which mail will be delivered. Reputation is
 		// per-mailbox. If referenced mailbox is not found (e.g. does not yet exist), we
@@ -288,7 +341,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			})
 			if mberr != nil {
 				addReasonText("error setting original destination mailbox for rejected message: %v", mberr)
-				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, dmarcOverrideReason, headers}
+				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, dmarcOverrideReason, headers, reservoirResult}
 			}
 			d.m.MailboxID = 0 // We plan to reject, no need to set intended MailboxID.
 		}
@@ -303,7 +356,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			log.Info("accepting reject to configured mailbox due to ruleset")
 			addReasonText("accepting reject to mailbox due to ruleset")
 		}
-		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, reason, reasonText, dmarcOverrideReason, headers}
+		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, reason, reasonText, dmarcOverrideReason, headers, reservoirResult}
 	}
 
 	if d.dmarcUse && d.dmarcResult.Reject {
@@ -410,6 +463,23 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		return reject(code, smtp.SePol7MultiAuthFails26, msg, nil, reasonMsgAuthRequired)
 	}
 
+	// Messages delivered over LMTP have been accepted by a trusted upstream, which is
+	// responsible for junk filtering. We don't evaluate reputation.
+	if d.lmtp {
+		addReasonText("delivered over lmtp by trusted upstream")
+		return analysis{
+			d:                   d,
+			accept:              true,
+			mailbox:             mailbox,
+			dmarcReport:         dmarcReport,
+			tlsReport:           tlsReport,
+			reason:              reasonLMTP,
+			reasonText:          reasonText,
+			dmarcOverrideReason: dmarcOverrideReason,
+			headers:             headers,
+		}
+	}
+
 	// Determine if message is acceptable based on DMARC domain, DKIM identities, or
 	// host-based reputation.
 	var isjunk *bool
@@ -548,6 +618,43 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			addReasonText("classify message error: %v", err)
 			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkClassifyError)
 		}
+		// With a reservoir filter, the Bayesian probability is combined with the
+		// probability from the reservoir and affective analysis. On errors, we continue
+		// with just the Bayesian probability, the reservoir filter is only an additional
+		// signal. In shadow mode, the combined probability is only recorded, not used
+		// for the decision.
+		// The message is prepared before getting the filter, which is shared by all
+		// deliveries to the account.
+		prob := result.Probability
+		var shadow bool
+		if rm, err := reservoirPrepare(log, f, d, isjunk); err != nil {
+			log.Errorx("preparing message for reservoir filter", err)
+			addReasonText("reservoir prepare error: %v", err)
+		} else if rm != nil {
+			err = d.acc.WithReservoirFilter(ctx, log, func(rf *reservoir.ReservoirFilter, rfc *config.ReservoirFilter) error {
+				shadow = rfc.Shadow
+				var err error
+				reservoirResult, err = rf.ClassifyPrepared(ctx, rm, result.Probability)
+				if err != nil {
+					log.Errorx("classifying message with reservoir filter", err)
+					addReasonText("reservoir classify error: %v", err)
+					return nil
+				}
+				var mode string
+				if shadow {
+					mode = " (shadow)"
+				} else {
+					prob = reservoirResult.CombinedProb
+				}
+				addReasonText("reservoir%s: bayesian %.2f, reservoir %.2f, affective %.2f, combined %.2f", mode, reservoirResult.BayesianProb, reservoirResult.ReservoirProb, reservoirResult.AffectiveProb, reservoirResult.CombinedProb)
+				return nil
+			})
+			if err != nil && err != store.ErrNoReservoirFilter {
+				log.Errorx("open reservoir filter", err)
+				addReasonText("open reservoir filter: %v", err)
+			}
+		}
+
 		// todo: if isjunk is not nil (i.e. there was inconclusive reputation), use it in the probability calculation. give reputation a score of 0.25 or .75 perhaps?
 		// todo: if there aren't enough historic messages, we should just let messages in.
 		// todo: we could require nham and nspam to be above a certain number when there were plenty of words in the message, and in the database. can indicate a spammer is misspelling words. however, it can also mean a message in a different language/script...
@@ -601,13 +708,37 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			reason = reasonJunkContentStrict
 			thresholdRemark = " (stricter due to recipient address not in to/cc header)"
 		}
-		accept = result.Probability <= threshold || (!result.Significant && !suspiciousIPrevFail)
-		junkSubjectpass = result.Probability < threshold-0.2
-		log.Info("content analyzed",
+		accept = prob <= threshold || (!result.Significant && !suspiciousIPrevFail)
+		if reservoirResult != nil {
+			// Counted in shadow mode too, as decisions the reservoir would have flipped.
+			combined := reservoirResult.CombinedProb
+			if result.Probability <= threshold && combined > threshold {
+				metricReservoirFlip.WithLabelValues("junk").Inc()
+			} else if result.Probability > threshold && combined <= threshold {
+				metricReservoirFlip.WithLabelValues("nonjunk").Inc()
+			}
+		}
+		junkSubjectpass = prob < threshold-0.2
+		attrs := []slog.Attr{
 			slog.Bool("accept", accept),
 			slog.Float64("contentprob", result.Probability),
 			slog.Bool("contentsignificant", result.Significant),
-			slog.Bool("subjectpass", junkSubjectpass))
+			slog.Bool("subjectpass", junkSubjectpass),
+		}
+		if reservoirResult != nil {
+			attrs = append(attrs,
+				slog.Float64("reservoirprob", reservoirResult.ReservoirProb),
+				slog.Float64("affectiveprob", reservoirResult.AffectiveProb),
+				slog.Float64("combinedprob", reservoirResult.CombinedProb),
+				slog.Bool("reservoirshadow", shadow))
+		}
+		log.Info("content analyzed", attrs...)
+		d.m.Classification = store.NewClassification(result, reservoirResult, prob, threshold, !accept)
+		if rc := d.m.Classification.Reservoir; rc != nil {
+			rc.Junk = !(rc.Combined <= threshold || (!result.Significant && !suspiciousIPrevFail))
+			rc.Shadow = shadow
+			rc.Reputation = isjunk
+		}
 
 		s := "content: "
 		if accept {
@@ -618,7 +749,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		if !result.Significant {
 			s += " (not significant)"
 		}
-		s += fmt.Sprintf(", spamscore %.2f, threshold %.2f%s", result.Probability, threshold, thresholdRemark)
+		s += fmt.Sprintf(", spamscore %.2f, threshold %.2f%s", prob, threshold, thresholdRemark)
 		s += " (ham words: "
 		for i, w := range result.Hams {
 			if i > 0 {
@@ -700,6 +831,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			reasonText:          reasonText,
 			dmarcOverrideReason: dmarcOverrideReason,
 			headers:             headers,
+			reservoir:           reservoirResult,
 		}
 	}
 
@@ -713,6 +845,30 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", nil, reason)
 }
 
+// reservoirPrepare parses the incoming message and prepares it for
+// classification by the reservoir filter of the account. Returns nil if the
+// account has no reservoir filter.
+func reservoirPrepare(log mlog.Log, jf *junk.Filter, d delivery, isjunk *bool) (*reservoir.Prepared, error) {
+	conf, _ := d.acc.Conf()
+	if conf.ReservoirFilter == nil {
+		return nil, nil
+	}
+	params := conf.ReservoirFilter.Params.WithDefaults()
+
+	p, err := message.Parse(log.Logger, false, store.FileMsgReader(d.m.MsgPrefix, d.dataFile))
+	if err != nil {
+		return nil, fmt.Errorf("parsing message: %w", err)
+	}
+	words, err := jf.ParseMessage(p)
+	if err != nil {
+		return nil, fmt.Errorf("parsing words: %w", err)
+	}
+	m := store.ReservoirMessage(*d.m, &p, words)
+	m.Auth.Reputation = isjunk
+	m.Sender = store.ReservoirSender(params, *d.m)
+	return reservoir.PrepareMessage(params, m)
+}
+
 func isASCII(s string) bool {
 	for _, b := range []byte(s) {
 		if b >= 0x80 {
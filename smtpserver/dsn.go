@@ -3,6 +3,7 @@ package smtpserver
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/mjl-/mox/dsn"
@@ -48,7 +49,9 @@ func queueDSN(ctx context.Context, log mlog.Log, c *conn, rcptTo smtp.Path, m ds
 	// Queue DSN with null reverse path so failures to deliver will eventually drop the
 	// message instead of causing delivery loops.
 	// ../rfc/3464:433
-	const has8bit = false
+	// The ascii-only DSN can only have 8-bit data when it includes a full original
+	// message with 8-bit data.
+	has8bit := slices.ContainsFunc(buf, func(b byte) bool { return b >= 0x80 })
 	const smtputf8 = false
 	var reqTLS *bool
 	if requireTLS {
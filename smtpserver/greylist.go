@@ -0,0 +1,118 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/greylist"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// greylisted returns whether the last recipient of the transaction, for address
+// rcptTo, should be deferred because the triplet of masked remote IP, MAIL FROM
+// and RCPT TO hasn't passed greylisting yet. Called for each RCPT TO of incoming
+// deliveries, so a deferred recipient is retried by the sender, instead of the
+// sender getting a DSN after a partial delivery. Remote IPs in AllowedNetworks,
+// remote networks from which the recipient account received non-junk messages, and
+// known senders are not greylisted. A known sender has an SPF pass for the MAIL
+// FROM domain, and the account received non-junk messages from the domain that
+// were DMARC-aligned. Errors are logged and don't cause a deferral.
+func (c *conn) greylisted(rcptTo smtp.Path) bool {
+	conf := mox.Conf.Static.Greylist
+	if conf == nil || greylist.DB == nil || c.submission || c.lmtp {
+		return false
+	}
+	for _, ipnet := range conf.AllowedNets {
+		if ipnet.Contains(c.remoteIP) {
+			return false
+		}
+	}
+
+	_, ipMasked, _ := ipmasked(c.remoteIP)
+	ctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+
+	if rcpt := c.recipients[len(c.recipients)-1]; rcpt.Account != nil {
+		if knownNetwork(ctx, c.log, rcpt.Account.AccountName, ipMasked, conf.Expiration) {
+			return false
+		}
+		if !c.mailFrom.IsZero() && c.mailFromSPFPassed() && knownSender(ctx, c.log, rcpt.Account.AccountName, c.mailFrom.IPDomain.Domain, conf.Expiration) {
+			return false
+		}
+	}
+
+	pass, err := greylist.Check(ctx, c.log, ipMasked, c.mailFrom.String(), rcptTo.String(), conf.Delay, conf.RetryWindow, conf.Expiration)
+	if err != nil {
+		c.log.Errorx("greylist check, not deferring recipient", err, slog.Any("rcptto", rcptTo), slog.String("ipmasked", ipMasked))
+		return false
+	}
+	if !pass {
+		c.log.Info("deferring recipient due to greylisting", slog.Any("rcptto", rcptTo), slog.String("ipmasked", ipMasked))
+		metricDelivery.WithLabelValues("reject", "greylisted").Inc()
+	}
+	return !pass
+}
+
+// knownNetwork returns whether the account received a non-junk message from the
+// masked remote IP in the past period.
+func knownNetwork(ctx context.Context, log mlog.Log, accountName, ipMasked string, period time.Duration) bool {
+	acc, err := store.OpenAccount(log, accountName, false)
+	if err != nil {
+		log.Errorx("open account for greylist reputation", err, slog.String("account", accountName))
+		return false
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "close account")
+	}()
+
+	q := bstore.QueryDB[store.Message](ctx, acc.DB)
+	q.FilterNonzero(store.Message{RemoteIPMasked2: ipMasked})
+	q.FilterEqual("Expunged", false)
+	q.FilterEqual("Notjunk", true)
+	q.FilterEqual("IsReject", false)
+	q.FilterGreaterEqual("Received", time.Now().Add(-period))
+	exists, err := q.Exists()
+	if err != nil {
+		log.Errorx("looking up messages from remote network for greylisting", err)
+		return false
+	}
+	return exists
+}
+
+// knownSender returns whether the account received a non-junk message from
+// mailFromDomain in the past period for which SPF passed and that was
+// DMARC-aligned.
+func knownSender(ctx context.Context, log mlog.Log, accountName string, mailFromDomain dns.Domain, period time.Duration) bool {
+	acc, err := store.OpenAccount(log, accountName, false)
+	if err != nil {
+		log.Errorx("open account for greylist reputation", err, slog.String("account", accountName))
+		return false
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "close account")
+	}()
+
+	q := bstore.QueryDB[store.Message](ctx, acc.DB)
+	q.FilterNonzero(store.Message{MailFromDomain: mailFromDomain.Name(), MsgFromOrgDomain: publicsuffix.Lookup(ctx, log.Logger, mailFromDomain).Name()})
+	q.FilterEqual("Expunged", false)
+	q.FilterEqual("Notjunk", true)
+	q.FilterEqual("IsReject", false)
+	q.FilterEqual("MailFromValidated", true)
+	q.FilterEqual("MsgFromValidated", true)
+	q.FilterGreaterEqual("Received", time.Now().Add(-period))
+	exists, err := q.Exists()
+	if err != nil {
+		log.Errorx("looking up messages from sender domain for greylisting", err)
+		return false
+	}
+	return exists
+}
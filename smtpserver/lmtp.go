@@ -0,0 +1,186 @@
+package smtpserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/iprev"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/spf"
+)
+
+// LMTP, ../rfc/2033, is SMTP for delivery to local mailboxes by a trusted MTA or
+// content filter. It is implemented as a mode of the SMTP connection, with a
+// response per recipient after DATA.
+
+// listenLMTP initializes a listener for LMTP, on socketPath if set, and otherwise
+// on TCP for ip and port, only allowing connections from allowedNets.
+func listenLMTP(name string, socketPath, ip string, port int, hostname dns.Domain, maxMessageSize int64, allowedNets []*net.IPNet, trustedAuthServID string) {
+	log := mlog.New("smtpserver", nil)
+
+	var ln net.Listener
+	var addr string
+	if socketPath != "" {
+		// The privileged process doesn't pass unix domain sockets, we create the socket
+		// in the unprivileged process, like the ctl socket.
+		if os.Getuid() == 0 && !mox.FilesImmediate {
+			return
+		}
+		addr = socketPath
+		_ = os.Remove(socketPath)
+		var err error
+		ln, err = net.Listen("unix", socketPath)
+		if err != nil {
+			log.Fatalx("lmtp: listen on unix domain socket", err, slog.String("listener", name), slog.String("path", socketPath))
+		}
+		err = os.Chmod(socketPath, 0660)
+		log.Check(err, "lmtp: setting permissions on unix domain socket", slog.String("path", socketPath))
+	} else {
+		addr = net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+		if os.Getuid() == 0 {
+			log.Print("listening for lmtp", slog.String("listener", name), slog.String("address", addr))
+		}
+		var err error
+		ln, err = mox.Listen(mox.Network(ip), addr)
+		if err != nil {
+			log.Fatalx("lmtp: listen for lmtp", err, slog.String("listener", name))
+		}
+	}
+
+	acceptLoop := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox("lmtp: accept", err, slog.String("listener", name))
+				continue
+			}
+
+			if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok && !lmtpAllowed(allowedNets, a.IP) {
+				log.Info("lmtp: refusing connection from ip not allowed", slog.Any("remoteip", a.IP), slog.String("listener", name), slog.String("address", addr))
+				err := conn.Close()
+				log.Check(err, "lmtp: closing connection")
+				continue
+			}
+
+			resolver := dns.StrictResolver{Log: log.Logger}
+			go serve(name, mox.Cid(), hostname, nil, conn, resolver, false, false, false, true, maxMessageSize, false, false, false, nil, 0, true, trustedAuthServID)
+		}
+	}
+
+	servers = append(servers, acceptLoop)
+}
+
+func lmtpAllowed(allowedNets []*net.IPNet, ip net.IP) bool {
+	for _, n := range allowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamAuth holds the authentication results from the upstream of an LMTP
+// connection that we cannot evaluate ourselves, because we don't see the
+// connection from the original sender.
+type upstreamAuth struct {
+	methods     []message.AuthMethod // Methods iprev and spf, for our Authentication-Results header.
+	remoteIP    net.IP               // From the iprev result, nil if absent.
+	iprevStatus iprev.Status
+	spf         spf.Received // Only Result and Identity are set.
+	spfDomain   dns.Domain   // Domain of SPF identity, zero if absent.
+}
+
+// parseUpstreamAuth returns the iprev and spf results from the topmost
+// Authentication-Results header with authServID in h. Returns nil if no such
+// header is present.
+func parseUpstreamAuth(log mlog.Log, h textproto.MIMEHeader, authServID string) *upstreamAuth {
+	for _, v := range h.Values("Authentication-Results") {
+		ar, err := message.ParseAuthResults(v + "\n")
+		if err != nil {
+			log.Debugx("parsing authentication-results header from upstream, skipping", err)
+			continue
+		} else if !strings.EqualFold(ar.Hostname, authServID) {
+			continue
+		}
+
+		ua := &upstreamAuth{}
+		for _, m := range ar.Methods {
+			switch m.Method {
+			case "iprev":
+				status := iprev.Status(m.Result)
+				switch status {
+				case iprev.StatusPass, iprev.StatusFail, iprev.StatusTemperror, iprev.StatusPermerror:
+				default:
+					continue
+				}
+				if ua.iprevStatus != "" {
+					continue
+				}
+				ua.iprevStatus = status
+				for _, p := range m.Props {
+					if p.Type == "policy" && p.Property == "iprev" {
+						ua.remoteIP = net.ParseIP(p.Value)
+					}
+				}
+				ua.methods = append(ua.methods, m)
+
+			case "spf":
+				status := spf.Status(m.Result)
+				switch status {
+				case spf.StatusNone, spf.StatusNeutral, spf.StatusPass, spf.StatusFail, spf.StatusSoftfail, spf.StatusTemperror, spf.StatusPermerror:
+				default:
+					continue
+				}
+				if ua.spf.Result != "" {
+					continue
+				}
+				ua.spf.Result = status
+				for _, p := range m.Props {
+					if p.Type != "smtp" || p.Property != string(spf.ReceivedMailFrom) && p.Property != string(spf.ReceivedHELO) {
+						continue
+					}
+					// Value can be a domain or an address.
+					s := p.Value
+					if i := strings.LastIndex(s, "@"); i >= 0 {
+						s = s[i+1:]
+					}
+					d, err := dns.ParseDomain(s)
+					if err != nil {
+						log.Debugx("parsing spf domain from upstream authentication-results, ignoring", err, slog.String("value", p.Value))
+						continue
+					}
+					ua.spf.Identity = spf.Identity(p.Property)
+					ua.spfDomain = d
+				}
+				ua.methods = append(ua.methods, m)
+			}
+		}
+		return ua
+	}
+	return nil
+}
+
+// lmtpDataError must be called deferred while handling DATA for LMTP. For an SMTP
+// error, it writes the error response for all but the last recipient, and passes
+// on the panic, causing the final response to be written. ../rfc/2033
+func (c *conn) lmtpDataError(nrcpt int) {
+	x := recover()
+	if x == nil {
+		return
+	}
+	var serr smtpError
+	if err, ok := x.(error); ok && !isClosed(err) && errors.As(err, &serr) {
+		for range nrcpt - 1 {
+			c.xbwritecodeline(serr.code, serr.secode, fmt.Sprintf("%s (%s)", serr.errmsg, mox.ReceivedID(c.cid)), serr.err)
+		}
+	}
+	panic(x)
+}
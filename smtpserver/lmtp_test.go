@@ -0,0 +1,155 @@
+package smtpserver
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/store"
+)
+
+func TestLMTP(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+
+	ts.lmtp = true
+	ts.serverConfig = nil
+
+	// Run an LMTP session, calling fn with functions to write a line and read a
+	// response, returning the last line of a (multiline) response.
+	session := func(fn func(writeline func(s string), readresp func(prefix string) string)) {
+		t.Helper()
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+			defer conn.Close()
+
+			br := bufio.NewReader(conn)
+			writeline := func(s string) {
+				t.Helper()
+				_, err := conn.Write([]byte(s + "\r\n"))
+				tcheck(t, err, "write")
+			}
+			readresp := func(prefix string) string {
+				t.Helper()
+				for {
+					line, err := br.ReadString('\n')
+					tcheck(t, err, "read")
+					line = strings.TrimRight(line, "\r\n")
+					if !strings.HasPrefix(line, prefix) {
+						t.Fatalf("got response %q, expected prefix %q", line, prefix)
+					}
+					if len(line) < 4 || line[3] != '-' {
+						return line
+					}
+				}
+			}
+
+			line := readresp("220 ")
+			if !strings.Contains(line, "LMTP") {
+				t.Fatalf("greeting %q does not mention lmtp", line)
+			}
+			fn(writeline, readresp)
+		})
+	}
+
+	lastMessage := func() store.Message {
+		t.Helper()
+		q := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB)
+		q.SortDesc("ID")
+		q.Limit(1)
+		m, err := q.Get()
+		tcheck(t, err, "get last message")
+		return m
+	}
+
+	// Delivery to multiple recipients, including another account and an unknown
+	// address that is refused before DATA. Each accepted recipient gets a response.
+	session(func(writeline func(s string), readresp func(prefix string) string) {
+		writeline("EHLO upstream.example")
+		readresp("500 ")
+		writeline("LHLO upstream.example")
+		readresp("250")
+
+		writeline("MAIL FROM:<remote@example.org> SMTPUTF8")
+		readresp("250 ")
+		writeline("RCPT TO:<mjl@mox.example>")
+		readresp("250 ")
+		writeline("RCPT TO:<unknown@mox.example>")
+		readresp("550 ")
+		writeline("RCPT TO:<☺@mox.example>")
+		readresp("250 ")
+		writeline("DATA")
+		readresp("354 ")
+		for _, line := range strings.Split(strings.TrimSuffix(deliverMessage, "\r\n"), "\r\n") {
+			writeline(line)
+		}
+		writeline(".")
+		readresp("250 ")
+		readresp("250 ")
+		writeline("QUIT")
+		readresp("221 ")
+	})
+	ts.checkCount("Inbox", 1)
+	m := lastMessage()
+	tcompare(t, m.RemoteIPMasked1, "")
+	tcompare(t, m.MailFromValidated, false)
+
+	// Upstream authentication results are only used when the authserv-id is trusted.
+	const upstreamMsg = "Authentication-Results: upstream.example; iprev=pass policy.iprev=10.1.2.3;\r\n\tspf=pass smtp.mailfrom=example.org\r\n" + "Authentication-Results: other.example; spf=fail smtp.mailfrom=example.org\r\n"
+	deliver := func() {
+		session(func(writeline func(s string), readresp func(prefix string) string) {
+			writeline("LHLO upstream.example")
+			readresp("250")
+			writeline("MAIL FROM:<remote@example.org>")
+			readresp("250 ")
+			writeline("RCPT TO:<mjl@mox.example>")
+			readresp("250 ")
+			writeline("DATA")
+			readresp("354 ")
+			for _, line := range strings.Split(strings.TrimSuffix(upstreamMsg+deliverMessage, "\r\n"), "\r\n") {
+				writeline(line)
+			}
+			writeline(".")
+			readresp("250 ")
+		})
+	}
+	deliver()
+	ts.checkCount("Inbox", 2)
+	m = lastMessage()
+	tcompare(t, m.RemoteIPMasked1, "")
+	tcompare(t, m.MailFromValidated, false)
+
+	ts.authServID = "upstream.example"
+	deliver()
+	ts.checkCount("Inbox", 3)
+	m = lastMessage()
+	tcompare(t, m.RemoteIP, "10.1.2.3")
+	tcompare(t, m.RemoteIPMasked1, "10.1.2.3")
+	tcompare(t, m.MailFromValidated, true)
+	tcompare(t, m.MailFromValidation, store.ValidationPass)
+
+	// Errors during DATA are returned for each recipient.
+	session(func(writeline func(s string), readresp func(prefix string) string) {
+		writeline("LHLO upstream.example")
+		readresp("250")
+		writeline("MAIL FROM:<remote@example.org> SMTPUTF8")
+		readresp("250 ")
+		writeline("RCPT TO:<mjl@mox.example>")
+		readresp("250 ")
+		writeline("RCPT TO:<móx@mox.example>")
+		readresp("250 ")
+		writeline("DATA")
+		readresp("354 ")
+		writeline("")
+		writeline("bare\n.")
+		writeline(".")
+		readresp("500 ")
+		readresp("500 ")
+	})
+	ts.checkCount("Inbox", 3)
+}
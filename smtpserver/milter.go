@@ -0,0 +1,373 @@
+package smtpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/milter"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var metricMilter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_milter_total",
+		Help: "Responses of milters by transaction stage (mail, rcpt, data) and result (continue, accept, reject, tempfail, discard, error).",
+	},
+	[]string{
+		"stage",
+		"result",
+	},
+)
+
+// milterSession is a connection to a milter for the current message transaction.
+type milterSession struct {
+	conf   config.Milter
+	client *milter.Client // Nil after the milter accepted the message, or failed and is skipped.
+}
+
+// milterMacros returns the macros passed with a milter command, as set by
+// Sendmail and Postfix. Milters like rspamd use them, e.g. to recognize
+// authenticated submissions.
+func (c *conn) milterMacros(kv ...string) map[string]string {
+	m := map[string]string{"i": mox.ReceivedID(c.cid)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m[kv[i]] = kv[i+1]
+	}
+	return m
+}
+
+// milterClose closes the connections to milters of the current transaction.
+func (c *conn) milterClose() {
+	for _, ms := range c.milterSessions {
+		if ms.client != nil {
+			err := ms.client.Close()
+			c.log.Check(err, "closing milter connection", slog.String("milter", ms.conf.Address))
+			ms.client = nil
+		}
+	}
+	c.milterSessions = nil
+}
+
+// xmilterResult handles the response for a stage of the transaction. Errors with
+// fail-closed milters abort the command with a temporary error, other failing
+// milters are skipped. A milter that accepts the message does not see the
+// remainder of the transaction. For rejects and tempfails, the response is
+// returned.
+func (c *conn) xmilterResult(ms *milterSession, stage string, r milter.Response, err error) *milter.Response {
+	if err != nil {
+		metricMilter.WithLabelValues(stage, "error").Inc()
+		if ms.client != nil {
+			xerr := ms.client.Close()
+			c.log.Check(xerr, "closing milter connection after error", slog.String("milter", ms.conf.Address))
+			ms.client = nil
+		}
+		if ms.conf.FailClosed {
+			c.log.Errorx("milter failed, rejecting message", err, slog.String("milter", ms.conf.Address), slog.String("stage", stage))
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "content filter unavailable")
+		}
+		c.log.Errorx("milter failed, skipping", err, slog.String("milter", ms.conf.Address), slog.String("stage", stage))
+		return nil
+	}
+
+	metricMilter.WithLabelValues(stage, r.Action.String()).Inc()
+	c.log.Debug("milter response", slog.String("milter", ms.conf.Address), slog.String("stage", stage), slog.Any("action", r.Action))
+	switch r.Action {
+	case milter.Accept:
+		err := ms.client.Close()
+		c.log.Check(err, "closing milter connection after accept", slog.String("milter", ms.conf.Address))
+		ms.client = nil
+	case milter.Discard:
+		c.log.Info("milter requested to discard message", slog.String("milter", ms.conf.Address), slog.String("stage", stage))
+		c.milterDiscard = true
+		c.milterClose()
+	case milter.Reject, milter.Tempfail:
+		c.log.Info("milter rejected", slog.String("milter", ms.conf.Address), slog.String("stage", stage), slog.Any("action", r.Action), slog.Int("code", r.Code), slog.String("text", r.Text))
+		return &r
+	}
+	return nil
+}
+
+// xmilterMail connects to the milters of the listener for a new transaction and
+// passes the connection details, hello and MAIL FROM. A reject or tempfail by a
+// milter aborts the command, and closes the milters of the transaction.
+func (c *conn) xmilterMail(rpath smtp.Path) {
+	// Sessions of an earlier failed MAIL FROM without RSET must not see this
+	// transaction.
+	c.milterClose()
+	c.milterDiscard = false
+	c.milterDataDone = false
+	if len(c.milterConfs) == 0 {
+		return
+	}
+	// On a reject, or an error with a fail-closed milter, close the sessions
+	// already started.
+	defer func() {
+		x := recover()
+		if x != nil {
+			c.milterClose()
+			panic(x)
+		}
+	}()
+	ctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+
+	var args []string
+	if c.has8bitmime {
+		args = append(args, "BODY=8BITMIME")
+	}
+	if c.smtputf8 {
+		args = append(args, "SMTPUTF8")
+	}
+	if c.requireTLS != nil && *c.requireTLS {
+		args = append(args, "REQUIRETLS")
+	}
+	if c.dsnRet != "" {
+		args = append(args, "RET="+c.dsnRet)
+	}
+	mailMacros := c.milterMacros("{mail_addr}", rpath.String())
+	if c.username != "" {
+		mailMacros["{auth_authen}"] = c.username
+	}
+
+	for _, conf := range c.milterConfs {
+		ms := &milterSession{conf: conf}
+		client, err := milter.Dial(ctx, c.log.Logger, conf.Address, conf.ConnectTimeout, conf.CommandTimeout)
+		if err != nil {
+			c.xmilterResult(ms, "mail", milter.Response{}, err)
+			continue
+		}
+		ms.client = client
+		c.milterSessions = append(c.milterSessions, ms)
+
+		var port int
+		if a, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+			port = a.Port
+		}
+		hostname := smtp.AddressLiteral(c.remoteIP)
+		connectMacros := c.milterMacros("j", mox.Conf.Static.HostnameDomain.ASCII, "{daemon_name}", "mox", "{client_addr}", c.remoteIP.String(), "{if_addr}", c.localIP.String())
+		r, err := client.Connect(hostname, c.remoteIP, port, connectMacros)
+		if r.Action == milter.Continue && err == nil {
+			r, err = client.Helo(c.hello.String(), nil)
+		}
+		if r.Action == milter.Continue && err == nil {
+			r, err = client.Mail(rpath.String(), args, mailMacros)
+		}
+		if rr := c.xmilterResult(ms, "mail", r, err); rr != nil {
+			xsmtpUserErrorf(rr.Code, rr.Secode, "%s", rr.Text)
+		}
+		if c.milterDiscard {
+			return
+		}
+	}
+}
+
+// xmilterRcpt passes a recipient to the milters. If a milter rejects the
+// recipient, the response is returned.
+func (c *conn) xmilterRcpt(rcpt smtp.Path, notify []string) *milter.Response {
+	var args []string
+	if len(notify) > 0 {
+		args = append(args, "NOTIFY="+strings.Join(notify, ","))
+	}
+	for _, ms := range c.milterSessions {
+		if ms.client == nil {
+			continue
+		}
+		r, err := ms.client.Rcpt(rcpt.String(), args, c.milterMacros("{rcpt_addr}", rcpt.String()))
+		if rr := c.xmilterResult(ms, "rcpt", r, err); rr != nil {
+			return rr
+		}
+	}
+	return nil
+}
+
+// milterResult holds the modifications requested by milters at the end of the
+// message, other than header changes.
+type milterResult struct {
+	quarantine string      // Reason, if quarantined.
+	addRcpts   []smtp.Path // Recipients to add.
+}
+
+// xmilterData passes the message to the milters. Header changes by a milter are
+// applied to the message before it is passed to the next milter. If header fields
+// were changed, a new temporary file is returned, which the caller must remove. A
+// reject or tempfail by a milter aborts the command.
+func (c *conn) xmilterData(msgWriter *message.Writer, dataFile *os.File) (*message.Writer, *os.File, milterResult) {
+	var mr milterResult
+	if len(c.milterConfs) == 0 {
+		return msgWriter, nil, mr
+	}
+	if c.milterDataDone {
+		// The transaction was already passed to the milters, which are closed. We don't
+		// want a message to circumvent the milters with a second attempt.
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "message already processed by content filter, start a new transaction")
+	}
+	c.milterDataDone = true
+	defer c.milterClose()
+
+	var newFile *os.File
+	defer func() {
+		x := recover()
+		if x != nil && newFile != nil {
+			store.CloseRemoveTempFile(c.log, newFile, "message with milter changes")
+		}
+		if x != nil {
+			panic(x)
+		}
+	}()
+
+	msgFile := dataFile
+	for _, ms := range c.milterSessions {
+		if ms.client == nil || c.milterDiscard {
+			continue
+		}
+		r, mods, err := ms.client.Message(io.NewSectionReader(msgFile, 0, msgWriter.Size), c.milterMacros())
+		if rr := c.xmilterResult(ms, "data", r, err); rr != nil {
+			// The milters are closed, we need a new transaction.
+			c.rset()
+			xsmtpUserErrorf(rr.Code, rr.Secode, "%s", rr.Text)
+		} else if err != nil || c.milterDiscard || len(mods) == 0 {
+			continue
+		}
+
+		var haveHeaderMods bool
+		for _, m := range mods {
+			switch m.Kind {
+			case milter.ModAddHeader, milter.ModInsertHeader, milter.ModChangeHeader:
+				haveHeaderMods = true
+			case milter.ModAddRcpt:
+				addr, err := smtp.ParseAddress(m.Rcpt)
+				if err != nil {
+					c.log.Infox("parsing recipient added by milter, ignoring", err, slog.String("milter", ms.conf.Address), slog.String("rcpt", m.Rcpt))
+					continue
+				}
+				mr.addRcpts = append(mr.addRcpts, addr.Path())
+			case milter.ModQuarantine:
+				mr.quarantine = m.Reason
+				if mr.quarantine == "" {
+					mr.quarantine = "quarantined by " + ms.conf.Address
+				}
+			}
+		}
+		if !haveHeaderMods {
+			continue
+		}
+
+		f, w, err := c.milterApplyHeader(msgFile, msgWriter.Size, mods)
+		if err != nil {
+			c.log.Errorx("applying header changes by milter", err, slog.String("milter", ms.conf.Address))
+			if ms.conf.FailClosed {
+				c.rset()
+				xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+			}
+			continue
+		}
+		if newFile != nil {
+			store.CloseRemoveTempFile(c.log, newFile, "message with milter changes")
+		}
+		newFile = f
+		msgFile = f
+		msgWriter = w
+	}
+	return msgWriter, newFile, mr
+}
+
+// milterApplyHeader writes the message with header changes by a milter applied
+// to a new temporary file.
+func (c *conn) milterApplyHeader(msgFile *os.File, size int64, mods []milter.Modification) (rf *os.File, rw *message.Writer, rerr error) {
+	br := bufio.NewReader(io.NewSectionReader(msgFile, 0, size))
+	fields, err := milter.ReadHeader(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading message header: %v", err)
+	}
+
+	f, err := store.CreateMessageTemp(c.log, "smtp-milter")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating temporary file: %v", err)
+	}
+	defer func() {
+		if rerr != nil {
+			store.CloseRemoveTempFile(c.log, f, "message with milter changes")
+		}
+	}()
+	w := message.NewWriter(f)
+	if _, err := w.Write(milter.ApplyHeader(fields, mods)); err != nil {
+		return nil, nil, fmt.Errorf("writing header: %v", err)
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		return nil, nil, fmt.Errorf("writing body: %v", err)
+	}
+	return f, w, nil
+}
+
+// milterAddRcpts adds recipients requested by milters to the transaction, with
+// the checks of RCPT TO. For incoming deliveries, only local recipients are added.
+// For submissions, external recipients are added too, and all recipients count
+// towards the outgoing limits of the account, which are checked after the milters
+// have run.
+func (c *conn) milterAddRcpts(log mlog.Log, rcpts []smtp.Path) {
+	for _, rcpt := range rcpts {
+		if len(c.recipients) >= rcptToLimit {
+			log.Info("max number of recipients reached, ignoring recipient added by milter", slog.Any("rcpt", rcpt))
+			continue
+		}
+		if len(rcpt.IPDomain.IP) > 0 {
+			if !c.submission {
+				log.Info("recipient added by milter is not local, ignoring", slog.Any("rcpt", rcpt))
+				continue
+			}
+			c.recipients = append(c.recipients, recipient{Addr: rcpt})
+			log.Info("recipient added by milter", slog.Any("rcpt", rcpt))
+			continue
+		}
+		accountName, alias, canonical, dest, err := mox.LookupAddress(rcpt.Localpart, rcpt.IPDomain.Domain, true, true, true)
+		if err == nil && alias != nil {
+			c.recipients = append(c.recipients, recipient{Addr: rcpt, Alias: &rcptAlias{*alias, canonical}})
+		} else if err == nil && dest.SMTPError != "" {
+			log.Info("recipient added by milter has smtp error configured, ignoring", slog.Any("rcpt", rcpt))
+			continue
+		} else if err == nil {
+			c.recipients = append(c.recipients, recipient{Addr: rcpt, Account: &rcptAccount{accountName, dest, canonical}})
+		} else if c.submission && errors.Is(err, mox.ErrDomainNotFound) {
+			c.recipients = append(c.recipients, recipient{Addr: rcpt})
+		} else {
+			log.Infox("recipient added by milter not accepted, ignoring", err, slog.Any("rcpt", rcpt))
+			continue
+		}
+		log.Info("recipient added by milter", slog.Any("rcpt", rcpt))
+	}
+}
+
+// milterQuarantine changes an analysis that accepts the message to deliver to the
+// Junk mailbox of the account instead, with the $Junk flag.
+func milterQuarantine(ctx context.Context, log mlog.Log, a *analysis, reason string) {
+	if !a.accept {
+		return
+	}
+	mailbox := "Junk"
+	mb, err := bstore.QueryDB[store.Mailbox](ctx, a.d.acc.DB).FilterEqual("Expunged", false).FilterEqual("Junk", true).Get()
+	if err == nil {
+		mailbox = mb.Name
+	} else if err != bstore.ErrAbsent {
+		log.Errorx("looking up junk mailbox for quarantined message", err)
+	}
+	log.Info("delivering message quarantined by milter to junk mailbox", slog.String("reason", reason), slog.String("mailbox", mailbox), slog.Any("rcptto", a.d.deliverTo))
+	a.mailbox = mailbox
+	a.d.m.Junk = true
+	a.reasonText = append(a.reasonText, "quarantined by milter: "+reason)
+}
@@ -0,0 +1,282 @@
+package smtpserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+// fakeMilter serves the milter protocol on conn. Senders and recipients
+// containing "reject" are rejected. Commands for a transaction after a rejected
+// sender are counted in violations. Messages with "tempfail" in the body are temporarily rejected.
+// Messages with "quarantine" in the body are quarantined. Messages with "addrcpt"
+// in the body get recipient other@example.net added. Other messages get a header
+// field added.
+func fakeMilter(conn net.Conn, violations *atomic.Int32) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	read := func() (byte, []byte, error) {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return 0, nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return 0, nil, err
+		}
+		return buf[0], buf[1:], nil
+	}
+	write := func(cmd byte, data ...string) {
+		s := string(cmd) + strings.Join(data, "")
+		buf := append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+		conn.Write(buf)
+	}
+
+	var body string
+	var mailRejected bool
+	for {
+		cmd, data, err := read()
+		if err != nil {
+			return
+		}
+		if mailRejected && cmd != 'Q' && cmd != 'A' && cmd != 'D' && cmd != 'M' {
+			violations.Add(1)
+		}
+		switch cmd {
+		case 'O':
+			// Optneg. Accept all actions offered, and ask for all steps.
+			write('O', string(binary.BigEndian.AppendUint32(nil, 6)), string(data[4:8]), string(make([]byte, 4)))
+		case 'Q':
+			return
+		case 'D':
+			// Macros, no response.
+		case 'M':
+			mailRejected = strings.Contains(string(data), "reject")
+			if mailRejected {
+				write('y', "550 5.7.1 sender refused by filter\x00")
+			} else {
+				write('c')
+			}
+		case 'R':
+			if strings.Contains(string(data), "reject") {
+				write('y', "550 5.7.1 recipient refused by filter\x00")
+			} else {
+				write('c')
+			}
+		case 'B':
+			body += string(data)
+			write('c')
+		case 'E':
+			if strings.Contains(body, "tempfail") {
+				write('t')
+				continue
+			}
+			if strings.Contains(body, "quarantine") {
+				write('q', "suspicious\x00")
+			}
+			if strings.Contains(body, "addrcpt") {
+				write('+', "other@example.net\x00")
+			}
+			write('h', "X-Milter\x00checked\x00")
+			write('a')
+		default:
+			write('c')
+		}
+	}
+}
+
+func TestMilter(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	var violations atomic.Int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen for milter")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeMilter(conn, &violations)
+		}
+	}()
+
+	// Address without milter listening.
+	xln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	badAddress := "inet:" + xln.Addr().String()
+	xln.Close()
+
+	milterConf := config.Milter{Address: "inet:" + ln.Addr().String(), ConnectTimeout: time.Second, CommandTimeout: time.Second}
+	mox.Conf.Static.Listeners["test"] = config.Listener{Milters: []config.Milter{milterConf}}
+	defer delete(mox.Conf.Static.Listeners, "test")
+
+	deliver := func(rcptTo, msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	lastMessage := func() (store.Message, string) {
+		t.Helper()
+		m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get last message")
+		buf, err := os.ReadFile(ts.acc.MessagePath(m.ID))
+		tcheck(t, err, "read message")
+		return m, string(buf)
+	}
+
+	// Header field added by milter.
+	deliver("mjl@mox.example", deliverMessage, nil)
+	ts.checkCount("Inbox", 1)
+	if _, s := lastMessage(); !strings.Contains(s, "\r\nX-Milter: checked\r\n") {
+		t.Fatalf("missing header field added by milter in message:\n%s", s)
+	}
+
+	// Recipient rejected by milter.
+	deliver("reject@mox.example", deliverMessage, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+
+	// Message temporarily rejected by milter.
+	tempfailMessage := strings.Replace(deliverMessage, "test email", "tempfail", 1)
+	deliver("mjl@mox.example", tempfailMessage, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SePol7Other0})
+	ts.checkCount("Inbox", 1)
+
+	// Quarantined message is delivered to the Junk mailbox.
+	quarantineMessage := strings.Replace(deliverMessage, "test email", "quarantine", 1)
+	deliver("mjl@mox.example", quarantineMessage, nil)
+	ts.checkCount("Inbox", 1)
+	ts.checkCount("Junk", 1)
+	if m, _ := lastMessage(); !m.Junk {
+		t.Fatalf("quarantined message does not have junk flag")
+	}
+
+	// Sender rejected by milter, followed by a new transaction without RSET. The
+	// milter that rejected the sender must not see the new transaction.
+	ts.runRaw(func(conn net.Conn) {
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		writeline := func(s string) {
+			t.Helper()
+			_, err := conn.Write([]byte(s + "\r\n"))
+			tcheck(t, err, "write")
+		}
+		readresp := func(prefix string) {
+			t.Helper()
+			for {
+				line, err := br.ReadString('\n')
+				tcheck(t, err, "read")
+				if !strings.HasPrefix(line, prefix) {
+					t.Fatalf("got response %q, expected prefix %q", line, prefix)
+				}
+				if len(line) < 4 || line[3] != '-' {
+					return
+				}
+			}
+		}
+
+		readresp("220 ")
+		writeline("EHLO example.org")
+		readresp("250")
+		writeline("MAIL FROM:<reject@example.org>")
+		readresp("550 5.7.1 ")
+		writeline("MAIL FROM:<remote@example.org>")
+		readresp("250 ")
+		writeline("RCPT TO:<mjl@mox.example>")
+		readresp("250 ")
+		writeline("DATA")
+		readresp("354 ")
+		writeline(strings.TrimSuffix(deliverMessage, "\r\n"))
+		writeline(".")
+		readresp("250 ")
+		writeline("QUIT")
+		readresp("221 ")
+	})
+	ts.checkCount("Inbox", 2)
+	tcompare(t, violations.Load(), int32(0))
+
+	// Failing milter is skipped by default.
+	mox.Conf.Static.Listeners["test"] = config.Listener{Milters: []config.Milter{{Address: badAddress, ConnectTimeout: time.Second, CommandTimeout: time.Second}}}
+	deliver("mjl@mox.example", deliverMessage, nil)
+	ts.checkCount("Inbox", 3)
+
+	// Failing milter rejects delivery when failing closed.
+	mox.Conf.Static.Listeners["test"] = config.Listener{Milters: []config.Milter{{Address: badAddress, ConnectTimeout: time.Second, CommandTimeout: time.Second, FailClosed: true}}}
+	deliver("mjl@mox.example", deliverMessage, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	ts.checkCount("Inbox", 3)
+
+	// Milter not used for incoming deliveries when disabled for SMTP.
+	mox.Conf.Static.Listeners["test"] = config.Listener{Milters: []config.Milter{{Address: badAddress, FailClosed: true, NoSMTP: true}}}
+	deliver("mjl@mox.example", deliverMessage, nil)
+	ts.checkCount("Inbox", 4)
+
+	// Quarantined submission is held in the queue.
+	mox.Conf.Static.Listeners["test"] = config.Listener{Milters: []config.Milter{milterConf}}
+	ts.submission = true
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.run(func(client *smtpclient.Client) {
+		msg := strings.Replace(submitMessage, "test email", "quarantine", 1)
+		err := client.Deliver(ctxbg, "mjl@mox.example", "remote@example.org", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Hold, true)
+
+	// Recipients added by milter count towards the outgoing limits of the account.
+	acc := mox.Conf.Dynamic.Accounts[ts.acc.Name]
+	acc.MaxOutgoingMessagesPerDay = 2
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+	defer func() {
+		acc.MaxOutgoingMessagesPerDay = 0
+		mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+	}()
+	addrcptMessage := strings.Replace(submitMessage, "test email", "addrcpt", 1)
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "mjl@mox.example", "remote@example.org", int64(len(addrcptMessage)), strings.NewReader(addrcptMessage), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SePol7DeliveryUnauth1})
+	})
+	acc.MaxOutgoingMessagesPerDay = 3
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "mjl@mox.example", "remote@example.org", int64(len(addrcptMessage)), strings.NewReader(addrcptMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	msgs, err = queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 3)
+	tcompare(t, msgs[2].Recipient().String(), "other@example.net")
+}
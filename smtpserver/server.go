@@ -27,6 +27,7 @@ import (
 	"runtime/debug"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -52,9 +53,11 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
@@ -187,6 +190,15 @@ var (
 			"reason", // "eof", "sslv2", "unsupportedversions", "nottls", "alert-<num>-<msg>", "other"
 		},
 	)
+	metricReservoirFlip = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_smtpserver_reservoir_flip_total",
+			Help: "Incoming deliveries where the reservoir filter moved the Bayesian junk probability across the junk threshold.",
+		},
+		[]string{
+			"to", // "junk" or "nonjunk"
+		},
+	)
 )
 
 var jitterRand = mox.NewPseudoRand()
@@ -260,6 +272,22 @@ func Listen() {
 				listen1("submissions", name, ip, port, hostname, tlsConfig, true, true, noTLSClientAuth, maxMsgSize, true, true, true, nil, 0)
 			}
 		}
+
+		if listener.LMTP.Enabled {
+			hostname := mox.Conf.Static.HostnameDomain
+			if listener.Hostname != "" {
+				hostname = listener.HostnameDomain
+			}
+			if listener.LMTP.UnixSocket != "" {
+				listenLMTP(name, mox.DataDirPath(listener.LMTP.UnixSocket), "", 0, hostname, maxMsgSize, nil, listener.LMTP.TrustedAuthServID)
+			}
+			if len(listener.LMTP.AllowedNets) > 0 {
+				port := config.Port(listener.LMTP.Port, 24)
+				for _, ip := range listener.IPs {
+					listenLMTP(name, "", ip, port, hostname, maxMsgSize, listener.LMTP.AllowedNets, listener.LMTP.TrustedAuthServID)
+				}
+			}
+		}
 	}
 }
 
@@ -299,7 +327,7 @@ func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig
 
 			// Package is set on the resolver by the dkim/spf/dmarc/etc packages.
 			resolver := dns.StrictResolver{Log: log.Logger}
-			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, false, noTLSClientAuth, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, dnsBLs, firstTimeSenderDelay)
+			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, false, noTLSClientAuth, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, dnsBLs, firstTimeSenderDelay, false, "")
 		}
 	}
 
@@ -337,6 +365,8 @@ type conn struct {
 	slow                  bool      // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
 	lastlog               time.Time // Used for printing the delta time since the previous logging for this connection.
 	submission            bool      // ../rfc/6409:19 applies
+	lmtp                  bool      // ../rfc/2033 applies, delivery by a trusted upstream with a response per recipient.
+	trustedAuthServID     string    // For LMTP, authserv-id of Authentication-Results header from upstream to use.
 	baseTLSConfig         *tls.Config
 	localIP               net.IP
 	remoteIP              net.IP
@@ -373,10 +403,29 @@ type conn struct {
 	requireTLS           *bool     // MAIL FROM with REQUIRETLS set.
 	futureRelease        time.Time // MAIL FROM with HOLDFOR or HOLDUNTIL.
 	futureReleaseRequest string    // For use in DSNs, either "for;" or "until;" plus original value. ../rfc/4865:305
+	dsnRet               string    // MAIL FROM with RET, "FULL" or "HDRS". ../rfc/3461
+	dsnEnvID             string    // MAIL FROM with ENVID, xtext-decoded.
 	has8bitmime          bool      // If MAIL FROM parameter BODY=8BITMIME was sent. Required for SMTPUTF8.
 	smtputf8             bool      // todo future: we should keep track of this per recipient. perhaps only a specific recipient requires smtputf8, e.g. due to a utf8 localpart.
 	msgsmtputf8          bool      // Is SMTPUTF8 required for the received message. Default to the same value as `smtputf8`, but is re-evaluated after the whole message (envelope and data) is received.
 	recipients           []recipient
+	mailFromSPFPass      *bool     // Whether SPF passed for the MAIL FROM domain, evaluated at RCPT TO when needed.
+	bdat                 *bdatData // Message data from BDAT chunks received so far, until the LAST chunk.
+
+	// Milters of the listener, and their state for the message transaction.
+	milterConfs      []config.Milter
+	milterSessions   []*milterSession
+	milterDiscard    bool   // If set, a milter requested the message to be discarded.
+	milterDataDone   bool   // If set, the message was passed to the milters, which are now closed.
+	milterQuarantine string // If set, reason a milter quarantined the message.
+}
+
+// bdatData holds the message data of a transaction while it is sent in chunks
+// with BDAT. ../rfc/3030
+type bdatData struct {
+	file      *os.File
+	msgWriter *message.Writer
+	lw        *limitWriter // Writes to msgWriter, enforcing the maximum message size over all chunks.
 }
 
 type rcptAccount struct {
@@ -398,6 +447,14 @@ type recipient struct {
 	// deliveries, this will result in an error.
 	Account *rcptAccount // If set, recipient address is for this local account.
 	Alias   *rcptAlias   // If set, for a local alias.
+
+	// If set, recipient is an SRS address of ours, used as MAIL FROM for a forwarded
+	// message, and this is the address the bounce is passed on to.
+	SRS *smtp.Path
+
+	// Parameters for the DSN extension, ../rfc/3461.
+	Notify []string // NOTIFY, "NEVER", or one or more of "SUCCESS", "FAILURE" and "DELAY".
+	ORCPT  string   // ORCPT, address type, semicolon and xtext-decoded address.
 }
 
 func isClosed(err error) bool {
@@ -673,10 +730,18 @@ func (c *conn) rset() {
 	c.requireTLS = nil
 	c.futureRelease = time.Time{}
 	c.futureReleaseRequest = ""
+	c.dsnRet = ""
+	c.dsnEnvID = ""
 	c.has8bitmime = false
 	c.smtputf8 = false
 	c.msgsmtputf8 = false
 	c.recipients = nil
+	c.mailFromSPFPass = nil
+	c.bdatCleanup()
+	c.milterClose()
+	c.milterDiscard = false
+	c.milterDataDone = false
+	c.milterQuarantine = ""
 }
 
 func (c *conn) earliestDeadline(d time.Duration) time.Time {
@@ -864,19 +929,24 @@ var cleanClose struct{} // Sentinel value for panic/recover indicating clean clo
 func ServeTLSConn(listenerName string, hostname dns.Domain, conn *tls.Conn, tlsConfig *tls.Config, submission, viaHTTPS bool, maxMsgSize int64, requireTLS bool) {
 	log := mlog.New("smtpserver", nil)
 	resolver := dns.StrictResolver{Log: log.Logger}
-	serve(listenerName, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, true, viaHTTPS, true, maxMsgSize, true, true, requireTLS, nil, 0)
+	serve(listenerName, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, true, viaHTTPS, true, maxMsgSize, true, true, requireTLS, nil, 0, false, "")
 }
 
-func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, xtls, viaHTTPS, noTLSClientAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
+func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, xtls, viaHTTPS, noTLSClientAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration, lmtp bool, trustedAuthServID string) {
 	var localIP, remoteIP net.IP
 	if a, ok := nc.LocalAddr().(*net.TCPAddr); ok {
 		localIP = a.IP
+	} else if _, ok := nc.LocalAddr().(*net.UnixAddr); ok {
+		// For LMTP over a unix domain socket.
+		localIP = net.IPv4(127, 0, 0, 1)
 	} else {
 		// For net.Pipe, during tests.
 		localIP = net.ParseIP("127.0.0.10")
 	}
 	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
 		remoteIP = a.IP
+	} else if _, ok := nc.RemoteAddr().(*net.UnixAddr); ok {
+		remoteIP = net.IPv4(127, 0, 0, 1)
 	} else {
 		// For net.Pipe, during tests.
 		remoteIP = net.ParseIP("127.0.0.10")
@@ -892,6 +962,8 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		origConn:              origConn,
 		conn:                  nc,
 		submission:            submission,
+		lmtp:                  lmtp,
+		trustedAuthServID:     trustedAuthServID,
 		tls:                   xtls,
 		viaHTTPS:              viaHTTPS,
 		noTLSClientAuth:       noTLSClientAuth,
@@ -908,6 +980,13 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		dnsBLs:                dnsBLs,
 		firstTimeSenderDelay:  firstTimeSenderDelay,
 	}
+	if !lmtp {
+		for _, m := range mox.Conf.Static.Listeners[listenerName].Milters {
+			if submission && !m.NoSubmission || !submission && !m.NoSMTP {
+				c.milterConfs = append(c.milterConfs, m)
+			}
+		}
+	}
 	var logmutex sync.Mutex
 	// Also see (and possibly update) c.logbg, for logging in a goroutine.
 	c.log = mlog.New("smtpserver", nil).WithFunc(func() []slog.Attr {
@@ -934,6 +1013,7 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		slog.Any("remote", c.conn.RemoteAddr()),
 		slog.Any("local", c.conn.LocalAddr()),
 		slog.Bool("submission", submission),
+		slog.Bool("lmtp", lmtp),
 		slog.Bool("tls", xtls),
 		slog.Bool("viahttps", viaHTTPS),
 		slog.String("listener", listenerName))
@@ -948,6 +1028,8 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 			c.log.Check(err, "closing account")
 			c.account = nil
 		}
+		c.bdatCleanup()
+		c.milterClose()
 
 		x := recover()
 		if x == nil || x == cleanClose {
@@ -975,7 +1057,9 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	default:
 	}
 
-	if !limiterConnectionRate.Add(c.remoteIP, time.Now(), 1) {
+	// Connections for LMTP come from a trusted upstream that may deliver many
+	// messages, we don't limit them.
+	if !lmtp && !limiterConnectionRate.Add(c.remoteIP, time.Now(), 1) {
 		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "connection rate from your ip or network too high, slow down please", nil)
 		return
 	}
@@ -988,24 +1072,35 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		return
 	}
 
-	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
-		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
-		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many open connections from your ip or network", nil)
-		return
+	if !lmtp {
+		if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+			c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+			c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many open connections from your ip or network", nil)
+			return
+		}
+		defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
 	}
-	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
 
 	// We register and unregister the original connection, in case c.conn is replaced
 	// with a TLS connection later on.
-	mox.Connections.Register(nc, "smtp", listenerName)
+	protocol := "smtp"
+	if lmtp {
+		protocol = "lmtp"
+	}
+	mox.Connections.Register(nc, protocol, listenerName)
 	defer mox.Connections.Unregister(nc)
 
-	// ../rfc/5321:964 ../rfc/5321:4294 about announcing software and version
-	// Syntax: ../rfc/5321:2586
-	// We include the string ESMTP. https://cr.yp.to/smtp/greeting.html recommends it.
-	// Should not be too relevant nowadays, but does not hurt and default blackbox
-	// exporter SMTP health check expects it.
-	c.xwritelinef("%d %s ESMTP mox", smtp.C220ServiceReady, c.hostname.ASCII)
+	if lmtp {
+		// ../rfc/2033
+		c.xwritelinef("%d %s LMTP mox", smtp.C220ServiceReady, c.hostname.ASCII)
+	} else {
+		// ../rfc/5321:964 ../rfc/5321:4294 about announcing software and version
+		// Syntax: ../rfc/5321:2586
+		// We include the string ESMTP. https://cr.yp.to/smtp/greeting.html recommends it.
+		// Should not be too relevant nowadays, but does not hurt and default blackbox
+		// exporter SMTP health check expects it.
+		c.xwritelinef("%d %s ESMTP mox", smtp.C220ServiceReady, c.hostname.ASCII)
+	}
 
 	for {
 		command(c)
@@ -1026,11 +1121,13 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 var commands = map[string]func(c *conn, p *parser){
 	"helo":     (*conn).cmdHelo,
 	"ehlo":     (*conn).cmdEhlo,
+	"lhlo":     (*conn).cmdLhlo,
 	"starttls": (*conn).cmdStarttls,
 	"auth":     (*conn).cmdAuth,
 	"mail":     (*conn).cmdMail,
 	"rcpt":     (*conn).cmdRcpt,
 	"data":     (*conn).cmdData,
+	"bdat":     (*conn).cmdBdat,
 	"rset":     (*conn).cmdRset,
 	"vrfy":     (*conn).cmdVrfy,
 	"expn":     (*conn).cmdExpn,
@@ -1114,6 +1211,8 @@ func command(c *conn) {
 func (c *conn) kind() string {
 	if c.submission {
 		return "submission"
+	} else if c.lmtp {
+		return "lmtp"
 	}
 	return "smtp"
 }
@@ -1141,13 +1240,30 @@ func isTLSReportRecipient(rcpt smtp.Path) bool {
 }
 
 func (c *conn) cmdHelo(p *parser) {
+	c.xneedNotLMTP()
 	c.cmdHello(p, false)
 }
 
 func (c *conn) cmdEhlo(p *parser) {
+	c.xneedNotLMTP()
 	c.cmdHello(p, true)
 }
 
+// ../rfc/2033
+func (c *conn) cmdLhlo(p *parser) {
+	if !c.lmtp {
+		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "lhlo only for lmtp")
+	}
+	c.cmdHello(p, true)
+}
+
+// HELO and EHLO are not allowed for LMTP. ../rfc/2033
+func (c *conn) xneedNotLMTP() {
+	if c.lmtp {
+		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "this is lmtp, use lhlo")
+	}
+}
+
 // ../rfc/5321:1783
 func (c *conn) cmdHello(p *parser, ehlo bool) {
 	var remote dns.IPDomain
@@ -1217,13 +1333,17 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 		if c.tls && len(c.conn.(*tls.Conn).ConnectionState().PeerCertificates) > 0 && !c.viaHTTPS && !c.noTLSClientAuth {
 			mechs = "EXTERNAL " + mechs
 		}
+		if (c.tls || !c.requireTLSForAuth) && oidc.Configured() != nil {
+			mechs += " OAUTHBEARER XOAUTH2" // ../rfc/7628
+		}
 		c.xbwritelinef("250-AUTH %s", mechs)
 		// ../rfc/4865:127
 		t := time.Now().Add(queue.FutureReleaseIntervalMax).UTC() // ../rfc/4865:98
 		c.xbwritelinef("250-FUTURERELEASE %d %s", queue.FutureReleaseIntervalMax/time.Second, t.Format(time.RFC3339))
 	}
-	c.xbwritelinef("250-ENHANCEDSTATUSCODES") // ../rfc/2034:71
-	// todo future? c.writelinef("250-DSN")
+	c.xbwritelinef("250-ENHANCEDSTATUSCODES")            // ../rfc/2034:71
+	c.xbwritelinef("250-DSN")                            // ../rfc/3461
+	c.xbwritelinef("250-CHUNKING")                       // ../rfc/3030
 	c.xbwritelinef("250-8BITMIME")                       // ../rfc/6152:86
 	c.xbwritelinef("250-LIMITS RCPTMAX=%d", rcptToLimit) // ../rfc/9422:301
 	c.xbwritecodeline(250, "", "SMTPUTF8", nil)          // ../rfc/6531:201
@@ -1630,6 +1750,70 @@ func (c *conn) cmdAuth(p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+	case "OAUTHBEARER", "XOAUTH2":
+		la.AuthMech = strings.ToLower(mech)
+
+		provider := oidc.Configured()
+		if provider == nil {
+			// ../rfc/4954:176
+			xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism %s not supported", mech)
+		}
+		// ../rfc/4954:343
+		if !c.tls && c.requireTLSForAuth {
+			xsmtpUserErrorf(smtp.C538EncReqForAuth, smtp.SePol7EncReqForAuth11, "authentication requires tls")
+		}
+
+		// Token is a credential, so hide it.
+		defer c.xtrace(mlog.LevelTraceauth)()
+		buf := xreadInitial("")
+		c.xtrace(mlog.LevelTrace) // Restore.
+		var authz, token string
+		var err error
+		if la.AuthMech == "oauthbearer" {
+			authz, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			authz, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			la.Result = store.AuthBadProtocol
+			xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "%s", err)
+		}
+
+		// On failure, we send a challenge with an error, the client responds with a dummy
+		// message, and then authentication fails. ../rfc/7628
+		xfailf := func(format string, args ...any) {
+			c.xwritelinef("%d %s", smtp.C334ContinueAuth, base64.StdEncoding.EncodeToString([]byte(sasl.OAuthErrorChallenge)))
+			xreadContinuation()
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, format, args...)
+		}
+
+		username, err = provider.VerifyAccessToken(context.TODO(), c.log, token)
+		if errors.Is(err, oidc.ErrToken) {
+			la.Result = store.AuthBadCredentials
+			c.log.Infox("failed authentication attempt with token", err, slog.Any("remote", c.remoteIP))
+			xfailf("bad token")
+		} else if err != nil {
+			c.log.Errorx("verifying token", err)
+			// ../rfc/4954:586
+			xsmtpUserErrorf(smtp.C454TempAuthFail, smtp.SePol7Other0, "temporary error verifying token")
+		}
+		la.LoginAddress = username
+
+		if authz != "" {
+			if addr, err := smtp.ParseAddress(norm.NFC.String(authz)); err != nil || addr.String() != username {
+				la.Result = store.AuthBadCredentials
+				xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "cannot assume other role")
+			}
+		}
+
+		account, la.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+			la.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt with token for unknown address", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xfailf("bad token")
+		}
+		xcheckf(err, "looking up address")
+
 	case "EXTERNAL":
 		la.AuthMech = "external"
 
@@ -1838,6 +2022,22 @@ func (c *conn) cmdMail(p *parser) {
 				c.futureRelease = t
 				c.futureReleaseRequest = "until;" + s
 			}
+		case "RET":
+			// ../rfc/3461
+			p.xtake("=")
+			v := strings.ToUpper(p.xparamValue())
+			if v != "FULL" && v != "HDRS" {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "RET must be FULL or HDRS")
+			}
+			c.dsnRet = v
+		case "ENVID":
+			// ../rfc/3461
+			p.xtake("=")
+			v := p.xtext()
+			if len(v) > 100 {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "ENVID too long")
+			}
+			c.dsnEnvID = v
 		default:
 			// ../rfc/5321:2230
 			xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
@@ -1867,7 +2067,8 @@ func (c *conn) cmdMail(p *parser) {
 		return ok
 	}
 
-	if !c.submission && !rpath.IPDomain.Domain.IsZero() {
+	// For LMTP, the upstream has already accepted the message.
+	if !c.submission && !c.lmtp && !rpath.IPDomain.Domain.IsZero() {
 		// If rpath domain has null MX record or is otherwise not accepting email, reject.
 		// ../rfc/7505:181
 		// ../rfc/5321:4045
@@ -1908,11 +2109,46 @@ func (c *conn) cmdMail(p *parser) {
 		c.xlocalserveError(rpath.Localpart)
 	}
 
+	c.xmilterMail(rpath)
+
 	c.mailFrom = &rpath
 
 	c.xbwritecodeline(smtp.C250Completed, smtp.SeAddr1Other0, "looking good", nil)
 }
 
+// mailFromSPFPassed returns whether SPF passes for the MAIL FROM domain. The SPF
+// check is done once per transaction, at the first RCPT TO that needs it.
+func (c *conn) mailFromSPFPassed() bool {
+	if c.mailFromSPFPass != nil {
+		return *c.mailFromSPFPass
+	}
+
+	var pass bool
+	d := c.mailFrom.IPDomain.Domain
+	if !d.IsZero() {
+		// todo: use this spf result for DATA.
+		spfArgs := spf.Args{
+			RemoteIP:          c.remoteIP,
+			MailFromLocalpart: c.mailFrom.Localpart,
+			MailFromDomain:    d,
+			HelloDomain:       c.hello,
+			LocalIP:           c.localIP,
+			LocalHostname:     c.hostname,
+		}
+		cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+		spfctx, spfcancel := context.WithTimeout(cidctx, time.Minute)
+		defer spfcancel()
+		receivedSPF, _, _, _, err := spf.Verify(spfctx, c.log.Logger, c.resolver, spfArgs)
+		spfcancel()
+		if err != nil {
+			c.log.Errorx("spf verify for mail from domain at rcpt to", err)
+		}
+		pass = receivedSPF.Identity == spf.ReceivedMailFrom && receivedSPF.Result == spf.StatusPass
+	}
+	c.mailFromSPFPass = &pass
+	return pass
+}
+
 // ../rfc/5321:1916 ../rfc/5321:1054
 func (c *conn) cmdRcpt(p *parser) {
 	c.xneedHello()
@@ -1936,13 +2172,46 @@ func (c *conn) cmdRcpt(p *parser) {
 	} else {
 		fpath = p.xforwardPath()
 	}
+	var notify []string
+	var orcpt string
+	paramSeen := map[string]bool{}
 	for p.space() {
 		// ../rfc/5321:2275
 		key := p.xparamKeyword()
-		// K := strings.ToUpper(key)
-		// todo future: DSN, ../rfc/3461, with "NOTIFY"
-		// ../rfc/5321:2230
-		xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
+		K := strings.ToUpper(key)
+		if paramSeen[K] {
+			xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "duplicate param %q", key)
+		}
+		paramSeen[K] = true
+
+		switch K {
+		case "NOTIFY":
+			// Either NEVER, or a list of SUCCESS, FAILURE and DELAY. ../rfc/3461
+			p.xtake("=")
+			for _, v := range strings.Split(strings.ToUpper(p.xparamValue()), ",") {
+				switch v {
+				case "NEVER", "SUCCESS", "FAILURE", "DELAY":
+				default:
+					xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "unrecognized NOTIFY value %q", v)
+				}
+				if slices.Contains(notify, v) || v == "NEVER" && len(notify) > 0 || slices.Contains(notify, "NEVER") {
+					xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "bad combination of NOTIFY values")
+				}
+				notify = append(notify, v)
+			}
+		case "ORCPT":
+			// Address type, semicolon, xtext-encoded address. ../rfc/3461
+			p.xtake("=")
+			addrType := p.xatom(false)
+			p.xtake(";")
+			orcpt = addrType + ";" + p.xtext()
+			if len(orcpt) > 500 {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "ORCPT too long")
+			}
+		default:
+			// ../rfc/5321:2230
+			xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
+		}
 	}
 	p.xend()
 
@@ -1962,7 +2231,7 @@ func (c *conn) cmdRcpt(p *parser) {
 	// We don't want to allow delivery to multiple recipients with a null reverse path.
 	// Why would anyone send like that? Null reverse path is intended for delivery
 	// notifications, they should go to a single recipient.
-	if !c.submission && len(c.recipients) > 0 && c.mailFrom.IsZero() {
+	if !c.submission && !c.lmtp && len(c.recipients) > 0 && c.mailFrom.IsZero() {
 		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "only one recipient allowed with null reverse address")
 	}
 
@@ -1975,31 +2244,9 @@ func (c *conn) cmdRcpt(p *parser) {
 	// ../rfc/5321:3598
 	// ../rfc/5321:4045
 	// Also see ../rfc/7489:2214
-	if !c.submission && len(c.recipients) == 1 && !Localserve {
+	if !c.submission && !c.lmtp && len(c.recipients) == 1 && !Localserve {
 		// note: because of check above, mailFrom cannot be the null address.
-		var pass bool
-		d := c.mailFrom.IPDomain.Domain
-		if !d.IsZero() {
-			// todo: use this spf result for DATA.
-			spfArgs := spf.Args{
-				RemoteIP:          c.remoteIP,
-				MailFromLocalpart: c.mailFrom.Localpart,
-				MailFromDomain:    d,
-				HelloDomain:       c.hello,
-				LocalIP:           c.localIP,
-				LocalHostname:     c.hostname,
-			}
-			cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
-			spfctx, spfcancel := context.WithTimeout(cidctx, time.Minute)
-			defer spfcancel()
-			receivedSPF, _, _, _, err := spf.Verify(spfctx, c.log.Logger, c.resolver, spfArgs)
-			spfcancel()
-			if err != nil {
-				c.log.Errorx("spf verify for multiple recipients", err)
-			}
-			pass = receivedSPF.Identity == spf.ReceivedMailFrom && receivedSPF.Result == spf.StatusPass
-		}
-		if !pass {
+		if !c.mailFromSPFPassed() {
 			xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "only one recipient allowed without spf pass")
 		}
 	}
@@ -2012,15 +2259,20 @@ func (c *conn) cmdRcpt(p *parser) {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for ip")
 		}
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{Addr: fpath})
+	} else if orig := c.xsrsReverse(fpath); orig != nil {
+		c.recipients = append(c.recipients, recipient{Addr: fpath, SRS: orig})
+	} else if len(c.recipients) > 0 && c.recipients[0].SRS != nil {
+		// The bounce is passed on as a whole, not delivered to other recipients.
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "srs address must be the only recipient")
 	} else if accountName, alias, canonical, dest, err := mox.LookupAddress(fpath.Localpart, fpath.IPDomain.Domain, true, true, true); err == nil {
 		// note: a bare postmaster, without domain, is handled by LookupAddress. ../rfc/5321:735
 		if alias != nil {
-			c.recipients = append(c.recipients, recipient{fpath, nil, &rcptAlias{*alias, canonical}})
+			c.recipients = append(c.recipients, recipient{Addr: fpath, Alias: &rcptAlias{*alias, canonical}})
 		} else if dest.SMTPError != "" {
 			xsmtpServerErrorf(codes{dest.SMTPErrorCode, dest.SMTPErrorSecode}, "%s", dest.SMTPErrorMsg)
 		} else {
-			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{accountName, dest, canonical}, nil})
+			c.recipients = append(c.recipients, recipient{Addr: fpath, Account: &rcptAccount{accountName, dest, canonical}})
 		}
 
 	} else if Localserve {
@@ -2030,7 +2282,7 @@ func (c *conn) cmdRcpt(p *parser) {
 		// which is typically the mox user.
 		acc, _ := mox.Conf.Account("mox")
 		dest := acc.Destinations["mox@localhost"]
-		c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{"mox", dest, "mox@localhost"}, nil})
+		c.recipients = append(c.recipients, recipient{Addr: fpath, Account: &rcptAccount{"mox", dest, "mox@localhost"}})
 	} else if errors.Is(err, mox.ErrDomainDisabled) {
 		c.log.Info("smtp recipient for temporarily disabled domain", slog.Any("domain", fpath.IPDomain.Domain))
 		xsmtpUserErrorf(smtp.C450MailboxUnavail, smtp.SeMailbox2Disabled1, "recipient domain temporarily disabled")
@@ -2039,21 +2291,34 @@ func (c *conn) cmdRcpt(p *parser) {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for domain")
 		}
 		// We'll be delivering this email.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{Addr: fpath})
 	} else if errors.Is(err, mox.ErrAddressNotFound) {
-		if c.submission {
+		if c.submission || c.lmtp {
 			// For submission, we're transparent about which user exists. Should be fine for the typical small-scale deploy.
 			// ../rfc/5321:1071
+			// For LMTP, the upstream is trusted, and the recipient is best rejected before
+			// the message is transferred.
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "no such user")
 		}
 		// We pretend to accept. We don't want to let remote know the user does not exist
 		// until after DATA. Because then remote has committed to sending a message.
 		// note: not local for !c.submission is the signal this address is in error.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{Addr: fpath})
 	} else {
 		c.log.Errorx("looking up account for delivery", err, slog.Any("rcptto", fpath))
 		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
 	}
+	if c.greylisted(fpath) {
+		c.recipients = c.recipients[:len(c.recipients)-1]
+		xsmtpUserErrorf(smtp.C451LocalErr, smtp.SePol7DeliveryUnauth1, "greylisted, try again later")
+	}
+	if r := c.xmilterRcpt(fpath, notify); r != nil {
+		c.recipients = c.recipients[:len(c.recipients)-1]
+		xsmtpUserErrorf(r.Code, r.Secode, "%s", r.Text)
+	}
+	rcpt := &c.recipients[len(c.recipients)-1]
+	rcpt.Notify = notify
+	rcpt.ORCPT = orcpt
 	c.xbwritecodeline(smtp.C250Completed, smtp.SeAddr1Other0, "now on the list", nil)
 }
 
@@ -2097,6 +2362,10 @@ func (c *conn) cmdData(p *parser) {
 		// ../rfc/5321:1130
 		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing RCPT TO")
 	}
+	if c.bdat != nil {
+		// ../rfc/3030
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "cannot mix DATA with BDAT in a transaction")
+	}
 
 	// ../rfc/5321:2066
 	p.xend()
@@ -2116,6 +2385,13 @@ func (c *conn) cmdData(p *parser) {
 	// ../rfc/5321:1994
 	c.xwritelinef("354 see you at the bare dot")
 
+	// For LMTP, a response is needed for each recipient, also for errors. ../rfc/2033
+	nrcpt := 1
+	if c.lmtp {
+		nrcpt = len(c.recipients)
+		defer c.lmtpDataError(nrcpt)
+	}
+
 	// Mark as tracedata.
 	defer c.xtrace(mlog.LevelTracedata)()
 
@@ -2141,7 +2417,10 @@ func (c *conn) cmdData(p *parser) {
 		}
 
 		if errors.Is(err, smtp.ErrCRLF) {
-			c.xwritecodeline(smtp.C500BadSyntax, smtp.SeProto5Syntax2, fmt.Sprintf("invalid bare \\r or \\n, may be smtp smuggling (%s)", mox.ReceivedID(c.cid)), err)
+			for range nrcpt {
+				c.xbwritecodeline(smtp.C500BadSyntax, smtp.SeProto5Syntax2, fmt.Sprintf("invalid bare \\r or \\n, may be smtp smuggling (%s)", mox.ReceivedID(c.cid)), err)
+			}
+			c.xflush()
 			return
 		}
 
@@ -2151,11 +2430,153 @@ func (c *conn) cmdData(p *parser) {
 		// available and our write blocks us from reading remaining data, leading to
 		// deadlock. We have a timeout on our connection writes though, so worst case we'll
 		// abort the connection due to expiration.
-		c.xwritecodeline(smtp.C451LocalErr, smtp.SeSys3Other0, fmt.Sprintf("error copying data to file (%s)", mox.ReceivedID(c.cid)), err)
+		for range nrcpt {
+			c.xbwritecodeline(smtp.C451LocalErr, smtp.SeSys3Other0, fmt.Sprintf("error copying data to file (%s)", mox.ReceivedID(c.cid)), err)
+		}
+		c.xflush()
 		io.Copy(io.Discard, dr)
 		return
 	}
 
+	c.data(cmdctx, msgWriter, dataFile)
+}
+
+// BDAT transfers message data in one or more chunks of explicit size, without
+// dot-stuffing. We only respond after having read a chunk, also for errors, or we
+// would interpret the message data as commands. ../rfc/3030
+func (c *conn) cmdBdat(p *parser) {
+	// Without a valid chunk size, we cannot find the start of the next command, so we
+	// abort the connection.
+	t := strings.Split(p.remainder(), " ")
+	var size int64 = -1
+	if t[0] == "" && (len(t) == 2 || len(t) == 3 && strings.EqualFold(t[2], "LAST")) {
+		if v, err := strconv.ParseUint(t[1], 10, 63); err == nil {
+			size = int64(v)
+		}
+	}
+	if size < 0 {
+		c.xwritecodeline(smtp.C501BadParamSyntax, smtp.SeProto5Syntax2, "bad BDAT syntax, expected chunk size and optional LAST", nil)
+		panic(fmt.Errorf("bad bdat syntax: %w", errIO))
+	}
+	last := len(t) == 3
+
+	// After an error, we read and discard the remainder of the chunk before the error
+	// response is written. The transaction has failed, the client must not send more
+	// chunks, and any chunks it already pipelined are rejected because the
+	// transaction is reset.
+	remaining := size
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && !isClosed(err) {
+			if remaining > 0 {
+				c.xtrace(mlog.LevelTracedata)
+				io.CopyN(io.Discard, c.xbr, remaining)
+				c.xtrace(mlog.LevelTrace)
+			}
+			c.rset()
+		}
+		panic(x)
+	}()
+
+	c.xneedHello()
+	c.xcheckAuth()
+	if c.mailFrom == nil {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing MAIL FROM")
+	}
+	if len(c.recipients) == 0 {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing RCPT TO")
+	}
+
+	// Entire chunk, and delivery for the last chunk, should be done within 30 minutes,
+	// or we abort.
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	cmdctx, cmdcancel := context.WithTimeout(cidctx, 30*time.Minute)
+	defer cmdcancel()
+	// Deadline is taken into account by Read and Write.
+	c.deadline, _ = cmdctx.Deadline()
+	defer func() {
+		c.deadline = time.Time{}
+	}()
+
+	if c.bdat == nil {
+		// We read the data into a temporary file, like for DATA.
+		dataFile, err := store.CreateMessageTemp(c.log, "smtp-deliver")
+		if err != nil {
+			xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "creating temporary file for message: %s", err)
+		}
+		msgWriter := message.NewWriter(dataFile)
+		c.bdat = &bdatData{dataFile, msgWriter, &limitWriter{maxSize: c.maxMessageSize, w: msgWriter}}
+	}
+
+	// Unlike DATA, we know the size before reading, so we can reject a too large
+	// message without reading the chunk. We don't want to read & discard possibly
+	// large amounts of data, so we close the connection, like for too large DATA.
+	if c.bdat.lw.written+size > c.maxMessageSize {
+		// ../rfc/1870:136 and ../rfc/3463:382
+		ecode := smtp.SeSys3MsgLimitExceeded4
+		if c.bdat.lw.written+size < config.DefaultMaxMsgSize {
+			ecode = smtp.SeMailbox2MsgLimitExceeded3
+		}
+		c.xwritecodeline(smtp.C552MailboxFull, ecode, fmt.Sprintf("message too large (%s)", mox.ReceivedID(c.cid)), errMessageTooLarge)
+		panic(fmt.Errorf("remote sent too much BDAT: %w", errIO))
+	}
+
+	// Mark as tracedata.
+	defer c.xtrace(mlog.LevelTracedata)()
+	// The limited reader keeps track of what we still have to discard on errors.
+	lr := &io.LimitedReader{R: c.xbr, N: size}
+	_, err := io.Copy(c.bdat.lw, lr)
+	remaining = lr.N
+	c.xtrace(mlog.LevelTrace) // Restore.
+	if err != nil {
+		xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "error copying data to file: %s", err)
+	}
+
+	if !last {
+		c.xwritecodeline(smtp.C250Completed, smtp.SeOther00, fmt.Sprintf("%d octets received", size), nil)
+		return
+	}
+
+	// For LMTP, a response is needed for each recipient, also for errors. ../rfc/2033
+	if c.lmtp {
+		defer c.lmtpDataError(len(c.recipients))
+	}
+
+	// The message data is complete, a new transaction or another attempt for this
+	// transaction starts with new chunks.
+	defer c.bdatCleanup()
+	c.data(cmdctx, c.bdat.msgWriter, c.bdat.file)
+}
+
+func (c *conn) bdatCleanup() {
+	if c.bdat != nil {
+		store.CloseRemoveTempFile(c.log, c.bdat.file, "smtpserver bdat message")
+		c.bdat = nil
+	}
+}
+
+// data processes a message received with DATA or BDAT, submitting or delivering it.
+func (c *conn) data(cmdctx context.Context, msgWriter *message.Writer, dataFile *os.File) {
+	// Pass the message through the milters, which may change the header, add
+	// recipients, or quarantine or discard the message.
+	msgWriter, milterFile, mr := c.xmilterData(msgWriter, dataFile)
+	if milterFile != nil {
+		defer store.CloseRemoveTempFile(c.log, milterFile, "message with milter changes")
+		dataFile = milterFile
+	}
+	if c.milterDiscard {
+		c.log.Info("message discarded by milter")
+		c.transactionGood++
+		c.rset()
+		c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+		return
+	}
+	c.milterAddRcpts(c.log, mr.addRcpts)
+	c.milterQuarantine = mr.quarantine
+
 	// Basic sanity checks on messages before we send them out to the world. Just
 	// trying to be strict in what we do to others and liberal in what we accept.
 	if c.submission {
@@ -2227,20 +2648,25 @@ func (c *conn) cmdData(p *parser) {
 			// comment belongs to "BY" which comes immediately after "FROM".
 			recvFrom = c.hello.Domain.XName(c.msgsmtputf8)
 		}
-		iprevctx, iprevcancel := context.WithTimeout(cmdctx, time.Minute)
-		var revName string
-		var revNames []string
-		iprevStatus, revName, revNames, iprevAuthentic, err = iprev.Lookup(iprevctx, c.resolver, c.remoteIP)
-		iprevcancel()
-		if err != nil {
-			c.log.Infox("reverse-forward lookup", err, slog.Any("remoteip", c.remoteIP))
-		}
-		c.log.Debug("dns iprev check", slog.Any("addr", c.remoteIP), slog.Any("status", iprevStatus))
 		var name string
-		if revName != "" {
-			name = revName
-		} else if len(revNames) > 0 {
-			name = revNames[0]
+		// For LMTP, the connection is from the upstream, not the original sender. Any
+		// iprev result comes from the upstream.
+		if !c.lmtp {
+			iprevctx, iprevcancel := context.WithTimeout(cmdctx, time.Minute)
+			var revName string
+			var revNames []string
+			var err error
+			iprevStatus, revName, revNames, iprevAuthentic, err = iprev.Lookup(iprevctx, c.resolver, c.remoteIP)
+			iprevcancel()
+			if err != nil {
+				c.log.Infox("reverse-forward lookup", err, slog.Any("remoteip", c.remoteIP))
+			}
+			c.log.Debug("dns iprev check", slog.Any("addr", c.remoteIP), slog.Any("status", iprevStatus))
+			if revName != "" {
+				name = revName
+			} else if len(revNames) > 0 {
+				name = revNames[0]
+			}
 		}
 		name = strings.TrimSuffix(name, ".")
 		recvFrom += " ("
@@ -2260,10 +2686,14 @@ func (c *conn) cmdData(p *parser) {
 	}
 
 	// ../rfc/3848:34 ../rfc/6531:791
-	with := "SMTP"
+	proto := "SMTP"
+	if c.lmtp {
+		proto = "LMTP"
+	}
+	with := proto
 	if c.msgsmtputf8 {
-		with = "UTF8SMTP"
-	} else if c.ehlo {
+		with = "UTF8" + proto
+	} else if c.ehlo && !c.lmtp {
 		with = "ESMTP"
 	}
 	if c.tls {
@@ -2277,7 +2707,7 @@ func (c *conn) cmdData(p *parser) {
 	// Assume transaction does not succeed. If it does, we'll compensate.
 	c.transactionBad++
 
-	recvHdrFor := func(rcptTo string) string {
+	recvHdrFor := func(rcptTo string, comments ...string) string {
 		recvHdr := &message.HeaderWriter{}
 		// For additional Received-header clauses, see:
 		// https://www.iana.org/assignments/mail-parameters/mail-parameters.xhtml#table-mail-parameters-8
@@ -2292,6 +2722,7 @@ func (c *conn) cmdData(p *parser) {
 			tlsComment := mox.TLSReceivedComment(c.log, tlsConn.ConnectionState())
 			recvHdr.Add(" ", tlsComment...)
 		}
+		recvHdr.Add(" ", comments...)
 		// We leave out an empty "for" clause. This is empty for messages submitted to
 		// multiple recipients, so the message stays identical and a single smtp
 		// transaction can deliver, only transferring the data once.
@@ -2307,6 +2738,8 @@ func (c *conn) cmdData(p *parser) {
 	// internet traffic.
 	if c.submission {
 		c.submit(cmdctx, recvHdrFor, msgWriter, dataFile, part)
+	} else if len(c.recipients) == 1 && c.recipients[0].SRS != nil {
+		c.deliverSRS(cmdctx, recvHdrFor, msgWriter, dataFile)
 	} else {
 		c.deliver(cmdctx, recvHdrFor, msgWriter, iprevStatus, iprevAuthentic, dataFile)
 	}
@@ -2330,7 +2763,7 @@ func hasTLSRequiredNo(h textproto.MIMEHeader) bool {
 }
 
 // submit is used for mail from authenticated users that we will try to deliver.
-func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File, part *message.Part) {
+func (c *conn) submit(ctx context.Context, recvHdrFor func(string, ...string) string, msgWriter *message.Writer, dataFile *os.File, part *message.Part) {
 	// Similar between ../smtpserver/server.go:/submit\( and ../webmail/api.go:/MessageSubmit\( and ../webapisrv/server.go:/Send\(
 
 	var msgPrefix []byte
@@ -2523,8 +2956,16 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 			qm.NextAttempt = c.futureRelease
 			qm.FutureReleaseRequest = c.futureReleaseRequest
 		}
+		qm.DSNNotify = rcpt.Notify
+		qm.DSNOriginalRecipient = rcpt.ORCPT
+		qm.DSNRet = c.dsnRet
+		qm.DSNEnvelopeID = c.dsnEnvID
 		qm.FromID = fromID
 		qm.Extra = extra
+		if c.milterQuarantine != "" {
+			c.log.Info("holding message quarantined by milter in queue", slog.String("reason", c.milterQuarantine), slog.Any("rcptto", rcpt.Addr))
+			qm.Hold = true
+		}
 		qml[i] = qm
 	}
 
@@ -2610,7 +3051,7 @@ func (c *conn) xlocalserveError(lp smtp.Localpart) {
 
 // deliver is called for incoming messages from external, typically untrusted
 // sources. i.e. not submitted by authenticated users.
-func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, iprevStatus iprev.Status, iprevAuthentic bool, dataFile *os.File) {
+func (c *conn) deliver(ctx context.Context, recvHdrFor func(string, ...string) string, msgWriter *message.Writer, iprevStatus iprev.Status, iprevAuthentic bool, dataFile *os.File) {
 	// todo: in decision making process, if we run into (some) temporary errors, attempt to continue. if we decide to accept, all good. if we decide to reject, we'll make it a temporary reject.
 
 	var msgFrom smtp.Address
@@ -2642,6 +3083,16 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		c.requireTLS = &v
 	}
 
+	// For LMTP, we take iprev and SPF results from the upstream, if configured and
+	// present.
+	var upstream *upstreamAuth
+	if c.lmtp && c.trustedAuthServID != "" {
+		upstream = parseUpstreamAuth(c.log, headers, c.trustedAuthServID)
+		if upstream == nil {
+			c.log.Info("no authentication-results header from trusted upstream in message", slog.String("authservid", c.trustedAuthServID))
+		}
+	}
+
 	// We'll be building up an Authentication-Results header.
 	authResults := message.AuthResults{
 		Hostname: mox.Conf.Static.HostnameDomain.XName(c.msgsmtputf8),
@@ -2657,14 +3108,20 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	// Reverse IP lookup results.
 	// todo future: how useful is this?
 	// ../rfc/5321:2481
-	authResults.Methods = append(authResults.Methods, message.AuthMethod{
-		Method:  "iprev",
-		Result:  string(iprevStatus),
-		Comment: commentAuthentic(iprevAuthentic),
-		Props: []message.AuthProp{
-			message.MakeAuthProp("policy", "iprev", c.remoteIP.String(), false, ""),
-		},
-	})
+	if !c.lmtp {
+		authResults.Methods = append(authResults.Methods, message.AuthMethod{
+			Method:  "iprev",
+			Result:  string(iprevStatus),
+			Comment: commentAuthentic(iprevAuthentic),
+			Props: []message.AuthProp{
+				message.MakeAuthProp("policy", "iprev", c.remoteIP.String(), false, ""),
+			},
+		})
+	} else if upstream != nil {
+		// Both iprev and spf, as reported by the upstream.
+		authResults.Methods = append(authResults.Methods, upstream.methods...)
+		iprevStatus = upstream.iprevStatus
+	}
 
 	// SPF and DKIM verification in parallel.
 	var wg sync.WaitGroup
@@ -2673,6 +3130,8 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	wg.Add(1)
 	var dkimResults []dkim.Result
 	var dkimErr error
+	var arcResult dkim.ARCResult
+	var arcErr error
 	go func() {
 		defer func() {
 			x := recover() // Should not happen, but don't take program down if it does.
@@ -2718,6 +3177,9 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			}
 		}
 		dkimResults, dkimErr = dkim.Verify(dkimctx, c.log.Logger, resolver, c.msgsmtputf8, dkim.DefaultPolicy, dataFile, ignoreTestMode)
+
+		// ARC sets are signed with DKIM keys of the intermediaries. ../rfc/8617
+		arcResult, arcErr = dkim.ARCVerify(dkimctx, c.log.Logger, resolver, c.msgsmtputf8, dataFile)
 		dkimcancel()
 	}()
 
@@ -2736,35 +3198,53 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		LocalIP:           c.localIP,
 		LocalHostname:     c.hostname,
 	}
-	wg.Add(1)
-	go func() {
-		defer func() {
-			x := recover() // Should not happen, but don't take program down if it does.
-			if x != nil {
-				c.log.Error("spf verify panic", slog.Any("err", x))
-				debug.PrintStack()
-				metrics.PanicInc(metrics.Spfverify)
-			}
-		}()
-		defer wg.Done()
-		spfctx, spfcancel := context.WithTimeout(ctx, time.Minute)
-		defer spfcancel()
-		resolver := c.resolver
-		// For localserve, give hosted domains a chance to pass for deliveries from queue.
-		if Localserve && c.remoteIP.IsLoopback() {
-			// Lookup based on message From address is an approximation.
-			if _, ok := mox.Conf.Domain(msgFrom.Domain); ok {
-				resolver = dns.MockResolver{
-					TXT: map[string][]string{msgFrom.Domain.ASCII + ".": {"v=spf1 ip4:127.0.0.1/8 ip6:::1 ~all"}},
-				}
+	if c.lmtp {
+		// We cannot verify SPF for LMTP, the connection is from the upstream.
+		receivedSPF.Result = spf.StatusNone
+		if upstream != nil && upstream.spf.Result != "" {
+			receivedSPF = upstream.spf
+			spfDomain = upstream.spfDomain
+			switch receivedSPF.Identity {
+			case spf.ReceivedHELO:
+				spfArgs.HelloDomain = dns.IPDomain{Domain: spfDomain}
+			case spf.ReceivedMailFrom:
+				spfArgs.MailFromDomain = spfDomain
 			}
 		}
-		receivedSPF, spfDomain, spfExpl, spfAuthentic, spfErr = spf.Verify(spfctx, c.log.Logger, resolver, spfArgs)
-		spfcancel()
-		if spfErr != nil {
-			c.log.Infox("spf verify", spfErr)
+		if upstream != nil && upstream.remoteIP != nil {
+			spfArgs.RemoteIP = upstream.remoteIP
 		}
-	}()
+	} else {
+		wg.Add(1)
+		go func() {
+			defer func() {
+				x := recover() // Should not happen, but don't take program down if it does.
+				if x != nil {
+					c.log.Error("spf verify panic", slog.Any("err", x))
+					debug.PrintStack()
+					metrics.PanicInc(metrics.Spfverify)
+				}
+			}()
+			defer wg.Done()
+			spfctx, spfcancel := context.WithTimeout(ctx, time.Minute)
+			defer spfcancel()
+			resolver := c.resolver
+			// For localserve, give hosted domains a chance to pass for deliveries from queue.
+			if Localserve && c.remoteIP.IsLoopback() {
+				// Lookup based on message From address is an approximation.
+				if _, ok := mox.Conf.Domain(msgFrom.Domain); ok {
+					resolver = dns.MockResolver{
+						TXT: map[string][]string{msgFrom.Domain.ASCII + ".": {"v=spf1 ip4:127.0.0.1/8 ip6:::1 ~all"}},
+					}
+				}
+			}
+			receivedSPF, spfDomain, spfExpl, spfAuthentic, spfErr = spf.Verify(spfctx, c.log.Logger, resolver, spfArgs)
+			spfcancel()
+			if spfErr != nil {
+				c.log.Infox("spf verify", spfErr)
+			}
+		}()
+	}
 
 	// Wait for DKIM and SPF validation to finish.
 	wg.Wait()
@@ -2854,6 +3334,31 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			slog.Any("identity", identity))
 	}
 
+	// Add ARC result to Authentication-Results header. ../rfc/8617
+	if arcErr != nil {
+		c.log.Errorx("arc verify", arcErr)
+	} else if arcResult.Status != dkim.ARCStatusNone {
+		am := message.AuthMethod{
+			Method: "arc",
+			Result: string(arcResult.Status),
+			Props: []message.AuthProp{
+				message.MakeAuthProp("smtp", "remote-ip", c.remoteIP.String(), false, ""),
+			},
+		}
+		if arcResult.Status == dkim.ARCStatusPass {
+			sealer := arcResult.Sealer()
+			am.Comment = "sealed by " + sealer.XName(c.msgsmtputf8)
+			am.Props = append([]message.AuthProp{message.MakeAuthProp("header", "oldest-pass", fmt.Sprintf("%d", arcResult.OldestPass), false, "")}, am.Props...)
+		} else if arcResult.Err != nil {
+			am.Reason = arcResult.Err.Error()
+		}
+		authResults.Methods = append(authResults.Methods, am)
+		c.log.Debugx("arc verification result", arcResult.Err,
+			slog.Any("status", arcResult.Status),
+			slog.Int("sets", len(arcResult.Sets)),
+			slog.Any("sealer", arcResult.Sealer()))
+	}
+
 	// Add SPF results to Authentication-Results header. ../rfc/7208:2141
 	var spfIdentity *dns.Domain
 	var mailFromValidation = store.ValidationUnknown
@@ -2878,12 +3383,15 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	} else {
 		spfComment = "without dnssec"
 	}
-	authResults.Methods = append(authResults.Methods, message.AuthMethod{
-		Method:  "spf",
-		Result:  string(receivedSPF.Result),
-		Comment: spfComment,
-		Props:   props,
-	})
+	// For LMTP, any spf result from the upstream has already been added.
+	if !c.lmtp {
+		authResults.Methods = append(authResults.Methods, message.AuthMethod{
+			Method:  "spf",
+			Result:  string(receivedSPF.Result),
+			Comment: spfComment,
+			Props:   props,
+		})
+	}
 	switch receivedSPF.Result {
 	case spf.StatusPass:
 		c.log.Debug("spf pass", slog.Any("ip", spfArgs.RemoteIP), slog.String("mailfromdomain", spfArgs.MailFromDomain.ASCII)) // todo: log the domain that was actually verified.
@@ -2947,6 +3455,10 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		defer dmarccancel()
 		dmarcUse, dmarcResult = dmarc.Verify(dmarcctx, c.log.Logger, c.resolver, msgFrom.Domain, dkimResults, receivedSPF.Result, spfIdentity, applyRandomPercentage)
 		dmarccancel()
+		if c.lmtp {
+			// The upstream is responsible for enforcing DMARC policies.
+			dmarcUse = false
+		}
 		var comment string
 		if dmarcResult.RecordAuthentic {
 			comment = "with dnssec"
@@ -2971,8 +3483,13 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	}
 	c.log.Debug("dmarc verification", slog.Any("result", dmarcResult.Status), slog.Any("domain", msgFrom.Domain))
 
-	// Prepare for analyzing content, calculating reputation.
-	ipmasked1, ipmasked2, ipmasked3 := ipmasked(c.remoteIP)
+	// Prepare for analyzing content, calculating reputation. For LMTP, we only know
+	// the IP of the original sender from a trusted upstream, we don't want
+	// reputation of the upstream IP.
+	var ipmasked1, ipmasked2, ipmasked3 string
+	if !c.lmtp || upstream != nil && upstream.remoteIP != nil {
+		ipmasked1, ipmasked2, ipmasked3 = ipmasked(spfArgs.RemoteIP)
+	}
 	var verifiedDKIMDomains []string
 	dkimSeen := map[string]bool{}
 	for _, r := range dkimResults {
@@ -2988,6 +3505,12 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		}
 	}
 
+	// For LMTP, we didn't evaluate SPF, so don't add a Received-SPF header.
+	var receivedSPFHeader string
+	if !c.lmtp {
+		receivedSPFHeader = receivedSPF.Header()
+	}
+
 	// When we deliver, we try to remove from rejects mailbox based on message-id.
 	// We'll parse it when we need it, but it is the same for each recipient.
 	var messageID string
@@ -3004,10 +3527,15 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		secode    string
 		userError bool
 		errmsg    string
+		rcpt      recipient
 	}
 	var deliverErrors []deliverError
+
+	// Recipients that were delivered to an account and requested a DSN for success
+	// with the NOTIFY parameter. ../rfc/3461
+	var deliveredNotify []recipient
 	addError := func(rcpt recipient, code int, secode string, userError bool, errmsg string) {
-		e := deliverError{rcpt.Addr, code, secode, userError, errmsg}
+		e := deliverError{rcpt.Addr, code, secode, userError, errmsg, rcpt}
 		c.log.Info("deliver error",
 			slog.Any("rcptto", e.rcptTo),
 			slog.Int("code", code),
@@ -3027,6 +3555,8 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		}
 		return 2
 	}
+	// For LMTP, we respond for each recipient in order of the RCPT TO commands.
+	rcptOrder := slices.Clone(c.recipients)
 	sort.SliceStable(c.recipients, func(i, j int) bool {
 		return rcptScore(c.recipients[i]) < rcptScore(c.recipients[j])
 	})
@@ -3065,11 +3595,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 
 		m := store.Message{
 			Received:           time.Now(),
-			RemoteIP:           c.remoteIP.String(),
+			RemoteIP:           spfArgs.RemoteIP.String(),
 			RemoteIPMasked1:    ipmasked1,
 			RemoteIPMasked2:    ipmasked2,
 			RemoteIPMasked3:    ipmasked3,
-			EHLODomain:         c.hello.Domain.Name(),
+			EHLODomain:         spfArgs.HelloDomain.Domain.Name(),
 			MailFrom:           c.mailFrom.String(),
 			MailFromLocalpart:  c.mailFrom.Localpart,
 			MailFromDomain:     c.mailFrom.IPDomain.Domain.Name(),
@@ -3104,7 +3634,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			msgTo = envelope.To
 			msgCc = envelope.CC
 		}
-		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, dmarcUse, dmarcResult, dkimResults, iprevStatus, c.smtputf8}
+		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, dmarcUse, dmarcResult, dkimResults, arcResult, iprevStatus, c.smtputf8, c.lmtp}
 
 		r := analyze(ctx, log, c.resolver, d)
 		return &r, nil
@@ -3188,6 +3718,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			addError(rcpt, a0.code, a0.secode, a0.userError, a0.errmsg)
 			return
 		}
+		if c.milterQuarantine != "" {
+			for i := range la {
+				milterQuarantine(ctx, log, &la[i], c.milterQuarantine)
+			}
+		}
 
 		// Any DMARC result override is stored in the evaluation for outgoing DMARC
 		// aggregate reports, and added to the Authentication-Results message header.
@@ -3198,7 +3733,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		if a0.dmarcOverrideReason != "" {
 			dmarcOverrides = []string{a0.dmarcOverrideReason}
 		}
-		if dmarcResult.Record != nil && !dmarcUse {
+		if dmarcResult.Record != nil && !dmarcUse && !c.lmtp {
 			dmarcOverrides = append(dmarcOverrides, string(dmarcrpt.PolicyOverrideSampledOut))
 		}
 
@@ -3239,6 +3774,17 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		xmox += a0.headers
 
 		for i := range la {
+			// Probabilities of the reservoir filter as comment in the Received header, so
+			// they are part of the trace of the message.
+			var recvComments []string
+			if rr := la[i].reservoir; rr != nil {
+				var mode string
+				if mc := la[i].d.m.Classification; mc != nil && mc.Reservoir != nil && mc.Reservoir.Shadow {
+					mode = " shadow"
+				}
+				recvComments = append(recvComments, fmt.Sprintf("(reservoir%s: bayesian %.2f, reservoir %.2f, affective %.2f, combined %.2f)", mode, rr.BayesianProb, rr.ReservoirProb, rr.AffectiveProb, rr.CombinedProb))
+			}
+
 			// ../rfc/5321:3204
 			// Received-SPF header goes before Received. ../rfc/7208:2038
 			la[i].d.m.MsgPrefix = []byte(
@@ -3246,10 +3792,13 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 					"Delivered-To: " + la[i].d.deliverTo.XString(c.msgsmtputf8) + "\r\n" + // ../rfc/9228:274
 					"Return-Path: <" + c.mailFrom.String() + ">\r\n" + // ../rfc/5321:3300
 					rcptAuthResults.Header() +
-					receivedSPF.Header() +
-					recvHdrFor(rcpt.Addr.String()),
+					receivedSPFHeader +
+					recvHdrFor(rcpt.Addr.String(), recvComments...),
 			)
 			la[i].d.m.Size += int64(len(la[i].d.m.MsgPrefix))
+			if mc := la[i].d.m.Classification; mc != nil {
+				mc.Reason = la[i].reason
+			}
 		}
 
 		// Store DMARC evaluation for inclusion in an aggregate report. Only if there is at
@@ -3259,7 +3808,8 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		// the analysis, we will report on rejects because of DMARC, because it could be
 		// valuable feedback about forwarded or mailing list messages.
 		// ../rfc/7489:1492
-		if !mox.Conf.Static.NoOutgoingDMARCReports && dmarcResult.Record != nil && len(dmarcResult.Record.AggregateReportAddresses) > 0 && (a0.accept && !a0.d.m.IsReject || a0.reason == reasonDMARCPolicy) {
+		// Not for LMTP, where the upstream is responsible for DMARC.
+		if !c.lmtp && !mox.Conf.Static.NoOutgoingDMARCReports && dmarcResult.Record != nil && len(dmarcResult.Record.AggregateReportAddresses) > 0 && (a0.accept && !a0.d.m.IsReject || a0.reason == reasonDMARCPolicy) {
 			// Disposition holds our decision on whether to accept the message. Not what the
 			// DMARC evaluation resulted in. We can override, e.g. because of mailing lists,
 			// forwarding, or local policy.
@@ -3475,7 +4025,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				})
 			}
 
-			log.Info("incoming message rejected", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+			log.Info("incoming message rejected", a0.logAttrs(msgFrom)...)
 			metricDelivery.WithLabelValues("reject", a0.reason).Inc()
 			c.setSlow(true)
 			addError(rcpt, a0.code, a0.secode, a0.userError, a0.errmsg)
@@ -3543,6 +4093,9 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		var nerr int       // Number of non-quota errors.
 		var nfull int      // Number of failed deliveries due to over quota.
 		var ndelivered int // Number delivered to account.
+		var ndiscarded int // Number discarded by a Sieve script.
+		var njunk int      // Number delivered to account as junk.
+		var rejectReason string
 		for _, a := range la {
 			// Don't deliver to recipient that was explicitly present in SMTP transaction, or
 			// is sending the message to an alias they are member of.
@@ -3550,9 +4103,31 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				continue
 			}
 
+			// Evaluate the active Sieve script of the account, if any. A reject results in
+			// an error for this recipient if no other account accepts the message.
+			sr := a.d.acc.SieveEvaluate(log, a.d.m, dataFile)
+			if sr != nil && sr.Rejected {
+				rejectReason = sieveRejectReason(sr.RejectReason)
+				log.Info("incoming message rejected by sieve script", slog.String("rejectreason", rejectReason))
+				metricDelivery.WithLabelValues("sievereject", a0.reason).Inc()
+				continue
+			}
+
 			var delivered bool
+			mailbox := a.mailbox
 			a.d.acc.WithWLock(func() {
-				if err := a.d.acc.DeliverMailbox(log, a.mailbox, a.d.m, dataFile); err != nil {
+				var err error
+				mailboxes := []string{a.mailbox}
+				if sr == nil {
+					err = a.d.acc.DeliverMailbox(log, a.mailbox, a.d.m, dataFile)
+				} else {
+					mailboxes, err = a.d.acc.DeliverSieve(log, sr, a.mailbox, a.d.m, dataFile)
+					if err != nil && len(mailboxes) > 0 {
+						log.Errorx("delivering copies for sieve script, message was delivered", err, slog.Any("mailboxes", mailboxes))
+						err = nil
+					}
+				}
+				if err != nil {
 					log.Errorx("delivering", err)
 					metricDelivery.WithLabelValues("delivererror", a0.reason).Inc()
 					if errors.Is(err, store.ErrOverQuota) {
@@ -3563,10 +4138,20 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 					}
 					return
 				}
+				if len(mailboxes) == 0 {
+					ndiscarded++
+					metricDelivery.WithLabelValues("sievediscard", a0.reason).Inc()
+					log.Info("incoming message discarded by sieve script", a0.logAttrs(msgFrom)...)
+					return
+				}
+				mailbox = mailboxes[0]
 				delivered = true
 				ndelivered++
+				if a.d.m.Junk {
+					njunk++
+				}
 				metricDelivery.WithLabelValues("delivered", a0.reason).Inc()
-				log.Info("incoming message delivered", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+				log.Info("incoming message delivered", a0.logAttrs(msgFrom)...)
 
 				conf, _ := a.d.acc.Conf()
 				if conf.RejectsMailbox != "" && a.d.m.MessageID != "" {
@@ -3583,7 +4168,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				if err != nil {
 					log.Errorx("loading parsed part for evaluating webhook", err)
 				} else {
-					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, a.mailbox)
+					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, mailbox)
 					log.Check(err, "queueing webhook for incoming delivery")
 				}
 			} else if nerr > 0 && ndelivered == 0 {
@@ -3591,6 +4176,28 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				// quota-related errors, we keep trying for an account to deliver to.
 				break
 			}
+
+			// Redirect and vacation actions of a Sieve script, for delivered and discarded
+			// messages.
+			if sr != nil && (delivered || len(sr.FileInto) == 0 && !sr.Keep) {
+				if len(sr.Redirects) > 0 && (a.d.m.Junk || !a.accept) {
+					// Don't turn into a relay for spam.
+					log.Info("not redirecting junk message for sieve script", slog.Any("redirects", sr.Redirects))
+				} else if len(sr.Redirects) > 0 {
+					c.sieveRedirect(ctx, log, a.d, headers, rcptAuthResults, msgWriter.Has8bit, messageID, headers.Get("Subject"), sr.Redirects)
+				}
+				if sr.Vacation != nil {
+					c.sieveVacation(ctx, log, a.d, headers, envelope, sr.Vacation)
+				}
+			}
+			// Vacation settings of the account, if the Sieve script didn't respond already.
+			if delivered && (sr == nil || sr.Vacation == nil) {
+				c.accountVacation(ctx, log, a.d, headers, envelope)
+			}
+		}
+		if ndelivered == 0 && ndiscarded == 0 && nerr == 0 && nfull == 0 && rejectReason != "" {
+			addError(rcpt, smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, true, rejectReason)
+			return
 		}
 		if ndelivered == 0 && (nerr > 0 || nfull > 0) {
 			if nerr == 0 {
@@ -3599,6 +4206,13 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
 			}
 		}
+		if ndelivered > 0 && slices.Contains(rcpt.Notify, "SUCCESS") {
+			if njunk == ndelivered {
+				log.Info("not sending dsn for successful delivery of junk message")
+			} else {
+				deliveredNotify = append(deliveredNotify, rcpt)
+			}
+		}
 	}
 
 	// For each recipient, do final spam analysis and delivery.
@@ -3606,6 +4220,27 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		processRecipient(rcpt)
 	}
 
+	// For LMTP, we don't generate DSNs, the upstream does so based on the response
+	// for each recipient. ../rfc/2033
+	if c.lmtp {
+		c.transactionGood++
+		c.transactionBad--
+		for _, rcpt := range rcptOrder {
+			i := slices.IndexFunc(deliverErrors, func(e deliverError) bool {
+				return e.rcptTo.Equal(rcpt.Addr)
+			})
+			if i < 0 {
+				c.xbwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, fmt.Sprintf("delivered to %s", rcpt.Addr.XString(c.smtputf8)), nil)
+			} else {
+				e := deliverErrors[i]
+				c.xbwritecodeline(e.code, e.secode, fmt.Sprintf("%s (%s)", e.errmsg, mox.ReceivedID(c.cid)), nil)
+			}
+		}
+		c.rset()
+		c.xflush()
+		return
+	}
+
 	// If all recipients failed to deliver, return an error.
 	if len(c.recipients) == len(deliverErrors) {
 		same := true
@@ -3641,7 +4276,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		lines = append(lines, "multiple errors")
 		xsmtpErrorf(code, secode, !serverError, "%s", strings.Join(lines, "\n"))
 	}
-	// Generate one DSN for all failed recipients.
+	// Generate one DSN for all failed recipients, except those that requested no
+	// DSN for failures with the NOTIFY parameter. ../rfc/3461
+	deliverErrors = slices.DeleteFunc(deliverErrors, func(e deliverError) bool {
+		return len(e.rcpt.Notify) > 0 && !slices.Contains(e.rcpt.Notify, "FAILURE")
+	})
 	if len(deliverErrors) > 0 {
 		now := time.Now()
 		dsnMsg := dsn.Message{
@@ -3653,9 +4292,10 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			References: messageID,
 
 			// Per-message details.
-			ReportingMTA:    mox.Conf.Static.HostnameDomain.ASCII,
-			ReceivedFromMTA: smtp.Ehlo{Name: c.hello, ConnIP: c.remoteIP},
-			ArrivalDate:     now,
+			OriginalEnvelopeID: c.dsnEnvID,
+			ReportingMTA:       mox.Conf.Static.HostnameDomain.ASCII,
+			ReceivedFromMTA:    smtp.Ehlo{Name: c.hello, ConnIP: c.remoteIP},
+			ArrivalDate:        now,
 		}
 
 		if len(deliverErrors) > 1 {
@@ -3669,14 +4309,83 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			}
 			dsnMsg.TextBody += fmt.Sprintf("%s delivery failure to:\n\n\t%s\n\nError:\n\n\t%s\n\n", kind, e.errmsg, e.rcptTo.XString(false))
 			rcpt := dsn.Recipient{
-				FinalRecipient:  e.rcptTo,
-				Action:          dsn.Failed,
-				Status:          fmt.Sprintf("%d.%s", e.code/100, e.secode),
-				LastAttemptDate: now,
+				FinalRecipient:    e.rcptTo,
+				OriginalRecipient: dsn.ParseORCPT(e.rcpt.ORCPT),
+				Action:            dsn.Failed,
+				Status:            fmt.Sprintf("%d.%s", e.code/100, e.secode),
+				LastAttemptDate:   now,
 			}
 			dsnMsg.Recipients = append(dsnMsg.Recipients, rcpt)
 		}
 
+		// With RET=FULL, the whole message is included, but not with REQUIRETLS because
+		// the DSN may be delivered without TLS. ../rfc/8689:379
+		if c.dsnRet == "FULL" && (c.requireTLS == nil || !*c.requireTLS) {
+			buf, err := io.ReadAll(&moxio.AtReader{R: dataFile})
+			if err != nil {
+				c.log.Errorx("reading incoming message for dsn, continuing dsn without message", err)
+			}
+			dsnMsg.Original = buf
+			dsnMsg.OriginalFull = true
+		} else {
+			header, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: dataFile}))
+			if err != nil {
+				c.log.Errorx("reading headers of incoming message for dsn, continuing dsn without headers", err)
+			}
+			dsnMsg.Original = header
+		}
+
+		if Localserve {
+			c.log.Error("not queueing dsn for incoming delivery due to localserve")
+		} else if err := queueDSN(context.TODO(), c.log, c, *c.mailFrom, dsnMsg, c.requireTLS != nil && *c.requireTLS); err != nil {
+			metricServerErrors.WithLabelValues("queuedsn").Inc()
+			c.log.Errorx("queuing DSN for incoming delivery, no DSN sent", err)
+		}
+	}
+
+	// Generate one DSN for all recipients delivered to an account that requested a
+	// DSN for success. We are the final destination, so the action is "delivered".
+	// The message is not returned in full for successful deliveries. ../rfc/3461
+	// Only sent when the MAIL FROM domain is verified, with an SPF pass or by being
+	// aligned with a DMARC-validated message From domain. Otherwise the DSN could be
+	// sent to a forged sender, as backscatter.
+	var mailFromVerified bool
+	if len(deliveredNotify) > 0 && !c.mailFrom.IsZero() {
+		msgFromValidated := msgFromValidation == store.ValidationStrict || msgFromValidation == store.ValidationDMARC || msgFromValidation == store.ValidationRelaxed
+		mailFromAligned := msgFromValidated && publicsuffix.Lookup(ctx, c.log.Logger, c.mailFrom.IPDomain.Domain) == publicsuffix.Lookup(ctx, c.log.Logger, msgFrom.Domain)
+		mailFromVerified = mailFromValidation == store.ValidationPass || mailFromAligned
+		if !mailFromVerified {
+			c.log.Info("not sending dsn for successful delivery, mail from domain not verified", slog.Any("mailfrom", *c.mailFrom), slog.Any("mailfromvalidation", mailFromValidation), slog.Any("msgfromvalidation", msgFromValidation))
+		}
+	}
+	if len(deliveredNotify) > 0 && mailFromVerified {
+		now := time.Now()
+		dsnMsg := dsn.Message{
+			SMTPUTF8:   c.msgsmtputf8,
+			From:       smtp.Path{Localpart: "postmaster", IPDomain: deliveredNotify[0].Addr.IPDomain},
+			To:         *c.mailFrom,
+			Subject:    "mail delivered",
+			MessageID:  mox.MessageIDGen(false),
+			References: messageID,
+
+			// Per-message details.
+			OriginalEnvelopeID: c.dsnEnvID,
+			ReportingMTA:       mox.Conf.Static.HostnameDomain.ASCII,
+			ReceivedFromMTA:    smtp.Ehlo{Name: c.hello, ConnIP: c.remoteIP},
+			ArrivalDate:        now,
+		}
+		dsnMsg.TextBody = "Your email has been delivered to:\n\n"
+		for _, rcpt := range deliveredNotify {
+			dsnMsg.TextBody += fmt.Sprintf("\t%s\n", rcpt.Addr.XString(false))
+			dsnMsg.Recipients = append(dsnMsg.Recipients, dsn.Recipient{
+				FinalRecipient:    rcpt.Addr,
+				OriginalRecipient: dsn.ParseORCPT(rcpt.ORCPT),
+				Action:            dsn.Delivered,
+				Status:            "2.0.0",
+				LastAttemptDate:   now,
+			})
+		}
+
 		header, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: dataFile}))
 		if err != nil {
 			c.log.Errorx("reading headers of incoming message for dsn, continuing dsn without headers", err)
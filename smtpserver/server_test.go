@@ -8,17 +8,21 @@ import (
 	"context"
 	"crypto/ed25519"
 	cryptorand "crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/big"
 	"mime/quotedprintable"
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -30,8 +34,10 @@ import (
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/greylist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/smtp"
@@ -101,6 +107,8 @@ type testserver struct {
 	tlsmode      smtpclient.TLSMode
 	tlspkix      bool
 	xops         webops.XOps
+	lmtp         bool
+	authServID   string // For lmtp, trusted authserv-id.
 }
 
 const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spaces.
@@ -152,6 +160,8 @@ func newTestServer(t *testing.T, configPath string, resolver dns.Resolver) *test
 	tcheck(t, err, "dmarcdb init")
 	err = tlsrptdb.Init()
 	tcheck(t, err, "tlsrptdb init")
+	err = greylist.Init()
+	tcheck(t, err, "greylist init")
 	err = store.Init(ctxbg)
 	tcheck(t, err, "store init")
 
@@ -177,6 +187,8 @@ func (ts *testserver) close() {
 	tcheck(ts.t, err, "dmarcdb close")
 	err = tlsrptdb.Close()
 	tcheck(ts.t, err, "tlsrptdb close")
+	err = greylist.Close()
+	tcheck(ts.t, err, "greylist close")
 	ts.comm.Unregister()
 	queue.Shutdown()
 	err = ts.acc.Close()
@@ -257,7 +269,7 @@ func (ts *testserver) runRaw(fn func(clientConn net.Conn)) {
 	defer func() { <-serverdone }()
 
 	go func() {
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, ts.serverConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, ts.requiretls, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, ts.serverConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, ts.requiretls, ts.dnsbls, 0, ts.lmtp, ts.authServID)
 		close(serverdone)
 	}()
 
@@ -476,7 +488,7 @@ func TestSubmission(t *testing.T) {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{fakeCert(ts.t, false)},
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, false, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, false, ts.dnsbls, 0, false, "")
 		close(serverdone)
 	}()
 
@@ -502,6 +514,64 @@ func TestSubmission(t *testing.T) {
 	}
 }
 
+// Test submission with OAUTHBEARER and XOAUTH2, with tokens from an OIDC identity provider.
+func TestSubmissionOAuth(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+
+	issuer := oidc.NewMockIssuer()
+	defer issuer.Close()
+
+	ts.submission = true
+
+	testAuth := func(client sasl.Client, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.auth = func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error) {
+			name, _ := client.Info()
+			if !slices.Contains(mechanisms, name) {
+				return nil, fmt.Errorf("mechanism %s not announced", name)
+			}
+			return client, nil
+		}
+		ts.runx(func(err error, client *smtpclient.Client) {
+			if err == nil {
+				err = client.Deliver(ctxbg, "mjl@mox.example", "remote@example.org", int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expErr == nil && err != nil || expErr != nil && (err == nil || !errors.As(err, &cerr) || cerr.Code != expErr.Code || cerr.Secode != expErr.Secode) {
+				t.Fatalf("got err:\n%#v (%q)\nexpected:\n%#v", err, err, expErr)
+			}
+		})
+	}
+
+	token := issuer.Token(map[string]any{"aud": "mail", "email": "mjl@mox.example", "email_verified": true})
+
+	// Not announced without config.
+	testAuth(sasl.NewClientOAUTHBEARER("", token), &smtpclient.Error{})
+
+	mox.Conf.Static.OIDC = &config.OIDC{
+		Issuer:       issuer.URL,
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		Audience:     []string{"mail"},
+		Claim:        "email",
+	}
+	defer func() { mox.Conf.Static.OIDC = nil }()
+
+	badCreds := &smtpclient.Error{Code: smtp.C535AuthBadCreds, Secode: smtp.SePol7AuthBadCreds8}
+	badToken := issuer.Token(map[string]any{"aud": "other", "email": "mjl@mox.example", "email_verified": true})
+	unknownToken := issuer.Token(map[string]any{"aud": "mail", "email": "unknown@mox.example", "email_verified": true})
+	disabledToken := issuer.Token(map[string]any{"aud": "mail", "email": "disabled@mox.example", "email_verified": true})
+
+	testAuth(sasl.NewClientOAUTHBEARER("", token), nil)
+	testAuth(sasl.NewClientOAUTHBEARER("mjl@mox.example", token), nil)
+	testAuth(sasl.NewClientXOAUTH2("mjl@mox.example", token), nil)
+	testAuth(sasl.NewClientOAUTHBEARER("", badToken), badCreds)
+	testAuth(sasl.NewClientXOAUTH2("mjl@mox.example", badToken), badCreds)
+	testAuth(sasl.NewClientOAUTHBEARER("", unknownToken), badCreds)
+	testAuth(sasl.NewClientOAUTHBEARER("other@mox.example", token), badCreds)
+	testAuth(sasl.NewClientOAUTHBEARER("", disabledToken), &smtpclient.Error{Code: smtp.C525AccountDisabled, Secode: smtp.SePol7AccountDisabled13})
+}
+
 func TestDomainDisabled(t *testing.T) {
 	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
 	defer ts.close()
@@ -802,6 +872,387 @@ func TestSpam(t *testing.T) {
 	})
 }
 
+// Test that content analysis with a reservoir filter adds its scores to the
+// trace header, and stores the classification breakdown.
+func TestReservoirFilter(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/reservoir/mox.conf"), resolver)
+	defer ts.close()
+
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "remote@example.org"
+		rcptTo := "mjl@mox.example"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	ts.checkCount("Inbox", 1)
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get delivered message")
+	if !strings.Contains(string(m.MsgPrefix), "reservoir: bayesian ") {
+		t.Fatalf("missing reservoir scores in message header, prefix %q", m.MsgPrefix)
+	}
+	// Combined probability is in the trace, as comment in the Received header.
+	received := func(m store.Message) string {
+		t.Helper()
+		prefix := strings.NewReplacer("\r\n\t", " ", "\r\n ", " ").Replace(string(m.MsgPrefix))
+		for _, line := range strings.Split(prefix, "\r\n") {
+			if strings.HasPrefix(line, "Received: ") {
+				return line
+			}
+		}
+		t.Fatalf("missing received header, prefix %q", m.MsgPrefix)
+		return ""
+	}
+	if s := received(m); !regexp.MustCompile(`\(reservoir: bayesian [0-9.]+, reservoir [0-9.]+, affective [0-9.]+, combined [0-9.]+\)`).MatchString(s) {
+		t.Fatalf("missing reservoir probabilities in received header %q", s)
+	}
+
+	// The classification breakdown is stored with the message.
+	c := m.Classification
+	if c == nil || c.Reservoir == nil {
+		t.Fatalf("missing classification with reservoir, got %#v", c)
+	}
+	tcompare(t, c.Reason, "no-bad-signals")
+	tcompare(t, c.Junk, false)
+	tcompare(t, c.Probability, c.Reservoir.Combined)
+	tcompare(t, c.Reservoir.Shadow, false)
+
+	// In shadow mode, the decision is made with the Bayesian probability only.
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "remote@example.org"
+		rcptTo := "shadow@mox.example"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+
+	acc, err := store.OpenAccount(pkglog, "shadow", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		acc.Close()
+		acc.WaitClosed()
+	}()
+	m, err = bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get delivered message")
+	if !strings.Contains(string(m.MsgPrefix), "reservoir (shadow): bayesian ") {
+		t.Fatalf("missing reservoir shadow scores in message header, prefix %q", m.MsgPrefix)
+	}
+	if s := received(m); !strings.Contains(s, "(reservoir shadow: bayesian ") {
+		t.Fatalf("missing reservoir shadow probabilities in received header %q", s)
+	}
+	c = m.Classification
+	if c == nil || c.Reservoir == nil {
+		t.Fatalf("missing classification with reservoir, got %#v", c)
+	}
+	tcompare(t, c.Probability, c.Bayesian)
+	tcompare(t, c.Reservoir.Shadow, true)
+}
+
+// Test delivery with a Sieve script.
+func TestSieve(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	const script = `require ["fileinto", "reject", "vacation", "copy"];
+if header :contains "subject" "reject" { reject "not wanted"; stop; }
+if header :contains "subject" "lists" { fileinto "Lists"; stop; }
+if header :contains "subject" "redirect" { redirect :copy "other@example.net"; stop; }
+if header :contains "subject" "spam" { fileinto "Junk"; redirect "other@example.net"; stop; }
+if header :contains "subject" "discard" { discard; stop; }
+vacation :days 1 "I'm away.";
+`
+	_, err := ts.acc.SieveScriptSave(ctxbg, "main", script)
+	tcheck(t, err, "save sieve script")
+	err = ts.acc.SieveScriptActivate(ctxbg, "main")
+	tcheck(t, err, "activate sieve script")
+
+	deliver := func(subject string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			msg := strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: "+subject)
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+	checkQueue := func(exp ...string) {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+		tcheck(t, err, "list queue")
+		var l []string
+		for _, qm := range msgs {
+			l = append(l, qm.Sender().String()+" "+qm.Recipient().String())
+		}
+		tcompare(t, l, exp)
+	}
+
+	deliver("please reject", &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+
+	deliver("lists", nil)
+	ts.checkCount("Lists", 1)
+
+	deliver("discard", nil)
+	deliver("redirect", nil)
+	ts.checkCount("Inbox", 1)
+	checkQueue("mjl@mox.example other@example.net")
+
+	// Redirected message has an ARC set with our authentication results.
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	if s := string(msgs[0].MsgPrefix); !strings.HasPrefix(s, "ARC-Seal: i=1; cv=none;") || !strings.Contains(s, "ARC-Authentication-Results: i=1; mox.example;") {
+		t.Fatalf("missing arc set in redirected message:\n%s", s)
+	}
+
+	// Message that was delivered to the recipient before is not redirected again.
+	ts.run(func(client *smtpclient.Client) {
+		msg := "Delivered-To: mjl@mox.example\r\n" + strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: redirect")
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	checkQueue("mjl@mox.example other@example.net")
+	ts.checkCount("Inbox", 2)
+
+	// Junk messages are not redirected.
+	deliver("spam", nil)
+	ts.checkCount("Junk", 1)
+	checkQueue("mjl@mox.example other@example.net")
+
+	// Redirects count towards the outgoing limits of the account.
+	acc := mox.Conf.Dynamic.Accounts[ts.acc.Name]
+	acc.MaxOutgoingMessagesPerDay = 1
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+	deliver("redirect again", nil)
+	ts.checkCount("Inbox", 3)
+	checkQueue("mjl@mox.example other@example.net")
+	acc.MaxOutgoingMessagesPerDay = 0
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+
+	// Vacation response is sent once.
+	deliver("hello", nil)
+	deliver("hello again", nil)
+	ts.checkCount("Inbox", 5)
+	checkQueue("mjl@mox.example other@example.net", " remote@example.org")
+}
+
+// Test DMARC policy is not applied for a message with a valid ARC chain sealed by
+// a trusted sealer.
+func TestARC(t *testing.T) {
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	tcheck(t, err, "generate key")
+	record := dkim.Record{Version: "DKIM1", Key: "rsa", PublicKey: key.Public()}
+	txt, err := record.Record()
+	tcheck(t, err, "dkim record")
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"_dmarc.example.org.":             {"v=DMARC1;p=reject"},
+			"arc._domainkey.forward.example.": {txt},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	sel := dkim.Selector{
+		Hash:       "sha256",
+		PrivateKey: key,
+		Headers:    []string{"From", "To", "Subject", "Message-Id"},
+		Domain:     dns.Domain{ASCII: "arc"},
+	}
+	// Message sealed by forward.example, with authResults recorded by the sealer.
+	sealed := func(authResults string) string {
+		t.Helper()
+		arcHeaders, err := dkim.ARCSeal(ctxbg, pkglog.Logger, dns.Domain{ASCII: "forward.example"}, []dkim.Selector{sel}, false, authResults, dkim.ARCResult{Status: dkim.ARCStatusNone}, strings.NewReader(deliverMessage))
+		tcheck(t, err, "arc seal")
+		return arcHeaders + deliverMessage
+	}
+
+	deliver := func(msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+	dmarcReject := &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7MultiAuthFails26}
+
+	// Sealer not trusted, DMARC policy is applied.
+	deliver(sealed("forward.example; spf=pass smtp.mailfrom=example.org"), dmarcReject)
+
+	mox.Conf.Static.TrustedARCSealerDomains = []dns.Domain{{ASCII: "forward.example"}}
+	defer func() {
+		mox.Conf.Static.TrustedARCSealerDomains = nil
+	}()
+
+	// Trusted sealer recorded an aligned pass.
+	deliver(sealed("forward.example; spf=pass smtp.mailfrom=example.org"), nil)
+	deliver(sealed("forward.example; dkim=pass header.d=sub.example.org"), nil)
+	deliver(sealed("forward.example; dmarc=pass header.from=example.org"), nil)
+	ts.checkCount("Inbox", 3)
+
+	// Trusted sealer recorded a failure, or a pass not aligned with the message From
+	// domain. DMARC policy is applied.
+	deliver(sealed("forward.example; dmarc=fail header.from=example.org; spf=fail smtp.mailfrom=example.org"), dmarcReject)
+	deliver(sealed("forward.example; spf=pass smtp.mailfrom=spammer.example; dkim=pass header.d=spammer.example"), dmarcReject)
+	deliver(sealed("forward.example; dmarc=pass header.from=spammer.example"), dmarcReject)
+	ts.checkCount("Inbox", 3)
+}
+
+// Test automatic responses for the vacation settings of an account.
+func TestVacation(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func(msg string) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			tcheck(t, err, "deliver")
+		})
+	}
+	checkQueue := func(n int) {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+		tcheck(t, err, "list queue")
+		tcompare(t, len(msgs), n)
+	}
+
+	// Not enabled yet.
+	deliver(deliverMessage)
+	checkQueue(0)
+
+	// Vacation that has ended.
+	now := time.Now()
+	v := store.Vacation{Enabled: true, Subject: "Away", Text: "I'm away.", HTML: "<p>I'm away.</p>", Days: 1, End: now.Add(-time.Hour)}
+	err := ts.acc.VacationSave(ctxbg, v)
+	tcheck(t, err, "save vacation")
+	deliver(deliverMessage)
+	checkQueue(0)
+
+	v.End = now.Add(time.Hour)
+	err = ts.acc.VacationSave(ctxbg, v)
+	tcheck(t, err, "save vacation")
+
+	// No response to mailing lists.
+	deliver("List-Id: <list.example.org>\r\n" + deliverMessage)
+	checkQueue(0)
+
+	// Response is sent once.
+	deliver(deliverMessage)
+	deliver(deliverMessage)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().String()+" "+msgs[0].Recipient().String(), " remote@example.org")
+	tcompare(t, msgs[0].Subject, "Away")
+
+	mr, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open queued message")
+	defer mr.Close()
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read queued message")
+	s := string(buf)
+	if !strings.Contains(s, "Auto-Submitted: auto-replied\r\n") || !strings.Contains(s, "Content-Type: multipart/alternative;") || !strings.Contains(s, "DKIM-Signature: ") {
+		t.Fatalf("unexpected vacation response:\n%s", s)
+	}
+}
+
+// Test rewriting the sender with SRS for Sieve redirects, and passing bounces to
+// SRS addresses on to the original sender.
+func TestSRS(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	mox.Conf.Static.SRS = &config.SRS{SecretsParsed: [][]byte{[]byte("0123456789abcdef")}, MaxAge: 21 * 24 * time.Hour}
+	defer func() {
+		mox.Conf.Static.SRS = nil
+	}()
+
+	_, err := ts.acc.SieveScriptSave(ctxbg, "main", `redirect "other@example.net";`)
+	tcheck(t, err, "save sieve script")
+	err = ts.acc.SieveScriptActivate(ctxbg, "main")
+	tcheck(t, err, "activate sieve script")
+
+	deliver := func(mailFrom, rcptTo string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	deliver("remote@example.org", "mjl@mox.example", nil)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	sender := msgs[0].Sender()
+	if !strings.HasPrefix(string(sender.Localpart), "SRS0=") || sender.IPDomain.Domain.ASCII != "mox.example" {
+		t.Fatalf("sender of redirected message is %s, expected srs address", sender)
+	}
+
+	// Only bounces are accepted for SRS addresses.
+	deliver("remote@example.org", sender.String(), &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+
+	// Invalid hash.
+	bad := sender
+	bad.Localpart = smtp.Localpart("SRS0=AAAAAA" + string(sender.Localpart)[len("SRS0=AAAAAA"):])
+	deliver("", bad.String(), &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+
+	// An SRS address must be the only recipient, other recipients are refused.
+	ts.run(func(client *smtpclient.Client) {
+		rcptTo := []string{sender.String(), "mjl@mox.example"}
+		rcptResps, err := client.DeliverMultiple(ctxbg, "", rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+		tcompare(t, len(rcptResps), 2)
+		tcompare(t, rcptResps[1].Code, smtp.C452StorageFull)
+	})
+
+	// Bounce was queued for the original sender.
+	msgs, err = queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 2)
+	tcompare(t, msgs[1].Sender().String()+" "+msgs[1].Recipient().String(), " remote@example.org")
+}
+
 // Test accept/reject with forwarded messages, DMARC ignored, no IP/EHLO/MAIL
 // FROM-based reputation.
 func TestForward(t *testing.T) {
@@ -1412,6 +1863,91 @@ func TestRatelimitDelivery(t *testing.T) {
 	})
 }
 
+func TestGreylist(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+			"example.net.": {"127.0.0.10"},
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"}, // For multiple recipients.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	mox.Conf.Static.Greylist = &config.Greylist{Delay: time.Hour, RetryWindow: 24 * time.Hour, Expiration: 35 * 24 * time.Hour}
+	defer func() {
+		mox.Conf.Static.Greylist = nil
+	}()
+
+	deliver := func(mailFrom string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, mailFrom, "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+	greylisted := &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SePol7DeliveryUnauth1}
+
+	// First attempt and retry before the delay are deferred.
+	deliver("remote@example.org", greylisted)
+	deliver("remote@example.org", greylisted)
+	ts.checkCount("Inbox", 0)
+
+	// Retry after the delay is accepted.
+	mox.Conf.Static.Greylist.Delay = 0
+	deliver("remote@example.org", nil)
+	ts.checkCount("Inbox", 1)
+
+	// Allowed networks are not greylisted.
+	_, ipnet, err := net.ParseCIDR("127.0.0.0/8")
+	tcheck(t, err, "parse cidr")
+	mox.Conf.Static.Greylist.Delay = time.Hour
+	mox.Conf.Static.Greylist.AllowedNets = []*net.IPNet{ipnet}
+	deliver("other@example.org", nil)
+	ts.checkCount("Inbox", 2)
+	mox.Conf.Static.Greylist.AllowedNets = nil
+
+	// In a single transaction, the recipient with a passed triplet is accepted, the
+	// new recipient is deferred at RCPT TO, and no DSN is queued for it.
+	ts.run(func(client *smtpclient.Client) {
+		rcptTo := []string{"mjl@mox.example", "o@mox.example"}
+		rcptResps, err := client.DeliverMultiple(ctxbg, "remote@example.org", rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+		tcompare(t, len(rcptResps), 2)
+		tcompare(t, rcptResps[0].Code, smtp.C250Completed)
+		tcompare(t, rcptResps[1].Code, smtp.C451LocalErr)
+		tcompare(t, rcptResps[1].Secode, smtp.SePol7DeliveryUnauth1)
+	})
+	ts.checkCount("Inbox", 3)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 0)
+
+	// Remote networks we received non-junk messages from are not greylisted.
+	_, err = bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).UpdateFields(map[string]any{"Notjunk": true})
+	tcheck(t, err, "mark messages as notjunk")
+	tretrain(t, ts.acc)
+	deliver("new@example.net", nil)
+	ts.checkCount("Inbox", 4)
+
+	// Senders with SPF pass from which we received non-junk DMARC-aligned messages
+	// are not greylisted, also from a new network.
+	_, err = bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).UpdateFields(map[string]any{"RemoteIPMasked2": "10.0.0.0"})
+	tcheck(t, err, "change remote network of messages")
+	deliver("other@example.org", nil)
+	ts.checkCount("Inbox", 5)
+
+	// Without SPF pass, the sender is not known.
+	deliver("other@example.net", greylisted)
+	ts.checkCount("Inbox", 5)
+}
+
 func TestNonSMTP(t *testing.T) {
 	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
 	defer ts.close()
@@ -1426,7 +1962,7 @@ func TestNonSMTP(t *testing.T) {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{fakeCert(ts.t, false)},
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, false, false, 100<<20, false, false, false, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, false, false, 100<<20, false, false, false, ts.dnsbls, 0, false, "")
 		close(serverdone)
 	}()
 
@@ -1936,6 +2472,314 @@ func TestFutureRelease(t *testing.T) {
 	test(" HOLDFOR=1 HOLDUNTIL="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "501")                        // Duplicate.
 }
 
+// Test parameters of DSN extension, and that they are stored with the queued message.
+func TestDSNParams(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	ts.tlsmode = smtpclient.TLSSkip
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	defer ts.close()
+
+	ts.auth = func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error) {
+		return sasl.NewClientPlain(ts.user, ts.pass), nil
+	}
+
+	test := func(mailMore, rcptMore, expMailPrefix, expRcptPrefix string, expMsg queue.Msg) {
+		t.Helper()
+
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+
+			ourHostname := mox.Conf.Static.HostnameDomain
+			remoteHostname := dns.Domain{ASCII: "mox.example"}
+			opts := smtpclient.Opts{Auth: ts.auth}
+			log := pkglog.WithCid(ts.cid - 1)
+			client, err := smtpclient.New(ctxbg, log.Logger, conn, ts.tlsmode, false, ourHostname, remoteHostname, opts)
+			tcheck(t, err, "smtpclient")
+			defer conn.Close()
+			if !client.SupportsDSN() {
+				t.Fatalf("dsn extension not announced")
+			}
+
+			write := func(s string) {
+				_, err := conn.Write([]byte(s))
+				tcheck(t, err, "write")
+			}
+
+			readPrefixLine := func(prefix string) string {
+				t.Helper()
+				buf := make([]byte, 512)
+				n, err := conn.Read(buf)
+				tcheck(t, err, "read")
+				s := strings.TrimRight(string(buf[:n]), "\r\n")
+				if !strings.HasPrefix(s, prefix) {
+					t.Fatalf("got smtp response %q, expected line with prefix %q", s, prefix)
+				}
+				return s
+			}
+
+			write(fmt.Sprintf("MAIL FROM:<mjl@mox.example>%s\r\n", mailMore))
+			readPrefixLine(expMailPrefix)
+			if expMailPrefix != "2" {
+				return
+			}
+			write(fmt.Sprintf("RCPT TO:<remote@example.org>%s\r\n", rcptMore))
+			readPrefixLine(expRcptPrefix)
+			if expRcptPrefix != "2" {
+				return
+			}
+
+			write("DATA\r\n")
+			readPrefixLine("3")
+			write("From: <mjl@mox.example>\r\n\r\nbody\r\n\r\n.\r\n")
+			readPrefixLine("2")
+
+			msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: false})
+			tcheck(t, err, "list queue")
+			m := msgs[0]
+			tcompare(t, m.DSNNotify, expMsg.DSNNotify)
+			tcompare(t, m.DSNOriginalRecipient, expMsg.DSNOriginalRecipient)
+			tcompare(t, m.DSNRet, expMsg.DSNRet)
+			tcompare(t, m.DSNEnvelopeID, expMsg.DSNEnvelopeID)
+		})
+	}
+
+	test("", "", "2", "2", queue.Msg{})
+	test(" RET=full ENVID=id+2B1", " NOTIFY=success,delay ORCPT=rfc822;Other+2Bx@example.org", "2", "2", queue.Msg{
+		DSNNotify:            []string{"SUCCESS", "DELAY"},
+		DSNOriginalRecipient: "rfc822;Other+x@example.org",
+		DSNRet:               "FULL",
+		DSNEnvelopeID:        "id+1",
+	})
+	test(" RET=HDRS", " NOTIFY=NEVER", "2", "2", queue.Msg{DSNNotify: []string{"NEVER"}, DSNRet: "HDRS"})
+
+	test(" RET=BOGUS", "", "501", "", queue.Msg{})               // Bad value.
+	test(" RET=FULL RET=HDRS", "", "501", "", queue.Msg{})       // Duplicate.
+	test(" ENVID=a+ZZ", "", "501", "", queue.Msg{})              // Bad xtext.
+	test("", " NOTIFY=NEVER,SUCCESS", "2", "501", queue.Msg{})   // Never cannot be combined.
+	test("", " NOTIFY=FAILURE,FAILURE", "2", "501", queue.Msg{}) // Duplicate value.
+	test("", " NOTIFY=BOGUS", "2", "501", queue.Msg{})           // Bad value.
+	test("", " ORCPT=rfc822", "2", "501", queue.Msg{})           // Missing address.
+	test("", " BOGUS=1", "2", "555", queue.Msg{})                // Unknown parameter.
+}
+
+// Test a DSN is queued for a local delivery with NOTIFY=SUCCESS, only for non-junk
+// messages from a verified sender.
+func TestDSNDelivered(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+		TXT: map[string][]string{},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	const script = `require ["fileinto"];
+if header :contains "subject" "spam" { fileinto "Junk"; }
+`
+	_, err := ts.acc.SieveScriptSave(ctxbg, "main", script)
+	tcheck(t, err, "save sieve script")
+	err = ts.acc.SieveScriptActivate(ctxbg, "main")
+	tcheck(t, err, "activate sieve script")
+
+	deliver := func(subject string, notify []string) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			msg := strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: "+subject)
+			opts := &smtpclient.DSNOpts{EnvID: "envid", Recipients: []smtpclient.DSNRecipient{{Notify: notify, ORcpt: "rfc822;mjl@mox.example"}}}
+			_, err := client.DeliverMultipleDSN(ctxbg, "remote@example.org", []string{"mjl@mox.example"}, int64(len(msg)), strings.NewReader(msg), false, false, false, opts)
+			tcheck(t, err, "deliver")
+		})
+	}
+	checkQueued := func(exp int) {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+		tcheck(t, err, "list queue")
+		tcompare(t, len(msgs), exp)
+	}
+
+	// No DSN if sender is not verified.
+	deliver("test", []string{"SUCCESS"})
+	ts.checkCount("Inbox", 1)
+	checkQueued(0)
+
+	resolver.TXT["example.org."] = []string{"v=spf1 ip4:127.0.0.10 -all"}
+
+	// No DSN without SUCCESS.
+	deliver("test", []string{"FAILURE"})
+	ts.checkCount("Inbox", 2)
+	checkQueued(0)
+
+	// No DSN for junk.
+	deliver("spam", []string{"SUCCESS"})
+	ts.checkCount("Junk", 1)
+	checkQueued(0)
+
+	deliver("test", []string{"SUCCESS"})
+	ts.checkCount("Inbox", 3)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().String()+" "+msgs[0].Recipient().String(), " remote@example.org")
+
+	mr, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open queued message")
+	defer mr.Close()
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read queued message")
+	s := string(buf)
+	for _, exp := range []string{"Action: delivered\r\n", "Status: 2.0.0\r\n", "Original-Envelope-ID: envid\r\n", "Original-Recipient: rfc822;mjl@mox.example\r\n"} {
+		if !strings.Contains(s, exp) {
+			t.Fatalf("dsn does not contain %q:\n%s", exp, s)
+		}
+	}
+}
+
+// Test BDAT from CHUNKING extension.
+func TestBDAT(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	ts.tlsmode = smtpclient.TLSSkip
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	defer ts.close()
+
+	ts.auth = func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error) {
+		return sasl.NewClientPlain(ts.user, ts.pass), nil
+	}
+
+	// Regular delivery through smtpclient uses BDAT when announced.
+	ts.run(func(client *smtpclient.Client) {
+		if !client.SupportsChunking() {
+			t.Fatalf("chunking extension not announced")
+		}
+		mailFrom := "mjl@mox.example"
+		rcptTo := "remote@example.org"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+
+	const msg = "From: <mjl@mox.example>\r\n\r\nbody\r\n.\r\nQUIT\r\n"
+
+	test := func(fn func(write func(s string), readPrefixLine func(prefix string) string)) {
+		t.Helper()
+
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+
+			ourHostname := mox.Conf.Static.HostnameDomain
+			remoteHostname := dns.Domain{ASCII: "mox.example"}
+			opts := smtpclient.Opts{Auth: ts.auth}
+			log := pkglog.WithCid(ts.cid - 1)
+			_, err := smtpclient.New(ctxbg, log.Logger, conn, ts.tlsmode, false, ourHostname, remoteHostname, opts)
+			tcheck(t, err, "smtpclient")
+			defer conn.Close()
+
+			write := func(s string) {
+				_, err := conn.Write([]byte(s))
+				tcheck(t, err, "write")
+			}
+
+			readPrefixLine := func(prefix string) string {
+				t.Helper()
+				buf := make([]byte, 512)
+				n, err := conn.Read(buf)
+				tcheck(t, err, "read")
+				s := strings.TrimRight(string(buf[:n]), "\r\n")
+				if !strings.HasPrefix(s, prefix) {
+					t.Fatalf("got smtp response %q, expected line with prefix %q", s, prefix)
+				}
+				return s
+			}
+
+			fn(write, readPrefixLine)
+		})
+	}
+
+	queued := func() int {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: false})
+		tcheck(t, err, "list queue")
+		return len(msgs)
+	}
+
+	// Message in multiple chunks. The data is not dot-stuffed, and the end-of-data
+	// sequence for DATA in the message is not special.
+	n := queued()
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("MAIL FROM:<mjl@mox.example>\r\n")
+		readPrefixLine("2")
+		write("RCPT TO:<remote@example.org>\r\n")
+		readPrefixLine("2")
+		write("BDAT 10\r\n" + msg[:10])
+		readPrefixLine("250 2.0.0 10 octets received")
+		write("BDAT 0\r\n")
+		readPrefixLine("250 ")
+		write(fmt.Sprintf("BDAT %d LAST\r\n%s", len(msg)-10, msg[10:]))
+		readPrefixLine("2")
+		write("NOOP\r\n")
+		readPrefixLine("2")
+	})
+	if queued() != n+1 {
+		t.Fatalf("message not queued")
+	}
+
+	// Chunk data is read before responding with an error, and not interpreted as commands.
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("BDAT 6 LAST\r\nRSET\r\n")
+		readPrefixLine("503 ")
+		write("NOOP\r\n")
+		readPrefixLine("2")
+	})
+
+	// After a failed chunk, the transaction is reset and pipelined chunks are rejected.
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("MAIL FROM:<mjl@mox.example>\r\n")
+		readPrefixLine("2")
+		write("BDAT 6 LAST\r\nRSET\r\n")
+		readPrefixLine("503 ")
+		write("RCPT TO:<remote@example.org>\r\n")
+		readPrefixLine("503 ")
+	})
+
+	// Cannot mix DATA with BDAT.
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("MAIL FROM:<mjl@mox.example>\r\n")
+		readPrefixLine("2")
+		write("RCPT TO:<remote@example.org>\r\n")
+		readPrefixLine("2")
+		write("BDAT 10\r\n" + msg[:10])
+		readPrefixLine("2")
+		write("DATA\r\n")
+		readPrefixLine("503 ")
+	})
+
+	// Too large messages are rejected before reading, and the connection is closed.
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("MAIL FROM:<mjl@mox.example>\r\n")
+		readPrefixLine("2")
+		write("RCPT TO:<remote@example.org>\r\n")
+		readPrefixLine("2")
+		write(fmt.Sprintf("BDAT %d LAST\r\n", 200<<20))
+		readPrefixLine("552 5.3.4 ")
+	})
+
+	// Bad syntax closes the connection, we don't know where the next command starts.
+	test(func(write func(s string), readPrefixLine func(prefix string) string) {
+		write("BDAT -1\r\n")
+		readPrefixLine("501 ")
+	})
+	if queued() != n+1 {
+		t.Fatalf("unexpected messages queued")
+	}
+}
+
 // Test SMTPUTF8
 func TestSMTPUTF8(t *testing.T) {
 	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
@@ -0,0 +1,382 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/srs"
+	"github.com/mjl-/mox/store"
+)
+
+// sieveRejectReason returns the reason from a Sieve reject action for use in an
+// SMTP response: on a single line, and of limited length.
+func sieveRejectReason(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return "rejected by recipient"
+	}
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
+
+// sieveRedirect queues the delivered message for delivery to the addresses of
+// Sieve redirect actions. If SRS is configured, the SMTP MAIL FROM is the
+// original sender rewritten to an SRS address in the recipient domain, so SPF
+// passes at the next hop and bounces are passed on to the original sender.
+// Otherwise, or for messages from the null sender, the recipient address is used
+// as MAIL FROM, and delivery failures are reported to the recipient.
+//
+// An ARC set with authResults is added to the message, signed with the DKIM keys
+// of the recipient domain, so the next hop can use our authentication results:
+// SPF and DMARC will typically fail after redirecting.
+//
+// Redirects count towards the outgoing message limits of the account. No
+// messages are redirected if a limit would be exceeded.
+//
+// Messages that already have a Delivered-To header for the recipient were
+// delivered to the recipient before, e.g. redirected back to us, and are not
+// redirected again to prevent loops.
+func (c *conn) sieveRedirect(ctx context.Context, log mlog.Log, d delivery, headers textproto.MIMEHeader, authResults message.AuthResults, has8bit bool, messageID, subject string, addrs []string) {
+	// ../rfc/9228:274
+	for _, v := range headers.Values("Delivered-To") {
+		if addr, err := smtp.ParseAddress(strings.TrimSpace(v)); err == nil && addr.Path().Equal(d.deliverTo) {
+			log.Info("not redirecting message for sieve script, already delivered to recipient before, possible loop", slog.Any("redirects", addrs))
+			return
+		}
+	}
+
+	// ../rfc/8617
+	msgPrefix := d.m.MsgPrefix
+	size := d.m.Size
+	arcAuthResults := strings.TrimPrefix(authResults.Header(), "Authentication-Results:")
+	arcHeaders, err := mox.ARCSeal(ctx, log, d.deliverTo.IPDomain.Domain, c.msgsmtputf8, arcAuthResults, d.arcResult, store.FileMsgReader(d.m.MsgPrefix, d.dataFile))
+	if err != nil {
+		log.Infox("adding arc set to message for sieve redirect", err)
+	} else if arcHeaders != "" {
+		msgPrefix = append([]byte(arcHeaders), msgPrefix...)
+		size += int64(len(arcHeaders))
+	}
+
+	// Only the first secret signs new SRS addresses, older secrets only verify bounces.
+	mailFrom := d.deliverTo
+	if conf := mox.Conf.Static.SRS; conf != nil && c.mailFrom != nil && !c.mailFrom.IsZero() {
+		if p, err := srs.Forward(conf.SecretsParsed[0], *c.mailFrom, d.deliverTo.IPDomain.Domain, time.Now()); err != nil {
+			log.Infox("rewriting sender with srs for sieve redirect, using recipient as sender", err, slog.Any("sender", *c.mailFrom))
+		} else {
+			mailFrom = p
+		}
+	}
+
+	var rcpts []smtp.Path
+	for _, s := range addrs {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			log.Errorx("parsing address of sieve redirect", err, slog.String("address", s))
+			continue
+		}
+		rcpts = append(rcpts, addr.Path())
+	}
+	if len(rcpts) == 0 {
+		return
+	}
+
+	// Redirects count towards the outgoing limits of the account, like submissions.
+	var msglimit, rcptlimit int
+	err = d.acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		var err error
+		msglimit, rcptlimit, err = d.acc.SendLimitReached(tx, rcpts)
+		return err
+	})
+	if err != nil {
+		log.Errorx("checking outgoing limits for sieve redirect", err)
+		return
+	} else if msglimit >= 0 {
+		log.Info("not redirecting message for sieve script, max number of messages over past 24h reached", slog.Int("maxmessages", msglimit), slog.Any("redirects", rcpts))
+		return
+	} else if rcptlimit >= 0 {
+		log.Info("not redirecting message for sieve script, max number of first-time recipients over past 24h reached", slog.Int("maxrecipients", rcptlimit), slog.Any("redirects", rcpts))
+		return
+	}
+
+	var queued []smtp.Path
+	for _, rcpt := range rcpts {
+		qm := queue.MakeMsg(mailFrom, rcpt, has8bit, c.msgsmtputf8, size, messageID, msgPrefix, nil, time.Now(), subject)
+		if err := queue.Add(ctx, log, d.acc.Name, d.dataFile, qm); err != nil {
+			log.Errorx("queueing message for sieve redirect", err, slog.Any("redirect", rcpt))
+			continue
+		}
+		queued = append(queued, rcpt)
+		log.Info("message redirected by sieve script", slog.Any("redirect", rcpt))
+	}
+
+	err = d.acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		for _, rcpt := range queued {
+			outgoing := store.Outgoing{Recipient: rcpt.XString(true)}
+			if err := tx.Insert(&outgoing); err != nil {
+				return fmt.Errorf("adding outgoing message: %v", err)
+			}
+		}
+		return nil
+	})
+	log.Check(err, "adding outgoing messages for sieve redirect")
+}
+
+// vacationResponse is an automatic response to an incoming message, from a Sieve
+// vacation action or from the vacation settings of the account.
+type vacationResponse struct {
+	Handle    string   // For tracking responses sent per sender.
+	Days      int      // Minimum number of days between responses to the same sender.
+	Subject   string   // If empty, the subject of the incoming message is used with an "Auto: " prefix.
+	From      string   // If empty, the recipient address is used.
+	Addresses []string // Additional addresses of the recipient.
+	Text      string   // Message text, or a MIME part if MIME is set.
+	HTML      string   // Optional, sent as alternative to Text.
+	MIME      bool
+}
+
+// sieveVacation sends the response of a Sieve vacation action. ../rfc/5230
+func (c *conn) sieveVacation(ctx context.Context, log mlog.Log, d delivery, headers textproto.MIMEHeader, envelope *message.Envelope, v *sieve.Vacation) {
+	c.vacation(ctx, log, d, headers, envelope, vacationResponse{
+		Handle:    v.Handle,
+		Days:      v.Days,
+		Subject:   v.Subject,
+		From:      v.From,
+		Addresses: v.Addresses,
+		Text:      v.Reason,
+		MIME:      v.MIME,
+	})
+}
+
+// accountVacation sends a response according to the vacation settings of the
+// account, if enabled and within the configured date range.
+func (c *conn) accountVacation(ctx context.Context, log mlog.Log, d delivery, headers textproto.MIMEHeader, envelope *message.Envelope) {
+	v, err := d.acc.VacationGet(ctx)
+	if err != nil {
+		log.Errorx("looking up vacation settings", err)
+		return
+	} else if !v.Active(time.Now()) {
+		return
+	}
+	c.vacation(ctx, log, d, headers, envelope, vacationResponse{
+		Handle:    store.VacationHandle,
+		Days:      v.Days,
+		Subject:   v.Subject,
+		Addresses: v.Addresses,
+		Text:      v.Text,
+		HTML:      v.HTML,
+	})
+}
+
+// vacation sends an automatic response to the envelope sender. No response is
+// sent for messages from the null sender, automated messages and mailing lists,
+// for messages not addressed to the recipient, and when a response was sent to
+// the sender in the past days of the response. ../rfc/3834
+func (c *conn) vacation(ctx context.Context, log mlog.Log, d delivery, headers textproto.MIMEHeader, envelope *message.Envelope, v vacationResponse) {
+	log = log.With(slog.String("handle", v.Handle))
+	skip := func(reason string) {
+		log.Debug("not sending vacation response", slog.String("reason", reason))
+	}
+
+	if c.mailFrom == nil || c.mailFrom.IsZero() {
+		skip("null sender")
+		return
+	}
+	sender := *c.mailFrom
+
+	// ../rfc/5230
+	lp := strings.ToLower(string(sender.Localpart))
+	if lp == "mailer-daemon" || lp == "listserv" || lp == "majordomo" || strings.HasPrefix(lp, "owner-") || strings.HasSuffix(lp, "-request") || strings.HasSuffix(lp, "-bounces") {
+		skip("sender address is automated")
+		return
+	}
+	// ../rfc/3834
+	if s := strings.ToLower(strings.TrimSpace(headers.Get("Auto-Submitted"))); s != "" && s != "no" {
+		skip("auto-submitted message")
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(headers.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		skip("bulk message")
+		return
+	}
+	if headers.Get("List-Id") != "" || headers.Get("List-Unsubscribe") != "" {
+		skip("mailing list message")
+		return
+	}
+	if d.m.Junk {
+		skip("junk message")
+		return
+	}
+
+	// Addresses of the recipient, the message must be addressed to one of them.
+	own := map[string]bool{}
+	for _, p := range []smtp.Path{d.deliverTo, d.smtpRcptTo} {
+		own[strings.ToLower(p.String())] = true
+	}
+	if d.canonicalAddress != "" {
+		own[strings.ToLower(d.canonicalAddress)] = true
+	}
+	for _, s := range v.Addresses {
+		own[strings.ToLower(s)] = true
+	}
+	if own[strings.ToLower(sender.String())] {
+		skip("message from recipient")
+		return
+	}
+	var addressed bool
+	for _, a := range append(append([]message.Address{}, d.msgTo...), d.msgCc...) {
+		if own[strings.ToLower(a.User+"@"+a.Host)] {
+			addressed = true
+			break
+		}
+	}
+	if !addressed {
+		skip("recipient not in to or cc header")
+		return
+	}
+
+	from := d.deliverTo
+	if v.From != "" {
+		// Only addresses of the recipient can be used, to prevent sending as others.
+		if l, err := message.ParseAddressList(v.From); err != nil || len(l) != 1 {
+			log.Info("parsing from address of vacation, using recipient address", slog.String("from", v.From))
+		} else if addr, err := smtp.ParseAddress(l[0].User + "@" + l[0].Host); err != nil || !own[strings.ToLower(addr.String())] {
+			log.Info("from address of vacation not an address of recipient, using recipient address", slog.String("from", v.From))
+		} else {
+			from = addr.Path()
+		}
+	}
+
+	due, err := d.acc.SieveVacationDue(ctx, v.Handle, sender.String(), v.Days)
+	if err != nil {
+		log.Errorx("checking whether vacation response is due", err)
+		return
+	} else if !due {
+		skip("response sent recently")
+		return
+	}
+
+	subject := v.Subject
+	if subject == "" {
+		var s string
+		if envelope != nil {
+			s = envelope.Subject
+		}
+		subject = "Auto: " + s
+	}
+	var inReplyTo string
+	if envelope != nil {
+		inReplyTo = envelope.MessageID
+	}
+
+	buf, msgID, smtputf8, err := composeVacation(from, sender, subject, inReplyTo, v)
+	if err != nil {
+		log.Errorx("composing vacation response", err)
+		return
+	}
+	dkimHeaders, err := mox.DKIMSign(ctx, log, from, smtputf8, buf)
+	log.Check(err, "dkim signing vacation response")
+
+	f, err := store.CreateMessageTemp(log, "smtp-vacation")
+	if err != nil {
+		log.Errorx("creating temp file for vacation response", err)
+		return
+	}
+	defer store.CloseRemoveTempFile(log, f, "smtpserver vacation message")
+	if _, err := f.Write(buf); err != nil {
+		log.Errorx("writing vacation response", err)
+		return
+	}
+
+	// Sent with null reverse path, so it cannot cause further automatic responses.
+	// ../rfc/3834
+	size := int64(len(dkimHeaders) + len(buf))
+	qm := queue.MakeMsg(smtp.Path{}, sender, true, smtputf8, size, msgID, []byte(dkimHeaders), nil, time.Now(), subject)
+	if err := queue.Add(ctx, log, d.acc.Name, f, qm); err != nil {
+		log.Errorx("queueing vacation response", err)
+		return
+	}
+	log.Info("vacation response queued", slog.Any("sender", sender))
+}
+
+func composeVacation(from, to smtp.Path, subject, inReplyTo string, v vacationResponse) (buf []byte, msgID string, smtputf8 bool, rerr error) {
+	smtputf8 = from.Localpart.IsInternational() || to.Localpart.IsInternational()
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 1024*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: smtp.Address{Localpart: from.Localpart, Domain: from.IPDomain.Domain}}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: smtp.Address{Localpart: to.Localpart, Domain: to.IPDomain.Domain}}})
+	xc.Subject(subject)
+	msgID = fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", msgID)
+	if inReplyTo != "" {
+		xc.Header("In-Reply-To", inReplyTo)
+		xc.Header("References", inReplyTo)
+	}
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("Auto-Submitted", "auto-replied")
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+	if v.MIME {
+		// Text is a MIME entity, with its own headers.
+		s := strings.ReplaceAll(strings.ReplaceAll(v.Text, "\r\n", "\n"), "\n", "\r\n")
+		_, err := xc.Write([]byte(s))
+		xc.Checkf(err, "writing mime part")
+	} else if v.HTML == "" || v.Text == "" {
+		subtype, text := "plain", v.Text
+		if v.Text == "" {
+			subtype, text = "html", v.HTML
+		}
+		body, ct, cte := xc.TextPart(subtype, text)
+		xc.Header("Content-Type", ct)
+		xc.Header("Content-Transfer-Encoding", cte)
+		xc.Line()
+		_, err := xc.Write(body)
+		xc.Checkf(err, "writing text")
+	} else {
+		mp := multipart.NewWriter(xc)
+		xc.Header("Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, mp.Boundary()))
+		xc.Line()
+		for _, t := range [][2]string{{"plain", v.Text}, {"html", v.HTML}} {
+			body, ct, cte := xc.TextPart(t[0], t[1])
+			p, err := mp.CreatePart(textproto.MIMEHeader{"Content-Type": []string{ct}, "Content-Transfer-Encoding": []string{cte}})
+			xc.Checkf(err, "adding part")
+			_, err = p.Write(body)
+			xc.Checkf(err, "writing %s part", t[0])
+		}
+		err := mp.Close()
+		xc.Checkf(err, "finishing multipart")
+	}
+	xc.Flush()
+	return b.Bytes(), msgID, xc.SMTPUTF8, nil
+}
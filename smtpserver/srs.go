@@ -0,0 +1,81 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/srs"
+)
+
+// xsrsReverse returns the address to send a bounce to if rcptTo is an SRS address
+// in one of our domains, as used as MAIL FROM for messages forwarded by Sieve
+// redirect. If rcptTo is not an SRS address for us, nil is returned. Invalid and
+// expired SRS addresses are rejected. Only bounces, with a null reverse path, are
+// accepted, so the SRS addresses cannot be used to relay other messages.
+func (c *conn) xsrsReverse(rcptTo smtp.Path) *smtp.Path {
+	conf := mox.Conf.Static.SRS
+	if conf == nil || c.submission || c.lmtp || !srs.IsSRS(rcptTo.Localpart) {
+		return nil
+	}
+	if _, ok := mox.Conf.Domain(rcptTo.IPDomain.Domain); !ok {
+		return nil
+	}
+
+	orig, err := srs.Reverse(conf.SecretsParsed, rcptTo.Localpart, conf.MaxAge, time.Now())
+	if err != nil && (errors.Is(err, srs.ErrHash) || errors.Is(err, srs.ErrExpired) || errors.Is(err, srs.ErrSyntax)) {
+		c.log.Infox("invalid srs address", err, slog.Any("rcptto", rcptTo))
+		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "invalid or expired srs address")
+	} else if err != nil {
+		c.log.Errorx("decoding srs address", err, slog.Any("rcptto", rcptTo))
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+	}
+	if !c.mailFrom.IsZero() {
+		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, "srs address only accepts bounces")
+	}
+	if len(c.recipients) > 0 {
+		// The bounce is passed on as a whole, not delivered to other recipients.
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "srs address must be the only recipient")
+	}
+	return &orig
+}
+
+// deliverSRS passes a bounce to an SRS address on to the original sender of the
+// forwarded message, through the queue with a null reverse path like for DSNs.
+func (c *conn) deliverSRS(ctx context.Context, recvHdrFor func(string, ...string) string, msgWriter *message.Writer, dataFile *os.File) {
+	rcpt := c.recipients[0]
+
+	var messageID, subject string
+	if part, err := message.Parse(c.log.Logger, false, dataFile); err != nil {
+		c.log.Debugx("parsing bounce for srs address", err)
+	} else {
+		if part.Envelope != nil {
+			messageID = part.Envelope.MessageID
+			subject = part.Envelope.Subject
+		}
+		// Basic loop detection. ../rfc/5321:4065
+		if header, err := part.Header(); err == nil && len(header.Values("Received")) > 100 {
+			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeNet4Loop6, "loop detected, more than 100 Received headers")
+		}
+	}
+
+	prefix := []byte(recvHdrFor(rcpt.Addr.String()))
+	qm := queue.MakeMsg(smtp.Path{}, *rcpt.SRS, msgWriter.Has8bit, c.msgsmtputf8, msgWriter.Size+int64(len(prefix)), messageID, prefix, nil, time.Now(), subject)
+	if err := queue.Add(ctx, c.log, mox.Conf.Static.Postmaster.Account, dataFile, qm); err != nil {
+		c.log.Errorx("queueing bounce for srs address", err)
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+	}
+	metricDelivery.WithLabelValues("srs", "").Inc()
+	c.log.Info("bounce to srs address queued for original sender", slog.Any("rcptto", rcpt.Addr), slog.Any("sender", *rcpt.SRS))
+
+	c.transactionGood++
+	c.transactionBad-- // Compensate for earlier pessimistic increase.
+	c.rset()
+	c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+}
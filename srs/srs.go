@@ -0,0 +1,155 @@
+// Package srs implements the Sender Rewriting Scheme, for forwarding messages
+// without breaking SPF.
+//
+// When a message is forwarded to another mail server, the SMTP MAIL FROM of the
+// original sender cannot be kept: The SPF policy of the original sender does not
+// allow the IPs of the forwarding mail server. With SRS, the forwarding mail
+// server rewrites the MAIL FROM to an address in its own domain that encodes the
+// original sender, authenticated with an HMAC and a timestamp. Bounces to the SRS
+// address are decoded and sent back to the original sender.
+//
+// Addresses forwarded for the first time get an SRS0 address, e.g.
+// SRS0=HHHHHH=TT=example.org=user@forward.example. Messages with an SRS address
+// that are forwarded again get an SRS1 address that references the first
+// forwarder, so bounces travel back through it: SRS1=HHHHHH=forward.example==HHHHHH=TT=example.org=user@forward2.example.
+package srs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+var (
+	ErrNotSRS   = errors.New("srs: not an srs address")
+	ErrSyntax   = errors.New("srs: malformed srs address")
+	ErrHash     = errors.New("srs: invalid hash")
+	ErrExpired  = errors.New("srs: address has expired")
+	ErrIPDomain = errors.New("srs: cannot rewrite address with ip address")
+)
+
+// The timestamp in SRS0 addresses is the day number modulo 1024, encoded as two
+// base32 characters.
+const base32Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// Number of base32 characters of the HMAC included in addresses.
+const hashLength = 6
+
+// IsSRS returns whether localpart looks like an SRS address.
+func IsSRS(localpart smtp.Localpart) bool {
+	s := strings.ToUpper(string(localpart))
+	return strings.HasPrefix(s, "SRS0=") || strings.HasPrefix(s, "SRS1=")
+}
+
+// hash returns the truncated HMAC over the parts of an SRS address. Parts are
+// compared case-insensitively, because mail servers may change the case of
+// localparts.
+func hash(secret []byte, parts ...string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(strings.Join(parts, "="))))
+	return base32.StdEncoding.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+func timestamp(t time.Time) string {
+	day := t.Unix() / (24 * 3600) % 1024
+	return string([]byte{base32Chars[day>>5], base32Chars[day&31]})
+}
+
+// Forward returns the SRS address for sender, in domain, for use as SMTP MAIL
+// FROM when forwarding a message. The secret authenticates the address, it must
+// be passed to Reverse when a bounce is received.
+func Forward(secret []byte, sender smtp.Path, domain dns.Domain, now time.Time) (smtp.Path, error) {
+	if len(sender.IPDomain.IP) > 0 {
+		return smtp.Path{}, ErrIPDomain
+	}
+	host := sender.IPDomain.Domain.ASCII
+	local := string(sender.Localpart)
+	var lp string
+	if t := strings.SplitN(local, "=", 2); len(t) == 2 && strings.EqualFold(t[0], "SRS0") {
+		// Forwarding an SRS0 address of another forwarder. We reference that forwarder,
+		// it will do the final decoding.
+		rest := "=" + t[1]
+		lp = "SRS1=" + hash(secret, host, rest) + "=" + host + "=" + rest
+	} else if t := strings.SplitN(local, "=", 4); len(t) == 4 && strings.EqualFold(t[0], "SRS1") {
+		// Forwarding an SRS1 address, we keep referencing the first forwarder.
+		lp = "SRS1=" + hash(secret, t[2], t[3]) + "=" + t[2] + "=" + t[3]
+	} else {
+		ts := timestamp(now)
+		lp = "SRS0=" + hash(secret, ts, host, local) + "=" + ts + "=" + host + "=" + local
+	}
+	return smtp.Path{Localpart: smtp.Localpart(lp), IPDomain: dns.IPDomain{Domain: domain}}, nil
+}
+
+// Reverse decodes the localpart of an SRS address to the address to send a
+// bounce to: The original sender for an SRS0 address, or the SRS0 address at the
+// first forwarder for an SRS1 address. The hash must have been made with one of
+// secrets. SRS0 addresses must not be older than maxAge.
+func Reverse(secrets [][]byte, localpart smtp.Localpart, maxAge time.Duration, now time.Time) (smtp.Path, error) {
+	if !IsSRS(localpart) {
+		return smtp.Path{}, ErrNotSRS
+	}
+
+	checkHash := func(h string, parts ...string) error {
+		for _, secret := range secrets {
+			if hmac.Equal([]byte(strings.ToUpper(h)), []byte(hash(secret, parts...))) {
+				return nil
+			}
+		}
+		return ErrHash
+	}
+
+	parseDomain := func(s string) (dns.Domain, error) {
+		d, err := dns.ParseDomain(s)
+		if err != nil {
+			return dns.Domain{}, fmt.Errorf("%w: parsing domain: %v", ErrSyntax, err)
+		}
+		return d, nil
+	}
+
+	s := string(localpart)
+	if strings.EqualFold(s[:4], "SRS1") {
+		t := strings.SplitN(s, "=", 4)
+		if len(t) != 4 || !strings.HasPrefix(t[3], "=") {
+			return smtp.Path{}, ErrSyntax
+		}
+		if err := checkHash(t[1], t[2], t[3]); err != nil {
+			return smtp.Path{}, err
+		}
+		d, err := parseDomain(t[2])
+		if err != nil {
+			return smtp.Path{}, err
+		}
+		return smtp.Path{Localpart: smtp.Localpart("SRS0" + t[3]), IPDomain: dns.IPDomain{Domain: d}}, nil
+	}
+
+	t := strings.SplitN(s, "=", 5)
+	if len(t) != 5 || len(t[2]) != 2 || t[3] == "" || t[4] == "" {
+		return smtp.Path{}, ErrSyntax
+	}
+	if err := checkHash(t[1], t[2], t[3], t[4]); err != nil {
+		return smtp.Path{}, err
+	}
+	ts := strings.ToUpper(t[2])
+	i0, i1 := strings.IndexByte(base32Chars, ts[0]), strings.IndexByte(base32Chars, ts[1])
+	if i0 < 0 || i1 < 0 {
+		return smtp.Path{}, fmt.Errorf("%w: bad timestamp", ErrSyntax)
+	}
+	day := int64(i0<<5 | i1)
+	today := now.Unix() / (24 * 3600) % 1024
+	age := (today - day + 1024) % 1024
+	if time.Duration(age)*24*time.Hour > maxAge {
+		return smtp.Path{}, fmt.Errorf("%w: %d days old", ErrExpired, age)
+	}
+	d, err := parseDomain(t[3])
+	if err != nil {
+		return smtp.Path{}, err
+	}
+	return smtp.Path{Localpart: smtp.Localpart(t[4]), IPDomain: dns.IPDomain{Domain: d}}, nil
+}
@@ -0,0 +1,80 @@
+package srs
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestSRS(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	secret2 := []byte("fedcba9876543210")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	const maxAge = 21 * 24 * time.Hour
+
+	path := func(s string) smtp.Path {
+		t.Helper()
+		a, err := smtp.ParseAddress(s)
+		if err != nil {
+			t.Fatalf("parse address %q: %v", s, err)
+		}
+		return a.Path()
+	}
+	reverse := func(secrets [][]byte, p smtp.Path, tm time.Time, expPath string, expErr error) {
+		t.Helper()
+		rp, err := Reverse(secrets, p.Localpart, maxAge, tm)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("reverse %s: got err %v, expected %v", p, err, expErr)
+		}
+		if err == nil && rp.String() != expPath {
+			t.Fatalf("reverse %s: got %s, expected %s", p, rp, expPath)
+		}
+	}
+
+	fwd := dns.Domain{ASCII: "forward.example"}
+	p, err := Forward(secret, path("user=x@example.org"), fwd, now)
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if !strings.HasPrefix(string(p.Localpart), "SRS0=") || !strings.HasSuffix(p.String(), "=example.org=user=x@forward.example") {
+		t.Fatalf("unexpected srs0 address %s", p)
+	}
+	reverse([][]byte{secret}, p, now, "user=x@example.org", nil)
+	reverse([][]byte{secret2, secret}, p, now.Add(20*24*time.Hour), "user=x@example.org", nil)
+	reverse([][]byte{secret2}, p, now, "", ErrHash)
+	reverse([][]byte{secret}, p, now.Add(22*24*time.Hour), "", ErrExpired)
+
+	// Case may be changed by other mail servers.
+	reverse([][]byte{secret}, smtp.Path{Localpart: smtp.Localpart(strings.ToLower(string(p.Localpart)))}, now, "user=x@example.org", nil)
+
+	// Forwarding again results in SRS1, pointing to first forwarder.
+	p1, err := Forward(secret2, p, dns.Domain{ASCII: "forward2.example"}, now)
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if !strings.HasPrefix(string(p1.Localpart), "SRS1=") {
+		t.Fatalf("unexpected srs1 address %s", p1)
+	}
+	reverse([][]byte{secret2}, p1, now, p.String(), nil)
+	reverse([][]byte{secret}, p1, now, "", ErrHash)
+
+	// Forwarding SRS1 keeps referencing first forwarder.
+	p2, err := Forward(secret, p1, dns.Domain{ASCII: "forward3.example"}, now)
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	reverse([][]byte{secret}, p2, now, p.String(), nil)
+
+	reverse([][]byte{secret}, path("user@example.org"), now, "", ErrNotSRS)
+	reverse([][]byte{secret}, path("SRS0=bogus@example.org"), now, "", ErrSyntax)
+
+	_, err = Forward(secret, smtp.Path{Localpart: "user", IPDomain: dns.IPDomain{IP: net.ParseIP("127.0.0.1")}}, fwd, now)
+	if !errors.Is(err, ErrIPDomain) {
+		t.Fatalf("got err %v, expected ErrIPDomain", err)
+	}
+}
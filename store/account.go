@@ -590,6 +590,13 @@ type Message struct {
 	// want to strip whitespace.
 	Preview *string
 
+	// Breakdown of the content-based junk classification during incoming delivery,
+	// for explaining why a message was or was not classified as junk. Nil if no
+	// content analysis was done, e.g. for messages from senders with a reputation,
+	// messages that were not delivered over SMTP, or messages delivered before this
+	// field was added.
+	Classification *Classification
+
 	// ParsedBuf message structure. Currently saved as JSON of message.Part because
 	// bstore wasn't able to store recursive types when this was implemented. Created
 	// when first needed, and saved in the database.
@@ -930,6 +937,11 @@ var DBTypes = []any{
 	RulesetNoMailbox{},
 	Annotation{},
 	MessageErase{},
+	ReservoirSample{},
+	SieveScript{},
+	SieveVacation{},
+	Vacation{},
+	EmailSubmission{},
 }
 
 // Account holds the information about a user, includings mailboxes, messages, imap subscriptions.
@@ -1081,6 +1093,10 @@ func removeAccount(log mlog.Log, accountName string) error {
 		if err := loginAttemptRemoveAccount(tx, accountName); err != nil {
 			return fmt.Errorf("removing historic login attempts for account: %v", err)
 		}
+
+		if err := mailboxACLRemoveAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing mailbox acls for account: %v", err)
+		}
 		return nil
 	})
 	if err != nil {
@@ -2329,6 +2345,11 @@ func (a *Account) MessageAdd(log mlog.Log, tx *bstore.Tx, mb *Mailbox, m *Messag
 	conf, _ := a.Conf()
 	m.JunkFlagsForMailbox(*mb, conf)
 
+	// For copies, the flags at delivery of the original are kept.
+	if c := m.Classification; c != nil && c.DeliveryFlags == nil {
+		c.DeliveryFlags = &DeliveryFlags{m.Junk, m.Notjunk}
+	}
+
 	var part *message.Part
 	if m.ParsedBuf == nil {
 		mr := FileMsgReader(m.MsgPrefix, msgFile) // We don't close, it would close the msgFile.
@@ -2911,7 +2932,13 @@ func (a *Account) MessageReader(m Message) *MsgReader {
 	return &MsgReader{prefix: m.MsgPrefix, path: a.MessagePath(m.ID), size: m.Size}
 }
 
-// DeliverDestination delivers an email to dest, based on the configured rulesets.
+// DeliverDestination delivers an email to dest, based on the configured rulesets
+// and the active Sieve script of the account. The mailbox selected by the rulesets
+// is used for the keep of the Sieve script. Only the keep, fileinto and discard
+// actions are executed. The reject, redirect and vacation actions need an SMTP
+// transaction and the queue, and are only executed for deliveries by the SMTP
+// server: they are logged and otherwise ignored, with a reject turning into a
+// keep.
 //
 // Returns ErrOverQuota when account would be over quota after adding message.
 //
@@ -2928,7 +2955,22 @@ func (a *Account) DeliverDestination(log mlog.Log, dest config.Destination, m *M
 	} else {
 		mailbox = dest.Mailbox
 	}
-	return a.DeliverMailbox(log, mailbox, m, msgFile)
+
+	r := a.SieveEvaluate(log, m, msgFile)
+	if r == nil {
+		return a.DeliverMailbox(log, mailbox, m, msgFile)
+	}
+	if r.Rejected || len(r.Redirects) > 0 || r.Vacation != nil {
+		log.Info("sieve reject, redirect and vacation not supported for this delivery, ignoring", slog.Bool("reject", r.Rejected), slog.Any("redirects", r.Redirects), slog.Bool("vacation", r.Vacation != nil))
+		if r.Rejected {
+			r.Keep = true
+		}
+	}
+	delivered, err := a.DeliverSieve(log, r, mailbox, m, msgFile)
+	if err == nil && len(delivered) == 0 {
+		log.Info("message discarded by sieve script")
+	}
+	return err
 }
 
 // DeliverMailbox delivers an email to the specified mailbox.
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ACLRightsAll are all rights, as defined by IMAP ACL, ../rfc/4314.
+//
+//   - l: lookup, mailbox is visible in listings.
+//   - r: read, select mailbox, fetch and search messages, copy from mailbox.
+//   - s: keep seen flag.
+//   - w: write flags other than seen and deleted.
+//   - i: insert messages, with append and copy.
+//   - p: post, for submission directly to the mailbox, not used by mox.
+//   - k: create mailboxes, not used by mox for shared mailboxes.
+//   - x: delete mailbox, not used by mox for shared mailboxes.
+//   - t: set deleted flag.
+//   - e: expunge.
+//   - a: administer, i.e. change the ACL.
+//
+// The owner of a mailbox always has all rights.
+const ACLRightsAll = "lrswipkxtea"
+
+// ACLAnyone is the grantee for all accounts.
+const ACLAnyone = "anyone"
+
+// ErrACLRights is returned for invalid rights in MailboxACLSet.
+var ErrACLRights = errors.New("invalid rights")
+
+// MailboxACL gives another account rights to a mailbox. Stored in the auth
+// database, so grants can be found without opening all accounts.
+//
+// Rights are granted on the mailbox ID, which is stable across renames. Grants
+// for mailboxes that have been removed are ignored.
+type MailboxACL struct {
+	ID int64
+
+	Account   string `bstore:"nonzero,index Account+MailboxID"` // Owner of the mailbox.
+	MailboxID int64  `bstore:"nonzero"`
+
+	// Account name of grantee, or "anyone" for all accounts.
+	Grantee string `bstore:"nonzero,index"`
+
+	// Rights from ACLRightsAll, in that order.
+	Rights string `bstore:"nonzero"`
+}
+
+// ACLRightsCanonical returns rights in canonical order, without duplicates. An
+// error is returned for unknown rights.
+func ACLRightsCanonical(rights string) (string, error) {
+	for _, c := range rights {
+		if !strings.ContainsRune(ACLRightsAll, c) {
+			return "", fmt.Errorf("%w: unknown right %q", ErrACLRights, c)
+		}
+	}
+	var r string
+	for _, c := range ACLRightsAll {
+		if strings.ContainsRune(rights, c) {
+			r += string(c)
+		}
+	}
+	return r, nil
+}
+
+// ACLRightsMerge returns the union of rights, in canonical order.
+func ACLRightsMerge(rights ...string) string {
+	var r string
+	for _, c := range ACLRightsAll {
+		for _, s := range rights {
+			if strings.ContainsRune(s, c) {
+				r += string(c)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// ACLGrantee returns the grantee name for identifier, which can be "anyone", an
+// account name, or an email address of an account.
+func ACLGrantee(identifier string) (string, error) {
+	if strings.EqualFold(identifier, ACLAnyone) {
+		return ACLAnyone, nil
+	}
+	if _, ok := mox.Conf.Account(identifier); ok {
+		return identifier, nil
+	}
+	if addr, err := smtp.ParseAddress(identifier); err == nil {
+		if dest, _, ok := mox.Conf.AccountDestination(addr.String()); ok && !dest.Catchall {
+			return dest.Account, nil
+		}
+	}
+	return "", fmt.Errorf("unknown account or address %q", identifier)
+}
+
+// MailboxACLList returns the grants for mailboxes of account. If mailboxID is
+// non-zero, only grants for that mailbox are returned.
+func MailboxACLList(ctx context.Context, account string, mailboxID int64) ([]MailboxACL, error) {
+	q := bstore.QueryDB[MailboxACL](ctx, AuthDB)
+	q.FilterNonzero(MailboxACL{Account: account, MailboxID: mailboxID})
+	q.SortAsc("MailboxID", "Grantee")
+	return q.List()
+}
+
+// MailboxACLSet sets the rights for grantee on a mailbox of account. Empty rights
+// remove the grant. The caller must ensure the mailbox exists, and should use
+// ACLGrantee to resolve the grantee.
+func MailboxACLSet(ctx context.Context, account string, mailboxID int64, grantee, rights string) error {
+	rights, err := ACLRightsCanonical(rights)
+	if err != nil {
+		return err
+	}
+	if grantee == account {
+		return fmt.Errorf("cannot change rights of mailbox owner")
+	}
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[MailboxACL](tx)
+		q.FilterNonzero(MailboxACL{Account: account, MailboxID: mailboxID, Grantee: grantee})
+		acl, err := q.Get()
+		if err == bstore.ErrAbsent {
+			if rights == "" {
+				return nil
+			}
+			return tx.Insert(&MailboxACL{Account: account, MailboxID: mailboxID, Grantee: grantee, Rights: rights})
+		} else if err != nil {
+			return fmt.Errorf("get mailbox acl: %v", err)
+		} else if rights == "" {
+			return tx.Delete(&acl)
+		}
+		acl.Rights = rights
+		return tx.Update(&acl)
+	})
+}
+
+// MailboxACLRights returns the rights account has on a mailbox of owner: all
+// rights for the owner, or the union of the grants for account and "anyone".
+func MailboxACLRights(ctx context.Context, owner string, mailboxID int64, account string) (string, error) {
+	if owner == account {
+		return ACLRightsAll, nil
+	}
+	q := bstore.QueryDB[MailboxACL](ctx, AuthDB)
+	q.FilterNonzero(MailboxACL{Account: owner, MailboxID: mailboxID})
+	q.FilterEqual("Grantee", account, ACLAnyone)
+	l, err := q.List()
+	if err != nil {
+		return "", err
+	}
+	var rights []string
+	for _, acl := range l {
+		rights = append(rights, acl.Rights)
+	}
+	return ACLRightsMerge(rights...), nil
+}
+
+// MailboxACLShared returns the grants to account, directly or through "anyone",
+// for mailboxes of other accounts. Multiple grants can exist for a mailbox.
+func MailboxACLShared(ctx context.Context, account string) ([]MailboxACL, error) {
+	q := bstore.QueryDB[MailboxACL](ctx, AuthDB)
+	q.FilterEqual("Grantee", account, ACLAnyone)
+	q.FilterNotEqual("Account", account)
+	q.SortAsc("Account", "MailboxID")
+	return q.List()
+}
+
+// mailboxACLRemoveAccount removes grants for mailboxes of the account, and grants
+// to the account.
+func mailboxACLRemoveAccount(tx *bstore.Tx, account string) error {
+	q := bstore.QueryTx[MailboxACL](tx)
+	q.FilterNonzero(MailboxACL{Account: account})
+	if _, err := q.Delete(); err != nil {
+		return err
+	}
+	q = bstore.QueryTx[MailboxACL](tx)
+	q.FilterNonzero(MailboxACL{Grantee: account})
+	_, err := q.Delete()
+	return err
+}
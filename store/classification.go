@@ -0,0 +1,112 @@
+package store
+
+import (
+	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/reservoir"
+)
+
+// Classification is the breakdown of the content-based junk classification of an
+// incoming message, stored with the message during delivery. Used to explain
+// why a message was or was not classified as junk.
+type Classification struct {
+	// Reason for the delivery decision, e.g. "junk-content", "no-bad-signals" or
+	// "dns-blocklisted". A message can be rejected for other reasons than its
+	// content.
+	Reason string
+
+	Junk        bool    // Whether the content was classified as junk.
+	Probability float64 // Probability used for the decision, combined probability with a reservoir filter, otherwise the Bayesian probability.
+	Threshold   float64 // Junk threshold, can be lower than configured for suspicious deliveries.
+	Significant bool    // Whether the Bayesian filter has enough training data to base decisions on.
+
+	// Bayesian probability, and words most indicative of junk and nonjunk, with
+	// their score (0 is nonjunk, 1 is junk).
+	Bayesian  float64
+	JunkWords []junk.WordScore
+	HamWords  []junk.WordScore
+
+	// Only set if the account has a reservoir filter.
+	Reservoir *ReservoirClassification
+
+	// Junk and nonjunk flags of the message when it was delivered, e.g. set
+	// automatically based on the mailbox. Flags that differ were changed later, e.g.
+	// by the user moving the message. Nil for messages delivered before flags were
+	// recorded.
+	DeliveryFlags *DeliveryFlags
+}
+
+// DeliveryFlags are the junk and nonjunk flags of a message at delivery.
+type DeliveryFlags struct {
+	Junk    bool
+	Notjunk bool
+}
+
+// ReservoirClassification is the reservoir and affective part of a
+// Classification.
+type ReservoirClassification struct {
+	Probability float64 // From the reservoir.
+	Trained     bool    // If false, the reservoir output is not yet trained and Probability is from a heuristic.
+	Combined    float64 // Combined Bayesian, reservoir and affective probability.
+
+	// Whether the inconclusive reputation analysis during delivery leaned towards
+	// junk (true) or nonjunk (false), nil if unknown. Used as reputation feature when
+	// the message is trained later.
+	Reputation *bool
+
+	// Whether the content would be classified as junk based on the combined
+	// probability.
+	Junk bool
+
+	// If set, the reservoir filter ran in shadow mode, and the delivery decision was
+	// made with only the Bayesian probability.
+	Shadow bool
+
+	// Only set if affective analysis is enabled. For messages in an unknown
+	// language, only Language is set.
+	Affective *AffectiveClassification
+}
+
+// AffectiveClassification is the affective analysis part of a Classification,
+// with the PAD (pleasure/valence, arousal, dominance) dimensions of the emotional
+// state of the message.
+type AffectiveClassification struct {
+	Language    string // Detected language, e.g. "en", or "unknown" if no lexicon matched and affective analysis was skipped.
+	Probability float64
+	Valence     float64 // -1 (negative) to 1 (positive).
+	Arousal     float64 // 0 to 1.
+	Dominance   float64 // 0 to 1.
+}
+
+// NewClassification returns a classification for the junk filter result with
+// the probability and threshold used for the decision, and the result of a
+// reservoir filter, which can be nil.
+func NewClassification(result junk.Result, rr *reservoir.ClassifyResult, prob, threshold float64, isjunk bool) *Classification {
+	c := &Classification{
+		Junk:        isjunk,
+		Probability: prob,
+		Threshold:   threshold,
+		Significant: result.Significant,
+		Bayesian:    result.Probability,
+		JunkWords:   result.Spams,
+		HamWords:    result.Hams,
+	}
+	if rr != nil {
+		c.Reservoir = &ReservoirClassification{
+			Probability: rr.ReservoirProb,
+			Trained:     rr.ReservoirTrained,
+			Combined:    rr.CombinedProb,
+		}
+		if s := rr.AffectiveState; s != nil {
+			c.Reservoir.Affective = &AffectiveClassification{
+				Language:    rr.AffectiveLanguage,
+				Probability: rr.AffectiveProb,
+				Valence:     s.Valence,
+				Arousal:     s.Arousal,
+				Dominance:   s.Dominance,
+			}
+		} else if rr.AffectiveLanguage != "" {
+			c.Reservoir.Affective = &AffectiveClassification{Language: rr.AffectiveLanguage}
+		}
+	}
+	return c
+}
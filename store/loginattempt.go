@@ -44,7 +44,7 @@ type LoginAttempt struct {
 	LocalIP              string
 	TLS                  string // Empty if no TLS, otherwise contains version, algorithm, properties, etc.
 	TLSPubKeyFingerprint string
-	Protocol             string // "submission", "imap", "webmail", "webaccount", "webadmin"
+	Protocol             string // "submission", "imap", "managesieve", "pop3", "webmail", "webaccount", "webadmin"
 	UserAgent            string // From HTTP header, or IMAP ID command.
 	AuthMech             string // "plain", "login", "cram-md5", "scram-sha-256-plus", "(unrecognized)", etc
 	Result               AuthResult
@@ -0,0 +1,529 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/reservoir"
+)
+
+// ErrNoReservoirFilter indicates user did not configure/enable a reservoir filter.
+var ErrNoReservoirFilter = errors.New("reservoirfilter: not configured")
+
+// ReservoirSample is a message that was trained as junk or nonjunk, kept for
+// retraining the reservoir filter output weights. At most MaxSamples from the
+// reservoir filter configuration are kept, the oldest are removed first.
+type ReservoirSample struct {
+	ID        int64
+	MessageID int64 `bstore:"index"`
+	Junk      bool
+	Features  []float64 // From the reservoir feature extractor.
+
+	// Sender key of the message, see ReservoirSender, and when it was received. For
+	// training on per-sender state sequences, like used during classification.
+	Sender   string
+	Received time.Time
+}
+
+// Per-sender reservoir states, per account. Kept outside Account because
+// accounts are closed when unused, and states should survive between
+// deliveries.
+var reservoirStates = struct {
+	sync.Mutex
+	m map[string]reservoirStateCache // By account name.
+}{m: map[string]reservoirStateCache{}}
+
+type reservoirStateCache struct {
+	max    int
+	expiry time.Duration
+	cache  *reservoir.StateCache
+}
+
+// reservoirStateCache returns the per-sender state cache for the account, or nil
+// if the configuration doesn't keep sender states. A new cache is created if the
+// limits in the configuration changed.
+func (a *Account) reservoirStateCache(params reservoir.FilterConfig) *reservoir.StateCache {
+	reservoirStates.Lock()
+	defer reservoirStates.Unlock()
+
+	if params.SenderSequence == "none" {
+		delete(reservoirStates.m, a.Name)
+		return nil
+	}
+	sc, ok := reservoirStates.m[a.Name]
+	if !ok || sc.max != params.SenderStates || sc.expiry != params.SenderStateExpiry {
+		sc = reservoirStateCache{params.SenderStates, params.SenderStateExpiry, reservoir.NewStateCache(params.SenderStates, params.SenderStateExpiry)}
+		reservoirStates.m[a.Name] = sc
+	}
+	return sc.cache
+}
+
+func (a *Account) HasReservoirFilter() bool {
+	conf, _ := a.Conf()
+	return conf.ReservoirFilter != nil
+}
+
+// Opened reservoir filters, per account. Like reservoirStates, kept outside
+// Account so filters survive between deliveries. Classifying changes the state of
+// a filter, so a filter is only used with the lock of its accountReservoir held.
+var reservoirFilters = struct {
+	sync.Mutex
+	m map[string]*accountReservoir // By account name.
+}{m: map[string]*accountReservoir{}}
+
+type accountReservoir struct {
+	sync.Mutex
+	conf *config.ReservoirFilter // Configuration rf was opened with, a reload of the configuration reopens the filter.
+	rf   *reservoir.ReservoirFilter
+
+	training bool // Whether training in the background is scheduled.
+}
+
+// accountReservoir returns the reservoir filter entry for the account, without
+// opening the filter.
+func (a *Account) accountReservoir() *accountReservoir {
+	reservoirFilters.Lock()
+	defer reservoirFilters.Unlock()
+	ar, ok := reservoirFilters.m[a.Name]
+	if !ok {
+		ar = &accountReservoir{}
+		reservoirFilters.m[a.Name] = ar
+	}
+	return ar
+}
+
+// WithReservoirFilter calls fn with the reservoir filter of the account, with
+// default parameters applied to the configuration. If the account does not have a
+// reservoir filter enabled, ErrNoReservoirFilter is returned.
+//
+// A single filter is kept per account, fn is called with its lock held. Messages
+// should be prepared before calling, see reservoir.PrepareMessage, to keep the
+// lock short. The reservoir, membrane and affective state are reset before
+// calling fn, so each message is classified like by a newly opened filter.
+//
+// The model is stored in reservoirfilter.model in the account directory. A new
+// model is created on first access. If the stored model does not match the
+// configuration, e.g. after changing the reservoir size, it is replaced by a new
+// model. Output weights are trained in the background, see
+// scheduleReservoirTraining, not by this function.
+func (a *Account) WithReservoirFilter(ctx context.Context, log mlog.Log, fn func(rf *reservoir.ReservoirFilter, rfc *config.ReservoirFilter) error) error {
+	conf, ok := a.Conf()
+	if !ok {
+		return ErrAccountUnknown
+	}
+	rfc := conf.ReservoirFilter
+	if rfc == nil {
+		return ErrNoReservoirFilter
+	}
+
+	// Samples may have changed since the model was last trained. Scheduled after
+	// releasing the lock.
+	var opened bool
+	defer func() {
+		if opened {
+			a.scheduleReservoirTraining(log)
+		}
+	}()
+
+	ar := a.accountReservoir()
+	ar.Lock()
+	defer ar.Unlock()
+	if ar.rf == nil || ar.conf != rfc {
+		rf, err := a.openReservoirFilter(ctx, log, rfc)
+		if err != nil {
+			return err
+		}
+		ar.conf, ar.rf = rfc, rf
+		opened = true
+	}
+	ar.rf.Reset()
+	return fn(ar.rf, rfc)
+}
+
+// openReservoirFilter opens the reservoir filter model of the account, creating
+// or replacing it if needed. Must be called with the lock of the accountReservoir
+// held, it serializes changes to the model file.
+func (a *Account) openReservoirFilter(ctx context.Context, log mlog.Log, rfc *config.ReservoirFilter) (*reservoir.ReservoirFilter, error) {
+	params := rfc.Params.WithDefaults()
+	modelPath := a.reservoirModelPath()
+
+	// Not the log of the caller, the filter outlives it.
+	flog := mlog.New("store", nil).With(slog.String("account", a.Name))
+
+	var rf *reservoir.ReservoirFilter
+	var err error
+	if _, xerr := os.Stat(modelPath); xerr != nil && os.IsNotExist(xerr) {
+		rf, err = reservoir.NewFilter(ctx, flog, params, modelPath)
+	} else {
+		rf, err = reservoir.OpenFilter(ctx, flog, params, modelPath)
+		if err != nil && errors.Is(err, reservoir.ErrModelMismatch) {
+			log.Infox("reservoir filter model does not match configuration, creating new model", err, slog.String("path", modelPath))
+			if err := os.Remove(modelPath); err != nil {
+				return nil, fmt.Errorf("removing mismatching model: %v", err)
+			}
+			rf, err = reservoir.NewFilter(ctx, flog, params, modelPath)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	rf.SetStateCache(a.reservoirStateCache(params))
+	return rf, nil
+}
+
+func (a *Account) reservoirModelPath() string {
+	return filepath.Join(mox.DataDirPath("accounts"), a.Name, "reservoirfilter.model")
+}
+
+// scheduleReservoirTraining starts training of the reservoir filter output
+// weights in the background, unless already scheduled. Called when training
+// samples change, typically from within a database transaction. Training waits
+// for that transaction to finish, see fitReservoirFilter.
+func (a *Account) scheduleReservoirTraining(log mlog.Log) {
+	ar := a.accountReservoir()
+	ar.Lock()
+	if ar.training {
+		ar.Unlock()
+		return
+	}
+	ar.training = true
+	ar.Unlock()
+
+	// Keep the account open while training. The goroutine below decreases nused by
+	// calling closeAccount.
+	openAccounts.Lock()
+	a.nused++
+	openAccounts.Unlock()
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("unhandled panic training reservoir filter", slog.Any("err", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Store)
+			}
+
+			err := closeAccount(a)
+			log.Check(err, "closing account after training reservoir filter")
+		}()
+
+		// Changes from here on schedule another training.
+		ar.Lock()
+		ar.training = false
+		ar.Unlock()
+
+		err := a.trainReservoirFilter(mox.Shutdown, log)
+		if err != nil && !errors.Is(err, ErrNoReservoirFilter) {
+			log.Errorx("training reservoir filter", err)
+		}
+	}()
+}
+
+// trainReservoirFilter retrains the output weights of the reservoir filter if
+// the training samples changed since the last training. Training is done on a
+// separately opened filter, so deliveries can continue to classify with the
+// current filter. The model is saved, and the trained filter replaces the current
+// filter.
+func (a *Account) trainReservoirFilter(ctx context.Context, log mlog.Log) error {
+	conf, ok := a.Conf()
+	if !ok {
+		return ErrAccountUnknown
+	}
+	rfc := conf.ReservoirFilter
+	if rfc == nil {
+		return ErrNoReservoirFilter
+	}
+
+	// Ensure the model file exists and matches the configuration.
+	ar := a.accountReservoir()
+	ar.Lock()
+	if ar.rf == nil || ar.conf != rfc {
+		rf, err := a.openReservoirFilter(ctx, log, rfc)
+		if err != nil {
+			ar.Unlock()
+			return err
+		}
+		ar.conf, ar.rf = rfc, rf
+	}
+	ar.Unlock()
+
+	params := rfc.Params.WithDefaults()
+	rf, err := reservoir.OpenFilter(ctx, mlog.New("store", nil).With(slog.String("account", a.Name)), params, a.reservoirModelPath())
+	if err != nil {
+		return fmt.Errorf("open reservoir filter for training: %w", err)
+	}
+	// State cache must be set before training, samples are trained on per-sender
+	// state sequences when it is set.
+	rf.SetStateCache(a.reservoirStateCache(params))
+	changed, err := a.fitReservoirFilter(ctx, log, rf)
+	if err != nil || !changed {
+		return err
+	}
+
+	ar.Lock()
+	defer ar.Unlock()
+	if ar.conf == rfc {
+		ar.rf = rf
+	}
+	return nil
+}
+
+// fitReservoirFilter retrains the output weights of rf if the samples in the
+// database changed since the last training, and saves the model. Returns whether
+// rf was retrained.
+func (a *Account) fitReservoirFilter(ctx context.Context, log mlog.Log, rf *reservoir.ReservoirFilter) (bool, error) {
+	if rf.ESN() == nil {
+		return false, nil
+	}
+
+	// Samples are read in a write transaction, it waits for a transaction that
+	// scheduled training to finish, so we see its samples.
+	var samples []reservoir.Sample
+	var changed bool
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		n, err := bstore.QueryTx[ReservoirSample](tx).Count()
+		if err != nil {
+			return fmt.Errorf("counting samples: %v", err)
+		}
+		var lastID int64
+		if n > 0 {
+			last, err := bstore.QueryTx[ReservoirSample](tx).SortDesc("ID").Limit(1).Get()
+			if err != nil {
+				return fmt.Errorf("get last sample: %v", err)
+			}
+			lastID = last.ID
+		}
+		tn, tlastID := rf.TrainedSamples()
+		if n == tn && lastID == tlastID {
+			return nil
+		}
+		changed = true
+
+		return bstore.QueryTx[ReservoirSample](tx).SortAsc("ID").ForEach(func(rs ReservoirSample) error {
+			samples = append(samples, reservoir.Sample{ID: rs.ID, Junk: rs.Junk, Features: rs.Features, Sender: rs.Sender, Received: rs.Received})
+			return nil
+		})
+	})
+	if err != nil || !changed {
+		return false, err
+	}
+
+	t0 := time.Now()
+	if err := rf.Fit(ctx, samples); err != nil {
+		return false, err
+	}
+	log.Debug("retrained reservoir filter", slog.Int("samples", len(samples)), slog.Duration("duration", time.Since(t0)))
+	return true, rf.Save()
+}
+
+// ReservoirMessage returns the input for reservoir feature extraction for m,
+// with part p and words from the junk filter tokenizer. Reputation is set from the
+// classification at delivery, so training samples have the same features as
+// during classification.
+func ReservoirMessage(m Message, p *message.Part, words map[string]struct{}) reservoir.Message {
+	var spf string
+	switch m.MailFromValidation {
+	case ValidationPass:
+		spf = "pass"
+	case ValidationNeutral:
+		spf = "neutral"
+	case ValidationTemperror:
+		spf = "temperror"
+	case ValidationPermerror:
+		spf = "permerror"
+	case ValidationFail:
+		spf = "fail"
+	case ValidationSoftfail:
+		spf = "softfail"
+	case ValidationNone:
+		spf = "none"
+	}
+	var reputation *bool
+	if c := m.Classification; c != nil && c.Reservoir != nil {
+		reputation = c.Reservoir.Reputation
+	}
+	return reservoir.Message{
+		Part:  p,
+		Words: words,
+		Auth: reservoir.Auth{
+			DKIMDomains:       m.DKIMDomains,
+			SPF:               spf,
+			EHLOValidated:     m.EHLOValidated,
+			MailFromValidated: m.MailFromValidated,
+			MsgFromValidated:  m.MsgFromValidated,
+			Reputation:        reputation,
+		},
+	}
+}
+
+// ReservoirSender returns the key for the sender of m, for keeping per-sender
+// reservoir state as configured in params. An empty string is returned if no
+// per-sender state is kept, or no key is available, e.g. for forwarded messages
+// without remote IP.
+func ReservoirSender(params reservoir.FilterConfig, m Message) string {
+	switch params.SenderSequence {
+	case "orgdomain":
+		if m.MsgFromValidated && m.MsgFromOrgDomain != "" {
+			return "domain:" + m.MsgFromOrgDomain
+		}
+		fallthrough
+	case "ip":
+		if m.RemoteIPMasked2 != "" {
+			return "ip:" + m.RemoteIPMasked2
+		}
+	}
+	return ""
+}
+
+// retrainReservoirSample updates the reservoir training samples for message m
+// with part p and junk filter words, after the junk filter (un)trained it.
+func (a *Account) retrainReservoirSample(log mlog.Log, tx *bstore.Tx, m *Message, p *message.Part, words map[string]struct{}, untrain, train, trainJunk bool) error {
+	conf, _ := a.Conf()
+	if conf.ReservoirFilter == nil {
+		return nil
+	}
+
+	if untrain {
+		if _, err := bstore.QueryTx[ReservoirSample](tx).FilterNonzero(ReservoirSample{MessageID: m.ID}).Delete(); err != nil {
+			return fmt.Errorf("removing reservoir sample: %v", err)
+		}
+	}
+	if !untrain && !train {
+		return nil
+	}
+	a.scheduleReservoirTraining(log)
+	if !train {
+		return nil
+	}
+
+	params := conf.ReservoirFilter.Params.WithDefaults()
+	extractor, err := reservoir.NewExtractor(params)
+	if err != nil {
+		return fmt.Errorf("reservoir feature extractor: %v", err)
+	}
+	rs := ReservoirSample{
+		MessageID: m.ID,
+		Junk:      trainJunk,
+		Features:  extractor.Features(ReservoirMessage(*m, p, words)),
+		Sender:    ReservoirSender(params, *m),
+		Received:  m.Received,
+	}
+	if err := tx.Insert(&rs); err != nil {
+		return fmt.Errorf("inserting reservoir sample: %v", err)
+	}
+
+	// Remove oldest samples beyond the maximum.
+	maxSamples := params.MaxSamples
+	n, err := bstore.QueryTx[ReservoirSample](tx).Count()
+	if err != nil {
+		return fmt.Errorf("counting reservoir samples: %v", err)
+	}
+	if n > maxSamples {
+		removed, err := bstore.QueryTx[ReservoirSample](tx).SortAsc("ID").Limit(n - maxSamples).Delete()
+		if err != nil {
+			return fmt.Errorf("removing old reservoir samples: %v", err)
+		}
+		log.Debug("removed old reservoir samples", slog.Int("removed", removed))
+	}
+	return nil
+}
+
+// ShadowReport compares the decisions of a reservoir filter in shadow mode with
+// those of the Bayesian filter, and with junk/nonjunk markings of messages after
+// delivery.
+type ShadowReport struct {
+	Since    time.Time
+	Messages int // Received since Since, with a shadow classification.
+
+	// Decisions by the Bayesian filter, used for delivery, against the decisions the
+	// reservoir filter would have made.
+	BothJunk          int
+	BothNonjunk       int
+	OnlyBayesianJunk  int
+	OnlyReservoirJunk int
+
+	// Messages with a junk or nonjunk flag set after delivery, e.g. by moving a
+	// message to or from the Junk mailbox. Flags set during delivery, e.g. with
+	// AutomaticJunkFlags in the account configuration, are not a judgement by the
+	// user, and not counted. The decisions of each filter are compared against the
+	// flags.
+	Marked    int
+	Bayesian  ShadowMatrix
+	Reservoir ShadowMatrix
+}
+
+// ShadowMatrix is a confusion matrix of junk decisions against junk/nonjunk
+// flags. Junk is positive.
+type ShadowMatrix struct {
+	TruePositives  int
+	FalsePositives int
+	TrueNegatives  int
+	FalseNegatives int
+}
+
+func (sm *ShadowMatrix) add(decidedJunk, markedJunk bool) {
+	switch {
+	case decidedJunk && markedJunk:
+		sm.TruePositives++
+	case decidedJunk:
+		sm.FalsePositives++
+	case markedJunk:
+		sm.FalseNegatives++
+	default:
+		sm.TrueNegatives++
+	}
+}
+
+// ShadowReport returns a report for messages received since the given time that
+// were classified with a reservoir filter in shadow mode.
+func (a *Account) ShadowReport(ctx context.Context, since time.Time) (ShadowReport, error) {
+	r := ShadowReport{Since: since}
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Message](tx)
+		q.FilterEqual("Expunged", false)
+		q.FilterGreaterEqual("Received", since)
+		return q.ForEach(func(m Message) error {
+			c := m.Classification
+			if c == nil || c.Reservoir == nil || !c.Reservoir.Shadow {
+				return nil
+			}
+			r.Messages++
+			rjunk := c.Reservoir.Junk
+			switch {
+			case c.Junk && rjunk:
+				r.BothJunk++
+			case c.Junk:
+				r.OnlyBayesianJunk++
+			case rjunk:
+				r.OnlyReservoirJunk++
+			default:
+				r.BothNonjunk++
+			}
+			// Only flags changed since delivery.
+			df := c.DeliveryFlags
+			if m.Junk == m.Notjunk || df == nil || df.Junk == m.Junk && df.Notjunk == m.Notjunk {
+				return nil
+			}
+			r.Marked++
+			r.Bayesian.add(c.Junk, m.Junk)
+			r.Reservoir.add(rjunk, m.Junk)
+			return nil
+		})
+	})
+	return r, err
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/reservoir"
+)
+
+func TestReservoirTraining(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	deliver := func(subject string) Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "reservoir-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, msgFile, "temp message file")
+		msg := "From: <remote@example.org>\r\nTo: <mjl@mox.example>\r\nSubject: " + subject + "\r\n\r\n" + subject + "\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver")
+		return m
+	}
+
+	retrain := func(m *Message, junk, notjunk bool) {
+		t.Helper()
+		m.Junk = junk
+		m.Notjunk = notjunk
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			l := []Message{*m}
+			err := acc.RetrainMessages(ctxbg, log, tx, l)
+			*m = l[0]
+			return err
+		})
+		tcheck(t, err, "retrain message")
+	}
+
+	checkSamples := func(expect int) {
+		t.Helper()
+		n, err := bstore.QueryDB[ReservoirSample](ctxbg, acc.DB).Count()
+		tcheck(t, err, "count samples")
+		tcompare(t, n, expect)
+	}
+
+	checkTrained := func(expect bool) {
+		t.Helper()
+		// Training normally happens in the background.
+		err := acc.trainReservoirFilter(ctxbg, log)
+		tcheck(t, err, "train reservoir filter")
+		trained := func() {
+			t.Helper()
+			err := acc.WithReservoirFilter(ctxbg, log, func(rf *reservoir.ReservoirFilter, rfc *config.ReservoirFilter) error {
+				tcompare(t, rf.ESN().Trained(), expect)
+				return nil
+			})
+			tcheck(t, err, "reservoir filter")
+		}
+		trained()
+
+		// Reopen, training must have been saved.
+		ar := acc.accountReservoir()
+		ar.Lock()
+		ar.rf = nil
+		ar.Unlock()
+		trained()
+	}
+
+	m0 := deliver("FREE money, click now, act now!!! $$$ winner prize")
+	m1 := deliver("Hi, thanks for the notes of the meeting. Regards")
+	m2 := deliver("Urgent: buy cheap pills at our pharmacy")
+
+	checkTrained(false)
+
+	// Only junk, no training possible yet.
+	retrain(&m0, true, false)
+	checkSamples(1)
+	checkTrained(false)
+
+	retrain(&m1, false, true)
+	checkSamples(2)
+	checkTrained(true)
+
+	// MaxSamples is 2 in the config, the oldest sample is removed, leaving only nonjunk.
+	retrain(&m2, false, true)
+	checkSamples(2)
+	checkTrained(false)
+
+	// Changing the classification replaces the sample.
+	retrain(&m2, true, false)
+	checkSamples(2)
+	checkTrained(true)
+
+	// Untraining removes the sample.
+	retrain(&m1, false, false)
+	checkSamples(1)
+	checkTrained(false)
+}
+
+func TestReservoirSender(t *testing.T) {
+	m := Message{MsgFromOrgDomain: "example.org", RemoteIPMasked2: "192.0.2.0"}
+	mv := m
+	mv.MsgFromValidated = true
+
+	test := func(sequence string, m Message, expect string) {
+		t.Helper()
+		params := reservoir.FilterConfig{SenderSequence: sequence}
+		tcompare(t, ReservoirSender(params, m), expect)
+	}
+	test("none", mv, "")
+	test("orgdomain", mv, "domain:example.org")
+	test("orgdomain", m, "ip:192.0.2.0")
+	test("orgdomain", Message{}, "")
+	test("ip", mv, "ip:192.0.2.0")
+}
+
+func TestReservoirMessage(t *testing.T) {
+	// Reputation at delivery is used for training samples.
+	tcompare(t, ReservoirMessage(Message{}, nil, nil).Auth.Reputation, (*bool)(nil))
+	junk := true
+	m := Message{Classification: &Classification{Reservoir: &ReservoirClassification{Reputation: &junk}}}
+	tcompare(t, ReservoirMessage(m, nil, nil).Auth.Reputation, &junk)
+}
+
+func TestShadowReport(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	deliver := func(c *Classification, flags Flags) Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "shadow-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, msgFile, "temp message file")
+		msg := "Subject: test\r\n\r\ntest\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg)), Flags: flags, Classification: c}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver")
+		return m
+	}
+	// Flags changed after delivery, like a user would.
+	mark := func(m Message, junk, notjunk bool) {
+		t.Helper()
+		m.Junk, m.Notjunk = junk, notjunk
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			if err := tx.Update(&m); err != nil {
+				return err
+			}
+			return acc.RetrainMessages(ctxbg, log, tx, []Message{m})
+		})
+		tcheck(t, err, "update flags")
+	}
+	shadow := func(bayesianJunk, reservoirJunk bool) *Classification {
+		return &Classification{Junk: bayesianJunk, Reservoir: &ReservoirClassification{Junk: reservoirJunk, Shadow: true}}
+	}
+
+	deliver(nil, Flags{})                                                    // Not classified.
+	deliver(&Classification{Reservoir: &ReservoirClassification{}}, Flags{}) // Not shadow.
+	mark(deliver(shadow(false, false), Flags{}), false, true)
+	mark(deliver(shadow(false, true), Flags{Notjunk: true}), true, false)
+	mark(deliver(shadow(true, false), Flags{}), false, true)
+	deliver(shadow(true, true), Flags{Junk: true}) // Flag set during delivery, not counted.
+
+	r, err := acc.ShadowReport(ctxbg, time.Now().Add(-time.Hour))
+	tcheck(t, err, "shadow report")
+	tcompare(t, r.Messages, 4)
+	tcompare(t, []int{r.BothJunk, r.BothNonjunk, r.OnlyBayesianJunk, r.OnlyReservoirJunk}, []int{1, 1, 1, 1})
+	tcompare(t, r.Marked, 3)
+	tcompare(t, r.Bayesian, ShadowMatrix{TrueNegatives: 1, FalseNegatives: 1, FalsePositives: 1})
+	tcompare(t, r.Reservoir, ShadowMatrix{TrueNegatives: 2, TruePositives: 1})
+
+	r, err = acc.ShadowReport(ctxbg, time.Now().Add(time.Hour))
+	tcheck(t, err, "shadow report")
+	tcompare(t, r.Messages, 0)
+}
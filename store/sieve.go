@@ -0,0 +1,360 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/sieve"
+)
+
+var (
+	ErrSieveInvalid = errors.New("invalid sieve script")
+	ErrSieveActive  = errors.New("sieve script is active")
+	ErrSieveExists  = errors.New("sieve script already exists")
+	ErrSieveQuota   = errors.New("sieve script quota exceeded")
+)
+
+// Limits for Sieve scripts of an account.
+const (
+	SieveScriptMaxSize = 64 * 1024
+	SieveScriptsMax    = 100
+)
+
+// SieveScript is a Sieve script for filtering incoming messages. At most one
+// script of an account is active, and evaluated during delivery. Other scripts
+// can be included by the active script. ../rfc/5228
+type SieveScript struct {
+	ID      int64
+	Name    string `bstore:"nonzero,unique"`
+	Content string
+	Active  bool
+	Created time.Time `bstore:"nonzero,default now"`
+	Updated time.Time `bstore:"nonzero,default now"`
+}
+
+// SieveVacation records a vacation response sent to an address, for not sending
+// responses to the same sender more often than configured in the script.
+type SieveVacation struct {
+	ID     int64
+	Handle string    `bstore:"nonzero,unique Handle+Sender"` // Identifies the vacation action.
+	Sender string    `bstore:"nonzero"`                      // Address the response was sent to.
+	Sent   time.Time `bstore:"nonzero,index"`
+}
+
+// SieveScripts returns the Sieve scripts of the account, sorted by name.
+func (a *Account) SieveScripts(ctx context.Context) ([]SieveScript, error) {
+	q := bstore.QueryDB[SieveScript](ctx, a.DB)
+	q.SortAsc("Name")
+	return q.List()
+}
+
+// SieveScriptGet returns a script by name, or bstore.ErrAbsent.
+func (a *Account) SieveScriptGet(ctx context.Context, name string) (SieveScript, error) {
+	q := bstore.QueryDB[SieveScript](ctx, a.DB)
+	q.FilterNonzero(SieveScript{Name: name})
+	return q.Get()
+}
+
+// SieveScriptCheck parses and checks a script, returning an error wrapping
+// ErrSieveInvalid if the script cannot be used, or ErrSieveQuota if it is too
+// large.
+func SieveScriptCheck(name, content string) error {
+	if err := sieve.CheckScriptName(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrSieveInvalid, err)
+	}
+	if len(content) > SieveScriptMaxSize {
+		return fmt.Errorf("%w: script larger than maximum size %d", ErrSieveQuota, SieveScriptMaxSize)
+	}
+	if _, err := sieve.Parse(content); err != nil {
+		return fmt.Errorf("%w: %v", ErrSieveInvalid, err)
+	}
+	return nil
+}
+
+// SieveScriptSave validates a script and adds it, or replaces the content of an
+// existing script with the same name.
+func (a *Account) SieveScriptSave(ctx context.Context, name, content string) (SieveScript, error) {
+	if err := SieveScriptCheck(name, content); err != nil {
+		return SieveScript{}, err
+	}
+
+	var ss SieveScript
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SieveScript](tx)
+		q.FilterNonzero(SieveScript{Name: name})
+		var err error
+		ss, err = q.Get()
+		if err == bstore.ErrAbsent {
+			n, err := bstore.QueryTx[SieveScript](tx).Count()
+			if err != nil {
+				return fmt.Errorf("counting scripts: %v", err)
+			} else if n >= SieveScriptsMax {
+				return fmt.Errorf("%w: maximum number of scripts %d reached", ErrSieveQuota, SieveScriptsMax)
+			}
+			ss = SieveScript{Name: name, Content: content}
+			return tx.Insert(&ss)
+		} else if err != nil {
+			return fmt.Errorf("looking up script: %v", err)
+		}
+		ss.Content = content
+		ss.Updated = time.Now()
+		return tx.Update(&ss)
+	})
+	return ss, err
+}
+
+// SieveScriptRename changes the name of a script. If the script does not exist,
+// bstore.ErrAbsent is returned. If a script with the new name already exists,
+// ErrSieveExists is returned.
+func (a *Account) SieveScriptRename(ctx context.Context, oldName, newName string) error {
+	if err := sieve.CheckScriptName(newName); err != nil {
+		return fmt.Errorf("%w: %v", ErrSieveInvalid, err)
+	}
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SieveScript](tx)
+		q.FilterNonzero(SieveScript{Name: oldName})
+		ss, err := q.Get()
+		if err != nil {
+			return err
+		}
+		if oldName == newName {
+			return nil
+		}
+		q = bstore.QueryTx[SieveScript](tx)
+		q.FilterNonzero(SieveScript{Name: newName})
+		if exists, err := q.Exists(); err != nil {
+			return fmt.Errorf("looking up script: %v", err)
+		} else if exists {
+			return ErrSieveExists
+		}
+		ss.Name = newName
+		ss.Updated = time.Now()
+		return tx.Update(&ss)
+	})
+}
+
+// SieveScriptActivate makes the script with name the active script, deactivating
+// any other script. If name is empty, all scripts are deactivated.
+func (a *Account) SieveScriptActivate(ctx context.Context, name string) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if name != "" {
+			q := bstore.QueryTx[SieveScript](tx)
+			q.FilterNonzero(SieveScript{Name: name})
+			if _, err := q.Get(); err != nil {
+				return err
+			}
+		}
+
+		q := bstore.QueryTx[SieveScript](tx)
+		q.FilterEqual("Active", true)
+		if _, err := q.UpdateField("Active", false); err != nil {
+			return fmt.Errorf("deactivating scripts: %v", err)
+		}
+		if name == "" {
+			return nil
+		}
+		q = bstore.QueryTx[SieveScript](tx)
+		q.FilterNonzero(SieveScript{Name: name})
+		_, err := q.UpdateField("Active", true)
+		return err
+	})
+}
+
+// SieveScriptDelete removes a script. The active script cannot be removed, it
+// must be deactivated first. If the script does not exist, bstore.ErrAbsent is
+// returned.
+func (a *Account) SieveScriptDelete(ctx context.Context, name string) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SieveScript](tx)
+		q.FilterNonzero(SieveScript{Name: name})
+		ss, err := q.Get()
+		if err != nil {
+			return err
+		}
+		if ss.Active {
+			return ErrSieveActive
+		}
+		return tx.Delete(&ss)
+	})
+}
+
+// SieveEvaluate evaluates the active Sieve script of the account for the message
+// in m and msgFile. If the account has no active script, nil is returned. Personal
+// scripts can be included, there are no global scripts.
+//
+// Errors during evaluation are logged, and result in an implicit keep.
+func (a *Account) SieveEvaluate(log mlog.Log, m *Message, msgFile *os.File) *sieve.Result {
+	ctx := context.TODO()
+
+	q := bstore.QueryDB[SieveScript](ctx, a.DB)
+	q.FilterEqual("Active", true)
+	ss, err := q.Get()
+	if err == bstore.ErrAbsent {
+		return nil
+	} else if err != nil {
+		log.Errorx("looking up active sieve script, continuing with implicit keep", err)
+		return &sieve.Result{Keep: true}
+	}
+	log = log.With(slog.String("sievescript", ss.Name))
+
+	script, err := sieve.Parse(ss.Content)
+	if err != nil {
+		// Scripts are checked when saved, should not happen.
+		log.Errorx("parsing active sieve script, continuing with implicit keep", err)
+		return &sieve.Result{Keep: true}
+	}
+
+	mr := FileMsgReader(m.MsgPrefix, msgFile) // We don't close, it would close the msgFile.
+	p, err := message.EnsurePart(log.Logger, false, mr, m.Size)
+	if err != nil {
+		log.Infox("parsing message for evaluating sieve script, continuing with fallback part", err)
+	}
+	header, err := p.Header()
+	if err != nil {
+		log.Infox("parsing message header for evaluating sieve script, continuing with implicit keep", err)
+		return &sieve.Result{Keep: true}
+	}
+
+	input := sieve.Input{
+		MailFrom: m.MailFrom,
+		Header:   header,
+		Part:     &p,
+		Size:     m.Size,
+	}
+	if m.RcptToLocalpart != "" || m.RcptToDomain != "" {
+		input.RcptTo = m.RcptToLocalpart.String() + "@" + m.RcptToDomain
+	}
+	loader := func(name string, global bool) (*sieve.Script, error) {
+		if global {
+			return nil, sieve.ErrScriptNotFound
+		}
+		ss, err := a.SieveScriptGet(ctx, name)
+		if err == bstore.ErrAbsent {
+			return nil, sieve.ErrScriptNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return sieve.Parse(ss.Content)
+	}
+	r, err := sieve.Evaluate(script, input, loader)
+	if err != nil {
+		log.Infox("evaluating sieve script, continuing with implicit keep", err)
+	}
+	return &r
+}
+
+// DeliverSieve delivers a message according to the result of a Sieve script: to
+// mailbox for a keep, and to the mailbox of each fileinto action, with the flags
+// and keywords from the script added. The message is delivered as m to the first
+// mailbox, and as copies to the other mailboxes. If a fileinto mailbox cannot be
+// delivered to, e.g. due to an invalid name, the message is delivered to mailbox
+// instead.
+//
+// The mailboxes the message was delivered to are returned, none for a discard or
+// reject. Other actions in the result, e.g. redirect and vacation, are the
+// responsibility of the caller.
+//
+// Returns ErrOverQuota when account would be over quota after adding message.
+//
+// Caller must hold account wlock.
+func (a *Account) DeliverSieve(log mlog.Log, r *sieve.Result, mailbox string, m *Message, msgFile *os.File) (delivered []string, rerr error) {
+	type target struct {
+		mailbox string
+		flags   []string
+	}
+	var targets []target
+	if r.Keep {
+		targets = append(targets, target{mailbox, r.KeepFlags})
+	}
+	for _, fi := range r.FileInto {
+		name, _, err := CheckMailboxName(fi.Mailbox, true)
+		if err != nil {
+			log.Infox("invalid mailbox in sieve fileinto, delivering to default mailbox", err, slog.String("mailbox", fi.Mailbox))
+			name = mailbox
+		}
+		targets = append(targets, target{name, fi.Flags})
+	}
+
+	orig := *m
+	for _, t := range targets {
+		if slices.Contains(delivered, t.mailbox) {
+			continue
+		}
+
+		dm := m
+		if len(delivered) > 0 {
+			mc := orig
+			mc.ID = 0
+			mc.UID = 0
+			mc.MailboxID = 0
+			mc.ModSeq = 0
+			mc.CreateSeq = 0
+			dm = &mc
+		}
+		dm.Flags = orig.Flags
+		dm.Keywords = orig.Keywords
+		for _, fl := range t.flags {
+			flags, keywords, err := ParseFlagsKeywords([]string{fl})
+			if err != nil {
+				log.Infox("ignoring invalid flag from sieve script", err, slog.String("flag", fl))
+				continue
+			}
+			dm.Flags = dm.Flags.Set(flags, flags)
+			dm.Keywords, _ = MergeKeywords(dm.Keywords, keywords)
+		}
+
+		err := a.DeliverMailbox(log, t.mailbox, dm, msgFile)
+		if err != nil && !errors.Is(err, ErrOverQuota) && t.mailbox != mailbox && !slices.Contains(delivered, mailbox) {
+			log.Errorx("delivering to mailbox from sieve fileinto, delivering to default mailbox", err, slog.String("mailbox", t.mailbox))
+			t.mailbox = mailbox
+			err = a.DeliverMailbox(log, t.mailbox, dm, msgFile)
+		}
+		if err != nil {
+			return delivered, err
+		}
+		delivered = append(delivered, t.mailbox)
+	}
+	return delivered, nil
+}
+
+// SieveVacationDue returns whether a vacation response for handle should be sent
+// to sender, i.e. no response was sent in the past days, and if so records the
+// response as sent. Records older than a year are removed.
+func (a *Account) SieveVacationDue(ctx context.Context, handle, sender string, days int) (due bool, rerr error) {
+	sender = strings.ToLower(sender)
+	now := time.Now()
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SieveVacation](tx)
+		q.FilterLess("Sent", now.Add(-366*24*time.Hour))
+		if _, err := q.Delete(); err != nil {
+			return fmt.Errorf("removing old vacation records: %v", err)
+		}
+
+		q = bstore.QueryTx[SieveVacation](tx)
+		q.FilterNonzero(SieveVacation{Handle: handle, Sender: sender})
+		sv, err := q.Get()
+		if err == bstore.ErrAbsent {
+			due = true
+			return tx.Insert(&SieveVacation{Handle: handle, Sender: sender, Sent: now})
+		} else if err != nil {
+			return fmt.Errorf("looking up vacation record: %v", err)
+		}
+		if now.Sub(sv.Sent) < time.Duration(days)*24*time.Hour {
+			return nil
+		}
+		due = true
+		sv.Sent = now
+		return tx.Update(&sv)
+	})
+	return due, err
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestSieve(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+	conf, _ := acc.Conf()
+
+	_, err = acc.SieveScriptSave(ctxbg, "bad", "bogus;")
+	if !errors.Is(err, ErrSieveInvalid) {
+		t.Fatalf("got err %v, expected ErrSieveInvalid", err)
+	}
+	_, err = acc.SieveScriptSave(ctxbg, "bad/name", "keep;")
+	if !errors.Is(err, ErrSieveInvalid) {
+		t.Fatalf("got err %v, expected ErrSieveInvalid", err)
+	}
+
+	_, err = acc.SieveScriptSave(ctxbg, "lists", `require "fileinto"; fileinto "Lists";`)
+	tcheck(t, err, "save script")
+	_, err = acc.SieveScriptSave(ctxbg, "main", `discard;`)
+	tcheck(t, err, "save script")
+	_, err = acc.SieveScriptSave(ctxbg, "main", `require ["include", "imap4flags", "fileinto", "copy"]; if header :contains "subject" "list" { include "lists"; } elsif header :contains "subject" "drop" { discard; } else { addflag "\\Flagged $label"; fileinto :copy "INBOX"; }`)
+	tcheck(t, err, "update script")
+
+	l, err := acc.SieveScripts(ctxbg)
+	tcheck(t, err, "list scripts")
+	tcompare(t, len(l), 2)
+	tcompare(t, l[0].Name, "lists")
+
+	err = acc.SieveScriptRename(ctxbg, "lists", "main")
+	tcompare(t, err, ErrSieveExists)
+	err = acc.SieveScriptRename(ctxbg, "absent", "other")
+	tcompare(t, err, bstore.ErrAbsent)
+	err = acc.SieveScriptRename(ctxbg, "lists", "lists2")
+	tcheck(t, err, "rename script")
+	err = acc.SieveScriptRename(ctxbg, "lists2", "lists")
+	tcheck(t, err, "rename script")
+
+	err = acc.SieveScriptActivate(ctxbg, "absent")
+	tcompare(t, err, bstore.ErrAbsent)
+	err = acc.SieveScriptActivate(ctxbg, "lists")
+	tcheck(t, err, "activate script")
+	err = acc.SieveScriptActivate(ctxbg, "main")
+	tcheck(t, err, "activate script")
+	l, err = acc.SieveScripts(ctxbg)
+	tcheck(t, err, "list scripts")
+	tcompare(t, []bool{l[0].Active, l[1].Active}, []bool{false, true})
+
+	err = acc.SieveScriptDelete(ctxbg, "main")
+	tcompare(t, err, ErrSieveActive)
+
+	deliver := func(subject string, expMailboxes ...string) []Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "sieve-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, msgFile, "temp message file")
+		msg := "From: <remote@example.org>\r\nTo: <mjl@mox.example>\r\nSubject: " + subject + "\r\n\r\n" + subject + "\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg)), MsgPrefix: []byte{}}
+		acc.WithWLock(func() {
+			err = acc.DeliverDestination(log, conf.Destinations["mjl@mox.example"], &m, msgFile)
+		})
+		tcheck(t, err, "deliver")
+
+		var ml []Message
+		var mailboxes []string
+		for _, mbname := range []string{"Inbox", "Lists"} {
+			mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: mbname}).Get()
+			if err == bstore.ErrAbsent {
+				continue
+			}
+			tcheck(t, err, "get mailbox")
+			q := bstore.QueryDB[Message](ctxbg, acc.DB)
+			q.FilterNonzero(Message{MailboxID: mb.ID, Size: m.Size})
+			q.FilterEqual("Expunged", false)
+			q.FilterFn(func(xm Message) bool { return xm.Received.Equal(m.Received) })
+			xl, err := q.List()
+			tcheck(t, err, "list messages")
+			for range xl {
+				mailboxes = append(mailboxes, mbname)
+			}
+			ml = append(ml, xl...)
+		}
+		tcompare(t, mailboxes, expMailboxes)
+		return ml
+	}
+
+	ml := deliver("a list message", "Lists")
+	tcompare(t, ml[0].Flagged, false)
+	deliver("drop this")
+	ml = deliver("other", "Inbox")
+	tcompare(t, ml[0].Flagged, true)
+	tcompare(t, ml[0].Keywords, []string{"$label"})
+
+	err = acc.SieveScriptActivate(ctxbg, "")
+	tcheck(t, err, "deactivate scripts")
+	deliver("drop this", "Inbox")
+	err = acc.SieveScriptDelete(ctxbg, "main")
+	tcheck(t, err, "delete script")
+
+	due, err := acc.SieveVacationDue(ctxbg, "h", "Remote@example.org", 7)
+	tcheck(t, err, "vacation due")
+	tcompare(t, due, true)
+	due, err = acc.SieveVacationDue(ctxbg, "h", "remote@example.org", 7)
+	tcheck(t, err, "vacation due")
+	tcompare(t, due, false)
+	due, err = acc.SieveVacationDue(ctxbg, "other", "remote@example.org", 7)
+	tcheck(t, err, "vacation due")
+	tcompare(t, due, true)
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"time"
+)
+
+// EmailSubmission records a message submitted for delivery through JMAP. The
+// message is added to the queue for each recipient. The delivery status is
+// tracked by the queue, the EmailSubmission only references the queued messages.
+// ../rfc/8621:3709
+type EmailSubmission struct {
+	ID int64
+
+	// Like for Message. Only creating, canceling and destroying a submission changes
+	// the ModSeq, not progress of delivery.
+	CreateSeq ModSeq
+	ModSeq    ModSeq `bstore:"index"`
+	Expunged  bool
+
+	IdentityID string // JMAP identity used for sending.
+	MessageID  int64  // Submitted Message.ID, the message may have been removed since.
+	ThreadID   int64  // ThreadID of message at time of submission.
+
+	MailFrom    string   // SMTP MAIL FROM address.
+	RcptTo      []string // SMTP RCPT TO addresses.
+	QueueMsgIDs []int64  // Queue message IDs, one for each address in RcptTo.
+
+	SendAt   time.Time // Time of submission, or later when delivery was scheduled for the future.
+	Canceled bool      // Whether delivery was canceled before the first attempt.
+}
@@ -133,6 +133,10 @@ func (a *Account) RetrainMessage(ctx context.Context, log mlog.Log, tx *bstore.T
 		return nil
 	}
 
+	if err := a.retrainReservoirSample(log, tx, m, &p, words, untrain, train, trainJunk); err != nil {
+		return err
+	}
+
 	if untrain {
 		err := jf.Untrain(ctx, !untrainJunk, words)
 		if err != nil {
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+var ErrVacationInvalid = errors.New("invalid vacation settings")
+
+// Maximum size of the subject and of each body of a vacation response.
+const VacationMaxSize = 64 * 1024
+
+// VacationHandle is the handle in SieveVacation records for responses sent for the
+// vacation settings of the account.
+const VacationHandle = "mox-account-vacation"
+
+// Vacation holds the settings for automatically responding to incoming messages,
+// e.g. while out of office. Responses are only sent while enabled and within the
+// optional date range. ../rfc/3834
+type Vacation struct {
+	ID uint8 // Singleton ID 1.
+
+	Enabled bool
+	Start   time.Time // If not zero, no responses are sent before this time.
+	End     time.Time // If not zero, no responses are sent after this time.
+
+	Subject string // If empty, the subject of the incoming message is used with an "Auto: " prefix.
+	Text    string // Plain text body. At least one of Text and HTML must be set when enabled.
+	HTML    string // Optional HTML body, sent as alternative to Text.
+
+	// Additional addresses of the account, besides the address the message was
+	// delivered to. Only messages addressed to one of these addresses in the To or Cc
+	// header get a response.
+	Addresses []string
+
+	// Minimum number of days between responses to the same sender.
+	Days int
+}
+
+// Active returns whether responses should be sent at time now.
+func (v Vacation) Active(now time.Time) bool {
+	return v.Enabled && (v.Start.IsZero() || !now.Before(v.Start)) && (v.End.IsZero() || now.Before(v.End))
+}
+
+// Check returns an error wrapping ErrVacationInvalid if the settings are not
+// valid.
+func (v Vacation) Check() error {
+	if v.Enabled && strings.TrimSpace(v.Text) == "" && strings.TrimSpace(v.HTML) == "" {
+		return fmt.Errorf("%w: text or html body required", ErrVacationInvalid)
+	}
+	if len(v.Subject) > VacationMaxSize || len(v.Text) > VacationMaxSize || len(v.HTML) > VacationMaxSize {
+		return fmt.Errorf("%w: subject or body larger than maximum size %d", ErrVacationInvalid, VacationMaxSize)
+	}
+	if !v.Start.IsZero() && !v.End.IsZero() && !v.Start.Before(v.End) {
+		return fmt.Errorf("%w: end must be after start", ErrVacationInvalid)
+	}
+	if v.Days < 1 || v.Days > 365 {
+		return fmt.Errorf("%w: days between responses must be between 1 and 365", ErrVacationInvalid)
+	}
+	for _, s := range v.Addresses {
+		if _, err := smtp.ParseAddress(s); err != nil {
+			return fmt.Errorf("%w: parsing address %q: %v", ErrVacationInvalid, s, err)
+		}
+	}
+	return nil
+}
+
+// VacationGet returns the vacation settings of the account. If none were saved
+// yet, disabled settings with a 7 day interval are returned.
+func (a *Account) VacationGet(ctx context.Context) (Vacation, error) {
+	v := Vacation{ID: 1}
+	err := a.DB.Get(ctx, &v)
+	if err == bstore.ErrAbsent {
+		return Vacation{ID: 1, Days: 7}, nil
+	}
+	return v, err
+}
+
+// VacationSave validates and stores the vacation settings. Records of responses
+// sent for previous settings are removed, so senders get a response for the new
+// settings.
+func (a *Account) VacationSave(ctx context.Context, v Vacation) error {
+	v.ID = 1
+	if err := v.Check(); err != nil {
+		return err
+	}
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SieveVacation](tx)
+		q.FilterNonzero(SieveVacation{Handle: VacationHandle})
+		if _, err := q.Delete(); err != nil {
+			return fmt.Errorf("removing vacation records: %v", err)
+		}
+
+		if err := tx.Get(&Vacation{ID: 1}); err == bstore.ErrAbsent {
+			return tx.Insert(&v)
+		} else if err != nil {
+			return fmt.Errorf("looking up vacation settings: %v", err)
+		}
+		return tx.Update(&v)
+	})
+}
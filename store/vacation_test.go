@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestVacation(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	v, err := acc.VacationGet(ctxbg)
+	tcheck(t, err, "get vacation")
+	tcompare(t, v, Vacation{ID: 1, Days: 7})
+
+	invalid := func(v Vacation) {
+		t.Helper()
+		err := acc.VacationSave(ctxbg, v)
+		if !errors.Is(err, ErrVacationInvalid) {
+			t.Fatalf("got err %v, expected ErrVacationInvalid", err)
+		}
+	}
+	now := time.Now()
+	invalid(Vacation{Enabled: true, Days: 7})
+	invalid(Vacation{Enabled: true, Text: "away", Days: 0})
+	invalid(Vacation{Enabled: true, Text: "away", Days: 7, Start: now, End: now.Add(-time.Hour)})
+	invalid(Vacation{Enabled: true, Text: "away", Days: 7, Addresses: []string{"bogus"}})
+
+	due, err := acc.SieveVacationDue(ctxbg, VacationHandle, "remote@example.org", 7)
+	tcheck(t, err, "vacation due")
+	tcompare(t, due, true)
+
+	v = Vacation{Enabled: true, Text: "away", Days: 7, Start: now.Add(-time.Hour).Round(0), End: now.Add(time.Hour).Round(0), Addresses: []string{"mjl@other.example"}}
+	err = acc.VacationSave(ctxbg, v)
+	tcheck(t, err, "save vacation")
+	xv, err := acc.VacationGet(ctxbg)
+	tcheck(t, err, "get vacation")
+	tcompare(t, xv.Active(now), true)
+	tcompare(t, xv.Active(now.Add(2*time.Hour)), false)
+	tcompare(t, xv.Active(now.Add(-2*time.Hour)), false)
+
+	// Saving resets the responses sent.
+	due, err = acc.SieveVacationDue(ctxbg, VacationHandle, "remote@example.org", 7)
+	tcheck(t, err, "vacation due")
+	tcompare(t, due, true)
+}
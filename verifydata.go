@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -17,10 +18,12 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/greylist"
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/reservoir"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
 )
@@ -241,6 +244,18 @@ possibly making them potentially no longer readable by the previous version.
 		}
 		// todo: add some kind of check for the bloom filter?
 
+		rfmodelpath := filepath.Join(accdir, "reservoirfilter.model")
+		if exists(rfmodelpath) {
+			f, err := os.Open(rfmodelpath)
+			checkf(err, rfmodelpath, "opening reservoir filter model")
+			if err == nil {
+				err := reservoir.CheckModel(bufio.NewReader(f))
+				checkf(err, rfmodelpath, "checking reservoir filter model")
+				err = f.Close()
+				checkf(err, rfmodelpath, "closing reservoir filter model")
+			}
+		}
+
 		// Check that all messages in the database have a message file on disk.
 		// And check consistency of UIDs with the mailbox UIDNext, and check UIDValidity.
 		seen := map[string]struct{}{}
@@ -431,7 +446,7 @@ possibly making them potentially no longer readable by the previous version.
 				p = p[len(dataDir)+1:]
 			}
 			switch p {
-			case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "lastknownversion":
+			case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "greylist.db", "receivedid.key", "lastknownversion":
 				return nil
 			case "acme", "queue", "accounts", "tmp", "moved":
 				return fs.SkipDir
@@ -455,6 +470,7 @@ possibly making them potentially no longer readable by the previous version.
 	checkDB(true, filepath.Join(dataDir, "mtasts.db"), mtastsdb.DBTypes)
 	checkDB(true, filepath.Join(dataDir, "tlsrpt.db"), tlsrptdb.ReportDBTypes)
 	checkDB(false, filepath.Join(dataDir, "tlsrptresult.db"), tlsrptdb.ResultDBTypes) // After v0.0.7.
+	checkDB(false, filepath.Join(dataDir, "greylist.db"), greylist.DBTypes)
 	checkQueue()
 	checkAccounts()
 	checkOther()
@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -104,7 +105,7 @@ func Handler(cookiePath string, isForwarded bool) func(w http.ResponseWriter, r
 			http.Error(w, "500 - internal server error - cannot handle requests", http.StatusInternalServerError)
 			return
 		}
-		handle(sh, isForwarded, w, r)
+		handle(sh, isForwarded, cookiePath, w, r)
 	}
 }
 
@@ -145,7 +146,7 @@ type Account struct {
 	isForwarded bool   // From listener, whether we look at X-Forwarded-* headers.
 }
 
-func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r *http.Request) {
+func handle(apiHandler http.Handler, isForwarded bool, cookiePath string, w http.ResponseWriter, r *http.Request) {
 	ctx := context.WithValue(r.Context(), mlog.CidKey, mox.Cid())
 	log := pkglog.WithContext(ctx).With(slog.String("userauth", ""))
 
@@ -215,6 +216,13 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
 		}
 		return
+	} else if r.URL.Path == "/oidc/login" {
+		// Login through an identity provider, redirects to it.
+		webauth.OIDCLogin(ctx, log, "webaccount", cookiePath, isForwarded, w, r)
+		return
+	} else if r.URL.Path == "/oidc/callback" {
+		webauth.OIDCCallback(ctx, log, "webaccount", cookiePath, isForwarded, w, r)
+		return
 	} else if r.URL.Path == "/licenses.txt" {
 		switch r.Method {
 		case "GET", "HEAD":
@@ -235,7 +243,7 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	var loginAddress, accName string
 	var sessionToken store.SessionToken
 	// All other URLs, except the login endpoint require some authentication.
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/LoginOIDCEnabled" {
 		var ok bool
 		isExport := r.URL.Path == "/export"
 		requireCSRF := isAPI || r.URL.Path == "/import" || isExport
@@ -360,6 +368,12 @@ func (w Account) Login(ctx context.Context, loginToken, username, password strin
 	return csrfToken
 }
 
+// LoginOIDCEnabled returns whether users can login through an OpenID Connect
+// identity provider, by opening oidc/login.
+func (Account) LoginOIDCEnabled(ctx context.Context) bool {
+	return webauth.OIDCEnabled()
+}
+
 // Logout invalidates the session token.
 func (w Account) Logout(ctx context.Context) {
 	log := pkglog.WithContext(ctx)
@@ -823,3 +837,153 @@ func (Account) IMAPSave(ctx context.Context, capabilitiesDisabled []string) {
 	})
 	xcheckf(ctx, err, "saving disabled imap capabilities")
 }
+
+// xopenAccount opens the account of the request, to be closed by the caller.
+func xopenAccount(ctx context.Context) *store.Account {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	return acc
+}
+
+func xcloseAccount(ctx context.Context, acc *store.Account) {
+	err := acc.Close()
+	pkglog.WithContext(ctx).Check(err, "closing account")
+}
+
+// SieveScripts returns the Sieve scripts of the account, for filtering incoming
+// messages.
+func (Account) SieveScripts(ctx context.Context) []store.SieveScript {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	l, err := acc.SieveScripts(ctx)
+	xcheckf(ctx, err, "listing sieve scripts")
+	return l
+}
+
+// SieveScriptSave adds a Sieve script, or replaces the content of an existing
+// script. The script is checked for errors before it is saved.
+func (Account) SieveScriptSave(ctx context.Context, name, content string) store.SieveScript {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	ss, err := acc.SieveScriptSave(ctx, name, content)
+	if errors.Is(err, store.ErrSieveInvalid) || errors.Is(err, store.ErrSieveQuota) {
+		xcheckuserf(ctx, err, "saving sieve script")
+	}
+	xcheckf(ctx, err, "saving sieve script")
+	return ss
+}
+
+// SieveScriptActivate makes a Sieve script the active script, used during
+// delivery. If name is empty, no script is active.
+func (Account) SieveScriptActivate(ctx context.Context, name string) {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	err := acc.SieveScriptActivate(ctx, name)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "activating sieve script")
+	}
+	xcheckf(ctx, err, "activating sieve script")
+}
+
+// SieveScriptDelete removes a Sieve script. The active script cannot be removed.
+func (Account) SieveScriptDelete(ctx context.Context, name string) {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	err := acc.SieveScriptDelete(ctx, name)
+	if err == bstore.ErrAbsent || errors.Is(err, store.ErrSieveActive) {
+		xcheckuserf(ctx, err, "removing sieve script")
+	}
+	xcheckf(ctx, err, "removing sieve script")
+}
+
+// Vacation returns the vacation settings of the account, for automatically
+// responding to incoming messages.
+func (Account) Vacation(ctx context.Context) store.Vacation {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	v, err := acc.VacationGet(ctx)
+	xcheckf(ctx, err, "looking up vacation settings")
+	return v
+}
+
+// VacationSave saves the vacation settings of the account.
+func (Account) VacationSave(ctx context.Context, v store.Vacation) {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+	err := acc.VacationSave(ctx, v)
+	if errors.Is(err, store.ErrVacationInvalid) {
+		xcheckuserf(ctx, err, "saving vacation settings")
+	}
+	xcheckf(ctx, err, "saving vacation settings")
+}
+
+// MailboxGrant is a grant of IMAP ACL rights on a mailbox of the account to
+// another account.
+type MailboxGrant struct {
+	MailboxName string
+	Grantee     string // Account name, or "anyone" for all accounts.
+	Rights      string // Letters from "lrswipkxtea", see store.ACLRightsAll.
+}
+
+// MailboxGrants returns the grants on mailboxes of the account to other accounts.
+// Grants for removed mailboxes are not returned.
+func (Account) MailboxGrants(ctx context.Context) []MailboxGrant {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+
+	l, err := store.MailboxACLList(ctx, acc.Name, 0)
+	xcheckf(ctx, err, "listing mailbox grants")
+	grants := []MailboxGrant{}
+	err = acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		for _, acl := range l {
+			mb, err := store.MailboxID(tx, acl.MailboxID)
+			if err == bstore.ErrAbsent || err == store.ErrMailboxExpunged {
+				continue
+			} else if err != nil {
+				return err
+			}
+			grants = append(grants, MailboxGrant{mb.Name, acl.Grantee, acl.Rights})
+		}
+		return nil
+	})
+	xcheckf(ctx, err, "looking up mailboxes")
+	slices.SortFunc(grants, func(a, b MailboxGrant) int {
+		if a.MailboxName != b.MailboxName {
+			return strings.Compare(a.MailboxName, b.MailboxName)
+		}
+		return strings.Compare(a.Grantee, b.Grantee)
+	})
+	return grants
+}
+
+// MailboxGrantSave sets the rights for grantee on a mailbox. Grantee can be an
+// account name, an email address of an account, or "anyone". Empty rights remove
+// the grant.
+func (Account) MailboxGrantSave(ctx context.Context, mailboxName, grantee, rights string) {
+	acc := xopenAccount(ctx)
+	defer xcloseAccount(ctx, acc)
+
+	var mb *store.Mailbox
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		var err error
+		mb, err = acc.MailboxFind(tx, mailboxName)
+		return err
+	})
+	xcheckf(ctx, err, "looking up mailbox")
+	if mb == nil {
+		xcheckuserf(ctx, errors.New("mailbox not found"), "looking up mailbox")
+	}
+
+	grantee, err = store.ACLGrantee(grantee)
+	xcheckuserf(ctx, err, "looking up grantee")
+	if grantee == acc.Name {
+		xcheckuserf(ctx, errors.New("account already has all rights to its mailboxes"), "saving mailbox grant")
+	}
+	err = store.MailboxACLSet(ctx, acc.Name, mb.ID, grantee, rights)
+	if errors.Is(err, store.ErrACLRights) {
+		xcheckuserf(ctx, err, "saving mailbox grant")
+	}
+	xcheckf(ctx, err, "saving mailbox grant")
+}
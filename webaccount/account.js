@@ -268,11 +268,11 @@ var api;
 		AuthResult["AuthError"] = "error";
 		AuthResult["AuthAborted"] = "aborted";
 	})(AuthResult = api.AuthResult || (api.AuthResult = {}));
-	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AutomaticJunkFlags": true, "Destination": true, "Domain": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "LoginAttempt": true, "NameAddress": true, "Outgoing": true, "OutgoingWebhook": true, "Route": true, "Ruleset": true, "Structure": true, "SubjectPass": true, "Suppression": true, "TLSPublicKey": true };
+	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AutomaticJunkFlags": true, "Destination": true, "Domain": true, "ESNParams": true, "FilterConfig": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "LoginAttempt": true, "MailboxGrant": true, "NameAddress": true, "Outgoing": true, "OutgoingWebhook": true, "PersonaTrait": true, "ReservoirFilter": true, "Route": true, "Ruleset": true, "SieveScript": true, "Structure": true, "SubjectPass": true, "Suppression": true, "TLSPublicKey": true, "Vacation": true };
 	api.stringsTypes = { "AuthResult": true, "CSRFToken": true, "Localpart": true, "OutgoingEvent": true };
 	api.intsTypes = {};
 	api.types = {
-		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
+		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "ReservoirFilter", "Docs": "", "Typewords": ["nullable", "ReservoirFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "POP3RetrievedMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
 		"OutgoingWebhook": { "Name": "OutgoingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "Events", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IncomingWebhook": { "Name": "IncomingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }] },
 		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "SMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageAuthRequiredSMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
@@ -281,6 +281,10 @@ var api;
 		"SubjectPass": { "Name": "SubjectPass", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
 		"AutomaticJunkFlags": { "Name": "AutomaticJunkFlags", "Docs": "", "Fields": [{ "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "JunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NeutralMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NotJunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }] },
 		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
+		"ReservoirFilter": { "Name": "ReservoirFilter", "Docs": "", "Fields": [{ "Name": "Params", "Docs": "", "Typewords": ["FilterConfig"] }, { "Name": "Shadow", "Docs": "", "Typewords": ["bool"] }] },
+		"FilterConfig": { "Name": "FilterConfig", "Docs": "", "Fields": [{ "Name": "ESNParams", "Docs": "", "Typewords": ["ESNParams"] }, { "Name": "Persona", "Docs": "", "Typewords": ["PersonaTrait"] }, { "Name": "EnableReservoir", "Docs": "", "Typewords": ["bool"] }, { "Name": "EnableAffective", "Docs": "", "Typewords": ["bool"] }, { "Name": "ReservoirWeight", "Docs": "", "Typewords": ["float64"] }, { "Name": "Lexicons", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MembraneDepth", "Docs": "", "Typewords": ["int32"] }, { "Name": "FeatureExtractor", "Docs": "", "Typewords": ["string"] }, { "Name": "FeatureWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxSamples", "Docs": "", "Typewords": ["int32"] }, { "Name": "SenderSequence", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderStates", "Docs": "", "Typewords": ["int32"] }, { "Name": "SenderStateExpiry", "Docs": "", "Typewords": ["int64"] }] },
+		"ESNParams": { "Name": "ESNParams", "Docs": "", "Fields": [{ "Name": "ReservoirSize", "Docs": "", "Typewords": ["int32"] }, { "Name": "SpectralRadius", "Docs": "", "Typewords": ["float64"] }, { "Name": "InputScaling", "Docs": "", "Typewords": ["float64"] }, { "Name": "LeakRate", "Docs": "", "Typewords": ["float64"] }, { "Name": "Sparsity", "Docs": "", "Typewords": ["float64"] }, { "Name": "RidgeParam", "Docs": "", "Typewords": ["float64"] }, { "Name": "TreeDepth", "Docs": "", "Typewords": ["int32"] }, { "Name": "Seed", "Docs": "", "Typewords": ["int64"] }] },
+		"PersonaTrait": { "Name": "PersonaTrait", "Docs": "", "Fields": [{ "Name": "Valence", "Docs": "", "Typewords": ["float64"] }, { "Name": "Arousal", "Docs": "", "Typewords": ["float64"] }, { "Name": "Dominance", "Docs": "", "Typewords": ["float64"] }, { "Name": "Attention", "Docs": "", "Typewords": ["float64"] }, { "Name": "Memory", "Docs": "", "Typewords": ["float64"] }, { "Name": "Creativity", "Docs": "", "Typewords": ["float64"] }] },
 		"Route": { "Name": "Route", "Docs": "", "Fields": [{ "Name": "FromDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MinimumAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"AddressAlias": { "Name": "AddressAlias", "Docs": "", "Fields": [{ "Name": "SubscriptionAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Alias", "Docs": "", "Typewords": ["Alias"] }, { "Name": "MemberAddresses", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }] },
@@ -295,6 +299,9 @@ var api;
 		"IncomingMeta": { "Name": "IncomingMeta", "Docs": "", "Fields": [{ "Name": "MsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MsgFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMVerifiedDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Automated", "Docs": "", "Typewords": ["bool"] }] },
 		"TLSPublicKey": { "Name": "TLSPublicKey", "Docs": "", "Fields": [{ "Name": "Fingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "NoIMAPPreauth", "Docs": "", "Typewords": ["bool"] }, { "Name": "CertDER", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }] },
 		"LoginAttempt": { "Name": "LoginAttempt", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Last", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "First", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Count", "Docs": "", "Typewords": ["int64"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalIP", "Docs": "", "Typewords": ["string"] }, { "Name": "TLS", "Docs": "", "Typewords": ["string"] }, { "Name": "TLSPubKeyFingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }, { "Name": "AuthMech", "Docs": "", "Typewords": ["string"] }, { "Name": "Result", "Docs": "", "Typewords": ["AuthResult"] }] },
+		"SieveScript": { "Name": "SieveScript", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Content", "Docs": "", "Typewords": ["string"] }, { "Name": "Active", "Docs": "", "Typewords": ["bool"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Updated", "Docs": "", "Typewords": ["timestamp"] }] },
+		"Vacation": { "Name": "Vacation", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["uint8"] }, { "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "End", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Text", "Docs": "", "Typewords": ["string"] }, { "Name": "HTML", "Docs": "", "Typewords": ["string"] }, { "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Days", "Docs": "", "Typewords": ["int32"] }] },
+		"MailboxGrant": { "Name": "MailboxGrant", "Docs": "", "Fields": [{ "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Grantee", "Docs": "", "Typewords": ["string"] }, { "Name": "Rights", "Docs": "", "Typewords": ["string"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 		"Localpart": { "Name": "Localpart", "Docs": "", "Values": null },
 		"OutgoingEvent": { "Name": "OutgoingEvent", "Docs": "", "Values": [{ "Name": "EventDelivered", "Value": "delivered", "Docs": "" }, { "Name": "EventSuppressed", "Value": "suppressed", "Docs": "" }, { "Name": "EventDelayed", "Value": "delayed", "Docs": "" }, { "Name": "EventFailed", "Value": "failed", "Docs": "" }, { "Name": "EventRelayed", "Value": "relayed", "Docs": "" }, { "Name": "EventExpanded", "Value": "expanded", "Docs": "" }, { "Name": "EventCanceled", "Value": "canceled", "Docs": "" }, { "Name": "EventUnrecognized", "Value": "unrecognized", "Docs": "" }] },
@@ -310,6 +317,10 @@ var api;
 		SubjectPass: (v) => api.parse("SubjectPass", v),
 		AutomaticJunkFlags: (v) => api.parse("AutomaticJunkFlags", v),
 		JunkFilter: (v) => api.parse("JunkFilter", v),
+		ReservoirFilter: (v) => api.parse("ReservoirFilter", v),
+		FilterConfig: (v) => api.parse("FilterConfig", v),
+		ESNParams: (v) => api.parse("ESNParams", v),
+		PersonaTrait: (v) => api.parse("PersonaTrait", v),
 		Route: (v) => api.parse("Route", v),
 		AddressAlias: (v) => api.parse("AddressAlias", v),
 		Alias: (v) => api.parse("Alias", v),
@@ -324,6 +335,9 @@ var api;
 		IncomingMeta: (v) => api.parse("IncomingMeta", v),
 		TLSPublicKey: (v) => api.parse("TLSPublicKey", v),
 		LoginAttempt: (v) => api.parse("LoginAttempt", v),
+		SieveScript: (v) => api.parse("SieveScript", v),
+		Vacation: (v) => api.parse("Vacation", v),
+		MailboxGrant: (v) => api.parse("MailboxGrant", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 		Localpart: (v) => api.parse("Localpart", v),
 		OutgoingEvent: (v) => api.parse("OutgoingEvent", v),
@@ -372,6 +386,15 @@ var api;
 			const params = [loginToken, username, password];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// LoginOIDCEnabled returns whether users can login through an OpenID Connect
+		// identity provider, by opening oidc/login.
+		async LoginOIDCEnabled() {
+			const fn = "LoginOIDCEnabled";
+			const paramTypes = [];
+			const returnTypes = [["bool"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// Logout invalidates the session token.
 		async Logout() {
 			const fn = "Logout";
@@ -610,6 +633,77 @@ var api;
 			const params = [capabilitiesDisabled];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// SieveScripts returns the Sieve scripts of the account, for filtering incoming
+		// messages.
+		async SieveScripts() {
+			const fn = "SieveScripts";
+			const paramTypes = [];
+			const returnTypes = [["[]", "SieveScript"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SieveScriptSave adds a Sieve script, or replaces the content of an existing
+		// script. The script is checked for errors before it is saved.
+		async SieveScriptSave(name, content) {
+			const fn = "SieveScriptSave";
+			const paramTypes = [["string"], ["string"]];
+			const returnTypes = [["SieveScript"]];
+			const params = [name, content];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SieveScriptActivate makes a Sieve script the active script, used during
+		// delivery. If name is empty, no script is active.
+		async SieveScriptActivate(name) {
+			const fn = "SieveScriptActivate";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [name];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SieveScriptDelete removes a Sieve script. The active script cannot be removed.
+		async SieveScriptDelete(name) {
+			const fn = "SieveScriptDelete";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [name];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Vacation returns the vacation settings of the account, for automatically
+		// responding to incoming messages.
+		async Vacation() {
+			const fn = "Vacation";
+			const paramTypes = [];
+			const returnTypes = [["Vacation"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// VacationSave saves the vacation settings of the account.
+		async VacationSave(v) {
+			const fn = "VacationSave";
+			const paramTypes = [["Vacation"]];
+			const returnTypes = [];
+			const params = [v];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MailboxGrants returns the grants on mailboxes of the account to other accounts.
+		// Grants for removed mailboxes are not returned.
+		async MailboxGrants() {
+			const fn = "MailboxGrants";
+			const paramTypes = [];
+			const returnTypes = [["[]", "MailboxGrant"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MailboxGrantSave sets the rights for grantee on a mailbox. Grantee can be an
+		// account name, an email address of an account, or "anyone". Empty rights remove
+		// the grant.
+		async MailboxGrantSave(mailboxName, grantee, rights) {
+			const fn = "MailboxGrantSave";
+			const paramTypes = [["string"], ["string"], ["string"]];
+			const returnTypes = [];
+			const params = [mailboxName, grantee, rights];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 	}
 	api.Client = Client;
 	api.defaultBaseURL = (function () {
@@ -973,6 +1067,7 @@ const login = async (reason) => {
 		let autosize;
 		let username;
 		let password;
+		let oidcElem;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(style({ display: 'flex', flexDirection: 'column', alignItems: 'center' }), reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
@@ -1001,9 +1096,17 @@ const login = async (reason) => {
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.autocomplete('username'), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.autocomplete('username'), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')), oidcElem = dom.div(style({ textAlign: 'center', marginTop: '2ex' })))))));
 		document.body.appendChild(root);
 		username.focus();
+		// Offer login through identity provider, if configured.
+		client.LoginOIDCEnabled()
+			.then(enabled => {
+			if (enabled) {
+				dom._kids(oidcElem, dom.a(attr.href('oidc/login'), 'Login with single sign-on'));
+			}
+		})
+			.catch(err => console.log('checking for single sign-on', err));
 	});
 };
 // Popup shows kids in a centered div with white background on top of a
@@ -1180,12 +1283,17 @@ const formatQuotaSize = (v) => {
 	return '' + v;
 };
 const index = async () => {
-	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts] = await Promise.all([
+	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts, sievescripts0, mailboxGrants0, vacation] = await Promise.all([
 		client.Account(),
 		client.TLSPublicKeys(),
 		client.LoginAttempts(10),
+		client.SieveScripts(),
+		client.MailboxGrants(),
+		client.Vacation(),
 	]);
 	const tlspubkeys = tlspubkeys0 || [];
+	let sievescripts = sievescripts0 || [];
+	let mailboxGrants = mailboxGrants0 || [];
 	let fullNameForm;
 	let fullNameFieldset;
 	let fullName;
@@ -1663,7 +1771,120 @@ openssl pkcs12 \\
 		e.preventDefault();
 		e.stopPropagation();
 		await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked));
-	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Webhooks'), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
+	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Vacation', attr.title('Automatically respond to incoming messages, e.g. while out of office. No responses are sent to mailing lists, bulk and automated messages, messages from the null sender, and messages not addressed to you in the To or Cc header. Each sender gets at most one response per interval. An active Sieve script with a vacation action takes precedence.')), (() => {
+		let vacationFieldset;
+		let vacationEnabled;
+		let vacationStart;
+		let vacationEnd;
+		let vacationSubject;
+		let vacationText;
+		let vacationHTML;
+		let vacationAddresses;
+		let vacationDays;
+		// Dates are whole days in local time. The end is stored as the start of the day
+		// after the last day of vacation.
+		const dateValue = (d, offsetDays) => {
+			if (d.getUTCFullYear() <= 1) {
+				return '';
+			}
+			const t = new Date(d.getTime());
+			t.setDate(t.getDate() + offsetDays);
+			const pad = (v) => (v < 10 ? '0' : '') + v;
+			return t.getFullYear() + '-' + pad(t.getMonth() + 1) + '-' + pad(t.getDate());
+		};
+		const parseDate = (s, offsetDays) => {
+			if (!s) {
+				return new Date('0001-01-01T00:00:00Z');
+			}
+			const t = new Date(s + 'T00:00:00');
+			t.setDate(t.getDate() + offsetDays);
+			return t;
+		};
+		return dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			const v = {
+				ID: 1,
+				Enabled: vacationEnabled.checked,
+				Start: parseDate(vacationStart.value, 0),
+				End: parseDate(vacationEnd.value, 1),
+				Subject: vacationSubject.value,
+				Text: vacationText.value,
+				HTML: vacationHTML.value,
+				Addresses: vacationAddresses.value.split(',').map(s => s.trim()).filter(s => !!s),
+				Days: parseInt(vacationDays.value),
+			};
+			await check(vacationFieldset, client.VacationSave(v));
+		}, vacationFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em', marginBottom: '1ex' }), dom.label('Enabled', dom.div(vacationEnabled = dom.input(attr.type('checkbox'), vacation.Enabled ? attr.checked('') : []))), dom.label('First day', attr.title('Optional. If set, no responses are sent before this day.'), dom.div(vacationStart = dom.input(attr.type('date'), attr.value(dateValue(vacation.Start, 0))))), dom.label('Last day', attr.title('Optional. If set, no responses are sent after this day.'), dom.div(vacationEnd = dom.input(attr.type('date'), attr.value(dateValue(vacation.End, -1))))), dom.label('Interval', attr.title('Minimum number of days between responses to the same sender.'), dom.div(vacationDays = dom.input(attr.type('number'), attr.min('1'), attr.max('365'), attr.required(''), attr.value('' + vacation.Days)), ' days'))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Subject', attr.title('If empty, the subject of the incoming message is used, prefixed with "Auto: ".'), dom.div(vacationSubject = dom.input(attr.value(vacation.Subject), style({ width: '40em' })))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Text', dom.div(vacationText = dom.textarea(attr.rows('6'), style({ width: '40em' }), vacation.Text))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'HTML', attr.title('Optional. If set, sent as alternative to the text. Either text or HTML is required.'), dom.div(vacationHTML = dom.textarea(attr.rows('4'), style({ width: '40em', fontFamily: 'monospace' }), vacation.HTML))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Additional addresses', attr.title('Comma-separated. Messages are only responded to if addressed to you in the To or Cc header: The address the message was delivered to, or one of these addresses.'), dom.div(vacationAddresses = dom.input(attr.value((vacation.Addresses || []).join(', ')), style({ width: '40em' })))), dom.submitbutton('Save')));
+	})(), dom.br(), dom.h2('Sieve scripts', attr.title('Sieve scripts filter incoming messages during delivery, e.g. to move them to a mailbox, set flags, discard or reject them, or send a vacation response. At most one script is active. The active script is evaluated after the rulesets of the address have selected a mailbox, which is used for "keep". Other scripts can be included by the active script.')), (() => {
+		let elem = dom.div();
+		let sieveFieldset;
+		let sieveName;
+		let sieveContent;
+		const reload = async (elem) => {
+			sievescripts = await check(elem, client.SieveScripts()) || [];
+			render();
+		};
+		const render = () => {
+			const e = dom.div(dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Active'), dom.th('Updated'), dom.th('Action'))), dom.tbody(sievescripts.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [], sievescripts.map(ss => dom.tr(dom.td(ss.Name), dom.td(ss.Active ? 'Yes' : 'No'), dom.td(age(ss.Updated)), dom.td(dom.clickbutton('Edit', function click() {
+				sieveName.value = ss.Name;
+				sieveContent.value = ss.Content;
+				sieveContent.focus();
+			}), ' ', dom.clickbutton(ss.Active ? 'Deactivate' : 'Activate', async function click(e) {
+				await check(e.target, client.SieveScriptActivate(ss.Active ? '' : ss.Name));
+				await reload(e.target);
+			}), ' ', dom.clickbutton('Remove', ss.Active ? attr.disabled('') : [], ss.Active ? attr.title('The active script cannot be removed, deactivate it first.') : [], async function click(e) {
+				if (!window.confirm('Are you sure you want to remove script "' + ss.Name + '"?')) {
+					return;
+				}
+				await check(e.target, client.SieveScriptDelete(ss.Name));
+				await reload(e.target);
+			})))))));
+			dom._kids(elem, e);
+		};
+		render();
+		return [
+			elem,
+			dom.form(style({ marginTop: '1ex' }), async function submit(e) {
+				e.preventDefault();
+				e.stopPropagation();
+				await check(sieveFieldset, client.SieveScriptSave(sieveName.value, sieveContent.value));
+				await reload(sieveFieldset);
+			}, sieveFieldset = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Name', attr.title('Saving with the name of an existing script replaces its content.'), dom.div(sieveName = dom.input(attr.required('')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Script', dom.div(sieveContent = dom.textarea(attr.rows('10'), style({ width: '40em', fontFamily: 'monospace' })))), dom.submitbutton('Save script'))),
+		];
+	})(), dom.br(), dom.h2('Shared mailboxes', attr.title('Mailboxes can be shared with other accounts on this server. Those accounts see the mailbox through IMAP, under "Other Users/" followed by the name of this account. Rights are IMAP ACL letters: l (lookup), r (read), s (keep seen flag), w (write other flags), i (insert messages), p (post), k (create mailboxes), x (delete mailbox), t (set deleted flag), e (expunge), a (administer rights). Grantee "anyone" gives rights to all accounts.')), (() => {
+		let elem = dom.div();
+		let grantFieldset;
+		let grantMailbox;
+		let grantGrantee;
+		let grantRights;
+		const reload = async (elem) => {
+			mailboxGrants = await check(elem, client.MailboxGrants()) || [];
+			render();
+		};
+		const render = () => {
+			const e = dom.table(dom.thead(dom.tr(dom.th('Mailbox'), dom.th('Grantee'), dom.th('Rights'), dom.th('Action'))), dom.tbody(mailboxGrants.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [], mailboxGrants.map(g => dom.tr(dom.td(g.MailboxName), dom.td(g.Grantee), dom.td(g.Rights), dom.td(dom.clickbutton('Edit', function click() {
+				grantMailbox.value = g.MailboxName;
+				grantGrantee.value = g.Grantee;
+				grantRights.value = g.Rights;
+				grantRights.focus();
+			}), ' ', dom.clickbutton('Remove', async function click(e) {
+				await check(e.target, client.MailboxGrantSave(g.MailboxName, g.Grantee, ''));
+				await reload(e.target);
+			}))))));
+			dom._kids(elem, e);
+		};
+		render();
+		return [
+			elem,
+			dom.form(style({ marginTop: '1ex' }), async function submit(e) {
+				e.preventDefault();
+				e.stopPropagation();
+				await check(grantFieldset, client.MailboxGrantSave(grantMailbox.value, grantGrantee.value, grantRights.value));
+				await reload(grantFieldset);
+			}, grantFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', dom.div(grantMailbox = dom.input(attr.required('')))), dom.label('Grantee', attr.title('Account name, email address of an account, or "anyone".'), dom.div(grantGrantee = dom.input(attr.required('')))), dom.label('Rights', attr.title('For example "lr" for read-only access, or "lrswite" to also manage flags and messages. Empty rights remove the grant.'), dom.div(grantRights = dom.input(attr.value('lr')))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save grant')))))),
+		];
+	})(), dom.br(), dom.h2('Webhooks'), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(outgoingWebhookFieldset, client.OutgoingWebhookSave(outgoingWebhookURL.value, outgoingWebhookAuthorization.value, [...outgoingWebhookEvents.selectedOptions].map(o => o.value)));
@@ -17,6 +17,7 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let oidcElem: HTMLElement
 
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
@@ -78,6 +79,7 @@ const login = async (reason: string) => {
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
 							),
+							oidcElem=dom.div(style({textAlign: 'center', marginTop: '2ex'})),
 						),
 					)
 				)
@@ -85,6 +87,15 @@ const login = async (reason: string) => {
 		)
 		document.body.appendChild(root)
 		username.focus()
+
+		// Offer login through identity provider, if configured.
+		client.LoginOIDCEnabled()
+			.then(enabled => {
+				if (enabled) {
+					dom._kids(oidcElem, dom.a(attr.href('oidc/login'), 'Login with single sign-on'))
+				}
+			})
+			.catch(err => console.log('checking for single sign-on', err))
 	})
 }
 
@@ -300,12 +311,17 @@ const formatQuotaSize = (v: number) => {
 }
 
 const index = async () => {
-	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts] = await Promise.all([
+	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts, sievescripts0, mailboxGrants0, vacation] = await Promise.all([
 		client.Account(),
 		client.TLSPublicKeys(),
 		client.LoginAttempts(10),
+		client.SieveScripts(),
+		client.MailboxGrants(),
+		client.Vacation(),
 	])
 	const tlspubkeys = tlspubkeys0 || []
+	let sievescripts = sievescripts0 || []
+	let mailboxGrants = mailboxGrants0 || []
 
 	let fullNameForm: HTMLFormElement
 	let fullNameFieldset: HTMLFieldSetElement
@@ -1234,6 +1250,285 @@ openssl pkcs12 \\
 		),
 		dom.br(),
 
+		dom.h2('Vacation', attr.title('Automatically respond to incoming messages, e.g. while out of office. No responses are sent to mailing lists, bulk and automated messages, messages from the null sender, and messages not addressed to you in the To or Cc header. Each sender gets at most one response per interval. An active Sieve script with a vacation action takes precedence.')),
+		(() => {
+			let vacationFieldset: HTMLFieldSetElement
+			let vacationEnabled: HTMLInputElement
+			let vacationStart: HTMLInputElement
+			let vacationEnd: HTMLInputElement
+			let vacationSubject: HTMLInputElement
+			let vacationText: HTMLTextAreaElement
+			let vacationHTML: HTMLTextAreaElement
+			let vacationAddresses: HTMLInputElement
+			let vacationDays: HTMLInputElement
+
+			// Dates are whole days in local time. The end is stored as the start of the day
+			// after the last day of vacation.
+			const dateValue = (d: Date, offsetDays: number) => {
+				if (d.getUTCFullYear() <= 1) {
+					return ''
+				}
+				const t = new Date(d.getTime())
+				t.setDate(t.getDate()+offsetDays)
+				const pad = (v: number) => (v < 10 ? '0' : '')+v
+				return t.getFullYear()+'-'+pad(t.getMonth()+1)+'-'+pad(t.getDate())
+			}
+			const parseDate = (s: string, offsetDays: number) => {
+				if (!s) {
+					return new Date('0001-01-01T00:00:00Z')
+				}
+				const t = new Date(s+'T00:00:00')
+				t.setDate(t.getDate()+offsetDays)
+				return t
+			}
+
+			return dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+
+					const v: api.Vacation = {
+						ID: 1,
+						Enabled: vacationEnabled.checked,
+						Start: parseDate(vacationStart.value, 0),
+						End: parseDate(vacationEnd.value, 1),
+						Subject: vacationSubject.value,
+						Text: vacationText.value,
+						HTML: vacationHTML.value,
+						Addresses: vacationAddresses.value.split(',').map(s => s.trim()).filter(s => !!s),
+						Days: parseInt(vacationDays.value),
+					}
+					await check(vacationFieldset, client.VacationSave(v))
+				},
+				vacationFieldset=dom.fieldset(
+					dom.div(style({display: 'flex', gap: '1em', marginBottom: '1ex'}),
+						dom.label(
+							'Enabled',
+							dom.div(vacationEnabled=dom.input(attr.type('checkbox'), vacation.Enabled ? attr.checked('') : [])),
+						),
+						dom.label(
+							'First day',
+							attr.title('Optional. If set, no responses are sent before this day.'),
+							dom.div(vacationStart=dom.input(attr.type('date'), attr.value(dateValue(vacation.Start, 0)))),
+						),
+						dom.label(
+							'Last day',
+							attr.title('Optional. If set, no responses are sent after this day.'),
+							dom.div(vacationEnd=dom.input(attr.type('date'), attr.value(dateValue(vacation.End, -1)))),
+						),
+						dom.label(
+							'Interval',
+							attr.title('Minimum number of days between responses to the same sender.'),
+							dom.div(vacationDays=dom.input(attr.type('number'), attr.min('1'), attr.max('365'), attr.required(''), attr.value(''+vacation.Days)), ' days'),
+						),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						'Subject',
+						attr.title('If empty, the subject of the incoming message is used, prefixed with "Auto: ".'),
+						dom.div(vacationSubject=dom.input(attr.value(vacation.Subject), style({width: '40em'}))),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						'Text',
+						dom.div(vacationText=dom.textarea(attr.rows('6'), style({width: '40em'}), vacation.Text)),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						'HTML',
+						attr.title('Optional. If set, sent as alternative to the text. Either text or HTML is required.'),
+						dom.div(vacationHTML=dom.textarea(attr.rows('4'), style({width: '40em', fontFamily: 'monospace'}), vacation.HTML)),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						'Additional addresses',
+						attr.title('Comma-separated. Messages are only responded to if addressed to you in the To or Cc header: The address the message was delivered to, or one of these addresses.'),
+						dom.div(vacationAddresses=dom.input(attr.value((vacation.Addresses || []).join(', ')), style({width: '40em'}))),
+					),
+					dom.submitbutton('Save'),
+				),
+			)
+		})(),
+		dom.br(),
+
+		dom.h2('Sieve scripts', attr.title('Sieve scripts filter incoming messages during delivery, e.g. to move them to a mailbox, set flags, discard or reject them, or send a vacation response. At most one script is active. The active script is evaluated after the rulesets of the address have selected a mailbox, which is used for "keep". Other scripts can be included by the active script.')),
+		(() => {
+			let elem = dom.div()
+
+			let sieveFieldset: HTMLFieldSetElement
+			let sieveName: HTMLInputElement
+			let sieveContent: HTMLTextAreaElement
+
+			const reload = async (elem: {disabled: boolean}) => {
+				sievescripts = await check(elem, client.SieveScripts()) || []
+				render()
+			}
+
+			const render = () => {
+				const e = dom.div(
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Name'),
+								dom.th('Active'),
+								dom.th('Updated'),
+								dom.th('Action'),
+							),
+						),
+						dom.tbody(
+							sievescripts.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [],
+							sievescripts.map(ss =>
+								dom.tr(
+									dom.td(ss.Name),
+									dom.td(ss.Active ? 'Yes' : 'No'),
+									dom.td(age(ss.Updated)),
+									dom.td(
+										dom.clickbutton('Edit', function click() {
+											sieveName.value = ss.Name
+											sieveContent.value = ss.Content
+											sieveContent.focus()
+										}),
+										' ',
+										dom.clickbutton(ss.Active ? 'Deactivate' : 'Activate', async function click(e: {target: HTMLButtonElement}) {
+											await check(e.target, client.SieveScriptActivate(ss.Active ? '' : ss.Name))
+											await reload(e.target)
+										}),
+										' ',
+										dom.clickbutton('Remove', ss.Active ? attr.disabled('') : [], ss.Active ? attr.title('The active script cannot be removed, deactivate it first.') : [], async function click(e: {target: HTMLButtonElement}) {
+											if (!window.confirm('Are you sure you want to remove script "'+ss.Name+'"?')) {
+												return
+											}
+											await check(e.target, client.SieveScriptDelete(ss.Name))
+											await reload(e.target)
+										}),
+									),
+								)
+							),
+						),
+					),
+				)
+				dom._kids(elem, e)
+			}
+			render()
+
+			return [
+				elem,
+				dom.form(
+					style({marginTop: '1ex'}),
+					async function submit(e: SubmitEvent) {
+						e.preventDefault()
+						e.stopPropagation()
+
+						await check(sieveFieldset, client.SieveScriptSave(sieveName.value, sieveContent.value))
+						await reload(sieveFieldset)
+					},
+					sieveFieldset=dom.fieldset(
+						dom.label(
+							style({display: 'block', marginBottom: '1ex'}),
+							'Name',
+							attr.title('Saving with the name of an existing script replaces its content.'),
+							dom.div(sieveName=dom.input(attr.required(''))),
+						),
+						dom.label(
+							style({display: 'block', marginBottom: '1ex'}),
+							'Script',
+							dom.div(sieveContent=dom.textarea(attr.rows('10'), style({width: '40em', fontFamily: 'monospace'}))),
+						),
+						dom.submitbutton('Save script'),
+					),
+				),
+			]
+		})(),
+		dom.br(),
+
+		dom.h2('Shared mailboxes', attr.title('Mailboxes can be shared with other accounts on this server. Those accounts see the mailbox through IMAP, under "Other Users/" followed by the name of this account. Rights are IMAP ACL letters: l (lookup), r (read), s (keep seen flag), w (write other flags), i (insert messages), p (post), k (create mailboxes), x (delete mailbox), t (set deleted flag), e (expunge), a (administer rights). Grantee "anyone" gives rights to all accounts.')),
+		(() => {
+			let elem = dom.div()
+
+			let grantFieldset: HTMLFieldSetElement
+			let grantMailbox: HTMLInputElement
+			let grantGrantee: HTMLInputElement
+			let grantRights: HTMLInputElement
+
+			const reload = async (elem: {disabled: boolean}) => {
+				mailboxGrants = await check(elem, client.MailboxGrants()) || []
+				render()
+			}
+
+			const render = () => {
+				const e = dom.table(
+					dom.thead(
+						dom.tr(
+							dom.th('Mailbox'),
+							dom.th('Grantee'),
+							dom.th('Rights'),
+							dom.th('Action'),
+						),
+					),
+					dom.tbody(
+						mailboxGrants.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [],
+						mailboxGrants.map(g =>
+							dom.tr(
+								dom.td(g.MailboxName),
+								dom.td(g.Grantee),
+								dom.td(g.Rights),
+								dom.td(
+									dom.clickbutton('Edit', function click() {
+										grantMailbox.value = g.MailboxName
+										grantGrantee.value = g.Grantee
+										grantRights.value = g.Rights
+										grantRights.focus()
+									}),
+									' ',
+									dom.clickbutton('Remove', async function click(e: {target: HTMLButtonElement}) {
+										await check(e.target, client.MailboxGrantSave(g.MailboxName, g.Grantee, ''))
+										await reload(e.target)
+									}),
+								),
+							)
+						),
+					),
+				)
+				dom._kids(elem, e)
+			}
+			render()
+
+			return [
+				elem,
+				dom.form(
+					style({marginTop: '1ex'}),
+					async function submit(e: SubmitEvent) {
+						e.preventDefault()
+						e.stopPropagation()
+
+						await check(grantFieldset, client.MailboxGrantSave(grantMailbox.value, grantGrantee.value, grantRights.value))
+						await reload(grantFieldset)
+					},
+					grantFieldset=dom.fieldset(
+						dom.div(
+							style({display: 'flex', gap: '1em'}),
+							dom.label(
+								'Mailbox',
+								dom.div(grantMailbox=dom.input(attr.required(''))),
+							),
+							dom.label(
+								'Grantee',
+								attr.title('Account name, email address of an account, or "anyone".'),
+								dom.div(grantGrantee=dom.input(attr.required(''))),
+							),
+							dom.label(
+								'Rights',
+								attr.title('For example "lr" for read-only access, or "lrswite" to also manage flags and messages. Empty rights remove the grant.'),
+								dom.div(grantRights=dom.input(attr.value('lr'))),
+							),
+							dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save grant'))),
+						),
+					),
+				),
+			]
+		})(),
+		dom.br(),
+
 		dom.h2('Webhooks'),
 		dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')),
 		dom.form(
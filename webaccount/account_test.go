@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime/debug"
 	"sort"
 	"strings"
@@ -36,6 +37,7 @@ import (
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/webauth"
@@ -186,7 +188,7 @@ func TestAccount(t *testing.T) {
 		}
 		rr := httptest.NewRecorder()
 		rr.Body = &bytes.Buffer{}
-		handle(apiHandler, false, rr, req)
+		handle(apiHandler, false, "/", rr, req)
 		if rr.Code != expStatusCode {
 			t.Fatalf("got status %d, expected %d (%s)", rr.Code, expStatusCode, readBody(rr.Body))
 		}
@@ -302,7 +304,7 @@ func TestAccount(t *testing.T) {
 		r.Header.Add("x-mox-csrf", string(csrfToken))
 		r.Header.Add("Cookie", cookieOK.String())
 		w := httptest.NewRecorder()
-		handle(apiHandler, false, w, r)
+		handle(apiHandler, false, "/", w, r)
 		if w.Code != http.StatusOK {
 			t.Fatalf("import, got status code %d, expected 200: %s", w.Code, w.Body.Bytes())
 		}
@@ -394,7 +396,7 @@ func TestAccount(t *testing.T) {
 		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		r.Header.Add("Cookie", cookieOK.String())
 		w := httptest.NewRecorder()
-		handle(apiHandler, false, w, r)
+		handle(apiHandler, false, "/", w, r)
 		if w.Code != http.StatusOK {
 			t.Fatalf("export, got status code %d, expected 200: %s", w.Code, w.Body.Bytes())
 		}
@@ -559,6 +561,37 @@ func TestAccount(t *testing.T) {
 	account, _, _, _ = api.Account(ctx)
 	tcompare(t, account.IMAPCapabilitiesDisabled, []string{})
 
+	// Sieve scripts.
+	tneedErrorCode(t, "user:error", func() { api.SieveScriptSave(ctx, "main", "bogus;") })
+	ss := api.SieveScriptSave(ctx, "main", `require "fileinto"; fileinto "Archive";`)
+	tcompare(t, ss.Name, "main")
+	api.SieveScriptActivate(ctx, "main")
+	tneedErrorCode(t, "user:error", func() { api.SieveScriptActivate(ctx, "absent") })
+	tneedErrorCode(t, "user:error", func() { api.SieveScriptDelete(ctx, "main") })
+	ssl := api.SieveScripts(ctx)
+	tcompare(t, len(ssl), 1)
+	tcompare(t, ssl[0].Active, true)
+	api.SieveScriptActivate(ctx, "")
+	api.SieveScriptDelete(ctx, "main")
+	tcompare(t, len(api.SieveScripts(ctx)), 0)
+
+	// Vacation.
+	tcompare(t, api.Vacation(ctx), store.Vacation{ID: 1, Days: 7})
+	tneedErrorCode(t, "user:error", func() { api.VacationSave(ctx, store.Vacation{Enabled: true, Days: 7}) })
+	api.VacationSave(ctx, store.Vacation{Enabled: true, Text: "Away.", Days: 3})
+	tcompare(t, api.Vacation(ctx), store.Vacation{ID: 1, Enabled: true, Text: "Away.", Days: 3})
+
+	// Mailbox grants.
+	tneedErrorCode(t, "user:error", func() { api.MailboxGrantSave(ctx, "absent", "disabled", "lr") })
+	tneedErrorCode(t, "user:error", func() { api.MailboxGrantSave(ctx, "Inbox", "bogus", "lr") })
+	tneedErrorCode(t, "user:error", func() { api.MailboxGrantSave(ctx, "Inbox", "mjl☺", "lr") })
+	tneedErrorCode(t, "user:error", func() { api.MailboxGrantSave(ctx, "Inbox", "disabled", "lrz") })
+	api.MailboxGrantSave(ctx, "Inbox", "disabled@mox.example", "rl")
+	api.MailboxGrantSave(ctx, "Inbox", "anyone", "l")
+	tcompare(t, api.MailboxGrants(ctx), []MailboxGrant{{"Inbox", "anyone", "l"}, {"Inbox", "disabled", "lr"}})
+	api.MailboxGrantSave(ctx, "Inbox", "anyone", "")
+	tcompare(t, api.MailboxGrants(ctx), []MailboxGrant{{"Inbox", "disabled", "lr"}})
+
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
@@ -574,3 +607,121 @@ func fakeCert(t *testing.T) []byte {
 	tcheck(t, err, "making certificate")
 	return localCertBuf
 }
+
+func TestOIDCLogin(t *testing.T) {
+	log := mlog.New("webaccount", nil)
+	os.RemoveAll("../testdata/httpaccount/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/httpaccount/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	defer func() {
+		err := store.Close()
+		tcheck(t, err, "store close")
+	}()
+	defer store.Switchboard()()
+
+	issuer := oidc.NewMockIssuer()
+	defer issuer.Close()
+
+	api := Account{cookiePath: "/account/"}
+	apiHandler, err := makeSherpaHandler(api.cookiePath, false)
+	tcheck(t, err, "sherpa handler")
+
+	serve := func(path string, cookies []*http.Cookie, expStatusCode int) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		handle(apiHandler, false, api.cookiePath, rr, req)
+		if rr.Code != expStatusCode {
+			t.Fatalf("got status %d, expected %d (%s)", rr.Code, expStatusCode, readBody(rr.Body))
+		}
+		return rr.Result()
+	}
+
+	// Not configured.
+	if api.LoginOIDCEnabled(ctxbg) {
+		t.Fatalf("oidc login enabled without config")
+	}
+	serve("/oidc/login", nil, http.StatusNotFound)
+
+	mox.Conf.Static.OIDC = &config.OIDC{
+		Issuer:       issuer.URL,
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		Audience:     []string{"webaccount"},
+		ClientID:     "webaccount",
+		Claim:        "email",
+		Scopes:       []string{"openid", "email"},
+	}
+	defer func() { mox.Conf.Static.OIDC = nil }()
+	if !api.LoginOIDCEnabled(ctxbg) {
+		t.Fatalf("oidc login not enabled")
+	}
+
+	// login starts a login, lets the issuer redirect back to us, and returns the path
+	// and query for the callback, and the oidc state cookie.
+	login := func() (string, []*http.Cookie) {
+		t.Helper()
+		resp := serve("/oidc/login", nil, http.StatusFound)
+		cookies := resp.Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "webaccountoidc" {
+			t.Fatalf("got cookies %v, expected webaccountoidc", cookies)
+		}
+		client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(resp.Header.Get("Location"))
+		tcheck(t, err, "authorize at issuer")
+		resp.Body.Close()
+		u, err := url.Parse(resp.Header.Get("Location"))
+		tcheck(t, err, "parse redirect from issuer")
+		if u.Host != "example.com" || u.Path != "/account/oidc/callback" {
+			t.Fatalf("unexpected redirect from issuer %q", u)
+		}
+		return strings.TrimPrefix(u.Path, "/account") + "?" + u.RawQuery, cookies
+	}
+
+	// Missing or mismatched state.
+	callback, cookies := login()
+	serve(callback, nil, http.StatusBadRequest)
+	serve(strings.Replace(callback, "state=", "state=x", 1), cookies, http.StatusBadRequest)
+	serve("/oidc/callback?error=access_denied", cookies, http.StatusForbidden)
+
+	// No account for address.
+	issuer.LoginClaims = map[string]any{"sub": "1", "email": "unknown@mox.example", "email_verified": true}
+	callback, cookies = login()
+	serve(callback, cookies, http.StatusForbidden)
+
+	// Login disabled for account.
+	issuer.LoginClaims = map[string]any{"sub": "2", "email": "disabled@mox.example", "email_verified": true}
+	callback, cookies = login()
+	serve(callback, cookies, http.StatusForbidden)
+
+	issuer.LoginClaims = map[string]any{"sub": "3", "email": "mjl☺@mox.example", "email_verified": true}
+	callback, cookies = login()
+	resp := serve(callback, cookies, http.StatusOK)
+	var sessionCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "webaccountsession" {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatalf("missing session cookie")
+	}
+	body, err := io.ReadAll(resp.Body)
+	tcheck(t, err, "read response")
+	m := regexp.MustCompile(`setItem\("webaccountcsrftoken", "([^"]+)"\)`).FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("missing csrf token in response %q", body)
+	}
+	sessionToken, _, _ := strings.Cut(sessionCookie.Value, " ")
+	ls, err := store.SessionUse(ctxbg, log, "mjl☺", store.SessionToken(sessionToken), store.CSRFToken(m[1]))
+	tcheck(t, err, "use session from oidc login")
+	tcompare(t, ls.LoginAddress, "mjl☺@mox.example")
+
+	// Code cannot be used again.
+	serve(callback, cookies, http.StatusForbidden)
+}
@@ -19,11 +19,13 @@ export interface Account {
 	KeepRejects: boolean
 	AutomaticJunkFlags: AutomaticJunkFlags
 	JunkFilter?: JunkFilter | null  // todo: sane defaults for junkfilter
+	ReservoirFilter?: ReservoirFilter | null
 	MaxOutgoingMessagesPerDay: number
 	MaxFirstTimeRecipientsPerDay: number
 	NoFirstTimeSenderDelay: boolean
 	NoCustomPassword: boolean
 	IMAPCapabilitiesDisabled?: string[] | null
+	POP3RetrievedMailbox: string
 	Routes?: Route[] | null
 	DNSDomain: Domain  // Parsed form of Domain.
 	Aliases?: AddressAlias[] | null
@@ -93,6 +95,50 @@ export interface JunkFilter {
 	RareWords: number
 }
 
+export interface ReservoirFilter {
+	Params: FilterConfig
+	Shadow: boolean
+}
+
+// FilterConfig contains configuration for the reservoir-enhanced filter.
+export interface FilterConfig {
+	ESNParams: ESNParams
+	Persona: PersonaTrait
+	EnableReservoir: boolean  // Integration parameters
+	EnableAffective: boolean
+	ReservoirWeight: number
+	Lexicons?: string[] | null  // Affective lexicons
+	MembraneDepth: number  // Membrane computing
+	FeatureExtractor: string  // Feature extraction
+	FeatureWords: number
+	MaxSamples: number  // Online training
+	SenderSequence: string  // Per-sender sequences
+	SenderStates: number
+	SenderStateExpiry: number
+}
+
+// ESNParams defines the hyper-parameters for the Echo State Network.
+export interface ESNParams {
+	ReservoirSize: number  // Reservoir size - number of neurons in the hidden layer
+	SpectralRadius: number  // Spectral radius - controls memory capacity
+	InputScaling: number  // Input scaling - scales input signals
+	LeakRate: number  // Leak rate - controls neuron activation decay
+	Sparsity: number  // Sparsity - connection density in reservoir
+	RidgeParam: number  // Ridge regression parameter for output training
+	TreeDepth: number  // Tree depth for hierarchical processing
+	Seed: number  // Seed for the random number generator that initializes the reservoir.
+}
+
+// PersonaTrait represents LLM personality traits mapped to reservoir parameters.
+export interface PersonaTrait {
+	Valence: number  // Affective dimensions
+	Arousal: number
+	Dominance: number
+	Attention: number  // Cognitive dimensions
+	Memory: number
+	Creativity: number
+}
+
 export interface Route {
 	FromDomain?: string[] | null
 	ToDomain?: string[] | null
@@ -240,12 +286,47 @@ export interface LoginAttempt {
 	LocalIP: string
 	TLS: string  // Empty if no TLS, otherwise contains version, algorithm, properties, etc.
 	TLSPubKeyFingerprint: string
-	Protocol: string  // "submission", "imap", "webmail", "webaccount", "webadmin"
+	Protocol: string  // "submission", "imap", "managesieve", "pop3", "webmail", "webaccount", "webadmin"
 	UserAgent: string  // From HTTP header, or IMAP ID command.
 	AuthMech: string  // "plain", "login", "cram-md5", "scram-sha-256-plus", "(unrecognized)", etc
 	Result: AuthResult
 }
 
+// SieveScript is a Sieve script for filtering incoming messages. At most one
+// script of an account is active, and evaluated during delivery. Other scripts
+// can be included by the active script. ../rfc/5228
+export interface SieveScript {
+	ID: number
+	Name: string
+	Content: string
+	Active: boolean
+	Created: Date
+	Updated: Date
+}
+
+// Vacation holds the settings for automatically responding to incoming messages,
+// e.g. while out of office. Responses are only sent while enabled and within the
+// optional date range. ../rfc/3834
+export interface Vacation {
+	ID: number  // Singleton ID 1.
+	Enabled: boolean
+	Start: Date  // If not zero, no responses are sent before this time.
+	End: Date  // If not zero, no responses are sent after this time.
+	Subject: string  // If empty, the subject of the incoming message is used with an "Auto: " prefix.
+	Text: string  // Plain text body. At least one of Text and HTML must be set when enabled.
+	HTML: string  // Optional HTML body, sent as alternative to Text.
+	Addresses?: string[] | null  // Additional addresses of the account, besides the address the message was delivered to. Only messages addressed to one of these addresses in the To or Cc header get a response.
+	Days: number  // Minimum number of days between responses to the same sender.
+}
+
+// MailboxGrant is a grant of IMAP ACL rights on a mailbox of the account to
+// another account.
+export interface MailboxGrant {
+	MailboxName: string
+	Grantee: string  // Account name, or "anyone" for all accounts.
+	Rights: string  // Letters from "lrswipkxtea", see store.ACLRightsAll.
+}
+
 export type CSRFToken = string
 
 // Localpart is a decoded local part of an email address, before the "@".
@@ -293,11 +374,11 @@ export enum AuthResult {
 	AuthAborted = "aborted",
 }
 
-export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AutomaticJunkFlags":true,"Destination":true,"Domain":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"LoginAttempt":true,"NameAddress":true,"Outgoing":true,"OutgoingWebhook":true,"Route":true,"Ruleset":true,"Structure":true,"SubjectPass":true,"Suppression":true,"TLSPublicKey":true}
+export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AutomaticJunkFlags":true,"Destination":true,"Domain":true,"ESNParams":true,"FilterConfig":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"LoginAttempt":true,"MailboxGrant":true,"NameAddress":true,"Outgoing":true,"OutgoingWebhook":true,"PersonaTrait":true,"ReservoirFilter":true,"Route":true,"Ruleset":true,"SieveScript":true,"Structure":true,"SubjectPass":true,"Suppression":true,"TLSPublicKey":true,"Vacation":true}
 export const stringsTypes: {[typename: string]: boolean} = {"AuthResult":true,"CSRFToken":true,"Localpart":true,"OutgoingEvent":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
-	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
+	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"ReservoirFilter","Docs":"","Typewords":["nullable","ReservoirFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"POP3RetrievedMailbox","Docs":"","Typewords":["string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
 	"OutgoingWebhook": {"Name":"OutgoingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"Events","Docs":"","Typewords":["[]","string"]}]},
 	"IncomingWebhook": {"Name":"IncomingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]}]},
 	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"SMTPError","Docs":"","Typewords":["string"]},{"Name":"MessageAuthRequiredSMTPError","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
@@ -306,6 +387,10 @@ export const types: TypenameMap = {
 	"SubjectPass": {"Name":"SubjectPass","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]}]},
 	"AutomaticJunkFlags": {"Name":"AutomaticJunkFlags","Docs":"","Fields":[{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"JunkMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NeutralMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NotJunkMailboxRegexp","Docs":"","Typewords":["string"]}]},
 	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
+	"ReservoirFilter": {"Name":"ReservoirFilter","Docs":"","Fields":[{"Name":"Params","Docs":"","Typewords":["FilterConfig"]},{"Name":"Shadow","Docs":"","Typewords":["bool"]}]},
+	"FilterConfig": {"Name":"FilterConfig","Docs":"","Fields":[{"Name":"ESNParams","Docs":"","Typewords":["ESNParams"]},{"Name":"Persona","Docs":"","Typewords":["PersonaTrait"]},{"Name":"EnableReservoir","Docs":"","Typewords":["bool"]},{"Name":"EnableAffective","Docs":"","Typewords":["bool"]},{"Name":"ReservoirWeight","Docs":"","Typewords":["float64"]},{"Name":"Lexicons","Docs":"","Typewords":["[]","string"]},{"Name":"MembraneDepth","Docs":"","Typewords":["int32"]},{"Name":"FeatureExtractor","Docs":"","Typewords":["string"]},{"Name":"FeatureWords","Docs":"","Typewords":["int32"]},{"Name":"MaxSamples","Docs":"","Typewords":["int32"]},{"Name":"SenderSequence","Docs":"","Typewords":["string"]},{"Name":"SenderStates","Docs":"","Typewords":["int32"]},{"Name":"SenderStateExpiry","Docs":"","Typewords":["int64"]}]},
+	"ESNParams": {"Name":"ESNParams","Docs":"","Fields":[{"Name":"ReservoirSize","Docs":"","Typewords":["int32"]},{"Name":"SpectralRadius","Docs":"","Typewords":["float64"]},{"Name":"InputScaling","Docs":"","Typewords":["float64"]},{"Name":"LeakRate","Docs":"","Typewords":["float64"]},{"Name":"Sparsity","Docs":"","Typewords":["float64"]},{"Name":"RidgeParam","Docs":"","Typewords":["float64"]},{"Name":"TreeDepth","Docs":"","Typewords":["int32"]},{"Name":"Seed","Docs":"","Typewords":["int64"]}]},
+	"PersonaTrait": {"Name":"PersonaTrait","Docs":"","Fields":[{"Name":"Valence","Docs":"","Typewords":["float64"]},{"Name":"Arousal","Docs":"","Typewords":["float64"]},{"Name":"Dominance","Docs":"","Typewords":["float64"]},{"Name":"Attention","Docs":"","Typewords":["float64"]},{"Name":"Memory","Docs":"","Typewords":["float64"]},{"Name":"Creativity","Docs":"","Typewords":["float64"]}]},
 	"Route": {"Name":"Route","Docs":"","Fields":[{"Name":"FromDomain","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomain","Docs":"","Typewords":["[]","string"]},{"Name":"MinimumAttempts","Docs":"","Typewords":["int32"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"FromDomainASCII","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomainASCII","Docs":"","Typewords":["[]","string"]}]},
 	"AddressAlias": {"Name":"AddressAlias","Docs":"","Fields":[{"Name":"SubscriptionAddress","Docs":"","Typewords":["string"]},{"Name":"Alias","Docs":"","Typewords":["Alias"]},{"Name":"MemberAddresses","Docs":"","Typewords":["[]","string"]}]},
 	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]}]},
@@ -320,6 +405,9 @@ export const types: TypenameMap = {
 	"IncomingMeta": {"Name":"IncomingMeta","Docs":"","Fields":[{"Name":"MsgID","Docs":"","Typewords":["int64"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"DKIMVerifiedDomains","Docs":"","Typewords":["[]","string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Automated","Docs":"","Typewords":["bool"]}]},
 	"TLSPublicKey": {"Name":"TLSPublicKey","Docs":"","Fields":[{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"NoIMAPPreauth","Docs":"","Typewords":["bool"]},{"Name":"CertDER","Docs":"","Typewords":["nullable","string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]}]},
 	"LoginAttempt": {"Name":"LoginAttempt","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["nullable","string"]},{"Name":"Last","Docs":"","Typewords":["timestamp"]},{"Name":"First","Docs":"","Typewords":["timestamp"]},{"Name":"Count","Docs":"","Typewords":["int64"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"LocalIP","Docs":"","Typewords":["string"]},{"Name":"TLS","Docs":"","Typewords":["string"]},{"Name":"TLSPubKeyFingerprint","Docs":"","Typewords":["string"]},{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"AuthMech","Docs":"","Typewords":["string"]},{"Name":"Result","Docs":"","Typewords":["AuthResult"]}]},
+	"SieveScript": {"Name":"SieveScript","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Content","Docs":"","Typewords":["string"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Updated","Docs":"","Typewords":["timestamp"]}]},
+	"Vacation": {"Name":"Vacation","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["uint8"]},{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"End","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Text","Docs":"","Typewords":["string"]},{"Name":"HTML","Docs":"","Typewords":["string"]},{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"Days","Docs":"","Typewords":["int32"]}]},
+	"MailboxGrant": {"Name":"MailboxGrant","Docs":"","Fields":[{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Grantee","Docs":"","Typewords":["string"]},{"Name":"Rights","Docs":"","Typewords":["string"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 	"Localpart": {"Name":"Localpart","Docs":"","Values":null},
 	"OutgoingEvent": {"Name":"OutgoingEvent","Docs":"","Values":[{"Name":"EventDelivered","Value":"delivered","Docs":""},{"Name":"EventSuppressed","Value":"suppressed","Docs":""},{"Name":"EventDelayed","Value":"delayed","Docs":""},{"Name":"EventFailed","Value":"failed","Docs":""},{"Name":"EventRelayed","Value":"relayed","Docs":""},{"Name":"EventExpanded","Value":"expanded","Docs":""},{"Name":"EventCanceled","Value":"canceled","Docs":""},{"Name":"EventUnrecognized","Value":"unrecognized","Docs":""}]},
@@ -336,6 +424,10 @@ export const parser = {
 	SubjectPass: (v: any) => parse("SubjectPass", v) as SubjectPass,
 	AutomaticJunkFlags: (v: any) => parse("AutomaticJunkFlags", v) as AutomaticJunkFlags,
 	JunkFilter: (v: any) => parse("JunkFilter", v) as JunkFilter,
+	ReservoirFilter: (v: any) => parse("ReservoirFilter", v) as ReservoirFilter,
+	FilterConfig: (v: any) => parse("FilterConfig", v) as FilterConfig,
+	ESNParams: (v: any) => parse("ESNParams", v) as ESNParams,
+	PersonaTrait: (v: any) => parse("PersonaTrait", v) as PersonaTrait,
 	Route: (v: any) => parse("Route", v) as Route,
 	AddressAlias: (v: any) => parse("AddressAlias", v) as AddressAlias,
 	Alias: (v: any) => parse("Alias", v) as Alias,
@@ -350,6 +442,9 @@ export const parser = {
 	IncomingMeta: (v: any) => parse("IncomingMeta", v) as IncomingMeta,
 	TLSPublicKey: (v: any) => parse("TLSPublicKey", v) as TLSPublicKey,
 	LoginAttempt: (v: any) => parse("LoginAttempt", v) as LoginAttempt,
+	SieveScript: (v: any) => parse("SieveScript", v) as SieveScript,
+	Vacation: (v: any) => parse("Vacation", v) as Vacation,
+	MailboxGrant: (v: any) => parse("MailboxGrant", v) as MailboxGrant,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 	Localpart: (v: any) => parse("Localpart", v) as Localpart,
 	OutgoingEvent: (v: any) => parse("OutgoingEvent", v) as OutgoingEvent,
@@ -406,6 +501,16 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
+	// LoginOIDCEnabled returns whether users can login through an OpenID Connect
+	// identity provider, by opening oidc/login.
+	async LoginOIDCEnabled(): Promise<boolean> {
+		const fn: string = "LoginOIDCEnabled"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as boolean
+	}
+
 	// Logout invalidates the session token.
 	async Logout(): Promise<void> {
 		const fn: string = "Logout"
@@ -670,6 +775,85 @@ export class Client {
 		const params: any[] = [capabilitiesDisabled]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
+
+	// SieveScripts returns the Sieve scripts of the account, for filtering incoming
+	// messages.
+	async SieveScripts(): Promise<SieveScript[] | null> {
+		const fn: string = "SieveScripts"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","SieveScript"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SieveScript[] | null
+	}
+
+	// SieveScriptSave adds a Sieve script, or replaces the content of an existing
+	// script. The script is checked for errors before it is saved.
+	async SieveScriptSave(name: string, content: string): Promise<SieveScript> {
+		const fn: string = "SieveScriptSave"
+		const paramTypes: string[][] = [["string"],["string"]]
+		const returnTypes: string[][] = [["SieveScript"]]
+		const params: any[] = [name, content]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SieveScript
+	}
+
+	// SieveScriptActivate makes a Sieve script the active script, used during
+	// delivery. If name is empty, no script is active.
+	async SieveScriptActivate(name: string): Promise<void> {
+		const fn: string = "SieveScriptActivate"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [name]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SieveScriptDelete removes a Sieve script. The active script cannot be removed.
+	async SieveScriptDelete(name: string): Promise<void> {
+		const fn: string = "SieveScriptDelete"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [name]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Vacation returns the vacation settings of the account, for automatically
+	// responding to incoming messages.
+	async Vacation(): Promise<Vacation> {
+		const fn: string = "Vacation"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["Vacation"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Vacation
+	}
+
+	// VacationSave saves the vacation settings of the account.
+	async VacationSave(v: Vacation): Promise<void> {
+		const fn: string = "VacationSave"
+		const paramTypes: string[][] = [["Vacation"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [v]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// MailboxGrants returns the grants on mailboxes of the account to other accounts.
+	// Grants for removed mailboxes are not returned.
+	async MailboxGrants(): Promise<MailboxGrant[] | null> {
+		const fn: string = "MailboxGrants"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","MailboxGrant"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as MailboxGrant[] | null
+	}
+
+	// MailboxGrantSave sets the rights for grantee on a mailbox. Grantee can be an
+	// account name, an email address of an account, or "anyone". Empty rights remove
+	// the grant.
+	async MailboxGrantSave(mailboxName: string, grantee: string, rights: string): Promise<void> {
+		const fn: string = "MailboxGrantSave"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [mailboxName, grantee, rights]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
 }
 
 export const defaultBaseURL = (function() {
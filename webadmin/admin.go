@@ -1631,6 +1631,27 @@ func (Admin) Account(ctx context.Context, account string) (accountConfig config.
 	return ac, diskUsage
 }
 
+// AccountShadowReport returns a report for messages received since start,
+// comparing the decisions of a reservoir filter in shadow mode with those of the
+// Bayesian filter and with junk/nonjunk flags.
+func (Admin) AccountShadowReport(ctx context.Context, account string, start time.Time) store.ShadowReport {
+	log := pkglog.WithContext(ctx)
+
+	acc, err := store.OpenAccount(log, account, false)
+	if err != nil && errors.Is(err, store.ErrAccountUnknown) {
+		xcheckuserf(ctx, err, "looking up account")
+	}
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	report, err := acc.ShadowReport(ctx, start)
+	xcheckf(ctx, err, "gathering shadow report")
+	return report
+}
+
 // ConfigFiles returns the paths and contents of the static and dynamic configuration files.
 func (Admin) ConfigFiles(ctx context.Context) (staticPath, dynamicPath, static, dynamic string) {
 	buf0, err := os.ReadFile(mox.ConfigStaticPath)
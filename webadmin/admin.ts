@@ -686,6 +686,92 @@ const accountloginattempts = async (accountName: string) => {
 	)
 }
 
+const accountshadow = async (accountName: string) => {
+	const since = (days: number) => new Date(new Date().getTime() - days*24*3600*1000)
+	const report = await client.AccountShadowReport(accountName, since(30))
+
+	let fieldset: HTMLFieldSetElement
+	let days: HTMLInputElement
+	const reportElem = dom.div()
+
+	const percentage = (n: number, total: number) => total === 0 ? '-' : (100*n/total).toFixed(1)+'%'
+	const matrixRow = (name: string, sm: api.ShadowMatrix) => {
+		const total = sm.TruePositives+sm.FalsePositives+sm.TrueNegatives+sm.FalseNegatives
+		return dom.tr(
+			dom.td(name),
+			dom.td(style({textAlign: 'right'}), ''+sm.TruePositives),
+			dom.td(style({textAlign: 'right'}), ''+sm.FalsePositives),
+			dom.td(style({textAlign: 'right'}), ''+sm.TrueNegatives),
+			dom.td(style({textAlign: 'right'}), ''+sm.FalseNegatives),
+			dom.td(style({textAlign: 'right'}), percentage(sm.TruePositives+sm.TrueNegatives, total)),
+		)
+	}
+	const render = (r: api.ShadowReport) => {
+		dom._kids(reportElem,
+			dom.p('Messages classified in shadow mode, received since ', r.Since.toISOString(), ': ', ''+r.Messages, '.'),
+			dom.h2('Bayesian filter versus reservoir filter'),
+			dom.table(
+				dom.thead(
+					dom.tr(dom.th(), dom.th('Reservoir junk'), dom.th('Reservoir nonjunk')),
+				),
+				dom.tbody(
+					dom.tr(dom.td('Bayesian junk'), dom.td(style({textAlign: 'right'}), ''+r.BothJunk), dom.td(style({textAlign: 'right'}), ''+r.OnlyBayesianJunk)),
+					dom.tr(dom.td('Bayesian nonjunk'), dom.td(style({textAlign: 'right'}), ''+r.OnlyReservoirJunk), dom.td(style({textAlign: 'right'}), ''+r.BothNonjunk)),
+				),
+			),
+			dom.p('Agreement: ', percentage(r.BothJunk+r.BothNonjunk, r.Messages), '.'),
+			dom.br(),
+			dom.h2('Filters versus junk/nonjunk flags'),
+			dom.p('Messages with a junk or nonjunk flag: ', ''+r.Marked, '. Flags are typically set by moving messages to or from the Junk mailbox. Only flags changed after delivery are counted, not flags set during delivery, e.g. with AutomaticJunkFlags in the account configuration. Junk is positive.'),
+			dom.table(
+				dom.thead(
+					dom.tr(
+						dom.th('Filter'),
+						dom.th('True positives'),
+						dom.th('False positives'),
+						dom.th('True negatives'),
+						dom.th('False negatives'),
+						dom.th('Accuracy'),
+					),
+				),
+				dom.tbody(
+					matrixRow('Bayesian, used for delivery', r.Bayesian),
+					matrixRow('Reservoir, shadow', r.Reservoir),
+				),
+			),
+		)
+	}
+	render(report)
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			crumblink('Accounts', '#accounts'),
+			crumblink(accountName, '#accounts/l/'+accountName),
+			'Shadow classification',
+		),
+		dom.p('The reservoir filter of this account runs in shadow mode: incoming messages are classified, but delivery decisions are made with only the Bayesian filter. This report compares the decisions the reservoir filter would have made.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const r = await check(fieldset, client.AccountShadowReport(accountName, since(parseInt(days.value))))
+				render(r)
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					'Period in days ',
+					days=dom.input(attr.type('number'), attr.required(''), attr.min('1'), attr.value('30')),
+				),
+				' ',
+				dom.submitbutton('Show'),
+			),
+		),
+		dom.br(),
+		reportElem,
+	)
+}
+
 const renderLoginAttempts = (accountLinks: boolean, loginAttempts: api.LoginAttempt[]) => {
 	// todo: pagination and search
 
@@ -1053,6 +1139,11 @@ const account = async (name: string) => {
 			},
 		),
 		dom.br(),
+		config.ReservoirFilter && config.ReservoirFilter.Shadow ? [
+			dom.h2('Reservoir filter shadow mode'),
+			dom.p('The reservoir filter classifies incoming messages without affecting delivery decisions. See the ', dom.a(attr.href('#accounts/l/'+name+'/shadow'), 'shadow classification report'), ' comparing its decisions with the Bayesian filter and junk/nonjunk flags.'),
+			dom.br(),
+		] : [],
 		dom.h2('Set new password'),
 		formPassword=dom.form(
 			fieldsetPassword=dom.fieldset(
@@ -5372,6 +5463,8 @@ const init = async () => {
 				root = await account(t[2])
 			} else if (t[0] === 'accounts' && t.length === 4 && t[1] === 'l' && t[3] === 'loginattempts') {
 				root = await accountloginattempts(t[2])
+			} else if (t[0] === 'accounts' && t.length === 4 && t[1] === 'l' && t[3] === 'shadow') {
+				root = await accountshadow(t[2])
 			} else if (t[0] === 'domains' && t.length === 2) {
 				root = await domain(t[1])
 			} else if (t[0] === 'domains' && t.length === 4 && t[2] === 'alias') {
@@ -245,7 +245,8 @@ Get a message in parsed form:
 			"MsgFromValidated": false,
 			"DKIMVerifiedDomains": [],
 			"RemoteIP": "",
-			"MailboxName": "Inbox"
+			"MailboxName": "Inbox",
+			"Classification": null
 		}
 	}
 
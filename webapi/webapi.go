@@ -165,6 +165,55 @@ type MessageMeta struct {
 	DKIMVerifiedDomains []string // Verified domains from DKIM-signature in message. Can be different domain than used in addresses.
 	RemoteIP            string   // Where the message was delivered from.
 	MailboxName         string
+
+	// Breakdown of the content-based junk classification during incoming delivery.
+	// Nil if no content analysis was done, e.g. for senders with a reputation.
+	Classification *Classification
+}
+
+// Classification explains why an incoming message was or was not classified as
+// junk based on its content.
+type Classification struct {
+	Reason      string  // Reason for the delivery decision, e.g. "junk-content", "no-bad-signals" or "dns-blocklisted".
+	Junk        bool    // Whether the content was classified as junk.
+	Probability float64 // Probability used for the decision, combined probability with a reservoir filter, otherwise the Bayesian probability.
+	Threshold   float64 // Junk threshold used for the decision.
+	Significant bool    // Whether the Bayesian filter has enough training data to base decisions on.
+
+	Bayesian  float64     // Probability from the Bayesian filter.
+	JunkWords []WordScore // Words most indicative of junk.
+	HamWords  []WordScore // Words most indicative of nonjunk.
+
+	// Only set if the account has a reservoir filter.
+	Reservoir *ReservoirClassification
+}
+
+// WordScore is a word with its junk score, 0 is nonjunk, 1 is junk.
+type WordScore struct {
+	Word  string
+	Score float64
+}
+
+// ReservoirClassification is the reservoir and affective part of a classification.
+type ReservoirClassification struct {
+	Probability float64 // From the reservoir.
+	Trained     bool    // If false, the reservoir is not yet trained and Probability is from a heuristic.
+	Combined    float64 // Combined Bayesian, reservoir and affective probability.
+
+	// Only set if affective analysis is enabled. For messages in an unknown
+	// language, only Language is set.
+	Affective *AffectiveClassification
+}
+
+// AffectiveClassification is the affective analysis part of a classification,
+// with the PAD (pleasure/valence, arousal, dominance) dimensions of the emotional
+// state of the message.
+type AffectiveClassification struct {
+	Language    string // Detected language, e.g. "en", or "unknown" if affective analysis was skipped.
+	Probability float64
+	Valence     float64 // -1 (negative) to 1 (positive).
+	Arousal     float64 // 0 to 1.
+	Dominance   float64 // 0 to 1.
 }
 
 type SendResult struct {
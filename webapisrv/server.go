@@ -34,6 +34,7 @@ import (
 
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
@@ -1293,6 +1294,7 @@ func (s server) MessageGet(ctx context.Context, req webapi.MessageGetRequest) (r
 		DKIMVerifiedDomains: m.DKIMDomains,
 		RemoteIP:            m.RemoteIP,
 		MailboxName:         mb.Name,
+		Classification:      webapiClassification(m.Classification),
 	}
 
 	structure, err := queue.PartStructure(log, &p)
@@ -1306,6 +1308,47 @@ func (s server) MessageGet(ctx context.Context, req webapi.MessageGetRequest) (r
 	return result, nil
 }
 
+// webapiClassification converts a stored classification to its webapi form.
+func webapiClassification(c *store.Classification) *webapi.Classification {
+	if c == nil {
+		return nil
+	}
+	words := func(l []junk.WordScore) []webapi.WordScore {
+		r := make([]webapi.WordScore, len(l))
+		for i, w := range l {
+			r[i] = webapi.WordScore{Word: w.Word, Score: w.Score}
+		}
+		return r
+	}
+	wc := &webapi.Classification{
+		Reason:      c.Reason,
+		Junk:        c.Junk,
+		Probability: c.Probability,
+		Threshold:   c.Threshold,
+		Significant: c.Significant,
+		Bayesian:    c.Bayesian,
+		JunkWords:   words(c.JunkWords),
+		HamWords:    words(c.HamWords),
+	}
+	if r := c.Reservoir; r != nil {
+		wc.Reservoir = &webapi.ReservoirClassification{
+			Probability: r.Probability,
+			Trained:     r.Trained,
+			Combined:    r.Combined,
+		}
+		if a := r.Affective; a != nil {
+			wc.Reservoir.Affective = &webapi.AffectiveClassification{
+				Language:    a.Language,
+				Probability: a.Probability,
+				Valence:     a.Valence,
+				Arousal:     a.Arousal,
+				Dominance:   a.Dominance,
+			}
+		}
+	}
+	return wc
+}
+
 func (s server) MessageRawGet(ctx context.Context, req webapi.MessageRawGetRequest) (resp io.ReadCloser, err error) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc := reqInfo.Account
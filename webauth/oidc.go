@@ -0,0 +1,228 @@
+package webauth
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
+	"github.com/mjl-/mox/store"
+)
+
+// Logins through an OpenID Connect identity provider use the authorization code
+// flow with PKCE. OIDCLogin sets a cookie with a random state, nonce and code
+// verifier, and redirects to the identity provider. After login, the identity
+// provider redirects back to OIDCCallback, which verifies the state, exchanges the
+// code for an ID token, and creates a session for the account with the email
+// address from the token. Only for the account and webmail interfaces, the admin
+// interface has no accounts.
+
+// OIDCEnabled returns whether logins through an identity provider are configured.
+func OIDCEnabled() bool {
+	p := oidc.Configured()
+	return p != nil && p.WebLogin()
+}
+
+func oidcRandom() string {
+	buf := make([]byte, 24)
+	cryptorand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// oidcRedirectURI returns the URL the identity provider must redirect to after
+// login. It must be registered at the identity provider.
+func oidcRedirectURI(isForwarded bool, r *http.Request, cookiePath string) string {
+	scheme := "http"
+	if isHTTPS(isForwarded, r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + cookiePath + "oidc/callback"
+}
+
+// OIDCLogin starts a login through the identity provider, by redirecting to it.
+//
+// kind is used for the cookie name (webaccount, webmail), and for logging. The
+// callback must be handled at cookiePath + "oidc/callback".
+func OIDCLogin(ctx context.Context, log mlog.Log, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+	p := oidc.Configured()
+	if p == nil || !p.WebLogin() {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, nonce, verifier := oidcRandom(), oidcRandom(), oidcRandom()
+	authURL, err := p.AuthorizationURL(ctx, log, oidcRedirectURI(isForwarded, r, cookiePath), state, nonce, verifier)
+	if err != nil {
+		log.Errorx("making oidc authorization url", err)
+		http.Error(w, "500 - internal server error - cannot reach identity provider", http.StatusInternalServerError)
+		return
+	}
+
+	// The cookie must be sent with the top-level navigation from the identity
+	// provider back to us, so samesite lax instead of strict.
+	http.SetCookie(w, &http.Cookie{
+		Name:     kind + "oidc",
+		Value:    state + " " + nonce + " " + verifier,
+		Path:     cookiePath + "oidc/",
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   10 * 60, // For one login at the identity provider.
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// The response after a successful login stores the CSRF token where the frontend
+// expects it, and opens the frontend with a new navigation, for which the
+// browser sends the samesite strict session cookie.
+var oidcDoneTemplate = template.Must(template.New("oidcdone").Parse(`<!doctype html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<title>Logging in...</title>
+	</head>
+	<body>
+		<p>Logging in...</p>
+		<script nonce="{{ .Nonce }}">
+try {
+	window.localStorage.setItem({{ .StorageKey }}, {{ .CSRFToken }})
+} catch (err) {
+	console.log('saving csrf token in localStorage', err)
+}
+window.location.replace({{ .Path }})
+		</script>
+	</body>
+</html>
+`))
+
+// OIDCCallback handles the redirect from the identity provider after a login. On
+// success, a session is created for the account and the browser is sent to
+// cookiePath.
+func OIDCCallback(ctx context.Context, log mlog.Log, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+	p := oidc.Configured()
+	if p == nil || !p.WebLogin() {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	if s := q.Get("error"); s != "" {
+		// E.g. when the user denied the login.
+		log.Info("oidc login failed at identity provider", slog.String("error", s), slog.String("description", q.Get("error_description")))
+		http.Error(w, "403 - forbidden - login at identity provider failed: "+s, http.StatusForbidden)
+		return
+	}
+
+	cookie, _ := r.Cookie(kind + "oidc")
+	var t []string
+	if cookie != nil {
+		t = strings.Split(cookie.Value, " ")
+	}
+	if len(t) != 3 || q.Get("state") == "" || t[0] != q.Get("state") {
+		http.Error(w, "400 - bad request - missing or mismatched login state, try logging in again", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := t[1], t[2]
+
+	ip := RemoteIP(log, isForwarded, r)
+	if ip == nil {
+		http.Error(w, "400 - bad request - cannot find ip for rate limit check (missing x-forwarded-for header?)", http.StatusBadRequest)
+		return
+	}
+	start := time.Now()
+	if !mox.LimiterFailedAuth.Add(ip, start, 1) {
+		metrics.AuthenticationRatelimitedInc(kind)
+		http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	la := loginAttempt(ip.String(), r, kind, "oidc")
+	defer func() {
+		store.LoginAttemptAdd(context.Background(), log, la)
+	}()
+
+	address, err := p.Login(ctx, log, q.Get("code"), oidcRedirectURI(isForwarded, r, cookiePath), nonce, verifier)
+	if err != nil && errors.Is(err, oidc.ErrToken) {
+		la.Result = store.AuthBadCredentials
+		log.Infox("oidc login failed", err)
+		time.Sleep(BadAuthDelay)
+		http.Error(w, "403 - forbidden - login failed, invalid token", http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Errorx("oidc login", err)
+		http.Error(w, "500 - internal server error - verifying login at identity provider", http.StatusInternalServerError)
+		return
+	}
+	la.LoginAddress = address
+
+	acc, accName, _, err := store.OpenEmail(log, address, true)
+	la.AccountName = accName
+	if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+		la.Result = store.AuthBadCredentials
+		time.Sleep(BadAuthDelay)
+		http.Error(w, "403 - forbidden - no account for address from identity provider", http.StatusForbidden)
+		return
+	} else if err != nil && errors.Is(err, store.ErrLoginDisabled) {
+		la.Result = store.AuthLoginDisabled
+		http.Error(w, "403 - forbidden - "+err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Errorx("open account for oidc login", err)
+		http.Error(w, "500 - internal server error - opening account", http.StatusInternalServerError)
+		return
+	}
+	err = acc.Close()
+	log.Check(err, "closing account")
+	la.Result = store.AuthSuccess
+	mox.LimiterFailedAuth.Reset(ip, start)
+
+	sessionToken, csrfToken, err := Accounts.add(ctx, log, accName, address)
+	if err != nil {
+		la.Result = store.AuthError
+		log.Errorx("adding session after oidc login", err)
+		http.Error(w, "500 - internal server error - adding session", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, kind, cookiePath, isForwarded, sessionToken, accName)
+	http.SetCookie(w, &http.Cookie{
+		Name:     kind + "oidc",
+		Path:     cookiePath + "oidc/",
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1, // Delete cookie.
+	})
+
+	scriptNonce := oidcRandom()
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Content-Security-Policy", fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; frame-ancestors 'none'", scriptNonce))
+	err = oidcDoneTemplate.Execute(w, map[string]string{
+		"Nonce":      scriptNonce,
+		"StorageKey": kind + "csrftoken",
+		"CSRFToken":  string(csrfToken),
+		"Path":       (&url.URL{Path: cookiePath}).String(),
+	})
+	log.Check(err, "writing oidc login response")
+}
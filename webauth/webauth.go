@@ -18,6 +18,9 @@ along with login credentials. If the loginToken is missing, the login attempt
 fails before checking any credentials. This should prevent third party websites
 from tricking a browser into logging in.
 
+If an OpenID Connect identity provider is configured, users of the account and
+mail interfaces can also login through the identity provider, see OIDCLogin.
+
 Sessions are stored server-side, and their lifetime automatically extended each
 time they are used. This makes it easy to invalidate existing sessions after a
 password change, and keeps the frontend free from handling long-term vs
@@ -293,7 +296,21 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 		return "", fmt.Errorf("adding session: %v", err)
 	}
 
-	// Add session cookie.
+	setSessionCookie(w, r, kind, cookiePath, isForwarded, sessionToken, accountName)
+	// Remove cookie used during login.
+	http.SetCookie(w, &http.Cookie{
+		Name:     kind + "login",
+		Path:     cookiePath,
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1, // Delete cookie
+	})
+	return csrfToken, nil
+}
+
+// setSessionCookie adds the session cookie to the response.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, kind, cookiePath string, isForwarded bool, sessionToken store.SessionToken, accountName string) {
 	http.SetCookie(w, &http.Cookie{
 		Name: kind + "session",
 		// Cookies values are ascii only, so we keep the account name query escaped.
@@ -307,16 +324,6 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 		// cookies. Our sessions are only valid for max 1 day. Convenience can come from
 		// the browser remembering the password.
 	})
-	// Remove cookie used during login.
-	http.SetCookie(w, &http.Cookie{
-		Name:     kind + "login",
-		Path:     cookiePath,
-		Secure:   isHTTPS(isForwarded, r),
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   -1, // Delete cookie
-	})
-	return csrfToken, nil
 }
 
 // Logout removes the session token through sessionAuth, and clears the session
@@ -122,6 +122,12 @@ func (w Webmail) Login(ctx context.Context, loginToken, username, password strin
 	return csrfToken
 }
 
+// LoginOIDCEnabled returns whether users can login through an OpenID Connect
+// identity provider, by opening oidc/login.
+func (Webmail) LoginOIDCEnabled(ctx context.Context) bool {
+	return webauth.OIDCEnabled()
+}
+
 // Logout invalidates the session token.
 func (w Webmail) Logout(ctx context.Context) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
@@ -1500,6 +1506,42 @@ func (Webmail) MailboxSetSpecialUse(ctx context.Context, mb store.Mailbox) {
 	})
 }
 
+// MailboxACLs returns the grants of rights on a mailbox to other accounts, for
+// access through IMAP. The account itself always has all rights.
+func (Webmail) MailboxACLs(ctx context.Context, mailboxID int64) []store.MailboxACL {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		xmailboxID(ctx, tx, mailboxID)
+	})
+	l, err := store.MailboxACLList(ctx, acc.Name, mailboxID)
+	xcheckf(ctx, err, "listing mailbox grants")
+	return l
+}
+
+// MailboxACLSet sets the rights for grantee on a mailbox. Grantee can be an
+// account name, an email address of an account, or "anyone". Empty rights remove
+// the grant.
+func (Webmail) MailboxACLSet(ctx context.Context, mailboxID int64, grantee, rights string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		xmailboxID(ctx, tx, mailboxID)
+	})
+	grantee, err := store.ACLGrantee(grantee)
+	xcheckuserf(ctx, err, "looking up grantee")
+	if grantee == acc.Name {
+		xcheckuserf(ctx, errors.New("account already has all rights to its mailboxes"), "setting mailbox grant")
+	}
+	err = store.MailboxACLSet(ctx, acc.Name, mailboxID, grantee, rights)
+	if errors.Is(err, store.ErrACLRights) {
+		xcheckuserf(ctx, err, "setting mailbox grant")
+	}
+	xcheckf(ctx, err, "setting mailbox grant")
+}
+
 // ThreadCollapse saves the ThreadCollapse field for the messages and its
 // children. The messageIDs are typically thread roots. But not all roots
 // (without parent) of a thread need to have the same collapsed state.
@@ -1814,6 +1856,29 @@ func (Webmail) SettingsSave(ctx context.Context, settings store.Settings) {
 	xcheckf(ctx, err, "save settings")
 }
 
+// Vacation returns the vacation settings of the account, for automatically
+// responding to incoming messages.
+func (Webmail) Vacation(ctx context.Context) store.Vacation {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	v, err := acc.VacationGet(ctx)
+	xcheckf(ctx, err, "looking up vacation settings")
+	return v
+}
+
+// VacationSave saves the vacation settings of the account.
+func (Webmail) VacationSave(ctx context.Context, v store.Vacation) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.VacationSave(ctx, v)
+	if errors.Is(err, store.ErrVacationInvalid) {
+		xcheckuserf(ctx, err, "saving vacation settings")
+	}
+	xcheckf(ctx, err, "saving vacation settings")
+}
+
 func (Webmail) RulesetSuggestMove(ctx context.Context, msgID, mbSrcID, mbDstID int64) (listID string, msgFrom string, isRemove bool, rcptTo string, ruleset *config.Ruleset) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc := reqInfo.Account
@@ -249,6 +249,21 @@ func TestAPI(t *testing.T) {
 	tneedError(t, func() { api.MailboxRename(ctx, inbox.ID, "Binbox") })      // Inbox not allowed.
 	tneedError(t, func() { api.MailboxRename(ctx, testbox1.ID, "Archive") })  // Exists.
 
+	// MailboxACLSet and MailboxACLs
+	api.MailboxACLSet(ctx, testbox1.ID, "other@mox.example", "rl")
+	api.MailboxACLSet(ctx, testbox1.ID, "anyone", "l")
+	tneedError(t, func() { api.MailboxACLSet(ctx, 0, "other", "lr") })            // Bad ID.
+	tneedError(t, func() { api.MailboxACLSet(ctx, testbox1.ID, "bogus", "lr") })  // Unknown account.
+	tneedError(t, func() { api.MailboxACLSet(ctx, testbox1.ID, "mjl", "lr") })    // Owner.
+	tneedError(t, func() { api.MailboxACLSet(ctx, testbox1.ID, "other", "lrz") }) // Unknown right.
+	acls := api.MailboxACLs(ctx, testbox1.ID)
+	tcompare(t, len(acls), 2)
+	tcompare(t, acls[0].Grantee, "anyone")
+	tcompare(t, acls[1].Grantee+" "+acls[1].Rights, "other lr")
+	api.MailboxACLSet(ctx, testbox1.ID, "anyone", "")
+	tcompare(t, len(api.MailboxACLs(ctx, testbox1.ID)), 1)
+	tneedError(t, func() { api.MailboxACLs(ctx, 0) })
+
 	// ParsedMessage
 	// todo: verify contents
 	api.ParsedMessage(ctx, inboxMinimal.ID)
@@ -265,6 +280,11 @@ func TestAPI(t *testing.T) {
 	pm = api.ParsedMessage(ctx, inboxText.ID)
 	tcompare(t, pm.ViewMode, store.ModeHTMLExt)
 
+	// Vacation
+	tneedError(t, func() { api.VacationSave(ctx, store.Vacation{Enabled: true, Text: "Away.", Days: 0}) })
+	api.VacationSave(ctx, store.Vacation{Enabled: true, Text: "Away.", HTML: "<p>Away.</p>", Days: 7})
+	tcompare(t, api.Vacation(ctx).HTML, "<p>Away.</p>")
+
 	// MailboxDelete
 	api.MailboxDelete(ctx, testbox1.ID)
 	testa, err := bstore.QueryDB[store.Mailbox](ctx, acc.DB).FilterEqual("Name", "Test/A").Get()
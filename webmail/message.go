@@ -96,6 +96,7 @@ func messageItem(log mlog.Log, m store.Message, state *msgState, moreHeaders []s
 	// Clear largish unused data.
 	m.MsgPrefix = nil
 	m.ParsedBuf = nil
+	m.Classification = nil
 	l := messageItemMoreHeaders(moreHeaders, pm)
 	return MessageItem{m, pm.envelope, pm.attachments, pm.isSigned, pm.isEncrypted, true, l}, nil
 }
@@ -109,6 +110,7 @@ func parsedMessage(log mlog.Log, m *store.Message, state *msgState, full, msgite
 		}
 		if full {
 			pm.Part = *state.part
+			pm.Classification = m.Classification
 		}
 	} else {
 		if !state.ensurePart(*m, false) {
@@ -166,7 +166,7 @@ type MessageEnvelope struct {
 // message.Part, made for the needs of the message items in the message list.
 // messages.
 type MessageItem struct {
-	Message     store.Message // Without ParsedBuf and MsgPrefix, for size. With Preview, even if it isn't stored yet in the database.
+	Message     store.Message // Without ParsedBuf, MsgPrefix and Classification, for size. With Preview, even if it isn't stored yet in the database.
 	Envelope    MessageEnvelope
 	Attachments []Attachment
 	IsSigned    bool
@@ -198,6 +198,11 @@ type ParsedMessage struct {
 	TextPaths [][]int // Paths to text parts.
 	HTMLPath  []int   // Path to HTML part.
 
+	// Breakdown of the junk classification during incoming delivery, for showing why
+	// a message was or was not classified as junk. Nil if no content analysis was
+	// done.
+	Classification *store.Classification
+
 	// Information used by MessageItem, not exported in this type.
 	envelope    MessageEnvelope
 	attachments []Attachment
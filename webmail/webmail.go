@@ -56,7 +56,7 @@ var requestInfoCtxKey ctxKey = "requestInfo"
 type requestInfo struct {
 	Log          mlog.Log
 	LoginAddress string
-	Account      *store.Account // Nil only for methods Login and LoginPrep.
+	Account      *store.Account // Nil only for methods Login, LoginPrep and LoginOIDCEnabled.
 	SessionToken store.SessionToken
 	Response     http.ResponseWriter
 	Request      *http.Request // For Proto and TLS connection state during message submit.
@@ -225,11 +225,11 @@ func Handler(maxMessageSize int64, cookiePath string, isForwarded bool, accountP
 			http.Error(w, "500 - internal server error - cannot handle requests", http.StatusInternalServerError)
 			return
 		}
-		handle(sh, isForwarded, accountPath, w, r)
+		handle(sh, isForwarded, cookiePath, accountPath, w, r)
 	}
 }
 
-func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w http.ResponseWriter, r *http.Request) {
+func handle(apiHandler http.Handler, isForwarded bool, cookiePath, accountPath string, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := pkglog.WithContext(ctx).With(slog.String("userauth", ""))
 
@@ -286,6 +286,15 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		}
 		return
 
+	case "/oidc/login":
+		// Login through an identity provider, redirects to it.
+		webauth.OIDCLogin(ctx, log, "webmail", cookiePath, isForwarded, w, r)
+		return
+
+	case "/oidc/callback":
+		webauth.OIDCCallback(ctx, log, "webmail", cookiePath, isForwarded, w, r)
+		return
+
 	case "/msg.js", "/text.js":
 		switch r.Method {
 		default:
@@ -315,7 +324,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 	var loginAddress, accName string
 	var sessionToken store.SessionToken
 	// All other URLs, except the login endpoint require some authentication.
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/LoginOIDCEnabled" {
 		var ok bool
 		isExport := r.URL.Path == "/export"
 		requireCSRF := isAPI || isExport
@@ -711,6 +720,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 
 		m.MsgPrefix = nil
 		m.ParsedBuf = nil
+		m.Classification = nil
 		hl := messageItemMoreHeaders(moreHeaders, pm)
 		mi := MessageItem{m, pm.envelope, pm.attachments, pm.isSigned, pm.isEncrypted, false, hl}
 		mijson, err := json.Marshal(mi)
@@ -257,6 +257,7 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let oidcElem: HTMLElement
 		const root = dom.div(
 			css('loginOverlay', {position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: styles.overlayOpaqueBackgroundColor, display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: zindexes.login, animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -329,6 +330,7 @@ const login = async (reason: string) => {
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
 							),
+							oidcElem=dom.div(style({textAlign: 'center', marginTop: '2ex'})),
 						),
 					)
 				)
@@ -336,6 +338,15 @@ const login = async (reason: string) => {
 		)
 		document.body.appendChild(root)
 		username.focus()
+
+		// Offer login through identity provider, if configured.
+		client.LoginOIDCEnabled()
+			.then(enabled => {
+				if (enabled) {
+					dom._kids(oidcElem, dom.a(attr.href('oidc/login'), 'Login with single sign-on'))
+				}
+			})
+			.catch(err => console.log('checking for single sign-on', err))
 	})
 }
 
@@ -1228,6 +1239,118 @@ const cmdSettings = async () => {
 	)
 }
 
+// Show vacation settings, for automatically responding to incoming messages.
+const cmdVacation = async () => {
+	const v = await withStatus('Fetching vacation settings', client.Vacation())
+
+	let fieldset: HTMLFieldSetElement
+	let enabled: HTMLInputElement
+	let start: HTMLInputElement
+	let end: HTMLInputElement
+	let subject: HTMLInputElement
+	let text: HTMLTextAreaElement
+	let html: HTMLTextAreaElement
+	let addresses: HTMLInputElement
+	let days: HTMLInputElement
+
+	// Dates are whole days in local time. The end is stored as the start of the day
+	// after the last day of vacation.
+	const dateValue = (d: Date, offsetDays: number) => {
+		if (d.getUTCFullYear() <= 1) {
+			return ''
+		}
+		const t = new Date(d.getTime())
+		t.setDate(t.getDate()+offsetDays)
+		const pad = (v: number) => (v < 10 ? '0' : '')+v
+		return t.getFullYear()+'-'+pad(t.getMonth()+1)+'-'+pad(t.getDate())
+	}
+	const parseDate = (s: string, offsetDays: number) => {
+		if (!s) {
+			return new Date('0001-01-01T00:00:00Z')
+		}
+		const t = new Date(s+'T00:00:00')
+		t.setDate(t.getDate()+offsetDays)
+		return t
+	}
+
+	const remove = popup(
+		css('popupVacation', {minWidth: '30em'}),
+		style({maxWidth: '50em'}),
+		dom.h1('Vacation'),
+		dom.p('Automatically respond to incoming messages, e.g. while out of office. No responses are sent to mailing lists, bulk and automated messages, and messages not addressed to you. Each sender gets at most one response per interval.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const nv: api.Vacation = {
+					ID: v.ID,
+					Enabled: enabled.checked,
+					Start: parseDate(start.value, 0),
+					End: parseDate(end.value, 1),
+					Subject: subject.value,
+					Text: text.value,
+					HTML: html.value,
+					Addresses: addresses.value.split(',').map(s => s.trim()).filter(s => !!s),
+					Days: parseInt(days.value),
+				}
+				await withDisabled(fieldset, client.VacationSave(nv))
+				remove()
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					style({margin: '1ex 0', display: 'block'}),
+					enabled=dom.input(attr.type('checkbox'), v.Enabled ? attr.checked('') : []),
+					' Enabled',
+				),
+				dom.div(style({display: 'flex', gap: '1em'}),
+					dom.label(
+						dom.div('First day'),
+						attr.title('Optional. If set, no responses are sent before this day.'),
+						start=dom.input(attr.type('date'), attr.value(dateValue(v.Start, 0))),
+					),
+					dom.label(
+						dom.div('Last day'),
+						attr.title('Optional. If set, no responses are sent after this day.'),
+						end=dom.input(attr.type('date'), attr.value(dateValue(v.End, -1))),
+					),
+					dom.label(
+						dom.div('Interval in days'),
+						attr.title('Minimum number of days between responses to the same sender.'),
+						days=dom.input(attr.type('number'), attr.min('1'), attr.max('365'), attr.required(''), attr.value(''+v.Days)),
+					),
+				),
+				dom.label(
+					style({margin: '1ex 0', display: 'block'}),
+					dom.div('Subject'),
+					attr.title('If empty, the subject of the incoming message is used, prefixed with "Auto: ".'),
+					subject=dom.input(attr.value(v.Subject), style({width: '100%'})),
+				),
+				dom.label(
+					style({margin: '1ex 0', display: 'block'}),
+					dom.div('Text'),
+					text=dom.textarea(new String(v.Text), style({width: '100%'}), attr.rows('6')),
+				),
+				dom.label(
+					style({margin: '1ex 0', display: 'block'}),
+					dom.div('HTML'),
+					attr.title('Optional. If set, sent as alternative to the text. Either text or HTML is required.'),
+					html=dom.textarea(new String(v.HTML), style({width: '100%', fontFamily: 'monospace'}), attr.rows('4')),
+				),
+				dom.label(
+					style({margin: '1ex 0', display: 'block'}),
+					dom.div('Additional addresses'),
+					attr.title('Comma-separated. Messages are only responded to if addressed to you in the To or Cc header: The address the message was delivered to, or one of these addresses.'),
+					addresses=dom.input(attr.value((v.Addresses || []).join(', ')), style({width: '100%'})),
+				),
+				dom.br(),
+				dom.div(
+					dom.submitbutton('Save'),
+				),
+			),
+		),
+	)
+}
+
 // Show help popup, with shortcuts and basic explanation.
 const cmdHelp = async () => {
 	popup(
@@ -3192,6 +3315,40 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		)
 	}
 
+	const cmdShowClassification = async () => {
+		const pm = await parsedMessagePromise
+		const c = pm.Classification
+		if (!c) {
+			window.alert('No junk classification available. The content of this message was not analyzed during delivery, e.g. because the sender has a reputation, or the message was not delivered over SMTP.')
+			return
+		}
+		const prob = (v: number) => v.toFixed(2)
+		const words = (l: api.WordScore[] | null) => (l || []).length === 0 ? '(none)' : (l || []).map(w => w.Word + ' ' + w.Score.toFixed(3)).join(', ')
+		const row = (label: string, value: string) => dom.tr(dom.td(css('classificationLabel', {paddingRight: '1em', color: styles.colorMild, verticalAlign: 'top', whiteSpace: 'nowrap'}), label), dom.td(value))
+		const r = c.Reservoir
+		const a = r?.Affective
+		popup(
+			css('popupClassification', {maxWidth: '50em'}),
+			dom.h1(c.Junk ? 'Why was this classified as junk?' : 'Why was this not classified as junk?'),
+			dom.table(
+				row('Decision', c.Reason),
+				row('Content', (c.Junk ? 'junk' : 'not junk') + (c.Significant ? '' : ' (not significant, too little training data)')),
+				row('Probability', prob(c.Probability) + ', threshold ' + prob(c.Threshold)),
+				row('Bayesian', prob(c.Bayesian)),
+				row('Junk words', words(c.JunkWords)),
+				row('Nonjunk words', words(c.HamWords)),
+				!r ? [] : [
+					row('Reservoir', prob(r.Probability) + (r.Trained ? '' : ' (not trained, heuristic)')),
+					!a ? [] : (a.Language === 'unknown' ? row('Affective', 'skipped, unknown language') : [
+						row('Affective', prob(a.Probability) + (a.Language ? ', language ' + a.Language : '')),
+						row('Valence, arousal, dominance', [a.Valence, a.Arousal, a.Dominance].map(v => prob(v)).join(', ')),
+					]),
+					row('Combined', prob(r.Combined)),
+				],
+			),
+		)
+	}
+
 	const cmdUp = async () => { msgscrollElem.scrollTo({top: msgscrollElem.scrollTop - 3*msgscrollElem.getBoundingClientRect().height / 4, behavior: 'smooth'}) }
 	const cmdDown = async () => { msgscrollElem.scrollTo({top: msgscrollElem.scrollTop + 3*msgscrollElem.getBoundingClientRect().height / 4, behavior: 'smooth'}) }
 	const cmdHome = async () => { msgscrollElem.scrollTo({top: 0 }) }
@@ -3279,6 +3436,7 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 					dom.clickbutton('Trash', attr.title('Move to the Trash mailbox.'), clickCmd(msglistView.cmdTrash, shortcuts)),
 				' ',
 				dom.clickbutton('Junk', attr.title('Move to Junk mailbox, marking as junk and causing this message to be used in spam classification of new incoming messages.'), clickCmd(msglistView.cmdJunk, shortcuts)), ' ',
+				!pm || !pm.Classification || !pm.Classification.Junk ? [] : [dom.clickbutton('Why junk?', attr.title('Show why the content of this message was classified as junk during delivery.'), clickCmd(cmdShowClassification, shortcuts)), ' '],
 				dom.clickbutton('Move to...', function click(e: MouseEvent) {
 					movePopover(e, listMailboxes(), [m])
 				}), ' ',
@@ -3304,6 +3462,7 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 								dom.clickbutton('Show raw original message in new tab', clickCmd(cmdOpenRaw, shortcuts)),
 								dom.clickbutton('Show currently displayed part as decoded text', clickCmd(cmdOpenRawPart, shortcuts)),
 								dom.clickbutton('Show internals in popup', clickCmd(cmdShowInternals, shortcuts)),
+								dom.clickbutton('Show junk classification', clickCmd(cmdShowClassification, shortcuts)),
 							].map(b => dom.div(b)),
 						),
 					)
@@ -5333,6 +5492,68 @@ const newMailboxView = (xmb: api.Mailbox, mailboxlistView: MailboxlistView, othe
 						)
 					}),
 				),
+				dom.div(
+					dom.clickbutton('Share mailbox...', attr.title('Give other accounts on this server access to this mailbox through IMAP, where it is listed under "Other Users/".'), async function click() {
+						remove()
+
+						const grantsElem = dom.div()
+						let fieldset: HTMLFieldSetElement, grantee: HTMLInputElement, rights: HTMLInputElement
+
+						const render = async () => {
+							const acls = await withStatus('Listing mailbox grants', client.MailboxACLs(mbv.mailbox.ID)) || []
+							dom._kids(grantsElem,
+								dom.table(
+									dom.tr(dom.th('Grantee'), dom.th('Rights'), dom.th()),
+									acls.length === 0 ? dom.tr(dom.td(attr.colspan('3'), 'Not shared.')) : [],
+									acls.map(acl =>
+										dom.tr(
+											dom.td(acl.Grantee),
+											dom.td(acl.Rights),
+											dom.td(
+												dom.clickbutton('Remove', async function click(e: MouseEvent) {
+													await withStatus('Removing mailbox grant', client.MailboxACLSet(mbv.mailbox.ID, acl.Grantee, ''), e.target! as HTMLButtonElement)
+													await render()
+												}),
+											),
+										)
+									),
+								),
+							)
+						}
+
+						popover(actionBtn, {},
+							dom.div(
+								grantsElem,
+								dom.form(
+									style({marginTop: '1ex'}),
+									async function submit(e: SubmitEvent) {
+										e.preventDefault()
+										await withStatus('Saving mailbox grant', client.MailboxACLSet(mbv.mailbox.ID, grantee.value, rights.value), fieldset)
+										grantee.value = ''
+										await render()
+									},
+									fieldset=dom.fieldset(
+										dom.label(
+											'Grantee ',
+											attr.title('Account name, email address of an account, or "anyone".'),
+											grantee=dom.input(attr.required('')),
+										),
+										' ',
+										dom.label(
+											'Rights ',
+											attr.title('IMAP ACL rights: l (lookup), r (read), s (keep seen flag), w (write other flags), i (insert messages), t (set deleted flag), e (expunge), a (administer rights). For example "lr" for read-only access, or "lrswite" to also manage flags and messages.'),
+											rights=dom.input(attr.value('lr'), style({width: '8em'})),
+										),
+										' ',
+										dom.submitbutton('Save'),
+									),
+								),
+							),
+						)
+						grantee.focus()
+						await render()
+					}),
+				),
 				dom.div(
 					dom.clickbutton('Export as...', function click() {
 						popoverExport(actionBtn, mbv.mailbox.Name, null)
@@ -6924,6 +7145,8 @@ const init = async () => {
 					' ',
 					dom.clickbutton('Settings', attr.title('Change settings for composing messages.'), clickCmd(cmdSettings, shortcuts)),
 					' ',
+					dom.clickbutton('Vacation', attr.title('Automatically respond to incoming messages, e.g. while out of office.'), clickCmd(cmdVacation, shortcuts)),
+					' ',
 					accountElem=dom.span(),
 					' ',
 					loginAddressElem=dom.span(),
@@ -402,7 +402,7 @@ func TestWebmail(t *testing.T) {
 		}
 		rr := httptest.NewRecorder()
 		rr.Body = &bytes.Buffer{}
-		handle(apiHandler, false, "", rr, req)
+		handle(apiHandler, false, "/", "", rr, req)
 		if rr.Code != expStatusCode {
 			t.Fatalf("got status %d, expected %d (%s)", rr.Code, expStatusCode, readBody(rr.Body))
 		}
@@ -496,7 +496,7 @@ func TestWebmail(t *testing.T) {
 		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		r.Header.Add("Cookie", cookieOK.String())
 		w := httptest.NewRecorder()
-		handle(apiHandler, false, "", w, r)
+		handle(apiHandler, false, "/", "", w, r)
 		if w.Code != http.StatusOK {
 			t.Fatalf("export, got status code %d, expected 200: %s", w.Code, w.Body.Bytes())
 		}
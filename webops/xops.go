@@ -1,4 +1,5 @@
-// Package webops implements shared functionality between webapisrv and webmail.
+// Package webops implements shared functionality between webapisrv, webmail and
+// pop3server.
 package webops
 
 import (